@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// analysisIDPattern restricts analysis IDs to characters that are safe in file names.
+var analysisIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// analysisLocks serialize read-modify-write cycles on the manifest of each analysis. The lock is per
+// analysis, so a slow storage request for one analysis never blocks the others; readers take no lock,
+// as every store replaces a manifest atomically.
+var (
+	analysisLocksMutex sync.Mutex
+	analysisLocks      = make(map[string]*sync.Mutex)
+)
+
+// lockAnalysisManifest locks the manifest of an analysis and returns the unlock function.
+func lockAnalysisManifest(analysisID string) func() {
+	analysisLocksMutex.Lock()
+	lock, ok := analysisLocks[analysisID]
+	if !ok {
+		lock = &sync.Mutex{}
+		analysisLocks[analysisID] = lock
+	}
+	analysisLocksMutex.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// AnalysisArtifact is one file produced for an analysis.
+type AnalysisArtifact struct {
+	Path       string    `json:"path"`
+	Kind       string    `json:"kind"`                 // "input" (caller's local profile), "profile" (downloaded), "flamegraph", ...
+	Source     string    `json:"source,omitempty"`     // Original URI or producing tool
+	Temporary  bool      `json:"temporary"`            // Created by the server; removed by cleanup_analysis
+	ArtifactID string    `json:"artifactId,omitempty"` // Random ID embedded in the artifact's metadata (SVGs)
+	CreatedAt  time.Time `json:"createdAt"`
+	StoredKey  string    `json:"storedKey,omitempty"` // Key of the copy in the workspace store (see storageEnv)
+	StoredAt   time.Time `json:"storedAt,omitempty"`
+}
+
+// AnalysisManifest lists the artifacts belonging to one logical investigation.
+type AnalysisManifest struct {
+	AnalysisID string             `json:"analysisId"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	Artifacts  []AnalysisArtifact `json:"artifacts"`
+}
+
+// analysisIDDescription describes the optional 'analysis_id' argument shared by the tools.
+const analysisIDDescription = "Optional investigation ID. Temporary files and results are named after it and recorded in its manifest (see 'export_bundle'), and kept until 'cleanup_analysis' is called."
+
+// analysisIDFromArgs returns the optional 'analysis_id' argument, validating its format.
+func analysisIDFromArgs(args map[string]interface{}) (string, error) {
+	analysisID, ok := args["analysis_id"].(string)
+	if !ok || analysisID == "" {
+		return "", nil
+	}
+	if !analysisIDPattern.MatchString(analysisID) {
+		return "", fmt.Errorf("invalid analysis_id '%s': use 1-64 letters, digits, '-' or '_'", analysisID)
+	}
+	return analysisID, nil
+}
+
+// analysisTempPattern returns the os.CreateTemp pattern for a temporary file of the given kind.
+// Files belonging to an analysis are prefixed with its ID so they can be found as a group.
+func analysisTempPattern(analysisID, kind string) string {
+	if analysisID == "" {
+		return "pprof-*"
+	}
+	return fmt.Sprintf("pprof-%s-%s-*", analysisID, kind)
+}
+
+// analysisManifestKey returns the key of an analysis manifest in the workspace store. With the default
+// store, this is a file next to the analysis' temporary files.
+func analysisManifestKey(analysisID string) string {
+	return fmt.Sprintf("pprof-%s-manifest.json", analysisID)
+}
+
+// analysisArtifactKey returns the key of the stored copy of an artifact. The hash of the path keeps
+// artifacts with the same file name (e.g. two 'heap.pb.gz' inputs) apart.
+func analysisArtifactKey(analysisID, path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return fmt.Sprintf("pprof-%s-artifacts/%s-%s", analysisID, hex.EncodeToString(sum[:4]), filepath.Base(path))
+}
+
+// readAnalysisManifest loads an analysis manifest; a missing manifest yields an empty one.
+func readAnalysisManifest(analysisID string) (*AnalysisManifest, error) {
+	ctx, cancel := storageContext()
+	defer cancel()
+	data, err := workspaceStore().Get(ctx, analysisManifestKey(analysisID))
+	if errors.Is(err, errNotStored) {
+		return &AnalysisManifest{AnalysisID: analysisID, CreatedAt: time.Now(), Artifacts: []AnalysisArtifact{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest for analysis '%s': %w", analysisID, err)
+	}
+	var manifest AnalysisManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest for analysis '%s': %w", analysisID, err)
+	}
+	return &manifest, nil
+}
+
+// recordAnalysisArtifact adds an artifact to the manifest of an analysis. It is a no-op without an analysis ID.
+func recordAnalysisArtifact(analysisID string, artifact AnalysisArtifact) error {
+	if analysisID == "" {
+		return nil
+	}
+	if artifact.CreatedAt.IsZero() {
+		artifact.CreatedAt = time.Now()
+	}
+	storeArtifactCopy(analysisID, &artifact)
+
+	defer lockAnalysisManifest(analysisID)()
+
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil {
+		return err
+	}
+	for i, existing := range manifest.Artifacts {
+		if existing.Path == artifact.Path {
+			manifest.Artifacts[i] = artifact
+			return writeAnalysisManifest(manifest)
+		}
+	}
+	manifest.Artifacts = append(manifest.Artifacts, artifact)
+	return writeAnalysisManifest(manifest)
+}
+
+// forgetAnalysisArtifacts removes the artifacts with the given paths from an analysis manifest, with their
+// stored copies, e.g. snapshots pruned by a schedule. It is a no-op without an analysis ID.
+func forgetAnalysisArtifacts(analysisID string, paths []string) error {
+	if analysisID == "" || len(paths) == 0 {
+		return nil
+	}
+	forget := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		forget[path] = true
+	}
+
+	defer lockAnalysisManifest(analysisID)()
+
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil {
+		return err
+	}
+	remaining := make([]AnalysisArtifact, 0, len(manifest.Artifacts))
+	var failed []string
+	for _, artifact := range manifest.Artifacts {
+		if !forget[artifact.Path] {
+			remaining = append(remaining, artifact)
+			continue
+		}
+		deleteStoredCopy(analysisID, artifact, &failed)
+	}
+	if len(remaining) == len(manifest.Artifacts) {
+		return nil
+	}
+	manifest.Artifacts = remaining
+	return writeAnalysisManifest(manifest)
+}
+
+// storeArtifactCopy copies an artifact's file to the workspace store when artifacts are stored, unless the
+// manifest already has a copy of the unchanged file (e.g. an input profile loaded again). The upload runs
+// outside the manifest lock; a failure is logged and leaves the artifact local only.
+func storeArtifactCopy(analysisID string, artifact *AnalysisArtifact) {
+	if !artifactsStored() {
+		return
+	}
+	info, err := os.Stat(artifact.Path)
+	if err != nil {
+		log.Printf("Warning: not storing artifact '%s' of analysis '%s': %v", artifact.Path, analysisID, err)
+		return
+	}
+	// Read without the lock: at worst a concurrent record of the same file uploads it twice
+	manifest, err := readAnalysisManifest(analysisID)
+	if err == nil {
+		for _, existing := range manifest.Artifacts {
+			if existing.Path == artifact.Path && existing.StoredKey != "" && !info.ModTime().After(existing.StoredAt) {
+				artifact.StoredKey, artifact.StoredAt = existing.StoredKey, existing.StoredAt
+				return
+			}
+		}
+	}
+
+	data, err := os.ReadFile(artifact.Path)
+	if err != nil {
+		log.Printf("Warning: not storing artifact '%s' of analysis '%s': %v", artifact.Path, analysisID, err)
+		return
+	}
+	key := analysisArtifactKey(analysisID, artifact.Path)
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := workspaceStore().Put(ctx, key, data); err != nil {
+		log.Printf("Warning: failed to store artifact '%s' of analysis '%s': %v", artifact.Path, analysisID, err)
+		return
+	}
+	artifact.StoredKey, artifact.StoredAt = key, time.Now()
+}
+
+// readArtifactFile reads an artifact's file, decrypting it if needed, or its copy in the workspace store when
+// the file is gone, e.g. after a restart or when the analysis ran on another instance.
+func readArtifactFile(artifact AnalysisArtifact) ([]byte, error) {
+	data, err := readSealedFile(artifact.Path)
+	if !os.IsNotExist(err) || artifact.StoredKey == "" {
+		return data, err
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	stored, storeErr := workspaceStore().Get(ctx, artifact.StoredKey)
+	if storeErr != nil {
+		return nil, fmt.Errorf("%v; stored copy: %v", err, storeErr)
+	}
+	log.Printf("Using stored copy %s of missing artifact %s", artifact.StoredKey, artifact.Path)
+	return openFileData(artifact.StoredKey, stored)
+}
+
+// writeAnalysisManifest saves a manifest to the workspace store.
+func writeAnalysisManifest(manifest *AnalysisManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest for analysis '%s': %w", manifest.AnalysisID, err)
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := workspaceStore().Put(ctx, analysisManifestKey(manifest.AnalysisID), data); err != nil {
+		return fmt.Errorf("failed to write manifest for analysis '%s': %w", manifest.AnalysisID, err)
+	}
+	return nil
+}
+
+// saveAnalysisResult writes a tool's result to a temporary file and records it in the analysis manifest,
+// so it can be included in an exported bundle, then runs the post-processing hook on it (if configured).
+// It is a no-op without an analysis ID.
+func saveAnalysisResult(ctx context.Context, analysisID, name, format, result string) *postProcessReport {
+	if analysisID == "" {
+		return nil
+	}
+	ext := ".txt"
+	switch format {
+	case "json", "flamegraph-json", "heatmap-json":
+		ext = ".json"
+	case "markdown", "markdown-compact":
+		ext = ".md"
+	}
+	// 配置了密钥时结果与 profile 一样加密保存
+	path, err := writeSealedTemp(analysisTempPattern(analysisID, "analysis-"+name)+ext, []byte(result))
+	if err != nil {
+		log.Printf("Warning: failed to save result of '%s' for analysis '%s': %v", name, analysisID, err)
+		return nil
+	}
+	artifact := AnalysisArtifact{Path: path, Kind: "analysis", Source: name, Temporary: true}
+	if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	return runPostProcessHook(ctx, analysisID, artifact)
+}
+
+// handleCleanupAnalysis removes every temporary artifact recorded for an analysis, plus its manifest.
+// Files written to caller-chosen paths (e.g. flame graph SVGs) are only removed when remove_outputs is true;
+// local input profiles are never removed.
+func handleCleanupAnalysis(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if analysisID == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: analysis_id (string)")
+	}
+	removeOutputs, _ := args["remove_outputs"].(bool)
+
+	log.Printf("Handling cleanup_analysis: AnalysisID=%s, RemoveOutputs=%t", analysisID, removeOutputs)
+
+	defer lockAnalysisManifest(analysisID)()
+
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil {
+		return nil, err
+	}
+	// A mistyped ID must not look like a successful cleanup
+	if len(manifest.Artifacts) == 0 {
+		return nil, fmt.Errorf("unknown analysis '%s': no artifacts are recorded for it (or it was already cleaned up)", analysisID)
+	}
+
+	removed, kept, failed := []string{}, []string{}, []string{}
+	remaining := make([]AnalysisArtifact, 0)
+	unreferenced := make([]AnalysisArtifact, 0) // Artifacts whose stored copies go away with the manifest
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Kind == "input" {
+			unreferenced = append(unreferenced, artifact)
+			continue // Never delete the caller's own profiles
+		}
+		if !artifact.Temporary && !removeOutputs {
+			kept = append(kept, artifact.Path)
+			unreferenced = append(unreferenced, artifact)
+			continue
+		}
+		if err := os.Remove(artifact.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove artifact '%s' of analysis '%s': %v", artifact.Path, analysisID, err)
+			failed = append(failed, fmt.Sprintf("%s (%v)", artifact.Path, err))
+			remaining = append(remaining, artifact)
+			continue
+		}
+		removed = append(removed, artifact.Path)
+		deleteStoredCopy(analysisID, artifact, &failed)
+	}
+
+	// Keep the manifest only for artifacts that could not be removed, so a later cleanup can retry
+	if len(remaining) == 0 {
+		for _, artifact := range unreferenced {
+			deleteStoredCopy(analysisID, artifact, &failed)
+		}
+		ctx, cancel := storageContext()
+		defer cancel()
+		if err := workspaceStore().Delete(ctx, analysisManifestKey(analysisID)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", analysisManifestKey(analysisID), err))
+		}
+	} else {
+		manifest.Artifacts = remaining
+		if err := writeAnalysisManifest(manifest); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Cleaned up analysis '%s': removed %d file(s).\n", analysisID, len(removed)))
+	for _, path := range removed {
+		b.WriteString(fmt.Sprintf("  - removed %s\n", path))
+	}
+	if len(kept) > 0 {
+		b.WriteString(fmt.Sprintf("Kept %d output file(s) (pass remove_outputs=true to delete them):\n", len(kept)))
+		for _, path := range kept {
+			b.WriteString(fmt.Sprintf("  - %s\n", path))
+		}
+	}
+	if len(failed) > 0 {
+		b.WriteString(fmt.Sprintf("Failed to remove %d file(s); they remain listed in the manifest:\n", len(failed)))
+		for _, f := range failed {
+			b.WriteString(fmt.Sprintf("  - %s\n", f))
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, nil
+}
+
+// deleteStoredCopy removes the stored copy of an artifact, if any, adding failures to failed.
+func deleteStoredCopy(analysisID string, artifact AnalysisArtifact, failed *[]string) {
+	if artifact.StoredKey == "" {
+		return
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	if err := workspaceStore().Delete(ctx, artifact.StoredKey); err != nil {
+		log.Printf("Warning: failed to delete stored copy '%s' of analysis '%s': %v", artifact.StoredKey, analysisID, err)
+		*failed = append(*failed, fmt.Sprintf("%s (%v)", artifact.StoredKey, err))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCleanupAnalysis(t *testing.T) {
+	store := useTestStore(t)
+	dir := t.TempDir()
+	analysisID := "cleanup-" + newArtifactID()[:8]
+	cleanup := func(args map[string]interface{}) (string, error) {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		result, err := handleCleanupAnalysis(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		return result.Content[0].(mcp.TextContent).Text, nil
+	}
+
+	files := map[string]AnalysisArtifact{}
+	for _, artifact := range []AnalysisArtifact{
+		{Path: filepath.Join(dir, "pprof-cleanup-analysis.json"), Kind: "analysis", Temporary: true},
+		{Path: filepath.Join(dir, "flame.svg"), Kind: "flamegraph"}, // Written to a caller-chosen path
+		{Path: filepath.Join(dir, "cpu.pprof"), Kind: "input"},
+	} {
+		if err := os.WriteFile(artifact.Path, []byte(artifact.Kind), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+			t.Fatal(err)
+		}
+		files[artifact.Kind] = artifact
+	}
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil || len(manifest.Artifacts) != 3 {
+		t.Fatalf("Expected 3 recorded artifacts, got %+v (%v)", manifest, err)
+	}
+	for _, artifact := range manifest.Artifacts {
+		if _, err := store.Get(context.Background(), artifact.StoredKey); artifact.StoredKey == "" || err != nil {
+			t.Fatalf("Expected a stored copy of %s, got key %q (%v)", artifact.Path, artifact.StoredKey, err)
+		}
+	}
+
+	text, err := cleanup(map[string]interface{}{"analysis_id": analysisID})
+	if err != nil {
+		t.Fatalf("cleanup_analysis failed: %v", err)
+	}
+	if !strings.Contains(text, "removed 1 file(s)") || !strings.Contains(text, "Kept 1 output file(s)") || strings.Contains(text, "Failed") {
+		t.Errorf("Unexpected result:\n%s", text)
+	}
+	if _, err := os.Stat(files["analysis"].Path); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be removed, got %v", err)
+	}
+	for _, kind := range []string{"flamegraph", "input"} {
+		if _, err := os.Stat(files[kind].Path); err != nil {
+			t.Errorf("Expected the %s file to be kept: %v", kind, err)
+		}
+	}
+	// The stored copies go away with the manifest, including those of the kept files
+	for _, artifact := range manifest.Artifacts {
+		if _, err := store.Get(context.Background(), artifact.StoredKey); !errors.Is(err, errNotStored) {
+			t.Errorf("Expected the stored copy of %s to be deleted, got %v", artifact.Path, err)
+		}
+	}
+	if _, err := store.Get(context.Background(), analysisManifestKey(analysisID)); !errors.Is(err, errNotStored) {
+		t.Errorf("Expected the manifest to be deleted, got %v", err)
+	}
+	entries, _ := os.ReadDir(store.dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), analysisID) {
+			t.Errorf("Expected nothing of the analysis left in the store, found %s", entry.Name())
+		}
+	}
+
+	// A mistyped or already cleaned up analysis is an error, not an empty success
+	for _, id := range []string{analysisID, "cleanup-unknown"} {
+		if _, err := cleanup(map[string]interface{}{"analysis_id": id}); err == nil || !strings.Contains(err.Error(), "unknown analysis '"+id+"'") {
+			t.Errorf("Expected analysis %s to be reported as unknown, got %v", id, err)
+		}
+	}
+	if _, err := cleanup(map[string]interface{}{}); err == nil {
+		t.Error("Expected an error without analysis_id")
+	}
+}
+
+func TestCleanupAnalysisKeepsManifestOnFailure(t *testing.T) {
+	useTestStore(t)
+	dir := t.TempDir()
+	analysisID := "cleanup-" + newArtifactID()[:8]
+	// A non-empty directory cannot be removed like a file
+	stuck := filepath.Join(dir, "pprof-stuck")
+	if err := os.MkdirAll(filepath.Join(stuck, "child"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "flame.svg")
+	os.WriteFile(output, []byte("<svg/>"), 0o600)
+	for _, artifact := range []AnalysisArtifact{
+		{Path: stuck, Kind: "analysis", Temporary: true},
+		{Path: output, Kind: "flamegraph"},
+	} {
+		if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"analysis_id": analysisID, "remove_outputs": true}
+	result, err := handleCleanupAnalysis(context.Background(), request)
+	if err != nil || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "Failed to remove 1 file(s)") {
+		t.Fatalf("Expected the failure to be reported, got %v (%v)", result, err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Expected the output to be removed with remove_outputs, got %v", err)
+	}
+	// The manifest only keeps the artifact left, so a later cleanup can retry
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil || len(manifest.Artifacts) != 1 || manifest.Artifacts[0].Path != stuck {
+		t.Errorf("Expected the manifest to list the artifact left, got %+v (%v)", manifest, err)
+	}
+}
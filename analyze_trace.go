@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// traceParseTimeout bounds 'go tool trace -d=parsed'; dumping the events of a long trace takes a while.
+const traceParseTimeout = 5 * time.Minute
+
+// handleAnalyzeTrace summarizes a runtime/trace file: goroutine latency distributions, blocking by reason
+// (network, system calls, synchronization, ...) and where it happens, and proc utilization, or with mode
+// "gc_analysis" its garbage collections. Trace files are decoded by 'go tool trace -d=parsed'; its saved
+// output is read directly.
+func handleAnalyzeTrace(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	traceURIStr, ok := args["trace_uri"].(string)
+	if !ok || traceURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: trace_uri (string)")
+	}
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 10.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 10
+	}
+	mode, ok := args["mode"].(string)
+	if !ok || mode == "" {
+		mode = "summary"
+	}
+	if mode != "summary" && mode != "gc_analysis" {
+		return nil, fmt.Errorf("unsupported mode: %s", mode)
+	}
+	maxPointsFloat, ok := args["max_points"].(float64)
+	if !ok {
+		maxPointsFloat = analyzer.DefaultTraceGCPoints
+	}
+	maxPoints := int(maxPointsFloat)
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return nil, fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+
+	log.Printf("Handling analyze_trace: URI=%s, Mode=%s, TopN=%d, MaxPoints=%d, Format=%s", traceURIStr, mode, topN, maxPoints, outputFormat)
+
+	inputFilePath, cleanup, err := getProfileAsFile(ctx, traceURIStr, analysisID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trace file: %w", err)
+	}
+	defer cleanup()
+
+	header := make([]byte, 64)
+	f, err := os.Open(inputFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file '%s': %w", inputFilePath, err)
+	}
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+	header = header[:n]
+
+	// parse renders the report of the selected mode from the trace events
+	var result string
+	parse := func(r io.Reader) error {
+		var err error
+		if mode == "gc_analysis" {
+			var summary *analyzer.TraceGCSummary
+			if summary, err = analyzer.ParseTraceGC(r, maxPoints); err == nil {
+				result, err = analyzer.FormatTraceGCSummary(summary, topN, outputFormat)
+			}
+			return err
+		}
+		var summary *analyzer.TraceSummary
+		if summary, err = analyzer.ParseTraceEvents(r); err == nil {
+			result, err = analyzer.FormatTraceSummary(summary, topN, outputFormat)
+		}
+		return err
+	}
+	switch {
+	case analyzer.IsTraceEventDump(header):
+		f, err := os.Open(inputFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace event dump '%s': %w", inputFilePath, err)
+		}
+		err = parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze trace event dump '%s': %w", traceURIStr, err)
+		}
+	case analyzer.IsGoTrace(header):
+		// 运行 go tool trace 前需要用户确认；预览中以原始 URI 代替临时文件
+		preview := []string{"go", "tool", "trace", "-d=parsed", traceURIStr}
+		if confirmErr := confirmSpawn("analyze_trace", args, preview, false); confirmErr != nil {
+			return confirmErr.toolResult(), nil
+		}
+		if err := parseTraceFile(ctx, inputFilePath, parse); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("'%s' is neither a runtime/trace file (as written by runtime/trace.Start or '/debug/pprof/trace') nor the output of 'go tool trace -d=parsed'", traceURIStr)
+	}
+
+	hookReport := saveAnalysisResult(ctx, analysisID, "analyze_trace", outputFormat, result)
+	return withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), nil
+}
+
+// parseTraceFile dumps the events of the runtime/trace file at filePath with 'go tool trace -d=parsed' and
+// passes them to parse while they are written, so the (much larger) text dump is never held in memory.
+func parseTraceFile(ctx context.Context, filePath string, parse func(io.Reader) error) error {
+	if _, err := exec.LookPath("go"); err != nil {
+		return fmt.Errorf("analyzing a runtime/trace file requires the Go toolchain in PATH, or pass the output of 'go tool trace -d=parsed %s' instead", filePath)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, traceParseTimeout)
+	defer cancel()
+	log.Printf("Executing command: go tool trace -d=parsed %s", filePath)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "tool", "trace", "-d=parsed", filePath)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to read the output of 'go tool trace': %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run 'go tool trace': %w", err)
+	}
+	parseErr := parse(stdout)
+	if parseErr != nil {
+		io.Copy(io.Discard, stdout) // Let the command exit instead of blocking on a full pipe
+	}
+	if err := cmd.Wait(); err != nil {
+		log.Printf("Error executing 'go tool trace': %v\nOutput:\n%s", err, stderr.String())
+		// Traces of newer Go versions than the local toolchain are rejected with an explicit message
+		return fmt.Errorf("failed to parse trace file '%s': %w. Output: %s", filePath, err, strings.TrimSpace(stderr.String()))
+	}
+	if parseErr != nil {
+		return fmt.Errorf("failed to analyze trace file '%s': %w", filePath, parseErr)
+	}
+	return nil
+}
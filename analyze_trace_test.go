@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/trace"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestHandleAnalyzeTrace(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("The Go toolchain is not in PATH")
+	}
+	// A trace of this test: the goroutine below sleeps, then blocks on a channel
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Skipf("Cannot trace the test: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(time.Millisecond)
+		close(done)
+	}()
+	<-done
+	trace.Stop()
+	path := filepath.Join(t.TempDir(), "trace.out")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(confirmEnv, "on")
+	call := func(args map[string]interface{}) (*mcp.CallToolResult, error) {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		return handleAnalyzeTrace(context.Background(), request)
+	}
+	result, err := call(map[string]interface{}{"trace_uri": path})
+	if err != nil || len(result.Content) == 0 || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "confirmation_required") {
+		t.Fatalf("Expected a confirmation request before running 'go tool trace', got %+v (%v)", result, err)
+	}
+	result, err = call(map[string]interface{}{"trace_uri": path, "confirm": true})
+	if err != nil {
+		t.Fatalf("handleAnalyzeTrace failed: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"=== Proc Utilization ===", "sleep", "chan receive"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the trace summary:\n%s", want, text)
+		}
+	}
+
+	result, err = call(map[string]interface{}{"trace_uri": path, "mode": "gc_analysis", "output_format": "json", "confirm": true})
+	if err != nil || !strings.Contains(result.Content[0].(mcp.TextContent).Text, `"series"`) {
+		t.Errorf("Expected a JSON GC summary, got %+v (%v)", result, err)
+	}
+
+	if _, err := call(map[string]interface{}{"trace_uri": filepath.Join("tests", "README.md")}); err == nil || !strings.Contains(err.Error(), "neither a runtime/trace file") {
+		t.Errorf("Expected an error for a file that is not a trace, got %v", err)
+	}
+}
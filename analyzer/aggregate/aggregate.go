@@ -0,0 +1,132 @@
+// Package aggregate folds pprof stack traces into per-function, per-site and call-tree aggregates.
+// It has no dependency on the MCP layer, so other Go programs can embed the same analysis the
+// pprof-analyzer-mcp tools use.
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// Stat is the aggregated flat value of one key (a function or an allocation site).
+type Stat struct {
+	Name    string
+	Flat    int64 // Value attributed to the key as the leaf of a stack
+	Objects int64 // Object count (memory profiles only; 0 without an objects sample type)
+}
+
+// Aggregation is a list of stats sorted by flat value (descending) plus the profile totals.
+type Aggregation struct {
+	Stats        []Stat
+	Total        int64 // Sum of the selected value over all samples with a stack
+	TotalObjects int64
+}
+
+// ObjectType returns the allocated object type recorded in a sample's "type" (or "object") label,
+// or an empty string when the sample carries no type information.
+func ObjectType(s *profile.Sample) string {
+	if typeLabels, ok := s.Label["type"]; ok && len(typeLabels) > 0 {
+		return typeLabels[0]
+	}
+	if objLabels, ok := s.Label["object"]; ok && len(objLabels) > 0 {
+		return objLabels[0]
+	}
+	return ""
+}
+
+// leafLine returns the first line with a function in the leaf location of a sample.
+func leafLine(s *profile.Sample) (profile.Line, bool) {
+	for _, line := range s.Location[0].Line {
+		if line.Function != nil {
+			return line, true
+		}
+	}
+	return profile.Line{}, false
+}
+
+// aggregateByLeaf sums the values at valueIndex (and objectsIndex, if >= 0) per key of the leaf frame.
+func aggregateByLeaf(p *profile.Profile, valueIndex, objectsIndex int, key func(line profile.Line) string) *Aggregation {
+	flat := make(map[string]int64)
+	objects := make(map[string]int64)
+	agg := &Aggregation{}
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= valueIndex {
+			continue
+		}
+		v := s.Value[valueIndex]
+		agg.Total += v
+
+		var objCount int64
+		if objectsIndex >= 0 && len(s.Value) > objectsIndex {
+			objCount = s.Value[objectsIndex]
+			agg.TotalObjects += objCount
+		}
+
+		line, ok := leafLine(s)
+		if !ok {
+			continue
+		}
+		k := key(line)
+		flat[k] += v
+		if objCount > 0 {
+			objects[k] += objCount
+		}
+	}
+
+	agg.Stats = make([]Stat, 0, len(flat))
+	for name, v := range flat {
+		agg.Stats = append(agg.Stats, Stat{Name: name, Flat: v, Objects: objects[name]})
+	}
+	sort.Slice(agg.Stats, func(i, j int) bool {
+		if agg.Stats[i].Flat != agg.Stats[j].Flat {
+			return agg.Stats[i].Flat > agg.Stats[j].Flat
+		}
+		return agg.Stats[i].Name < agg.Stats[j].Name
+	})
+	return agg
+}
+
+// AggregateByFunction attributes the value at valueIndex of every sample to the function of its leaf frame.
+// objectsIndex selects the object count sample type (e.g. inuse_objects); pass -1 when there is none.
+func AggregateByFunction(p *profile.Profile, valueIndex, objectsIndex int) *Aggregation {
+	return aggregateByLeaf(p, valueIndex, objectsIndex, func(line profile.Line) string {
+		return line.Function.Name
+	})
+}
+
+// AggregateBySite is like AggregateByFunction but keys by allocation site: "function at file:line".
+func AggregateBySite(p *profile.Profile, valueIndex, objectsIndex int) *Aggregation {
+	return aggregateByLeaf(p, valueIndex, objectsIndex, func(line profile.Line) string {
+		return fmt.Sprintf("%s at %s:%d", line.Function.Name, line.Function.Filename, line.Line)
+	})
+}
+
+// CumulativeByFunction sums the value at valueIndex of every sample for each function anywhere on its stack
+// (pprof's "cum"). A function is counted once per sample, so recursion does not inflate its value.
+func CumulativeByFunction(p *profile.Profile, valueIndex int) map[string]int64 {
+	cum := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function == nil || seen[line.Function.Name] {
+					continue
+				}
+				seen[line.Function.Name] = true
+				cum[line.Function.Name] += s.Value[valueIndex]
+			}
+		}
+	}
+	return cum
+}
+
+// IsMemoryValueType reports whether a sample type holds allocated bytes (heap or allocs profiles).
+func IsMemoryValueType(st *profile.ValueType) bool {
+	return st.Unit == "bytes" && (st.Type == "inuse_space" || st.Type == "alloc_space" ||
+		st.Type == "alloc" || st.Type == "allocation")
+}
@@ -0,0 +1,156 @@
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// Node is one function in a call tree built from root (callers) to leaves (callees).
+// All calls to the same function under the same parent are merged, as in a basic flame graph.
+type Node struct {
+	Name     string
+	Filename string
+	Line     int
+	Value    int64 // Cumulative value of the node and its descendants
+	Self     int64 // Value of samples ending in this node
+	Objects  int64 // Object count of samples ending in this node (memory profiles only)
+	Type     string
+	Labels   map[string]map[string]int64 // Value of the samples through the node by label key and value; nil without string labels
+	Children []*Node                     // Sorted by value, descending
+
+	children map[uint64]*Node // Keyed by function ID while the tree is built
+}
+
+// BuildTree folds every sample stack of the profile into a call tree using the value at valueIndex.
+// The returned root is named "root" and holds the profile total. For memory profiles (see IsMemoryValueType)
+// object counts and object types are recorded as well.
+func BuildTree(p *profile.Profile, valueIndex int) (*Node, error) {
+	if valueIndex < 0 || valueIndex >= len(p.SampleType) {
+		return nil, fmt.Errorf("invalid value index %d for profile with %d sample types", valueIndex, len(p.SampleType))
+	}
+
+	objectsIndex := -1
+	isMemoryProfile := IsMemoryValueType(p.SampleType[valueIndex])
+	if isMemoryProfile {
+		for i, st := range p.SampleType {
+			if (st.Type == "inuse_objects" || st.Type == "alloc_objects") && st.Unit == "count" {
+				objectsIndex = i
+				break
+			}
+		}
+	}
+
+	root := &Node{Name: "root", children: make(map[uint64]*Node)}
+	for _, sample := range p.Sample {
+		if len(sample.Value) <= valueIndex {
+			continue
+		}
+		value := sample.Value[valueIndex]
+		if value == 0 {
+			continue // Skip samples with zero value for the selected index
+		}
+		root.Value += value
+		root.addLabels(sample.Label, value)
+
+		var objCount int64
+		if isMemoryProfile && objectsIndex >= 0 && len(sample.Value) > objectsIndex {
+			objCount = sample.Value[objectsIndex]
+			root.Objects += objCount
+		}
+		typeName := ""
+		if isMemoryProfile {
+			typeName = ObjectType(sample)
+		}
+
+		// Walk the stack from the outermost caller to the leaf
+		current := root
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			loc := sample.Location[i]
+			if len(loc.Line) == 0 {
+				continue // Skip locations without line info
+			}
+			// A location can have multiple lines (inlining); the first line's function is used
+			line := loc.Line[0]
+			fn := line.Function
+			if fn == nil {
+				fn = &profile.Function{ID: 0, Name: fmt.Sprintf("unknown @ 0x%x", loc.Address)}
+			}
+
+			child, exists := current.children[fn.ID]
+			if !exists {
+				child = &Node{
+					Name:     fn.Name,
+					Filename: fn.Filename,
+					Line:     int(line.Line),
+					Type:     typeName,
+					children: make(map[uint64]*Node),
+				}
+				current.children[fn.ID] = child
+			}
+			child.addLabels(sample.Label, value)
+			if i == 0 {
+				child.Self += value
+				if isMemoryProfile && objCount > 0 {
+					child.Objects += objCount
+					if typeName != "" && child.Type == "" {
+						child.Type = typeName
+					}
+				}
+			}
+			current = child
+		}
+	}
+
+	for _, child := range root.children {
+		finishNode(child)
+		if child.Value > 0 {
+			root.Children = append(root.Children, child)
+		}
+	}
+	sortNodes(root.Children)
+	return root, nil
+}
+
+// addLabels adds the value of a sample to the counts of its string labels (pprof tags).
+func (n *Node) addLabels(labels map[string][]string, value int64) {
+	for key, values := range labels {
+		if n.Labels == nil {
+			n.Labels = make(map[string]map[string]int64)
+		}
+		counts := n.Labels[key]
+		if counts == nil {
+			counts = make(map[string]int64)
+			n.Labels[key] = counts
+		}
+		for _, v := range values {
+			counts[v] += value
+		}
+	}
+}
+
+// finishNode computes cumulative values bottom-up and turns the child maps into sorted slices.
+// Children whose cumulative value is not positive are dropped.
+func finishNode(n *Node) {
+	n.Value = n.Self
+	for _, child := range n.children {
+		finishNode(child)
+		n.Value += child.Value
+		if child.Value > 0 {
+			n.Children = append(n.Children, child)
+		}
+	}
+	sortNodes(n.Children)
+	n.children = nil
+}
+
+// sortNodes orders sibling nodes by value (descending), then by name for a deterministic result.
+func sortNodes(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Value != nodes[j].Value {
+			return nodes[i].Value > nodes[j].Value
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+}
@@ -0,0 +1,52 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// unknownFile collects frames without a source file name (e.g. stripped binaries) at aggregation level "file".
+const unknownFile = "(unknown file)"
+
+// AggregationLevels returns the units top-N lists can be rolled up to (see WithAggregationLevel).
+func AggregationLevels() []string {
+	return []string{"function", "file", "package"}
+}
+
+// rollUp renames the functions of p in place to the source file or Go package they belong to, so every
+// analysis (flat and cum values, stacks, call graphs) aggregates by that unit. Adjacent frames of the same
+// unit are counted once per stack in cum values, like recursion. Line numbers are kept, so allocation
+// sites still point at the code.
+func rollUp(p *profile.Profile, level string) {
+	for _, fn := range p.Function {
+		var name string
+		switch level {
+		case "file":
+			if name = fn.Filename; name == "" {
+				name = unknownFile
+			}
+		case "package":
+			if name = PackageName(fn.Name); name == "" {
+				name = unknownPackage
+			}
+		default:
+			return
+		}
+		fn.Name, fn.SystemName = name, name
+	}
+}
+
+// rolledUp reports whether the aggregation level replaces function names.
+func (o Options) rolledUp() bool {
+	return o.AggregationLevel != "" && o.AggregationLevel != "function"
+}
+
+// aggregationNote explains text reports rolled up by file or package, whose "function" columns hold other names.
+func (o Options) aggregationNote() string {
+	if !o.rolledUp() {
+		return ""
+	}
+	return fmt.Sprintf("Note: aggregated by %s; names below are %s, not functions.\n\n", o.AggregationLevel,
+		map[string]string{"file": "source files", "package": "package import paths"}[o.AggregationLevel])
+}
@@ -0,0 +1,270 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Thresholds for clustering allocation sites by shared entry path.
+const (
+	// clusterMinSites is the minimum number of distinct allocation sites a cluster must group.
+	clusterMinSites = 3
+	// clusterMinSharedFrames is the minimum length of the shared entry path, in frames.
+	clusterMinSharedFrames = 3
+	// clusterMinSharedRatio is the minimum share of the average member stack depth the entry path must cover,
+	// so that sites diverging right below a common server loop are not lumped together.
+	clusterMinSharedRatio = 0.6
+	// clusterPathFrames is the number of entry path frames shown, from the funnel frame up.
+	clusterPathFrames = 4
+	// clusterTopSites is the number of member sites listed per cluster.
+	clusterTopSites = 3
+)
+
+// AllocSiteCluster groups allocation sites whose stacks share a long common suffix, i.e. that are reached
+// through the same entry path, such as the many sites of a JSON decoder called from one handler.
+type AllocSiteCluster struct {
+	Funnel         string          `json:"funnel"`       // Deepest function shared by all the stacks, where they branch out
+	EntryPath      []string        `json:"entryPath"`    // The shared frames from the funnel up, at most clusterPathFrames
+	SharedFrames   int             `json:"sharedFrames"` // Length of the shared entry path
+	Sites          int             `json:"sites"`        // Distinct allocation sites in the cluster
+	Value          int64           `json:"value"`
+	ValueFormatted string          `json:"valueFormatted"`
+	ObjectCount    int64           `json:"objectCount,omitempty"`
+	Percentage     float64         `json:"percentage"`
+	TopSites       []AllocSiteStat `json:"topSites"` // The largest member sites, with their percentage of the profile total
+}
+
+// clusterNode is a function in the call tree built from the roots of the stacks by ClusterAllocationSites.
+type clusterNode struct {
+	name     string
+	depth    int
+	children map[string]*clusterNode
+	value    int64
+	objects  int64
+	samples  int64
+	depthSum int64                     // Sum of the stack depths of the samples below the node
+	sites    map[string]bool           // Distinct sites below the node, capped at clusterMinSites
+	ends     map[string]*AllocSiteStat // Sites of the samples whose stack ends at the node
+}
+
+// ClusterAllocationSites groups the allocation sites ("function at file:line" of the leaf frame, as in the
+// "By Allocation Site" list) whose stacks share a long common suffix, so that tens of sites funneling through
+// one decoding path show up as a single cluster. Stacks are folded from their roots into a call tree; walking
+// it from the roots, a function starts a cluster when at least clusterMinSites sites are reached through it
+// and its path covers at least clusterMinSharedRatio of the average depth of their stacks. The cluster is then
+// reported at its funnel, the deepest function all its stacks share. Clusters are sorted by value; limit <= 0
+// returns them all.
+func ClusterAllocationSites(p *profile.Profile, valueIndex, objectsIndex int, limit int) []AllocSiteCluster {
+	if valueIndex < 0 {
+		return nil
+	}
+	root := &clusterNode{children: make(map[string]*clusterNode)}
+	total := int64(0)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		names := sampleFunctions(s)
+		if len(names) == 0 {
+			continue
+		}
+		line := s.Location[0].Line
+		site := ""
+		for _, l := range line {
+			if l.Function != nil {
+				site = fmt.Sprintf("%s at %s:%d", l.Function.Name, l.Function.Filename, l.Line)
+				break
+			}
+		}
+		if site == "" {
+			continue
+		}
+		v := s.Value[valueIndex]
+		objects := int64(0)
+		if objectsIndex >= 0 && len(s.Value) > objectsIndex {
+			objects = s.Value[objectsIndex]
+		}
+		total += v
+
+		node := root
+		node.add(v, objects, len(names))
+		for i := len(names) - 1; i >= 0; i-- {
+			child, ok := node.children[names[i]]
+			if !ok {
+				child = &clusterNode{name: names[i], depth: node.depth + 1, children: make(map[string]*clusterNode)}
+				node.children[names[i]] = child
+			}
+			node = child
+			node.add(v, objects, len(names))
+		}
+		if node.ends == nil {
+			node.ends = make(map[string]*AllocSiteStat)
+		}
+		stat, ok := node.ends[site]
+		if !ok {
+			stat = &AllocSiteStat{Site: site}
+			node.ends[site] = stat
+		}
+		stat.Value += v
+		stat.ObjectCount += objects
+	}
+	root.countSites()
+
+	clusters := make([]AllocSiteCluster, 0)
+	var visit func(n *clusterNode, path []string)
+	visit = func(n *clusterNode, path []string) {
+		if len(n.sites) < clusterMinSites {
+			return
+		}
+		if n.depth >= clusterMinSharedFrames && float64(n.depth) >= clusterMinSharedRatio*float64(n.depthSum)/float64(n.samples) {
+			clusters = append(clusters, n.cluster(path, total))
+			return
+		}
+		for _, child := range n.sortedChildren() {
+			visit(child, append(path, child.name))
+		}
+	}
+	visit(root, nil)
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Value != clusters[j].Value {
+			return clusters[i].Value > clusters[j].Value
+		}
+		return clusters[i].Funnel < clusters[j].Funnel
+	})
+	if limit > 0 && len(clusters) > limit {
+		clusters = clusters[:limit]
+	}
+	return clusters
+}
+
+// add accounts a sample of the given stack depth to the node.
+func (n *clusterNode) add(value, objects int64, depth int) {
+	n.value += value
+	n.objects += objects
+	n.samples++
+	n.depthSum += int64(depth)
+}
+
+// countSites fills the capped site sets of n and its descendants.
+func (n *clusterNode) countSites() {
+	n.sites = make(map[string]bool, clusterMinSites)
+	for site := range n.ends {
+		if len(n.sites) == clusterMinSites {
+			break
+		}
+		n.sites[site] = true
+	}
+	for _, child := range n.children {
+		child.countSites()
+		for site := range child.sites {
+			if len(n.sites) == clusterMinSites {
+				break
+			}
+			n.sites[site] = true
+		}
+	}
+}
+
+// sortedChildren returns the children of n by name, for a deterministic walk.
+func (n *clusterNode) sortedChildren() []*clusterNode {
+	children := make([]*clusterNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// cluster builds the cluster of the sites below n, whose stacks share path (root first). The path is extended
+// down to the funnel while all the stacks go through a single child.
+func (n *clusterNode) cluster(path []string, total int64) AllocSiteCluster {
+	funnel := n
+	for len(funnel.ends) == 0 && len(funnel.children) == 1 {
+		for _, child := range funnel.children {
+			funnel = child
+		}
+		path = append(path, funnel.name)
+	}
+
+	members := make(map[string]*AllocSiteStat)
+	var collect func(n *clusterNode)
+	collect = func(n *clusterNode) {
+		for site, stat := range n.ends {
+			m, ok := members[site]
+			if !ok {
+				m = &AllocSiteStat{Site: site}
+				members[site] = m
+			}
+			m.Value += stat.Value
+			m.ObjectCount += stat.ObjectCount
+		}
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	collect(funnel)
+	sites := make([]AllocSiteStat, 0, len(members))
+	for _, m := range members {
+		sites = append(sites, *m)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Value != sites[j].Value {
+			return sites[i].Value > sites[j].Value
+		}
+		return sites[i].Site < sites[j].Site
+	})
+	top := sites[:min(len(sites), clusterTopSites)]
+	for i := range top {
+		top[i].ValueFormatted = FormatBytes(top[i].Value)
+		if total != 0 {
+			top[i].Percentage = float64(top[i].Value) / float64(total) * 100
+		}
+		if top[i].ObjectCount > 0 {
+			top[i].AvgSize = top[i].Value / top[i].ObjectCount
+			top[i].AvgSizeFormatted = FormatBytes(top[i].AvgSize)
+		}
+	}
+
+	entryPath := make([]string, 0, clusterPathFrames)
+	for i := len(path) - 1; i >= 0 && len(entryPath) < clusterPathFrames; i-- {
+		entryPath = append(entryPath, path[i])
+	}
+	c := AllocSiteCluster{
+		Funnel:         funnel.name,
+		EntryPath:      entryPath,
+		SharedFrames:   len(path),
+		Sites:          len(sites),
+		Value:          funnel.value,
+		ValueFormatted: FormatBytes(funnel.value),
+		ObjectCount:    funnel.objects,
+		TopSites:       top,
+	}
+	if total != 0 {
+		c.Percentage = float64(funnel.value) / float64(total) * 100
+	}
+	return c
+}
+
+// writeAllocSiteClusters renders the allocation site clusters section for text/markdown output.
+func writeAllocSiteClusters(w *reportWriter, valueType string, clusters []AllocSiteCluster) {
+	if len(clusters) == 0 {
+		return
+	}
+	w.heading("By Allocation Cluster (Sites Sharing an Entry Path)")
+	t := newTable(valueColumn(valueType), valueColumn("%"), valueColumn("Sites"), nameColumn("Entry Path"))
+	for _, c := range clusters {
+		path := strings.Join(c.EntryPath, " ← ")
+		if c.SharedFrames > len(c.EntryPath) {
+			path += " ← …"
+		}
+		t.add(c.ValueFormatted, percentString(c.Percentage), FormatCount(int64(c.Sites)), path)
+		for _, site := range c.TopSites {
+			t.note(fmt.Sprintf("    %s (%s%%) %s", site.ValueFormatted, percentString(site.Percentage), site.Site))
+		}
+	}
+	w.table(t)
+}
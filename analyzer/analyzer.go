@@ -6,5 +6,6 @@ package analyzer
 // - heap.go
 // - goroutine.go
 // - placeholders.go (for allocs, mutex, block)
+// Stack aggregation (by function, by site, call tree) is in the aggregate subpackage.
 // Type definitions are in types.go.
 // Formatting helpers are in formatters.go.
@@ -0,0 +1,291 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// DefaultAnnotateFunctions is the number of functions AnnotateSource lists when no limit is given.
+const DefaultAnnotateFunctions = 5
+
+// annotateContextLines is the number of unsampled source lines shown after the last sampled line of a function.
+const annotateContextLines = 2
+
+// maxAnnotatedLines caps the lines listed per function, e.g. for profiles with a bogus start line.
+const maxAnnotatedLines = 400
+
+// sourceExtensions are the file types AnnotateSource reads. Paths come from the profile, so other files (e.g.
+// a crafted profile naming /etc/passwd) are never read.
+var sourceExtensions = map[string]bool{".go": true, ".s": true, ".c": true, ".cc": true, ".cpp": true, ".h": true}
+
+// SourceLine is one line of an annotated function.
+type SourceLine struct {
+	Line          int64  `json:"line"`
+	Flat          int64  `json:"flat"`
+	FlatFormatted string `json:"flatFormatted"`
+	Cum           int64  `json:"cum"`
+	CumFormatted  string `json:"cumFormatted"`
+	Source        string `json:"source,omitempty"`
+}
+
+// AnnotatedFunction is the source of one function with the values of its lines.
+type AnnotatedFunction struct {
+	Function      string       `json:"function"`
+	File          string       `json:"file"`                   // As recorded in the profile
+	ResolvedPath  string       `json:"resolvedPath,omitempty"` // The file the source was read from
+	SourceMissing bool         `json:"sourceMissing,omitempty"`
+	Flat          int64        `json:"flat"`
+	FlatFormatted string       `json:"flatFormatted"`
+	Cum           int64        `json:"cum"`
+	CumFormatted  string       `json:"cumFormatted"`
+	CumPercentage float64      `json:"cumPercentage"`
+	Lines         []SourceLine `json:"lines"`
+}
+
+// AnnotatedSource is the result of AnnotateSource, like the output of 'go tool pprof -list'.
+type AnnotatedSource struct {
+	Pattern        string              `json:"pattern"`
+	SampleType     string              `json:"sampleType"`
+	Unit           string              `json:"unit"`
+	Total          int64               `json:"total"`
+	TotalFormatted string              `json:"totalFormatted"`
+	Matched        int                 `json:"matched"` // Functions matching the pattern, before the limit
+	Functions      []AnnotatedFunction `json:"functions"`
+}
+
+// functionKey identifies a function by name and file: the same function may appear under several IDs,
+// e.g. one per mapping.
+type functionKey struct {
+	name, file string
+}
+
+// lineKey identifies a source line of a function.
+type lineKey struct {
+	fn   functionKey
+	line int64
+}
+
+// sourceFunction accumulates the values of one function and its lines.
+type sourceFunction struct {
+	fn        *profile.Function
+	flat, cum int64
+	lines     map[int64]*SourceLine
+}
+
+// AnnotateSource lists the source lines of the functions matching functionRegex with their flat and cum
+// values, like 'go tool pprof -list'. Sources are read from sourceRoot when it is set: the profile's file
+// path, then ever shorter suffixes of it, are looked up below it, so a checkout of the repository works for
+// binaries built elsewhere or with -trimpath. Without sourceRoot, the recorded paths are read as they are.
+// Functions whose source cannot be found list only their sampled lines. The maxFunctions functions with the
+// highest cum value are returned.
+func AnnotateSource(p *profile.Profile, functionRegex, sourceRoot, sampleType string, maxFunctions int) (*AnnotatedSource, error) {
+	rx, err := regexp.Compile(functionRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid function regex '%s': %w", functionRegex, err)
+	}
+	valueIndex, err := sampleValueIndex(p, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	if maxFunctions <= 0 {
+		maxFunctions = DefaultAnnotateFunctions
+	}
+	st := p.SampleType[valueIndex]
+	log.Printf("Annotating source of functions matching %q (SampleType: %s, SourceRoot: %q)", functionRegex, st.Type, sourceRoot)
+
+	result := &AnnotatedSource{Pattern: functionRegex, SampleType: st.Type, Unit: st.Unit, Functions: make([]AnnotatedFunction, 0)}
+	functions := make(map[functionKey]*sourceFunction)
+	matches := make(map[*profile.Function]bool)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		v := s.Value[valueIndex]
+		result.Total += v
+		// cum 值每个样本只计一次，递归调用不会重复累计
+		seenFunctions := make(map[functionKey]bool)
+		seenLines := make(map[lineKey]bool)
+		for i, loc := range s.Location {
+			for j, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				matched, ok := matches[line.Function]
+				if !ok {
+					matched = rx.MatchString(line.Function.Name)
+					matches[line.Function] = matched
+				}
+				if !matched {
+					continue
+				}
+				fk := functionKey{line.Function.Name, line.Function.Filename}
+				sf := functions[fk]
+				if sf == nil {
+					sf = &sourceFunction{fn: line.Function, lines: make(map[int64]*SourceLine)}
+					functions[fk] = sf
+				}
+				sl := sf.lines[line.Line]
+				if sl == nil {
+					sl = &SourceLine{Line: line.Line}
+					sf.lines[line.Line] = sl
+				}
+				if i == 0 && j == 0 { // The innermost frame of the leaf location
+					sf.flat += v
+					sl.Flat += v
+				}
+				if !seenFunctions[fk] {
+					seenFunctions[fk] = true
+					sf.cum += v
+				}
+				if key := (lineKey{fk, line.Line}); !seenLines[key] {
+					seenLines[key] = true
+					sl.Cum += v
+				}
+			}
+		}
+	}
+	if len(functions) == 0 {
+		return nil, fmt.Errorf("no sampled function matches '%s'", functionRegex)
+	}
+
+	ordered := make([]*sourceFunction, 0, len(functions))
+	for _, sf := range functions {
+		ordered = append(ordered, sf)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].cum != ordered[j].cum {
+			return ordered[i].cum > ordered[j].cum
+		}
+		return ordered[i].fn.Name < ordered[j].fn.Name
+	})
+	result.Matched = len(ordered)
+	if len(ordered) > maxFunctions {
+		ordered = ordered[:maxFunctions]
+	}
+
+	result.TotalFormatted = FormatSampleValue(result.Total, st.Unit)
+	for _, sf := range ordered {
+		af := AnnotatedFunction{
+			Function:      sf.fn.Name,
+			File:          sf.fn.Filename,
+			Flat:          sf.flat,
+			FlatFormatted: FormatSampleValue(sf.flat, st.Unit),
+			Cum:           sf.cum,
+			CumFormatted:  FormatSampleValue(sf.cum, st.Unit),
+		}
+		if result.Total != 0 {
+			af.CumPercentage = float64(sf.cum) / float64(result.Total) * 100
+		}
+		var source []string
+		if path, ok := resolveSourceFile(sf.fn.Filename, sourceRoot); ok {
+			if source, err = readSourceLines(path); err == nil {
+				af.ResolvedPath = path
+			} else {
+				log.Printf("Warning: failed to read source '%s': %v", path, err)
+			}
+		}
+		af.SourceMissing = af.ResolvedPath == ""
+		af.Lines = annotatedLines(sf, source, st.Unit)
+		result.Functions = append(result.Functions, af)
+	}
+	return result, nil
+}
+
+// annotatedLines returns the lines of a function: with its source, every line from the function's start to
+// a little after its last sampled line; without it, only the sampled lines.
+func annotatedLines(sf *sourceFunction, source []string, unit string) []SourceLine {
+	sampled := make([]int64, 0, len(sf.lines))
+	for n := range sf.lines {
+		if n > 0 {
+			sampled = append(sampled, n)
+		}
+	}
+	sort.Slice(sampled, func(i, j int) bool { return sampled[i] < sampled[j] })
+
+	format := func(sl SourceLine) SourceLine {
+		sl.FlatFormatted, sl.CumFormatted = FormatSampleValue(sl.Flat, unit), FormatSampleValue(sl.Cum, unit)
+		return sl
+	}
+	lines := make([]SourceLine, 0)
+	if len(source) == 0 || len(sampled) == 0 {
+		for _, n := range sampled {
+			lines = append(lines, format(*sf.lines[n]))
+		}
+		return lines
+	}
+	first, last := sampled[0], sampled[len(sampled)-1]+annotateContextLines
+	if start := sf.fn.StartLine; start > 0 && start < first {
+		first = start
+	}
+	if last > int64(len(source)) {
+		last = int64(len(source))
+	}
+	if last-first >= maxAnnotatedLines {
+		first = last - maxAnnotatedLines + 1
+	}
+	for n := first; n <= last; n++ {
+		sl := SourceLine{Line: n}
+		if sampledLine := sf.lines[n]; sampledLine != nil {
+			sl = *sampledLine
+		}
+		if n >= 1 && n <= int64(len(source)) {
+			sl.Source = source[n-1]
+		}
+		lines = append(lines, format(sl))
+	}
+	return lines
+}
+
+// FormatAnnotatedSource formats annotated sources as "text", "markdown" or "json".
+func FormatAnnotatedSource(r *AnnotatedSource, format string) (string, error) {
+	switch format {
+	case "text", "markdown":
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Source Annotation: functions matching '%s' (%s, total %s)\n", r.Pattern, r.SampleType, r.TotalFormatted))
+		b.WriteString(fmt.Sprintf("Showing %d of %d matching functions, by cum\n", len(r.Functions), r.Matched))
+		for _, f := range r.Functions {
+			if format == "markdown" {
+				b.WriteString(fmt.Sprintf("\n### `%s`\n\n", f.Function))
+				b.WriteString(fmt.Sprintf("%s — flat %s, cum %s (%.2f%% of total)\n\n```text\n", f.File, f.FlatFormatted, f.CumFormatted, f.CumPercentage))
+			} else {
+				b.WriteString(fmt.Sprintf("\nROUTINE ======================== %s in %s\n", f.Function, f.File))
+				b.WriteString(fmt.Sprintf("%12s %12s (flat, cum) %.2f%% of Total\n", f.FlatFormatted, f.CumFormatted, f.CumPercentage))
+			}
+			if f.SourceMissing {
+				b.WriteString("(source not found; pass 'source_root' to a checkout of the code. Sampled lines only)\n")
+			}
+			for _, l := range f.Lines {
+				b.WriteString(fmt.Sprintf("%12s %12s %6d: %s\n", dotIfZero(l.Flat, l.FlatFormatted), dotIfZero(l.Cum, l.CumFormatted), l.Line, l.Source))
+			}
+			if format == "markdown" {
+				b.WriteString("```\n")
+			}
+		}
+		return b.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling source annotation to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// dotIfZero shows lines without samples as "." like pprof does.
+func dotIfZero(value int64, formatted string) string {
+	if value == 0 {
+		return "."
+	}
+	return formatted
+}
@@ -0,0 +1,269 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// frameClassifier maps a stack (function names, leaf first) to the entity owning its cost.
+// It returns an empty key when the stack cannot be attributed.
+type frameClassifier struct {
+	Description string
+	Classify    func(names []string) (key, kind string)
+}
+
+// frameClassifiers are the supported attribution modes, keyed by the name used in the 'attribute_by' argument.
+var frameClassifiers = map[string]frameClassifier{
+	"handler": {
+		Description: "gRPC method / net/http handler",
+		Classify:    classifyHandler,
+	},
+	"test": {
+		Description: "go test function (Test*/Benchmark*/Fuzz*)",
+		Classify:    classifyTest,
+	},
+}
+
+// AttributionModes returns the names of the supported attribution modes, sorted.
+func AttributionModes() []string {
+	names := make([]string, 0, len(frameClassifiers))
+	for name := range frameClassifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// grpcHandlerFrame matches the handler functions generated by protoc-gen-go-grpc,
+// e.g. "example.com/pb._Greeter_SayHello_Handler" or its ".func1" interceptor closure.
+var grpcHandlerFrame = regexp.MustCompile(`\._([A-Za-z0-9]+)_([A-Za-z0-9_]+)_Handler(\.func\d+)*$`)
+
+// httpServeFrames mark the net/http server loop; the handler is the first application frame below them.
+var httpServeFrames = []string{"net/http.(*conn).serve", "net/http.serverHandler.ServeHTTP"}
+
+// httpRouterPrefixes are routing frames skipped when looking for the application handler.
+var httpRouterPrefixes = []string{
+	"net/http.", "runtime.",
+	"github.com/gorilla/mux.", "github.com/go-chi/chi", "github.com/julienschmidt/httprouter.",
+	"github.com/gin-gonic/gin.", "github.com/labstack/echo",
+}
+
+// classifyHandler attributes a stack to the outermost gRPC method or net/http handler it runs under.
+func classifyHandler(names []string) (string, string) {
+	serving := false
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		if m := grpcHandlerFrame.FindStringSubmatch(name); m != nil {
+			return fmt.Sprintf("/%s/%s", m[1], m[2]), "grpc"
+		}
+		if matchesAnyPrefix(name, httpServeFrames) {
+			serving = true
+			continue
+		}
+		// gRPC servers also run on net/http (ServeHTTP mode); keep looking for generated frames first
+		if serving && !matchesAnyPrefix(name, httpRouterPrefixes) && !strings.HasPrefix(name, "google.golang.org/grpc.") {
+			for _, rest := range names[:i] {
+				if m := grpcHandlerFrame.FindStringSubmatch(rest); m != nil {
+					return fmt.Sprintf("/%s/%s", m[1], m[2]), "grpc"
+				}
+			}
+			return name, "http"
+		}
+	}
+	return "", ""
+}
+
+// testFunctionName matches the top-level function names 'go test' runs.
+var testFunctionName = regexp.MustCompile(`^(Test|Benchmark|Fuzz)([A-Z0-9_].*)?$`)
+
+// classifyTest attributes a stack to the outermost Test*/Benchmark*/Fuzz* function it runs under.
+// Subtest and closure frames (e.g. "pkg.TestFoo.func1") are folded into their top-level test.
+func classifyTest(names []string) (string, string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		pkgEnd := strings.LastIndex(name, "/") + 1
+		dot := strings.Index(name[pkgEnd:], ".")
+		if dot < 0 {
+			continue
+		}
+		pkg, rest := name[:pkgEnd+dot], name[pkgEnd+dot+1:]
+		if pkg == "testing" {
+			continue
+		}
+		fn := rest
+		if j := strings.Index(rest, "."); j >= 0 {
+			fn = rest[:j]
+		}
+		if m := testFunctionName.FindStringSubmatch(fn); m != nil {
+			return pkg + "." + fn, strings.ToLower(m[1])
+		}
+	}
+	return "", ""
+}
+
+// AttributionStat is the cost attributed to one entity (RPC method, handler, ...).
+type AttributionStat struct {
+	Key            string  `json:"key"`
+	Kind           string  `json:"kind"`
+	Value          int64   `json:"value"`
+	ValueFormatted string  `json:"valueFormatted"`
+	Percentage     float64 `json:"percentage"`
+	Samples        int     `json:"samples"`
+}
+
+// AttributionResult is the JSON result of a cost attribution.
+type AttributionResult struct {
+	AttributeBy           string            `json:"attributeBy"`
+	ValueType             string            `json:"valueType"`
+	ValueUnit             string            `json:"valueUnit"`
+	TotalValue            int64             `json:"totalValue"`
+	TotalValueFormatted   string            `json:"totalValueFormatted"`
+	AttributedValue       int64             `json:"attributedValue"`
+	AttributedPercentage  float64           `json:"attributedPercentage"`
+	UnattributedFormatted string            `json:"unattributedFormatted"`
+	TopN                  int               `json:"topN"`
+	Entries               []AttributionStat `json:"entries"`
+}
+
+// sampleValueIndex returns the index of the named sample type, or the profile's default sample type
+// when sampleType is empty.
+func sampleValueIndex(p *profile.Profile, sampleType string) (int, error) {
+	if sampleType == "" {
+		if idx := DefaultSampleIndex(p); idx >= 0 {
+			return idx, nil
+		}
+		return -1, fmt.Errorf("profile has no sample types")
+	}
+	available := make([]string, 0, len(p.SampleType))
+	for i, st := range p.SampleType {
+		if st.Type == sampleType {
+			return i, nil
+		}
+		available = append(available, st.Type)
+	}
+	return -1, fmt.Errorf("sample type '%s' not found in profile (available: %s)", sampleType, strings.Join(available, ", "))
+}
+
+// AttributeCosts walks every stack of a CPU/heap profile and attributes its cost to the entity chosen by
+// the attribution mode (see AttributionModes), producing a per-entity cost table. It works from stack
+// frames alone, so no pprof labels are required.
+func AttributeCosts(p *profile.Profile, attributeBy, sampleType string, topN int, format string) (string, error) {
+	classifier, ok := frameClassifiers[attributeBy]
+	if !ok {
+		return "", fmt.Errorf("unsupported attribution mode: '%s' (supported: %s)", attributeBy, strings.Join(AttributionModes(), ", "))
+	}
+	valueIndex, err := sampleValueIndex(p, sampleType)
+	if err != nil {
+		return "", err
+	}
+	valueType := p.SampleType[valueIndex].Type
+	valueUnit := p.SampleType[valueIndex].Unit
+	log.Printf("Attributing costs by %s (Top %d, SampleType: %s, Format: %s)", attributeBy, topN, valueType, format)
+
+	type entry struct {
+		kind    string
+		value   int64
+		samples int
+	}
+	entries := make(map[string]*entry)
+	totalValue, attributedValue := int64(0), int64(0)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		v := s.Value[valueIndex]
+		totalValue += v
+		key, kind := classifier.Classify(sampleFunctions(s))
+		if key == "" {
+			continue
+		}
+		e, ok := entries[key]
+		if !ok {
+			e = &entry{kind: kind}
+			entries[key] = e
+		}
+		e.value += v
+		e.samples++
+		attributedValue += v
+	}
+
+	stats := make([]AttributionStat, 0, len(entries))
+	for key, e := range entries {
+		percentage := 0.0
+		if totalValue != 0 {
+			percentage = float64(e.value) / float64(totalValue) * 100
+		}
+		stats = append(stats, AttributionStat{
+			Key:            key,
+			Kind:           e.kind,
+			Value:          e.value,
+			ValueFormatted: FormatSampleValue(e.value, valueUnit),
+			Percentage:     percentage,
+			Samples:        e.samples,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Value != stats[j].Value {
+			return stats[i].Value > stats[j].Value
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if topN > 0 && len(stats) > topN {
+		stats = stats[:topN]
+	}
+
+	attributedPercentage := 0.0
+	if totalValue != 0 {
+		attributedPercentage = float64(attributedValue) / float64(totalValue) * 100
+	}
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Cost Attribution by %s (Top %d by %s)", classifier.Description, topN, valueType)
+		w.line("Total %s: %s", valueType, FormatSampleValue(totalValue, valueUnit))
+		w.line("Attributed: %s (%.2f%%), unattributed: %s",
+			FormatSampleValue(attributedValue, valueUnit), attributedPercentage, FormatSampleValue(totalValue-attributedValue, valueUnit))
+		if len(stats) == 0 {
+			w.line("No stacks could be attributed to a %s.", classifier.Description)
+			return w.String(), nil
+		}
+		t := newTable(valueColumn(valueType), valueColumn("Percent"), valueColumn("Samples"), textColumn("Kind", 6), nameColumn("Entity"))
+		for _, stat := range stats {
+			t.add(stat.ValueFormatted, fmt.Sprintf("%.2f%%", stat.Percentage), FormatCount(int64(stat.Samples)), stat.Kind, stat.Key)
+		}
+		w.table(t)
+		return w.String(), nil
+
+	case "json":
+		result := AttributionResult{
+			AttributeBy:           attributeBy,
+			ValueType:             valueType,
+			ValueUnit:             valueUnit,
+			TotalValue:            totalValue,
+			TotalValueFormatted:   FormatSampleValue(totalValue, valueUnit),
+			AttributedValue:       attributedValue,
+			AttributedPercentage:  attributedPercentage,
+			UnattributedFormatted: FormatSampleValue(totalValue-attributedValue, valueUnit),
+			TopN:                  len(stats),
+			Entries:               stats,
+		}
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling cost attribution to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
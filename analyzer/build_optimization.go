@@ -0,0 +1,247 @@
+package analyzer
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Thresholds for detecting two profiles of builds with different optimization flags.
+const (
+	// optimizationMinLocations is the minimum number of locations a package must have in both profiles for
+	// its inlining to be compared.
+	optimizationMinLocations = 10
+	// optimizationMinInlinedPercent is the minimum share of the locations of a package with inlined frames
+	// for it to count as optimized.
+	optimizationMinInlinedPercent = 5
+	// optimizationMinInliningChanges is the minimum number of functions inlined in one build only that
+	// reveals different inlining decisions on its own, e.g. with PGO or another Go version.
+	optimizationMinInliningChanges = 5
+	// maxInliningChangedExamples limits the functions named in the evidence.
+	maxInliningChangedExamples = 3
+)
+
+// debugBinaryPrefix is the name Delve gives the binaries it builds with optimizations disabled.
+const debugBinaryPrefix = "__debug_bin"
+
+// PackageInlining compares the share of the locations of a package (by the function containing them, the
+// outermost frame of the location) that have inlined frames in two profiles.
+type PackageInlining struct {
+	Package           string  `json:"package"`
+	OldLocations      int     `json:"oldLocations"`
+	NewLocations      int     `json:"newLocations"`
+	OldInlinedPercent float64 `json:"oldInlinedPercent"`
+	NewInlinedPercent float64 `json:"newInlinedPercent"`
+	Unoptimized       string  `json:"unoptimized"` // "old" or "new": the build in which the package has no inlined frames
+}
+
+// OptimizationMismatch reports that two compared profiles come from builds with different optimization flags,
+// such as a debug build (-gcflags="-N -l") and a release build, so their diff mixes code changes with the
+// cost of the build flags.
+type OptimizationMismatch struct {
+	UnoptimizedBuild string            `json:"unoptimizedBuild,omitempty"` // "old", "new", or empty when only inlining decisions differ
+	Packages         []PackageInlining `json:"packages,omitempty"`         // Packages optimized in one build only
+	InliningChanged  int               `json:"inliningChanged"`            // Functions inlined in one build only
+	Evidence         []string          `json:"evidence"`
+	Warning          string            `json:"warning"`
+}
+
+// buildInlining is what the locations of a profile tell about the inlining of its build.
+type buildInlining struct {
+	locations  map[string]int  // Locations per package
+	inlined    map[string]int  // Locations with inlined frames per package
+	inlinedFns map[string]bool // Functions seen as inlined frames
+	framedFns  map[string]bool // Functions seen as the outermost frame of a location
+	binary     string          // Base name of the main binary
+	buildID    string
+}
+
+// collectBuildInlining gathers the inlining of the symbolized locations of p, by package.
+func collectBuildInlining(p *profile.Profile) *buildInlining {
+	b := &buildInlining{
+		locations:  make(map[string]int),
+		inlined:    make(map[string]int),
+		inlinedFns: make(map[string]bool),
+		framedFns:  make(map[string]bool),
+	}
+	if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
+		b.binary = filepath.Base(p.Mapping[0].File)
+		b.buildID = p.Mapping[0].BuildID
+	}
+	for _, loc := range p.Location {
+		if len(loc.Line) == 0 {
+			continue
+		}
+		outer := loc.Line[len(loc.Line)-1].Function
+		if outer == nil {
+			continue
+		}
+		b.framedFns[outer.Name] = true
+		pkg := PackageName(outer.Name)
+		b.locations[pkg]++
+		if len(loc.Line) > 1 {
+			b.inlined[pkg]++
+		}
+		for _, line := range loc.Line[:len(loc.Line)-1] {
+			if line.Function != nil {
+				b.inlinedFns[line.Function.Name] = true
+			}
+		}
+	}
+	return b
+}
+
+// inlinedPercent is the share of the locations of pkg with inlined frames.
+func (b *buildInlining) inlinedPercent(pkg string) float64 {
+	if b.locations[pkg] == 0 {
+		return 0
+	}
+	return float64(b.inlined[pkg]) / float64(b.locations[pkg]) * 100
+}
+
+// inlinedOnly reports whether the function is only seen inlined into its callers in the build.
+func (b *buildInlining) inlinedOnly(name string) bool {
+	return b.inlinedFns[name] && !b.framedFns[name]
+}
+
+// debugBinary reports whether the main binary was built by Delve, without optimizations.
+func (b *buildInlining) debugBinary() bool {
+	return strings.HasPrefix(b.binary, debugBinaryPrefix)
+}
+
+// DetectOptimizationMismatch tells whether two profiles come from builds with different optimization flags.
+// Inlining is the visible trace of optimizations in a profile: a package whose locations have inlined frames
+// in one build and none in the other was built with -N -l (or -l) in the latter, and Delve names its debug
+// builds __debug_bin. Functions inlined in one build but separate frames in the other reveal different
+// inlining decisions even when both builds are optimized. It returns nil when the builds look alike, or when
+// the profiles do not have enough symbolized locations to tell.
+func DetectOptimizationMismatch(oldProfile, newProfile *profile.Profile) *OptimizationMismatch {
+	return detectOptimizationMismatch(collectBuildInlining(oldProfile), collectBuildInlining(newProfile))
+}
+
+// detectOptimizationMismatch implements DetectOptimizationMismatch on the collected inlining of both builds.
+func detectOptimizationMismatch(oldBuild, newBuild *buildInlining) *OptimizationMismatch {
+	m := &OptimizationMismatch{Packages: make([]PackageInlining, 0), Evidence: make([]string, 0)}
+
+	for pkg, oldLocations := range oldBuild.locations {
+		newLocations := newBuild.locations[pkg]
+		if pkg == "" || oldLocations < optimizationMinLocations || newLocations < optimizationMinLocations {
+			continue
+		}
+		pi := PackageInlining{
+			Package:           pkg,
+			OldLocations:      oldLocations,
+			NewLocations:      newLocations,
+			OldInlinedPercent: oldBuild.inlinedPercent(pkg),
+			NewInlinedPercent: newBuild.inlinedPercent(pkg),
+		}
+		switch {
+		case pi.OldInlinedPercent >= optimizationMinInlinedPercent && newBuild.inlined[pkg] == 0:
+			pi.Unoptimized = "new"
+		case pi.NewInlinedPercent >= optimizationMinInlinedPercent && oldBuild.inlined[pkg] == 0:
+			pi.Unoptimized = "old"
+		default:
+			continue
+		}
+		m.Packages = append(m.Packages, pi)
+	}
+	sort.Slice(m.Packages, func(i, j int) bool {
+		a, b := m.Packages[i], m.Packages[j]
+		if a.OldLocations+a.NewLocations != b.OldLocations+b.NewLocations {
+			return a.OldLocations+a.NewLocations > b.OldLocations+b.NewLocations
+		}
+		return a.Package < b.Package
+	})
+	unoptimized := make(map[string]bool)
+	for _, pi := range m.Packages {
+		unoptimized[pi.Unoptimized] = true
+		optimized, percent, locations := "old", pi.OldInlinedPercent, pi.OldLocations
+		plainLocations := pi.NewLocations
+		if pi.Unoptimized == "old" {
+			optimized, percent, locations = "new", pi.NewInlinedPercent, pi.NewLocations
+			plainLocations = pi.OldLocations
+		}
+		m.Evidence = append(m.Evidence, fmt.Sprintf("package %s: %s%% of %d locations have inlined frames in the %s build, none of %d in the %s build",
+			pi.Package, percentString(percent), locations, optimized, plainLocations, pi.Unoptimized))
+	}
+	for _, side := range []struct {
+		name  string
+		build *buildInlining
+		other *buildInlining
+	}{{"old", oldBuild, newBuild}, {"new", newBuild, oldBuild}} {
+		if side.build.debugBinary() && !side.other.debugBinary() {
+			unoptimized[side.name] = true
+			m.Evidence = append(m.Evidence, fmt.Sprintf("the %s binary is %s, the name Delve gives the builds it compiles with -gcflags=all=\"-N -l\"",
+				side.name, side.build.binary))
+		}
+	}
+
+	changed := make([]string, 0)
+	for name := range newBuild.framedFns {
+		if oldBuild.inlinedOnly(name) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldBuild.framedFns {
+		if newBuild.inlinedOnly(name) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	m.InliningChanged = len(changed)
+	if len(unoptimized) == 0 && m.InliningChanged < optimizationMinInliningChanges {
+		return nil
+	}
+	if m.InliningChanged > 0 {
+		examples := changed[:min(len(changed), maxInliningChangedExamples)]
+		m.Evidence = append(m.Evidence, fmt.Sprintf("%d functions are inlined into their callers in one build but separate frames in the other, e.g. %s",
+			m.InliningChanged, strings.Join(examples, ", ")))
+	}
+	if oldBuild.buildID != "" && newBuild.buildID != "" && oldBuild.buildID != newBuild.buildID {
+		m.Evidence = append(m.Evidence, fmt.Sprintf("the main binaries have different build IDs (%s, %s)", oldBuild.buildID, newBuild.buildID))
+	}
+
+	switch {
+	case unoptimized["old"] && !unoptimized["new"]:
+		m.UnoptimizedBuild = "old"
+	case unoptimized["new"] && !unoptimized["old"]:
+		m.UnoptimizedBuild = "new"
+	}
+	if m.UnoptimizedBuild != "" {
+		m.Warning = fmt.Sprintf("The %s profile comes from a build with optimizations or inlining disabled (e.g. -gcflags=\"-N -l\"), the other from an optimized build. "+
+			"Unoptimized code is slower throughout and keeps the calls of the small functions an optimized build inlines, so the changes below largely "+
+			"reflect the build flags rather than the code: compare profiles of builds with the same flags.", m.UnoptimizedBuild)
+	} else {
+		m.Warning = "The profiles come from builds with different inlining decisions (e.g. different -gcflags, PGO or Go versions). " +
+			"A function inlined in one build shows up as a separate frame with its call overhead in the other, so the changes of the functions " +
+			"marked below may reflect the builds rather than the code."
+	}
+	return m
+}
+
+// annotateInlining marks the diffs of the functions inlined in one build only (see FunctionDiff.Inlining).
+func annotateInlining(diffs []FunctionDiff, oldBuild, newBuild *buildInlining) {
+	for i := range diffs {
+		switch name := diffs[i].Name; {
+		case oldBuild.inlinedOnly(name) && newBuild.framedFns[name]:
+			diffs[i].Inlining = "old"
+		case newBuild.inlinedOnly(name) && oldBuild.framedFns[name]:
+			diffs[i].Inlining = "new"
+		}
+	}
+}
+
+// writeOptimizationMismatch renders the optimization mismatch section of a diff for text/markdown output.
+func writeOptimizationMismatch(w *reportWriter, m *OptimizationMismatch) {
+	if m == nil {
+		return
+	}
+	w.heading("Optimization Mismatch")
+	w.line("WARNING: %s", m.Warning)
+	for _, e := range m.Evidence {
+		w.line("- %s", e)
+	}
+}
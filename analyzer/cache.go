@@ -0,0 +1,132 @@
+package analyzer
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer/aggregate"
+)
+
+// DefaultAnalysisCacheEntries is the number of aggregations and flame graph trees kept by the analysis cache.
+const DefaultAnalysisCacheEntries = 64
+
+// analysisCache holds computed aggregations and flame graph trees of profiles analyzed with a cache key
+// (see WithCacheKey), so repeated analyses of one profile with other top-N or output settings reuse them.
+// Cached values are shared and must be treated as read-only.
+var analysisCache = newLRUCache(DefaultAnalysisCacheEntries)
+
+// lruCache is a fixed-size, least-recently-used cache safe for concurrent use.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Front is the most recently used entry
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) add(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*lruEntry).value = value
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// preparedKey identifies the prepared profile: the caller's key for the parsed profile plus every option that
+// changes it before the analysis. It is empty when caching is disabled.
+func (o Options) preparedKey() string {
+	if o.CacheKey == "" {
+		return ""
+	}
+	key := fmt.Sprintf("%s|%+v|%s|%s|%d|%d", o.CacheKey, o.Filters, o.Granularity, o.AggregationLevel, o.MaxSamples, o.Seed)
+	if !o.MinSampleValue.IsZero() {
+		// The threshold applies to the analyzed sample type
+		key += fmt.Sprintf("|min=%s|%s", o.MinSampleValue, o.SampleType)
+	}
+	return key
+}
+
+// cached returns the value cached under the prepared profile's key and name, computing and caching it on a miss.
+func (o Options) cached(name string, compute func() (interface{}, error)) (interface{}, error) {
+	key := o.preparedKey()
+	if key == "" {
+		return compute()
+	}
+	key += "|" + name
+	if value, ok := analysisCache.get(key); ok {
+		log.Printf("Analysis cache hit: %s", name)
+		return value, nil
+	}
+	value, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	analysisCache.add(key, value)
+	return value, nil
+}
+
+// aggregateByFunction is aggregate.AggregateByFunction through the analysis cache.
+func (o Options) aggregateByFunction(p *profile.Profile, valueIndex, objectsIndex int) *aggregate.Aggregation {
+	value, _ := o.cached(fmt.Sprintf("function/%d/%d", valueIndex, objectsIndex), func() (interface{}, error) {
+		return aggregate.AggregateByFunction(p, valueIndex, objectsIndex), nil
+	})
+	return value.(*aggregate.Aggregation)
+}
+
+// cumulativeByFunction is aggregate.CumulativeByFunction through the analysis cache.
+func (o Options) cumulativeByFunction(p *profile.Profile, valueIndex int) map[string]int64 {
+	value, _ := o.cached(fmt.Sprintf("cum/%d", valueIndex), func() (interface{}, error) {
+		return aggregate.CumulativeByFunction(p, valueIndex), nil
+	})
+	return value.(map[string]int64)
+}
+
+// aggregateBySite is aggregate.AggregateBySite through the analysis cache.
+func (o Options) aggregateBySite(p *profile.Profile, valueIndex, objectsIndex int) *aggregate.Aggregation {
+	value, _ := o.cached(fmt.Sprintf("site/%d/%d", valueIndex, objectsIndex), func() (interface{}, error) {
+		return aggregate.AggregateBySite(p, valueIndex, objectsIndex), nil
+	})
+	return value.(*aggregate.Aggregation)
+}
+
+// flameGraphTree is BuildFlameGraphTree through the analysis cache.
+func (o Options) flameGraphTree(p *profile.Profile, valueIndex int) (*FlameGraphNode, error) {
+	value, err := o.cached(fmt.Sprintf("flamegraph/%d", valueIndex), func() (interface{}, error) {
+		return BuildFlameGraphTree(p, valueIndex)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*FlameGraphNode), nil
+}
@@ -0,0 +1,292 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// CallGraphNode is a function in a call graph.
+type CallGraphNode struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Flat          int64  `json:"flat"` // Value of samples with the function as leaf
+	FlatFormatted string `json:"flatFormatted"`
+	Cum           int64  `json:"cum"` // Value of samples with the function anywhere on the stack
+	CumFormatted  string `json:"cumFormatted"`
+}
+
+// CallGraphEdge is a call from one function to another, weighted by the samples containing it.
+type CallGraphEdge struct {
+	From         int    `json:"from"` // Caller node ID
+	To           int    `json:"to"`   // Callee node ID
+	Caller       string `json:"caller"`
+	Callee       string `json:"callee"`
+	Flat         int64  `json:"flat"` // Value of samples in which the callee is the leaf
+	Cum          int64  `json:"cum"`  // Value of samples containing the call (once per sample)
+	CumFormatted string `json:"cumFormatted"`
+}
+
+// CallGraph is a caller → callee graph of the hottest functions of a profile, like 'go tool pprof -dot'.
+type CallGraph struct {
+	ProfileType  string          `json:"profileType"`
+	SampleType   string          `json:"sampleType"`
+	Unit         string          `json:"unit"`
+	Total        int64           `json:"total"`
+	Nodes        []CallGraphNode `json:"nodes"` // Sorted by cum value
+	Edges        []CallGraphEdge `json:"edges"` // Between the kept nodes, sorted by cum value
+	DroppedNodes int             `json:"droppedNodes"`
+	DroppedEdges int             `json:"droppedEdges"`
+}
+
+// callGraphEdgeKey identifies an edge by caller and callee.
+type callGraphEdgeKey struct {
+	caller, callee string
+}
+
+// BuildCallGraph builds the call graph of the value at valueIndex, keeping the maxNodes functions with the
+// highest cum value (all for maxNodes <= 0) and the edges between them. Recursive calls become self edges;
+// node and edge cum values count every sample once, so recursion does not inflate them.
+func BuildCallGraph(p *profile.Profile, valueIndex, maxNodes int) (*CallGraph, error) {
+	if valueIndex < 0 || valueIndex >= len(p.SampleType) {
+		return nil, fmt.Errorf("invalid sample value index %d", valueIndex)
+	}
+	st := p.SampleType[valueIndex]
+	graph := &CallGraph{SampleType: st.Type, Unit: st.Unit}
+
+	flat := make(map[string]int64)
+	cum := make(map[string]int64)
+	edgeFlat := make(map[callGraphEdgeKey]int64)
+	edgeCum := make(map[callGraphEdgeKey]int64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		names := sampleFunctions(s) // Leaf first
+		if len(names) == 0 {
+			continue
+		}
+		v := s.Value[valueIndex]
+		graph.Total += v
+		flat[names[0]] += v
+		seen := make(map[string]bool, len(names))
+		seenEdges := make(map[callGraphEdgeKey]bool, len(names))
+		for i, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				cum[name] += v
+			}
+			if i+1 == len(names) {
+				continue
+			}
+			edge := callGraphEdgeKey{caller: names[i+1], callee: name}
+			if !seenEdges[edge] {
+				seenEdges[edge] = true
+				edgeCum[edge] += v
+			}
+			if i == 0 {
+				edgeFlat[edge] += v
+			}
+		}
+	}
+
+	for name, value := range cum {
+		graph.Nodes = append(graph.Nodes, CallGraphNode{Name: name, Flat: flat[name], Cum: value})
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		a, b := graph.Nodes[i], graph.Nodes[j]
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		}
+		return a.Name < b.Name
+	})
+	if maxNodes > 0 && len(graph.Nodes) > maxNodes {
+		graph.DroppedNodes = len(graph.Nodes) - maxNodes
+		graph.Nodes = graph.Nodes[:maxNodes]
+	}
+	ids := make(map[string]int, len(graph.Nodes))
+	for i := range graph.Nodes {
+		node := &graph.Nodes[i]
+		node.ID = i + 1
+		node.FlatFormatted = FormatSampleValue(node.Flat, st.Unit)
+		node.CumFormatted = FormatSampleValue(node.Cum, st.Unit)
+		ids[node.Name] = node.ID
+	}
+	if graph.Nodes == nil {
+		graph.Nodes = make([]CallGraphNode, 0)
+	}
+
+	graph.Edges = make([]CallGraphEdge, 0)
+	for edge, value := range edgeCum {
+		from, okFrom := ids[edge.caller]
+		to, okTo := ids[edge.callee]
+		if !okFrom || !okTo {
+			graph.DroppedEdges++
+			continue
+		}
+		graph.Edges = append(graph.Edges, CallGraphEdge{
+			From:         from,
+			To:           to,
+			Caller:       edge.caller,
+			Callee:       edge.callee,
+			Flat:         edgeFlat[edge],
+			Cum:          value,
+			CumFormatted: FormatSampleValue(value, st.Unit),
+		})
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return graph, nil
+}
+
+// dotString quotes s as a Graphviz string.
+func dotString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// FormatCallGraphDOT renders a call graph as Graphviz DOT: boxes labeled with flat and cum values and
+// shares (larger font for more flat value), and edges labeled with their cum value (thicker for more).
+func FormatCallGraphDOT(g *CallGraph) string {
+	share := func(v int64) string {
+		if g.Total == 0 {
+			return "0%"
+		}
+		return DefaultValueFormat().Float(float64(v)/float64(g.Total)*100, 2) + "%"
+	}
+	var maxFlat, maxEdge int64 = 1, 1
+	for _, n := range g.Nodes {
+		if n.Flat > maxFlat {
+			maxFlat = n.Flat
+		}
+	}
+	for _, e := range g.Edges {
+		if e.Cum > maxEdge {
+			maxEdge = e.Cum
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph \"callgraph\" {\n")
+	b.WriteString(fmt.Sprintf("  label=%s;\n", dotString(fmt.Sprintf("%s profile (%s): total %s, %d nodes (%d dropped)",
+		g.ProfileType, g.SampleType, FormatSampleValue(g.Total, g.Unit), len(g.Nodes), g.DroppedNodes))))
+	b.WriteString("  node [shape=box fontname=\"Helvetica\"];\n")
+	for _, n := range g.Nodes {
+		label := fmt.Sprintf("%s\nflat %s (%s)\ncum %s (%s)", n.Name, n.FlatFormatted, share(n.Flat), n.CumFormatted, share(n.Cum))
+		fontSize := 10 + 14*n.Flat/maxFlat
+		b.WriteString(fmt.Sprintf("  N%d [label=%s fontsize=%d];\n", n.ID, dotString(label), fontSize))
+	}
+	for _, e := range g.Edges {
+		penWidth := 1 + 4*float64(e.Cum)/float64(maxEdge)
+		b.WriteString(fmt.Sprintf("  N%d -> N%d [label=%s penwidth=%.2f];\n", e.From, e.To, dotString(" "+e.CumFormatted), penWidth))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// xmlString escapes s for XML text and attribute values.
+func xmlString(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s)) // Writing to a strings.Builder does not fail
+	return b.String()
+}
+
+// FormatCallGraphGraphML renders a call graph as GraphML, to explore it in Gephi or yEd. Nodes carry the
+// function name ("label", shown by both tools), its package (to partition or group nodes by), whether the
+// package is in the standard library, and the flat and cum values with their shares; edges carry their cum
+// value as "weight", and their flat value.
+func FormatCallGraphGraphML(g *CallGraph) string {
+	share := func(v int64) float64 {
+		if g.Total == 0 {
+			return 0
+		}
+		return float64(v) / float64(g.Total) * 100
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd">` + "\n")
+	for _, key := range []struct{ id, domain, name, typ string }{
+		{"label", "node", "label", "string"},
+		{"package", "node", "package", "string"},
+		{"standard", "node", "standard", "boolean"},
+		{"flat", "node", "flat", "long"},
+		{"cum", "node", "cum", "long"},
+		{"flat_percent", "node", "flat_percent", "double"},
+		{"cum_percent", "node", "cum_percent", "double"},
+		{"weight", "edge", "weight", "long"},
+		{"edge_flat", "edge", "flat", "long"},
+	} {
+		b.WriteString(fmt.Sprintf("  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", key.id, key.domain, key.name, key.typ))
+	}
+	b.WriteString(fmt.Sprintf("  <graph id=\"callgraph\" edgedefault=\"directed\">\n    <desc>%s</desc>\n", xmlString(fmt.Sprintf(
+		"%s profile (%s, %s): total %s, %d nodes (%d dropped)", g.ProfileType, g.SampleType, g.Unit, FormatSampleValue(g.Total, g.Unit), len(g.Nodes), g.DroppedNodes))))
+	for _, n := range g.Nodes {
+		pkg := PackageName(n.Name)
+		b.WriteString(fmt.Sprintf("    <node id=\"n%d\">\n", n.ID))
+		b.WriteString(fmt.Sprintf("      <data key=\"label\">%s</data>\n", xmlString(n.Name)))
+		b.WriteString(fmt.Sprintf("      <data key=\"package\">%s</data>\n", xmlString(pkg)))
+		b.WriteString(fmt.Sprintf("      <data key=\"standard\">%t</data>\n", pkg != "" && isStandardPackage(pkg)))
+		b.WriteString(fmt.Sprintf("      <data key=\"flat\">%d</data>\n      <data key=\"cum\">%d</data>\n", n.Flat, n.Cum))
+		b.WriteString(fmt.Sprintf("      <data key=\"flat_percent\">%.4f</data>\n      <data key=\"cum_percent\">%.4f</data>\n", share(n.Flat), share(n.Cum)))
+		b.WriteString("    </node>\n")
+	}
+	for i, e := range g.Edges {
+		b.WriteString(fmt.Sprintf("    <edge id=\"e%d\" source=\"n%d\" target=\"n%d\">\n", i+1, e.From, e.To))
+		b.WriteString(fmt.Sprintf("      <data key=\"weight\">%d</data>\n      <data key=\"edge_flat\">%d</data>\n", e.Cum, e.Flat))
+		b.WriteString("    </edge>\n")
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	return b.String()
+}
+
+// formatCallGraph implements the "callgraph" (JSON), "callgraph-dot" (Graphviz DOT) and "graphml" formats of
+// Analyze for every profile type, with o.TopN as the number of nodes.
+func formatCallGraph(p *profile.Profile, profileType string, o Options) (string, error) {
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	log.Printf("Building call graph for %s profile (SampleType: %s, Nodes: %d)", profileType, p.SampleType[valueIndex].Type, o.TopN)
+	graph, err := BuildCallGraph(p, valueIndex, o.TopN)
+	if err != nil {
+		return "", err
+	}
+	graph.ProfileType = profileType
+
+	switch o.Format {
+	case "callgraph-dot":
+		return FormatCallGraphDOT(graph), nil
+	case "graphml":
+		return FormatCallGraphGraphML(graph), nil
+	}
+	jsonBytes, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling call graph to JSON: %v", err)
+		errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+		errJsonBytes, _ := json.Marshal(errorResult)
+		return string(errJsonBytes), nil
+	}
+	return string(jsonBytes), nil
+}
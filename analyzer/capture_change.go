@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// CaptureChange is the change of a live target's profile since its previous capture: a short DiffProfiles
+// summary that analyses of a target include without an explicit diff call.
+type CaptureChange struct {
+	PreviousCapture time.Time          `json:"previousCapture"`
+	ElapsedSeconds  float64            `json:"elapsedSeconds"`
+	Diff            *ProfileDiffResult `json:"diff"`
+}
+
+// CompareWithPreviousCapture diffs the current capture of a target against the previous one (see
+// DiffProfiles, by flat value), keeping the topN functions that grew and shrank the most.
+func CompareWithPreviousCapture(previous, current *profile.Profile, profileType, sampleType string, previousCapture, now time.Time, topN int) (*CaptureChange, error) {
+	diff, err := DiffProfiles(previous, current, profileType, sampleType, "flat", topN)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureChange{PreviousCapture: previousCapture, ElapsedSeconds: now.Sub(previousCapture).Seconds(), Diff: diff}, nil
+}
+
+// formatCaptureAge describes how long ago the previous capture was taken, e.g. "12 minutes ago".
+func formatCaptureAge(seconds float64) string {
+	switch {
+	case seconds < 60:
+		return fmt.Sprintf("%.0f seconds ago", seconds)
+	case seconds < 2*3600:
+		return fmt.Sprintf("%.0f minutes ago", seconds/60)
+	case seconds < 2*86400:
+		return fmt.Sprintf("%.1f hours ago", seconds/3600)
+	default:
+		return fmt.Sprintf("%.1f days ago", seconds/86400)
+	}
+}
+
+// FormatCaptureChange renders a capture change as a short "text" or "markdown" section, or as "json".
+func FormatCaptureChange(c *CaptureChange, format string) (string, error) {
+	d := c.Diff
+	log.Printf("Formatting change since last capture: %s, %d grew, %d shrank (Format: %s)", d.ProfileType, d.Regressed, d.Improved, format)
+	switch format {
+	case "text", "markdown", "markdown-compact":
+		formatValue := func(v int64) string { return FormatSampleValue(v, d.Unit) }
+		w := newReportWriter(format)
+		w.title("Change since last capture (%s)", formatCaptureAge(c.ElapsedSeconds))
+		w.line("Total %s: %s → %s (%s, %+.2f%%)", d.SampleType, formatValue(d.OldTotal), formatValue(d.NewTotal),
+			formatSignedValue(d.TotalDelta, formatValue), d.TotalDeltaPercent)
+		if len(d.Regressions) == 0 && len(d.Improvements) == 0 {
+			w.line("No function changed.")
+			return w.String(), nil
+		}
+		t := newTable(valueColumn("Previous"), valueColumn("Now"), deltaColumn("Delta"), nameColumn("Function Name"))
+		for _, list := range [][]FunctionDiff{d.Regressions, d.Improvements} {
+			for _, f := range list {
+				t.add(f.OldFormatted, f.NewFormatted, f.DeltaFormatted, f.Name)
+			}
+		}
+		w.table(t)
+		if more := d.Regressed + d.Improved - len(d.Regressions) - len(d.Improvements); more > 0 {
+			w.line("%d more function(s) changed; call diff_profiles for the full comparison.", more)
+		}
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.Marshal(map[string]*CaptureChange{"changeSinceLastCapture": c})
+		if err != nil {
+			log.Printf("Error marshaling capture change to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format for the capture change: '%s' (supported: text, markdown, json)", format)
+	}
+}
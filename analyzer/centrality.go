@@ -0,0 +1,166 @@
+package analyzer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// centralityMinEdgeShare is the share of a function's cum value an edge must carry for the caller or callee
+// to count in its fan-in or fan-out, so that rare calls do not make every function look like a hub.
+const centralityMinEdgeShare = 0.1
+
+// centralityChokepointShare is the betweenness from which a funnel or a chokepoint is reported as such.
+const centralityChokepointShare = 0.1
+
+// FunctionCentrality holds the call graph metrics of a function.
+type FunctionCentrality struct {
+	Name string
+	Flat int64
+	Cum  int64
+	// Through is the value of the samples in which the function is neither the leaf nor the root: the cost
+	// that flows through it from its callers to its callees (counted once per sample)
+	Through int64
+	// Betweenness is Through as a share of the total: the weighted betweenness of the function over the
+	// root-to-leaf paths of the samples
+	Betweenness float64
+	FanIn       int    // Callers carrying at least centralityMinEdgeShare of Cum
+	FanOut      int    // Callees carrying at least centralityMinEdgeShare of Cum
+	Role        string // "chokepoint" (fan-in and fan-out), "funnel" (fan-in), "dispatcher" (fan-out) or "chain"
+}
+
+// CallGraphCentrality computes the centrality of the functions of a profile for the value at valueIndex,
+// sorted by the cost flowing through them (Through), then by cum value. It also returns the total value.
+// Recursive calls are counted once per sample and do not count in fan-in or fan-out.
+func CallGraphCentrality(p *profile.Profile, valueIndex int) ([]FunctionCentrality, int64, error) {
+	graph, err := BuildCallGraph(p, valueIndex, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	through := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		names := sampleFunctions(s) // Leaf first
+		seen := make(map[string]bool, len(names))
+		for i := 1; i < len(names)-1; i++ {
+			if !seen[names[i]] {
+				seen[names[i]] = true
+				through[names[i]] += s.Value[valueIndex]
+			}
+		}
+	}
+
+	cum := make(map[string]int64, len(graph.Nodes))
+	for _, n := range graph.Nodes {
+		cum[n.Name] = n.Cum
+	}
+	fanIn := make(map[string]int)
+	fanOut := make(map[string]int)
+	for _, e := range graph.Edges {
+		if e.Caller == e.Callee {
+			continue
+		}
+		if float64(e.Cum) >= centralityMinEdgeShare*float64(cum[e.Callee]) {
+			fanIn[e.Callee]++
+		}
+		if float64(e.Cum) >= centralityMinEdgeShare*float64(cum[e.Caller]) {
+			fanOut[e.Caller]++
+		}
+	}
+
+	result := make([]FunctionCentrality, 0, len(graph.Nodes))
+	for _, n := range graph.Nodes {
+		c := FunctionCentrality{Name: n.Name, Flat: n.Flat, Cum: n.Cum, Through: through[n.Name], FanIn: fanIn[n.Name], FanOut: fanOut[n.Name]}
+		if graph.Total != 0 {
+			c.Betweenness = float64(c.Through) / float64(graph.Total)
+		}
+		switch {
+		case c.FanIn >= 2 && c.FanOut >= 2:
+			c.Role = "chokepoint"
+		case c.FanIn >= 2:
+			c.Role = "funnel"
+		case c.FanOut >= 2:
+			c.Role = "dispatcher"
+		default:
+			c.Role = "chain"
+		}
+		result = append(result, c)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Through != result[j].Through {
+			return result[i].Through > result[j].Through
+		}
+		return result[i].Cum > result[j].Cum // graph.Nodes is already sorted by cum, then flat, then name
+	})
+	return result, graph.Total, nil
+}
+
+// formatCentrality implements the "centrality" format of Analyze for every profile type: the o.TopN functions
+// through which most of the cost flows, and the funnels and chokepoints among them.
+func formatCentrality(p *profile.Profile, profileType string, o Options) (string, error) {
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	st := p.SampleType[valueIndex]
+	log.Printf("Computing call graph centrality for %s profile (SampleType: %s, Top: %d)", profileType, st.Type, o.TopN)
+	functions, total, err := CallGraphCentrality(p, valueIndex)
+	if err != nil {
+		return "", err
+	}
+	limit := o.TopN
+	if limit <= 0 || limit > len(functions) {
+		limit = len(functions)
+	}
+	share := func(v int64) string {
+		if total == 0 {
+			return "0.00%"
+		}
+		return DefaultValueFormat().Float(float64(v)/float64(total)*100, 2) + "%"
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Call Graph Centrality (%s profile, Top %d Functions by Flow-through)\n", profileType, limit))
+	b.WriteString(fmt.Sprintf("Total %s (%s): %s\n", st.Type, st.Unit, FormatSampleValue(total, st.Unit)))
+	b.WriteString("Flow-through: value of the samples in which the function is neither the leaf nor the root, i.e. the cost it passes\n")
+	b.WriteString("from its callers to its callees; as a share of the total, its weighted betweenness. In/Out count the callers and\n")
+	b.WriteString(fmt.Sprintf("callees carrying at least %.0f%% of its cum value.\n", centralityMinEdgeShare*100))
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(fmt.Sprintf("%-15s %-12s %-15s %-15s %-4s %-4s %-11s %s\n", "Through", "Betweenness", "Cum", "Flat", "In", "Out", "Role", "Function Name"))
+	b.WriteString("--------------------------------------------------\n")
+	for _, c := range functions[:limit] {
+		b.WriteString(fmt.Sprintf("%-15s %-12s %-15s %-15s %-4d %-4d %-11s %s\n",
+			FormatSampleValue(c.Through, st.Unit), share(c.Through), FormatSampleValue(c.Cum, st.Unit),
+			FormatSampleValue(c.Flat, st.Unit), c.FanIn, c.FanOut, c.Role, c.Name))
+	}
+
+	// Funnels gather the cost of several callers and chokepoints also spread it over several callees: making
+	// them cheaper (or calling them less) helps every path through them, which flat and cum rankings do not show
+	var chokepoints []FunctionCentrality
+	for _, c := range functions {
+		if c.Betweenness >= centralityChokepointShare && (c.Role == "chokepoint" || c.Role == "funnel") {
+			chokepoints = append(chokepoints, c)
+		}
+	}
+	b.WriteString(fmt.Sprintf("\n=== Chokepoints (Flow-through >= %.0f%%, several callers) ===\n", centralityChokepointShare*100))
+	if len(chokepoints) == 0 {
+		b.WriteString("None: no function with several significant callers carries that much of the cost.\n")
+	}
+	for _, c := range chokepoints {
+		b.WriteString(fmt.Sprintf("- %s (%s): %s of the cost flows through it from %d callers to %d callees (flat %s)\n",
+			c.Name, c.Role, share(c.Through), c.FanIn, c.FanOut, share(c.Flat)))
+	}
+	return b.String(), nil
+}
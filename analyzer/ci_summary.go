@@ -0,0 +1,179 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// CIFunction is one of the hottest functions of a profile in a CI summary.
+type CIFunction struct {
+	FunctionName   string  `json:"functionName"`
+	Value          int64   `json:"value"`
+	ValueFormatted string  `json:"valueFormatted"`
+	Percentage     float64 `json:"percentage"`
+}
+
+// CIProfile summarizes one profile referenced by CI output: its inferred type, total and hottest functions
+// by flat value. Error is set instead when the profile could not be loaded or summarized.
+type CIProfile struct {
+	Path           string       `json:"path"`
+	ProfileType    string       `json:"profileType,omitempty"`
+	SampleType     string       `json:"sampleType,omitempty"`
+	Unit           string       `json:"unit,omitempty"`
+	Total          int64        `json:"total"`
+	TotalFormatted string       `json:"totalFormatted,omitempty"`
+	Functions      []CIFunction `json:"functions,omitempty"`
+	Error          string       `json:"error,omitempty"`
+}
+
+// CIPackage groups the profiles of one Go package (or, without a package, of one artifact directory).
+type CIPackage struct {
+	Package  string      `json:"package"`
+	Status   string      `json:"status,omitempty"` // Test result from 'go test -json': "pass", "fail" or "skip"
+	Profiles []CIProfile `json:"profiles"`
+}
+
+// CISummary is the per-package summary of the profiles found in CI output.
+type CISummary struct {
+	Source   string      `json:"source"`
+	Packages []CIPackage `json:"packages"`
+	Skipped  int         `json:"skipped,omitempty"` // Profiles found but not analyzed (over the limit)
+}
+
+// InferProfileType guesses the profile type of a profile from its sample types, like 'go tool pprof' picks
+// its defaults. The file name breaks the ties the sample types leave open: heap and allocs profiles have the
+// same sample types ("allocs" in the name selects allocs), and so do mutex and block profiles ("block" in the
+// name selects block). It returns "" when the sample types match no known profile type.
+func InferProfileType(p *profile.Profile, name string) string {
+	types := make(map[string]bool, len(p.SampleType))
+	for _, st := range p.SampleType {
+		types[st.Type] = true
+	}
+	base := strings.ToLower(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	switch {
+	case types["inuse_space"] || types["alloc_space"]:
+		if strings.Contains(base, "alloc") || !types["inuse_space"] {
+			return "allocs"
+		}
+		return "heap"
+	case types["goroutine"]:
+		return "goroutine"
+	case types["threadcreate"]:
+		return "threadcreate"
+	case types["contentions"] || types["delay"]:
+		if strings.Contains(base, "block") {
+			return "block"
+		}
+		return "mutex"
+	case types["cpu"] || types["samples"]:
+		return "cpu"
+	}
+	return ""
+}
+
+// SummarizeCIProfile infers the type of a profile (see InferProfileType) and returns its total and the topN
+// functions by flat value of the sample type DiffProfiles compares for that type, or the profile's default
+// sample type.
+func SummarizeCIProfile(p *profile.Profile, name string, topN int) CIProfile {
+	summary := CIProfile{Path: name, ProfileType: InferProfileType(p, name)}
+	sampleType := ""
+	if preferred, ok := diffSampleTypes[summary.ProfileType]; ok {
+		if _, err := sampleValueIndex(p, preferred); err == nil {
+			sampleType = preferred
+		}
+	}
+	heatmap, err := BuildHeatmap([]*profile.Profile{p}, []string{name}, sampleType, topN)
+	if err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	summary.SampleType = heatmap.SampleType
+	summary.Unit = heatmap.Unit
+	summary.Total = heatmap.ColumnTotals[0]
+	summary.TotalFormatted = FormatSampleValue(summary.Total, summary.Unit)
+	for i, function := range heatmap.Functions {
+		value := heatmap.Values[i][0]
+		if value <= 0 {
+			continue
+		}
+		summary.Functions = append(summary.Functions, CIFunction{
+			FunctionName:   function,
+			Value:          value,
+			ValueFormatted: FormatSampleValue(value, summary.Unit),
+			Percentage:     heatmap.Normalized[i][0] * 100,
+		})
+	}
+	return summary
+}
+
+// FormatCISummary renders a CI summary in the given format ("text", "markdown" or "json").
+func FormatCISummary(s *CISummary, format string) (string, error) {
+	profiles, failed := 0, 0
+	for _, pkg := range s.Packages {
+		for _, p := range pkg.Profiles {
+			profiles++
+			if p.Error != "" {
+				failed++
+			}
+		}
+	}
+	log.Printf("Formatting CI summary: %d profiles in %d packages (Format: %s)", profiles, len(s.Packages), format)
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("CI Profile Summary: %d profile(s) in %d package(s) from %s", profiles, len(s.Packages), s.Source)
+		if failed > 0 {
+			w.line("%d profile(s) could not be analyzed.", failed)
+		}
+		if s.Skipped > 0 {
+			w.line("%d more profile(s) were found but not analyzed (raise 'max_profiles').", s.Skipped)
+		}
+		for _, pkg := range s.Packages {
+			if pkg.Status != "" {
+				w.heading("%s (%s)", pkg.Package, pkg.Status)
+			} else {
+				w.heading("%s", pkg.Package)
+			}
+			for j, p := range pkg.Profiles {
+				if j > 0 {
+					w.blankLine()
+				}
+				if p.Error != "" {
+					w.line("%s: ERROR: %s", p.Path, p.Error)
+					continue
+				}
+				profileType := p.ProfileType
+				if profileType == "" {
+					profileType = "unknown"
+				}
+				w.line("%s: %s profile, total %s %s", p.Path, profileType, p.TotalFormatted, p.SampleType)
+				if len(p.Functions) == 0 {
+					continue
+				}
+				t := newTable(valueColumn("Flat"), valueColumn("Flat%"), nameColumn("Function Name"))
+				for _, f := range p.Functions {
+					t.add(f.ValueFormatted, percentString(f.Percentage), f.FunctionName)
+				}
+				w.table(t)
+			}
+		}
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling CI summary to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format for the CI summary: '%s' (supported: text, markdown, json)", format)
+	}
+}
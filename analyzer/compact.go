@@ -0,0 +1,146 @@
+package analyzer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// DefaultCharBudget is the target size of markdown-compact reports when no budget is given.
+const DefaultCharBudget = 4000
+
+// compactStackDepth is the number of frames shown per stack in markdown-compact reports.
+const compactStackDepth = 8
+
+// AbbreviateFunctionName drops the import path of a Go function name, keeping the package name:
+// "github.com/org/repo/pkg.(*T).Method" becomes "pkg.(*T).Method". Slashes inside generic type
+// arguments are left alone.
+func AbbreviateFunctionName(name string) string {
+	path := name
+	if i := strings.Index(name, "["); i >= 0 {
+		path = name[:i]
+	}
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// compactStack is the aggregated value of one distinct call stack.
+type compactStack struct {
+	frames []string // Leaf first, abbreviated
+	value  int64
+}
+
+// formatCompactMarkdown renders a token-efficient markdown report for LLM context windows: abbreviated
+// function names, value and percentage merged into one field, and the hottest functions and stacks only.
+// Lines are added in order of importance until budget characters are reached.
+func formatCompactMarkdown(p *profile.Profile, profileType string, o Options) (string, error) {
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	budget := o.CharBudget
+	if budget <= 0 {
+		budget = DefaultCharBudget
+	}
+	sampleType := p.SampleType[valueIndex]
+	log.Printf("Formatting compact markdown for %s profile (SampleType: %s, Top %d, Budget %d chars)", profileType, sampleType.Type, o.TopN, budget)
+
+	agg := o.aggregateByFunction(p, valueIndex, -1)
+	stacks := make(map[string]*compactStack)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex || s.Value[valueIndex] == 0 {
+			continue
+		}
+		names := sampleFunctions(s)
+		key := strings.Join(names, "\n")
+		stack, ok := stacks[key]
+		if !ok {
+			frames := make([]string, len(names))
+			for i, name := range names {
+				frames[i] = AbbreviateFunctionName(name)
+			}
+			stack = &compactStack{frames: frames}
+			stacks[key] = stack
+		}
+		stack.value += s.Value[valueIndex]
+	}
+	sortedStacks := make([]*compactStack, 0, len(stacks))
+	for _, stack := range stacks {
+		sortedStacks = append(sortedStacks, stack)
+	}
+	sort.Slice(sortedStacks, func(i, j int) bool {
+		if sortedStacks[i].value != sortedStacks[j].value {
+			return sortedStacks[i].value > sortedStacks[j].value
+		}
+		return strings.Join(sortedStacks[i].frames, ";") < strings.Join(sortedStacks[j].frames, ";")
+	})
+
+	share := func(v int64) string {
+		if agg.Total == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%.1f%%", float64(v)*100/float64(agg.Total))
+	}
+
+	var b strings.Builder
+	omitted := 0
+	add := func(line string, limit int) {
+		if b.Len()+len(line)+1 > limit {
+			omitted++
+			return
+		}
+		b.WriteString(line + "\n")
+	}
+	add(fmt.Sprintf("**%s** %s total %s", profileType, sampleType.Type, FormatSampleValue(agg.Total, sampleType.Unit)), budget)
+	switch profileType {
+	case "goroutine":
+		add("States: "+FormatGoroutineStateSummary(SummarizeGoroutineStates(p)), budget)
+	case "threadcreate":
+		add("Causes: "+FormatThreadCreateCauseSummary(SummarizeThreadCreateCauses(p)), budget)
+	}
+	if len(sortedStacks) == 0 {
+		b.WriteString("_no samples_\n")
+		return b.String(), nil
+	}
+
+	// Functions get a bit more than half of the budget, so the stacks section is never crowded out
+	add("Top functions (flat):", budget)
+	for i, stat := range agg.Stats {
+		if i >= o.TopN {
+			break
+		}
+		add(fmt.Sprintf("- %s %s %s", share(stat.Flat), FormatSampleValue(stat.Flat, sampleType.Unit), AbbreviateFunctionName(stat.Name)), budget*6/10)
+	}
+	add("Top stacks (leaf←caller):", budget)
+	for i, stack := range sortedStacks {
+		if i >= o.TopN {
+			break
+		}
+		frames := stack.frames
+		suffix := ""
+		depth := compactStackDepth
+		if o.MaxStackDepth > 0 {
+			depth = o.MaxStackDepth
+		}
+		if len(frames) > depth {
+			suffix = fmt.Sprintf("←…+%d", len(frames)-depth)
+			frames = frames[:depth]
+		}
+		add(fmt.Sprintf("- %s %s", share(stack.value), strings.Join(frames, "←")+suffix), budget)
+	}
+	if omitted > 0 {
+		b.WriteString(fmt.Sprintf("_%d lines omitted (budget %d chars)_\n", omitted, budget))
+	}
+	return b.String(), nil
+}
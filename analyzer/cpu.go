@@ -5,24 +5,43 @@ import (
 	"fmt"
 	"log"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/google/pprof/profile"
 )
 
+// cpuStat 是 CPU 分析中单个函数的 Flat 和 Cum 值。
+type cpuStat struct {
+	Name string
+	Flat int64
+	Cum  int64
+}
+
 // AnalyzeCPUProfile 分析 CPU profile 文件并返回格式化结果。
 func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, error) {
+	return analyzeCPUProfile(p, NewOptions(WithTopN(topN), WithFormat(format)))
+}
+
+// analyzeCPUProfile is the implementation of AnalyzeCPUProfile, driven by Options (see Analyze).
+func analyzeCPUProfile(p *profile.Profile, o Options) (string, error) {
+	topN, format := o.TopN, o.Format
 	log.Printf("Analyzing CPU profile (Top %d, Format: %s)", topN, format)
 
 	// --- 1. 确定用于分析的值的索引 (通常是 CPU 时间) ---
-	valueIndex := -1 // CPU 时间样本值的索引 (通常是 1, 'samples/count' 是 0)
-	for i, st := range p.SampleType {
-		// 查找 'cpu' 和 'nanoseconds' 或类似的样本类型
-		if (st.Type == "cpu" || st.Type == "samples") && (st.Unit == "nanoseconds" || st.Unit == "count") {
-			// 优先选择 'cpu'/'nanoseconds'，否则选择 'samples'/'count'
-			if valueIndex == -1 || st.Type == "cpu" {
-				valueIndex = i
+	// o.SampleType overrides the default value type
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		// CPU 时间样本值的索引 (通常是 1, 'samples/count' 是 0)
+		for i, st := range p.SampleType {
+			// 查找 'cpu' 和 'nanoseconds' 或类似的样本类型
+			if (st.Type == "cpu" || st.Type == "samples") && (st.Unit == "nanoseconds" || st.Unit == "count") {
+				// 优先选择 'cpu'/'nanoseconds'，否则选择 'samples'/'count'
+				if valueIndex == -1 || st.Type == "cpu" {
+					valueIndex = i
+				}
 			}
 		}
 	}
@@ -40,42 +59,52 @@ func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, err
 	valueUnit := p.SampleType[valueIndex].Unit
 	log.Printf("使用索引 %d (%s/%s) 进行 CPU 分析", valueIndex, p.SampleType[valueIndex].Type, valueUnit)
 
-	// --- 2. 按函数聚合 Flat 时间 ---
-	flatTime := make(map[string]int64)
-	totalValue := int64(0)
-
-	for _, s := range p.Sample {
-		if len(s.Location) > 0 && len(s.Value) > valueIndex {
-			v := s.Value[valueIndex]
-			totalValue += v
-			// Flat 时间归因于堆栈中最顶层的函数
-			loc := s.Location[0]
-			for _, line := range loc.Line {
-				if line.Function != nil {
-					flatTime[line.Function.Name] += v
-					// 每个样本的顶层框架只计算一次函数
-					break
-				}
-			}
-		}
-	}
+	// --- 2. 按函数聚合 Flat 时间 (归因于堆栈中最顶层的函数) 和 Cum 时间 (函数出现在堆栈任意位置) ---
+	agg := o.aggregateByFunction(p, valueIndex, -1)
+	totalValue := agg.Total
+	cum := o.cumulativeByFunction(p, valueIndex)
 
 	if totalValue == 0 {
 		log.Printf("Warning: Total value for the selected sample type (%s/%s) is zero.", p.SampleType[valueIndex].Type, valueUnit)
 		// 继续处理，可能只是一个空的 profile 或选择了错误的样本类型
 	}
 
-	// --- 3. 按 Flat 时间对函数进行排序 ---
-	stats := make([]functionStat, 0, len(flatTime))
-	for name, flat := range flatTime {
-		stats = append(stats, functionStat{Name: name, Flat: flat})
+	// --- 3. 排序：按 Flat 时排列叶子函数 (已按 Flat 降序排列)；按 Cum 时排列堆栈中的所有函数 ---
+	sortBy := o.SortBy
+	if sortBy == "" {
+		sortBy = "flat"
+	}
+	stats := make([]cpuStat, 0, len(agg.Stats))
+	if sortBy == "cum" {
+		flat := make(map[string]int64, len(agg.Stats))
+		for _, stat := range agg.Stats {
+			flat[stat.Name] = stat.Flat
+		}
+		for name, value := range cum {
+			stats = append(stats, cpuStat{Name: name, Flat: flat[name], Cum: value})
+		}
+		sort.Slice(stats, func(i, j int) bool {
+			if stats[i].Cum != stats[j].Cum {
+				return stats[i].Cum > stats[j].Cum
+			}
+			if stats[i].Flat != stats[j].Flat {
+				return stats[i].Flat > stats[j].Flat
+			}
+			return stats[i].Name < stats[j].Name
+		})
+	} else {
+		for _, stat := range agg.Stats {
+			stats = append(stats, cpuStat{Name: stat.Name, Flat: stat.Flat, Cum: cum[stat.Name]})
+		}
+	}
+	percentOf := func(value int64) float64 {
+		if totalValue == 0 { // 如果 totalValue 为零，则百分比为零
+			return 0
+		}
+		return (float64(value) / float64(totalValue)) * 100
 	}
-	sort.Slice(stats, func(i, j int) bool {
-		return stats[i].Flat > stats[j].Flat // 降序排列
-	})
 
 	// --- 4. 格式化输出 ---
-	var b strings.Builder
 	limit := topN
 	if limit > len(stats) {
 		limit = len(stats)
@@ -90,30 +119,25 @@ func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, err
 	}
 
 	switch format {
-	case "text", "markdown": // 目前两者使用相似格式
-		if format == "markdown" {
-			b.WriteString("```text\n") // 使用文本块以获得更好的对齐效果
+	case "text", "markdown":
+		w := newReportWriter(format)
+		sortTitle := "Flat"
+		if sortBy == "cum" {
+			sortTitle = "Cum"
 		}
-		b.WriteString(fmt.Sprintf("CPU Profile Analysis (Top %d Functions by Flat Time)\n", topN))
-		b.WriteString(fmt.Sprintf("Total Samples/Time (%s): %s\n", valueUnit, FormatSampleValue(totalValue, valueUnit))) // 使用导出的 FormatSampleValue
+		w.title("CPU Profile Analysis (Top %d Functions by %s Time)", topN, sortTitle)
+		w.line("Total Samples/Time (%s): %s", valueUnit, FormatSampleValue(totalValue, valueUnit)) // 使用导出的 FormatSampleValue
 		if totalDuration > 0 {
-			b.WriteString(fmt.Sprintf("Total Duration: %s\n", totalDuration))
+			w.line("Total Duration: %s", totalDuration)
 		}
-		b.WriteString("--------------------------------------------------\n")
-		b.WriteString(fmt.Sprintf("%-15s %-15s %s\n", "Flat Time", "%", "Function Name"))
-		b.WriteString("--------------------------------------------------\n")
+		t := newTable(valueColumn("Flat Time"), valueColumn("Flat%"), valueColumn("Cum Time"), valueColumn("Cum%"), nameColumn("Function Name"))
 		for i := 0; i < limit; i++ {
 			stat := stats[i]
-			percent := 0.0
-			// 如果 totalValue 不为零，则计算百分比
-			if totalValue != 0 {
-				percent = (float64(stat.Flat) / float64(totalValue)) * 100
-			}
-			b.WriteString(fmt.Sprintf("%-15s %-15.2f %s\n", FormatSampleValue(stat.Flat, valueUnit), percent, stat.Name)) // 使用导出的 FormatSampleValue
-		}
-		if format == "markdown" {
-			b.WriteString("```\n")
+			t.add(FormatSampleValue(stat.Flat, valueUnit), percentString(percentOf(stat.Flat)),
+				FormatSampleValue(stat.Cum, valueUnit), percentString(percentOf(stat.Cum)), stat.Name)
 		}
+		w.table(t)
+		return w.String(), nil
 	case "json":
 		result := CPUAnalysisResult{ // 使用 types.go 中的结构体
 			ProfileType:         "cpu",
@@ -122,6 +146,7 @@ func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, err
 			TotalValue:          totalValue,
 			TotalValueFormatted: FormatSampleValue(totalValue, valueUnit), // 使用导出的 FormatSampleValue
 			TopN:                limit,
+			SortBy:              sortBy,
 			Functions:           make([]CPUFunctionStat, 0, limit), // 使用 types.go 中的结构体
 		}
 		if totalDuration > 0 {
@@ -130,15 +155,14 @@ func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, err
 
 		for i := 0; i < limit; i++ {
 			stat := stats[i]
-			percent := 0.0
-			if totalValue != 0 {
-				percent = (float64(stat.Flat) / float64(totalValue)) * 100
-			}
 			result.Functions = append(result.Functions, CPUFunctionStat{ // 使用 types.go 中的结构体
 				FunctionName:       stat.Name,
 				FlatValue:          stat.Flat,
 				FlatValueFormatted: FormatSampleValue(stat.Flat, valueUnit), // 使用导出的 FormatSampleValue
-				Percentage:         percent,
+				Percentage:         percentOf(stat.Flat),
+				CumValue:           stat.Cum,
+				CumValueFormatted:  FormatSampleValue(stat.Cum, valueUnit),
+				CumPercentage:      percentOf(stat.Cum),
 			})
 		}
 
@@ -154,7 +178,7 @@ func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, err
 
 	case "flamegraph-json":
 		log.Printf("Generating flame graph JSON for CPU profile using value index %d", valueIndex)
-		flameGraphRoot, err := BuildFlameGraphTree(p, valueIndex) // 调用新函数
+		flameGraphRoot, err := o.flameGraphTree(p, valueIndex) // 调用新函数
 		if err != nil {
 			log.Printf("Error building flame graph tree: %v", err)
 			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to build flame graph tree: %v", err)}
@@ -173,6 +197,4 @@ func AnalyzeCPUProfile(p *profile.Profile, topN int, format string) (string, err
 	default:
 		return "", fmt.Errorf("unsupported output format: %s", format)
 	}
-
-	return b.String(), nil
 }
@@ -0,0 +1,299 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// dbConnWaitFrames identify database/sql waiting for a free connection from the pool.
+var dbConnWaitFrames = []string{"database/sql.(*DB).conn"}
+
+// dbOpenerFrames identify the background goroutine opening new connections for a pool.
+var dbOpenerFrames = []string{"database/sql.(*DB).connectionOpener"}
+
+// dbBackgroundFrames are pool maintenance goroutines whose names share the (*DB).conn prefix.
+var dbBackgroundFrames = []string{"database/sql.(*DB).connectionOpener", "database/sql.(*DB).connectionCleaner", "database/sql.(*DB).connectionResetter"}
+
+// dbMutexFrames identify lock contention inside database/sql (DB.mu, driverConn locks).
+var dbMutexFrames = []string{"database/sql."}
+
+// dbInternalPrefixes are skipped when attributing a wait to the calling query site.
+var dbInternalPrefixes = []string{"database/sql.", "runtime.", "context.", "sync."}
+
+// dbPoolRecommendations are printed when the pool looks exhausted.
+var dbPoolRecommendations = []string{
+	"Check SetMaxOpenConns: the pool may be too small for the request concurrency (db.Stats().WaitCount/WaitDuration confirm it)",
+	"Always close *sql.Rows (defer rows.Close()) and finish transactions; unreleased connections keep the pool exhausted",
+	"Keep transactions short and avoid calling external services while holding a connection",
+	"Pass a context with a deadline to QueryContext/ExecContext so callers fail fast instead of queueing indefinitely",
+	"Tune SetMaxIdleConns / SetConnMaxIdleTime so connections are reused instead of reopened by connectionOpener",
+}
+
+// DBPoolProfiles bundles the profiles inspected by the database pool contention analysis.
+// Any profile may be nil, but at least one must be provided.
+type DBPoolProfiles struct {
+	Block     *profile.Profile
+	Mutex     *profile.Profile
+	Goroutine *profile.Profile
+}
+
+// DBPoolSiteStat is the contribution of one query call site to pool contention.
+type DBPoolSiteStat struct {
+	Source         string `json:"source"` // "block", "mutex" or "goroutine"
+	Site           string `json:"site"`
+	Value          int64  `json:"value"`
+	ValueFormatted string `json:"valueFormatted"`
+	Contentions    int64  `json:"contentions,omitempty"`
+}
+
+// DBPoolContentionResult is the JSON result of the database pool contention analysis.
+type DBPoolContentionResult struct {
+	BlockDelay              int64            `json:"blockDelay"`
+	BlockDelayFormatted     string           `json:"blockDelayFormatted"`
+	BlockContentions        int64            `json:"blockContentions"`
+	BlockDelayShare         float64          `json:"blockDelayShare"` // Percentage of the profile's total block delay
+	MutexDelay              int64            `json:"mutexDelay"`
+	MutexDelayFormatted     string           `json:"mutexDelayFormatted"`
+	MutexContentions        int64            `json:"mutexContentions"`
+	MutexDelayShare         float64          `json:"mutexDelayShare"`
+	WaitingGoroutines       int64            `json:"waitingGoroutines"`
+	ConnectionOpeners       int64            `json:"connectionOpeners"`
+	PoolExhaustionSuspected bool             `json:"poolExhaustionSuspected"`
+	Verdict                 string           `json:"verdict"`
+	Sites                   []DBPoolSiteStat `json:"sites"`
+	Recommendations         []string         `json:"recommendations,omitempty"`
+}
+
+// contentionValueIndices returns the contentions/count and delay/nanoseconds indices of a block or mutex profile.
+func contentionValueIndices(p *profile.Profile) (contentionsIndex, delayIndex int) {
+	contentionsIndex, delayIndex = -1, -1
+	if p == nil {
+		return
+	}
+	for i, st := range p.SampleType {
+		if st.Type == "contentions" && st.Unit == "count" {
+			contentionsIndex = i
+		}
+		if st.Type == "delay" && st.Unit == "nanoseconds" {
+			delayIndex = i
+		}
+	}
+	return
+}
+
+// profileTotal sums a sample value over all samples of a profile.
+func profileTotal(p *profile.Profile, valueIndex int) int64 {
+	total := int64(0)
+	if p == nil || valueIndex < 0 {
+		return total
+	}
+	for _, s := range p.Sample {
+		if len(s.Value) > valueIndex {
+			total += s.Value[valueIndex]
+		}
+	}
+	return total
+}
+
+// withoutFrames returns a copy of p (sample types and samples only) without the samples whose stack
+// contains any of the given frames.
+func withoutFrames(p *profile.Profile, frames []string) *profile.Profile {
+	if p == nil {
+		return nil
+	}
+	filtered := &profile.Profile{SampleType: p.SampleType, Sample: make([]*profile.Sample, 0, len(p.Sample))}
+	for _, s := range p.Sample {
+		keep := true
+		for _, name := range sampleFunctions(s) {
+			if matchesAnyPrefix(name, frames) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			filtered.Sample = append(filtered.Sample, s)
+		}
+	}
+	return filtered
+}
+
+// AnalyzeDBPoolContention recognizes database/sql pool internals in block, mutex and goroutine profiles
+// and reports how much time is spent waiting for connections, how many goroutines are queued for one,
+// and which query call sites drive the waits.
+func AnalyzeDBPoolContention(profiles DBPoolProfiles, limit int, format string) (string, error) {
+	if profiles.Block == nil && profiles.Mutex == nil && profiles.Goroutine == nil {
+		return "", fmt.Errorf("at least one block, mutex or goroutine profile is required")
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	log.Printf("Analyzing database/sql pool contention (Limit %d, Format: %s)", limit, format)
+
+	result := DBPoolContentionResult{Sites: make([]DBPoolSiteStat, 0)}
+	sites := make([]DBPoolSiteStat, 0)
+
+	// Block profile: time spent in (*DB).conn waiting for a free connection
+	if profiles.Block != nil {
+		contentionsIndex, delayIndex := contentionValueIndices(profiles.Block)
+		if delayIndex == -1 {
+			return "", fmt.Errorf("could not find delay/nanoseconds sample type in the block profile")
+		}
+		delays, contentions, total := aggregatePattern(withoutFrames(profiles.Block, dbBackgroundFrames), delayIndex, contentionsIndex, dbConnWaitFrames, dbInternalPrefixes)
+		result.BlockDelay = total
+		result.BlockDelayFormatted = FormatSampleValue(total, "nanoseconds")
+		if all := profileTotal(profiles.Block, delayIndex); all > 0 {
+			result.BlockDelayShare = float64(total) / float64(all) * 100
+		}
+		for site, delay := range delays {
+			result.BlockContentions += contentions[site]
+			sites = append(sites, DBPoolSiteStat{
+				Source:         "block",
+				Site:           site,
+				Value:          delay,
+				ValueFormatted: FormatSampleValue(delay, "nanoseconds"),
+				Contentions:    contentions[site],
+			})
+		}
+	}
+
+	// Mutex profile: contention on database/sql locks
+	if profiles.Mutex != nil {
+		contentionsIndex, delayIndex := contentionValueIndices(profiles.Mutex)
+		if delayIndex == -1 {
+			return "", fmt.Errorf("could not find delay/nanoseconds sample type in the mutex profile")
+		}
+		delays, contentions, total := aggregatePattern(profiles.Mutex, delayIndex, contentionsIndex, dbMutexFrames, dbInternalPrefixes)
+		result.MutexDelay = total
+		result.MutexDelayFormatted = FormatSampleValue(total, "nanoseconds")
+		if all := profileTotal(profiles.Mutex, delayIndex); all > 0 {
+			result.MutexDelayShare = float64(total) / float64(all) * 100
+		}
+		for site, delay := range delays {
+			result.MutexContentions += contentions[site]
+			sites = append(sites, DBPoolSiteStat{
+				Source:         "mutex",
+				Site:           site,
+				Value:          delay,
+				ValueFormatted: FormatSampleValue(delay, "nanoseconds"),
+				Contentions:    contentions[site],
+			})
+		}
+	}
+
+	// Goroutine profile: goroutines queued for a connection and connection opener goroutines
+	if profiles.Goroutine != nil {
+		waiting, _, total := aggregatePattern(withoutFrames(profiles.Goroutine, dbBackgroundFrames), 0, -1, dbConnWaitFrames, dbInternalPrefixes)
+		result.WaitingGoroutines = total
+		_, _, result.ConnectionOpeners = aggregatePattern(profiles.Goroutine, 0, -1, dbOpenerFrames, nil)
+		for site, count := range waiting {
+			sites = append(sites, DBPoolSiteStat{
+				Source:         "goroutine",
+				Site:           site,
+				Value:          count,
+				ValueFormatted: FormatCount(count) + " goroutines",
+			})
+		}
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Source != sites[j].Source {
+			return sites[i].Source < sites[j].Source
+		}
+		if sites[i].Value != sites[j].Value {
+			return sites[i].Value > sites[j].Value
+		}
+		return sites[i].Site < sites[j].Site
+	})
+	perSource := make(map[string]int)
+	for _, site := range sites {
+		if perSource[site.Source] < limit {
+			result.Sites = append(result.Sites, site)
+			perSource[site.Source]++
+		}
+	}
+
+	switch {
+	case result.WaitingGoroutines > 0 || result.BlockDelay > 0:
+		result.PoolExhaustionSuspected = true
+		result.Verdict = "Pool exhaustion likely: callers are waiting in database/sql for a free connection."
+	case result.MutexDelay > 0:
+		result.Verdict = "No connection waits found, but database/sql locks are contended."
+	default:
+		result.Verdict = "No database/sql pool contention found."
+	}
+	if result.PoolExhaustionSuspected || result.MutexDelay > 0 {
+		result.Recommendations = dbPoolRecommendations
+	}
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Database Connection Pool Contention Report (database/sql)")
+		if profiles.Block != nil {
+			w.line("Connection wait delay (block): %s over %s contentions (%.2f%% of total block delay)",
+				result.BlockDelayFormatted, FormatCount(result.BlockContentions), result.BlockDelayShare)
+		}
+		if profiles.Mutex != nil {
+			w.line("database/sql lock delay (mutex): %s over %s contentions (%.2f%% of total mutex delay)",
+				result.MutexDelayFormatted, FormatCount(result.MutexContentions), result.MutexDelayShare)
+		}
+		if profiles.Goroutine != nil {
+			w.line("Goroutines waiting for a connection: %s", FormatCount(result.WaitingGoroutines))
+			w.line("Connection opener goroutines (one per sql.DB): %s", FormatCount(result.ConnectionOpeners))
+		}
+		w.line("Verdict: %s", result.Verdict)
+
+		headings := map[string]string{
+			"block":     "Query call sites waiting for connections (block delay)",
+			"goroutine": "Query call sites with goroutines waiting for connections",
+			"mutex":     "Call sites contending on database/sql locks (mutex delay)",
+		}
+		for _, source := range []string{"block", "goroutine", "mutex"} {
+			var t *table
+			if source == "goroutine" {
+				t = newTable(valueColumn("Waiting"), nameColumn("Call Site"))
+			} else {
+				t = newTable(valueColumn("Delay"), valueColumn("Waits"), nameColumn("Call Site"))
+			}
+			for _, site := range result.Sites {
+				if site.Source != source {
+					continue
+				}
+				if source == "goroutine" {
+					t.add(site.ValueFormatted, site.Site)
+				} else {
+					t.add(site.ValueFormatted, FormatCount(site.Contentions), site.Site)
+				}
+			}
+			if len(t.rows) > 0 {
+				w.heading("%s", headings[source])
+				w.table(t)
+			}
+		}
+
+		if len(result.Recommendations) > 0 {
+			w.heading("Recommendations")
+			for i, rec := range result.Recommendations {
+				w.line("%d. %s", i+1, rec)
+			}
+		}
+		return w.String(), nil
+
+	case "json":
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling database pool contention result to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
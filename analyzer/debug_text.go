@@ -0,0 +1,319 @@
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// legacyTextHeaders start the text profiles written by runtime/pprof with debug=1 (e.g. /debug/pprof/heap?debug=1).
+var legacyTextHeaders = []string{"heap profile:", "goroutine profile:", "threadcreate profile:", "--- mutex:", "--- contention:"}
+
+// goroutineDumpHeader matches the first line of a goroutine in a full goroutine dump (debug=2, or a panic
+// traceback): "goroutine 7 [chan receive, 5 minutes]:", with the "gp=... m=..." fields of GOTRACEBACK=system.
+var goroutineDumpHeader = regexp.MustCompile(`^goroutine (\d+)(?: [^\[]*)? ?\[([^\]]*)\]:$`)
+
+// debugFrameComment matches the "#\t0x51bee4\tmain.alloc+0xa4\t/app/main.go:18" lines following each stack of a
+// debug=1 profile, which symbolize its addresses; inlined frames share the address of their caller.
+var debugFrameComment = regexp.MustCompile(`^#\s+0x([0-9a-fA-F]+)\s+(\S+?)(?:\+0x[0-9a-fA-F]+)?\s+(\S.*):(\d+)$`)
+
+// IsDebugText reports whether data is a debug text dump of runtime/pprof: a debug=1 profile symbolized by
+// '#' comment lines, or a full goroutine dump (goroutine?debug=2).
+func IsDebugText(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if goroutineDumpHeader.Match(bytes.TrimRight(firstLine, "\r")) {
+		return true
+	}
+	for _, header := range legacyTextHeaders {
+		if bytes.HasPrefix(data, []byte(header)) {
+			return bytes.Contains(data, []byte("\n#\t0x"))
+		}
+	}
+	return false
+}
+
+// ParseDebugText converts a debug text dump of runtime/pprof into a symbolized profile (see IsDebugText).
+// profile.Parse reads debug=1 profiles but ignores their '#' lines, leaving bare addresses: those lines name
+// the functions, files and lines here. The runtime frames debug=1 hides at the top of stacks (e.g.
+// runtime.mallocgc) are dropped, so flat values go to the first frame it printed. Full goroutine dumps,
+// which profile.Parse rejects, are parsed by ParseGoroutineDump.
+func ParseDebugText(data []byte) (*profile.Profile, error) {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+	if goroutineDumpHeader.Match(bytes.TrimRight(firstLine, "\r")) {
+		return ParseGoroutineDump(data)
+	}
+
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse debug=1 text profile: %w", err)
+	}
+
+	type frame struct {
+		function, file string
+		line           int64
+	}
+	frames := make(map[uint64][]frame)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	var lastAddress uint64
+	for scanner.Scan() {
+		m := debugFrameComment.FindStringSubmatch(strings.TrimRight(scanner.Text(), "\r"))
+		if m == nil {
+			lastAddress = 0
+			continue
+		}
+		address, err := strconv.ParseUint(m[1], 16, 64)
+		if err != nil {
+			continue
+		}
+		line, _ := strconv.ParseInt(m[4], 10, 64)
+		// The same address appears once per stack containing it: keep the frames of its first occurrence,
+		// where consecutive lines with the same address are the inlined calls, callee first
+		if _, seen := frames[address]; seen && address != lastAddress {
+			continue
+		}
+		frames[address] = append(frames[address], frame{function: m[2], file: m[3], line: line})
+		lastAddress = address
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read debug=1 text profile: %w", err)
+	}
+
+	functions := make(map[string]*profile.Function)
+	symbolized := 0
+	for _, loc := range p.Location {
+		fs, ok := frames[loc.Address]
+		if !ok || len(loc.Line) > 0 {
+			continue
+		}
+		for _, f := range fs {
+			key := f.function + "\x00" + f.file
+			fn, ok := functions[key]
+			if !ok {
+				fn = &profile.Function{ID: uint64(len(p.Function) + 1), Name: f.function, SystemName: f.function, Filename: f.file}
+				p.Function = append(p.Function, fn)
+				functions[key] = fn
+			}
+			loc.Line = append(loc.Line, profile.Line{Function: fn, Line: f.line})
+		}
+		symbolized++
+	}
+	for _, m := range p.Mapping {
+		m.HasFunctions, m.HasFilenames, m.HasLineNumbers = true, true, true
+	}
+
+	// Like the debug=1 output, stacks start at their first non-runtime frame: the runtime frames above it
+	// (runtime.mallocgc, runtime.gopark...) are only named where another stack printed them, and
+	// runtime.goexit at the root never is
+	dropped := 0
+	for _, s := range p.Sample {
+		var kept []*profile.Location
+		for _, loc := range s.Location {
+			if len(loc.Line) == 0 {
+				continue
+			}
+			if len(kept) == 0 && strings.HasPrefix(loc.Line[len(loc.Line)-1].Function.Name, "runtime.") {
+				continue
+			}
+			kept = append(kept, loc)
+		}
+		if len(kept) > 0 {
+			dropped += len(s.Location) - len(kept)
+			s.Location = kept
+		}
+	}
+	log.Printf("Symbolized debug=1 text profile: %d of %d locations named, %d runtime frames dropped", symbolized, len(p.Location), dropped)
+	p = p.Compact()
+	if err := p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid profile built from debug=1 text profile: %w", err)
+	}
+	return p, nil
+}
+
+// goroutineFunctionName strips the arguments from a frame of a goroutine dump:
+// "runtime/pprof.(*Profile).WriteTo(0x51dcd4?, {0x64e8e8?, 0x0?}, 0x7?)" gives "runtime/pprof.(*Profile).WriteTo".
+func goroutineFunctionName(line string) string {
+	if !strings.HasSuffix(line, ")") {
+		return line
+	}
+	depth := 0
+	for i := len(line) - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// Labels of the samples of a goroutine dump (see ParseGoroutineDump).
+const (
+	GoroutineStateLabel     = "state"        // Wait reason or status printed by the runtime, e.g. "chan receive"
+	GoroutineWaitLabel      = "wait_minutes" // Numeric label, in minutes: how long the goroutines have been blocked
+	GoroutineCreatedByLabel = "created_by"   // Function that started the goroutines
+)
+
+// goroutineDumpComment marks the profiles built from goroutine dumps, whose states are known rather than
+// inferred from the stacks.
+const goroutineDumpComment = "goroutine dump (debug=2): states and wait durations reported by the runtime"
+
+// goroutineWaitMinutes matches the wait duration in the state of a goroutine dump, e.g. "5 minutes".
+var goroutineWaitMinutes = regexp.MustCompile(`^(\d+) minutes?$`)
+
+// isGoroutineDump reports whether p was built by ParseGoroutineDump.
+func isGoroutineDump(p *profile.Profile) bool {
+	for _, c := range p.Comments {
+		if c == goroutineDumpComment {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGoroutineDump converts a full goroutine dump (goroutine?debug=2, or the traceback of a crash) into a
+// goroutine profile. Goroutines with the same stack, state, wait duration and creator are counted in one
+// sample, labeled with their GoroutineStateLabel ("chan receive", "running", ...), GoroutineCreatedByLabel and,
+// when the runtime printed one (blocked for a minute or more), their GoroutineWaitLabel. Stacks end at the
+// goroutine's entry function; the "created by" frame is not included, as in goroutine profiles.
+func ParseGoroutineDump(data []byte) (*profile.Profile, error) {
+	p := &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		PeriodType:        &profile.ValueType{Type: "goroutine", Unit: "count"},
+		Period:            1,
+		DefaultSampleType: "goroutine",
+		Comments:          []string{goroutineDumpComment},
+	}
+	functions := make(map[string]*profile.Function)
+	locations := make(map[string]*profile.Location)
+	location := func(function, file string, line int64) *profile.Location {
+		key := fmt.Sprintf("%s\x00%s\x00%d", function, file, line)
+		if loc, ok := locations[key]; ok {
+			return loc
+		}
+		fn, ok := functions[function+"\x00"+file]
+		if !ok {
+			fn = &profile.Function{ID: uint64(len(p.Function) + 1), Name: function, SystemName: function, Filename: file}
+			p.Function = append(p.Function, fn)
+			functions[function+"\x00"+file] = fn
+		}
+		loc := &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn, Line: line}}}
+		p.Location = append(p.Location, loc)
+		locations[key] = loc
+		return loc
+	}
+
+	samples := make(map[string]*profile.Sample)
+	var (
+		stack     []*profile.Location
+		state     string
+		wait      int64  // Minutes, -1 when not printed
+		createdBy string // Function that started the goroutine
+		function  string // The function line waiting for its file line
+		inside    bool   // Reading the frames of a goroutine
+		created   bool   // After "created by": the next file line belongs to it
+		total     int
+	)
+	finish := func() {
+		if !inside {
+			return
+		}
+		inside = false
+		if len(stack) == 0 {
+			return
+		}
+		ids := make([]string, len(stack))
+		for i, loc := range stack {
+			ids[i] = strconv.FormatUint(loc.ID, 10)
+		}
+		key := fmt.Sprintf("%s\x00%d\x00%s\x00%s", state, wait, createdBy, strings.Join(ids, ","))
+		if s, ok := samples[key]; ok {
+			s.Value[0]++
+			return
+		}
+		s := &profile.Sample{Value: []int64{1}, Location: stack, Label: map[string][]string{GoroutineStateLabel: {state}}}
+		if createdBy != "" {
+			s.Label[GoroutineCreatedByLabel] = []string{createdBy}
+		}
+		if wait >= 0 {
+			s.NumLabel = map[string][]int64{GoroutineWaitLabel: {wait}}
+			s.NumUnit = map[string][]string{GoroutineWaitLabel: {"minutes"}}
+		}
+		samples[key] = s
+		p.Sample = append(p.Sample, s)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := goroutineDumpHeader.FindStringSubmatch(line); m != nil {
+			finish()
+			inside, created, function, stack, createdBy, wait = true, false, "", nil, "", -1
+			// "chan receive, 5 minutes, locked to thread"
+			fields := strings.Split(m[2], ", ")
+			state = fields[0]
+			for _, field := range fields[1:] {
+				if wm := goroutineWaitMinutes.FindStringSubmatch(field); wm != nil {
+					wait, _ = strconv.ParseInt(wm[1], 10, 64)
+				}
+			}
+			total++
+			continue
+		}
+		if !inside {
+			continue
+		}
+		switch {
+		case strings.TrimSpace(line) == "":
+			finish()
+		case strings.HasPrefix(line, "\t"):
+			if created || function == "" {
+				created = false
+				continue
+			}
+			// "\t/app/main.go:25 +0x1d", with " fp=... sp=... pc=..." under GOTRACEBACK=system
+			fileLine, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+			file, lineNumber := fileLine, int64(0)
+			if i := strings.LastIndex(fileLine, ":"); i > 0 {
+				if n, err := strconv.ParseInt(fileLine[i+1:], 10, 64); err == nil {
+					file, lineNumber = fileLine[:i], n
+				}
+			}
+			stack = append(stack, location(function, file, lineNumber))
+			function = ""
+		case strings.HasPrefix(line, "created by "):
+			// "created by main.main in goroutine 1"
+			createdBy, _, _ = strings.Cut(strings.TrimPrefix(line, "created by "), " in goroutine ")
+			created, function = true, ""
+		case strings.HasPrefix(line, "..."):
+			// "...additional frames elided..."
+		default:
+			function = goroutineFunctionName(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read goroutine dump: %w", err)
+	}
+	finish()
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("no goroutines with stacks found in goroutine dump")
+	}
+	log.Printf("Parsed goroutine dump: %d goroutines, %d distinct stacks, %d functions", total, len(p.Sample), len(p.Function))
+	if err := p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid profile built from goroutine dump: %w", err)
+	}
+	return p, nil
+}
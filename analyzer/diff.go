@@ -0,0 +1,251 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// DiffProfileTypes are the profile types supported by DiffProfiles.
+var DiffProfileTypes = []string{"cpu", "heap", "allocs", "goroutine", "mutex", "block"}
+
+// diffSampleTypes is the sample type DiffProfiles compares for each profile type when none is given.
+var diffSampleTypes = map[string]string{
+	"cpu":       "cpu",
+	"heap":      "inuse_space",
+	"allocs":    "alloc_space",
+	"goroutine": "goroutine",
+	"mutex":     "delay",
+	"block":     "delay",
+}
+
+// FunctionDiff is the change of one function between two profiles.
+type FunctionDiff struct {
+	DiffChange
+	OldFormatted   string `json:"oldFormatted"`
+	NewFormatted   string `json:"newFormatted"`
+	DeltaFormatted string `json:"deltaFormatted"`
+	Inlining       string `json:"inlining,omitempty"` // "old" or "new": the only build inlining the function, see OptimizationMismatch
+}
+
+// ProfileDiffResult is the per-function comparison of two profiles of the same type.
+type ProfileDiffResult struct {
+	ProfileType       string         `json:"profileType"`
+	SampleType        string         `json:"sampleType"`
+	Unit              string         `json:"unit"`
+	SortBy            string         `json:"sortBy"` // "flat" or "cum"
+	OldTotal          int64          `json:"oldTotal"`
+	NewTotal          int64          `json:"newTotal"`
+	TotalDelta        int64          `json:"totalDelta"`
+	TotalDeltaPercent float64        `json:"totalDeltaPercent"`
+	Regressed         int            `json:"regressed"`    // Functions that grew, before the top-N limit
+	Improved          int            `json:"improved"`     // Functions that shrank, before the top-N limit
+	Regressions       []FunctionDiff `json:"regressions"`  // Largest growth first
+	Improvements      []FunctionDiff `json:"improvements"` // Largest shrinkage first
+	// OptimizationMismatch is set when the profiles come from builds with different optimization flags
+	OptimizationMismatch *OptimizationMismatch `json:"optimizationMismatch,omitempty"`
+}
+
+// diffSampleIndex finds the sample type to compare in a profile: sampleType if given, else the one of the
+// profile type, so that e.g. a heap profile passed as 'mutex' is reported instead of silently compared.
+func diffSampleIndex(p *profile.Profile, profileType, sampleType string) (int, error) {
+	if sampleType != "" {
+		return sampleValueIndex(p, sampleType)
+	}
+	idx, err := sampleValueIndex(p, diffSampleTypes[profileType])
+	if err != nil {
+		return -1, fmt.Errorf("not a %s profile: %w", profileType, err)
+	}
+	return idx, nil
+}
+
+// diffSampleIndexes finds the compared sample type in both profiles (see diffSampleIndex) and checks that they
+// share its unit. profileType must already be resolved.
+func diffSampleIndexes(oldProfile, newProfile *profile.Profile, profileType, sampleType string) (oldIndex, newIndex int, err error) {
+	if _, ok := diffSampleTypes[profileType]; !ok {
+		return -1, -1, fmt.Errorf("unsupported profile type: '%s' (supported: %s)", profileType, strings.Join(DiffProfileTypes, ", "))
+	}
+	newIndex, err = diffSampleIndex(newProfile, profileType, sampleType)
+	if err != nil {
+		return -1, -1, fmt.Errorf("new profile: %w", err)
+	}
+	st := newProfile.SampleType[newIndex]
+	oldIndex, err = diffSampleIndex(oldProfile, profileType, sampleType)
+	if err != nil {
+		return -1, -1, fmt.Errorf("old profile: %w", err)
+	}
+	if oldUnit := oldProfile.SampleType[oldIndex].Unit; oldUnit != st.Unit {
+		return -1, -1, fmt.Errorf("sample type '%s' has unit '%s' in the old profile but '%s' in the new one", st.Type, oldUnit, st.Unit)
+	}
+	return oldIndex, newIndex, nil
+}
+
+// functionValues sums the value at valueIndex per function: flat values for the leaf function of each sample,
+// or cum values for every function on the stack (once per sample).
+func functionValues(p *profile.Profile, valueIndex int, sortBy string) map[string]int64 {
+	if sortBy == "cum" {
+		return stackSetValues(p, valueIndex, "function")
+	}
+	values := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		if names := sampleFunctions(s); len(names) > 0 {
+			values[names[0]] += s.Value[valueIndex]
+		}
+	}
+	return values
+}
+
+// DiffProfiles compares two profiles of the same type function by function, like 'go tool pprof -diff_base
+// oldProfile newProfile'. sortBy selects flat ("flat") or cumulative ("cum") values and sampleType the sample
+// type (default: the usual one of the profile type, e.g. 'delay' for mutex profiles). The topN functions
+// that grew and the topN that shrank the most are reported, in absolute value and percentage. Profiles of
+// builds with different optimization flags are flagged (see DetectOptimizationMismatch).
+func DiffProfiles(oldProfile, newProfile *profile.Profile, profileType, sampleType, sortBy string, topN int) (*ProfileDiffResult, error) {
+	profileType = ResolveProfileType(profileType)
+	if sortBy == "" {
+		sortBy = "flat"
+	}
+	if sortBy != "flat" && sortBy != "cum" {
+		return nil, fmt.Errorf("invalid sort_by '%s': must be 'flat' or 'cum'", sortBy)
+	}
+	oldIndex, newIndex, err := diffSampleIndexes(oldProfile, newProfile, profileType, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	st := newProfile.SampleType[newIndex]
+	log.Printf("Diffing %s profiles (SampleType: %s, SortBy: %s, Top %d)", profileType, st.Type, sortBy, topN)
+
+	oldValues := functionValues(oldProfile, oldIndex, sortBy)
+	newValues := functionValues(newProfile, newIndex, sortBy)
+	result := &ProfileDiffResult{
+		ProfileType:  profileType,
+		SampleType:   st.Type,
+		Unit:         st.Unit,
+		SortBy:       sortBy,
+		OldTotal:     sampleTotal(oldProfile, oldIndex),
+		NewTotal:     sampleTotal(newProfile, newIndex),
+		Regressions:  make([]FunctionDiff, 0),
+		Improvements: make([]FunctionDiff, 0),
+	}
+	total := NewDiffChange("", result.OldTotal, result.NewTotal)
+	result.TotalDelta, result.TotalDeltaPercent = total.Delta, total.DeltaPercent
+
+	add := func(name string, oldValue, newValue int64) {
+		change := NewDiffChange(name, oldValue, newValue)
+		diff := FunctionDiff{
+			DiffChange:     change,
+			OldFormatted:   FormatSampleValue(oldValue, st.Unit),
+			NewFormatted:   FormatSampleValue(newValue, st.Unit),
+			DeltaFormatted: formatSignedValue(change.Delta, func(v int64) string { return FormatSampleValue(v, st.Unit) }),
+		}
+		switch {
+		case change.Delta > 0:
+			result.Regressions = append(result.Regressions, diff)
+		case change.Delta < 0:
+			result.Improvements = append(result.Improvements, diff)
+		}
+	}
+	for name, newValue := range newValues {
+		add(name, oldValues[name], newValue)
+	}
+	for name, oldValue := range oldValues {
+		if _, ok := newValues[name]; !ok {
+			add(name, oldValue, 0)
+		}
+	}
+	result.Regressed, result.Improved = len(result.Regressions), len(result.Improvements)
+
+	for _, list := range [][]FunctionDiff{result.Regressions, result.Improvements} {
+		sort.Slice(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			if abs64(a.Delta) != abs64(b.Delta) {
+				return abs64(a.Delta) > abs64(b.Delta)
+			}
+			return a.Name < b.Name
+		})
+	}
+	if topN > 0 && len(result.Regressions) > topN {
+		result.Regressions = result.Regressions[:topN]
+	}
+	if topN > 0 && len(result.Improvements) > topN {
+		result.Improvements = result.Improvements[:topN]
+	}
+
+	oldBuild, newBuild := collectBuildInlining(oldProfile), collectBuildInlining(newProfile)
+	if result.OptimizationMismatch = detectOptimizationMismatch(oldBuild, newBuild); result.OptimizationMismatch != nil {
+		annotateInlining(result.Regressions, oldBuild, newBuild)
+		annotateInlining(result.Improvements, oldBuild, newBuild)
+	}
+	return result, nil
+}
+
+// sampleTotal sums the value at valueIndex over all samples.
+func sampleTotal(p *profile.Profile, valueIndex int) int64 {
+	var total int64
+	for _, s := range p.Sample {
+		if len(s.Value) > valueIndex {
+			total += s.Value[valueIndex]
+		}
+	}
+	return total
+}
+
+// abs64 returns the absolute value of v.
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// FormatProfileDiff formats a profile diff as "text", "markdown" or "json".
+func FormatProfileDiff(r *ProfileDiffResult, format string) (string, error) {
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Profile Diff: %s (%s, %s values)", r.ProfileType, r.SampleType, r.SortBy)
+		w.line("Total: %s → %s (%s, %+.2f%%)", FormatSampleValue(r.OldTotal, r.Unit), FormatSampleValue(r.NewTotal, r.Unit),
+			formatSignedValue(r.TotalDelta, func(v int64) string { return FormatSampleValue(v, r.Unit) }), r.TotalDeltaPercent)
+		w.line("Functions that grew: %d, shrank: %d", r.Regressed, r.Improved)
+		writeOptimizationMismatch(w, r.OptimizationMismatch)
+		for _, section := range []struct {
+			title string
+			diffs []FunctionDiff
+			count int
+		}{{"Regressions", r.Regressions, r.Regressed}, {"Improvements", r.Improvements, r.Improved}} {
+			w.heading("%s (showing %d of %d)", section.title, len(section.diffs), section.count)
+			if len(section.diffs) == 0 {
+				w.line("(none)")
+				continue
+			}
+			t := newTable(valueColumn("Old"), valueColumn("New"), deltaColumn("Delta"), deltaColumn("Delta%"), nameColumn("Function Name"))
+			for _, d := range section.diffs {
+				name := d.Name
+				if d.Inlining != "" {
+					name += fmt.Sprintf(" (inlined in the %s build only)", d.Inlining)
+				}
+				t.add(d.OldFormatted, d.NewFormatted, d.DeltaFormatted, fmt.Sprintf("%+.2f%%", d.DeltaPercent), name)
+			}
+			w.table(t)
+		}
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling profile diff to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
@@ -0,0 +1,176 @@
+package analyzer
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// DownsampleStats describes a downsampling pass (see Downsample).
+type DownsampleStats struct {
+	OriginalSamples int
+	KeptSamples     int
+	Threshold       int64 // Samples weighing at least this much were always kept
+	Seed            int64
+}
+
+// Downsample reduces p to about maxSamples samples for faster analysis of very large profiles, trading
+// precision for responsiveness. It uses threshold sampling on the values of valueIndex: samples weighing at
+// least the threshold are always kept, lighter ones are kept with a probability proportional to their weight
+// and scaled up accordingly, so hotspots stay exact and the value total is preserved. In diff profiles, the
+// sums of the positive and of the negative values are each preserved. The other sample values are scaled
+// by the same factor and are therefore estimates. The result only depends on seed.
+//
+// p itself is not modified; when it has no more than maxSamples samples it is returned unchanged.
+func Downsample(p *profile.Profile, valueIndex, maxSamples int, seed int64) (*profile.Profile, DownsampleStats, error) {
+	stats := DownsampleStats{OriginalSamples: len(p.Sample), KeptSamples: len(p.Sample), Seed: seed}
+	if maxSamples <= 0 {
+		return nil, stats, fmt.Errorf("invalid sample budget %d: must be positive", maxSamples)
+	}
+	if valueIndex < 0 || valueIndex >= len(p.SampleType) {
+		return nil, stats, fmt.Errorf("invalid sample value index %d", valueIndex)
+	}
+	if len(p.Sample) <= maxSamples {
+		return p, stats, nil
+	}
+
+	weight := func(s *profile.Sample) int64 {
+		if valueIndex >= len(s.Value) {
+			return 0
+		}
+		if v := s.Value[valueIndex]; v >= 0 {
+			return v
+		}
+		return -s.Value[valueIndex]
+	}
+
+	// Find the threshold t for which the expected number of kept samples, sum(min(1, w/t)), is maxSamples:
+	// the heaviest samples are kept outright while the remaining budget is spread over the rest.
+	weights := make([]int64, 0, len(p.Sample))
+	var rest int64
+	for _, s := range p.Sample {
+		if w := weight(s); w > 0 {
+			weights = append(weights, w)
+			rest += w
+		}
+	}
+	sort.Slice(weights, func(i, j int) bool { return weights[i] > weights[j] })
+	threshold := int64(1) // Keeps every non-zero sample when there are at most maxSamples of them
+	for heavy, w := range weights {
+		if heavy == maxSamples {
+			break
+		}
+		t := int64(math.Ceil(float64(rest) / float64(maxSamples-heavy)))
+		if w < t {
+			threshold = t
+			break
+		}
+		rest -= w
+	}
+	stats.Threshold = threshold
+
+	// Light samples are sampled by the magnitude of their value, but positive and negative values (of a diff
+	// profile) are corrected separately below, so that both sums are preserved and so is their total.
+	type lightSamples struct {
+		total, kept int64             // Weight of the light samples and of the kept (scaled) ones
+		heaviest    *profile.Sample   // Kept when none was sampled, so the total can still be restored
+		samples     []*profile.Sample // Kept (scaled) samples
+	}
+	var groups [2]lightSamples // Positive and negative values of valueIndex
+
+	rng := rand.New(rand.NewSource(seed))
+	downsampled := withSamples(p, make([]*profile.Sample, 0, maxSamples))
+	for _, s := range p.Sample {
+		w := weight(s)
+		if w >= threshold {
+			downsampled.Sample = append(downsampled.Sample, s)
+			continue
+		}
+		if w == 0 {
+			continue
+		}
+		g := &groups[0]
+		if s.Value[valueIndex] < 0 {
+			g = &groups[1]
+		}
+		g.total += w
+		if g.heaviest == nil || w > weight(g.heaviest) {
+			g.heaviest = s
+		}
+		if rng.Float64() >= float64(w)/float64(threshold) {
+			continue
+		}
+		// A kept light sample stands for threshold/w samples like it
+		scaled := scaleSample(s, float64(threshold)/float64(w))
+		g.kept += weight(scaled)
+		g.samples = append(g.samples, scaled)
+		downsampled.Sample = append(downsampled.Sample, scaled)
+	}
+
+	// Correct the estimates so the totals of valueIndex match the original exactly
+	for i := range groups {
+		g := &groups[i]
+		if g.total == 0 {
+			continue
+		}
+		if len(g.samples) == 0 {
+			g.samples = append(g.samples, scaleSample(g.heaviest, 1))
+			g.kept = weight(g.samples[0])
+			downsampled.Sample = append(downsampled.Sample, g.samples[0])
+		}
+		if g.kept != g.total {
+			factor := float64(g.total) / float64(g.kept)
+			var corrected int64
+			for _, s := range g.samples {
+				for i, v := range s.Value {
+					s.Value[i] = int64(math.Round(float64(v) * factor))
+				}
+				corrected += weight(s)
+			}
+			last := g.samples[len(g.samples)-1]
+			if last.Value[valueIndex] >= 0 {
+				last.Value[valueIndex] += g.total - corrected
+			} else {
+				last.Value[valueIndex] -= g.total - corrected
+			}
+		}
+	}
+
+	stats.KeptSamples = len(downsampled.Sample)
+	log.Printf("Downsampled profile from %d to %d samples (threshold %d, seed %d)", stats.OriginalSamples, stats.KeptSamples, threshold, seed)
+	return downsampled, stats, nil
+}
+
+// scaleSample returns a copy of s with its values multiplied by factor.
+func scaleSample(s *profile.Sample, factor float64) *profile.Sample {
+	scaled := &profile.Sample{Location: s.Location, Label: s.Label, NumLabel: s.NumLabel, NumUnit: s.NumUnit}
+	scaled.Value = make([]int64, len(s.Value))
+	for i, v := range s.Value {
+		scaled.Value[i] = int64(math.Round(float64(v) * factor))
+	}
+	return scaled
+}
+
+// withSamples returns a profile sharing everything but its samples with p, which is not modified.
+func withSamples(p *profile.Profile, samples []*profile.Sample) *profile.Profile {
+	return &profile.Profile{
+		SampleType:        p.SampleType,
+		DefaultSampleType: p.DefaultSampleType,
+		Sample:            samples,
+		Mapping:           p.Mapping,
+		Location:          p.Location,
+		Function:          p.Function,
+		Comments:          p.Comments,
+		DocURL:            p.DocURL,
+		DropFrames:        p.DropFrames,
+		KeepFrames:        p.KeepFrames,
+		TimeNanos:         p.TimeNanos,
+		DurationNanos:     p.DurationNanos,
+		PeriodType:        p.PeriodType,
+		Period:            p.Period,
+	}
+}
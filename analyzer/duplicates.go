@@ -0,0 +1,196 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Thresholds for the duplicate small object heuristic.
+const (
+	// duplicateMinObjects is the minimum number of same-size objects a site must produce.
+	duplicateMinObjects = 100
+	// duplicateMaxObjectSize is the largest object size (bytes) considered "small".
+	duplicateMaxObjectSize = 256
+	// duplicateDominance is the share of a site's objects that must have the same size.
+	duplicateDominance = 0.9
+)
+
+// DuplicateAllocFinding flags an allocation site producing many identical-size small objects,
+// which often indicates duplicated strings or buffers that could be interned or pooled.
+type DuplicateAllocFinding struct {
+	Site                      string `json:"site"`
+	Mechanism                 string `json:"mechanism"`
+	ObjectSize                int64  `json:"objectSize"`
+	ObjectCount               int64  `json:"objectCount"`
+	TotalBytes                int64  `json:"totalBytes"`
+	TotalBytesFormatted       string `json:"totalBytesFormatted"`
+	EstimatedSavings          int64  `json:"estimatedSavings"`
+	EstimatedSavingsFormatted string `json:"estimatedSavingsFormatted"`
+	Suggestion                string `json:"suggestion"`
+}
+
+// duplicateMechanisms maps runtime/library frames to the mechanism producing the copies.
+var duplicateMechanisms = []struct {
+	prefix     string
+	mechanism  string
+	suggestion string
+}{
+	{"runtime.concatstring", "string concatenation", "build strings once with strings.Builder, or intern repeated values (unique.Make)"},
+	{"runtime.slicebytetostring", "[]byte to string conversion", "intern repeated strings (unique.Make) or avoid converting on hot paths"},
+	{"runtime.stringtoslicebyte", "string to []byte conversion", "reuse buffers via sync.Pool or work on the string directly"},
+	{"bytes.Clone", "bytes.Clone", "share the original buffer or reuse buffers via sync.Pool"},
+	{"strings.Clone", "strings.Clone", "intern repeated strings (unique.Make) instead of cloning"},
+	{"fmt.Sprint", "fmt formatting", "cache formatted values or use strconv with a reused buffer"},
+}
+
+// isHelperFrame reports whether a function belongs to the runtime or standard helpers that
+// perform the copy, so the report can point at the calling code instead.
+func isHelperFrame(name string) bool {
+	for _, prefix := range []string{"runtime.", "bytes.", "strings.", "fmt.", "internal/", "unique."} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectDuplicateAllocations finds allocation sites that produce very many identical-size small objects.
+// spaceIndex and objectsIndex select the bytes and object count sample values. Object sizes are taken
+// from the 'bytes' numeric label Go attaches to memory samples, falling back to the average size.
+// The estimated savings assume that all but one of the objects are duplicates and are an upper bound.
+func DetectDuplicateAllocations(p *profile.Profile, spaceIndex, objectsIndex int, limit int) []DuplicateAllocFinding {
+	if spaceIndex < 0 || objectsIndex < 0 {
+		return nil
+	}
+
+	type siteSizeKey struct {
+		site string
+		size int64
+	}
+	type bucket struct {
+		objects   int64
+		bytes     int64
+		mechanism int // index into duplicateMechanisms, -1 if unknown
+	}
+	buckets := make(map[siteSizeKey]*bucket)
+	siteObjects := make(map[string]int64)
+
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= spaceIndex || len(s.Value) <= objectsIndex {
+			continue
+		}
+		objects := s.Value[objectsIndex]
+		if objects <= 0 {
+			continue
+		}
+		size := s.Value[spaceIndex] / objects
+		if sizes, ok := s.NumLabel["bytes"]; ok && len(sizes) > 0 {
+			size = sizes[0]
+		}
+
+		// Walk up the stack: remember the copying helper and attribute to the first non-helper frame
+		site := ""
+		mechanism := -1
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				if isHelperFrame(name) {
+					if mechanism == -1 {
+						for i, m := range duplicateMechanisms {
+							if strings.HasPrefix(name, m.prefix) {
+								mechanism = i
+								break
+							}
+						}
+					}
+					continue
+				}
+				site = fmt.Sprintf("%s at %s:%d", name, line.Function.Filename, line.Line)
+				break
+			}
+			if site != "" {
+				break
+			}
+		}
+		if site == "" {
+			continue
+		}
+
+		siteObjects[site] += objects
+		key := siteSizeKey{site: site, size: size}
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{mechanism: mechanism}
+			buckets[key] = b
+		}
+		b.objects += objects
+		b.bytes += s.Value[spaceIndex]
+		if b.mechanism == -1 {
+			b.mechanism = mechanism
+		}
+	}
+
+	findings := make([]DuplicateAllocFinding, 0)
+	for key, b := range buckets {
+		if key.size <= 0 || key.size > duplicateMaxObjectSize || b.objects < duplicateMinObjects {
+			continue
+		}
+		if float64(b.objects) < duplicateDominance*float64(siteObjects[key.site]) {
+			continue
+		}
+		mechanism := "identical-size small objects"
+		suggestion := "consider interning repeated values or reusing objects via sync.Pool"
+		if b.mechanism >= 0 {
+			mechanism = duplicateMechanisms[b.mechanism].mechanism
+			suggestion = duplicateMechanisms[b.mechanism].suggestion
+		}
+		savings := (b.objects - 1) * key.size
+		findings = append(findings, DuplicateAllocFinding{
+			Site:                      key.site,
+			Mechanism:                 mechanism,
+			ObjectSize:                key.size,
+			ObjectCount:               b.objects,
+			TotalBytes:                b.bytes,
+			TotalBytesFormatted:       FormatBytes(b.bytes),
+			EstimatedSavings:          savings,
+			EstimatedSavingsFormatted: FormatBytes(savings),
+			Suggestion:                suggestion,
+		})
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].EstimatedSavings != findings[j].EstimatedSavings {
+			return findings[i].EstimatedSavings > findings[j].EstimatedSavings
+		}
+		return findings[i].Site < findings[j].Site
+	})
+	if limit > 0 && len(findings) > limit {
+		findings = findings[:limit]
+	}
+	return findings
+}
+
+// writeDuplicateFindings renders the duplicate allocation findings section for text/markdown output.
+func writeDuplicateFindings(w *reportWriter, findings []DuplicateAllocFinding) {
+	if len(findings) == 0 {
+		return
+	}
+	w.heading("Findings: Duplicate Small Objects (Interning/Pooling Candidates)")
+	for _, f := range findings {
+		details := fmt.Sprintf("%s objects of %d B via %s (total %s, est. savings up to %s)",
+			FormatCount(f.ObjectCount), f.ObjectSize, f.Mechanism, f.TotalBytesFormatted, f.EstimatedSavingsFormatted)
+		if w.markdown {
+			w.line("- %s: %s. Suggestion: %s", markdownCell(f.Site, true), details, f.Suggestion)
+			continue
+		}
+		w.line("%s", f.Site)
+		w.line("  %s", details)
+		w.line("  Suggestion: %s", f.Suggestion)
+	}
+}
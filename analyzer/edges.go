@@ -0,0 +1,102 @@
+package analyzer
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// CallEdgeStat is a caller → callee edge of the call graph, weighted by the samples containing the call.
+type CallEdgeStat struct {
+	Caller string
+	Callee string
+	Weight int64 // Value of the samples containing the call (once per sample)
+	// Percent is Weight as a share of the total, CalleePercent as a share of the callee's cum value: how much
+	// of the cost of the callee comes through this caller
+	Percent       float64
+	CalleePercent float64
+}
+
+// TopCallEdges returns the topN (all for topN <= 0) heaviest caller → callee edges for the value at valueIndex,
+// and the total value. With callee set, only the edges into the functions it matches are considered, which
+// ranks the callers responsible for their cost. Recursive calls (self edges) are left out.
+func TopCallEdges(p *profile.Profile, valueIndex, topN int, callee *regexp.Regexp) ([]CallEdgeStat, int64, error) {
+	graph, err := BuildCallGraph(p, valueIndex, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	cum := make(map[string]int64, len(graph.Nodes))
+	for _, n := range graph.Nodes {
+		cum[n.Name] = n.Cum
+	}
+
+	edges := make([]CallEdgeStat, 0, topN)
+	for _, e := range graph.Edges { // Sorted by cum value
+		if topN > 0 && len(edges) == topN {
+			break
+		}
+		if e.Caller == e.Callee || (callee != nil && !callee.MatchString(e.Callee)) {
+			continue
+		}
+		stat := CallEdgeStat{Caller: e.Caller, Callee: e.Callee, Weight: e.Cum}
+		if graph.Total != 0 {
+			stat.Percent = float64(e.Cum) / float64(graph.Total) * 100
+		}
+		if c := cum[e.Callee]; c != 0 {
+			stat.CalleePercent = float64(e.Cum) / float64(c) * 100
+		}
+		edges = append(edges, stat)
+	}
+	return edges, graph.Total, nil
+}
+
+// formatCallEdges implements the "edges" format of Analyze for every profile type: the o.TopN heaviest caller →
+// callee edges, only into the functions matching the focus filter (focus_regex) when there is one.
+func formatCallEdges(p *profile.Profile, profileType string, o Options) (string, error) {
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	var callee *regexp.Regexp
+	if o.Filters.Focus != "" {
+		if callee, err = regexp.Compile(o.Filters.Focus); err != nil {
+			return "", fmt.Errorf("invalid focus regex '%s': %w", o.Filters.Focus, err)
+		}
+	}
+	st := p.SampleType[valueIndex]
+	log.Printf("Ranking call edges for %s profile (SampleType: %s, Top: %d, Callee: %q)", profileType, st.Type, o.TopN, o.Filters.Focus)
+	edges, total, err := TopCallEdges(p, valueIndex, o.TopN, callee)
+	if err != nil {
+		return "", err
+	}
+	format := DefaultValueFormat()
+
+	var b strings.Builder
+	if callee != nil {
+		b.WriteString(fmt.Sprintf("Top %d Call Edges into Functions Matching '%s' (%s profile)\n", o.TopN, o.Filters.Focus, profileType))
+	} else {
+		b.WriteString(fmt.Sprintf("Top %d Call Edges by Weight (%s profile)\n", o.TopN, profileType))
+	}
+	b.WriteString(fmt.Sprintf("Total %s (%s): %s\n", st.Type, st.Unit, FormatSampleValue(total, st.Unit)))
+	b.WriteString("Weight: value of the samples containing the call; Callee%: share of the callee's cum value coming through the caller.\n")
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(fmt.Sprintf("%-15s %-10s %-10s %s\n", "Weight", "%", "Callee%", "Caller -> Callee"))
+	b.WriteString("--------------------------------------------------\n")
+	if len(edges) == 0 {
+		b.WriteString("No call edges (stacks of a single frame, or no caller of the matching functions).\n")
+	}
+	for _, e := range edges {
+		b.WriteString(fmt.Sprintf("%-15s %-10s %-10s %s -> %s\n", FormatSampleValue(e.Weight, st.Unit),
+			format.Float(e.Percent, 2), format.Float(e.CalleePercent, 2), e.Caller, e.Callee))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,46 @@
+package analyzer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/google/pprof/profile"
+)
+
+// ExportStats describes how PrepareProfile changed a profile.
+type ExportStats struct {
+	OriginalSamples int
+	Samples         int
+	Downsampled     *DownsampleStats // nil unless the profile was downsampled
+	MinValue        *MinValueStats   // nil unless a minimum sample value was set
+}
+
+// PrepareProfile returns p as an analysis with opts would see it, for writing it back to a file: filtered
+// (Filters, Granularity, AggregationLevel), without the samples below MinSampleValue and downsampled
+// (MaxSamples, Seed, SampleType), then compacted so
+// the locations, functions and mappings no sample refers to any more are dropped. p itself is not modified.
+func PrepareProfile(p *profile.Profile, opts ...Option) (*profile.Profile, ExportStats, error) {
+	o := NewOptions(opts...)
+	stats := ExportStats{OriginalSamples: len(p.Sample)}
+	if err := o.validate(); err != nil {
+		return nil, stats, err
+	}
+	if o.SampleType != "" {
+		if _, err := sampleValueIndex(p, o.SampleType); err != nil {
+			return nil, stats, err
+		}
+	}
+	log.Printf("Preparing profile for export: %d samples (Filters: %+v, MaxSamples: %d)", len(p.Sample), o.Filters, o.MaxSamples)
+
+	prepared, preparedStats, err := o.prepare(p)
+	if err != nil {
+		return nil, stats, err
+	}
+	if len(prepared.Sample) == 0 && len(p.Sample) > 0 {
+		return nil, stats, fmt.Errorf("no samples left to export: all %d samples were removed", len(p.Sample))
+	}
+	stats.Samples = len(prepared.Sample)
+	stats.Downsampled = preparedStats.Downsampled
+	stats.MinValue = preparedStats.MinValue
+	return prepared.Compact(), stats, nil
+}
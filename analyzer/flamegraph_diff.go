@@ -0,0 +1,375 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// minMoveSimilarity is the share of a subtree's value that must be found, with the same shape, under the new
+// parent for it to be reported as moved rather than as removed and added code.
+const minMoveSimilarity = 0.5
+
+// FlameGraphMove is a subtree found under a different call path in the new flame graph.
+type FlameGraphMove struct {
+	DiffChange
+	OldPath        string  `json:"oldPath"` // e.g. "root;main.main;main.handle;main.parse"
+	NewPath        string  `json:"newPath"`
+	Similarity     float64 `json:"similarity"` // Share of the larger subtree found at the same relative paths in both (0-1)
+	OldFormatted   string  `json:"oldFormatted"`
+	NewFormatted   string  `json:"newFormatted"`
+	DeltaFormatted string  `json:"deltaFormatted"`
+}
+
+// FlameGraphPathDiff is the change of one flame graph node: of its self value when its call path exists in
+// both flame graphs, or of the value of a subtree only one of them has.
+type FlameGraphPathDiff struct {
+	DiffChange
+	Path           string `json:"path"`
+	OldFormatted   string `json:"oldFormatted"`
+	NewFormatted   string `json:"newFormatted"`
+	DeltaFormatted string `json:"deltaFormatted"`
+}
+
+// FlameGraphDiff is the structural comparison of two flame graphs.
+type FlameGraphDiff struct {
+	ProfileType       string               `json:"profileType,omitempty"`
+	SampleType        string               `json:"sampleType,omitempty"`
+	Unit              string               `json:"unit"`
+	OldTotal          int64                `json:"oldTotal"`
+	NewTotal          int64                `json:"newTotal"`
+	TotalDelta        int64                `json:"totalDelta"`
+	TotalDeltaPercent float64              `json:"totalDeltaPercent"`
+	MovedCount        int                  `json:"movedCount"`        // Moved subtrees, before the top-N limit
+	ValueChangedCount int                  `json:"valueChangedCount"` // Call paths whose self value changed, before the top-N limit
+	AddedCount        int                  `json:"addedCount"`
+	RemovedCount      int                  `json:"removedCount"`
+	Moves             []FlameGraphMove     `json:"moves"`        // Largest subtrees first
+	ValueChanges      []FlameGraphPathDiff `json:"valueChanges"` // Largest changes first
+	Added             []FlameGraphPathDiff `json:"added"`        // Subtrees only in the new flame graph and not moved there
+	Removed           []FlameGraphPathDiff `json:"removed"`      // Subtrees only in the old flame graph and not moved away
+}
+
+// treeDiffNode is a flame graph node prepared for the structural diff: children with the same name are merged,
+// as FlameGraphNodes are keyed by function ID.
+type treeDiffNode struct {
+	name        string
+	path        string
+	value, self int64
+	children    map[string]*treeDiffNode
+	ordered     []*treeDiffNode // children sorted by name, for a deterministic result
+	counterpart *treeDiffNode   // The node at the same path in the other flame graph
+	moved       bool            // Part of a moved subtree
+}
+
+// newTreeDiffNode converts a flame graph.
+func newTreeDiffNode(root *FlameGraphNode) *treeDiffNode {
+	n := &treeDiffNode{name: root.Name, path: root.Name, children: make(map[string]*treeDiffNode)}
+	n.merge(root)
+	n.finish()
+	return n
+}
+
+// merge adds the values and children of a flame graph node to n.
+func (n *treeDiffNode) merge(fg *FlameGraphNode) {
+	n.value += fg.Value
+	for _, child := range fg.Children {
+		c := n.children[child.Name]
+		if c == nil {
+			c = &treeDiffNode{name: child.Name, path: n.path + FlameGraphPathSeparator + child.Name, children: make(map[string]*treeDiffNode)}
+			n.children[child.Name] = c
+		}
+		c.merge(child)
+	}
+}
+
+// finish computes self values and orders the children of the subtree.
+func (n *treeDiffNode) finish() {
+	n.self = n.value
+	for _, c := range n.children {
+		n.self -= c.value
+		n.ordered = append(n.ordered, c)
+		c.finish()
+	}
+	sort.Slice(n.ordered, func(i, j int) bool { return n.ordered[i].name < n.ordered[j].name })
+}
+
+// walk calls visit for n and its descendants, parents first.
+func (n *treeDiffNode) walk(visit func(*treeDiffNode)) {
+	visit(n)
+	for _, c := range n.ordered {
+		c.walk(visit)
+	}
+}
+
+// markMoved marks the subtree as moved.
+func (n *treeDiffNode) markMoved() {
+	n.walk(func(d *treeDiffNode) { d.moved = true })
+}
+
+// containsMoved reports whether n or one of its descendants is part of a moved subtree.
+func (n *treeDiffNode) containsMoved() bool {
+	if n.moved {
+		return true
+	}
+	for _, c := range n.ordered {
+		if c.containsMoved() {
+			return true
+		}
+	}
+	return false
+}
+
+// remaining is the value of the subtree that is not part of a moved subtree.
+func (n *treeDiffNode) remaining() int64 {
+	if n.moved {
+		return 0
+	}
+	v := n.self
+	for _, c := range n.ordered {
+		v += c.remaining()
+	}
+	return v
+}
+
+// pairTreeDiffNodes links the nodes of both flame graphs that have the same call path.
+func pairTreeDiffNodes(a, b *treeDiffNode) {
+	a.counterpart, b.counterpart = b, a
+	for name, c := range a.children {
+		if other := b.children[name]; other != nil {
+			pairTreeDiffNodes(c, other)
+		}
+	}
+}
+
+// sharedValue is the value two subtrees have in common: the smaller self value of their roots plus the shared
+// value of their children with the same names.
+func sharedValue(a, b *treeDiffNode) int64 {
+	shared := min(max(a.self, 0), max(b.self, 0))
+	for name, c := range a.children {
+		if other := b.children[name]; other != nil {
+			shared += sharedValue(c, other)
+		}
+	}
+	return shared
+}
+
+// DiffFlameGraphTrees compares the structure of two flame graphs. Subtrees whose call path only exists in one
+// of them are matched, by function name and shape, to find those that moved to a different parent (e.g. code
+// extracted into a helper or called from another layer after a refactor); they are reported separately from
+// pure value changes, the self value changes of call paths present in both. Subtrees left unmatched are
+// reported as added or removed. unit formats the values; topN limits each list (all entries if <= 0).
+func DiffFlameGraphTrees(oldRoot, newRoot *FlameGraphNode, unit string, topN int) (*FlameGraphDiff, error) {
+	if oldRoot == nil || newRoot == nil {
+		return nil, fmt.Errorf("flame graph is empty")
+	}
+	oldTree, newTree := newTreeDiffNode(oldRoot), newTreeDiffNode(newRoot)
+	pairTreeDiffNodes(oldTree, newTree)
+
+	result := &FlameGraphDiff{
+		Unit:         unit,
+		OldTotal:     oldTree.value,
+		NewTotal:     newTree.value,
+		Moves:        make([]FlameGraphMove, 0),
+		ValueChanges: make([]FlameGraphPathDiff, 0),
+		Added:        make([]FlameGraphPathDiff, 0),
+		Removed:      make([]FlameGraphPathDiff, 0),
+	}
+	total := NewDiffChange("", result.OldTotal, result.NewTotal)
+	result.TotalDelta, result.TotalDeltaPercent = total.Delta, total.DeltaPercent
+	formatValue := func(v int64) string { return FormatSampleValue(v, unit) }
+
+	// 只在一侧存在的调用路径：按函数名配对，作为移动候选
+	added := make(map[string][]*treeDiffNode)
+	newTree.walk(func(n *treeDiffNode) {
+		if n.counterpart == nil {
+			added[n.name] = append(added[n.name], n)
+		}
+	})
+	type moveCandidate struct {
+		from, to   *treeDiffNode
+		shared     int64
+		similarity float64
+	}
+	candidates := make([]moveCandidate, 0)
+	oldTree.walk(func(n *treeDiffNode) {
+		if n.counterpart != nil {
+			return
+		}
+		for _, to := range added[n.name] {
+			shared := sharedValue(n, to)
+			if shared <= 0 {
+				continue
+			}
+			similarity := float64(shared) / float64(max(n.value, to.value))
+			if similarity >= minMoveSimilarity {
+				candidates = append(candidates, moveCandidate{from: n, to: to, shared: shared, similarity: similarity})
+			}
+		}
+	})
+	// 共享值最大的候选优先，因此整棵移动的子树先于其内部的节点被选中
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.shared != b.shared {
+			return a.shared > b.shared
+		}
+		return a.similarity > b.similarity
+	})
+	for _, c := range candidates {
+		if c.from.containsMoved() || c.to.containsMoved() {
+			continue
+		}
+		c.from.markMoved()
+		c.to.markMoved()
+		change := NewDiffChange(c.from.name, c.from.value, c.to.value)
+		result.Moves = append(result.Moves, FlameGraphMove{
+			DiffChange:     change,
+			OldPath:        c.from.path,
+			NewPath:        c.to.path,
+			Similarity:     c.similarity,
+			OldFormatted:   formatValue(c.from.value),
+			NewFormatted:   formatValue(c.to.value),
+			DeltaFormatted: formatSignedValue(change.Delta, formatValue),
+		})
+	}
+
+	pathDiff := func(n *treeDiffNode, oldValue, newValue int64) FlameGraphPathDiff {
+		change := NewDiffChange(n.name, oldValue, newValue)
+		return FlameGraphPathDiff{
+			DiffChange:     change,
+			Path:           n.path,
+			OldFormatted:   formatValue(oldValue),
+			NewFormatted:   formatValue(newValue),
+			DeltaFormatted: formatSignedValue(change.Delta, formatValue),
+		}
+	}
+	oldTree.walk(func(n *treeDiffNode) {
+		if n.counterpart != nil && n.self != n.counterpart.self {
+			result.ValueChanges = append(result.ValueChanges, pathDiff(n, n.self, n.counterpart.self))
+		}
+	})
+	// 未被移动解释的新增/删除子树：只报告最外层的节点，其值不含移出的部分
+	unmatched := func(root *treeDiffNode, report func(n *treeDiffNode, value int64)) {
+		var visit func(n *treeDiffNode)
+		visit = func(n *treeDiffNode) {
+			if n.counterpart == nil && !n.moved {
+				if v := n.remaining(); v != 0 {
+					report(n, v)
+				}
+				return
+			}
+			for _, c := range n.ordered {
+				visit(c)
+			}
+		}
+		visit(root)
+	}
+	unmatched(oldTree, func(n *treeDiffNode, v int64) { result.Removed = append(result.Removed, pathDiff(n, v, 0)) })
+	unmatched(newTree, func(n *treeDiffNode, v int64) { result.Added = append(result.Added, pathDiff(n, 0, v)) })
+
+	result.MovedCount, result.ValueChangedCount = len(result.Moves), len(result.ValueChanges)
+	result.AddedCount, result.RemovedCount = len(result.Added), len(result.Removed)
+	sort.SliceStable(result.Moves, func(i, j int) bool {
+		a, b := result.Moves[i], result.Moves[j]
+		return max(a.OldValue, a.NewValue) > max(b.OldValue, b.NewValue)
+	})
+	for _, list := range [][]FlameGraphPathDiff{result.ValueChanges, result.Added, result.Removed} {
+		sort.SliceStable(list, func(i, j int) bool { return abs64(list[i].Delta) > abs64(list[j].Delta) })
+	}
+	if topN > 0 {
+		result.Moves = result.Moves[:min(topN, len(result.Moves))]
+		result.ValueChanges = result.ValueChanges[:min(topN, len(result.ValueChanges))]
+		result.Added = result.Added[:min(topN, len(result.Added))]
+		result.Removed = result.Removed[:min(topN, len(result.Removed))]
+	}
+	return result, nil
+}
+
+// DiffFlameGraphs builds the flame graphs of two profiles of the same type and compares their structure with
+// DiffFlameGraphTrees. profileType and sampleType select the compared values as in DiffProfiles.
+func DiffFlameGraphs(oldProfile, newProfile *profile.Profile, profileType, sampleType string, topN int) (*FlameGraphDiff, error) {
+	profileType = ResolveProfileType(profileType)
+	oldIndex, newIndex, err := diffSampleIndexes(oldProfile, newProfile, profileType, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	st := newProfile.SampleType[newIndex]
+	log.Printf("Diffing %s flame graphs (SampleType: %s, Top %d)", profileType, st.Type, topN)
+
+	oldRoot, err := BuildFlameGraphTree(oldProfile, oldIndex)
+	if err != nil {
+		return nil, fmt.Errorf("old profile: %w", err)
+	}
+	newRoot, err := BuildFlameGraphTree(newProfile, newIndex)
+	if err != nil {
+		return nil, fmt.Errorf("new profile: %w", err)
+	}
+	result, err := DiffFlameGraphTrees(oldRoot, newRoot, st.Unit, topN)
+	if err != nil {
+		return nil, err
+	}
+	result.ProfileType, result.SampleType = profileType, st.Type
+	return result, nil
+}
+
+// FormatFlameGraphDiff formats a structural flame graph diff as "text", "markdown" or "json".
+func FormatFlameGraphDiff(r *FlameGraphDiff, format string) (string, error) {
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Flame Graph Structural Diff: %s (%s)", r.ProfileType, r.SampleType)
+		w.line("Total: %s → %s (%s, %+.2f%%)", FormatSampleValue(r.OldTotal, r.Unit), FormatSampleValue(r.NewTotal, r.Unit),
+			formatSignedValue(r.TotalDelta, func(v int64) string { return FormatSampleValue(v, r.Unit) }), r.TotalDeltaPercent)
+		w.line("Moved subtrees: %d, value changes: %d, added subtrees: %d, removed subtrees: %d",
+			r.MovedCount, r.ValueChangedCount, r.AddedCount, r.RemovedCount)
+
+		w.heading("Moved Subtrees (call path changed; showing %d of %d)", len(r.Moves), r.MovedCount)
+		if len(r.Moves) == 0 {
+			w.line("(none)")
+		} else {
+			t := newTable(valueColumn("Old"), valueColumn("New"), deltaColumn("Delta"), valueColumn("Similar"), nameColumn("Function Name"))
+			for _, m := range r.Moves {
+				t.add(m.OldFormatted, m.NewFormatted, m.DeltaFormatted, fmt.Sprintf("%.0f%%", m.Similarity*100), m.Name)
+				t.note("      from: " + m.OldPath)
+				t.note("      to:   " + m.NewPath)
+			}
+			w.table(t)
+		}
+
+		for _, section := range []struct {
+			title string
+			diffs []FlameGraphPathDiff
+			count int
+		}{
+			{"Value Changes (self values at unchanged call paths", r.ValueChanges, r.ValueChangedCount},
+			{"Added Subtrees (not moved from elsewhere", r.Added, r.AddedCount},
+			{"Removed Subtrees (not moved elsewhere", r.Removed, r.RemovedCount},
+		} {
+			w.heading("%s; showing %d of %d)", section.title, len(section.diffs), section.count)
+			if len(section.diffs) == 0 {
+				w.line("(none)")
+				continue
+			}
+			t := newTable(valueColumn("Old"), valueColumn("New"), deltaColumn("Delta"), deltaColumn("Delta%"), nameColumn("Call Path"))
+			for _, d := range section.diffs {
+				t.add(d.OldFormatted, d.NewFormatted, d.DeltaFormatted, fmt.Sprintf("%+.2f%%", d.DeltaPercent), d.Path)
+			}
+			w.table(t)
+		}
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling flame graph diff to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
@@ -0,0 +1,171 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// DiffFlameGraphNode is a node of a differential flame graph: the call paths of two profiles merged into one
+// tree, each node carrying its value in both. Value is the new value, as d3-flame-graph's differential mode
+// expects (it colors frames by Delta); frames only in the base profile have a Value of 0 and are hidden by such
+// renderers, which can swap the profiles for the "negated" view, like flamegraph.pl --negate.
+type DiffFlameGraphNode struct {
+	Name           string                `json:"name"`
+	Value          int64                 `json:"value"`
+	BaseValue      int64                 `json:"baseValue"`
+	NewValue       int64                 `json:"newValue"`
+	Delta          int64                 `json:"delta"`                  // NewValue - BaseValue
+	SelfDelta      int64                 `json:"selfDelta"`              // Change of the node's self value, excluding its children
+	DeltaPercent   float64               `json:"deltaPercent,omitempty"` // Delta relative to BaseValue; ±100 for frames only in one profile
+	ValueFormatted string                `json:"valueFormatted,omitempty"`
+	DeltaFormatted string                `json:"deltaFormatted,omitempty"`
+	Children       []*DiffFlameGraphNode `json:"children,omitempty"` // Sorted by the larger of both values, descending
+
+	baseSelf, newSelf int64
+	children          map[string]*DiffFlameGraphNode // Keyed by function name while the tree is built
+}
+
+// DiffFlameGraphResult is a differential flame graph with the totals it was built from.
+type DiffFlameGraphResult struct {
+	ProfileType string              `json:"profileType"`
+	SampleType  string              `json:"sampleType"`
+	Unit        string              `json:"unit"`
+	BaseTotal   int64               `json:"baseTotal"` // After normalization, when Normalized is set
+	NewTotal    int64               `json:"newTotal"`
+	Normalized  bool                `json:"normalized,omitempty"` // Base values were scaled to the new total
+	Root        *DiffFlameGraphNode `json:"root"`
+}
+
+// BuildDiffFlameGraphTree merges the flame graphs (see BuildFlameGraphTree) of a base and a new profile into
+// one differential tree. Nodes are matched by function name along the call path, so the same path through
+// different function IDs (e.g. after a rebuild) lines up. baseScale multiplies the base values, e.g. to
+// normalize the base profile to the new total; 1 keeps them unchanged.
+func BuildDiffFlameGraphTree(baseProfile, newProfile *profile.Profile, baseIndex, newIndex int, baseScale float64) (*DiffFlameGraphNode, error) {
+	baseRoot, err := BuildFlameGraphTree(baseProfile, baseIndex)
+	if err != nil {
+		return nil, fmt.Errorf("base profile: %w", err)
+	}
+	newRoot, err := BuildFlameGraphTree(newProfile, newIndex)
+	if err != nil {
+		return nil, fmt.Errorf("new profile: %w", err)
+	}
+	unit := newProfile.SampleType[newIndex].Unit
+
+	root := &DiffFlameGraphNode{Name: newRoot.Name}
+	root.add(baseRoot, false, baseScale)
+	root.add(newRoot, true, 1)
+	root.finish(unit)
+	return root, nil
+}
+
+// add merges a flame graph node and its descendants into n, as base or new values.
+func (n *DiffFlameGraphNode) add(fg *FlameGraphNode, isNew bool, scale float64) {
+	value, self := fg.Value, fg.Value
+	for _, child := range fg.Children {
+		self -= child.Value
+	}
+	if scale != 1 {
+		value, self = int64(math.Round(float64(value)*scale)), int64(math.Round(float64(self)*scale))
+	}
+	if isNew {
+		n.NewValue += value
+		n.newSelf += self
+	} else {
+		n.BaseValue += value
+		n.baseSelf += self
+	}
+	for _, child := range fg.Children {
+		if n.children == nil {
+			n.children = make(map[string]*DiffFlameGraphNode)
+		}
+		c := n.children[child.Name]
+		if c == nil {
+			c = &DiffFlameGraphNode{Name: child.Name}
+			n.children[child.Name] = c
+		}
+		c.add(child, isNew, scale)
+	}
+}
+
+// finish computes the derived fields of the subtree and orders the children.
+func (n *DiffFlameGraphNode) finish(unit string) {
+	n.Value = n.NewValue
+	n.Delta = n.NewValue - n.BaseValue
+	n.SelfDelta = n.newSelf - n.baseSelf
+	switch {
+	case n.BaseValue != 0:
+		n.DeltaPercent = math.Round(float64(n.Delta)/float64(n.BaseValue)*10000) / 100
+	case n.NewValue != 0:
+		n.DeltaPercent = 100
+	}
+	n.ValueFormatted = FormatSampleValue(n.NewValue, unit)
+	n.DeltaFormatted = formatSignedValue(n.Delta, func(v int64) string { return FormatSampleValue(v, unit) })
+	for _, c := range n.children {
+		c.finish(unit)
+		n.Children = append(n.Children, c)
+	}
+	n.children = nil
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := max(n.Children[i].BaseValue, n.Children[i].NewValue), max(n.Children[j].BaseValue, n.Children[j].NewValue)
+		if a != b {
+			return a > b
+		}
+		return n.Children[i].Name < n.Children[j].Name
+	})
+}
+
+// DiffFlameGraph builds the differential flame graph of two profiles of the same type. profileType and
+// sampleType select the compared values as in DiffProfiles. With normalize, the base values are scaled so both
+// profiles have the same total, which compares the shape of profiles captured for different durations or
+// under different load.
+func DiffFlameGraph(baseProfile, newProfile *profile.Profile, profileType, sampleType string, normalize bool) (*DiffFlameGraphResult, error) {
+	profileType = ResolveProfileType(profileType)
+	baseIndex, newIndex, err := diffSampleIndexes(baseProfile, newProfile, profileType, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	st := newProfile.SampleType[newIndex]
+	log.Printf("Building differential %s flame graph (SampleType: %s, Normalize: %t)", profileType, st.Type, normalize)
+
+	scale := 1.0
+	if normalize {
+		baseTotal, newTotal := sampleTotal(baseProfile, baseIndex), sampleTotal(newProfile, newIndex)
+		if baseTotal != 0 && newTotal != 0 {
+			scale = float64(newTotal) / float64(baseTotal)
+		}
+	}
+	root, err := BuildDiffFlameGraphTree(baseProfile, newProfile, baseIndex, newIndex, scale)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffFlameGraphResult{
+		ProfileType: profileType,
+		SampleType:  st.Type,
+		Unit:        st.Unit,
+		BaseTotal:   root.BaseValue,
+		NewTotal:    root.NewValue,
+		Normalized:  scale != 1,
+		Root:        root,
+	}, nil
+}
+
+// FormatDiffFlameGraphJSON builds a differential flame graph (see DiffFlameGraph) and serializes it as JSON.
+func FormatDiffFlameGraphJSON(baseProfile, newProfile *profile.Profile, profileType, sampleType string, normalize bool) (string, error) {
+	result, err := DiffFlameGraph(baseProfile, newProfile, profileType, sampleType, normalize)
+	if err != nil {
+		return "", err
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error marshaling differential flame graph to JSON: %v", err)
+		errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+		errJsonBytes, _ := json.Marshal(errorResult)
+		return string(errJsonBytes), nil
+	}
+	return string(jsonBytes), nil
+}
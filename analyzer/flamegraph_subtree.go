@@ -0,0 +1,72 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FlameGraphPathSeparator separates frame names in a flame graph node path, e.g. "root;main.main;main.work".
+const FlameGraphPathSeparator = ";"
+
+// FindFlameGraphSubtree returns the node of the flame graph tree addressed by path. The leading "root"
+// element may be omitted. With maxDepth > 0, descendants deeper than maxDepth levels below the node are
+// cut off and the number of omitted children is reported in HiddenChildren, so clients can drill down
+// progressively. The returned tree is a copy; root is not modified.
+func FindFlameGraphSubtree(root *FlameGraphNode, path string, maxDepth int) (*FlameGraphNode, error) {
+	if root == nil {
+		return nil, fmt.Errorf("flame graph is empty")
+	}
+	elements := make([]string, 0)
+	for _, element := range strings.Split(path, FlameGraphPathSeparator) {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	if len(elements) > 0 && elements[0] == root.Name {
+		elements = elements[1:]
+	}
+
+	node := root
+	walked := []string{root.Name}
+	for _, element := range elements {
+		var next *FlameGraphNode
+		for _, child := range node.Children {
+			if child.Name == element {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			available := make([]string, 0, 10)
+			for i, child := range node.Children {
+				if i == 10 {
+					available = append(available, fmt.Sprintf("... %d more", len(node.Children)-10))
+					break
+				}
+				available = append(available, child.Name)
+			}
+			return nil, fmt.Errorf("node '%s' not found under '%s' (children: %s)",
+				element, strings.Join(walked, FlameGraphPathSeparator), strings.Join(available, ", "))
+		}
+		node = next
+		walked = append(walked, element)
+	}
+	if maxDepth <= 0 {
+		maxDepth = -1
+	}
+	return copyFlameGraphNode(node, maxDepth), nil
+}
+
+// copyFlameGraphNode copies a node and its descendants up to depth levels below it (unlimited if depth < 0).
+func copyFlameGraphNode(node *FlameGraphNode, depth int) *FlameGraphNode {
+	copied := *node
+	copied.Children = nil
+	if depth == 0 {
+		copied.HiddenChildren = len(node.Children)
+		return &copied
+	}
+	for _, child := range node.Children {
+		copied.Children = append(copied.Children, copyFlameGraphNode(child, depth-1))
+	}
+	return &copied
+}
@@ -0,0 +1,216 @@
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// isFoldableProfileType reports whether the "folded" format supports a (resolved) profile type.
+func isFoldableProfileType(profileType string) bool {
+	switch profileType {
+	case "cpu", "heap", "allocs", "mutex", "block":
+		return true
+	}
+	return false
+}
+
+// FormatFoldedStacks renders the samples of a profile as collapsed stacks in the format of Brendan Gregg's
+// stackcollapse scripts: one line per distinct stack, frames root-first separated by ';', followed by a space
+// and the summed sample value at valueIndex ("main.main;main.handle;main.parse 1230000"). The output can be fed
+// to flamegraph.pl and other tools reading folded stacks. Samples with a zero or negative value are skipped,
+// and lines are sorted by stack for a deterministic output.
+func FormatFoldedStacks(p *profile.Profile, valueIndex int) (string, error) {
+	if valueIndex < 0 || valueIndex >= len(p.SampleType) {
+		return "", fmt.Errorf("invalid value index %d for profile with %d sample types", valueIndex, len(p.SampleType))
+	}
+
+	values := make(map[string]int64)
+	frames := make([]string, 0, 64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex || s.Value[valueIndex] <= 0 {
+			continue
+		}
+		// pprof stores locations leaf-first and inlined lines callee-first; folded stacks are root-first
+		frames = frames[:0]
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			loc := s.Location[i]
+			if len(loc.Line) == 0 {
+				frames = append(frames, fmt.Sprintf("0x%x", loc.Address))
+				continue
+			}
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				if fn := loc.Line[j].Function; fn != nil && fn.Name != "" {
+					frames = append(frames, foldedFrame(fn.Name))
+				} else {
+					frames = append(frames, fmt.Sprintf("0x%x", loc.Address))
+				}
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		values[strings.Join(frames, ";")] += s.Value[valueIndex]
+	}
+
+	stacks := make([]string, 0, len(values))
+	for stack := range values {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	var b strings.Builder
+	for _, stack := range stacks {
+		b.WriteString(stack)
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatInt(values[stack], 10))
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// foldedFrame 替换函数名中会破坏 folded 格式的字符：';' 分隔栈帧，换行分隔栈
+var foldedFrame = strings.NewReplacer(";", ":", "\n", " ").Replace
+
+// formatFolded implements the "folded" format of Analyze for cpu, heap, allocs, mutex and block profiles.
+func formatFolded(p *profile.Profile, profileType string, o Options) (string, error) {
+	if !isFoldableProfileType(profileType) {
+		return "", fmt.Errorf("the folded format supports cpu, heap, allocs, mutex and block profiles, not '%s'", profileType)
+	}
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	log.Printf("Folding stacks of %s profile (SampleType: %s)", profileType, p.SampleType[valueIndex].Type)
+	return FormatFoldedStacks(p, valueIndex)
+}
+
+// foldedLine matches a line of folded stacks: the stack, whitespace and the value. flamegraph.pl also accepts
+// decimal values, which some eBPF tools print.
+var foldedLine = regexp.MustCompile(`^(\S.*?)\s+(\d+(?:\.\d+)?)$`)
+
+// foldedSniffBytes is how much of a file IsFoldedStacks looks at.
+const foldedSniffBytes = 64 << 10
+
+// IsFoldedStacks reports whether data looks like folded stacks (see FormatFoldedStacks), as written by
+// stackcollapse scripts, async-profiler's collapsed output or eBPF tools such as 'profile -f': every line,
+// ignoring empty and '#' lines, is a stack followed by a value, and at least one stack has several frames,
+// which tells it from other text formats ending lines in numbers.
+func IsFoldedStacks(data []byte) bool {
+	truncated := len(data) > foldedSniffBytes
+	if truncated {
+		data = data[:foldedSniffBytes]
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false // Binary, e.g. a gzipped or raw pprof profile
+	}
+	lines := strings.Split(string(data), "\n")
+	if truncated {
+		lines = lines[:len(lines)-1] // Cut at the sniff limit
+	}
+	sawStack, sawFrames := false, false
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := foldedLine.FindStringSubmatch(line)
+		if m == nil {
+			return false
+		}
+		sawStack = true
+		sawFrames = sawFrames || strings.Contains(m[1], ";")
+	}
+	return sawStack && sawFrames
+}
+
+// ParseFoldedStacks converts folded stacks (root first, frames separated by ';', then the value) into a
+// pprof profile with one "samples" count column, so the analyses and flame graphs work on stacks produced by
+// tools that only write this format. Identical stacks are merged; decimal values are rounded. Frame names
+// are kept as written, including annotations such as flamegraph.pl's "_[k]" kernel suffix.
+func ParseFoldedStacks(data []byte) (*profile.Profile, error) {
+	p := &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		DefaultSampleType: "samples",
+	}
+	locations := make(map[string]*profile.Location)
+	location := func(name string) *profile.Location {
+		if loc, ok := locations[name]; ok {
+			return loc
+		}
+		fn := &profile.Function{ID: uint64(len(p.Function) + 1), Name: name, SystemName: name}
+		p.Function = append(p.Function, fn)
+		loc := &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn}}}
+		p.Location = append(p.Location, loc)
+		locations[name] = loc
+		return loc
+	}
+
+	samples := make(map[string]*profile.Sample)
+	lineNo, skipped := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20) // Deep stacks make long lines
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := foldedLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d is not a folded stack ('frame;frame;... value'): %q", lineNo, line)
+		}
+		value, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value %q: %w", lineNo, m[2], err)
+		}
+		count := int64(math.Round(value))
+		if count <= 0 {
+			skipped++
+			continue
+		}
+		if s, ok := samples[m[1]]; ok {
+			s.Value[0] += count
+			continue
+		}
+		frames := strings.Split(m[1], ";")
+		s := &profile.Sample{Value: []int64{count}, Location: make([]*profile.Location, 0, len(frames))}
+		// Folded stacks are root first; pprof stores locations leaf first
+		for i := len(frames) - 1; i >= 0; i-- {
+			if frames[i] == "" {
+				continue
+			}
+			s.Location = append(s.Location, location(frames[i]))
+		}
+		if len(s.Location) == 0 {
+			skipped++
+			continue
+		}
+		samples[m[1]] = s
+		p.Sample = append(p.Sample, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read folded stacks: %w", err)
+	}
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("no stacks with a positive value found in folded stacks")
+	}
+	log.Printf("Parsed folded stacks: %d distinct stacks, %d functions (%d lines skipped)", len(p.Sample), len(p.Function), skipped)
+	if err := p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid profile built from folded stacks: %w", err)
+	}
+	return p, nil
+}
@@ -2,44 +2,303 @@ package analyzer
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-// FormatSampleValue 将样本值 (如 CPU 时间或计数) 转换为人类可读的字符串。
-// 注意：已导出 (首字母大写)。
-func FormatSampleValue(value int64, unit string) string {
+// ByteUnits 选择字节数的换算方式。
+type ByteUnits string
+
+const (
+	// ByteUnitsJEDEC 按 1024 换算并使用 KB、MB 等单位名 (默认，与 'go tool pprof' 相同)。
+	ByteUnitsJEDEC ByteUnits = "jedec"
+	// ByteUnitsIEC 按 1024 换算并使用 KiB、MiB 等单位名。
+	ByteUnitsIEC ByteUnits = "iec"
+	// ByteUnitsSI 按 1000 换算并使用 kB、MB 等单位名。
+	ByteUnitsSI ByteUnits = "si"
+)
+
+// NumberLocale 定义数字的小数点和千位分隔符。
+type NumberLocale struct {
+	Name    string
+	Decimal string
+	Group   string // 为空时不分组
+}
+
+// numberLocales 是支持的数字区域设置，"c" 为默认值 (不分组，与此前的输出相同)。
+var numberLocales = map[string]NumberLocale{
+	"c":  {Name: "c", Decimal: "."},
+	"en": {Name: "en", Decimal: ".", Group: ","},
+	"de": {Name: "de", Decimal: ",", Group: "."},
+	"fr": {Name: "fr", Decimal: ",", Group: "\u202f"}, // 窄不换行空格
+	"ch": {Name: "ch", Decimal: ".", Group: "'"},
+}
+
+// LookupNumberLocale 返回指定名称的数字区域设置 (不区分大小写，接受 "de_DE.UTF-8" 这类形式)；空名称返回 "c"。
+func LookupNumberLocale(name string) (NumberLocale, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if i := strings.IndexAny(key, "_-."); i > 0 {
+		key = key[:i]
+	}
+	switch key {
+	case "", "posix":
+		key = "c"
+	}
+	locale, ok := numberLocales[key]
+	if !ok {
+		return NumberLocale{}, fmt.Errorf("unsupported number locale '%s' (supported: c, en, de, fr, ch)", name)
+	}
+	return locale, nil
+}
+
+// ParseByteUnits 解析字节单位名称；空名称返回默认的 ByteUnitsJEDEC。
+func ParseByteUnits(name string) (ByteUnits, error) {
+	switch units := ByteUnits(strings.ToLower(strings.TrimSpace(name))); units {
+	case "":
+		return ByteUnitsJEDEC, nil
+	case ByteUnitsJEDEC, ByteUnitsIEC, ByteUnitsSI:
+		return units, nil
+	default:
+		return "", fmt.Errorf("unsupported byte units '%s' (supported: jedec, iec, si)", name)
+	}
+}
+
+// CountStyle 决定对象数、goroutine 数、样本数等计数的显示方式。
+type CountStyle string
+
+const (
+	// CountsGrouped 使用区域设置的千位分隔符 (默认)；区域设置不分组时 (例如 "c") 使用 ","，例如 123,456,789。
+	CountsGrouped CountStyle = "grouped"
+	// CountsPlain 不分组，例如 123456789。
+	CountsPlain CountStyle = "plain"
+	// CountsHuman 将不小于 10000 的计数缩写为 K、M、G 等，例如 123.5M；较小的计数与 CountsGrouped 相同。
+	CountsHuman CountStyle = "human"
+)
+
+// ParseCountStyle 解析计数样式名称；空名称返回默认的 CountsGrouped。
+func ParseCountStyle(name string) (CountStyle, error) {
+	switch style := CountStyle(strings.ToLower(strings.TrimSpace(name))); style {
+	case "":
+		return CountsGrouped, nil
+	case CountsGrouped, CountsPlain, CountsHuman:
+		return style, nil
+	default:
+		return "", fmt.Errorf("unsupported count style '%s' (supported: grouped, plain, human)", name)
+	}
+}
+
+// ValueColumnWidth 是文本表格中数值列的宽度。
+const ValueColumnWidth = 15
+
+// ValueFormat 决定样本值在所有分析器的文本和格式化字段中的显示方式；零值即默认格式 (jedec, "c")。
+type ValueFormat struct {
+	ByteUnits ByteUnits
+	Locale    NumberLocale
+	Counts    CountStyle
+	// 为 true 时，文本表格中的数值列在 ValueColumnWidth 内右对齐 (见 valueCell)，便于纵向比较
+	AlignRight bool
+	// 为 true 时，"text" 报告使用 ANSI 颜色：标题和表头加粗，增长显示为红色、减少为绿色 (见 reportWriter)
+	Color bool
+}
+
+// defaultValueFormat 保存进程范围的 *ValueFormat，由 SetDefaultValueFormat 设置。
+var defaultValueFormat atomic.Value
+
+// DefaultValueFormat 返回 FormatBytes、FormatSampleValue 及各分析器使用的格式。
+func DefaultValueFormat() ValueFormat {
+	if f, ok := defaultValueFormat.Load().(ValueFormat); ok {
+		return f
+	}
+	return ValueFormat{ByteUnits: ByteUnitsJEDEC, Locale: numberLocales["c"], Counts: CountsGrouped}
+}
+
+// SetDefaultValueFormat 设置所有分析器的数值格式，通常在启动时根据配置调用一次。
+func SetDefaultValueFormat(f ValueFormat) {
+	if f.ByteUnits == "" {
+		f.ByteUnits = ByteUnitsJEDEC
+	}
+	if f.Locale.Decimal == "" {
+		f.Locale = numberLocales["c"]
+	}
+	if f.Counts == "" {
+		f.Counts = CountsGrouped
+	}
+	defaultValueFormat.Store(f)
+}
+
+// Float 按区域设置格式化浮点数，保留 prec 位小数。
+func (f ValueFormat) Float(v float64, prec int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', prec, 64)
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	b.WriteString(f.group(intPart))
+	if fracPart != "" {
+		if f.Locale.Decimal == "" {
+			b.WriteByte('.') // 零值 ValueFormat 与默认的 "c" 区域设置相同
+		} else {
+			b.WriteString(f.Locale.Decimal)
+		}
+		b.WriteString(fracPart)
+	}
+	return b.String()
+}
+
+// Int 按区域设置格式化整数。
+func (f ValueFormat) Int(v int64) string {
+	s := strconv.FormatInt(v, 10)
+	if v < 0 {
+		return "-" + f.group(s[1:])
+	}
+	return f.group(s)
+}
+
+// countUnits 是 CountsHuman 使用的缩写单位 (按 1000 换算)。
+var countUnits = []string{"K", "M", "G", "T", "P", "E"}
+
+// Count 按 f.Counts 格式化计数，例如对象数或 goroutine 数；零值 ValueFormat 与 CountsGrouped 相同。
+func (f ValueFormat) Count(v int64) string {
+	switch f.Counts {
+	case CountsPlain:
+		return strconv.FormatInt(v, 10)
+	case CountsHuman:
+		if v >= 10000 || v <= -10000 {
+			scaled, unit := math.Abs(float64(v)), -1
+			// 按保留一位小数后的值换算，避免出现 "1000.0K"
+			for scaled >= 999.95 && unit < len(countUnits)-1 {
+				scaled /= 1000
+				unit++
+			}
+			sign := ""
+			if v < 0 {
+				sign = "-"
+			}
+			return sign + f.Float(scaled, 1) + countUnits[unit]
+		}
+	}
+	if f.Locale.Group == "" {
+		f.Locale.Group = "," // 计数总是分组：与小数点无关，不会产生歧义
+	}
+	return f.Int(v)
+}
+
+// group 在整数部分的数字间插入千位分隔符。
+func (f ValueFormat) group(digits string) string {
+	if f.Locale.Group == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(f.Locale.Group)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+// Bytes 将字节数转换为人类可读的字符串，例如 "1.50 MB" (jedec)、"1.50 MiB" (iec) 或 "1.57 MB" (si)。负值 (例如差值) 保留符号。
+func (f ValueFormat) Bytes(b int64) string {
+	sign, magnitude := "", uint64(b)
+	if b < 0 {
+		sign, magnitude = "-", uint64(-b) // math.MinInt64 的绝对值也能用 uint64 表示
+	}
+	base, names := uint64(1024), []string{"KB", "MB", "GB", "TB", "PB", "EB"}
+	switch f.ByteUnits {
+	case ByteUnitsIEC:
+		names = []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	case ByteUnitsSI:
+		base, names = 1000, []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	}
+	if magnitude < base {
+		return fmt.Sprintf("%s%s B", sign, f.Int(int64(magnitude)))
+	}
+	div, exp := base, 0
+	for n := magnitude / base; n >= base; n /= base {
+		div *= base
+		exp++
+	}
+	return fmt.Sprintf("%s%s %s", sign, f.Float(float64(magnitude)/float64(div), 2), names[exp])
+}
+
+// SampleValue 将样本值 (如 CPU 时间或计数) 转换为人类可读的字符串；字节值见 Bytes。
+func (f ValueFormat) SampleValue(value int64, unit string) string {
 	switch unit {
 	case "nanoseconds":
+		sign := ""
+		if value < 0 && value != math.MinInt64 {
+			sign, value = "-", -value
+		}
 		d := time.Duration(value) * time.Nanosecond
 		if d >= time.Second {
-			return fmt.Sprintf("%.2fs", d.Seconds())
+			return sign + f.Float(d.Seconds(), 2) + "s"
 		}
 		if d >= time.Millisecond {
-			return fmt.Sprintf("%.2fms", float64(d.Milliseconds()))
+			return sign + f.Float(float64(d.Milliseconds()), 2) + "ms"
 		}
 		if d >= time.Microsecond {
-			return fmt.Sprintf("%.2fus", float64(d.Microseconds()))
+			return sign + f.Float(float64(d.Microseconds()), 2) + "us"
 		}
-		return fmt.Sprintf("%dns", d.Nanoseconds())
+		return sign + f.Int(d.Nanoseconds()) + "ns"
 	case "count":
-		return fmt.Sprintf("%d", value)
+		return f.Count(value)
+	case "bytes":
+		return f.Bytes(value)
 	// 如果需要，可以添加其他潜在单位的处理
 	default:
-		return fmt.Sprintf("%d %s", value, unit) // 回退方案
+		return fmt.Sprintf("%s %s", f.Int(value), unit) // 回退方案
 	}
 }
 
-// FormatBytes 将字节数转换为人类可读的字符串 (KB, MB, GB)。
+// FormatSampleValue 将样本值 (如 CPU 时间或计数) 转换为人类可读的字符串，使用 DefaultValueFormat。
+// 注意：已导出 (首字母大写)。
+func FormatSampleValue(value int64, unit string) string {
+	return DefaultValueFormat().SampleValue(value, unit)
+}
+
+// FormatCount 按 DefaultValueFormat 格式化计数，例如 "123,456,789" 或 (CountsHuman) "123.5M"。
+func FormatCount(n int64) string {
+	return DefaultValueFormat().Count(n)
+}
+
+// FormatBytes 将字节数转换为人类可读的字符串 (KB, MB, GB)，单位制和分隔符由 DefaultValueFormat 决定。
 // 注意：已导出 (首字母大写)。
 func FormatBytes(b int64) string {
-	const unit = 1024
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
+	return DefaultValueFormat().Bytes(b)
+}
+
+// percentCell 将百分比 (保留两位小数，不含 % 号) 格式化为文本表格中的数值列，见 valueCell。
+func percentCell(percent float64) string {
+	return valueCell(percentString(percent))
+}
+
+// percentString 将百分比格式化为表格单元格 (保留两位小数，不含 % 号)。
+func percentString(percent float64) string {
+	return DefaultValueFormat().Float(percent, 2)
+}
+
+// valueCell 将文本表格中的数值 (或其表头) 填充到 ValueColumnWidth 宽度，按 DefaultValueFormat 左对齐或右对齐，
+// 并保留一个空格与下一列分隔。宽度按字符计算，因此多字节的千位分隔符不会破坏对齐。
+func valueCell(s string) string {
+	pad := ValueColumnWidth - utf8.RuneCountInString(s)
+	if pad < 0 {
+		pad = 0
 	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	if DefaultValueFormat().AlignRight {
+		return strings.Repeat(" ", pad) + s + " "
 	}
-	return fmt.Sprintf("%.2f %cB", float64(b)/float64(div), "KMGTPE"[exp]) // Kilo, Mega, Giga, Tera, Peta, Exa
+	return s + strings.Repeat(" ", pad) + " "
 }
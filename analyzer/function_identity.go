@@ -0,0 +1,244 @@
+package analyzer
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// FunctionMatch is a function of a profile matched to a differently named function of its base profile.
+type FunctionMatch struct {
+	Name     string `json:"name"`     // Name in the profile
+	BaseName string `json:"baseName"` // Name in the base profile
+	Method   string `json:"method"`   // "address", "normalized name" or "file and name"
+}
+
+var (
+	// majorVersionElement matches a module major version path element, e.g. "/v2" in "example.com/lib/v2.Parse".
+	majorVersionElement = regexp.MustCompile(`/v[0-9]+([./])`)
+	// gopkgVersion matches the version suffix of a gopkg.in package, e.g. ".v3" in "gopkg.in/yaml.v3.Unmarshal".
+	gopkgVersion = regexp.MustCompile(`^(gopkg\.in/[^/]+?)\.v[0-9]+\.`)
+	// closureSuffix matches the numbering of closures and wrappers, which changes when code is inlined or moved,
+	// e.g. ".func2.1" in "main.run.func2.1".
+	closureSuffix = regexp.MustCompile(`\.(func|gowrap|deferwrap)[0-9]+(\.[0-9]+)*`)
+)
+
+// NormalizeFunctionName removes the parts of a function name that change between builds of the same code:
+// vendor directories, module major versions ("example.com/lib/v2.Parse" → "example.com/lib.Parse"), gopkg.in
+// versions, generic type arguments and the numbering of closures ("main.run.func3" → "main.run.func").
+func NormalizeFunctionName(name string) string {
+	if i := strings.LastIndex(name, "/vendor/"); i >= 0 {
+		name = name[i+len("/vendor/"):]
+	}
+	name = gopkgVersion.ReplaceAllString(name, "$1.")
+	name = majorVersionElement.ReplaceAllString(name, "$1")
+	name = stripTypeArguments(name)
+	return closureSuffix.ReplaceAllString(name, ".$1")
+}
+
+// stripTypeArguments replaces the type arguments of generic functions and types, which may be nested (e.g.
+// "[go.shape.[]string]"), by "[...]" as printed by the Go runtime.
+func stripTypeArguments(name string) string {
+	if !strings.Contains(name, "[") {
+		return name
+	}
+	var b strings.Builder
+	depth := 0
+	for _, r := range name {
+		switch {
+		case r == '[':
+			if depth == 0 {
+				b.WriteString("[...]")
+			}
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// identityMethod derives matching keys for the functions of a profile: functions of two profiles sharing a key
+// are considered the same function.
+type identityMethod struct {
+	name string
+	keys func(p *profile.Profile) map[string]map[string]bool // Function name → keys
+}
+
+// identityMethods are tried in order, from the most to the least reliable.
+var identityMethods = []identityMethod{
+	{"address", addressKeys},
+	{"normalized name", func(p *profile.Profile) map[string]map[string]bool {
+		keys := make(map[string]map[string]bool)
+		for _, fn := range p.Function {
+			addKey(keys, fn.Name, NormalizeFunctionName(fn.Name))
+		}
+		return keys
+	}},
+	{"file and name", func(p *profile.Profile) map[string]map[string]bool {
+		keys := make(map[string]map[string]bool)
+		for _, fn := range p.Function {
+			if fn.Filename == "" {
+				continue
+			}
+			normalized := NormalizeFunctionName(fn.Name)
+			if pkg := PackageName(normalized); pkg != "" {
+				normalized = normalized[len(pkg)+1:] // Packages may move, e.g. when a module is renamed
+			}
+			addKey(keys, fn.Name, path.Base(fn.Filename)+"|"+normalized)
+		}
+		return keys
+	}},
+}
+
+// addKey adds key to the set of keys of name.
+func addKey(keys map[string]map[string]bool, name, key string) {
+	if keys[name] == nil {
+		keys[name] = make(map[string]bool)
+	}
+	keys[name][key] = true
+}
+
+// addressKeys keys the functions of locations in binaries with a build ID by the build ID and the offset of
+// the location in the binary, which is the same in every profile of that binary regardless of symbolization.
+func addressKeys(p *profile.Profile) map[string]map[string]bool {
+	keys := make(map[string]map[string]bool)
+	for _, loc := range p.Location {
+		m := loc.Mapping
+		if m == nil || m.BuildID == "" || loc.Address < m.Start {
+			continue
+		}
+		offset := loc.Address - m.Start + m.Offset
+		for i, line := range loc.Line {
+			if line.Function != nil {
+				// Inlined frames share the address; the index tells them apart
+				addKey(keys, line.Function.Name, fmt.Sprintf("%s@%x#%d", m.BuildID, offset, len(loc.Line)-1-i))
+			}
+		}
+	}
+	return keys
+}
+
+// functionNames returns the set of function names of a profile.
+func functionNames(p *profile.Profile) map[string]bool {
+	names := make(map[string]bool, len(p.Function))
+	for _, fn := range p.Function {
+		names[fn.Name] = true
+	}
+	return names
+}
+
+// MatchFunctionIdentities matches the functions of p that do not exist in base to functions of base that do
+// not exist in p, trying build IDs and addresses, normalized names and file basenames in turn. It is best
+// effort: a function is only matched when exactly one function of each profile shares a key, so ambiguous
+// names stay unmatched. The matches are sorted by name.
+func MatchFunctionIdentities(p, base *profile.Profile) []FunctionMatch {
+	names, baseNames := functionNames(p), functionNames(base)
+	unmatched := make(map[string]bool)
+	for name := range names {
+		if !baseNames[name] {
+			unmatched[name] = true
+		}
+	}
+	targets := make(map[string]bool)
+	for name := range baseNames {
+		if !names[name] {
+			targets[name] = true
+		}
+	}
+
+	matches := make([]FunctionMatch, 0)
+	for _, method := range identityMethods {
+		if len(unmatched) == 0 || len(targets) == 0 {
+			break
+		}
+		byKey := make(map[string]map[string]bool) // Key → unmatched base functions
+		for name, keys := range method.keys(base) {
+			if !targets[name] {
+				continue
+			}
+			for key := range keys {
+				addKey(byKey, key, name)
+			}
+		}
+		proposals := make(map[string]string) // Function → base function
+		proposed := make(map[string]int)     // Base function → number of functions proposing it
+		for name, keys := range method.keys(p) {
+			if !unmatched[name] {
+				continue
+			}
+			candidates := make(map[string]bool)
+			for key := range keys {
+				for target := range byKey[key] {
+					candidates[target] = true
+				}
+			}
+			if len(candidates) != 1 {
+				continue
+			}
+			for target := range candidates {
+				proposals[name] = target
+				proposed[target]++
+			}
+		}
+		for name, target := range proposals {
+			if proposed[target] != 1 {
+				continue
+			}
+			matches = append(matches, FunctionMatch{Name: name, BaseName: target, Method: method.name})
+			delete(unmatched, name)
+			delete(targets, target)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Name < matches[j].Name
+	})
+	return matches
+}
+
+// MapFunctionIdentities returns p with the functions matched by MatchFunctionIdentities renamed to their names
+// in base, so that diffs against base treat them as the same function, together with the matches.
+// p is not modified; without matches it is returned as is.
+func MapFunctionIdentities(p, base *profile.Profile) (*profile.Profile, []FunctionMatch) {
+	matches := MatchFunctionIdentities(p, base)
+	if len(matches) == 0 {
+		return p, matches
+	}
+	renames := make(map[string]string, len(matches))
+	for _, m := range matches {
+		renames[m.Name] = m.BaseName
+	}
+	mapped := p.Copy()
+	for _, fn := range mapped.Function {
+		if baseName, ok := renames[fn.Name]; ok {
+			if fn.SystemName == fn.Name {
+				fn.SystemName = baseName
+			}
+			fn.Name = baseName
+		}
+	}
+	log.Printf("Matched %d renamed function(s) to the base profile", len(matches))
+	return mapped, matches
+}
+
+// FormatFunctionMatches summarizes the matches in one line per function, e.g.
+// "example.com/lib/v2.Parse → example.com/lib.Parse (normalized name)", listing at most limit of them.
+func FormatFunctionMatches(matches []FunctionMatch, limit int) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Matched %d renamed function(s) to the base profile:\n", len(matches)))
+	for i, m := range matches {
+		if i == limit {
+			b.WriteString(fmt.Sprintf("  ... and %d more\n", len(matches)-limit))
+			break
+		}
+		b.WriteString(fmt.Sprintf("  %s → %s (%s)\n", m.Name, m.BaseName, m.Method))
+	}
+	return b.String()
+}
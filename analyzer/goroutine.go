@@ -18,31 +18,99 @@ type stackInfo struct {
 	Count int64    // 具有此堆栈的 goroutine 数量
 }
 
-// AnalyzeGoroutineProfile 分析 Goroutine profile 并返回格式化结果。
-func AnalyzeGoroutineProfile(p *profile.Profile, topN int, format string) (string, error) {
-	log.Printf("Analyzing Goroutine profile (Top %d, Format: %s)", topN, format)
+// goroutineWaitReasons 将阻塞在运行时函数中的堆栈归类为等待原因 (类似 debug=2 输出中的 [chan receive])。
+// protobuf 格式的 goroutine profile 不包含等待原因，因此按堆栈中离叶子最近的匹配帧进行分类。
+var goroutineWaitReasons = []struct {
+	reason    string
+	functions []string
+}{
+	{"chan receive", []string{"runtime.chanrecv", "runtime.chanrecv1", "runtime.chanrecv2"}},
+	{"chan send", []string{"runtime.chansend", "runtime.chansend1"}},
+	{"select", []string{"runtime.selectgo"}},
+	{"select (no cases)", []string{"runtime.block"}},
+	{"IO wait", []string{"internal/poll.runtime_pollWait", "runtime.netpollblock"}},
+	{"sync.Mutex lock", []string{"sync.runtime_SemacquireMutex", "sync.runtime_SemacquireRWMutex", "sync.runtime_SemacquireRWMutexR", "internal/sync.runtime_SemacquireMutex"}},
+	{"sync.Cond wait", []string{"sync.runtime_notifyListWait"}},
+	{"semacquire", []string{"sync.runtime_Semacquire", "sync.runtime_SemacquireWaitGroup"}},
+	{"sleep", []string{"time.Sleep", "runtime.timeSleep"}},
+	{"syscall", []string{"syscall.Syscall", "syscall.Syscall6", "syscall.RawSyscall", "syscall.syscall", "syscall.syscall6", "internal/runtime/syscall.Syscall6", "runtime/internal/syscall.Syscall6"}},
+	{"GC worker (idle)", []string{"runtime.gcBgMarkWorker"}},
+	{"GC sweep wait", []string{"runtime.bgsweep"}},
+	{"GC scavenge wait", []string{"runtime.bgscavenge"}},
+	{"force gc (idle)", []string{"runtime.forcegchelper"}},
+	{"finalizer wait", []string{"runtime.runfinq", "runtime.runFinalizersAndCleanups"}},
+}
 
-	// --- 1. 确定 Goroutine 计数的样本值索引 ---
-	// Goroutine profile 通常只有一个样本类型："goroutines" / "count"
-	valueIndex := 0 // 假设第一个样本类型是 goroutine 计数
-	if len(p.SampleType) == 0 {
-		return "", fmt.Errorf("goroutine profile 没有样本类型")
+// classifyGoroutineStack 返回堆栈 (叶子在前) 的等待原因：离叶子最近的匹配帧决定原因；
+// 停靠 (gopark) 但无法归类的为 "other wait"，其余视为 "running"。
+func classifyGoroutineStack(names []string) string {
+	parked := false
+	for _, name := range names {
+		for _, rule := range goroutineWaitReasons {
+			for _, fn := range rule.functions {
+				if name == fn {
+					return rule.reason
+				}
+			}
+		}
+		if name == "runtime.gopark" || name == "runtime.goparkunlock" {
+			parked = true
+		}
 	}
-	if p.SampleType[0].Type != "goroutines" {
-		log.Printf("Warning: Expected 'goroutines' sample type, found: %v. Using index 0.", p.SampleType)
+	if parked {
+		return "other wait"
 	}
-	valueType := p.SampleType[valueIndex].Type
-	valueUnit := p.SampleType[valueIndex].Unit
-	log.Printf("使用索引 %d (%s/%s) 进行 Goroutine 分析", valueIndex, valueType, valueUnit)
+	return "running"
+}
+
+// SummarizeGoroutineStates 按等待原因统计 goroutine 数量，按数量降序排列。
+// 来自完整 goroutine 转储 (debug=2) 的 profile 使用运行时打印的状态，而不是根据堆栈推断。
+func SummarizeGoroutineStates(p *profile.Profile) (int64, []GoroutineStateCount) {
+	counts := make(map[string]int64)
+	total := int64(0)
+	dump := isGoroutineDump(p)
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 {
+			continue
+		}
+		total += s.Value[0]
+		counts[goroutineSampleState(s, dump)] += s.Value[0]
+	}
+	states := make([]GoroutineStateCount, 0, len(counts))
+	for state, count := range counts {
+		states = append(states, GoroutineStateCount{State: state, Count: count})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Count != states[j].Count {
+			return states[i].Count > states[j].Count
+		}
+		return states[i].State < states[j].State
+	})
+	return total, states
+}
+
+// FormatGoroutineStateSummary 生成一行摘要，例如 "3,240 total: 2,100 chan receive, 600 IO wait, 300 select, 240 running"。
+func FormatGoroutineStateSummary(total int64, states []GoroutineStateCount) string {
+	parts := make([]string, len(states))
+	for i, st := range states {
+		parts[i] = FormatCount(st.Count) + " " + st.State
+	}
+	if len(parts) == 0 {
+		return FormatCount(total) + " total"
+	}
+	return FormatCount(total) + " total: " + strings.Join(parts, ", ")
+}
 
-	// --- 2. 按堆栈跟踪聚合 Goroutine ---
+// aggregateStacks 按堆栈跟踪 (函数、文件和行号) 聚合样本值，返回按值降序排列的堆栈及总值。
+// goroutine 和 threadcreate 分析共用。
+func aggregateStacks(p *profile.Profile, valueIndex int) ([]*stackInfo, int64) {
 	stackCounts := make(map[string]*stackInfo) // Map 的键是堆栈的字符串表示形式
-	totalGoroutines := int64(0)
+	total := int64(0)
 
 	for _, s := range p.Sample {
 		if len(s.Value) > valueIndex {
-			count := s.Value[valueIndex] // 此堆栈的 Goroutine 数量
-			totalGoroutines += count
+			count := s.Value[valueIndex] // 此堆栈的样本值 (goroutine 或线程数量)
+			total += count
 
 			var stackKey strings.Builder
 			var formattedStack []string
@@ -83,16 +151,59 @@ func AnalyzeGoroutineProfile(p *profile.Profile, topN int, format string) (strin
 		}
 	}
 
-	// --- 3. 按 Goroutine 数量对堆栈进行排序 ---
+	// 按数量对堆栈进行排序，数量相同时按堆栈 (函数、文件:行号) 排序，保证输出稳定
 	stats := make([]*stackInfo, 0, len(stackCounts))
 	for _, info := range stackCounts {
 		stats = append(stats, info)
 	}
 	sort.Slice(stats, func(i, j int) bool {
-		return stats[i].Count > stats[j].Count // 降序排列
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count // 降序排列
+		}
+		return strings.Join(stats[i].Stack, "\n") < strings.Join(stats[j].Stack, "\n")
 	})
+	return stats, total
+}
+
+// AnalyzeGoroutineProfile 分析 Goroutine profile 并返回格式化结果。
+func AnalyzeGoroutineProfile(p *profile.Profile, topN int, format string) (string, error) {
+	return analyzeGoroutineProfile(p, NewOptions(WithTopN(topN), WithFormat(format)))
+}
 
-	// --- 4. 格式化输出 ---
+// truncatedStack 返回堆栈的前 maxDepth 帧 (叶子在前)，并以 "… N more frames" 标记省略的帧数；maxDepth 为 0 时返回完整堆栈。
+func truncatedStack(stack []string, maxDepth int) []string {
+	if maxDepth <= 0 || len(stack) <= maxDepth {
+		return stack
+	}
+	truncated := make([]string, maxDepth, maxDepth+1)
+	copy(truncated, stack[:maxDepth])
+	return append(truncated, fmt.Sprintf("… %d more frames", len(stack)-maxDepth))
+}
+
+// analyzeGoroutineProfile is the implementation of AnalyzeGoroutineProfile, driven by Options (see Analyze).
+func analyzeGoroutineProfile(p *profile.Profile, o Options) (string, error) {
+	topN, format := o.TopN, o.Format
+	log.Printf("Analyzing Goroutine profile (Top %d, Format: %s)", topN, format)
+
+	// --- 1. 确定 Goroutine 计数的样本值索引 ---
+	// Goroutine profile 通常只有一个样本类型："goroutines" / "count"
+	valueIndex := 0 // 假设第一个样本类型是 goroutine 计数
+	if len(p.SampleType) == 0 {
+		return "", fmt.Errorf("goroutine profile 没有样本类型")
+	}
+	if p.SampleType[0].Type != "goroutines" {
+		log.Printf("Warning: Expected 'goroutines' sample type, found: %v. Using index 0.", p.SampleType)
+	}
+	valueType := p.SampleType[valueIndex].Type
+	valueUnit := p.SampleType[valueIndex].Unit
+	log.Printf("使用索引 %d (%s/%s) 进行 Goroutine 分析", valueIndex, valueType, valueUnit)
+
+	// --- 2. 按堆栈跟踪聚合 Goroutine 并按数量排序 ---
+	stats, totalGoroutines := aggregateStacks(p, valueIndex)
+	// 完整的 goroutine 转储还包含等待时长：按状态统计并列出等待最久的堆栈
+	stateWaits, waitGroups := SummarizeGoroutineWaits(p)
+
+	// --- 3. 格式化输出 ---
 	var b strings.Builder
 	limit := topN
 	if limit > len(stats) {
@@ -105,26 +216,37 @@ func AnalyzeGoroutineProfile(p *profile.Profile, topN int, format string) (strin
 			b.WriteString("```text\n")
 		}
 		b.WriteString(fmt.Sprintf("Goroutine Profile Analysis (Top %d Stacks by Count)\n", topN))
-		b.WriteString(fmt.Sprintf("Total Goroutines (%s/%s): %d\n", valueType, valueUnit, totalGoroutines))
+		b.WriteString(fmt.Sprintf("Total Goroutines (%s/%s): %s\n", valueType, valueUnit, FormatCount(totalGoroutines)))
+		b.WriteString(fmt.Sprintf("States: %s\n", FormatGoroutineStateSummary(SummarizeGoroutineStates(p))))
 		b.WriteString("--------------------------------------------------\n")
 		for i := 0; i < limit; i++ {
 			stat := stats[i]
-			b.WriteString(fmt.Sprintf("\n%d goroutines with stack:\n", stat.Count))
-			// 打印堆栈跟踪
-			for _, line := range stat.Stack {
+			b.WriteString(fmt.Sprintf("\n%s goroutines with stack (%d frames):\n", FormatCount(stat.Count), len(stat.Stack)))
+			// 打印堆栈跟踪 (超过 max_stack_depth 的帧以标记代替)
+			for _, line := range truncatedStack(stat.Stack, o.MaxStackDepth) {
 				b.WriteString(fmt.Sprintf("  %s\n", line)) // 缩进堆栈行
 			}
 			b.WriteString("--------------------------------------------------\n")
 		}
+		if stateWaits != nil {
+			b.WriteString(formatGoroutineWaits(stateWaits, longestWaitingStacks(waitGroups, topN, o.MaxStackDepth)))
+		}
 		if format == "markdown" {
 			b.WriteString("```\n")
 		}
 	case "json":
+		stateTotal, states := SummarizeGoroutineStates(p)
 		result := GoroutineAnalysisResult{ // 使用 types.go 中的结构体
 			ProfileType:     "goroutine",
 			TotalGoroutines: totalGoroutines,
+			StateSummary:    FormatGoroutineStateSummary(stateTotal, states),
+			States:          states,
 			TopN:            limit,
 			Stacks:          make([]GoroutineStackInfo, 0, limit), // 使用 types.go 中的结构体
+			StateWaits:      stateWaits,
+		}
+		if stateWaits != nil {
+			result.LongestWaiting = longestWaitingStacks(waitGroups, topN, o.MaxStackDepth)
 		}
 
 		for i := 0; i < limit; i++ {
@@ -133,7 +255,8 @@ func AnalyzeGoroutineProfile(p *profile.Profile, topN int, format string) (strin
 			// 但在这个场景下，stat 是局部变量，应该没问题。
 			result.Stacks = append(result.Stacks, GoroutineStackInfo{ // 使用 types.go 中的结构体
 				Count:      stat.Count,
-				StackTrace: stat.Stack, // 直接使用已格式化的堆栈
+				StackTrace: truncatedStack(stat.Stack, o.MaxStackDepth), // 直接使用已格式化的堆栈
+				Frames:     len(stat.Stack),
 			})
 		}
 
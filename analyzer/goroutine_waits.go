@@ -0,0 +1,179 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// goroutineSampleState returns the state of the goroutines of a sample: the one printed by the runtime in
+// goroutine dumps, or the wait reason inferred from the stack otherwise (see classifyGoroutineStack).
+func goroutineSampleState(s *profile.Sample, dump bool) string {
+	if dump {
+		if states := s.Label[GoroutineStateLabel]; len(states) > 0 && states[0] != "" {
+			return states[0]
+		}
+	}
+	return classifyGoroutineStack(sampleFunctions(s))
+}
+
+// goroutineSampleWait returns the wait duration of the goroutines of a dump sample in minutes, 0 when the
+// runtime did not print one (blocked for less than a minute, or running).
+func goroutineSampleWait(s *profile.Sample) int64 {
+	if waits := s.NumLabel[GoroutineWaitLabel]; len(waits) > 0 {
+		return waits[0]
+	}
+	return 0
+}
+
+// formatGoroutineStack formats the stack of a sample like aggregateStacks: "function\n\tfile:line" per frame.
+func formatGoroutineStack(s *profile.Sample) []string {
+	stack := make([]string, 0, len(s.Location))
+	for _, loc := range s.Location {
+		if len(loc.Line) > 0 && loc.Line[0].Function != nil {
+			line := loc.Line[0]
+			stack = append(stack, fmt.Sprintf("%s\n\t%s:%d", line.Function.Name, line.Function.Filename, line.Line))
+		}
+	}
+	return stack
+}
+
+// FormatWaitMinutes formats a wait duration of a goroutine dump, e.g. "1 minute" or "95 minutes (1h35m)".
+func FormatWaitMinutes(minutes int64) string {
+	switch {
+	case minutes == 1:
+		return "1 minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes", minutes)
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d minutes (%dh)", minutes, minutes/60)
+	}
+	return fmt.Sprintf("%d minutes (%dh%dm)", minutes, minutes/60, minutes%60)
+}
+
+// SummarizeGoroutineWaits summarizes a profile built from a goroutine dump (see ParseGoroutineDump): the
+// goroutines by state, sorted by count, and the groups of goroutines with the same state and stack, sorted
+// by their longest wait, then by count. Stack traces are complete. It returns nil for other profiles, which
+// have no wait durations.
+func SummarizeGoroutineWaits(p *profile.Profile) ([]GoroutineStateWait, []GoroutineWaitStack) {
+	if !isGoroutineDump(p) {
+		return nil, nil
+	}
+	states := make(map[string]*GoroutineStateWait)
+	groups := make(map[string]*GoroutineWaitStack)
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 || s.Value[0] <= 0 {
+			continue
+		}
+		count, state, wait := s.Value[0], goroutineSampleState(s, true), goroutineSampleWait(s)
+		st, ok := states[state]
+		if !ok {
+			st = &GoroutineStateWait{State: state}
+			states[state] = st
+		}
+		st.Count += count
+		if wait > 0 {
+			st.Waiting += count
+		}
+		st.MaxWaitMinutes = max(st.MaxWaitMinutes, wait)
+
+		stack := formatGoroutineStack(s)
+		createdBy := ""
+		if c := s.Label[GoroutineCreatedByLabel]; len(c) > 0 {
+			createdBy = c[0]
+		}
+		key := state + "\x00" + createdBy + "\x00" + strings.Join(stack, "\n")
+		g, ok := groups[key]
+		if !ok {
+			g = &GoroutineWaitStack{State: state, MinWaitMinutes: wait, CreatedBy: createdBy, StackTrace: stack, Frames: len(stack)}
+			groups[key] = g
+		}
+		g.Count += count
+		g.MinWaitMinutes = min(g.MinWaitMinutes, wait)
+		g.MaxWaitMinutes = max(g.MaxWaitMinutes, wait)
+	}
+
+	stateList := make([]GoroutineStateWait, 0, len(states))
+	for _, st := range states {
+		stateList = append(stateList, *st)
+	}
+	sort.Slice(stateList, func(i, j int) bool {
+		if stateList[i].Count != stateList[j].Count {
+			return stateList[i].Count > stateList[j].Count
+		}
+		return stateList[i].State < stateList[j].State
+	})
+	groupList := make([]GoroutineWaitStack, 0, len(groups))
+	for _, g := range groups {
+		groupList = append(groupList, *g)
+	}
+	sort.Slice(groupList, func(i, j int) bool {
+		a, b := groupList[i], groupList[j]
+		if a.MaxWaitMinutes != b.MaxWaitMinutes {
+			return a.MaxWaitMinutes > b.MaxWaitMinutes
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return strings.Join(a.StackTrace, "\n") < strings.Join(b.StackTrace, "\n")
+	})
+	return stateList, groupList
+}
+
+// longestWaitingStacks returns the first topN groups of SummarizeGoroutineWaits that waited at least a
+// minute, with stacks truncated to maxDepth frames.
+func longestWaitingStacks(groups []GoroutineWaitStack, topN, maxDepth int) []GoroutineWaitStack {
+	result := make([]GoroutineWaitStack, 0, topN)
+	for _, g := range groups {
+		if len(result) == topN || g.MaxWaitMinutes == 0 {
+			break
+		}
+		g.StackTrace = truncatedStack(g.StackTrace, maxDepth)
+		result = append(result, g)
+	}
+	return result
+}
+
+// formatGoroutineWaits renders the state and wait sections of the text analysis of a goroutine dump.
+func formatGoroutineWaits(states []GoroutineStateWait, longest []GoroutineWaitStack) string {
+	var b strings.Builder
+	b.WriteString("\n=== By State (from goroutine dump) ===\n")
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(fmt.Sprintf("%-24s %-12s %-16s %s\n", "State", "Goroutines", "Waiting >= 1m", "Longest Wait"))
+	b.WriteString("--------------------------------------------------\n")
+	for _, st := range states {
+		longestWait := "-"
+		if st.MaxWaitMinutes > 0 {
+			longestWait = FormatWaitMinutes(st.MaxWaitMinutes)
+		}
+		b.WriteString(fmt.Sprintf("%-24s %-12s %-16s %s\n", st.State, FormatCount(st.Count), FormatCount(st.Waiting), longestWait))
+	}
+
+	b.WriteString("\n=== Longest-Waiting Stacks ===\n")
+	if len(longest) == 0 {
+		b.WriteString("None: no goroutine has been blocked for a minute or more (the runtime prints shorter waits as no duration).\n")
+		return b.String()
+	}
+	b.WriteString("--------------------------------------------------\n")
+	for _, g := range longest {
+		wait := "waiting " + FormatWaitMinutes(g.MaxWaitMinutes)
+		if g.MinWaitMinutes != g.MaxWaitMinutes {
+			wait = fmt.Sprintf("waiting %d to %s", g.MinWaitMinutes, FormatWaitMinutes(g.MaxWaitMinutes))
+			if g.MinWaitMinutes == 0 {
+				wait = "waiting up to " + FormatWaitMinutes(g.MaxWaitMinutes)
+			}
+		}
+		created := ""
+		if g.CreatedBy != "" {
+			created = ", created by " + g.CreatedBy
+		}
+		b.WriteString(fmt.Sprintf("\n%s goroutines in %s, %s%s (%d frames):\n", FormatCount(g.Count), g.State, wait, created, g.Frames))
+		for _, line := range g.StackTrace {
+			b.WriteString(fmt.Sprintf("  %s\n", line))
+		}
+		b.WriteString("--------------------------------------------------\n")
+	}
+	return b.String()
+}
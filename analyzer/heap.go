@@ -5,13 +5,20 @@ import (
 	"fmt"
 	"log"
 	"sort"
-	"strings"
 
 	"github.com/google/pprof/profile"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer/aggregate"
 )
 
 // AnalyzeHeapProfile 分析 Heap profile (主要关注 inuse_space) 并返回格式化结果。
 func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, error) {
+	return analyzeHeapProfile(p, NewOptions(WithTopN(topN), WithFormat(format)))
+}
+
+// analyzeHeapProfile is the implementation of AnalyzeHeapProfile, driven by Options (see Analyze).
+func analyzeHeapProfile(p *profile.Profile, o Options) (string, error) {
+	topN, format := o.TopN, o.Format
 	log.Printf("Analyzing Heap profile (Top %d, Format: %s)", topN, format)
 
 	// --- 1. 查找 'inuse_space' 的样本值索引 ---
@@ -56,6 +63,14 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 			valueIndex, p.SampleType[valueIndex].Type, p.SampleType[valueIndex].Unit)
 	}
 
+	// o.SampleType overrides the default value type
+	if explicitIndex, err := o.sampleIndex(p); err != nil {
+		return "", err
+	} else if explicitIndex >= 0 {
+		valueIndex = explicitIndex
+		objectsIndex = matchingObjectsIndex(p, valueIndex, objectsIndex)
+	}
+
 	if valueIndex == -1 {
 		return "", fmt.Errorf("无法从 profile 样本类型中确定值类型 (例如 inuse_space bytes)")
 	}
@@ -67,105 +82,38 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 		log.Printf("使用索引 %d (%s/%s) 进行对象计数", objectsIndex, p.SampleType[objectsIndex].Type, p.SampleType[objectsIndex].Unit)
 	}
 
-	// --- 2. Aggregate memory usage values by function and allocation site ---
-	// Create two maps: one for aggregating by function, one for aggregating by allocation site
-	funcValue := make(map[string]int64)        // Aggregate by function name
-	allocSiteValue := make(map[string]int64)   // Aggregate by allocation site (function+file+line)
-	funcObjects := make(map[string]int64)      // Object count aggregated by function
-	allocSiteObjects := make(map[string]int64) // Object count aggregated by allocation site
+	// --- 2. Aggregate memory usage values by function, allocation site and type ---
+	// Memory is attributed to the topmost function in the allocation stack
+	funcAgg := o.aggregateByFunction(p, valueIndex, objectsIndex)
+	allocSiteStats := o.aggregateBySite(p, valueIndex, objectsIndex).Stats
+	funcStats := funcAgg.Stats
+	totalValue := funcAgg.Total
+	totalObjects := funcAgg.TotalObjects
+
+	if totalValue == 0 {
+		log.Printf("Warning: Total value for the selected sample type (%s/%s) is zero.", valueType, valueUnit)
+	}
 
-	// Maps for storing type information
+	// --- 3. Aggregate and sort by type ---
 	typeValue := make(map[string]int64)   // Memory usage aggregated by type
 	typeObjects := make(map[string]int64) // Object count aggregated by type
-
-	totalValue := int64(0)
-	totalObjects := int64(0)
-
 	for _, s := range p.Sample {
 		if len(s.Location) > 0 && len(s.Value) > valueIndex {
-			v := s.Value[valueIndex] // Memory usage (bytes)
-			totalValue += v
-
-			// If object count information is available, collect it too
 			var objCount int64 = 0
 			if objectsIndex >= 0 && len(s.Value) > objectsIndex {
 				objCount = s.Value[objectsIndex]
-				totalObjects += objCount
 			}
-
-			// Extract type information (if available)
-			typeName := "unknown"
-			if len(s.Label) > 0 {
-				if typeLabels, ok := s.Label["type"]; ok && len(typeLabels) > 0 {
-					typeName = typeLabels[0]
-				} else if objLabels, ok := s.Label["object"]; ok && len(objLabels) > 0 {
-					typeName = objLabels[0]
-				}
+			typeName := aggregate.ObjectType(s)
+			if typeName == "" {
+				typeName = "unknown"
 			}
-
-			// Aggregate by type
-			typeValue[typeName] += v
+			typeValue[typeName] += s.Value[valueIndex]
 			if objCount > 0 {
 				typeObjects[typeName] += objCount
 			}
-
-			// Attribute memory to the topmost function in the allocation stack
-			loc := s.Location[0]
-			for _, line := range loc.Line {
-				if line.Function != nil {
-					funcName := line.Function.Name
-					fileName := line.Function.Filename
-					lineNum := line.Line
-
-					// Aggregate by function
-					funcValue[funcName] += v
-					if objCount > 0 {
-						funcObjects[funcName] += objCount
-					}
-
-					// Aggregate by allocation site (function+file+line)
-					allocSiteKey := fmt.Sprintf("%s at %s:%d", funcName, fileName, lineNum)
-					allocSiteValue[allocSiteKey] += v
-					if objCount > 0 {
-						allocSiteObjects[allocSiteKey] += objCount
-					}
-
-					break // Only attribute to the first function found in the top frame
-				}
-			}
 		}
 	}
 
-	if totalValue == 0 {
-		log.Printf("Warning: Total value for the selected sample type (%s/%s) is zero.", valueType, valueUnit)
-	}
-
-	// --- 3. Sort functions, allocation sites, and types by aggregated values ---
-	// Sort by function
-	funcStats := make([]functionStat, 0, len(funcValue))
-	for name, val := range funcValue {
-		funcStats = append(funcStats, functionStat{Name: name, Flat: val})
-	}
-	sort.Slice(funcStats, func(i, j int) bool {
-		return funcStats[i].Flat > funcStats[j].Flat // Sort in descending order
-	})
-
-	// Sort by allocation site
-	type allocSiteStat struct {
-		Site  string
-		Value int64
-		Count int64
-	}
-	allocSiteStats := make([]allocSiteStat, 0, len(allocSiteValue))
-	for site, val := range allocSiteValue {
-		count := allocSiteObjects[site]
-		allocSiteStats = append(allocSiteStats, allocSiteStat{Site: site, Value: val, Count: count})
-	}
-	sort.Slice(allocSiteStats, func(i, j int) bool {
-		return allocSiteStats[i].Value > allocSiteStats[j].Value // Sort in descending order
-	})
-
-	// Sort by type
 	type typeStat struct {
 		Type  string
 		Value int64
@@ -177,11 +125,13 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 		typeStats = append(typeStats, typeStat{Type: typeName, Value: val, Count: count})
 	}
 	sort.Slice(typeStats, func(i, j int) bool {
-		return typeStats[i].Value > typeStats[j].Value // Sort in descending order
+		if typeStats[i].Value != typeStats[j].Value {
+			return typeStats[i].Value > typeStats[j].Value // Sort in descending order
+		}
+		return typeStats[i].Type < typeStats[j].Type // Ties by name, for stable output
 	})
 
 	// --- 4. Format output ---
-	var b strings.Builder
 	limit := topN
 	if limit > len(funcStats) {
 		limit = len(funcStats)
@@ -192,97 +142,86 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 		allocSiteLimit = len(allocSiteStats)
 	}
 
+	duplicateFindings := DetectDuplicateAllocations(p, valueIndex, objectsIndex, topN)
+	clusters := ClusterAllocationSites(p, valueIndex, objectsIndex, topN)
+
 	typeLimit := limit
 	if typeLimit > len(typeStats) {
 		typeLimit = len(typeStats)
 	}
 
+	// Estimate object survival per allocation site (requires alloc_objects and inuse_objects)
+	var retained []SurvivalStat
+	if survivalStats, err := EstimateObjectSurvival(p); err == nil {
+		retained = retentionCandidates(survivalStats, topN)
+	} else {
+		log.Printf("Skipping object survival estimation: %v", err)
+	}
+
 	switch format {
 	case "text", "markdown":
-		if format == "markdown" {
-			b.WriteString("```text\n")
-		}
-		b.WriteString(fmt.Sprintf("Heap Profile Analysis (Top %d Functions by %s)\n", topN, valueType))
-		b.WriteString(fmt.Sprintf("Total %s (%s): %s\n", valueType, valueUnit, FormatBytes(totalValue)))
+		w := newReportWriter(format)
+		w.title("Heap Profile Analysis (Top %d Functions by %s)", topN, valueType)
+		w.line("Total %s (%s): %s", valueType, valueUnit, FormatBytes(totalValue))
 		if totalObjects > 0 {
-			b.WriteString(fmt.Sprintf("Total Objects: %d\n", totalObjects))
+			w.line("Total Objects: %s", FormatSampleValue(totalObjects, "count"))
 		}
-
-		// Output by function
-		b.WriteString("\n=== By Function ===\n")
-		b.WriteString("--------------------------------------------------\n")
-		b.WriteString(fmt.Sprintf("%-15s %-15s %s\n", valueType, "%", "Function Name"))
-		b.WriteString("--------------------------------------------------\n")
-		for i := 0; i < limit; i++ {
-			stat := funcStats[i]
-			percent := 0.0
-			if totalValue != 0 {
-				percent = (float64(stat.Flat) / float64(totalValue)) * 100
+		percentOf := func(value int64) string {
+			if totalValue == 0 {
+				return percentString(0)
 			}
-			objStr := ""
-			if count, ok := funcObjects[stat.Name]; ok && count > 0 {
-				objStr = fmt.Sprintf(" (%d objects)", count)
-			}
-			b.WriteString(fmt.Sprintf("%-15s %-15.2f %s%s\n",
-				FormatBytes(stat.Flat), percent, stat.Name, objStr))
+			return percentString(float64(value) / float64(totalValue) * 100)
 		}
 
-		// Output by allocation site
-		b.WriteString("\n=== By Allocation Site ===\n")
-		b.WriteString("--------------------------------------------------\n")
-		b.WriteString(fmt.Sprintf("%-15s %-15s %s\n", valueType, "%", "Allocation Site"))
-		b.WriteString("--------------------------------------------------\n")
-		for i := 0; i < allocSiteLimit; i++ {
-			stat := allocSiteStats[i]
-			percent := 0.0
-			if totalValue != 0 {
-				percent = (float64(stat.Value) / float64(totalValue)) * 100
-			}
-			objStr := ""
-			if stat.Count > 0 {
-				objStr = fmt.Sprintf(" (%d objects)", stat.Count)
-			}
-			b.WriteString(fmt.Sprintf("%-15s %-15.2f %s%s\n",
-				FormatBytes(stat.Value), percent, stat.Site, objStr))
-		}
+		// Output by function and by allocation site
+		w.heading("By Function")
+		w.table(memoryStatTable(valueType, "Function Name", funcStats[:limit], totalValue))
+		w.heading("By Allocation Site")
+		w.table(memoryStatTable(valueType, "Allocation Site", allocSiteStats[:allocSiteLimit], totalValue))
+		writeAllocSiteClusters(w, valueType, clusters)
 
 		if len(typeStats) > 0 && typeStats[0].Type != "unknown" {
-			b.WriteString("\n=== By Type ===\n")
-			b.WriteString("--------------------------------------------------\n")
-			b.WriteString(fmt.Sprintf("%-15s %-15s %-15s %s\n", valueType, "%", "Avg Size", "Type"))
-			b.WriteString("--------------------------------------------------\n")
+			w.heading("By Type")
+			t := newTable(valueColumn(valueType), valueColumn("%"), valueColumn("Avg Size"), valueColumn("Objects"), nameColumn("Type"))
 			for i := 0; i < typeLimit; i++ {
 				stat := typeStats[i]
-				percent := 0.0
-				if totalValue != 0 {
-					percent = (float64(stat.Value) / float64(totalValue)) * 100
-				}
-
 				avgSize := int64(0)
 				if stat.Count > 0 {
 					avgSize = stat.Value / stat.Count
 				}
-
-				b.WriteString(fmt.Sprintf("%-15s %-15.2f %-15s %s (%d objects)\n",
-					FormatBytes(stat.Value), percent, FormatBytes(avgSize), stat.Type, stat.Count))
+				t.add(FormatBytes(stat.Value), percentOf(stat.Value), FormatBytes(avgSize), FormatSampleValue(stat.Count, "count"), stat.Type)
 			}
+			w.table(t)
 		}
-		if format == "markdown" {
-			b.WriteString("```\n")
+
+		writeDuplicateFindings(w, duplicateFindings)
+
+		if len(retained) > 0 {
+			w.heading("Long-lived Objects (Survival >= %.0f%%, Retention Candidates)", RetentionSurvivalThreshold*100)
+			t := newTable(valueColumn("inuse_space"), valueColumn("Survival"), tableColumn{title: "Inuse/Alloc Objects", kind: columnValue, width: 20}, nameColumn("Allocation Site"))
+			for _, stat := range retained {
+				t.add(stat.InuseBytesFormatted, fmt.Sprintf("%.1f%%", stat.SurvivalRatio*100),
+					FormatCount(stat.InuseObjects)+"/"+FormatCount(stat.AllocObjects), stat.Site)
+			}
+			w.table(t)
 		}
+		return w.String(), nil
 	case "json":
 
 		result := struct {
-			ProfileType         string             `json:"profileType"`
-			ValueType           string             `json:"valueType"`
-			ValueUnit           string             `json:"valueUnit"`
-			TotalValue          int64              `json:"totalValue"`
-			TotalValueFormatted string             `json:"totalValueFormatted"`
-			TotalObjects        int64              `json:"totalObjects,omitempty"`
-			TopN                int                `json:"topN"`
-			Functions           []HeapFunctionStat `json:"functions"`
-			AllocationSites     []AllocSiteStat    `json:"allocationSites,omitempty"`
-			Types               []TypeStat         `json:"types,omitempty"`
+			ProfileType         string                  `json:"profileType"`
+			ValueType           string                  `json:"valueType"`
+			ValueUnit           string                  `json:"valueUnit"`
+			TotalValue          int64                   `json:"totalValue"`
+			TotalValueFormatted string                  `json:"totalValueFormatted"`
+			TotalObjects        int64                   `json:"totalObjects,omitempty"`
+			TopN                int                     `json:"topN"`
+			Functions           []HeapFunctionStat      `json:"functions"`
+			AllocationSites     []AllocSiteStat         `json:"allocationSites,omitempty"`
+			AllocationClusters  []AllocSiteCluster      `json:"allocationClusters,omitempty"`
+			Types               []TypeStat              `json:"types,omitempty"`
+			RetentionCandidates []SurvivalStat          `json:"retentionCandidates,omitempty"`
+			DuplicateFindings   []DuplicateAllocFinding `json:"duplicateFindings,omitempty"`
 		}{
 			ProfileType:         "heap",
 			ValueType:           valueType,
@@ -291,6 +230,9 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 			TotalValueFormatted: FormatBytes(totalValue), // 使用导出的 FormatBytes
 			TopN:                limit,
 			Functions:           make([]HeapFunctionStat, 0, limit),
+			RetentionCandidates: retained,
+			AllocationClusters:  clusters,
+			DuplicateFindings:   duplicateFindings,
 		}
 
 		if totalObjects > 0 {
@@ -320,19 +262,19 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 				stat := allocSiteStats[i]
 				percent := 0.0
 				if totalValue != 0 {
-					percent = (float64(stat.Value) / float64(totalValue)) * 100
+					percent = (float64(stat.Flat) / float64(totalValue)) * 100
 				}
 
 				siteStat := AllocSiteStat{
-					Site:           stat.Site,
-					Value:          stat.Value,
-					ValueFormatted: FormatBytes(stat.Value),
+					Site:           stat.Name,
+					Value:          stat.Flat,
+					ValueFormatted: FormatBytes(stat.Flat),
 					Percentage:     percent,
 				}
 
-				if stat.Count > 0 {
-					siteStat.ObjectCount = stat.Count
-					avgSize := stat.Value / stat.Count
+				if stat.Objects > 0 {
+					siteStat.ObjectCount = stat.Objects
+					avgSize := stat.Flat / stat.Objects
 					siteStat.AvgSize = avgSize
 					siteStat.AvgSizeFormatted = FormatBytes(avgSize)
 				}
@@ -381,7 +323,7 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 		log.Printf("Generating flame graph JSON for Heap profile (%s) using value index %d", valueType, valueIndex)
 		// BuildFlameGraphTree will automatically detect this is a memory profile and find the objectsIndex
 		// based on the valueType and valueUnit
-		flameGraphRoot, err := BuildFlameGraphTree(p, valueIndex)
+		flameGraphRoot, err := o.flameGraphTree(p, valueIndex)
 		if err != nil {
 			log.Printf("Error building flame graph tree for heap: %v", err)
 			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to build flame graph tree for heap: %v", err)}
@@ -399,6 +341,21 @@ func AnalyzeHeapProfile(p *profile.Profile, topN int, format string) (string, er
 	default:
 		return "", fmt.Errorf("unsupported output format: %s", format)
 	}
+}
 
-	return b.String(), nil
+// memoryStatTable 返回堆和分配分析中按函数或分配点统计的表格；没有对象数时 Objects 列为空。
+func memoryStatTable(valueType, nameTitle string, stats []aggregate.Stat, totalValue int64) *table {
+	t := newTable(valueColumn(valueType), valueColumn("%"), valueColumn("Objects"), nameColumn(nameTitle))
+	for _, stat := range stats {
+		percent := 0.0
+		if totalValue != 0 {
+			percent = float64(stat.Flat) / float64(totalValue) * 100
+		}
+		objects := ""
+		if stat.Objects > 0 {
+			objects = FormatSampleValue(stat.Objects, "count")
+		}
+		t.add(FormatBytes(stat.Flat), percentString(percent), objects, stat.Name)
+	}
+	return t
 }
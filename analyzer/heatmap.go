@@ -0,0 +1,127 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// HeatmapResult is a function × snapshot matrix for rendering heatmaps of hotspot evolution over time.
+// Values[i][j] is the flat value of Functions[i] in Snapshots[j]; Normalized[i][j] is that value divided by
+// the column total, so snapshots of different durations or sizes can be compared.
+type HeatmapResult struct {
+	SampleType   string      `json:"sampleType"`
+	Unit         string      `json:"unit"`
+	Snapshots    []string    `json:"snapshots"`
+	Functions    []string    `json:"functions"`
+	ColumnTotals []int64     `json:"columnTotals"`
+	Values       [][]int64   `json:"values"`
+	Normalized   [][]float64 `json:"normalized"`
+}
+
+// BuildHeatmap aggregates the flat (leaf) value of the given sample type per function in every snapshot and
+// keeps the topN functions with the highest normalized value in any snapshot. Snapshots missing the sample
+// type are an error.
+func BuildHeatmap(snapshots []*profile.Profile, labels []string, sampleType string, topN int) (*HeatmapResult, error) {
+	if len(snapshots) != len(labels) {
+		return nil, fmt.Errorf("got %d snapshots but %d labels", len(snapshots), len(labels))
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshots to build a heatmap from")
+	}
+
+	unit := ""
+	flat := make([]map[string]int64, len(snapshots))
+	totals := make([]int64, len(snapshots))
+	for j, p := range snapshots {
+		valueIndex, err := sampleValueIndex(p, sampleType)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot '%s': %w", labels[j], err)
+		}
+		if sampleType == "" {
+			sampleType = p.SampleType[valueIndex].Type // The first snapshot decides the default
+		}
+		unit = p.SampleType[valueIndex].Unit
+		flat[j] = make(map[string]int64)
+		for _, s := range p.Sample {
+			if len(s.Value) <= valueIndex {
+				continue
+			}
+			name := "unknown"
+			if names := sampleFunctions(s); len(names) > 0 {
+				name = names[0]
+			}
+			flat[j][name] += s.Value[valueIndex]
+			totals[j] += s.Value[valueIndex]
+		}
+	}
+
+	// Rank functions by their peak share of any snapshot, so short-lived hotspots are kept as well
+	peak := make(map[string]float64)
+	for j := range snapshots {
+		for name, v := range flat[j] {
+			share := 0.0
+			if totals[j] != 0 {
+				share = float64(v) / float64(totals[j])
+			}
+			if current, ok := peak[name]; !ok || share > current {
+				peak[name] = share
+			}
+		}
+	}
+	functions := make([]string, 0, len(peak))
+	for name := range peak {
+		functions = append(functions, name)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if peak[functions[i]] != peak[functions[j]] {
+			return peak[functions[i]] > peak[functions[j]]
+		}
+		return functions[i] < functions[j]
+	})
+	if topN > 0 && len(functions) > topN {
+		functions = functions[:topN]
+	}
+
+	result := &HeatmapResult{
+		SampleType:   sampleType,
+		Unit:         unit,
+		Snapshots:    labels,
+		Functions:    functions,
+		ColumnTotals: totals,
+		Values:       make([][]int64, len(functions)),
+		Normalized:   make([][]float64, len(functions)),
+	}
+	for i, name := range functions {
+		result.Values[i] = make([]int64, len(snapshots))
+		result.Normalized[i] = make([]float64, len(snapshots))
+		for j := range snapshots {
+			v := flat[j][name]
+			result.Values[i][j] = v
+			if totals[j] != 0 {
+				result.Normalized[i][j] = float64(v) / float64(totals[j])
+			}
+		}
+	}
+	return result, nil
+}
+
+// FormatHeatmapJSON builds a heatmap (see BuildHeatmap) and serializes it as JSON.
+func FormatHeatmapJSON(snapshots []*profile.Profile, labels []string, sampleType string, topN int) (string, error) {
+	log.Printf("Building heatmap over %d snapshots (SampleType: %s, Top %d)", len(snapshots), sampleType, topN)
+	result, err := BuildHeatmap(snapshots, labels, sampleType, topN)
+	if err != nil {
+		return "", err
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error marshaling heatmap to JSON: %v", err)
+		errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+		errJsonBytes, _ := json.Marshal(errorResult)
+		return string(errJsonBytes), nil
+	}
+	return string(jsonBytes), nil
+}
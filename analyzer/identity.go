@@ -0,0 +1,212 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// maxIdentityExamples limits the example stacks listed per kind of difference.
+const maxIdentityExamples = 3
+
+// maxExampleFrames limits the frames shown per example stack.
+const maxExampleFrames = 4
+
+// ProfileIdentity tells whether two profiles are the same, byte for byte or after normalization.
+type ProfileIdentity struct {
+	ByteIdentical         bool     `json:"byteIdentical"` // Set by callers comparing the files, see ProfileURI/OtherURI
+	ProfileURI            string   `json:"profileUri,omitempty"`
+	OtherURI              string   `json:"otherUri,omitempty"`
+	Digest                string   `json:"digest,omitempty"`
+	OtherDigest           string   `json:"otherDigest,omitempty"`
+	SemanticallyIdentical bool     `json:"semanticallyIdentical"`
+	Differences           []string `json:"differences,omitempty"` // Why the profiles are not semantically identical
+	IgnoredDifferences    []string `json:"ignoredDifferences,omitempty"`
+}
+
+// normalizedSamples sums the sample values per normalized sample: the stack as function:line frames (addresses
+// for unsymbolized frames) plus the sorted labels. IDs, sample order and how samples are split do not matter.
+func normalizedSamples(p *profile.Profile) map[string][]int64 {
+	samples := make(map[string][]int64)
+	for _, s := range p.Sample {
+		frames := make([]string, 0, len(s.Location))
+		for _, loc := range s.Location {
+			if len(loc.Line) == 0 {
+				frames = append(frames, fmt.Sprintf("0x%x", loc.Address))
+				continue
+			}
+			for _, line := range loc.Line {
+				name := "?"
+				if line.Function != nil {
+					name = line.Function.Name
+				}
+				frames = append(frames, fmt.Sprintf("%s:%d", name, line.Line))
+			}
+		}
+		labels := make([]string, 0, len(s.Label)+len(s.NumLabel))
+		for key, values := range s.Label {
+			labels = append(labels, fmt.Sprintf("%s=%s", key, strings.Join(values, ",")))
+		}
+		for key, values := range s.NumLabel {
+			labels = append(labels, fmt.Sprintf("%s=%v%v", key, values, s.NumUnit[key]))
+		}
+		sort.Strings(labels)
+		key := strings.Join(frames, ";") + "|" + strings.Join(labels, ",")
+
+		sum, ok := samples[key]
+		if !ok {
+			sum = make([]int64, len(s.Value))
+			samples[key] = sum
+		}
+		for i, v := range s.Value {
+			if i < len(sum) {
+				sum[i] += v
+			}
+		}
+	}
+	return samples
+}
+
+func sampleTypeNames(p *profile.Profile) string {
+	names := make([]string, len(p.SampleType))
+	for i, st := range p.SampleType {
+		names[i] = st.Type + "/" + st.Unit
+	}
+	return strings.Join(names, ", ")
+}
+
+// exampleStack returns a normalized sample key for messages: the stack root first, like the other stack
+// outputs, without line numbers and shortened to the frames nearest the leaf.
+func exampleStack(key string) string {
+	stack := strings.SplitN(key, "|", 2)[0]
+	frames := strings.Split(stack, ";")
+	shown := make([]string, 0, maxExampleFrames+1)
+	for i := len(frames) - 1; i >= 0; i-- {
+		frame := frames[i]
+		if colon := strings.LastIndex(frame, ":"); colon > 0 {
+			frame = frame[:colon]
+		}
+		shown = append(shown, frame)
+	}
+	if len(shown) > maxExampleFrames {
+		shown = append([]string{"..."}, shown[len(shown)-maxExampleFrames:]...)
+	}
+	return strings.Join(shown, ";")
+}
+
+// CompareProfileIdentity tells whether two profiles are semantically identical: the same sample types, period
+// and samples after normalization (see normalizedSamples). Capture time, duration and comments are ignored but
+// reported, so two captures of an idle process with identical stacks still count as the same profile.
+func CompareProfileIdentity(p, other *profile.Profile) *ProfileIdentity {
+	result := &ProfileIdentity{Differences: make([]string, 0), IgnoredDifferences: make([]string, 0)}
+
+	if a, b := sampleTypeNames(p), sampleTypeNames(other); a != b {
+		result.Differences = append(result.Differences, fmt.Sprintf("sample types differ: [%s] vs [%s]", a, b))
+	}
+	if p.Period != other.Period {
+		result.Differences = append(result.Differences, fmt.Sprintf("periods differ: %d vs %d", p.Period, other.Period))
+	}
+	if p.TimeNanos != other.TimeNanos {
+		result.IgnoredDifferences = append(result.IgnoredDifferences, "capture times differ")
+	}
+	if p.DurationNanos != other.DurationNanos {
+		result.IgnoredDifferences = append(result.IgnoredDifferences, "durations differ")
+	}
+	if strings.Join(p.Comments, "\n") != strings.Join(other.Comments, "\n") {
+		result.IgnoredDifferences = append(result.IgnoredDifferences, "comments differ")
+	}
+
+	if len(result.Differences) == 0 {
+		samples, otherSamples := normalizedSamples(p), normalizedSamples(other)
+		var onlyProfile, onlyOther, changed []string
+		for key, values := range samples {
+			otherValues, ok := otherSamples[key]
+			if !ok {
+				onlyProfile = append(onlyProfile, key)
+				continue
+			}
+			for i := range values {
+				if values[i] != otherValues[i] {
+					changed = append(changed, key)
+					break
+				}
+			}
+		}
+		for key := range otherSamples {
+			if _, ok := samples[key]; !ok {
+				onlyOther = append(onlyOther, key)
+			}
+		}
+		for _, d := range []struct {
+			what string
+			keys []string
+		}{
+			{"stacks only in the profile", onlyProfile},
+			{"stacks only in the other profile", onlyOther},
+			{"stacks with different values", changed},
+		} {
+			if len(d.keys) == 0 {
+				continue
+			}
+			sort.Strings(d.keys)
+			examples := make([]string, 0, maxIdentityExamples)
+			for i := 0; i < len(d.keys) && i < maxIdentityExamples; i++ {
+				examples = append(examples, exampleStack(d.keys[i]))
+			}
+			result.Differences = append(result.Differences, fmt.Sprintf("%d %s (e.g. %s)", len(d.keys), d.what, strings.Join(examples, " | ")))
+		}
+	}
+	result.SemanticallyIdentical = len(result.Differences) == 0
+	return result
+}
+
+// FormatProfileIdentity formats an identity check as "text", "markdown" or "json".
+func FormatProfileIdentity(r *ProfileIdentity, format string) (string, error) {
+	switch format {
+	case "text", "markdown":
+		var b strings.Builder
+		if format == "markdown" {
+			b.WriteString("```text\n")
+		}
+		switch {
+		case r.ByteIdentical:
+			b.WriteString("Same profile: the files are byte-identical.\n")
+		case r.SemanticallyIdentical:
+			b.WriteString("Same profile: the files differ, but their samples are identical after normalization.\n")
+		default:
+			b.WriteString("Different profiles.\n")
+		}
+		if r.ProfileURI != "" {
+			b.WriteString(fmt.Sprintf("  Profile: %s (SHA256 %.12s)\n", r.ProfileURI, r.Digest))
+			b.WriteString(fmt.Sprintf("  Other:   %s (SHA256 %.12s)\n", r.OtherURI, r.OtherDigest))
+		}
+		for _, d := range r.Differences {
+			b.WriteString("  - " + d + "\n")
+		}
+		if len(r.IgnoredDifferences) > 0 {
+			b.WriteString("Ignored: " + strings.Join(r.IgnoredDifferences, ", ") + "\n")
+		}
+		if r.SemanticallyIdentical {
+			b.WriteString("Comparing or diffing these profiles would show no changes.\n")
+		}
+		if format == "markdown" {
+			b.WriteString("```\n")
+		}
+		return b.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling profile identity to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
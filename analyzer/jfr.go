@@ -0,0 +1,245 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// JFREvents are the Java Flight Recorder events ParseJFRJSON converts: CPU samples and the three kinds of
+// allocation events (sampled allocations since JDK 16, and allocations in a new TLAB or outside of one).
+var JFREvents = []string{"jdk.ExecutionSample", "jdk.ObjectAllocationSample", "jdk.ObjectAllocationInNewTLAB", "jdk.ObjectAllocationOutsideTLAB"}
+
+// jfrEvent is an event of 'jfr print --json', with the fields ParseJFRJSON uses.
+type jfrEvent struct {
+	Type   string `json:"type"`
+	Values struct {
+		StartTime      string     `json:"startTime"`
+		SampledThread  *jfrThread `json:"sampledThread"`
+		EventThread    *jfrThread `json:"eventThread"`
+		ObjectClass    *jfrClass  `json:"objectClass"`
+		Weight         int64      `json:"weight"`         // jdk.ObjectAllocationSample
+		TLABSize       int64      `json:"tlabSize"`       // jdk.ObjectAllocationInNewTLAB
+		AllocationSize int64      `json:"allocationSize"` // jdk.ObjectAllocation*TLAB
+		StackTrace     *struct {
+			Truncated bool       `json:"truncated"`
+			Frames    []jfrFrame `json:"frames"`
+		} `json:"stackTrace"`
+	} `json:"values"`
+}
+
+type jfrThread struct {
+	JavaName string `json:"javaName"`
+	OSName   string `json:"osName"`
+}
+
+type jfrClass struct {
+	Name string `json:"name"`
+}
+
+type jfrFrame struct {
+	Method struct {
+		Type jfrClass `json:"type"`
+		Name string   `json:"name"`
+	} `json:"method"`
+	LineNumber int64  `json:"lineNumber"`
+	Type       string `json:"type"` // "Interpreted", "JIT compiled", "Inlined" or "Native"
+}
+
+// IsJFRJSON reports whether data is the output of 'jfr print --json': an object whose first key is
+// "recording".
+func IsJFRJSON(data []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return false
+	}
+	t, err := dec.Token()
+	return err == nil && t == "recording"
+}
+
+// jfrClassName returns a class name in Java's dotted form; 'jfr print' may print the internal form.
+func jfrClassName(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// jfrConverter builds a profile from JFR events, deduplicating functions and locations.
+type jfrConverter struct {
+	p         *profile.Profile
+	functions map[string]*profile.Function
+	locations map[string]*profile.Location
+}
+
+// location returns the location of a stack frame: its method, named "package.Class.method" like Java stack
+// traces print it, at its line.
+func (c *jfrConverter) location(f jfrFrame) *profile.Location {
+	name := jfrClassName(f.Method.Type.Name) + "." + f.Method.Name
+	key := fmt.Sprintf("%s:%d", name, f.LineNumber)
+	if loc, ok := c.locations[key]; ok {
+		return loc
+	}
+	fn, ok := c.functions[name]
+	if !ok {
+		fn = &profile.Function{ID: uint64(len(c.p.Function) + 1), Name: name, SystemName: name}
+		c.p.Function = append(c.p.Function, fn)
+		c.functions[name] = fn
+	}
+	loc := &profile.Location{ID: uint64(len(c.p.Location) + 1), Line: []profile.Line{{Function: fn, Line: f.LineNumber}}}
+	c.p.Location = append(c.p.Location, loc)
+	c.locations[key] = loc
+	return loc
+}
+
+// ParseJFRJSON converts Java Flight Recorder events printed by 'jfr print --json' (see JFREvents) into a
+// pprof profile with three sample types: "samples" counts the CPU samples (jdk.ExecutionSample), and
+// "alloc_objects" and "alloc_space" the sampled allocations and their weight in bytes (the sample weight, the
+// new TLAB's size or the allocation's size), so the cpu and allocs analyses work on Java recordings. The
+// allocated class becomes the "type" label of allocation samples and the thread the "thread" label of every
+// sample; stacks are leaf first, as JFR records them. Other events are ignored.
+func ParseJFRJSON(r io.Reader) (*profile.Profile, error) {
+	c := &jfrConverter{
+		p: &profile.Profile{
+			SampleType: []*profile.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: "alloc_objects", Unit: "count"},
+				{Type: "alloc_space", Unit: "bytes"},
+			},
+		},
+		functions: make(map[string]*profile.Function),
+		locations: make(map[string]*profile.Location),
+	}
+
+	// The output of long recordings is large: decode the events one by one
+	dec := json.NewDecoder(r)
+	expect := func(want json.Delim) error {
+		t, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("invalid jfr print --json output: %w", err)
+		}
+		if t != want {
+			return fmt.Errorf("invalid jfr print --json output: expected '%s', got %v", want, t)
+		}
+		return nil
+	}
+	// skipTo reads the keys of the current object up to key, skipping the values of the others
+	skipTo := func(key string) error {
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return fmt.Errorf("invalid jfr print --json output: %w", err)
+			}
+			if t == key {
+				return nil
+			}
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return fmt.Errorf("invalid jfr print --json output: %w", err)
+			}
+		}
+		return fmt.Errorf("invalid jfr print --json output: no '%s'", key)
+	}
+	if err := expect('{'); err != nil {
+		return nil, err
+	}
+	if err := skipTo("recording"); err != nil {
+		return nil, err
+	}
+	if err := expect('{'); err != nil {
+		return nil, err
+	}
+	if err := skipTo("events"); err != nil {
+		return nil, err
+	}
+	if err := expect('['); err != nil {
+		return nil, err
+	}
+
+	var first, last time.Time
+	cpuSamples, allocSamples, truncated, ignored := 0, 0, 0, 0
+	for dec.More() {
+		var e jfrEvent
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("invalid event in jfr print --json output: %w", err)
+		}
+		value := make([]int64, 3)
+		thread := e.Values.EventThread
+		switch e.Type {
+		case "jdk.ExecutionSample":
+			value[0] = 1
+			thread = e.Values.SampledThread
+			cpuSamples++
+		case "jdk.ObjectAllocationSample":
+			value[1], value[2] = 1, e.Values.Weight
+			allocSamples++
+		case "jdk.ObjectAllocationInNewTLAB":
+			value[1], value[2] = 1, e.Values.TLABSize
+			allocSamples++
+		case "jdk.ObjectAllocationOutsideTLAB":
+			value[1], value[2] = 1, e.Values.AllocationSize
+			allocSamples++
+		default:
+			ignored++
+			continue
+		}
+		if e.Values.StackTrace == nil || len(e.Values.StackTrace.Frames) == 0 {
+			ignored++
+			continue
+		}
+		if e.Values.StackTrace.Truncated {
+			truncated++
+		}
+
+		s := &profile.Sample{Value: value, Label: make(map[string][]string)}
+		for _, f := range e.Values.StackTrace.Frames {
+			s.Location = append(s.Location, c.location(f))
+		}
+		if thread != nil {
+			name := thread.JavaName
+			if name == "" {
+				name = thread.OSName
+			}
+			if name != "" {
+				s.Label["thread"] = []string{name}
+			}
+		}
+		if e.Values.ObjectClass != nil && value[1] > 0 {
+			s.Label["type"] = []string{jfrClassName(e.Values.ObjectClass.Name)}
+		}
+		c.p.Sample = append(c.p.Sample, s)
+
+		if t, err := time.Parse(time.RFC3339Nano, e.Values.StartTime); err == nil {
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+	}
+	if len(c.p.Sample) == 0 {
+		return nil, fmt.Errorf("no CPU or allocation samples with stack traces found in the JFR recording (events: %s)", strings.Join(JFREvents, ", "))
+	}
+
+	c.p.DefaultSampleType = "samples"
+	if cpuSamples == 0 {
+		c.p.DefaultSampleType = "alloc_space"
+	}
+	if !first.IsZero() {
+		c.p.TimeNanos = first.UnixNano()
+		c.p.DurationNanos = last.Sub(first).Nanoseconds()
+	}
+	if truncated > 0 {
+		c.p.Comments = append(c.p.Comments, fmt.Sprintf("%d stack traces were truncated by the recording's stack depth", truncated))
+	}
+	log.Printf("Parsed JFR recording: %d CPU samples, %d allocation samples, %d functions (%d events ignored, %d truncated stacks)",
+		cpuSamples, allocSamples, len(c.p.Function), ignored, truncated)
+	if err := c.p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid profile built from the JFR recording: %w", err)
+	}
+	return c.p, nil
+}
@@ -0,0 +1,309 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// noLabelValue groups the samples without the label passed to BuildLabelBreakdown.
+const noLabelValue = "(no label)"
+
+// TagCondition is one condition of a tag filter: the sample must (or, with Negate, must not) carry the label
+// Key with a value fully matching Value.
+type TagCondition struct {
+	Key    string
+	Value  *regexp.Regexp
+	Negate bool
+}
+
+// ParseTagFilter parses a tag filter: comma-separated "key=regex" conditions, all of which must hold, such as
+// "handler=/api/foo,region=eu-.*". "key!=regex" drops samples whose label matches instead. The regex must match
+// the whole label value; numeric labels (pprof.NumLabel) are matched in their formatted form, e.g. "4096 bytes"
+// or just "4096" without a unit. An empty filter has no conditions.
+func ParseTagFilter(expr string) ([]TagCondition, error) {
+	conditions := make([]TagCondition, 0)
+	if strings.TrimSpace(expr) == "" {
+		return conditions, nil
+	}
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag filter condition '%s': expected key=regex or key!=regex", part)
+		}
+		negate := strings.HasSuffix(key, "!")
+		key = strings.TrimSpace(strings.TrimSuffix(key, "!"))
+		if key == "" {
+			return nil, fmt.Errorf("invalid tag filter condition '%s': missing label key", part)
+		}
+		rx, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in tag filter condition '%s': %w", part, err)
+		}
+		conditions = append(conditions, TagCondition{Key: key, Value: rx, Negate: negate})
+	}
+	return conditions, nil
+}
+
+// sampleLabelValues returns the values of a sample's string or numeric label key, numeric values formatted
+// with their unit.
+func sampleLabelValues(s *profile.Sample, key string) []string {
+	values := append([]string(nil), s.Label[key]...)
+	for i, n := range s.NumLabel[key] {
+		unit := ""
+		if units := s.NumUnit[key]; i < len(units) {
+			unit = units[i]
+		}
+		if unit == "" {
+			values = append(values, fmt.Sprintf("%d", n))
+		} else {
+			values = append(values, fmt.Sprintf("%d %s", n, unit))
+		}
+	}
+	return values
+}
+
+// matches reports whether a sample satisfies one condition.
+func (c TagCondition) matches(s *profile.Sample) bool {
+	matched := false
+	for _, value := range sampleLabelValues(s, c.Key) {
+		// "4096 bytes" also matches a regex written for the bare number
+		number, _, _ := strings.Cut(value, " ")
+		if c.Value.MatchString(value) || c.Value.MatchString(number) {
+			matched = true
+			break
+		}
+	}
+	return matched != c.Negate
+}
+
+// filterByTags keeps the samples of p matching every condition, in place.
+func filterByTags(p *profile.Profile, conditions []TagCondition) {
+	if len(conditions) == 0 {
+		return
+	}
+	kept := p.Sample[:0]
+	for _, s := range p.Sample {
+		keep := true
+		for _, c := range conditions {
+			if !c.matches(s) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			kept = append(kept, s)
+		}
+	}
+	p.Sample = kept
+}
+
+// LabelKeys returns the string and numeric label keys present in a profile, sorted.
+func LabelKeys(p *profile.Profile) []string {
+	seen := make(map[string]bool)
+	for _, s := range p.Sample {
+		for key := range s.Label {
+			seen[key] = true
+		}
+		for key := range s.NumLabel {
+			seen[key] = true
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// LabelGroup is the share of one label value and its top functions.
+type LabelGroup struct {
+	Value          string              `json:"value"` // The label value, or "(no label)"
+	Total          int64               `json:"total"`
+	TotalFormatted string              `json:"totalFormatted"`
+	Percentage     float64             `json:"percentage"`
+	Samples        int                 `json:"samples"`
+	Functions      []LabelFunctionStat `json:"functions"` // Top N functions by flat value within the group
+}
+
+// LabelFunctionStat is the flat value of one function within a label value.
+type LabelFunctionStat struct {
+	FunctionName   string  `json:"functionName"`
+	Value          int64   `json:"value"`
+	ValueFormatted string  `json:"valueFormatted"`
+	Percentage     float64 `json:"percentage"` // Share of the label value's total
+}
+
+// LabelBreakdown is a profile broken down by the values of one label (see BuildLabelBreakdown).
+type LabelBreakdown struct {
+	ProfileType         string       `json:"profileType"`
+	Label               string       `json:"label"`
+	ValueType           string       `json:"valueType"`
+	ValueUnit           string       `json:"valueUnit"`
+	TotalValue          int64        `json:"totalValue"`
+	TotalValueFormatted string       `json:"totalValueFormatted"`
+	Groups              []LabelGroup `json:"groups"`             // Sorted by total, largest first
+	AvailableLabels     []string     `json:"availableLabels"`    // Label keys present in the profile
+	Unlabeled           int          `json:"unlabeledSamples"`   // Samples without the label
+	MultiValued         int          `json:"multiValuedSamples"` // Samples with several values, counted in each
+}
+
+// BuildLabelBreakdown groups the samples of p by the values of label and lists the topN functions (by flat
+// value at valueIndex) of each value. Samples without the label form a "(no label)" group; a sample with
+// several values for the label counts in each of them.
+func BuildLabelBreakdown(p *profile.Profile, profileType, label string, valueIndex, topN int) *LabelBreakdown {
+	type group struct {
+		total   int64
+		samples int
+		flat    map[string]int64
+	}
+	groups := make(map[string]*group)
+	sampleType := p.SampleType[valueIndex]
+	report := &LabelBreakdown{
+		ProfileType:     profileType,
+		Label:           label,
+		ValueType:       sampleType.Type,
+		ValueUnit:       sampleType.Unit,
+		Groups:          make([]LabelGroup, 0),
+		AvailableLabels: LabelKeys(p),
+	}
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= valueIndex {
+			continue
+		}
+		v := s.Value[valueIndex]
+		report.TotalValue += v
+		values := sampleLabelValues(s, label)
+		if len(values) == 0 {
+			values = []string{noLabelValue}
+			report.Unlabeled++
+		} else if len(values) > 1 {
+			report.MultiValued++
+		}
+		leaf := ""
+		if names := sampleFunctions(s); len(names) > 0 {
+			leaf = names[0]
+		}
+		for _, value := range values {
+			g, ok := groups[value]
+			if !ok {
+				g = &group{flat: make(map[string]int64)}
+				groups[value] = g
+			}
+			g.total += v
+			g.samples++
+			if leaf != "" {
+				g.flat[leaf] += v
+			}
+		}
+	}
+	report.TotalValueFormatted = FormatSampleValue(report.TotalValue, sampleType.Unit)
+
+	for value, g := range groups {
+		functions := make([]LabelFunctionStat, 0, len(g.flat))
+		for name, flat := range g.flat {
+			functions = append(functions, LabelFunctionStat{
+				FunctionName:   name,
+				Value:          flat,
+				ValueFormatted: FormatSampleValue(flat, sampleType.Unit),
+				Percentage:     percentOf(flat, g.total),
+			})
+		}
+		sort.Slice(functions, func(i, j int) bool {
+			if functions[i].Value != functions[j].Value {
+				return functions[i].Value > functions[j].Value
+			}
+			return functions[i].FunctionName < functions[j].FunctionName
+		})
+		if len(functions) > topN {
+			functions = functions[:topN]
+		}
+		report.Groups = append(report.Groups, LabelGroup{
+			Value:          value,
+			Total:          g.total,
+			TotalFormatted: FormatSampleValue(g.total, sampleType.Unit),
+			Percentage:     percentOf(g.total, report.TotalValue),
+			Samples:        g.samples,
+			Functions:      functions,
+		})
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Total != report.Groups[j].Total {
+			return report.Groups[i].Total > report.Groups[j].Total
+		}
+		return report.Groups[i].Value < report.Groups[j].Value
+	})
+	return report
+}
+
+// percentOf returns part as a percentage of total, or 0 for an empty total.
+func percentOf(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// analyzeByLabel is the group_by_label mode of Analyze (see WithGroupByLabel).
+func analyzeByLabel(p *profile.Profile, profileType string, o Options) (string, error) {
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	log.Printf("Analyzing %s profile by label '%s' (SampleType: %s, Top %d per value)", profileType, o.GroupByLabel, p.SampleType[valueIndex].Type, o.TopN)
+	report := BuildLabelBreakdown(p, profileType, o.GroupByLabel, valueIndex, o.TopN)
+	return FormatLabelBreakdown(report, o.Format)
+}
+
+// FormatLabelBreakdown formats a label breakdown as "text", "markdown" (also used for "markdown-compact") or "json".
+func FormatLabelBreakdown(report *LabelBreakdown, format string) (string, error) {
+	switch format {
+	case "text", "markdown", "markdown-compact":
+		w := newReportWriter(format)
+		w.title("%s Profile by Label '%s' (%s, %d values)", strings.ToUpper(report.ProfileType), report.Label, report.ValueType, len(report.Groups))
+		w.line("Total %s: %s", report.ValueType, report.TotalValueFormatted)
+		if len(report.Groups) == 1 && report.Groups[0].Value == noLabelValue {
+			available := "none"
+			if len(report.AvailableLabels) > 0 {
+				available = strings.Join(report.AvailableLabels, ", ")
+			}
+			w.line("No sample carries the label '%s' (labels in this profile: %s).", report.Label, available)
+		}
+		if report.MultiValued > 0 {
+			w.line("Note: %s samples have several values for '%s' and count towards each of them.", FormatCount(int64(report.MultiValued)), report.Label)
+		}
+		for _, g := range report.Groups {
+			w.heading("%s=%s: %s (%.2f%%, %s samples)", report.Label, g.Value, g.TotalFormatted, g.Percentage, FormatCount(int64(g.Samples)))
+			t := newTable(valueColumn(report.ValueType), valueColumn("%"), nameColumn("Function Name"))
+			for _, fn := range g.Functions {
+				t.add(fn.ValueFormatted, percentString(fn.Percentage), fn.FunctionName)
+			}
+			w.table(t)
+		}
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling label breakdown to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format for group_by_label: %s (supported: text, markdown, markdown-compact, json)", format)
+	}
+}
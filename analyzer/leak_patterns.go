@@ -0,0 +1,395 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// leakPattern describes a well-known resource leak shape recognizable from stack frames.
+type leakPattern struct {
+	Description string
+	// GoroutineFrames are function name prefixes identifying goroutines belonging to the pattern.
+	GoroutineFrames []string
+	// HeapFrames are function name prefixes identifying allocations belonging to the pattern.
+	HeapFrames []string
+	// InternalPrefixes are skipped when looking for the user call site responsible for a sample.
+	InternalPrefixes []string
+	// HighGoroutineCount flags a single snapshot with at least this many matching goroutines.
+	HighGoroutineCount int64
+	Recommendations    []string
+}
+
+// leakPatterns is the registry of supported leak pattern detectors, keyed by name.
+var leakPatterns = map[string]leakPattern{
+	"context": {
+		Description: "context.WithCancel/WithTimeout without cancel()",
+		GoroutineFrames: []string{
+			"context.(*cancelCtx).propagateCancel", "context.propagateCancel",
+		},
+		HeapFrames: []string{
+			"context.WithCancel", "context.WithTimeout", "context.WithDeadline", "context.withCancel",
+			"context.(*cancelCtx)", "context.(*timerCtx)",
+		},
+		InternalPrefixes:   []string{"runtime.", "context.", "time."},
+		HighGoroutineCount: 100,
+		Recommendations: []string{
+			"Call the cancel function returned by context.WithCancel/WithTimeout/WithDeadline on every path (defer cancel())",
+			"Run 'go vet' (lostcancel check) to find derived contexts whose cancel function is discarded",
+			"Avoid deriving new contexts inside long-lived loops without cancelling the previous one",
+			"Goroutines in propagateCancel indicate a custom parent Context implementation; prefer embedding a standard context",
+		},
+	},
+	"http": {
+		Description: "HTTP client connection leaks (un-closed response bodies)",
+		GoroutineFrames: []string{
+			"net/http.(*persistConn).readLoop", "net/http.(*persistConn).writeLoop",
+		},
+		HeapFrames: []string{
+			"net/http.(*Transport).dialConn", "net/http.(*Transport).roundTrip", "net/http.(*Client).do",
+			"net/http.(*persistConn)",
+		},
+		InternalPrefixes:   []string{"runtime.", "net/http.", "net.", "bufio.", "crypto/tls.", "context.", "sync."},
+		HighGoroutineCount: 200,
+		Recommendations: []string{
+			"Always close response bodies (defer resp.Body.Close()), including on error paths and non-2xx responses",
+			"Drain bodies you do not read (io.Copy(io.Discard, resp.Body)) so connections can be reused",
+			"Reuse a single http.Client/Transport instead of creating one per request; set IdleConnTimeout or call CloseIdleConnections",
+		},
+	},
+	"timer": {
+		Description: "time.Ticker / time.After leaks",
+		GoroutineFrames: []string{
+			"time.Sleep", "runtime.timeSleep", "time.(*Ticker)", "time.(*Timer)", "time.Tick", "time.After",
+		},
+		HeapFrames: []string{
+			"time.NewTicker", "time.NewTimer", "time.After", "time.AfterFunc", "time.Tick", "runtime.newTimer", "time.newTimer",
+		},
+		InternalPrefixes:   []string{"runtime.", "time."},
+		HighGoroutineCount: 100,
+		Recommendations: []string{
+			"Call Stop() on every time.Ticker (e.g. defer ticker.Stop()) once the consuming loop exits",
+			"Avoid time.After inside long-running select loops; reuse a time.Timer and Reset it instead",
+			"Make sure goroutines waiting on timers also select on a cancellation channel or ctx.Done()",
+		},
+	},
+}
+
+// LeakPatternNames returns the names of all supported leak patterns in sorted order.
+func LeakPatternNames() []string {
+	names := make([]string, 0, len(leakPatterns))
+	for name := range leakPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LeakPatternProfiles bundles the snapshots a leak pattern detector can inspect.
+// Any profile may be nil; the Old* profiles enable growth detection between snapshots.
+type LeakPatternProfiles struct {
+	OldGoroutine *profile.Profile
+	Goroutine    *profile.Profile
+	OldHeap      *profile.Profile
+	Heap         *profile.Profile
+}
+
+// PatternSiteStat is the contribution of one call site to a leak pattern.
+type PatternSiteStat struct {
+	Source         string `json:"source"` // "goroutine" or "heap"
+	Site           string `json:"site"`
+	OldValue       int64  `json:"oldValue"`
+	NewValue       int64  `json:"newValue"`
+	Growth         int64  `json:"growth"`
+	ValueFormatted string `json:"valueFormatted"`
+	Objects        int64  `json:"objects,omitempty"`
+}
+
+// LeakPatternResult is the JSON result of a leak pattern detection.
+type LeakPatternResult struct {
+	Pattern            string            `json:"pattern"`
+	Description        string            `json:"description"`
+	HasBaseline        bool              `json:"hasBaseline"`
+	OldGoroutines      int64             `json:"oldGoroutines,omitempty"`
+	Goroutines         int64             `json:"goroutines"`
+	OldHeapBytes       int64             `json:"oldHeapBytes,omitempty"`
+	HeapBytes          int64             `json:"heapBytes"`
+	HeapBytesFormatted string            `json:"heapBytesFormatted"`
+	Suspected          bool              `json:"suspected"`
+	Verdict            string            `json:"verdict"`
+	Sites              []PatternSiteStat `json:"sites"`
+	Recommendations    []string          `json:"recommendations"`
+}
+
+// matchesAnyPrefix reports whether name starts with any of the given prefixes.
+func matchesAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternCallSite returns the call site responsible for a sample matching frames, or "" if no frame matches.
+// The site is the first frame above the matching frame that is not internal to the pattern;
+// if there is none, the matching frame itself is used.
+func patternCallSite(s *profile.Sample, frames, internal []string) string {
+	matched := ""
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function == nil {
+				continue
+			}
+			name := line.Function.Name
+			if matched == "" {
+				if matchesAnyPrefix(name, frames) {
+					matched = name
+				}
+				continue
+			}
+			if !matchesAnyPrefix(name, internal) && !strings.HasPrefix(name, "runtime.") {
+				return fmt.Sprintf("%s at %s:%d", name, line.Function.Filename, line.Line)
+			}
+		}
+	}
+	return matched
+}
+
+// aggregatePattern sums valueIndex (and objectsIndex, if >= 0) per call site for samples matching frames.
+func aggregatePattern(p *profile.Profile, valueIndex, objectsIndex int, frames, internal []string) (map[string]int64, map[string]int64, int64) {
+	values := make(map[string]int64)
+	objects := make(map[string]int64)
+	total := int64(0)
+	if p == nil || valueIndex < 0 {
+		return values, objects, total
+	}
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		site := patternCallSite(s, frames, internal)
+		if site == "" {
+			continue
+		}
+		values[site] += s.Value[valueIndex]
+		total += s.Value[valueIndex]
+		if objectsIndex >= 0 && len(s.Value) > objectsIndex {
+			objects[site] += s.Value[objectsIndex]
+		}
+	}
+	return values, objects, total
+}
+
+// heapValueIndices returns the inuse_space and inuse_objects indices of a heap profile.
+func heapValueIndices(p *profile.Profile) (spaceIndex, objectsIndex int) {
+	spaceIndex, objectsIndex = -1, -1
+	if p == nil {
+		return
+	}
+	for i, st := range p.SampleType {
+		if st.Type == "inuse_space" && st.Unit == "bytes" {
+			spaceIndex = i
+		}
+		if st.Type == "inuse_objects" && st.Unit == "count" {
+			objectsIndex = i
+		}
+	}
+	return
+}
+
+// DetectLeakPattern inspects goroutine and heap snapshots for a well-known leak pattern
+// (see LeakPatternNames) and reports the call sites involved. With old snapshots it reports
+// growth between snapshots; with a single snapshot it reports absolute counts.
+func DetectLeakPattern(patternName string, profiles LeakPatternProfiles, limit int, format string) (string, error) {
+	pattern, ok := leakPatterns[patternName]
+	if !ok {
+		return "", fmt.Errorf("unsupported leak pattern: '%s' (supported: %s)", patternName, strings.Join(LeakPatternNames(), ", "))
+	}
+	if profiles.Goroutine == nil && profiles.Heap == nil {
+		return "", fmt.Errorf("at least one goroutine or heap profile is required")
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	log.Printf("Detecting leak pattern '%s' (Limit %d, Format: %s)", patternName, limit, format)
+
+	hasBaseline := profiles.OldGoroutine != nil || profiles.OldHeap != nil
+	sites := make([]PatternSiteStat, 0)
+
+	// Goroutines: the goroutine profile has a single goroutines/count sample type at index 0
+	oldG, _, oldGTotal := aggregatePattern(profiles.OldGoroutine, 0, -1, pattern.GoroutineFrames, pattern.InternalPrefixes)
+	newG, _, newGTotal := aggregatePattern(profiles.Goroutine, 0, -1, pattern.GoroutineFrames, pattern.InternalPrefixes)
+	for _, site := range unionKeys(oldG, newG) {
+		sites = append(sites, PatternSiteStat{
+			Source:         "goroutine",
+			Site:           site,
+			OldValue:       oldG[site],
+			NewValue:       newG[site],
+			Growth:         newG[site] - oldG[site],
+			ValueFormatted: FormatCount(newG[site]) + " goroutines",
+		})
+	}
+
+	// Heap: in-use bytes attributed to the creating call site
+	oldSpace, oldObjectsIndex := heapValueIndices(profiles.OldHeap)
+	newSpace, newObjectsIndex := heapValueIndices(profiles.Heap)
+	if profiles.Heap != nil && newSpace == -1 {
+		return "", fmt.Errorf("could not find inuse_space sample type in the heap profile")
+	}
+	oldH, _, oldHTotal := aggregatePattern(profiles.OldHeap, oldSpace, oldObjectsIndex, pattern.HeapFrames, pattern.InternalPrefixes)
+	newH, newObjects, newHTotal := aggregatePattern(profiles.Heap, newSpace, newObjectsIndex, pattern.HeapFrames, pattern.InternalPrefixes)
+	for _, site := range unionKeys(oldH, newH) {
+		sites = append(sites, PatternSiteStat{
+			Source:         "heap",
+			Site:           site,
+			OldValue:       oldH[site],
+			NewValue:       newH[site],
+			Growth:         newH[site] - oldH[site],
+			ValueFormatted: FormatBytes(newH[site]),
+			Objects:        newObjects[site],
+		})
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].Source != sites[j].Source {
+			return sites[i].Source < sites[j].Source
+		}
+		if hasBaseline && sites[i].Growth != sites[j].Growth {
+			return sites[i].Growth > sites[j].Growth
+		}
+		if sites[i].NewValue != sites[j].NewValue {
+			return sites[i].NewValue > sites[j].NewValue
+		}
+		return sites[i].Site < sites[j].Site
+	})
+
+	// Keep at most limit sites per source
+	perSource := make(map[string]int)
+	limited := make([]PatternSiteStat, 0, len(sites))
+	for _, site := range sites {
+		if perSource[site.Source] < limit {
+			limited = append(limited, site)
+			perSource[site.Source]++
+		}
+	}
+
+	suspected := false
+	verdict := "No sign of this leak pattern."
+	switch {
+	case hasBaseline && ((profiles.OldGoroutine != nil && newGTotal > oldGTotal) || (profiles.OldHeap != nil && newHTotal > oldHTotal)):
+		suspected = true
+		verdict = "Likely leak: matching goroutines or allocations grew between snapshots."
+	case !hasBaseline && pattern.HighGoroutineCount > 0 && newGTotal >= pattern.HighGoroutineCount:
+		suspected = true
+		verdict = fmt.Sprintf("Possible leak: %s matching goroutines in a single snapshot; capture a second snapshot to confirm growth.", FormatCount(newGTotal))
+	case newGTotal > 0 || newHTotal > 0:
+		verdict = "Matching goroutines or allocations are present but not growing."
+	}
+
+	result := LeakPatternResult{
+		Pattern:            patternName,
+		Description:        pattern.Description,
+		HasBaseline:        hasBaseline,
+		OldGoroutines:      oldGTotal,
+		Goroutines:         newGTotal,
+		OldHeapBytes:       oldHTotal,
+		HeapBytes:          newHTotal,
+		HeapBytesFormatted: FormatBytes(newHTotal),
+		Suspected:          suspected,
+		Verdict:            verdict,
+		Sites:              limited,
+		Recommendations:    pattern.Recommendations,
+	}
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Leak Pattern Report: %s (%s)", patternName, pattern.Description)
+		if profiles.Goroutine != nil {
+			if profiles.OldGoroutine != nil {
+				w.line("Matching goroutines: %s → %s (%s)", FormatCount(oldGTotal), FormatCount(newGTotal), formatSignedValue(newGTotal-oldGTotal, FormatCount))
+			} else {
+				w.line("Matching goroutines: %s", FormatCount(newGTotal))
+			}
+		}
+		if profiles.Heap != nil {
+			if profiles.OldHeap != nil {
+				w.line("Matching in-use heap: %s → %s (growth %s)", FormatBytes(oldHTotal), FormatBytes(newHTotal), FormatBytes(newHTotal-oldHTotal))
+			} else {
+				w.line("Matching in-use heap: %s", FormatBytes(newHTotal))
+			}
+		}
+		w.line("Verdict: %s", verdict)
+
+		for _, source := range []string{"goroutine", "heap"} {
+			columns := []tableColumn{valueColumn("Value"), nameColumn("Call Site")}
+			if hasBaseline {
+				columns = []tableColumn{valueColumn("Value"), deltaColumn("Growth"), nameColumn("Call Site")}
+			}
+			t := newTable(columns...)
+			for _, site := range limited {
+				if site.Source != source {
+					continue
+				}
+				if !hasBaseline {
+					t.add(site.ValueFormatted, site.Site)
+					continue
+				}
+				growth := formatSignedValue(site.Growth, FormatCount)
+				if source == "heap" {
+					growth = formatSignedValue(site.Growth, FormatBytes)
+				}
+				t.add(site.ValueFormatted, growth, site.Site)
+			}
+			if len(t.rows) == 0 {
+				continue
+			}
+			if source == "goroutine" {
+				w.heading("Goroutine call sites")
+			} else {
+				w.heading("Heap allocation call sites")
+			}
+			w.table(t)
+		}
+
+		w.heading("Recommendations")
+		for i, rec := range pattern.Recommendations {
+			w.line("%d. %s", i+1, rec)
+		}
+		return w.String(), nil
+
+	case "json":
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling leak pattern result to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// unionKeys returns the sorted union of the keys of two maps.
+func unionKeys(a, b map[string]int64) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	keys := make([]string, 0, len(a)+len(b))
+	for _, m := range []map[string]int64{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,20 +3,32 @@ package analyzer
 import (
 	"fmt"
 	"sort"
-	"strings"
 
 	"github.com/google/pprof/profile"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer/aggregate"
 )
 
 // DetectPotentialMemoryLeaks analyzes Heap profiles and attempts to detect potential memory leaks.
 // This function compares two Heap profiles (typically snapshots from different points in time) and identifies memory allocations with significant growth.
 func DetectPotentialMemoryLeaks(oldProfile, newProfile *profile.Profile, threshold float64, limit int) (string, error) {
+	return DetectPotentialMemoryLeaksWithOptions(oldProfile, newProfile, threshold, limit)
+}
+
+// DetectPotentialMemoryLeaksWithOptions is DetectPotentialMemoryLeaks with Options: the report also lists
+// the TopN regressions and improvements ranked by materiality (see RankByMateriality), and samples with a
+// frame matching one of the IgnoreFunctions (known noise) are left out of both profiles.
+func DetectPotentialMemoryLeaksWithOptions(oldProfile, newProfile *profile.Profile, threshold float64, limit int, opts ...Option) (string, error) {
+	o := NewOptions(opts...)
+	topK, ignore := o.TopN, o.IgnoreFunctions
 	if threshold <= 0 {
 		threshold = 0.1 // Default threshold: 10% growth
 	}
 	if limit <= 0 {
 		limit = 10 // Default: show top 10 potential leaks
 	}
+	oldProfile, droppedOld := DropIgnoredSamples(oldProfile, ignore)
+	newProfile, droppedNew := DropIgnoredSamples(newProfile, ignore)
 
 	// Analyze memory usage in the old profile
 	oldMemory := make(map[string]int64)
@@ -51,13 +63,9 @@ func DetectPotentialMemoryLeaks(oldProfile, newProfile *profile.Profile, thresho
 			}
 
 			// Extract type information (if available)
-			typeName := "unknown"
-			if len(s.Label) > 0 {
-				if typeLabels, ok := s.Label["type"]; ok && len(typeLabels) > 0 {
-					typeName = typeLabels[0]
-				} else if objLabels, ok := s.Label["object"]; ok && len(objLabels) > 0 {
-					typeName = objLabels[0]
-				}
+			typeName := aggregate.ObjectType(s)
+			if typeName == "" {
+				typeName = "unknown"
 			}
 
 			// Aggregate by type
@@ -101,13 +109,9 @@ func DetectPotentialMemoryLeaks(oldProfile, newProfile *profile.Profile, thresho
 			}
 
 			// Extract type information (if available)
-			typeName := "unknown"
-			if len(s.Label) > 0 {
-				if typeLabels, ok := s.Label["type"]; ok && len(typeLabels) > 0 {
-					typeName = typeLabels[0]
-				} else if objLabels, ok := s.Label["object"]; ok && len(objLabels) > 0 {
-					typeName = objLabels[0]
-				}
+			typeName := aggregate.ObjectType(s)
+			if typeName == "" {
+				typeName = "unknown"
 			}
 
 			// Aggregate by type
@@ -175,54 +179,67 @@ func DetectPotentialMemoryLeaks(oldProfile, newProfile *profile.Profile, thresho
 
 	// Sort by memory growth
 	sort.Slice(growthStats, func(i, j int) bool {
-		return growthStats[i].Growth > growthStats[j].Growth
+		if growthStats[i].Growth != growthStats[j].Growth {
+			return growthStats[i].Growth > growthStats[j].Growth
+		}
+		return growthStats[i].Type < growthStats[j].Type
 	})
 
+	// Rank every type present in either profile by materiality, including shrinking and vanished ones
+	changes := make([]DiffChange, 0, len(newMemory))
+	for typeName, newVal := range newMemory {
+		changes = append(changes, NewDiffChange(typeName, oldMemory[typeName], newVal))
+	}
+	for typeName, oldVal := range oldMemory {
+		if _, exists := newMemory[typeName]; !exists {
+			changes = append(changes, NewDiffChange(typeName, oldVal, 0))
+		}
+	}
+	regressions, improvements := RankByMateriality(changes, topK)
+
 	// Format output
-	var b strings.Builder
-	b.WriteString("Memory Leak Detection Report\n")
-	b.WriteString("==========================\n\n")
+	format := o.Format
+	if format == "" {
+		format = "text"
+	}
+	w := newReportWriter(format)
+	w.title("Memory Leak Detection Report")
+	writeIgnoreNote(w, ignore, droppedOld, droppedNew)
 
 	if len(growthStats) == 0 {
-		b.WriteString("No significant memory growth detected.\n")
-		return b.String(), nil
+		w.line("No significant memory growth detected.")
+		writeTopChanges(w, regressions, improvements, FormatBytes)
+		return w.String(), nil
 	}
 
-	b.WriteString(fmt.Sprintf("Found %d types with significant memory growth (threshold: %.1f%%)\n\n",
-		len(growthStats), threshold*100))
-
-	b.WriteString("Top Potential Memory Leaks:\n")
-	b.WriteString("--------------------------------------------------\n")
-	b.WriteString(fmt.Sprintf("%-20s %-15s %-15s %-15s %s\n",
-		"Type", "Old Size", "New Size", "Growth", "Growth %"))
-	b.WriteString("--------------------------------------------------\n")
+	w.line("Found %d types with significant memory growth (threshold: %.1f%%)", len(growthStats), threshold*100)
 
 	displayLimit := limit
 	if displayLimit > len(growthStats) {
 		displayLimit = len(growthStats)
 	}
 
+	w.heading("Top Potential Memory Leaks")
+	t := newTable(valueColumn("Old Size"), valueColumn("New Size"), deltaColumn("Growth"), valueColumn("Growth %"),
+		textColumn("Objects", 30), nameColumn("Type"))
 	for i := 0; i < displayLimit; i++ {
 		stat := growthStats[i]
-		b.WriteString(fmt.Sprintf("%-20s %-15s %-15s %-15s %.2f%%",
-			stat.Type,
-			FormatBytes(stat.OldValue),
-			FormatBytes(stat.NewValue),
-			FormatBytes(stat.Growth),
-			stat.GrowthPercent))
-
+		objects := ""
 		if stat.OldCount > 0 || stat.NewCount > 0 {
-			b.WriteString(fmt.Sprintf(" (Objects: %d → %d, +%d, %.2f%%)",
-				stat.OldCount, stat.NewCount, stat.CountGrowth, stat.CountGrowthPct))
+			objects = fmt.Sprintf("%s → %s (%s, %.2f%%)", FormatCount(stat.OldCount), FormatCount(stat.NewCount),
+				formatSignedValue(stat.CountGrowth, FormatCount), stat.CountGrowthPct)
 		}
-
-		b.WriteString("\n")
+		t.add(FormatBytes(stat.OldValue), FormatBytes(stat.NewValue), formatSignedValue(stat.Growth, FormatBytes),
+			DefaultValueFormat().Float(stat.GrowthPercent, 2)+"%", objects, stat.Type)
 	}
+	w.table(t)
+
+	writeTopChanges(w, regressions, improvements, FormatBytes)
 
-	b.WriteString("\nRecommendations:\n")
-	b.WriteString("1. Focus on types with both high absolute growth and high percentage growth\n")
-	b.WriteString("2. Look for objects that grow in count but not significantly in size (may indicate collection leaks)\n")
-	b.WriteString("3. Compare multiple snapshots over time to confirm consistent growth patterns\n")
+	w.heading("Recommendations")
+	w.line("1. Focus on types with both high absolute growth and high percentage growth")
+	w.line("2. Look for objects that grow in count but not significantly in size (may indicate collection leaks)")
+	w.line("3. Compare multiple snapshots over time to confirm consistent growth patterns")
 
-	return b.String(), nil
+	return w.String(), nil
 }
@@ -0,0 +1,147 @@
+package analyzer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// MinSampleValue is the threshold below which samples are dropped before the analysis (see DropSmallSamples):
+// an absolute value in the unit of the analyzed sample type, or a percentage of its total.
+type MinSampleValue struct {
+	Value   int64   // Absolute threshold, e.g. 1000000 (1ms of CPU time or 1MB of memory)
+	Percent float64 // Threshold as a percentage of the total; used when Value is 0
+}
+
+// IsZero reports whether no threshold is set.
+func (m MinSampleValue) IsZero() bool {
+	return m.Value == 0 && m.Percent == 0
+}
+
+// String formats the threshold as ParseMinSampleValue accepts it.
+func (m MinSampleValue) String() string {
+	if m.Value == 0 && m.Percent != 0 {
+		return strconv.FormatFloat(m.Percent, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatInt(m.Value, 10)
+}
+
+// ParseMinSampleValue parses a threshold given as an absolute value ("1000000") or as a percentage of the
+// total ("0.1%"). An empty string gives the zero threshold, which keeps every sample.
+func ParseMinSampleValue(s string) (MinSampleValue, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return MinSampleValue{}, nil
+	}
+	if percent, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || v < 0 || v > 100 {
+			return MinSampleValue{}, fmt.Errorf("invalid minimum sample value '%s': the percentage must be between 0 and 100", s)
+		}
+		return MinSampleValue{Percent: v}, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return MinSampleValue{}, fmt.Errorf("invalid minimum sample value '%s': expected a non-negative integer in the unit of the sample type, or a percentage such as '0.1%%'", s)
+	}
+	return MinSampleValue{Value: v}, nil
+}
+
+// MinValueStats describes the samples DropSmallSamples removed.
+type MinValueStats struct {
+	Threshold       int64 // The absolute threshold applied
+	Unit            string
+	OriginalSamples int
+	DroppedSamples  int
+	Total           int64 // Total weight before dropping
+	DroppedValue    int64 // Weight of the dropped samples
+}
+
+// DroppedPercent is the share of the total weight that was dropped.
+func (s MinValueStats) DroppedPercent() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.DroppedValue) / float64(s.Total) * 100
+}
+
+// Note describes the dropped samples for text reports, e.g. "Note: dropped 120 of 5,000 samples below 1ms
+// (min_sample_value 0.1%); they weighed 80ms, 2.00% of the total."
+func (s MinValueStats) Note(m MinSampleValue) string {
+	return fmt.Sprintf("Note: dropped %s of %s samples below %s (min_sample_value %s); they weighed %s, %s%% of the total.",
+		FormatCount(int64(s.DroppedSamples)), FormatCount(int64(s.OriginalSamples)), FormatSampleValue(s.Threshold, s.Unit),
+		m, FormatSampleValue(s.DroppedValue, s.Unit), DefaultValueFormat().Float(s.DroppedPercent(), 2))
+}
+
+// MinValueSummary reports the samples dropped below the minimum sample value in JSON results.
+type MinValueSummary struct {
+	MinSampleValue     string  `json:"minSampleValue"` // As requested, e.g. "0.1%"
+	Threshold          int64   `json:"threshold"`
+	ThresholdFormatted string  `json:"thresholdFormatted"`
+	OriginalSamples    int     `json:"originalSamples"`
+	DroppedSamples     int     `json:"droppedSamples"`
+	DroppedValue       int64   `json:"droppedValue"`
+	DroppedFormatted   string  `json:"droppedFormatted"`
+	DroppedPercent     float64 `json:"droppedPercent"`
+}
+
+// minValueSummary converts the stats of DropSmallSamples for JSON results; nil stats give nil.
+func minValueSummary(s *MinValueStats, m MinSampleValue) *MinValueSummary {
+	if s == nil {
+		return nil
+	}
+	return &MinValueSummary{
+		MinSampleValue:     m.String(),
+		Threshold:          s.Threshold,
+		ThresholdFormatted: FormatSampleValue(s.Threshold, s.Unit),
+		OriginalSamples:    s.OriginalSamples,
+		DroppedSamples:     s.DroppedSamples,
+		DroppedValue:       s.DroppedValue,
+		DroppedFormatted:   FormatSampleValue(s.DroppedValue, s.Unit),
+		DroppedPercent:     s.DroppedPercent(),
+	}
+}
+
+// DropSmallSamples removes the samples whose value of valueIndex (in absolute terms) is below the threshold,
+// before any aggregation, to cut the noise and the memory use of gigantic profiles. A percentage threshold is
+// resolved against the total of valueIndex. p itself is not modified; it is returned as is when no sample is
+// dropped.
+func DropSmallSamples(p *profile.Profile, valueIndex int, m MinSampleValue) (*profile.Profile, MinValueStats, error) {
+	stats := MinValueStats{OriginalSamples: len(p.Sample)}
+	if valueIndex < 0 || valueIndex >= len(p.SampleType) {
+		return nil, stats, fmt.Errorf("invalid sample value index %d", valueIndex)
+	}
+	stats.Unit = p.SampleType[valueIndex].Unit
+	weight := func(s *profile.Sample) int64 {
+		if valueIndex >= len(s.Value) {
+			return 0
+		}
+		if v := s.Value[valueIndex]; v >= 0 {
+			return v
+		}
+		return -s.Value[valueIndex]
+	}
+	for _, s := range p.Sample {
+		stats.Total += weight(s)
+	}
+	stats.Threshold = m.Value
+	if m.Value == 0 {
+		stats.Threshold = int64(float64(stats.Total) * m.Percent / 100)
+	}
+
+	kept := make([]*profile.Sample, 0, len(p.Sample))
+	for _, s := range p.Sample {
+		if w := weight(s); w < stats.Threshold {
+			stats.DroppedSamples++
+			stats.DroppedValue += w
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if stats.DroppedSamples == 0 {
+		return p, stats, nil
+	}
+	return withSamples(p, kept), stats, nil
+}
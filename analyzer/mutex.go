@@ -0,0 +1,298 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// mutexLockKinds identify the kind of lock a mutex profile sample was released from, in priority order: the
+// first rule with a frame anywhere in the stack wins. RWMutex rules come first as RWMutex.Unlock releases
+// its writer sync.Mutex as well.
+var mutexLockKinds = []struct {
+	kind      string
+	functions []string
+}{
+	{"RWMutex (read)", []string{"sync.(*RWMutex).RUnlock", "sync.(*RWMutex).rUnlockSlow"}},
+	{"RWMutex (write)", []string{"sync.(*RWMutex).Unlock"}},
+	{"Mutex", []string{"sync.(*Mutex).Unlock", "sync.(*Mutex).unlockSlow", "internal/sync.(*Mutex).Unlock", "internal/sync.(*Mutex).unlockSlow"}},
+	{"runtime", []string{"runtime.unlock", "runtime.unlock2", "runtime.unlockWithRank"}},
+}
+
+// Thresholds for the mutex recommendations.
+const (
+	// mutexHotSiteShare is the share of the total delay (%) from which a lock site gets a recommendation.
+	mutexHotSiteShare = 10.0
+	// mutexShardStacks is the number of distinct stacks contending on a lock site from which sharding is suggested.
+	mutexShardStacks = 3
+	// mutexLongWait is the average delay per contention (ns) from which the critical section is considered long.
+	mutexLongWait = 1e6
+	// mutexRWDominance is the share of the RWMutex delay (%) from which its read or write path dominates.
+	mutexRWDominance = 50.0
+	// mutexRuntimeShare is the share of the total delay (%) from which runtime-internal locks are reported.
+	mutexRuntimeShare = 20.0
+)
+
+// MutexSiteStat is the contention on a lock, identified by the function releasing it (the first frame outside
+// the sync and runtime packages), as mutex profiles record the stack of the Unlock that ended a wait.
+type MutexSiteStat struct {
+	Site             string  `json:"site"`
+	Lock             string  `json:"lock"` // "Mutex", "RWMutex (read)", "RWMutex (write)", "runtime" or "other"
+	Delay            int64   `json:"delay"`
+	DelayFormatted   string  `json:"delayFormatted"`
+	Percentage       float64 `json:"percentage"`
+	Contentions      int64   `json:"contentions"`
+	AvgWaitFormatted string  `json:"avgWaitFormatted,omitempty"` // Delay per contention
+	Stacks           int     `json:"stacks"`                     // Distinct stacks releasing the lock
+}
+
+// MutexRecommendation is a heuristic suggestion derived from a mutex profile.
+type MutexRecommendation struct {
+	Kind       string  `json:"kind"`           // "shard", "long-critical-section", "rwmutex-read-path", "rwmutex-write-path" or "runtime-locks"
+	Site       string  `json:"site,omitempty"` // The lock site the recommendation is about, if any
+	Percentage float64 `json:"percentage"`     // Share of the total delay concerned
+	Message    string  `json:"message"`
+}
+
+// MutexAnalysisResult is the JSON result of the mutex profile analysis.
+type MutexAnalysisResult struct {
+	ProfileType         string                `json:"profileType"`
+	TotalDelay          int64                 `json:"totalDelay"`
+	TotalDelayFormatted string                `json:"totalDelayFormatted"`
+	TotalContentions    int64                 `json:"totalContentions"`
+	TopN                int                   `json:"topN"`
+	Sites               []MutexSiteStat       `json:"sites"`
+	Recommendations     []MutexRecommendation `json:"recommendations"`
+}
+
+// classifyMutexLock returns the kind of lock a stack (function names, leaf first) released.
+func classifyMutexLock(names []string) string {
+	for _, rule := range mutexLockKinds {
+		for _, name := range names {
+			for _, fn := range rule.functions {
+				if name == fn {
+					return rule.kind
+				}
+			}
+		}
+	}
+	return "other"
+}
+
+// mutexLockSite returns the first function of a stack (leaf first) outside the sync and runtime packages.
+func mutexLockSite(names []string) string {
+	for _, name := range names {
+		if !matchesAnyPrefix(name, []string{"sync.", "runtime.", "internal/"}) {
+			return name
+		}
+	}
+	if len(names) > 0 {
+		return names[0]
+	}
+	return "(unknown)"
+}
+
+// AggregateMutexSites sums the delay and contentions of a mutex profile per lock site (see MutexSiteStat),
+// sorted by delay. It returns the sites and the total delay and contentions.
+func AggregateMutexSites(p *profile.Profile) ([]MutexSiteStat, int64, int64, error) {
+	contentionsIndex, delayIndex := contentionValueIndices(p)
+	if delayIndex == -1 {
+		return nil, 0, 0, fmt.Errorf("could not find delay/nanoseconds sample type in the mutex profile")
+	}
+	type siteKey struct{ site, lock string }
+	sites := make(map[siteKey]*MutexSiteStat)
+	stacks := make(map[siteKey]map[string]bool)
+	totalDelay, totalContentions := int64(0), int64(0)
+	for _, s := range p.Sample {
+		if len(s.Value) <= delayIndex {
+			continue
+		}
+		names := sampleFunctions(s)
+		key := siteKey{mutexLockSite(names), classifyMutexLock(names)}
+		st, ok := sites[key]
+		if !ok {
+			st = &MutexSiteStat{Site: key.site, Lock: key.lock}
+			sites[key] = st
+			stacks[key] = make(map[string]bool)
+		}
+		st.Delay += s.Value[delayIndex]
+		totalDelay += s.Value[delayIndex]
+		if contentionsIndex >= 0 && len(s.Value) > contentionsIndex {
+			st.Contentions += s.Value[contentionsIndex]
+			totalContentions += s.Value[contentionsIndex]
+		}
+		stacks[key][strings.Join(names, "\n")] = true
+	}
+
+	stats := make([]MutexSiteStat, 0, len(sites))
+	for key, st := range sites {
+		st.Stacks = len(stacks[key])
+		st.DelayFormatted = FormatSampleValue(st.Delay, "nanoseconds")
+		if totalDelay != 0 {
+			st.Percentage = float64(st.Delay) / float64(totalDelay) * 100
+		}
+		if st.Contentions > 0 {
+			st.AvgWaitFormatted = FormatSampleValue(st.Delay/st.Contentions, "nanoseconds")
+		}
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Delay != stats[j].Delay {
+			return stats[i].Delay > stats[j].Delay
+		}
+		if stats[i].Site != stats[j].Site {
+			return stats[i].Site < stats[j].Site
+		}
+		return stats[i].Lock < stats[j].Lock
+	})
+	return stats, totalDelay, totalContentions, nil
+}
+
+// MutexRecommendations derives heuristic recommendations from the lock sites of AggregateMutexSites: sharding
+// locks contended from many stacks, shortening long critical sections, the read or write path dominating an
+// RWMutex, and runtime-internal lock contention. At most topN sites get a recommendation; they are sorted by
+// the share of the delay they concern.
+func MutexRecommendations(sites []MutexSiteStat, totalDelay int64, topN int) []MutexRecommendation {
+	recommendations := make([]MutexRecommendation, 0)
+	if totalDelay <= 0 {
+		return recommendations
+	}
+	share := func(delay int64) float64 { return float64(delay) / float64(totalDelay) * 100 }
+
+	reported := 0
+	for _, st := range sites {
+		if reported == topN || st.Percentage < mutexHotSiteShare {
+			break
+		}
+		if st.Lock == "runtime" {
+			continue
+		}
+		switch {
+		case st.Stacks >= mutexShardStacks:
+			recommendations = append(recommendations, MutexRecommendation{
+				Kind: "shard", Site: st.Site, Percentage: st.Percentage,
+				Message: fmt.Sprintf("The %s released in %s is contended by %d stacks (%s%% of the delay): consider sharding it (e.g. striped locks per key) or narrowing the data it protects.",
+					st.Lock, st.Site, st.Stacks, percentString(st.Percentage)),
+			})
+		case st.Contentions > 0 && st.Delay/st.Contentions >= mutexLongWait:
+			recommendations = append(recommendations, MutexRecommendation{
+				Kind: "long-critical-section", Site: st.Site, Percentage: st.Percentage,
+				Message: fmt.Sprintf("The %s released in %s makes waiters wait %s per contention (%s%% of the delay): move slow work (I/O, allocations, logging) out of the critical section.",
+					st.Lock, st.Site, st.AvgWaitFormatted, percentString(st.Percentage)),
+			})
+		default:
+			continue
+		}
+		reported++
+	}
+
+	var readDelay, writeDelay, runtimeDelay int64
+	for _, st := range sites {
+		switch st.Lock {
+		case "RWMutex (read)":
+			readDelay += st.Delay
+		case "RWMutex (write)":
+			writeDelay += st.Delay
+		case "runtime":
+			runtimeDelay += st.Delay
+		}
+	}
+	if rw := readDelay + writeDelay; rw > 0 && share(rw) >= mutexHotSiteShare {
+		switch {
+		case float64(readDelay)/float64(rw)*100 >= mutexRWDominance:
+			recommendations = append(recommendations, MutexRecommendation{
+				Kind: "rwmutex-read-path", Percentage: share(readDelay),
+				Message: fmt.Sprintf("RWMutex read path dominates: readers holding RLock delay writers for %s%% of the delay. Keep read sections short, or use copy-on-write (atomic.Pointer) if writes are rare.",
+					percentString(share(readDelay))),
+			})
+		case float64(writeDelay)/float64(rw)*100 >= mutexRWDominance:
+			recommendations = append(recommendations, MutexRecommendation{
+				Kind: "rwmutex-write-path", Percentage: share(writeDelay),
+				Message: fmt.Sprintf("RWMutex write path dominates: writers block all readers for %s%% of the delay. Batch or shorten writes; with frequent writes a sync.Mutex or sharding may do better.",
+					percentString(share(writeDelay))),
+			})
+		}
+	}
+	if share(runtimeDelay) >= mutexRuntimeShare {
+		recommendations = append(recommendations, MutexRecommendation{
+			Kind: "runtime-locks", Percentage: share(runtimeDelay),
+			Message: fmt.Sprintf("Runtime-internal locks account for %s%% of the delay (e.g. channel, timer or scheduler locks): look for channels or timers shared by many goroutines.",
+				percentString(share(runtimeDelay))),
+		})
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool { return recommendations[i].Percentage > recommendations[j].Percentage })
+	return recommendations
+}
+
+// AnalyzeMutexProfile 分析 Mutex profile (锁竞争情况)。
+func AnalyzeMutexProfile(p *profile.Profile, topN int, format string) (string, error) {
+	return analyzeMutexProfile(p, NewOptions(WithTopN(topN), WithFormat(format)))
+}
+
+// analyzeMutexProfile is the implementation of AnalyzeMutexProfile, driven by Options (see Analyze): the lock
+// sites by delay and the recommendations derived from them.
+func analyzeMutexProfile(p *profile.Profile, o Options) (string, error) {
+	topN, format := o.TopN, o.Format
+	log.Printf("Analyzing Mutex profile (Top %d, Format: %s)", topN, format)
+
+	sites, totalDelay, totalContentions, err := AggregateMutexSites(p)
+	if err != nil {
+		return "", err
+	}
+	limit := min(topN, len(sites))
+	recommendations := MutexRecommendations(sites, totalDelay, topN)
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Mutex Profile Analysis (Top %d Lock Sites by Delay)", topN)
+		w.line("Total Delay: %s over %s contentions", FormatSampleValue(totalDelay, "nanoseconds"), FormatCount(totalContentions))
+
+		w.heading("By Lock Site")
+		t := newTable(valueColumn("Delay"), valueColumn("%"), valueColumn("Contentions"), valueColumn("Avg Wait"), valueColumn("Stacks"),
+			textColumn("Lock", 16), nameColumn("Released In"))
+		for _, st := range sites[:limit] {
+			avgWait := st.AvgWaitFormatted
+			if avgWait == "" {
+				avgWait = "-"
+			}
+			t.add(st.DelayFormatted, percentString(st.Percentage), FormatCount(st.Contentions), avgWait, FormatCount(int64(st.Stacks)), st.Lock, st.Site)
+		}
+		w.table(t)
+
+		if len(recommendations) > 0 {
+			w.heading("Recommendations")
+			for i, rec := range recommendations {
+				w.line("%d. %s", i+1, rec.Message)
+			}
+		}
+		return w.String(), nil
+
+	case "json":
+		result := MutexAnalysisResult{
+			ProfileType:         "mutex",
+			TotalDelay:          totalDelay,
+			TotalDelayFormatted: FormatSampleValue(totalDelay, "nanoseconds"),
+			TotalContentions:    totalContentions,
+			TopN:                limit,
+			Sites:               sites[:limit],
+			Recommendations:     recommendations,
+		}
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling Mutex analysis to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
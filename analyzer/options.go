@@ -0,0 +1,478 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Filters restricts an analysis to part of the profile. Focus, Ignore, Hide and Show are regular expressions
+// matched against function names, with the same semantics as the 'go tool pprof' options of the same name.
+type Filters struct {
+	Focus  string // Keep only samples with a frame matching Focus
+	Ignore string // Drop samples with a frame matching Ignore
+	Hide   string // Remove matching frames from stacks
+	Show   string // Keep only matching frames in stacks
+	Tags   string // Keep only samples whose labels match these conditions (see ParseTagFilter)
+	// Remove the frames of test files and mock packages from stacks, like Hide with TestFramesPattern
+	ExcludeTestFrames bool
+}
+
+// TestFramesPattern matches the frames ExcludeTestFrames removes: functions defined in _test.go files and
+// functions of mock packages, i.e. packages named mock, mocks, mock_*, or ending in "mock" or "mocks" (gomock,
+// testify's mock, mockgen and mockery output such as storemock). Their cost goes to their callers, so profiles
+// collected while running tests show the production code paths.
+const TestFramesPattern = `_test\.go$|(^|/)(mock_[^/.]*|[^/.]*mocks?)\.`
+
+// IsZero reports whether no filter is set.
+func (f Filters) IsZero() bool {
+	return f == Filters{}
+}
+
+// HidePattern returns the regular expression of the frames to remove from stacks: Hide, combined with
+// TestFramesPattern when ExcludeTestFrames is set.
+func (f Filters) HidePattern() string {
+	if !f.ExcludeTestFrames {
+		return f.Hide
+	}
+	if f.Hide == "" {
+		return TestFramesPattern
+	}
+	return f.Hide + "|" + TestFramesPattern
+}
+
+// Options configures an analysis run through Analyze. Use NewOptions with the With* functional options
+// rather than positional arguments, so new settings can be added without changing every signature.
+type Options struct {
+	TopN        int     // Number of entries in top-N lists
+	Format      string  // "text", "markdown", "markdown-compact", "json", "flamegraph-json" (depending on the profile type), "callgraph", "callgraph-dot", "graphml", "centrality", "edges" or "folded"
+	SortBy      string  // Sort order of top-N lists: "flat" or "cum" (CPU profiles; other types sort by flat)
+	SampleType  string  // Sample type to analyze (e.g. "alloc_space"); empty selects the profile type's default
+	Granularity string  // pprof granularity: "functions", "filefunctions", "files", "lines" or "addresses"
+	Filters     Filters // Sample filters applied before the analysis
+	MaxSamples  int     // Downsample profiles with more samples to about this many (see Downsample); 0 disables
+	// Samples below this value are dropped before the analysis (see DropSmallSamples); zero keeps them all
+	MinSampleValue MinSampleValue
+	Seed           int64  // Seed of the downsampling pass
+	CacheKey       string // Identifies the parsed profile for the analysis cache (see WithCacheKey); empty disables it
+	CharBudget     int    // Target size of "markdown-compact" reports in characters; 0 uses DefaultCharBudget
+	GroupBy        string // "function" (default) or "package" for the memory ownership summary of heap/allocs profiles
+	// Share of the total (in percent) above which a package is flagged with GroupBy "package";
+	// 0 uses DefaultOwnershipThreshold
+	OwnershipThreshold float64
+	// Frames shown per stack in goroutine and markdown-compact stack outputs; deeper stacks end with a
+	// "… N more frames" marker. 0 shows all frames (markdown-compact: compactStackDepth)
+	MaxStackDepth int
+	// Label key whose values the analysis is broken down by (see BuildLabelBreakdown); empty disables it
+	GroupByLabel string
+	// Unit of top-N lists: "function" (default), "file" or "package" (see WithAggregationLevel)
+	AggregationLevel string
+	// Samples with a frame matching one of these are dropped from heap diffs (see ParseIgnoreList)
+	IgnoreFunctions []*regexp.Regexp
+	// Context of the analysis; Analyze stops with its error between stages once it is done. nil never stops
+	Context context.Context
+}
+
+// Option sets one field of Options.
+type Option func(*Options)
+
+// WithTopN sets the number of entries in top-N lists.
+func WithTopN(topN int) Option {
+	return func(o *Options) { o.TopN = topN }
+}
+
+// WithFormat sets the output format.
+func WithFormat(format string) Option {
+	return func(o *Options) { o.Format = format }
+}
+
+// WithSortBy sets the sort order of top-N lists.
+func WithSortBy(sortBy string) Option {
+	return func(o *Options) { o.SortBy = sortBy }
+}
+
+// WithSampleType selects the sample type to analyze instead of the profile type's default.
+func WithSampleType(sampleType string) Option {
+	return func(o *Options) { o.SampleType = sampleType }
+}
+
+// WithGranularity aggregates the profile at the given pprof granularity before the analysis.
+func WithGranularity(granularity string) Option {
+	return func(o *Options) { o.Granularity = granularity }
+}
+
+// WithFilters sets the sample filters applied before the analysis.
+func WithFilters(filters Filters) Option {
+	return func(o *Options) { o.Filters = filters }
+}
+
+// WithDownsampling downsamples profiles with more than maxSamples samples before the analysis (see
+// Downsample). A larger budget is more accurate; the same seed always gives the same result.
+func WithDownsampling(maxSamples int, seed int64) Option {
+	return func(o *Options) {
+		o.MaxSamples = maxSamples
+		o.Seed = seed
+	}
+}
+
+// WithMinSampleValue drops the samples below a threshold before the analysis (see DropSmallSamples). The
+// threshold applies to the analyzed sample type.
+func WithMinSampleValue(m MinSampleValue) Option {
+	return func(o *Options) { o.MinSampleValue = m }
+}
+
+// WithCacheKey caches the aggregations and flame graph trees computed for the profile under key, so
+// later analyses with the same key and preparation options (filters, granularity, downsampling) reuse
+// them, e.g. when only the top-N or output format changes. The key must identify the profile's contents,
+// such as a digest of the file it was parsed from.
+func WithCacheKey(key string) Option {
+	return func(o *Options) { o.CacheKey = key }
+}
+
+// WithCharBudget sets the target size of "markdown-compact" reports in characters.
+func WithCharBudget(chars int) Option {
+	return func(o *Options) { o.CharBudget = chars }
+}
+
+// WithGroupBy sets how heap and allocs profiles are rolled up: "function" (the default report) or "package"
+// for a short memory ownership summary (see BuildOwnershipReport).
+func WithGroupBy(groupBy string) Option {
+	return func(o *Options) { o.GroupBy = groupBy }
+}
+
+// WithOwnershipThreshold sets the share of the total, in percent, above which a package is flagged as a
+// dominant owner with GroupBy "package".
+func WithOwnershipThreshold(percent float64) Option {
+	return func(o *Options) { o.OwnershipThreshold = percent }
+}
+
+// WithMaxStackDepth sets the number of frames shown per stack (0 for all).
+func WithMaxStackDepth(depth int) Option {
+	return func(o *Options) { o.MaxStackDepth = depth }
+}
+
+// WithGroupByLabel breaks the analysis down by the values of a sample label (pprof.Labels), listing the top
+// functions of each value.
+func WithGroupByLabel(label string) Option {
+	return func(o *Options) { o.GroupByLabel = label }
+}
+
+// WithAggregationLevel rolls top-N lists, stacks and call graphs up from functions to the source file
+// ("file") or Go package ("package") each function belongs to, to see which module owns the cost.
+func WithAggregationLevel(level string) Option {
+	return func(o *Options) { o.AggregationLevel = level }
+}
+
+// WithIgnoreFunctions drops samples with a frame matching one of the patterns (known noise) from both
+// profiles of a heap diff (see DetectPotentialMemoryLeaksWithOptions).
+func WithIgnoreFunctions(patterns []*regexp.Regexp) Option {
+	return func(o *Options) { o.IgnoreFunctions = patterns }
+}
+
+// WithContext stops the analysis when ctx is done. Analyze checks it between its stages (filtering,
+// downsampling, aggregation and formatting), so a canceled request releases its memory early.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) { o.Context = ctx }
+}
+
+// DefaultOptions returns the options used when none are given: top 5 by flat value, as text.
+func DefaultOptions() Options {
+	return Options{TopN: 5, Format: "text", SortBy: "flat"}
+}
+
+// NewOptions applies the given options on top of DefaultOptions.
+func NewOptions(opts ...Option) Options {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// validate checks settings the analyzers cannot interpret.
+func (o Options) validate() error {
+	switch o.SortBy {
+	case "", "flat", "cum":
+	default:
+		return fmt.Errorf("unsupported sort order: '%s' (supported: flat, cum)", o.SortBy)
+	}
+	switch o.GroupBy {
+	case "", "function", "package":
+	default:
+		return fmt.Errorf("unsupported group_by: '%s' (supported: function, package)", o.GroupBy)
+	}
+	switch o.AggregationLevel {
+	case "", "function", "file", "package":
+	default:
+		return fmt.Errorf("unsupported aggregation level: '%s' (supported: %s)", o.AggregationLevel, strings.Join(AggregationLevels(), ", "))
+	}
+	// The ownership summary derives packages from function names, which a rollup has already replaced
+	if o.GroupBy == "package" && o.rolledUp() {
+		return fmt.Errorf("group_by 'package' already rolls memory up by package; use it with aggregation level 'function'")
+	}
+	if o.OwnershipThreshold < 0 || o.OwnershipThreshold > 100 {
+		return fmt.Errorf("invalid ownership threshold %v: must be between 0 and 100 percent", o.OwnershipThreshold)
+	}
+	if o.CharBudget < 0 {
+		return fmt.Errorf("invalid character budget %d: must not be negative", o.CharBudget)
+	}
+	if o.MaxSamples < 0 {
+		return fmt.Errorf("invalid max samples %d: must not be negative", o.MaxSamples)
+	}
+	if o.MinSampleValue.Value < 0 || o.MinSampleValue.Percent < 0 || o.MinSampleValue.Percent > 100 {
+		return fmt.Errorf("invalid minimum sample value %s: must not be negative, nor above 100%%", o.MinSampleValue)
+	}
+	if o.MaxStackDepth < 0 {
+		return fmt.Errorf("invalid max stack depth %d: must not be negative", o.MaxStackDepth)
+	}
+	if _, err := ParseTagFilter(o.Filters.Tags); err != nil {
+		return err
+	}
+	return nil
+}
+
+// canceled returns the context's error once it is done.
+func (o Options) canceled() error {
+	if o.Context == nil {
+		return nil
+	}
+	return o.Context.Err()
+}
+
+// prepareStats describes what prepare did to the profile besides filtering.
+type prepareStats struct {
+	Downsampled *DownsampleStats // nil unless the profile was downsampled
+	MinValue    *MinValueStats   // nil unless MinSampleValue is set
+}
+
+// prepare returns the profile the analysis should run on: a filtered/aggregated copy when filters, a
+// granularity or an aggregation level are set, without the samples below MinSampleValue, downsampled when it
+// exceeds MaxSamples, otherwise p itself.
+func (o Options) prepare(p *profile.Profile) (*profile.Profile, prepareStats, error) {
+	var stats prepareStats
+	p, err := o.filter(p)
+	if err != nil {
+		return nil, stats, err
+	}
+	if err := o.canceled(); err != nil {
+		return nil, stats, err
+	}
+	needsDownsampling := o.MaxSamples > 0 && len(p.Sample) > o.MaxSamples
+	if o.MinSampleValue.IsZero() && !needsDownsampling {
+		return p, stats, nil
+	}
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return nil, stats, err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	// Dropping the noise first leaves the downsampling budget to the samples that matter
+	if !o.MinSampleValue.IsZero() {
+		var dropped MinValueStats
+		if p, dropped, err = DropSmallSamples(p, valueIndex, o.MinSampleValue); err != nil {
+			return nil, stats, err
+		}
+		stats.MinValue = &dropped
+		if len(p.Sample) == 0 && dropped.OriginalSamples > 0 {
+			return nil, stats, fmt.Errorf("no samples left: all %d samples are below the minimum sample value %s (%s)",
+				dropped.OriginalSamples, o.MinSampleValue, FormatSampleValue(dropped.Threshold, dropped.Unit))
+		}
+	}
+	if o.MaxSamples == 0 || len(p.Sample) <= o.MaxSamples {
+		return p, stats, nil
+	}
+	downsampled, ds, err := Downsample(p, valueIndex, o.MaxSamples, o.Seed)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Downsampled = &ds
+	return downsampled, stats, nil
+}
+
+// filter applies Filters, Granularity and AggregationLevel to a copy of p; without them p itself is returned.
+func (o Options) filter(p *profile.Profile) (*profile.Profile, error) {
+	if o.Filters.IsZero() && o.Granularity == "" && !o.rolledUp() {
+		return p, nil
+	}
+	cfg := PprofConfig{
+		Focus:       o.Filters.Focus,
+		Ignore:      o.Filters.Ignore,
+		Hide:        o.Filters.HidePattern(),
+		Show:        o.Filters.Show,
+		Granularity: o.Granularity,
+	}
+	tags, err := ParseTagFilter(o.Filters.Tags)
+	if err != nil {
+		return nil, err
+	}
+	filtered := p.Copy()
+	if _, err := cfg.Apply(filtered); err != nil {
+		return nil, err
+	}
+	filterByTags(filtered, tags)
+	rollUp(filtered, o.AggregationLevel) // 在过滤之后进行，使 focus/ignore 等仍然匹配函数名
+	// An empty analysis would look like an idle profile; say that the filters removed everything instead
+	if len(filtered.Sample) == 0 && len(p.Sample) > 0 {
+		applied := make([]string, 0, 4)
+		for _, f := range []struct{ name, expr string }{{"focus", o.Filters.Focus}, {"ignore", o.Filters.Ignore}, {"show", o.Filters.Show}, {"tags", o.Filters.Tags}} {
+			if f.expr != "" {
+				applied = append(applied, fmt.Sprintf("%s=%s", f.name, f.expr))
+			}
+		}
+		if len(applied) > 0 {
+			return nil, fmt.Errorf("no samples left after filtering with %s: all %d samples were removed", strings.Join(applied, ", "), len(p.Sample))
+		}
+	}
+	return filtered, nil
+}
+
+// sampleIndex returns the index of o.SampleType, or -1 when the analyzer should pick its default.
+func (o Options) sampleIndex(p *profile.Profile) (int, error) {
+	if o.SampleType == "" {
+		return -1, nil
+	}
+	return sampleValueIndex(p, o.SampleType)
+}
+
+// profileTypeAliases maps alternative profile type names used by other tools and clients to the canonical
+// pprof names.
+var profileTypeAliases = map[string]string{
+	"memory":      "heap",
+	"mem":         "heap",
+	"allocations": "allocs",
+	"alloc":       "allocs",
+	"contention":  "mutex",
+	"lock":        "mutex",
+	"locks":       "mutex",
+	"blocking":    "block",
+	"goroutines":  "goroutine",
+	"threads":     "threadcreate",
+	"thread":      "threadcreate",
+	"profile":     "cpu", // The net/http/pprof endpoint name
+}
+
+// ResolveProfileType normalizes a profile type name: case and surrounding spaces are ignored and aliases
+// such as "memory" (heap), "contention" (mutex), "blocking" (block) or "goroutines" (goroutine) are resolved.
+// Unknown names are returned lower-cased, so callers still report them as unsupported.
+func ResolveProfileType(profileType string) string {
+	normalized := strings.ToLower(strings.TrimSpace(profileType))
+	if canonical, ok := profileTypeAliases[normalized]; ok {
+		return canonical
+	}
+	return normalized
+}
+
+// ProfileTypeNames returns the given canonical profile types followed by their aliases (see ResolveProfileType),
+// for input schema enums that should accept the aliases as well.
+func ProfileTypeNames(canonical ...string) []string {
+	names := append([]string{}, canonical...)
+	aliases := make([]string, 0)
+	for alias, target := range profileTypeAliases {
+		for _, c := range canonical {
+			if target == c {
+				aliases = append(aliases, alias)
+				break
+			}
+		}
+	}
+	sort.Strings(aliases)
+	return append(names, aliases...)
+}
+
+// isAnalyzableProfileType reports whether Analyze supports a (resolved) profile type.
+func isAnalyzableProfileType(profileType string) bool {
+	switch profileType {
+	case "cpu", "heap", "allocs", "goroutine", "mutex", "block", "threadcreate":
+		return true
+	}
+	return false
+}
+
+// Analyze runs the analysis for the given profile type ("cpu", "heap", "allocs", "goroutine", "mutex",
+// "block" or "threadcreate", or an alias accepted by ResolveProfileType) with the given options. It is the entry point for
+// embedding the analyzers in other programs.
+func Analyze(p *profile.Profile, profileType string, opts ...Option) (string, error) {
+	o := NewOptions(opts...)
+	if err := o.validate(); err != nil {
+		return "", err
+	}
+	if err := o.canceled(); err != nil {
+		return "", err
+	}
+	p, stats, err := o.prepare(p)
+	if err != nil {
+		return "", err
+	}
+	if err := o.canceled(); err != nil {
+		return "", err
+	}
+
+	var result string
+	switch resolved := ResolveProfileType(profileType); {
+	case o.GroupByLabel != "" && isAnalyzableProfileType(resolved):
+		result, err = analyzeByLabel(p, resolved, o)
+	case o.GroupBy == "package" && (resolved == "heap" || resolved == "allocs"):
+		result, err = analyzeOwnership(p, resolved, o)
+	case o.Format == "markdown-compact" && isAnalyzableProfileType(resolved):
+		result, err = formatCompactMarkdown(p, resolved, o)
+	case (o.Format == "callgraph" || o.Format == "callgraph-dot" || o.Format == "graphml") && isAnalyzableProfileType(resolved):
+		result, err = formatCallGraph(p, resolved, o)
+	case o.Format == "centrality" && isAnalyzableProfileType(resolved):
+		result, err = formatCentrality(p, resolved, o)
+	case o.Format == "edges" && isAnalyzableProfileType(resolved):
+		result, err = formatCallEdges(p, resolved, o)
+	case o.Format == "folded":
+		result, err = formatFolded(p, resolved, o)
+	case o.Format == "flamegraph-json" && isStackTreeProfileType(resolved):
+		result, err = formatStackTreeFlameGraph(p, resolved, o)
+	case resolved == "cpu":
+		result, err = analyzeCPUProfile(p, o)
+	case resolved == "heap":
+		result, err = analyzeHeapProfile(p, o)
+	case resolved == "allocs":
+		result, err = analyzeAllocsProfile(p, o)
+	case resolved == "goroutine":
+		result, err = analyzeGoroutineProfile(p, o)
+	case resolved == "mutex":
+		result, err = analyzeMutexProfile(p, o)
+	case resolved == "block":
+		result, err = AnalyzeBlockProfile(p, o.TopN, o.Format)
+	case resolved == "threadcreate":
+		result, err = analyzeThreadCreateProfile(p, o)
+	default:
+		return "", fmt.Errorf("unsupported profile type: '%s'", profileType)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := o.canceled(); err != nil {
+		return "", err
+	}
+	// The sample types and the recovery warning (partially recovered profiles are flagged first) are added to
+	// the formats that have a place for them, see ReportsSampleTypes
+	switch o.Format {
+	case "json":
+		return withJSONMeta(result, jsonResultMeta{Warning: RecoveryWarning(p), SampleTypes: SummarizeSampleTypes(p), MinSampleValue: minValueSummary(stats.MinValue, o.MinSampleValue)})
+	case "text", "markdown", "markdown-compact":
+		result = appendSampleTypes(result, p, o.Format)
+		// Text reports say they are approximate; JSON output is left as is for clients that parse it
+		result = o.aggregationNote() + result
+		if ds := stats.Downsampled; ds != nil {
+			result = fmt.Sprintf("Note: downsampled from %s to %s samples (seed %d); values are approximate.\n\n",
+				FormatCount(int64(ds.OriginalSamples)), FormatCount(int64(ds.KeptSamples)), ds.Seed) + result
+		}
+		if mv := stats.MinValue; mv != nil && mv.DroppedSamples > 0 {
+			result = mv.Note(o.MinSampleValue) + "\n\n" + result
+		}
+		if warning := RecoveryWarning(p); warning != "" {
+			result = warning + "\n\n" + result
+		}
+	}
+	return result, nil
+}
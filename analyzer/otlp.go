@@ -0,0 +1,342 @@
+package analyzer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// The types below are the OpenTelemetry profiles data model of opentelemetry-proto's
+// opentelemetry/proto/profiles/v1experimental (pprofextended.proto), in the OTLP/HTTP JSON encoding: field
+// names in lowerCamelCase, enums as integers and IDs as hex strings. Fields this exporter never sets are left
+// out.
+
+// OTLPProfilesData is the body of an OTLP ExportProfilesServiceRequest.
+type OTLPProfilesData struct {
+	ResourceProfiles []OTLPResourceProfiles `json:"resourceProfiles"`
+}
+
+// OTLPResourceProfiles are the profiles of one resource (e.g. one service).
+type OTLPResourceProfiles struct {
+	Resource      OTLPResource        `json:"resource"`
+	ScopeProfiles []OTLPScopeProfiles `json:"scopeProfiles"`
+}
+
+// OTLPResource describes the entity the profiles were collected from.
+type OTLPResource struct {
+	Attributes []OTLPKeyValue `json:"attributes,omitempty"`
+}
+
+// OTLPScopeProfiles are the profiles produced by one instrumentation scope.
+type OTLPScopeProfiles struct {
+	Scope    OTLPScope              `json:"scope"`
+	Profiles []OTLPProfileContainer `json:"profiles"`
+}
+
+// OTLPScope identifies the instrumentation scope: this exporter.
+type OTLPScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// OTLPKeyValue is an attribute.
+type OTLPKeyValue struct {
+	Key   string       `json:"key"`
+	Value OTLPAnyValue `json:"value"`
+}
+
+// OTLPAnyValue is an attribute value; exactly one field is set. Integers are strings, as int64 values are
+// in the proto3 JSON mapping.
+type OTLPAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+// OTLPProfileContainer wraps a profile with its ID and time range.
+type OTLPProfileContainer struct {
+	ProfileID         string      `json:"profileId"` // 16 bytes, hex
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Profile           OTLPProfile `json:"profile"`
+}
+
+// OTLPProfile is a pprofextended Profile. Strings are indices into StringTable, whose first entry is "".
+type OTLPProfile struct {
+	SampleType        []OTLPValueType     `json:"sampleType"`
+	Sample            []OTLPSample        `json:"sample"`
+	Mapping           []OTLPMapping       `json:"mapping,omitempty"`
+	Location          []OTLPLocation      `json:"location"`
+	LocationIndices   []int64             `json:"locationIndices"`
+	Function          []OTLPFunction      `json:"function"`
+	AttributeTable    []OTLPKeyValue      `json:"attributeTable,omitempty"`
+	AttributeUnits    []OTLPAttributeUnit `json:"attributeUnits,omitempty"`
+	StringTable       []string            `json:"stringTable"`
+	DropFrames        int64               `json:"dropFrames,omitempty"`
+	KeepFrames        int64               `json:"keepFrames,omitempty"`
+	TimeNanos         int64               `json:"timeNanos,omitempty"`
+	DurationNanos     int64               `json:"durationNanos,omitempty"`
+	PeriodType        *OTLPValueType      `json:"periodType,omitempty"`
+	Period            int64               `json:"period,omitempty"`
+	Comment           []int64             `json:"comment,omitempty"`
+	DefaultSampleType int64               `json:"defaultSampleType,omitempty"`
+}
+
+// OTLPValueType describes a sample value. Profiles converted from pprof are cumulative over their duration.
+type OTLPValueType struct {
+	Type                   int64 `json:"type"`
+	Unit                   int64 `json:"unit"`
+	AggregationTemporality int   `json:"aggregationTemporality,omitempty"`
+}
+
+// otlpAggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const otlpAggregationTemporalityCumulative = 2
+
+// OTLPSample is a sample: its stack is LocationIndices[LocationsStartIndex:+LocationsLength], leaf first,
+// and its labels are indices into AttributeTable.
+type OTLPSample struct {
+	LocationsStartIndex uint64   `json:"locationsStartIndex"`
+	LocationsLength     uint64   `json:"locationsLength"`
+	Value               []int64  `json:"value"`
+	Attributes          []uint64 `json:"attributes,omitempty"`
+}
+
+// OTLPMapping is a mapped binary.
+type OTLPMapping struct {
+	ID              uint64 `json:"id"`
+	MemoryStart     uint64 `json:"memoryStart,omitempty"`
+	MemoryLimit     uint64 `json:"memoryLimit,omitempty"`
+	FileOffset      uint64 `json:"fileOffset,omitempty"`
+	Filename        int64  `json:"filename,omitempty"`
+	BuildID         int64  `json:"buildId,omitempty"`
+	HasFunctions    bool   `json:"hasFunctions,omitempty"`
+	HasFilenames    bool   `json:"hasFilenames,omitempty"`
+	HasLineNumbers  bool   `json:"hasLineNumbers,omitempty"`
+	HasInlineFrames bool   `json:"hasInlineFrames,omitempty"`
+}
+
+// OTLPLocation is a code location; MappingIndex and FunctionIndex are indices into the profile's tables.
+type OTLPLocation struct {
+	ID           uint64     `json:"id"`
+	MappingIndex uint64     `json:"mappingIndex,omitempty"`
+	Address      uint64     `json:"address,omitempty"`
+	Line         []OTLPLine `json:"line,omitempty"`
+	IsFolded     bool       `json:"isFolded,omitempty"`
+}
+
+// OTLPLine is a (possibly inlined) function at a location, innermost first.
+type OTLPLine struct {
+	FunctionIndex uint64 `json:"functionIndex"`
+	Line          int64  `json:"line,omitempty"`
+	Column        int64  `json:"column,omitempty"`
+}
+
+// OTLPFunction is a function.
+type OTLPFunction struct {
+	ID         uint64 `json:"id"`
+	Name       int64  `json:"name"`
+	SystemName int64  `json:"systemName,omitempty"`
+	Filename   int64  `json:"filename,omitempty"`
+	StartLine  int64  `json:"startLine,omitempty"`
+}
+
+// OTLPAttributeUnit is the unit of a numeric label, by attribute key.
+type OTLPAttributeUnit struct {
+	AttributeKey int64 `json:"attributeKey"`
+	Unit         int64 `json:"unit"`
+}
+
+// otlpConverter builds the deduplicated tables of an OTLPProfile.
+type otlpConverter struct {
+	out        *OTLPProfile
+	strings    map[string]int64
+	attributes map[string]uint64
+	units      map[string]bool
+}
+
+// str returns the index of s in the string table, adding it if needed.
+func (c *otlpConverter) str(s string) int64 {
+	if i, ok := c.strings[s]; ok {
+		return i
+	}
+	i := int64(len(c.out.StringTable))
+	c.out.StringTable = append(c.out.StringTable, s)
+	c.strings[s] = i
+	return i
+}
+
+// attribute returns the index of a label in the attribute table, adding it if needed.
+func (c *otlpConverter) attribute(key string, value OTLPAnyValue) uint64 {
+	id := key + "\x00"
+	if value.StringValue != nil {
+		id += "s" + *value.StringValue
+	} else {
+		id += "i" + *value.IntValue
+	}
+	if i, ok := c.attributes[id]; ok {
+		return i
+	}
+	i := uint64(len(c.out.AttributeTable))
+	c.out.AttributeTable = append(c.out.AttributeTable, OTLPKeyValue{Key: key, Value: value})
+	c.attributes[id] = i
+	return i
+}
+
+// ConvertProfileToOTLP converts a pprof profile into an OpenTelemetry profile (pprofextended), wrapped in an
+// export request for one resource. serviceName, when set, becomes the 'service.name' resource attribute.
+// Sample labels become attributes (numeric labels keep their unit in attributeUnits); the profile ID is
+// derived from the profile's content, so converting the same profile twice gives the same ID.
+func ConvertProfileToOTLP(p *profile.Profile, serviceName string) (*OTLPProfilesData, error) {
+	if len(p.SampleType) == 0 {
+		return nil, fmt.Errorf("the profile has no sample types")
+	}
+	log.Printf("Converting profile to OTLP: %d samples, %d locations, %d functions", len(p.Sample), len(p.Location), len(p.Function))
+
+	out := OTLPProfile{
+		StringTable:   []string{""},
+		TimeNanos:     p.TimeNanos,
+		DurationNanos: p.DurationNanos,
+		Period:        p.Period,
+	}
+	c := &otlpConverter{out: &out, strings: map[string]int64{"": 0}, attributes: make(map[string]uint64), units: make(map[string]bool)}
+
+	for _, st := range p.SampleType {
+		out.SampleType = append(out.SampleType, OTLPValueType{Type: c.str(st.Type), Unit: c.str(st.Unit), AggregationTemporality: otlpAggregationTemporalityCumulative})
+	}
+	if p.PeriodType != nil {
+		out.PeriodType = &OTLPValueType{Type: c.str(p.PeriodType.Type), Unit: c.str(p.PeriodType.Unit)}
+	}
+	if p.DefaultSampleType != "" {
+		out.DefaultSampleType = c.str(p.DefaultSampleType)
+	}
+	if p.DropFrames != "" {
+		out.DropFrames = c.str(p.DropFrames)
+	}
+	if p.KeepFrames != "" {
+		out.KeepFrames = c.str(p.KeepFrames)
+	}
+	for _, comment := range p.Comments {
+		out.Comment = append(out.Comment, c.str(comment))
+	}
+
+	// The tables are indexed by position; pprof refers to mappings, locations and functions by ID
+	mappingIndex := make(map[uint64]uint64, len(p.Mapping))
+	for _, m := range p.Mapping {
+		mappingIndex[m.ID] = uint64(len(out.Mapping))
+		out.Mapping = append(out.Mapping, OTLPMapping{
+			ID: m.ID, MemoryStart: m.Start, MemoryLimit: m.Limit, FileOffset: m.Offset,
+			Filename: c.str(m.File), BuildID: c.str(m.BuildID),
+			HasFunctions: m.HasFunctions, HasFilenames: m.HasFilenames, HasLineNumbers: m.HasLineNumbers, HasInlineFrames: m.HasInlineFrames,
+		})
+	}
+	functionIndex := make(map[*profile.Function]uint64, len(p.Function))
+	addFunction := func(f *profile.Function) uint64 {
+		if i, ok := functionIndex[f]; ok {
+			return i
+		}
+		i := uint64(len(out.Function))
+		out.Function = append(out.Function, OTLPFunction{ID: f.ID, Name: c.str(f.Name), SystemName: c.str(f.SystemName), Filename: c.str(f.Filename), StartLine: f.StartLine})
+		functionIndex[f] = i
+		return i
+	}
+	for _, f := range p.Function {
+		addFunction(f)
+	}
+	locationIndex := make(map[*profile.Location]int64, len(p.Location))
+	addLocation := func(l *profile.Location) int64 {
+		if i, ok := locationIndex[l]; ok {
+			return i
+		}
+		loc := OTLPLocation{ID: l.ID, Address: l.Address, IsFolded: l.IsFolded}
+		if l.Mapping != nil {
+			loc.MappingIndex = mappingIndex[l.Mapping.ID]
+		}
+		for _, line := range l.Line {
+			if line.Function == nil {
+				continue
+			}
+			loc.Line = append(loc.Line, OTLPLine{FunctionIndex: addFunction(line.Function), Line: line.Line, Column: line.Column})
+		}
+		i := int64(len(out.Location))
+		out.Location = append(out.Location, loc)
+		locationIndex[l] = i
+		return i
+	}
+	for _, l := range p.Location {
+		addLocation(l)
+	}
+
+	for _, s := range p.Sample {
+		sample := OTLPSample{LocationsStartIndex: uint64(len(out.LocationIndices)), LocationsLength: uint64(len(s.Location)), Value: s.Value}
+		for _, l := range s.Location {
+			out.LocationIndices = append(out.LocationIndices, addLocation(l))
+		}
+		for _, key := range sortedKeys(s.Label) {
+			for _, v := range s.Label[key] {
+				value := v
+				sample.Attributes = append(sample.Attributes, c.attribute(key, OTLPAnyValue{StringValue: &value}))
+			}
+		}
+		for _, key := range sortedKeys(s.NumLabel) {
+			for i, v := range s.NumLabel[key] {
+				value := fmt.Sprint(v)
+				sample.Attributes = append(sample.Attributes, c.attribute(key, OTLPAnyValue{IntValue: &value}))
+				if units := s.NumUnit[key]; i < len(units) && units[i] != "" && !c.units[key] {
+					c.units[key] = true
+					out.AttributeUnits = append(out.AttributeUnits, OTLPAttributeUnit{AttributeKey: c.str(key), Unit: c.str(units[i])})
+				}
+			}
+		}
+		out.Sample = append(out.Sample, sample)
+	}
+
+	var resource OTLPResource
+	if serviceName != "" {
+		resource.Attributes = append(resource.Attributes, OTLPKeyValue{Key: "service.name", Value: OTLPAnyValue{StringValue: &serviceName}})
+	}
+	return &OTLPProfilesData{ResourceProfiles: []OTLPResourceProfiles{{
+		Resource: resource,
+		ScopeProfiles: []OTLPScopeProfiles{{
+			Scope: OTLPScope{Name: "pprof-analyzer-mcp"},
+			Profiles: []OTLPProfileContainer{{
+				ProfileID:         otlpProfileID(p),
+				StartTimeUnixNano: fmt.Sprint(p.TimeNanos),
+				EndTimeUnixNano:   fmt.Sprint(p.TimeNanos + p.DurationNanos),
+				Profile:           out,
+			}},
+		}},
+	}}}, nil
+}
+
+// sortedKeys returns the keys of a label map in order, so conversions are deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// otlpProfileID derives the 16-byte profile ID from the serialized profile.
+func otlpProfileID(p *profile.Profile) string {
+	h := sha256.New()
+	if err := p.WriteUncompressed(h); err != nil {
+		return hex.EncodeToString(make([]byte, 16))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// FormatOTLPJSON converts a profile (see ConvertProfileToOTLP) and serializes it as an OTLP/HTTP JSON
+// export request.
+func FormatOTLPJSON(p *profile.Profile, serviceName string) ([]byte, error) {
+	data, err := ConvertProfileToOTLP(p, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
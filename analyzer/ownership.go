@@ -0,0 +1,215 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// DefaultOwnershipThreshold is the share of the heap (in percent) above which a package is flagged as a
+// dominant owner when no threshold is given.
+const DefaultOwnershipThreshold = 20.0
+
+// unknownPackage collects samples whose stacks have no Go package name (e.g. cgo or stripped frames).
+const unknownPackage = "(unknown)"
+
+// versionElement matches the gopkg.in style version suffix of a package path element, e.g. "yaml.v3".
+var versionElement = regexp.MustCompile(`^\.v[0-9]+`)
+
+// PackageName returns the import path of the package a Go function belongs to:
+// "github.com/org/repo/pkg.(*T).Method" belongs to "github.com/org/repo/pkg" and "gopkg.in/yaml.v3.(*parser).parse"
+// to "gopkg.in/yaml.v3". It returns an empty string for names that are not qualified by a package.
+func PackageName(function string) string {
+	path := function
+	if i := strings.Index(function, "["); i >= 0 {
+		path = function[:i] // Generic type arguments may contain other packages
+	}
+	lastSlash := strings.LastIndex(path, "/") + 1
+	dot := strings.Index(path[lastSlash:], ".")
+	if dot <= 0 {
+		return ""
+	}
+	end := lastSlash + dot
+	if m := versionElement.FindString(path[end:]); m != "" && strings.HasPrefix(path[end+len(m):], ".") {
+		end += len(m)
+	}
+	return path[:end]
+}
+
+// isStandardPackage reports whether a package belongs to the Go standard library (no dot in the first path
+// element), such as "runtime", "bytes" or "encoding/json". "main" is an application package.
+func isStandardPackage(pkg string) bool {
+	first, _, _ := strings.Cut(pkg, "/")
+	return pkg != "main" && !strings.Contains(first, ".")
+}
+
+// owningPackage attributes a stack (function names, leaf first) to the first package outside the standard
+// library, since allocations made by e.g. bytes.Clone or runtime.makeslice are owned by their caller.
+// Stacks entirely in the standard library are attributed to their leaf package.
+func owningPackage(names []string) string {
+	leaf := ""
+	for _, name := range names {
+		pkg := PackageName(name)
+		if pkg == "" {
+			continue
+		}
+		if leaf == "" {
+			leaf = pkg
+		}
+		if !isStandardPackage(pkg) {
+			return pkg
+		}
+	}
+	if leaf == "" {
+		return unknownPackage
+	}
+	return leaf
+}
+
+// PackageOwnership is the memory owned by one package.
+type PackageOwnership struct {
+	Package        string  `json:"package"`
+	Value          int64   `json:"value"`
+	ValueFormatted string  `json:"valueFormatted"`
+	Percentage     float64 `json:"percentage"`
+	ObjectCount    int64   `json:"objectCount,omitempty"`
+	Dominant       bool    `json:"dominant,omitempty"` // Percentage exceeds the ownership threshold
+}
+
+// OwnershipReport is the "memory ownership" summary of a heap or allocs profile, rolled up by package.
+type OwnershipReport struct {
+	ProfileType         string             `json:"profileType"`
+	ValueType           string             `json:"valueType"`
+	ValueUnit           string             `json:"valueUnit"`
+	TotalValue          int64              `json:"totalValue"`
+	TotalValueFormatted string             `json:"totalValueFormatted"`
+	Threshold           float64            `json:"thresholdPercent"`
+	PackageCount        int                `json:"packageCount"`
+	Packages            []PackageOwnership `json:"packages"`           // Top N packages plus every dominant one
+	Dominant            []string           `json:"dominant,omitempty"` // Packages owning more than Threshold percent
+}
+
+// BuildOwnershipReport rolls the value at valueIndex up to the owning package of each stack (see
+// owningPackage) and flags every package owning more than threshold percent of the total. objectsIndex selects
+// the object count sample type; pass -1 when there is none.
+func BuildOwnershipReport(p *profile.Profile, profileType string, valueIndex, objectsIndex int, threshold float64, topN int) *OwnershipReport {
+	values := make(map[string]int64)
+	objects := make(map[string]int64)
+	var total int64
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= valueIndex {
+			continue
+		}
+		pkg := owningPackage(sampleFunctions(s))
+		values[pkg] += s.Value[valueIndex]
+		total += s.Value[valueIndex]
+		if objectsIndex >= 0 && len(s.Value) > objectsIndex {
+			objects[pkg] += s.Value[objectsIndex]
+		}
+	}
+
+	sampleType := p.SampleType[valueIndex]
+	report := &OwnershipReport{
+		ProfileType:         profileType,
+		ValueType:           sampleType.Type,
+		ValueUnit:           sampleType.Unit,
+		TotalValue:          total,
+		TotalValueFormatted: FormatSampleValue(total, sampleType.Unit),
+		Threshold:           threshold,
+		PackageCount:        len(values),
+		Packages:            make([]PackageOwnership, 0),
+	}
+	all := make([]PackageOwnership, 0, len(values))
+	for pkg, v := range values {
+		percent := 0.0
+		if total != 0 {
+			percent = float64(v) * 100 / float64(total)
+		}
+		all = append(all, PackageOwnership{
+			Package:        pkg,
+			Value:          v,
+			ValueFormatted: FormatSampleValue(v, sampleType.Unit),
+			Percentage:     percent,
+			ObjectCount:    objects[pkg],
+			Dominant:       percent > threshold,
+		})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Value != all[j].Value {
+			return all[i].Value > all[j].Value
+		}
+		return all[i].Package < all[j].Package
+	})
+	for i, stat := range all {
+		if i < topN || stat.Dominant {
+			report.Packages = append(report.Packages, stat)
+		}
+		if stat.Dominant {
+			report.Dominant = append(report.Dominant, stat.Package)
+		}
+	}
+	return report
+}
+
+// analyzeOwnership is the group_by=package mode of heap and allocs analyses (see WithGroupBy).
+func analyzeOwnership(p *profile.Profile, profileType string, o Options) (string, error) {
+	valueIndex, err := o.sampleIndex(p)
+	if err != nil {
+		return "", err
+	}
+	if valueIndex == -1 {
+		valueIndex = DefaultSampleIndex(p)
+	}
+	if valueIndex == -1 {
+		return "", fmt.Errorf("profile has no sample types")
+	}
+	threshold := o.OwnershipThreshold
+	if threshold == 0 {
+		threshold = DefaultOwnershipThreshold
+	}
+	log.Printf("Analyzing %s ownership by package (SampleType: %s, Threshold: %.1f%%)", profileType, p.SampleType[valueIndex].Type, threshold)
+	report := BuildOwnershipReport(p, profileType, valueIndex, matchingObjectsIndex(p, valueIndex, -1), threshold, o.TopN)
+	return FormatOwnershipReport(report, o.Format)
+}
+
+// FormatOwnershipReport formats an ownership report as "text", "markdown" (also used for "markdown-compact",
+// as the summary is short) or "json".
+func FormatOwnershipReport(report *OwnershipReport, format string) (string, error) {
+	switch format {
+	case "text", "markdown", "markdown-compact":
+		w := newReportWriter(format)
+		w.title("Memory Ownership by Package (%s %s, %d packages)", report.ProfileType, report.ValueType, report.PackageCount)
+		w.line("Total %s: %s", report.ValueType, report.TotalValueFormatted)
+		if len(report.Dominant) > 0 {
+			w.line("Dominant owners (> %.1f%% of the total): %s", report.Threshold, strings.Join(report.Dominant, ", "))
+		} else {
+			w.line("No package owns more than %.1f%% of the total.", report.Threshold)
+		}
+		t := newTable(valueColumn(report.ValueType), valueColumn("%"), textColumn("Dominant", 8), nameColumn("Package"))
+		for _, stat := range report.Packages {
+			dominant := ""
+			if stat.Dominant {
+				dominant = "yes"
+			}
+			t.add(stat.ValueFormatted, percentString(stat.Percentage), dominant, stat.Package)
+		}
+		w.table(t)
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling ownership report to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format for group_by 'package': %s (supported: text, markdown, markdown-compact, json)", format)
+	}
+}
@@ -0,0 +1,273 @@
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// perfScriptHeader matches the first line of a sample in 'perf script' output: the command (which may
+// contain spaces), the pid with an optional /tid, then the optional [cpu], timestamp, period and event, as
+// printed by the default fields and by '-F comm,pid,tid,cpu,time,period,event,ip,sym,dso'. Anything after
+// the event (the ip, sym and dso of a sample recorded without call chains, or tracepoint arguments) is kept
+// in the last group.
+var perfScriptHeader = regexp.MustCompile(`^(\S.*?)\s+(\d+)(?:/(\d+))?(?:\s+\[\d+\])?(?:\s+(\d+\.\d+):)?(?:\s+(\d+))?(?:\s+([A-Za-z]\S*):)?(?:\s+(.*))?$`)
+
+// perfEventModifiers are the modifier letters perf appends to event names ("cycles:u", "cpu-clock:pppH").
+var perfEventModifiers = regexp.MustCompile(`:[ukhIGHpPSDWe]+$`)
+
+// perfSymbolOffset is the "+0x1c" offset perf appends to symbols with '-F +symoff'.
+var perfSymbolOffset = regexp.MustCompile(`\+0x[0-9a-fA-F]+$`)
+
+// perfScriptSniffBytes is how much of a file IsPerfScript looks at.
+const perfScriptSniffBytes = 64 << 10
+
+// IsPerfScript reports whether data looks like the text output of Linux 'perf script': after optional '#'
+// comment lines, a sample header line with a timestamp or an event (see perfScriptHeader), which tells it
+// from the legacy text profiles pprof parses itself, followed by an indented stack frame or another header.
+func IsPerfScript(data []byte) bool {
+	if len(data) > perfScriptSniffBytes {
+		data = data[:perfScriptSniffBytes]
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return false // Binary, e.g. a gzipped or raw pprof profile
+	}
+	isHeader := func(line string) bool {
+		m := perfScriptHeader.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		return m != nil && (m[4] != "" || m[6] != "")
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isHeader(line) {
+			return false
+		}
+		for _, next := range lines[i+1:] {
+			if strings.TrimSpace(next) == "" {
+				continue
+			}
+			return next[0] == ' ' || next[0] == '\t' || isHeader(next)
+		}
+		return true // A single sample without a call chain
+	}
+	return false
+}
+
+// perfSampleType returns the sample type of a perf event: "cpu" in nanoseconds for the software clocks,
+// whose period is a duration, and the event name counted otherwise.
+func perfSampleType(event string) *profile.ValueType {
+	event = perfEventModifiers.ReplaceAllString(event, "")
+	switch event {
+	case "cpu-clock", "task-clock":
+		return &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+	}
+	return &profile.ValueType{Type: event, Unit: "count"}
+}
+
+// perfScriptParser builds a profile from 'perf script' output, deduplicating mappings (one per DSO),
+// functions and locations.
+type perfScriptParser struct {
+	p         *profile.Profile
+	columns   map[string]int // Sample type -> value index
+	mappings  map[string]*profile.Mapping
+	functions map[string]*profile.Function
+	locations map[string]*profile.Location
+}
+
+// column returns the value index of an event, adding a sample type for it if needed.
+func (ps *perfScriptParser) column(event string) int {
+	st := perfSampleType(event)
+	if i, ok := ps.columns[st.Type]; ok {
+		return i
+	}
+	i := len(ps.p.SampleType)
+	ps.p.SampleType = append(ps.p.SampleType, st)
+	ps.columns[st.Type] = i
+	return i
+}
+
+// location returns the location of a stack frame line ("ffffffff8100 native_safe_halt+0x6 ([kernel.kallsyms])"
+// or, without addresses, "main.work (/app/bin)"); ok is false for lines that are not frames.
+func (ps *perfScriptParser) location(line string) (*profile.Location, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, false
+	}
+	dso := ""
+	if strings.HasSuffix(line, ")") {
+		// The symbol itself may contain parentheses (C++ signatures): the DSO is the last group
+		if i := strings.LastIndex(line, " ("); i >= 0 {
+			dso, line = line[i+2:len(line)-1], strings.TrimSpace(line[:i])
+		} else if strings.HasPrefix(line, "(") {
+			dso, line = line[1:len(line)-1], ""
+		}
+	}
+	var address uint64
+	if first, rest, _ := strings.Cut(line, " "); first != "" {
+		if a, err := strconv.ParseUint(first, 16, 64); err == nil {
+			address, line = a, strings.TrimSpace(rest)
+		}
+	}
+	symbol := perfSymbolOffset.ReplaceAllString(line, "")
+	if symbol == "" {
+		symbol = "[unknown]"
+	}
+
+	key := fmt.Sprintf("%s\x00%x\x00%s", dso, address, symbol)
+	if loc, ok := ps.locations[key]; ok {
+		return loc, true
+	}
+	mapping, ok := ps.mappings[dso]
+	if !ok {
+		mapping = &profile.Mapping{ID: uint64(len(ps.p.Mapping) + 1), File: dso, HasFunctions: true}
+		ps.p.Mapping = append(ps.p.Mapping, mapping)
+		ps.mappings[dso] = mapping
+	}
+	fn, ok := ps.functions[dso+"\x00"+symbol]
+	if !ok {
+		fn = &profile.Function{ID: uint64(len(ps.p.Function) + 1), Name: symbol, SystemName: symbol}
+		ps.p.Function = append(ps.p.Function, fn)
+		ps.functions[dso+"\x00"+symbol] = fn
+	}
+	loc := &profile.Location{ID: uint64(len(ps.p.Location) + 1), Mapping: mapping, Address: address, Line: []profile.Line{{Function: fn}}}
+	ps.p.Location = append(ps.p.Location, loc)
+	ps.locations[key] = loc
+	return loc, true
+}
+
+// ParsePerfScript converts the text output of Linux 'perf script' (e.g. 'perf record -g' followed by
+// 'perf script > out.perf') into a pprof profile, so non-Go CPU profiles go through the same analyses as Go
+// ones. Every sample counts once in "samples"; its period is added to the sample type of its event: "cpu"
+// in nanoseconds for cpu-clock and task-clock, the event name (e.g. "cycles") otherwise. Stacks are leaf
+// first, as perf prints them; the command, pid and tid become the "comm" label and the "pid" and "tid"
+// numeric labels. Samples without a period count 1.
+func ParsePerfScript(data []byte) (*profile.Profile, error) {
+	ps := &perfScriptParser{
+		p:         &profile.Profile{SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}}},
+		columns:   map[string]int{"samples": 0},
+		mappings:  make(map[string]*profile.Mapping),
+		functions: make(map[string]*profile.Function),
+		locations: make(map[string]*profile.Location),
+	}
+	var (
+		current          *profile.Sample
+		currentColumn    int
+		currentPeriod    int64
+		first, last      float64
+		lineNo, skipped  int
+		sawTimestamp     bool
+		defaultEventType string
+	)
+	finish := func() {
+		if current == nil {
+			return
+		}
+		value := make([]int64, len(ps.p.SampleType))
+		value[0] = 1
+		if currentColumn > 0 {
+			value[currentColumn] = currentPeriod
+		}
+		current.Value = value
+		ps.p.Sample = append(ps.p.Sample, current)
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20) // C++ symbols can be long
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#"):
+			finish()
+		case line[0] == ' ' || line[0] == '\t':
+			if current == nil {
+				skipped++ // A frame without a header, e.g. after a truncated sample
+				continue
+			}
+			if loc, ok := ps.location(line); ok {
+				current.Location = append(current.Location, loc)
+			}
+		default:
+			finish()
+			m := perfScriptHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d is neither a perf script sample header nor a stack frame: %q", lineNo, line)
+			}
+			current = &profile.Sample{
+				Label:    map[string][]string{"comm": {m[1]}},
+				NumLabel: map[string][]int64{},
+			}
+			if pid, err := strconv.ParseInt(m[2], 10, 64); err == nil {
+				current.NumLabel["pid"] = []int64{pid}
+			}
+			if tid, err := strconv.ParseInt(m[3], 10, 64); err == nil {
+				current.NumLabel["tid"] = []int64{tid}
+			}
+			if ts, err := strconv.ParseFloat(m[4], 64); err == nil {
+				if !sawTimestamp || ts < first {
+					first = ts
+				}
+				if !sawTimestamp || ts > last {
+					last = ts
+				}
+				sawTimestamp = true
+			}
+			currentColumn, currentPeriod = 0, 1
+			if period, err := strconv.ParseInt(m[5], 10, 64); err == nil {
+				currentPeriod = period
+			}
+			if m[6] != "" {
+				currentColumn = ps.column(m[6])
+				if defaultEventType == "" {
+					defaultEventType = ps.p.SampleType[currentColumn].Type
+				}
+			}
+			// Without call chains, the ip, sym and dso of the sample follow the event on the same line
+			if rest := m[7]; rest != "" {
+				if address, _, _ := strings.Cut(rest, " "); address != "" {
+					if _, err := strconv.ParseUint(address, 16, 64); err == nil {
+						if loc, ok := ps.location(rest); ok {
+							current.Location = append(current.Location, loc)
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read perf script output: %w", err)
+	}
+	finish()
+	if len(ps.p.Sample) == 0 {
+		return nil, fmt.Errorf("no samples found in perf script output")
+	}
+
+	// Samples read before a new event was seen have fewer values
+	for _, s := range ps.p.Sample {
+		for len(s.Value) < len(ps.p.SampleType) {
+			s.Value = append(s.Value, 0)
+		}
+	}
+	if defaultEventType != "" {
+		ps.p.DefaultSampleType = defaultEventType
+		ps.p.PeriodType = ps.p.SampleType[ps.columns[defaultEventType]]
+	}
+	if sawTimestamp {
+		ps.p.DurationNanos = int64((last - first) * 1e9)
+	}
+	log.Printf("Parsed perf script output: %d samples, %d functions, %d mappings (%d stray frame lines skipped)",
+		len(ps.p.Sample), len(ps.p.Function), len(ps.p.Mapping), skipped)
+	if err := ps.p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid profile built from perf script output: %w", err)
+	}
+	return ps.p, nil
+}
@@ -8,19 +8,6 @@ import (
 	"github.com/google/pprof/profile"
 )
 
-// AnalyzeMutexProfile 分析 Mutex profile (锁竞争情况)。
-func AnalyzeMutexProfile(p *profile.Profile, topN int, format string) (string, error) {
-	log.Printf("analyzeMutexProfile called (Top %d, Format: %s) - Implementation Pending", topN, format)
-	if format == "json" {
-		errorResult := ErrorResult{Error: "JSON output not yet implemented for mutex profile", TopN: topN} // 使用 types.go 中的结构体
-		jsonBytes, _ := json.MarshalIndent(errorResult, "", "  ")
-		return string(jsonBytes), nil
-	}
-	// TODO: 实现实际的 Mutex profile 分析 (竞争分析)
-	// 样本类型：contentions/count, delay/nanoseconds
-	return fmt.Sprintf("Mutex Analysis Result (Top %d, Format: %s)\n[Implementation Pending]", topN, format), nil
-}
-
 // AnalyzeBlockProfile 分析 Block profile (阻塞情况)。
 func AnalyzeBlockProfile(p *profile.Profile, topN int, format string) (string, error) {
 	log.Printf("analyzeBlockProfile called (Top %d, Format: %s) - Implementation Pending", topN, format)
@@ -0,0 +1,308 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// PoolSiteStat describes the allocations made through one sync.Pool, identified by the
+// function calling (*sync.Pool).Get.
+type PoolSiteStat struct {
+	Owner                     string  `json:"owner"`
+	NewFunction               string  `json:"newFunction,omitempty"`
+	MissBytes                 int64   `json:"missBytes"`
+	MissBytesFormatted        string  `json:"missBytesFormatted"`
+	MissObjects               int64   `json:"missObjects,omitempty"`
+	OwnerCumBytes             int64   `json:"ownerCumBytes"`
+	MissShare                 float64 `json:"missShare"` // Percentage of the owner's allocations caused by pool misses
+	BaselineOwnerCumBytes     int64   `json:"baselineOwnerCumBytes,omitempty"`
+	EstimatedSavings          int64   `json:"estimatedSavings,omitempty"`
+	EstimatedSavingsFormatted string  `json:"estimatedSavingsFormatted,omitempty"`
+}
+
+// PoolAnalysisResult is the JSON result of the sync.Pool effectiveness analysis.
+type PoolAnalysisResult struct {
+	ProfileType          string         `json:"profileType"`
+	ValueType            string         `json:"valueType"`
+	TotalBytes           int64          `json:"totalBytes"`
+	TotalBytesFormatted  string         `json:"totalBytesFormatted"`
+	PoolBytes            int64          `json:"poolBytes"`
+	PoolBytesFormatted   string         `json:"poolBytesFormatted"`
+	DirectBytes          int64          `json:"directBytes"`
+	DirectBytesFormatted string         `json:"directBytesFormatted"`
+	BaselineTotalBytes   int64          `json:"baselineTotalBytes,omitempty"` // Scaled by BaselineScale
+	HasBaseline          bool           `json:"hasBaseline"`
+	BaselineScale        float64        `json:"baselineScale,omitempty"` // Factor scaling the baseline to the profile's duration, 1 without durations
+	TopN                 int            `json:"topN"`
+	Pools                []PoolSiteStat `json:"pools"`
+}
+
+// allocValueIndices returns the indices of the allocated bytes and object count sample values,
+// preferring alloc_* over inuse_* types.
+func allocValueIndices(p *profile.Profile) (spaceIndex, objectsIndex int) {
+	spaceIndex, objectsIndex = -1, -1
+	for i, st := range p.SampleType {
+		if st.Type == "alloc_space" && st.Unit == "bytes" {
+			spaceIndex = i
+		}
+		if st.Type == "alloc_objects" && st.Unit == "count" {
+			objectsIndex = i
+		}
+	}
+	for i, st := range p.SampleType {
+		if spaceIndex == -1 && st.Type == "inuse_space" && st.Unit == "bytes" {
+			spaceIndex = i
+		}
+		if objectsIndex == -1 && st.Type == "inuse_objects" && st.Unit == "count" {
+			objectsIndex = i
+		}
+	}
+	return spaceIndex, objectsIndex
+}
+
+// sampleFunctions returns the function names of a sample's stack, leaf first, including inlined frames.
+func sampleFunctions(s *profile.Sample) []string {
+	names := make([]string, 0, len(s.Location))
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function != nil {
+				names = append(names, line.Function.Name)
+			}
+		}
+	}
+	return names
+}
+
+// cumBytesByFunction sums a sample value for every function appearing in the stack (once per sample).
+func cumBytesByFunction(p *profile.Profile, valueIndex int, wanted map[string]bool) map[string]int64 {
+	cum := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, name := range sampleFunctions(s) {
+			if wanted[name] && !seen[name] {
+				cum[name] += s.Value[valueIndex]
+				seen[name] = true
+			}
+		}
+	}
+	return cum
+}
+
+// AnalyzePoolEffectiveness reports allocation sites that flow through sync.Pool (allocations made by
+// the pool's New function on a pool miss) versus direct allocation. When a baseline profile taken before
+// the pools were introduced is given, the owners' cumulative allocations are compared to estimate savings.
+// Allocations grow with the time a profile covers, so when both profiles have a duration (delta profiles,
+// e.g. captured with 'seconds'), the baseline is scaled to the current profile's duration. Otherwise they are
+// compared as they are, which is only meaningful for profiles covering the same duration and load.
+func AnalyzePoolEffectiveness(current, baseline *profile.Profile, topN int, format string) (string, error) {
+	log.Printf("Analyzing sync.Pool effectiveness (Top %d, Format: %s, Baseline: %t)", topN, format, baseline != nil)
+
+	spaceIndex, objectsIndex := allocValueIndices(current)
+	if spaceIndex == -1 {
+		return "", fmt.Errorf("could not find alloc_space or inuse_space sample type in the profile")
+	}
+	valueType := current.SampleType[spaceIndex].Type
+
+	type poolAgg struct {
+		newFunction string
+		bytes       int64
+		objects     int64
+	}
+	pools := make(map[string]*poolAgg)
+	totalBytes, poolBytes := int64(0), int64(0)
+
+	for _, s := range current.Sample {
+		if len(s.Value) <= spaceIndex {
+			continue
+		}
+		v := s.Value[spaceIndex]
+		totalBytes += v
+
+		names := sampleFunctions(s)
+		getIndex := -1
+		for i, name := range names {
+			if name == "sync.(*Pool).Get" {
+				getIndex = i
+				break
+			}
+		}
+		if getIndex == -1 {
+			continue
+		}
+		poolBytes += v
+
+		// Owner: first non-sync caller above Get; New function: closest non-runtime frame below Get
+		owner := "unknown"
+		for _, name := range names[getIndex+1:] {
+			if !strings.HasPrefix(name, "sync.") {
+				owner = name
+				break
+			}
+		}
+		newFunction := ""
+		for i := getIndex - 1; i >= 0; i-- {
+			if !strings.HasPrefix(names[i], "runtime.") && !strings.HasPrefix(names[i], "sync.") {
+				newFunction = names[i]
+				break
+			}
+		}
+
+		agg, ok := pools[owner]
+		if !ok {
+			agg = &poolAgg{newFunction: newFunction}
+			pools[owner] = agg
+		}
+		agg.bytes += v
+		if objectsIndex >= 0 && len(s.Value) > objectsIndex {
+			agg.objects += s.Value[objectsIndex]
+		}
+	}
+
+	owners := make(map[string]bool, len(pools))
+	for owner := range pools {
+		owners[owner] = true
+	}
+	currentCum := cumBytesByFunction(current, spaceIndex, owners)
+	var baselineCum map[string]int64
+	baselineTotal := int64(0)
+	baselineScale := 0.0
+	if baseline != nil {
+		baselineSpaceIndex, _ := allocValueIndices(baseline)
+		if baselineSpaceIndex == -1 {
+			return "", fmt.Errorf("could not find alloc_space or inuse_space sample type in the baseline profile")
+		}
+		baselineScale = 1
+		if current.DurationNanos > 0 && baseline.DurationNanos > 0 {
+			baselineScale = float64(current.DurationNanos) / float64(baseline.DurationNanos)
+		}
+		baselineCum = cumBytesByFunction(baseline, baselineSpaceIndex, owners)
+		for owner, v := range baselineCum {
+			baselineCum[owner] = int64(float64(v) * baselineScale)
+		}
+		for _, s := range baseline.Sample {
+			if len(s.Value) > baselineSpaceIndex {
+				baselineTotal += s.Value[baselineSpaceIndex]
+			}
+		}
+		baselineTotal = int64(float64(baselineTotal) * baselineScale)
+	}
+
+	stats := make([]PoolSiteStat, 0, len(pools))
+	for owner, agg := range pools {
+		stat := PoolSiteStat{
+			Owner:              owner,
+			NewFunction:        agg.newFunction,
+			MissBytes:          agg.bytes,
+			MissBytesFormatted: FormatBytes(agg.bytes),
+			MissObjects:        agg.objects,
+			OwnerCumBytes:      currentCum[owner],
+		}
+		if stat.OwnerCumBytes > 0 {
+			stat.MissShare = float64(agg.bytes) / float64(stat.OwnerCumBytes) * 100
+		}
+		if baselineCum != nil {
+			stat.BaselineOwnerCumBytes = baselineCum[owner]
+			stat.EstimatedSavings = stat.BaselineOwnerCumBytes - stat.OwnerCumBytes
+			stat.EstimatedSavingsFormatted = FormatBytes(stat.EstimatedSavings)
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].MissBytes != stats[j].MissBytes {
+			return stats[i].MissBytes > stats[j].MissBytes
+		}
+		return stats[i].Owner < stats[j].Owner
+	})
+
+	limit := topN
+	if limit > len(stats) {
+		limit = len(stats)
+	}
+	directBytes := totalBytes - poolBytes
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("sync.Pool Effectiveness Analysis (Top %d Pools by %s)", topN, valueType)
+		w.line("Total %s: %s", valueType, FormatBytes(totalBytes))
+		poolPercent, directPercent := 0.0, 0.0
+		if totalBytes > 0 {
+			poolPercent = float64(poolBytes) / float64(totalBytes) * 100
+			directPercent = float64(directBytes) / float64(totalBytes) * 100
+		}
+		w.line("Allocated via sync.Pool New (pool misses): %s (%.2f%%)", FormatBytes(poolBytes), poolPercent)
+		w.line("Direct allocation: %s (%.2f%%)", FormatBytes(directBytes), directPercent)
+		if baseline != nil {
+			if baselineScale != 1 {
+				w.line("Baseline total %s: %s (scaled by %.2f to the duration of the profile)", valueType, FormatBytes(baselineTotal), baselineScale)
+			} else {
+				w.line("Baseline total %s: %s", valueType, FormatBytes(baselineTotal))
+			}
+			if baseline.DurationNanos == 0 || current.DurationNanos == 0 {
+				w.line("Note: the profiles have no duration, so savings assume they cover the same duration and load.")
+			}
+		}
+		if limit == 0 {
+			w.line("No allocations flowing through sync.Pool were found.")
+			return w.String(), nil
+		}
+		// Miss% 是池未命中分配占所属函数累计分配的比例
+		columns := []tableColumn{valueColumn("Pool Misses"), valueColumn("Objects"), valueColumn("Miss%"), valueColumn("Owner Cum")}
+		if baseline != nil {
+			columns = append(columns, valueColumn("Before Pooling"), valueColumn("Est. Savings"))
+		}
+		t := newTable(append(columns, nameColumn("Pool Owner"))...)
+		for _, stat := range stats[:limit] {
+			objects := ""
+			if stat.MissObjects > 0 {
+				objects = FormatSampleValue(stat.MissObjects, "count")
+			}
+			cells := []string{stat.MissBytesFormatted, objects, percentString(stat.MissShare), FormatBytes(stat.OwnerCumBytes)}
+			if baseline != nil {
+				cells = append(cells, FormatBytes(stat.BaselineOwnerCumBytes), stat.EstimatedSavingsFormatted)
+			}
+			t.add(append(cells, stat.Owner)...)
+			if stat.NewFunction != "" {
+				t.note("  New function: " + stat.NewFunction)
+			}
+		}
+		w.table(t)
+		return w.String(), nil
+
+	case "json":
+		result := PoolAnalysisResult{
+			ProfileType:          "allocs",
+			ValueType:            valueType,
+			TotalBytes:           totalBytes,
+			TotalBytesFormatted:  FormatBytes(totalBytes),
+			PoolBytes:            poolBytes,
+			PoolBytesFormatted:   FormatBytes(poolBytes),
+			DirectBytes:          directBytes,
+			DirectBytesFormatted: FormatBytes(directBytes),
+			BaselineTotalBytes:   baselineTotal,
+			HasBaseline:          baseline != nil,
+			BaselineScale:        baselineScale,
+			TopN:                 limit,
+			Pools:                stats[:limit],
+		}
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling pool analysis to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
@@ -0,0 +1,222 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// PprofConfig holds the settings saved by the 'go tool pprof' web UI (Config > Save as ...).
+// Field names follow pprof's own JSON encoding so saved files can be read unchanged.
+type PprofConfig struct {
+	Name         string  `json:"name,omitempty"`
+	Focus        string  `json:"focus,omitempty"`
+	Ignore       string  `json:"ignore,omitempty"`
+	Hide         string  `json:"hide,omitempty"`
+	Show         string  `json:"show,omitempty"`
+	ShowFrom     string  `json:"show_from,omitempty"`
+	PruneFrom    string  `json:"prune_from,omitempty"`
+	TagFocus     string  `json:"tagfocus,omitempty"`
+	TagIgnore    string  `json:"tagignore,omitempty"`
+	Granularity  string  `json:"granularity,omitempty"`
+	NoInlines    bool    `json:"noinlines,omitempty"`
+	DropNegative bool    `json:"drop_negative,omitempty"`
+	NodeCount    int     `json:"nodecount,omitempty"`
+	NodeFraction float64 `json:"nodefraction,omitempty"`
+	Sort         string  `json:"sort,omitempty"`
+}
+
+// pprofSettings is the layout of pprof's settings.json: a list of named configs.
+type pprofSettings struct {
+	Configs []PprofConfig `json:"configs"`
+}
+
+// ParsePprofConfig reads a pprof settings file (with a "configs" list) or a single config object.
+// When the file holds several configs, name selects one; it may be empty if there is exactly one.
+func ParsePprofConfig(data []byte, name string) (*PprofConfig, error) {
+	var settings pprofSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse pprof config: %w", err)
+	}
+	if settings.Configs == nil {
+		var cfg PprofConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse pprof config: %w", err)
+		}
+		return &cfg, nil
+	}
+
+	names := make([]string, 0, len(settings.Configs))
+	for i := range settings.Configs {
+		if settings.Configs[i].Name == name || (name == "" && len(settings.Configs) == 1) {
+			return &settings.Configs[i], nil
+		}
+		names = append(names, settings.Configs[i].Name)
+	}
+	sort.Strings(names)
+	if name == "" {
+		return nil, fmt.Errorf("pprof settings contain %d configs, specify one of: %s", len(names), strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("pprof config '%s' not found (available: %s)", name, strings.Join(names, ", "))
+}
+
+// compileOptional compiles a regular expression, returning nil for an empty pattern.
+func compileOptional(field, expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	rx, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s regular expression '%s': %w", field, expr, err)
+	}
+	return rx, nil
+}
+
+// Apply filters and aggregates the profile in place the way the pprof web UI would with this config.
+// It returns notes describing what was applied and which settings have no effect on these analyses.
+func (c *PprofConfig) Apply(p *profile.Profile) ([]string, error) {
+	notes := make([]string, 0)
+
+	focus, err := compileOptional("focus", c.Focus)
+	if err != nil {
+		return nil, err
+	}
+	ignore, err := compileOptional("ignore", c.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	hide, err := compileOptional("hide", c.Hide)
+	if err != nil {
+		return nil, err
+	}
+	show, err := compileOptional("show", c.Show)
+	if err != nil {
+		return nil, err
+	}
+	showFrom, err := compileOptional("show_from", c.ShowFrom)
+	if err != nil {
+		return nil, err
+	}
+	pruneFrom, err := compileOptional("prune_from", c.PruneFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	if focus != nil || ignore != nil || hide != nil || show != nil {
+		fm, im, hm, hnm := p.FilterSamplesByName(focus, ignore, hide, show)
+		for _, f := range []struct {
+			rx      *regexp.Regexp
+			name    string
+			matched bool
+		}{{focus, "focus", fm}, {ignore, "ignore", im}, {hide, "hide", hm}, {show, "show", hnm}} {
+			if f.rx == nil {
+				continue
+			}
+			if f.matched {
+				notes = append(notes, fmt.Sprintf("%s=%s applied", f.name, f.rx))
+			} else {
+				notes = append(notes, fmt.Sprintf("%s=%s matched no frames", f.name, f.rx))
+			}
+		}
+	}
+	if showFrom != nil {
+		if p.ShowFrom(showFrom) {
+			notes = append(notes, fmt.Sprintf("show_from=%s applied", showFrom))
+		} else {
+			notes = append(notes, fmt.Sprintf("show_from=%s matched no frames", showFrom))
+		}
+	}
+	if pruneFrom != nil {
+		p.PruneFrom(pruneFrom)
+		notes = append(notes, fmt.Sprintf("prune_from=%s applied", pruneFrom))
+	}
+
+	if c.DropNegative {
+		kept := p.Sample[:0]
+		for _, s := range p.Sample {
+			negative := false
+			for _, v := range s.Value {
+				if v < 0 {
+					negative = true
+					break
+				}
+			}
+			if !negative {
+				kept = append(kept, s)
+			}
+		}
+		p.Sample = kept
+		notes = append(notes, "drop_negative applied")
+	}
+
+	if c.Granularity != "" || c.NoInlines {
+		inlines := !c.NoInlines
+		var function, filename, linenumber, address bool
+		switch c.Granularity {
+		case "", "functions":
+			function = true
+		case "filefunctions":
+			function, filename = true, true
+		case "files":
+			inlines, filename = false, true
+		case "lines":
+			function, filename, linenumber = true, true, true
+		case "addresses":
+			function, filename, linenumber, address = true, true, true, true
+		default:
+			return nil, fmt.Errorf("unsupported granularity '%s' (expected functions, filefunctions, files, lines or addresses)", c.Granularity)
+		}
+		if err := p.Aggregate(inlines, function, filename, linenumber, false, address); err != nil {
+			return nil, fmt.Errorf("failed to aggregate profile by %s: %w", c.Granularity, err)
+		}
+		granularity := c.Granularity
+		if granularity == "" {
+			granularity = "functions"
+		}
+		notes = append(notes, fmt.Sprintf("granularity=%s applied (noinlines=%t)", granularity, c.NoInlines))
+	}
+
+	// Graph rendering settings do not affect the tabular analyses; report them instead of silently dropping them
+	ignored := make([]string, 0)
+	if c.TagFocus != "" || c.TagIgnore != "" {
+		ignored = append(ignored, "tagfocus/tagignore")
+	}
+	if c.NodeCount != 0 || c.NodeFraction != 0 {
+		ignored = append(ignored, "nodecount/nodefraction")
+	}
+	if c.Sort != "" {
+		ignored = append(ignored, "sort")
+	}
+	if len(ignored) > 0 {
+		notes = append(notes, fmt.Sprintf("not applied (graph-only or unsupported): %s", strings.Join(ignored, ", ")))
+	}
+	return notes, nil
+}
+
+// PprofFlags returns the 'go tool pprof' flags applying the settings Apply applies, to reproduce an analysis
+// of a profile with this config on the command line.
+func (c *PprofConfig) PprofFlags() []string {
+	flags := make([]string, 0)
+	for _, f := range []struct{ name, value string }{
+		{"focus", c.Focus}, {"ignore", c.Ignore}, {"hide", c.Hide}, {"show", c.Show},
+		{"show_from", c.ShowFrom}, {"prune_from", c.PruneFrom},
+	} {
+		if f.value != "" {
+			flags = append(flags, "-"+f.name+"="+f.value)
+		}
+	}
+	if c.DropNegative {
+		flags = append(flags, "-drop_negative")
+	}
+	if c.Granularity != "" {
+		flags = append(flags, "-"+c.Granularity)
+	}
+	if c.NoInlines {
+		flags = append(flags, "-noinlines")
+	}
+	return flags
+}
@@ -0,0 +1,531 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/pprof/profile"
+)
+
+// QueryDefaultLimit is the number of groups returned when a query has no LIMIT clause.
+const QueryDefaultLimit = 20
+
+// queryToken is a lexical token of the query language.
+type queryToken struct {
+	kind string // "ident", "string", "number", "op", ","
+	text string
+}
+
+// queryCondition is one "field op value" predicate of a WHERE clause.
+type queryCondition struct {
+	field string
+	op    string
+	value string
+	rx    *regexp.Regexp
+	num   int64
+}
+
+// ProfileQuery is a parsed query_profile statement:
+//
+//	SELECT <sample type>[, ...] | *
+//	[WHERE <field> <op> <value> [AND ...]]
+//	[GROUP BY <key>[, ...]]
+//	[ORDER BY <sample type> [ASC|DESC]]
+//	[LIMIT <n>]
+//
+// Fields and keys: function (WHERE: any frame; GROUP BY: leaf), leaf, root, file (leaf file),
+// label.<key>, numlabel.<key>. Operators: = != ~ !~ (regex) for strings; = != > >= < <= for numlabels.
+type ProfileQuery struct {
+	Select     []string
+	Where      []queryCondition
+	GroupBy    []string
+	OrderBy    string
+	Descending bool
+	Limit      int
+}
+
+// tokenizeQuery splits a query into tokens.
+func tokenizeQuery(q string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	for i := 0; i < len(q); {
+		c := rune(q[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == ',':
+			tokens = append(tokens, queryToken{kind: ",", text: ","})
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(q) && q[end] != q[i] {
+				if q[end] == '\\' && q[i] == '"' {
+					end++
+				}
+				end++
+			}
+			if end >= len(q) {
+				return nil, fmt.Errorf("unterminated string starting at position %d", i)
+			}
+			text := q[i+1 : end]
+			if q[i] == '"' {
+				unquoted, err := strconv.Unquote(q[i : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid string %s: %w", q[i:end+1], err)
+				}
+				text = unquoted
+			}
+			tokens = append(tokens, queryToken{kind: "string", text: text})
+			i = end + 1
+		case strings.ContainsRune("=!~<>", c):
+			op := string(c)
+			if i+1 < len(q) {
+				switch q[i : i+2] {
+				case "!=", "!~", ">=", "<=":
+					op = q[i : i+2]
+				}
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at position %d (use != or !~)", i)
+			}
+			tokens = append(tokens, queryToken{kind: "op", text: op})
+			i += len(op)
+		case c == '*':
+			tokens = append(tokens, queryToken{kind: "ident", text: "*"})
+			i++
+		case c == '-' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(q) && unicode.IsDigit(rune(q[end])) {
+				end++
+			}
+			tokens = append(tokens, queryToken{kind: "number", text: q[i:end]})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(q) && (unicode.IsLetter(rune(q[end])) || unicode.IsDigit(rune(q[end])) || strings.ContainsRune("_./-", rune(q[end]))) {
+				end++
+			}
+			tokens = append(tokens, queryToken{kind: "ident", text: q[i:end]})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+// isQueryKey reports whether name is a valid WHERE field / GROUP BY key.
+func isQueryKey(name string) bool {
+	switch name {
+	case "function", "leaf", "root", "file":
+		return true
+	}
+	return (strings.HasPrefix(name, "label.") || strings.HasPrefix(name, "numlabel.")) && !strings.HasSuffix(name, ".")
+}
+
+// ParseProfileQuery parses a query_profile statement (see ProfileQuery).
+func ParseProfileQuery(q string) (*ProfileQuery, error) {
+	tokens, err := tokenizeQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	peekKeyword := func(words ...string) bool {
+		for i, w := range words {
+			if pos+i >= len(tokens) || tokens[pos+i].kind != "ident" || !strings.EqualFold(tokens[pos+i].text, w) {
+				return false
+			}
+		}
+		return true
+	}
+	next := func(what string) (queryToken, error) {
+		if pos >= len(tokens) {
+			return queryToken{}, fmt.Errorf("unexpected end of query, expected %s", what)
+		}
+		pos++
+		return tokens[pos-1], nil
+	}
+	identList := func(what string) ([]string, error) {
+		list := make([]string, 0)
+		for {
+			t, err := next(what)
+			if err != nil {
+				return nil, err
+			}
+			if t.kind != "ident" {
+				return nil, fmt.Errorf("expected %s, got '%s'", what, t.text)
+			}
+			list = append(list, t.text)
+			if pos < len(tokens) && tokens[pos].kind == "," {
+				pos++
+				continue
+			}
+			return list, nil
+		}
+	}
+
+	query := &ProfileQuery{Descending: true}
+	if !peekKeyword("select") {
+		return nil, fmt.Errorf("query must start with SELECT")
+	}
+	pos++
+	if query.Select, err = identList("a sample type or *"); err != nil {
+		return nil, err
+	}
+
+	if peekKeyword("where") {
+		pos++
+		for {
+			field, err := next("a field")
+			if err != nil {
+				return nil, err
+			}
+			if field.kind != "ident" || !isQueryKey(field.text) {
+				return nil, fmt.Errorf("invalid WHERE field '%s' (expected function, leaf, root, file, label.<key> or numlabel.<key>)", field.text)
+			}
+			op, err := next("an operator")
+			if err != nil {
+				return nil, err
+			}
+			if op.kind != "op" {
+				return nil, fmt.Errorf("expected an operator after '%s', got '%s'", field.text, op.text)
+			}
+			value, err := next("a value")
+			if err != nil {
+				return nil, err
+			}
+			if value.kind == "," || value.kind == "op" {
+				return nil, fmt.Errorf("expected a value after '%s %s', got '%s'", field.text, op.text, value.text)
+			}
+			cond := queryCondition{field: field.text, op: op.text, value: value.text}
+			if strings.HasPrefix(cond.field, "numlabel.") {
+				switch cond.op {
+				case "=", "!=", ">", ">=", "<", "<=":
+				default:
+					return nil, fmt.Errorf("operator '%s' is not supported for numeric field '%s'", cond.op, cond.field)
+				}
+				if cond.num, err = strconv.ParseInt(cond.value, 10, 64); err != nil {
+					return nil, fmt.Errorf("numeric field '%s' requires an integer value, got '%s'", cond.field, cond.value)
+				}
+			} else {
+				switch cond.op {
+				case "=", "!=":
+				case "~", "!~":
+					if cond.rx, err = regexp.Compile(cond.value); err != nil {
+						return nil, fmt.Errorf("invalid regular expression '%s': %w", cond.value, err)
+					}
+				default:
+					return nil, fmt.Errorf("operator '%s' is not supported for field '%s' (use =, !=, ~ or !~)", cond.op, cond.field)
+				}
+			}
+			query.Where = append(query.Where, cond)
+			if !peekKeyword("and") {
+				break
+			}
+			pos++
+		}
+	}
+
+	if peekKeyword("group", "by") {
+		pos += 2
+		if query.GroupBy, err = identList("a group key"); err != nil {
+			return nil, err
+		}
+		for _, key := range query.GroupBy {
+			if !isQueryKey(key) {
+				return nil, fmt.Errorf("invalid GROUP BY key '%s' (expected function, leaf, root, file, label.<key> or numlabel.<key>)", key)
+			}
+		}
+	}
+
+	if peekKeyword("order", "by") {
+		pos += 2
+		t, err := next("a sample type")
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != "ident" {
+			return nil, fmt.Errorf("expected a sample type after ORDER BY, got '%s'", t.text)
+		}
+		query.OrderBy = t.text
+		if peekKeyword("asc") {
+			query.Descending = false
+			pos++
+		} else if peekKeyword("desc") {
+			pos++
+		}
+	}
+
+	if peekKeyword("limit") {
+		pos++
+		t, err := next("a number")
+		if err != nil {
+			return nil, err
+		}
+		if query.Limit, err = strconv.Atoi(t.text); err != nil || t.kind != "number" || query.Limit <= 0 {
+			return nil, fmt.Errorf("LIMIT requires a positive integer, got '%s'", t.text)
+		}
+	}
+
+	if pos < len(tokens) {
+		return nil, fmt.Errorf("unexpected '%s' (clauses must follow the order SELECT, WHERE, GROUP BY, ORDER BY, LIMIT)", tokens[pos].text)
+	}
+	return query, nil
+}
+
+// sampleKeyValues returns the values of a field/key for a sample. "function" yields every frame
+// when anyFrame is set (WHERE) and only the leaf otherwise (GROUP BY).
+func sampleKeyValues(s *profile.Sample, key string, anyFrame bool) []string {
+	switch {
+	case key == "function" && anyFrame:
+		return sampleFunctions(s)
+	case key == "function" || key == "leaf" || key == "root" || key == "file":
+		names := sampleFunctions(s)
+		if len(names) == 0 {
+			return nil
+		}
+		if key == "root" {
+			return names[len(names)-1:]
+		}
+		if key == "file" {
+			for _, loc := range s.Location {
+				for _, line := range loc.Line {
+					if line.Function != nil {
+						return []string{line.Function.Filename}
+					}
+				}
+			}
+			return nil
+		}
+		return names[:1]
+	case strings.HasPrefix(key, "label."):
+		return s.Label[strings.TrimPrefix(key, "label.")]
+	case strings.HasPrefix(key, "numlabel."):
+		nums := s.NumLabel[strings.TrimPrefix(key, "numlabel.")]
+		values := make([]string, 0, len(nums))
+		for _, n := range nums {
+			values = append(values, strconv.FormatInt(n, 10))
+		}
+		return values
+	}
+	return nil
+}
+
+// matches evaluates the condition against a sample; a sample without the field only matches != and !~.
+func (c queryCondition) matches(s *profile.Sample) bool {
+	if strings.HasPrefix(c.field, "numlabel.") {
+		nums := s.NumLabel[strings.TrimPrefix(c.field, "numlabel.")]
+		if len(nums) == 0 {
+			return c.op == "!="
+		}
+		for _, n := range nums {
+			switch {
+			case c.op == "=" && n == c.num, c.op == "!=" && n != c.num,
+				c.op == ">" && n > c.num, c.op == ">=" && n >= c.num,
+				c.op == "<" && n < c.num, c.op == "<=" && n <= c.num:
+				return true
+			}
+		}
+		return false
+	}
+
+	values := sampleKeyValues(s, c.field, true)
+	found := false
+	for _, v := range values {
+		if (c.rx != nil && c.rx.MatchString(v)) || (c.rx == nil && v == c.value) {
+			found = true
+			break
+		}
+	}
+	if c.op == "!=" || c.op == "!~" {
+		return !found
+	}
+	return found
+}
+
+// QueryGroup is one row of a query result.
+type QueryGroup struct {
+	Key             []string `json:"key,omitempty"`
+	Values          []int64  `json:"values"`
+	ValuesFormatted []string `json:"valuesFormatted"`
+	Samples         int      `json:"samples"`
+}
+
+// QueryResult is the JSON result of query_profile.
+type QueryResult struct {
+	Query          string       `json:"query"`
+	Columns        []string     `json:"columns"`
+	GroupBy        []string     `json:"groupBy,omitempty"`
+	MatchedSamples int          `json:"matchedSamples"`
+	TotalSamples   int          `json:"totalSamples"`
+	Totals         []int64      `json:"totals"`
+	TotalGroups    int          `json:"totalGroups"`
+	Groups         []QueryGroup `json:"groups"`
+}
+
+// QueryProfile evaluates a query_profile statement directly over the parsed profile.
+func QueryProfile(p *profile.Profile, queryText string, format string) (string, error) {
+	query, err := ParseProfileQuery(queryText)
+	if err != nil {
+		return "", fmt.Errorf("invalid query: %w", err)
+	}
+	log.Printf("Evaluating profile query (Format: %s): %s", format, queryText)
+
+	// Resolve the selected columns to sample value indices
+	columns := make([]string, 0)
+	indices := make([]int, 0)
+	units := make([]string, 0)
+	if len(query.Select) == 1 && query.Select[0] == "*" {
+		for i, st := range p.SampleType {
+			columns, indices, units = append(columns, st.Type), append(indices, i), append(units, st.Unit)
+		}
+	} else {
+		for _, name := range query.Select {
+			idx, err := sampleValueIndex(p, name)
+			if err != nil {
+				return "", err
+			}
+			columns, indices, units = append(columns, name), append(indices, idx), append(units, p.SampleType[idx].Unit)
+		}
+	}
+	orderColumn := 0
+	if query.OrderBy != "" {
+		orderColumn = -1
+		for i, name := range columns {
+			if name == query.OrderBy {
+				orderColumn = i
+			}
+		}
+		if orderColumn == -1 {
+			return "", fmt.Errorf("ORDER BY column '%s' must be one of the selected columns (%s)", query.OrderBy, strings.Join(columns, ", "))
+		}
+	}
+	limit := query.Limit
+	if limit == 0 {
+		limit = QueryDefaultLimit
+	}
+
+	groups := make(map[string]*QueryGroup)
+	totals := make([]int64, len(indices))
+	matched := 0
+	for _, s := range p.Sample {
+		ok := true
+		for _, cond := range query.Where {
+			if !cond.matches(s) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		matched++
+
+		key := make([]string, 0, len(query.GroupBy))
+		for _, k := range query.GroupBy {
+			values := sampleKeyValues(s, k, false)
+			if len(values) == 0 {
+				key = append(key, "<none>")
+			} else {
+				key = append(key, strings.Join(values, ","))
+			}
+		}
+		mapKey := strings.Join(key, "\x00")
+		g, exists := groups[mapKey]
+		if !exists {
+			g = &QueryGroup{Key: key, Values: make([]int64, len(indices))}
+			groups[mapKey] = g
+		}
+		g.Samples++
+		for i, idx := range indices {
+			if idx < len(s.Value) {
+				g.Values[i] += s.Value[idx]
+				totals[i] += s.Value[idx]
+			}
+		}
+	}
+
+	rows := make([]QueryGroup, 0, len(groups))
+	for _, g := range groups {
+		g.ValuesFormatted = make([]string, len(g.Values))
+		for i, v := range g.Values {
+			g.ValuesFormatted[i] = FormatSampleValue(v, units[i])
+		}
+		rows = append(rows, *g)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].Values[orderColumn], rows[j].Values[orderColumn]
+		if a != b {
+			if query.Descending {
+				return a > b
+			}
+			return a < b
+		}
+		return strings.Join(rows[i].Key, "\x00") < strings.Join(rows[j].Key, "\x00")
+	})
+	totalGroups := len(rows)
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Query: %s", queryText)
+		w.line("Matched samples: %s of %s", FormatCount(int64(matched)), FormatCount(int64(len(p.Sample))))
+		for i, name := range columns {
+			w.line("Total %s: %s", name, FormatSampleValue(totals[i], units[i]))
+		}
+		tableColumns := make([]tableColumn, 0, len(columns)+len(query.GroupBy)+1)
+		for _, name := range columns {
+			tableColumns = append(tableColumns, valueColumn(name))
+		}
+		tableColumns = append(tableColumns, valueColumn("Samples"))
+		for i, key := range query.GroupBy {
+			if i == len(query.GroupBy)-1 {
+				tableColumns = append(tableColumns, nameColumn(key))
+			} else {
+				tableColumns = append(tableColumns, textColumn(key, 15))
+			}
+		}
+		t := newTable(tableColumns...)
+		for _, row := range rows {
+			cells := append([]string{}, row.ValuesFormatted...)
+			cells = append(cells, FormatCount(int64(row.Samples)))
+			t.add(append(cells, row.Key...)...)
+		}
+		w.table(t)
+		if totalGroups > len(rows) {
+			w.line("... %d more groups (raise LIMIT to see them)", totalGroups-len(rows))
+		}
+		return w.String(), nil
+
+	case "json":
+		result := QueryResult{
+			Query:          queryText,
+			Columns:        columns,
+			GroupBy:        query.GroupBy,
+			MatchedSamples: matched,
+			TotalSamples:   len(p.Sample),
+			Totals:         totals,
+			TotalGroups:    totalGroups,
+			Groups:         rows,
+		}
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling query result to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
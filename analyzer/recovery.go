@@ -0,0 +1,213 @@
+package analyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// recoveryCommentPrefix marks the comment RecoverProfile adds to a partially recovered profile.
+const recoveryCommentPrefix = "pprof-analyzer-mcp: "
+
+// maxRecoveryBackoff is the number of trailing protobuf fields RecoverProfile drops, one at a time, when the
+// longest complete prefix still does not parse (e.g. corrupted rather than truncated data).
+const maxRecoveryBackoff = 16
+
+// stringTableField is the field number of Profile.string_table in profile.proto.
+const stringTableField = 6
+
+// RecoveryStats describes what RecoverProfile could salvage.
+type RecoveryStats struct {
+	InputBytes     int    // Size of the (possibly compressed) input
+	DecodedBytes   int    // Uncompressed protobuf bytes available
+	ParsedBytes    int    // Bytes of complete protobuf fields the profile was recovered from
+	DecompressErr  string // Error that stopped decompression, if any
+	DroppedSamples int    // Samples dropped because they referenced lost locations or had too few values
+	MissingStrings bool   // The string table was (partly) lost; names are replaced by placeholders
+}
+
+// fieldBoundary is the end offset of a complete top-level protobuf field, with the number of string table
+// entries before it.
+type fieldBoundary struct {
+	offset  int
+	strings int
+}
+
+// RecoverProfile parses as much as possible of a corrupt or truncated profile, e.g. from a process that
+// crashed while writing it. A truncated gzip stream is decompressed up to the corruption point and the
+// protobuf is cut after its last complete top-level field. Go writes the string table after the samples,
+// so names lost with it are replaced by "<missing:N>" placeholders. The recovered profile carries a
+// comment marking it as partial (see RecoveryWarning).
+func RecoverProfile(data []byte) (*profile.Profile, RecoveryStats, error) {
+	stats := RecoveryStats{InputBytes: len(data)}
+	raw := data
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, stats, fmt.Errorf("corrupt gzip header: %w", err)
+		}
+		// ReadAll returns everything decompressed before the error
+		raw, err = io.ReadAll(gz)
+		if err != nil {
+			stats.DecompressErr = err.Error()
+		}
+	}
+	stats.DecodedBytes = len(raw)
+
+	boundaries := scanProtoFields(raw)
+	for back := 0; back < maxRecoveryBackoff && back < len(boundaries); back++ {
+		end := boundaries[len(boundaries)-1-back]
+		p, missingStrings, err := parseWithPlaceholderStrings(raw[:end.offset], end.strings)
+		if err != nil {
+			continue
+		}
+		dropped, err := sanitizeRecoveredProfile(p)
+		if err != nil {
+			continue
+		}
+		stats.ParsedBytes = end.offset
+		stats.DroppedSamples = dropped
+		stats.MissingStrings = missingStrings
+		p.Comments = append(p.Comments, recoveryCommentPrefix+recoveryWarningText(stats))
+		return p, stats, nil
+	}
+	return nil, stats, fmt.Errorf("no recoverable profile data in %d bytes", len(raw))
+}
+
+// RecoveryWarning returns the warning for a profile produced by RecoverProfile, or "" for intact profiles.
+func RecoveryWarning(p *profile.Profile) string {
+	for _, c := range p.Comments {
+		if strings.HasPrefix(c, recoveryCommentPrefix) {
+			return strings.TrimPrefix(c, recoveryCommentPrefix)
+		}
+	}
+	return ""
+}
+
+func recoveryWarningText(stats RecoveryStats) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("WARNING: the profile is corrupt or truncated and was only partially recovered (%d of %d bytes parsed", stats.ParsedBytes, stats.DecodedBytes))
+	if stats.DecompressErr != "" {
+		b.WriteString(", gzip stream: " + stats.DecompressErr)
+	}
+	b.WriteString(fmt.Sprintf(", %d samples dropped)", stats.DroppedSamples))
+	if stats.MissingStrings {
+		b.WriteString("; names lost with the string table are shown as <missing:N>")
+	}
+	b.WriteString(". Results are best-effort and may be incomplete.")
+	return b.String()
+}
+
+// scanProtoFields returns the end offsets of the complete top-level fields of a protobuf message, stopping
+// at the first truncated or malformed one.
+func scanProtoFields(data []byte) []fieldBoundary {
+	boundaries := make([]fieldBoundary, 0)
+	strs := 0
+	i := 0
+scan:
+	for i < len(data) {
+		key, n := binary.Uvarint(data[i:])
+		if n <= 0 || key>>3 == 0 {
+			break
+		}
+		field, wire := key>>3, key&7
+		j := i + n
+		switch wire {
+		case 0: // varint
+			_, m := binary.Uvarint(data[j:])
+			if m <= 0 {
+				break scan
+			}
+			j += m
+		case 1: // fixed64
+			j += 8
+		case 2: // length-delimited
+			l, m := binary.Uvarint(data[j:])
+			if m <= 0 || l > uint64(len(data)-j-m) {
+				break scan
+			}
+			j += m + int(l)
+		case 5: // fixed32
+			j += 4
+		default:
+			break scan
+		}
+		if j > len(data) {
+			break
+		}
+		if field == stringTableField && wire == 2 {
+			strs++
+		}
+		i = j
+		boundaries = append(boundaries, fieldBoundary{offset: i, strings: strs})
+	}
+	return boundaries
+}
+
+// parseWithPlaceholderStrings parses a protobuf prefix; when string references cannot be resolved, it appends
+// a growing number of placeholder strings to the string table until they can. It reports whether
+// placeholders were needed.
+func parseWithPlaceholderStrings(prefix []byte, strs int) (*profile.Profile, bool, error) {
+	p, err := profile.ParseUncompressed(prefix)
+	if err == nil {
+		return p, false, nil
+	}
+	var buf bytes.Buffer
+	for count := 64; count <= 2*len(prefix)+64; count *= 2 {
+		buf.Reset()
+		buf.Write(prefix)
+		for i := 0; i < count; i++ {
+			s := ""
+			if index := strs + i; index > 0 {
+				s = fmt.Sprintf("<missing:%d>", index)
+			}
+			buf.Write(binary.AppendUvarint(nil, stringTableField<<3|2))
+			buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+			buf.WriteString(s)
+		}
+		if p, err = profile.ParseUncompressed(buf.Bytes()); err == nil {
+			return p, true, nil
+		}
+	}
+	return nil, false, err
+}
+
+// sanitizeRecoveredProfile drops the parts of a recovered profile referencing data that was lost (locations,
+// functions) and checks that the rest is valid. It returns the number of dropped samples.
+func sanitizeRecoveredProfile(p *profile.Profile) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, fmt.Errorf("no sample types recovered")
+	}
+	for _, loc := range p.Location {
+		lines := loc.Line[:0]
+		for _, line := range loc.Line {
+			if line.Function != nil {
+				lines = append(lines, line)
+			}
+		}
+		loc.Line = lines
+	}
+	dropped := 0
+	kept := p.Sample[:0]
+sample:
+	for _, s := range p.Sample {
+		if len(s.Value) != len(p.SampleType) {
+			dropped++
+			continue
+		}
+		for _, loc := range s.Location {
+			if loc == nil {
+				dropped++
+				continue sample
+			}
+		}
+		kept = append(kept, s)
+	}
+	p.Sample = kept
+	return dropped, p.CheckValid()
+}
@@ -0,0 +1,176 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// DefaultTopChanges is the default number of regressions/improvements listed in diff reports.
+const DefaultTopChanges = 5
+
+// DiffChange is the change of one entry (function, type, ...) between two profiles.
+type DiffChange struct {
+	Name         string  `json:"name"`
+	OldValue     int64   `json:"oldValue"`
+	NewValue     int64   `json:"newValue"`
+	Delta        int64   `json:"delta"`
+	DeltaPercent float64 `json:"deltaPercent"`
+	Materiality  float64 `json:"materiality"` // |Delta| × |DeltaPercent| / 100
+}
+
+// NewDiffChange computes the delta, percentage and materiality score of an entry.
+// Entries that only exist in the new (old) profile count as +100% (-100%).
+func NewDiffChange(name string, oldValue, newValue int64) DiffChange {
+	delta := newValue - oldValue
+	deltaPct := 0.0
+	if oldValue != 0 {
+		deltaPct = float64(delta) / math.Abs(float64(oldValue)) * 100
+	} else if delta > 0 {
+		deltaPct = 100.0
+	} else if delta < 0 {
+		deltaPct = -100.0
+	}
+	return DiffChange{
+		Name:         name,
+		OldValue:     oldValue,
+		NewValue:     newValue,
+		Delta:        delta,
+		DeltaPercent: deltaPct,
+		Materiality:  math.Abs(float64(delta)) * math.Abs(deltaPct) / 100,
+	}
+}
+
+// RankByMateriality splits changes into regressions (growth) and improvements (shrinkage), each ranked by
+// materiality score so that changes that are both large and relatively significant come first, rather than
+// large-but-proportionally-tiny or proportionally-huge-but-negligible ones. At most k entries are kept per list.
+func RankByMateriality(changes []DiffChange, k int) (regressions, improvements []DiffChange) {
+	if k <= 0 {
+		k = DefaultTopChanges
+	}
+	regressions = make([]DiffChange, 0)
+	improvements = make([]DiffChange, 0)
+	for _, c := range changes {
+		if c.Delta > 0 {
+			regressions = append(regressions, c)
+		} else if c.Delta < 0 {
+			improvements = append(improvements, c)
+		}
+	}
+	for _, list := range [][]DiffChange{regressions, improvements} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Materiality != list[j].Materiality {
+				return list[i].Materiality > list[j].Materiality
+			}
+			return list[i].Name < list[j].Name
+		})
+	}
+	if len(regressions) > k {
+		regressions = regressions[:k]
+	}
+	if len(improvements) > k {
+		improvements = improvements[:k]
+	}
+	return regressions, improvements
+}
+
+// writeTopChanges writes the "top regressions" and "top improvements" sections of a diff report.
+func writeTopChanges(w *reportWriter, regressions, improvements []DiffChange, formatValue func(int64) string) {
+	for _, section := range []struct {
+		title   string
+		changes []DiffChange
+	}{{"Top Regressions", regressions}, {"Top Improvements", improvements}} {
+		w.heading("%s (ranked by materiality = |delta| × |delta %%|)", section.title)
+		if len(section.changes) == 0 {
+			w.line("(none)")
+			continue
+		}
+		t := newTable(valueColumn("Old"), valueColumn("New"), deltaColumn("Delta"), deltaColumn("Delta%"), valueColumn("Score"), nameColumn("Name"))
+		for _, c := range section.changes {
+			t.add(formatValue(c.OldValue), formatValue(c.NewValue), formatSignedValue(c.Delta, formatValue),
+				fmt.Sprintf("%+.2f%%", c.DeltaPercent), fmt.Sprintf("%.0f", c.Materiality), c.Name)
+		}
+		w.table(t)
+	}
+}
+
+// formatSignedValue formats a delta with an explicit sign.
+func formatSignedValue(delta int64, formatValue func(int64) string) string {
+	if delta < 0 {
+		return "-" + formatValue(-delta)
+	}
+	return "+" + formatValue(delta)
+}
+
+// ParseIgnoreList compiles a comma-separated list of function regular expressions, e.g.
+// "runtime\.futex, runtime\.epollwait". Empty elements are skipped.
+func ParseIgnoreList(spec string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0)
+	for _, expr := range strings.Split(spec, ",") {
+		if expr = strings.TrimSpace(expr); expr == "" {
+			continue
+		}
+		rx, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore regular expression '%s': %w", expr, err)
+		}
+		patterns = append(patterns, rx)
+	}
+	return patterns, nil
+}
+
+// DropIgnoredSamples returns a copy of p without the samples that have a frame matching one of the ignore
+// patterns (the same semantics as pprof's -ignore), and the number of samples removed. p is not modified;
+// it is returned as is when nothing matches.
+func DropIgnoredSamples(p *profile.Profile, ignore []*regexp.Regexp) (*profile.Profile, int) {
+	if len(ignore) == 0 {
+		return p, 0
+	}
+	dropped := 0
+	for _, s := range p.Sample {
+		if sampleMatchesAny(s, ignore) {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		return p, 0
+	}
+	// Copy duplicates the samples' locations, so match the copy's samples again rather than by pointer
+	filtered := p.Copy()
+	kept := filtered.Sample[:0]
+	for _, s := range filtered.Sample {
+		if !sampleMatchesAny(s, ignore) {
+			kept = append(kept, s)
+		}
+	}
+	filtered.Sample = kept
+	return filtered, dropped
+}
+
+// sampleMatchesAny reports whether any frame of the sample matches one of the patterns.
+func sampleMatchesAny(s *profile.Sample, patterns []*regexp.Regexp) bool {
+	for _, name := range sampleFunctions(s) {
+		for _, rx := range patterns {
+			if rx.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeIgnoreNote writes which noise patterns were excluded from a diff report, if any.
+func writeIgnoreNote(w *reportWriter, ignore []*regexp.Regexp, droppedOld, droppedNew int) {
+	if len(ignore) == 0 {
+		return
+	}
+	exprs := make([]string, 0, len(ignore))
+	for _, rx := range ignore {
+		exprs = append(exprs, rx.String())
+	}
+	w.line("Ignored functions: %s (excluded %s old / %s new samples)", strings.Join(exprs, ", "), FormatCount(int64(droppedOld)), FormatCount(int64(droppedNew)))
+}
@@ -0,0 +1,149 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// SampleTypeSummary is the total of one sample type of a profile.
+type SampleTypeSummary struct {
+	Type           string `json:"type"`
+	Unit           string `json:"unit"`
+	Total          int64  `json:"total"`
+	TotalFormatted string `json:"totalFormatted"`
+	Default        bool   `json:"default,omitempty"` // The profile's default sample type
+}
+
+// SummarizeSampleTypes lists every sample type of a profile with its total, so reports can show which other
+// metrics could be analyzed (e.g. alloc_objects next to inuse_space). It works for any pprof producer.
+func SummarizeSampleTypes(p *profile.Profile) []SampleTypeSummary {
+	totals := make([]int64, len(p.SampleType))
+	for _, s := range p.Sample {
+		for i := range totals {
+			if i < len(s.Value) {
+				totals[i] += s.Value[i]
+			}
+		}
+	}
+	defaultIndex := DefaultSampleIndex(p)
+	summaries := make([]SampleTypeSummary, len(p.SampleType))
+	for i, st := range p.SampleType {
+		summaries[i] = SampleTypeSummary{
+			Type:           st.Type,
+			Unit:           st.Unit,
+			Total:          totals[i],
+			TotalFormatted: FormatSampleValue(totals[i], st.Unit),
+			Default:        i == defaultIndex,
+		}
+	}
+	return summaries
+}
+
+// ReportsSampleTypes reports whether Analyze includes the sample type summary and the recovery warning (see
+// RecoveryWarning) in results of the given format. The layout of the other formats (flamegraph-json, fixed by
+// d3-flame-graph, and the call graphs) has no place for them; callers return FormatSampleTypes separately.
+func ReportsSampleTypes(format string) bool {
+	switch format {
+	case "text", "markdown", "markdown-compact", "json":
+		return true
+	}
+	return false
+}
+
+// FormatSampleTypes renders the sample type summary of a profile as a "text" or "markdown" table, or as the
+// one-line "markdown-compact" list. It returns "" for a profile without sample types.
+func FormatSampleTypes(p *profile.Profile, format string) string {
+	summaries := SummarizeSampleTypes(p)
+	if len(summaries) == 0 {
+		return ""
+	}
+	if format == "markdown-compact" {
+		parts := make([]string, len(summaries))
+		for i, st := range summaries {
+			parts[i] = fmt.Sprintf("%s %s", st.Type, st.TotalFormatted)
+		}
+		return "Sample types: " + strings.Join(parts, ", ") + "\n"
+	}
+	w := newReportWriter(format)
+	if w.markdown {
+		w.line("**Sample Types** (analyze another with `sample_type`)")
+	} else {
+		w.line("Sample Types (analyze another with 'sample_type'):")
+	}
+	t := newTable(textColumn("Type", 20), textColumn("Unit", 14), valueColumn("Total"))
+	for _, st := range summaries {
+		t.add(st.Type, st.Unit, st.TotalFormatted+defaultMarker(st))
+	}
+	w.table(t)
+	return w.String()
+}
+
+// appendSampleTypes adds the sample type summary to a text, markdown or markdown-compact analysis result.
+func appendSampleTypes(result string, p *profile.Profile, format string) string {
+	table := FormatSampleTypes(p, format)
+	if table == "" {
+		return result
+	}
+	if format == "markdown-compact" {
+		return strings.TrimRight(result, "\n") + "\n" + table
+	}
+	return strings.TrimRight(result, "\n") + "\n\n" + table
+}
+
+// jsonResultMeta is the metadata Analyze adds to JSON results, next to the analyzer's own fields.
+type jsonResultMeta struct {
+	Warning     string              `json:"warning,omitempty"`
+	SampleTypes []SampleTypeSummary `json:"sampleTypes,omitempty"`
+	// The samples dropped below the minimum sample value, when one is set
+	MinSampleValue *MinValueSummary `json:"minSampleValue,omitempty"`
+}
+
+// withJSONMeta adds the fields of meta to a JSON object result. Results that are not a JSON object are
+// returned unchanged.
+func withJSONMeta(result string, meta jsonResultMeta) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(result), &fields); err != nil || fields == nil {
+		return result, nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result metadata: %w", err)
+	}
+	var metaFields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &metaFields); err != nil {
+		return "", fmt.Errorf("failed to marshal result metadata: %w", err)
+	}
+	for name, value := range metaFields {
+		fields[name] = value
+	}
+	merged, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return string(merged), nil
+}
+
+func defaultMarker(st SampleTypeSummary) string {
+	if st.Default {
+		return " (default)"
+	}
+	return ""
+}
+
+// matchingObjectsIndex returns the object count sample type belonging to a space sample type (e.g.
+// alloc_objects for alloc_space), or fallback when the profile has none.
+func matchingObjectsIndex(p *profile.Profile, valueIndex, fallback int) int {
+	prefix, ok := strings.CutSuffix(p.SampleType[valueIndex].Type, "_space")
+	if !ok {
+		return fallback
+	}
+	for i, st := range p.SampleType {
+		if st.Type == prefix+"_objects" {
+			return i
+		}
+	}
+	return fallback
+}
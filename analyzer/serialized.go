@@ -0,0 +1,112 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer/aggregate"
+)
+
+// AggregatedFunction is a row of a ProfileAggregation.
+type AggregatedFunction struct {
+	Name          string  `json:"name"`
+	Flat          int64   `json:"flat"`
+	FlatFormatted string  `json:"flatFormatted"`
+	Percent       float64 `json:"percent"`
+	Objects       int64   `json:"objects,omitempty"` // Memory profiles with an object count only
+}
+
+// ProfileAggregation is the flat value of the functions (or allocation sites) of a profile, sorted by value.
+type ProfileAggregation struct {
+	SampleType     string               `json:"sampleType"`
+	Unit           string               `json:"unit"`
+	Total          int64                `json:"total"`
+	TotalFormatted string               `json:"totalFormatted"`
+	TotalObjects   int64                `json:"totalObjects,omitempty"`
+	Entries        []AggregatedFunction `json:"entries"`
+	Omitted        int                  `json:"omitted"` // Entries beyond topN
+}
+
+// parseSerializedProfile parses a profile as read from a file (gzipped or not) and selects its sample type.
+func parseSerializedProfile(data []byte, sampleType string) (*profile.Profile, int, error) {
+	p, err := profile.ParseData(data)
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to parse profile: %w", err)
+	}
+	valueIndex, err := sampleValueIndex(p, sampleType)
+	if err != nil {
+		return nil, -1, err
+	}
+	return p, valueIndex, nil
+}
+
+// AggregateProfileJSON aggregates the flat values of a serialized profile by function ("function") or by
+// allocation site ("site") and returns the topN heaviest as a JSON ProfileAggregation (all for topN <= 0).
+// It works on the bytes of a profile file rather than on a parsed profile so it can be called from
+// JavaScript in the WebAssembly build (see cmd/pprof-analyzer-wasm).
+func AggregateProfileJSON(data []byte, sampleType, by string, topN int) (string, error) {
+	p, valueIndex, err := parseSerializedProfile(data, sampleType)
+	if err != nil {
+		return "", err
+	}
+	st := p.SampleType[valueIndex]
+	objectsIndex := -1
+	if aggregate.IsMemoryValueType(st) {
+		objectsIndex = matchingObjectsIndex(p, valueIndex, -1)
+	}
+	var agg *aggregate.Aggregation
+	switch by {
+	case "", "function":
+		agg = aggregate.AggregateByFunction(p, valueIndex, objectsIndex)
+	case "site":
+		agg = aggregate.AggregateBySite(p, valueIndex, objectsIndex)
+	default:
+		return "", fmt.Errorf("unsupported aggregation: '%s' (supported: function, site)", by)
+	}
+
+	result := ProfileAggregation{
+		SampleType:     st.Type,
+		Unit:           st.Unit,
+		Total:          agg.Total,
+		TotalFormatted: FormatSampleValue(agg.Total, st.Unit),
+		TotalObjects:   agg.TotalObjects,
+		Entries:        make([]AggregatedFunction, 0, len(agg.Stats)),
+	}
+	stats := agg.Stats
+	if topN > 0 && len(stats) > topN {
+		result.Omitted = len(stats) - topN
+		stats = stats[:topN]
+	}
+	for _, s := range stats {
+		entry := AggregatedFunction{Name: s.Name, Flat: s.Flat, FlatFormatted: FormatSampleValue(s.Flat, st.Unit), Objects: s.Objects}
+		if agg.Total != 0 {
+			entry.Percent = float64(s.Flat) / float64(agg.Total) * 100
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal aggregation to JSON: %w", err)
+	}
+	return string(jsonBytes), nil
+}
+
+// FlameGraphTreeJSON builds the flame graph tree of a serialized profile (see BuildFlameGraphTree) and
+// returns it as JSON, like AggregateProfileJSON.
+func FlameGraphTreeJSON(data []byte, sampleType string) (string, error) {
+	p, valueIndex, err := parseSerializedProfile(data, sampleType)
+	if err != nil {
+		return "", err
+	}
+	tree, err := BuildFlameGraphTree(p, valueIndex)
+	if err != nil {
+		return "", err
+	}
+	jsonBytes, err := json.Marshal(tree)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal flame graph to JSON: %w", err)
+	}
+	return string(jsonBytes), nil
+}
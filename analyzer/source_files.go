@@ -0,0 +1,54 @@
+//go:build !(js && wasm)
+
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveSourceFile finds the source file of a profile path. With a root, the path and its suffixes
+// ("/build/src/example.com/app/pkg/f.go", ..., "pkg/f.go", "f.go") are looked up below it, longest first;
+// lookups never leave the root. Only files with a source extension are considered.
+func resolveSourceFile(file, root string) (string, bool) {
+	if file == "" || !sourceExtensions[strings.ToLower(filepath.Ext(file))] {
+		return "", false
+	}
+	isFile := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().IsRegular()
+	}
+	if root == "" {
+		return file, isFile(file)
+	}
+	root = filepath.Clean(root)
+	parts := strings.Split(filepath.ToSlash(file), "/")
+	for i := range parts {
+		candidate := filepath.Join(root, filepath.FromSlash(strings.Join(parts[i:], "/")))
+		if rel, err := filepath.Rel(root, candidate); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if isFile(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// readSourceLines reads a source file as lines, with tabs expanded so the listing stays aligned.
+func readSourceLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, strings.ReplaceAll(scanner.Text(), "\t", "    "))
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,16 @@
+//go:build js && wasm
+
+package analyzer
+
+import "errors"
+
+// resolveSourceFile finds no source file in the browser, which has no file system: AnnotateSource lists
+// only the sampled lines.
+func resolveSourceFile(file, root string) (string, bool) {
+	return "", false
+}
+
+// readSourceLines is never called, as resolveSourceFile finds no file.
+func readSourceLines(path string) ([]string, error) {
+	return nil, errors.New("source files cannot be read in WebAssembly builds")
+}
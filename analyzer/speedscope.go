@@ -0,0 +1,140 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// SpeedscopeFrame is a single frame in the speedscope shared frame table.
+type SpeedscopeFrame struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int64  `json:"line,omitempty"`
+}
+
+// SpeedscopeProfile is a "sampled" profile in the speedscope file format.
+// Each sample is a list of frame indices ordered from root to leaf.
+type SpeedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// SpeedscopeFile is the top-level speedscope document.
+// See https://www.speedscope.app/file-format-schema.json
+type SpeedscopeFile struct {
+	Schema string `json:"$schema"`
+	Shared struct {
+		Frames []SpeedscopeFrame `json:"frames"`
+	} `json:"shared"`
+	Profiles           []SpeedscopeProfile `json:"profiles"`
+	Name               string              `json:"name,omitempty"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter,omitempty"`
+}
+
+// DefaultSampleIndex returns the index of the profile's default sample type.
+// It honors DefaultSampleType when set and otherwise falls back to the last sample type,
+// matching the behaviour of 'go tool pprof'.
+func DefaultSampleIndex(p *profile.Profile) int {
+	if len(p.SampleType) == 0 {
+		return -1
+	}
+	if p.DefaultSampleType != "" {
+		for i, st := range p.SampleType {
+			if st.Type == p.DefaultSampleType {
+				return i
+			}
+		}
+	}
+	return len(p.SampleType) - 1
+}
+
+// speedscopeUnit maps a pprof unit to a unit understood by speedscope.
+func speedscopeUnit(unit string) string {
+	switch unit {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return unit
+	default:
+		return "none"
+	}
+}
+
+// BuildSpeedscopeProfile converts a pprof profile into the speedscope "sampled" file format
+// using the sample value at valueIndex as the weight of each stack.
+func BuildSpeedscopeProfile(p *profile.Profile, valueIndex int, name string) (*SpeedscopeFile, error) {
+	if valueIndex < 0 || valueIndex >= len(p.SampleType) {
+		return nil, fmt.Errorf("invalid value index %d for profile with %d sample types", valueIndex, len(p.SampleType))
+	}
+
+	st := p.SampleType[valueIndex]
+	result := &SpeedscopeFile{
+		Schema:   "https://www.speedscope.app/file-format-schema.json",
+		Name:     name,
+		Exporter: "pprof-analyzer-mcp",
+	}
+	result.Shared.Frames = []SpeedscopeFrame{}
+
+	sampled := SpeedscopeProfile{
+		Type:    "sampled",
+		Name:    fmt.Sprintf("%s (%s)", name, st.Type),
+		Unit:    speedscopeUnit(st.Unit),
+		Samples: [][]int{},
+		Weights: []int64{},
+	}
+
+	// Frames are deduplicated by function name and file
+	frameIndex := make(map[SpeedscopeFrame]int)
+	frameFor := func(name, file string, line int64) int {
+		key := SpeedscopeFrame{Name: name, File: file}
+		if idx, ok := frameIndex[key]; ok {
+			return idx
+		}
+		idx := len(result.Shared.Frames)
+		result.Shared.Frames = append(result.Shared.Frames, SpeedscopeFrame{Name: name, File: file, Line: line})
+		frameIndex[key] = idx
+		return idx
+	}
+
+	total := int64(0)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex || s.Value[valueIndex] == 0 {
+			continue
+		}
+		v := s.Value[valueIndex]
+
+		// pprof stores locations leaf-first and inlined lines callee-first; speedscope wants root-first
+		stack := make([]int, 0, len(s.Location))
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			loc := s.Location[i]
+			if len(loc.Line) == 0 {
+				stack = append(stack, frameFor(fmt.Sprintf("unknown @ 0x%x", loc.Address), "", 0))
+				continue
+			}
+			for j := len(loc.Line) - 1; j >= 0; j-- {
+				line := loc.Line[j]
+				if line.Function == nil {
+					stack = append(stack, frameFor(fmt.Sprintf("unknown @ 0x%x", loc.Address), "", 0))
+					continue
+				}
+				stack = append(stack, frameFor(line.Function.Name, line.Function.Filename, line.Line))
+			}
+		}
+		if len(stack) == 0 {
+			continue
+		}
+
+		sampled.Samples = append(sampled.Samples, stack)
+		sampled.Weights = append(sampled.Weights, v)
+		total += v
+	}
+	sampled.EndValue = total
+
+	result.Profiles = []SpeedscopeProfile{sampled}
+	return result, nil
+}
@@ -0,0 +1,185 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// StackSetOperations are the set operations supported by CompareStackSets.
+var StackSetOperations = []string{"intersection", "only_in_profile", "only_in_base", "union"}
+
+// StackSetEntry is one function or stack with its value in both profiles.
+type StackSetEntry struct {
+	Key                string `json:"key"`      // Function name, or the stack "root;...;leaf"
+	Presence           string `json:"presence"` // "both", "profile" or "base"
+	Value              int64  `json:"value"`
+	ValueFormatted     string `json:"valueFormatted"`
+	BaseValue          int64  `json:"baseValue"`
+	BaseValueFormatted string `json:"baseValueFormatted"`
+}
+
+// StackSetResult is the result of a set operation over the functions or stacks of two profiles.
+type StackSetResult struct {
+	Operation   string          `json:"operation"`
+	Level       string          `json:"level"` // "function" or "stack"
+	SampleType  string          `json:"sampleType"`
+	Unit        string          `json:"unit"`
+	InBoth      int             `json:"inBoth"`
+	OnlyProfile int             `json:"onlyInProfile"`
+	OnlyBase    int             `json:"onlyInBase"`
+	Matched     int             `json:"matched"` // Entries matching the operation, before the top-N limit
+	Entries     []StackSetEntry `json:"entries"`
+}
+
+// stackSetValues sums the value at valueIndex per function (cumulative: once per sample for every function
+// on the stack) or per distinct stack (flat).
+func stackSetValues(p *profile.Profile, valueIndex int, level string) map[string]int64 {
+	values := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Value) <= valueIndex {
+			continue
+		}
+		names := sampleFunctions(s)
+		if len(names) == 0 {
+			continue
+		}
+		v := s.Value[valueIndex]
+		if level == "stack" {
+			frames := make([]string, len(names))
+			for i, name := range names {
+				frames[len(names)-1-i] = name
+			}
+			values[strings.Join(frames, ";")] += v
+			continue
+		}
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				values[name] += v
+			}
+		}
+	}
+	return values
+}
+
+// CompareStackSets compares the functions (level "function") or complete stacks (level "stack") of a profile
+// and a base profile as sets: "intersection" reports the ones present in both, "only_in_profile" the ones
+// that appear only in the profile (e.g. code paths introduced by a change), "only_in_base" the ones that
+// disappeared, and "union" all of them with their presence. Entries are sorted by their larger value.
+// sampleType selects the sample type by name in both profiles (default: the profile's default sample type).
+func CompareStackSets(p, base *profile.Profile, operation, level, sampleType string, topN int) (*StackSetResult, error) {
+	wanted := map[string]map[string]bool{
+		"intersection":    {"both": true},
+		"only_in_profile": {"profile": true},
+		"only_in_base":    {"base": true},
+		"union":           {"both": true, "profile": true, "base": true},
+	}[operation]
+	if wanted == nil {
+		return nil, fmt.Errorf("unsupported operation: '%s' (supported: %s)", operation, strings.Join(StackSetOperations, ", "))
+	}
+	if level != "function" && level != "stack" {
+		return nil, fmt.Errorf("unsupported level: '%s' (supported: function, stack)", level)
+	}
+	valueIndex, err := sampleValueIndex(p, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	st := p.SampleType[valueIndex]
+	baseIndex, err := sampleValueIndex(base, st.Type)
+	if err != nil {
+		return nil, fmt.Errorf("base profile: %w", err)
+	}
+	log.Printf("Comparing %s sets (%s, SampleType: %s, Top %d)", level, operation, st.Type, topN)
+
+	values := stackSetValues(p, valueIndex, level)
+	baseValues := stackSetValues(base, baseIndex, level)
+	result := &StackSetResult{Operation: operation, Level: level, SampleType: st.Type, Unit: st.Unit, Entries: make([]StackSetEntry, 0)}
+
+	add := func(key, presence string) {
+		if !wanted[presence] {
+			return
+		}
+		result.Entries = append(result.Entries, StackSetEntry{
+			Key:                key,
+			Presence:           presence,
+			Value:              values[key],
+			ValueFormatted:     FormatSampleValue(values[key], st.Unit),
+			BaseValue:          baseValues[key],
+			BaseValueFormatted: FormatSampleValue(baseValues[key], st.Unit),
+		})
+	}
+	for key := range values {
+		if _, ok := baseValues[key]; ok {
+			result.InBoth++
+			add(key, "both")
+		} else {
+			result.OnlyProfile++
+			add(key, "profile")
+		}
+	}
+	for key := range baseValues {
+		if _, ok := values[key]; !ok {
+			result.OnlyBase++
+			add(key, "base")
+		}
+	}
+
+	larger := func(e StackSetEntry) int64 {
+		if e.BaseValue > e.Value {
+			return e.BaseValue
+		}
+		return e.Value
+	}
+	sort.Slice(result.Entries, func(i, j int) bool {
+		if vi, vj := larger(result.Entries[i]), larger(result.Entries[j]); vi != vj {
+			return vi > vj
+		}
+		return result.Entries[i].Key < result.Entries[j].Key
+	})
+	result.Matched = len(result.Entries)
+	if topN > 0 && len(result.Entries) > topN {
+		result.Entries = result.Entries[:topN]
+	}
+	return result, nil
+}
+
+// FormatStackSetResult formats a set comparison as "text", "markdown" or "json".
+func FormatStackSetResult(r *StackSetResult, format string) (string, error) {
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Stack Set Comparison: %s (by %s, %s)", r.Operation, r.Level, r.SampleType)
+		w.line("In both: %s, only in profile: %s, only in base: %s", FormatCount(int64(r.InBoth)), FormatCount(int64(r.OnlyProfile)), FormatCount(int64(r.OnlyBase)))
+		w.line("Showing %d of %d matching %ss", len(r.Entries), r.Matched, r.Level)
+		t := newTable(valueColumn("Profile"), valueColumn("Base"), textColumn("In", 8), nameColumn(strings.ToUpper(r.Level[:1])+r.Level[1:]))
+		for _, e := range r.Entries {
+			value, baseValue := e.ValueFormatted, e.BaseValueFormatted
+			if e.Presence == "base" {
+				value = "-"
+			}
+			if e.Presence == "profile" {
+				baseValue = "-"
+			}
+			t.add(value, baseValue, e.Presence, e.Key)
+		}
+		w.table(t)
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling stack set comparison to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
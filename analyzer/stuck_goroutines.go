@@ -0,0 +1,364 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// stuckFindingStacks is the number of distinct stacks reported per stuck goroutine finding.
+const stuckFindingStacks = 3
+
+// stuckInternalPrefixes are skipped when looking for the call site where goroutines block.
+var stuckInternalPrefixes = []string{"runtime.", "sync.", "internal/"}
+
+// stuckKind describes a way goroutines can be parked forever, with its likely cause and the fix to look for.
+type stuckKind struct {
+	LikelyCause string
+	Suggestion  string
+}
+
+// stuckKinds is the registry of blocking operations recognized by DetectStuckGoroutines, keyed by kind.
+var stuckKinds = map[string]stuckKind{
+	"nil channel": {
+		LikelyCause: "Blocked forever on a nil channel: the channel was never initialized (or was set to nil) before being used.",
+		Suggestion:  "Initialize the channel with make before starting the goroutines, or only set it to nil to disable a select case on purpose.",
+	},
+	"chan receive": {
+		LikelyCause: "No sender and the channel is never closed: the producer exited, failed or was never started. Can also be an idle worker pool.",
+		Suggestion:  "Close the channel when the producer is done (defer close(ch)), and select on ctx.Done() so receivers can exit.",
+	},
+	"chan send": {
+		LikelyCause: "No receiver: the consumer exited or stopped reading, e.g. a result channel abandoned after a timeout, or a full buffer.",
+		Suggestion:  "Give result channels a buffer of 1 so senders never block, or select on ctx.Done() next to the send.",
+	},
+	"select": {
+		LikelyCause: "None of the select cases becomes ready: the channels are never signaled and there is no cancellation case.",
+		Suggestion:  "Add a case <-ctx.Done() (or a done channel), and check that the channels are closed when their producers exit.",
+	},
+	"select (no cases)": {
+		LikelyCause: "select {} blocks forever by design: fine to keep main alive, a leak anywhere else.",
+		Suggestion:  "Replace select {} with a wait on a signal, a context or a WaitGroup that ends when the work is done.",
+	},
+	"mutex": {
+		LikelyCause: "The lock is never released: an Unlock missing on some path (e.g. an early return without defer), a lock-order inversion (deadlock), or a holder stuck on something else.",
+		Suggestion:  "Use defer mu.Unlock() right after Lock, always take locks in the same order, and look for the goroutine holding the lock in the other findings.",
+	},
+	"waitgroup": {
+		LikelyCause: "The WaitGroup counter never reaches zero: Done is missing on some path, a worker is itself stuck, or Add was called too many times.",
+		Suggestion:  "Call defer wg.Done() first thing in each worker, and call Add before starting the goroutine with the exact number of workers.",
+	},
+	"cond": {
+		LikelyCause: "sync.Cond.Wait without a matching Signal or Broadcast: the condition changes without notifying the waiters.",
+		Suggestion:  "Call Broadcast whenever the condition may have changed (including on shutdown), or replace the Cond with a channel.",
+	},
+	"semaphore": {
+		LikelyCause: "A runtime semaphore is never released, e.g. a lock or a WaitGroup whose caller could not be identified.",
+		Suggestion:  "Look at the stacks for the lock or WaitGroup involved and check that every acquisition is released.",
+	},
+}
+
+// StuckGoroutineCriteria are the thresholds of DetectStuckGoroutines.
+type StuckGoroutineCriteria struct {
+	MinGoroutines  int64 // Goroutines parked at the same call site to report it
+	MinWaitMinutes int64 // Goroutine dumps: a call site waiting at least this long is reported whatever its count; 0 disables
+}
+
+// StuckGoroutineFinding is a call site where goroutines are parked on the same kind of operation.
+type StuckGoroutineFinding struct {
+	Kind           string               `json:"kind"` // "chan receive", "chan send", "nil channel", "select", "select (no cases)", "mutex", "waitgroup", "cond" or "semaphore"
+	Site           string               `json:"site"` // First frame outside the runtime and sync packages
+	Goroutines     int64                `json:"goroutines"`
+	MinWaitMinutes int64                `json:"minWaitMinutes,omitempty"` // Goroutine dumps only
+	MaxWaitMinutes int64                `json:"maxWaitMinutes,omitempty"`
+	Severity       string               `json:"severity"` // "high" or "medium"
+	LikelyCause    string               `json:"likelyCause"`
+	Suggestion     string               `json:"suggestion"`
+	Stacks         []GoroutineWaitStack `json:"stacks"` // The most common stacks of the site
+}
+
+// StuckGoroutineResult is the JSON result of DetectStuckGoroutines.
+type StuckGoroutineResult struct {
+	TotalGoroutines   int64                   `json:"totalGoroutines"`
+	BlockedGoroutines int64                   `json:"blockedGoroutines"` // Parked on a channel, lock, select, WaitGroup or Cond
+	HasWaitDurations  bool                    `json:"hasWaitDurations"`  // The profile is a goroutine dump (debug=2)
+	MinGoroutines     int64                   `json:"minGoroutines"`
+	MinWaitMinutes    int64                   `json:"minWaitMinutes,omitempty"`
+	Suspected         bool                    `json:"suspected"`
+	Verdict           string                  `json:"verdict"`
+	Findings          []StuckGoroutineFinding `json:"findings"`
+}
+
+// classifyStuckGoroutines returns the kind of blocking operation goroutines in state (see goroutineSampleState)
+// with the given stack (function names, leaf first) are parked on, or "" when they are not blocked on one
+// (running, IO wait, sleep, syscall, GC workers, ...).
+func classifyStuckGoroutines(state string, names []string) string {
+	hasFrame := func(frames ...string) bool {
+		for _, name := range names {
+			for _, frame := range frames {
+				if name == frame {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case strings.HasSuffix(state, "(nil chan)"):
+		return "nil channel"
+	case state == "chan receive", state == "chan send", state == "select", state == "select (no cases)":
+		return state
+	case state == "sync.Cond wait", state == "sync.Cond.Wait":
+		return "cond"
+	case state == "sync.WaitGroup.Wait", hasFrame("sync.(*WaitGroup).Wait"):
+		return "waitgroup"
+	case state == "sync.Mutex lock", state == "sync.Mutex.Lock", state == "sync.RWMutex.Lock", state == "sync.RWMutex.RLock",
+		hasFrame("sync.(*Mutex).Lock", "sync.(*Mutex).lockSlow", "sync.(*RWMutex).Lock", "sync.(*RWMutex).RLock"):
+		return "mutex"
+	case state == "semacquire":
+		return "semaphore"
+	}
+	return ""
+}
+
+// stuckCallSite returns the first frame of a sample outside the runtime and sync packages, where its goroutines
+// block, e.g. "main.(*Cache).Get at /app/cache.go:42".
+func stuckCallSite(s *profile.Sample) string {
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function != nil && !matchesAnyPrefix(line.Function.Name, stuckInternalPrefixes) {
+				return fmt.Sprintf("%s at %s:%d", line.Function.Name, line.Function.Filename, line.Line)
+			}
+		}
+	}
+	return "(runtime)"
+}
+
+// DetectStuckGoroutines looks for goroutines parked on the same channel, lock, select, WaitGroup or Cond in a
+// goroutine profile or a goroutine dump (see ParseGoroutineDump), which hints at deadlocks and leaks. Goroutines
+// are grouped by kind of operation and by the call site where they block, as profiles do not say which channel
+// or mutex they wait on. A site is reported when it has at least MinGoroutines goroutines or, in a goroutine
+// dump, when its goroutines waited at least MinWaitMinutes; it is "high" severity when both hold, or for a nil
+// channel. Findings come with their most common stacks and a likely-cause classification.
+func DetectStuckGoroutines(p *profile.Profile, criteria StuckGoroutineCriteria, limit, maxStackDepth int, format string) (string, error) {
+	if len(p.SampleType) == 0 {
+		return "", fmt.Errorf("goroutine profile has no sample types")
+	}
+	if criteria.MinGoroutines <= 0 {
+		criteria.MinGoroutines = 10
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	dump := isGoroutineDump(p)
+	if !dump {
+		criteria.MinWaitMinutes = 0
+	}
+	log.Printf("Detecting stuck goroutines (Min goroutines %d, Min wait %d minutes, Limit %d, Format: %s)",
+		criteria.MinGoroutines, criteria.MinWaitMinutes, limit, format)
+
+	result := StuckGoroutineResult{
+		HasWaitDurations: dump,
+		MinGoroutines:    criteria.MinGoroutines,
+		MinWaitMinutes:   criteria.MinWaitMinutes,
+		Findings:         make([]StuckGoroutineFinding, 0),
+	}
+	type siteGroup struct {
+		finding StuckGoroutineFinding
+		stacks  map[string]*GoroutineWaitStack
+	}
+	groups := make(map[string]*siteGroup)
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 || s.Value[0] <= 0 {
+			continue
+		}
+		count := s.Value[0]
+		result.TotalGoroutines += count
+		state := goroutineSampleState(s, dump)
+		kind := classifyStuckGoroutines(state, sampleFunctions(s))
+		if kind == "" {
+			continue
+		}
+		result.BlockedGoroutines += count
+
+		site, wait := stuckCallSite(s), goroutineSampleWait(s)
+		g, ok := groups[kind+"\x00"+site]
+		if !ok {
+			g = &siteGroup{finding: StuckGoroutineFinding{Kind: kind, Site: site, MinWaitMinutes: wait}, stacks: make(map[string]*GoroutineWaitStack)}
+			groups[kind+"\x00"+site] = g
+		}
+		g.finding.Goroutines += count
+		g.finding.MinWaitMinutes = min(g.finding.MinWaitMinutes, wait)
+		g.finding.MaxWaitMinutes = max(g.finding.MaxWaitMinutes, wait)
+
+		stack := formatGoroutineStack(s)
+		createdBy := ""
+		if c := s.Label[GoroutineCreatedByLabel]; len(c) > 0 {
+			createdBy = c[0]
+		}
+		key := state + "\x00" + createdBy + "\x00" + strings.Join(stack, "\n")
+		st, ok := g.stacks[key]
+		if !ok {
+			st = &GoroutineWaitStack{State: state, MinWaitMinutes: wait, CreatedBy: createdBy, StackTrace: stack, Frames: len(stack)}
+			g.stacks[key] = st
+		}
+		st.Count += count
+		st.MinWaitMinutes = min(st.MinWaitMinutes, wait)
+		st.MaxWaitMinutes = max(st.MaxWaitMinutes, wait)
+	}
+
+	findings := make([]StuckGoroutineFinding, 0)
+	for _, g := range groups {
+		f := g.finding
+		many := f.Goroutines >= criteria.MinGoroutines
+		long := criteria.MinWaitMinutes > 0 && f.MaxWaitMinutes >= criteria.MinWaitMinutes
+		if !many && !long && f.Kind != "nil channel" {
+			continue
+		}
+		f.Severity = "medium"
+		if (many && long) || f.Kind == "nil channel" {
+			f.Severity = "high"
+		}
+		f.LikelyCause, f.Suggestion = stuckKinds[f.Kind].LikelyCause, stuckKinds[f.Kind].Suggestion
+
+		stacks := make([]GoroutineWaitStack, 0, len(g.stacks))
+		for _, st := range g.stacks {
+			stacks = append(stacks, *st)
+		}
+		sort.Slice(stacks, func(i, j int) bool {
+			if stacks[i].Count != stacks[j].Count {
+				return stacks[i].Count > stacks[j].Count
+			}
+			if stacks[i].MaxWaitMinutes != stacks[j].MaxWaitMinutes {
+				return stacks[i].MaxWaitMinutes > stacks[j].MaxWaitMinutes
+			}
+			return strings.Join(stacks[i].StackTrace, "\n") < strings.Join(stacks[j].StackTrace, "\n")
+		})
+		if len(stacks) > stuckFindingStacks {
+			stacks = stacks[:stuckFindingStacks]
+		}
+		for i := range stacks {
+			stacks[i].StackTrace = truncatedStack(stacks[i].StackTrace, maxStackDepth)
+		}
+		f.Stacks = stacks
+		findings = append(findings, f)
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity == "high"
+		}
+		if a.Goroutines != b.Goroutines {
+			return a.Goroutines > b.Goroutines
+		}
+		if a.MaxWaitMinutes != b.MaxWaitMinutes {
+			return a.MaxWaitMinutes > b.MaxWaitMinutes
+		}
+		return a.Kind+a.Site < b.Kind+b.Site
+	})
+	if len(findings) > limit {
+		findings = findings[:limit]
+	}
+	result.Findings = findings
+
+	if len(findings) > 0 {
+		stuck := int64(0)
+		for _, f := range findings {
+			stuck += f.Goroutines
+		}
+		result.Suspected = true
+		result.Verdict = fmt.Sprintf("Stuck goroutines suspected: %s goroutines parked at %d call sites; see the likely causes below.",
+			FormatCount(stuck), len(findings))
+	} else {
+		result.Verdict = fmt.Sprintf("No stuck goroutines found: no call site has %s or more goroutines parked on a channel, lock, select, WaitGroup or Cond",
+			FormatCount(criteria.MinGoroutines))
+		if criteria.MinWaitMinutes > 0 {
+			result.Verdict += " or waiting for " + FormatWaitMinutes(criteria.MinWaitMinutes) + " or more"
+		}
+		result.Verdict += "."
+	}
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Stuck Goroutine Report")
+		w.line("Goroutines: %s total, %s parked on a channel, lock, select, WaitGroup or Cond",
+			FormatCount(result.TotalGoroutines), FormatCount(result.BlockedGoroutines))
+		if dump {
+			criterion := fmt.Sprintf("Thresholds: %s goroutines per call site", FormatCount(criteria.MinGoroutines))
+			if criteria.MinWaitMinutes > 0 {
+				criterion += ", or a wait of " + FormatWaitMinutes(criteria.MinWaitMinutes)
+			}
+			w.line("%s", criterion)
+		} else {
+			w.line("Thresholds: %s goroutines per call site (wait durations need a goroutine dump, goroutine?debug=2)", FormatCount(criteria.MinGoroutines))
+		}
+		w.line("Verdict: %s", result.Verdict)
+		if len(findings) == 0 {
+			return w.String(), nil
+		}
+
+		columns := []tableColumn{textColumn("Severity", 8), valueColumn("Goroutines"), textColumn("Kind", 17), nameColumn("Call Site")}
+		if dump {
+			columns = []tableColumn{textColumn("Severity", 8), valueColumn("Goroutines"), textColumn("Longest Wait", 20), textColumn("Kind", 17), nameColumn("Call Site")}
+		}
+		t := newTable(columns...)
+		for _, f := range findings {
+			if dump {
+				longest := "-"
+				if f.MaxWaitMinutes > 0 {
+					longest = FormatWaitMinutes(f.MaxWaitMinutes)
+				}
+				t.add(f.Severity, FormatCount(f.Goroutines), longest, f.Kind, f.Site)
+			} else {
+				t.add(f.Severity, FormatCount(f.Goroutines), f.Kind, f.Site)
+			}
+		}
+		w.heading("Findings")
+		w.table(t)
+
+		for i, f := range findings {
+			w.heading("%d. %s goroutines (%s) at %s", i+1, FormatCount(f.Goroutines), f.Kind, f.Site)
+			w.line("Likely cause: %s", f.LikelyCause)
+			w.line("Suggestion: %s", f.Suggestion)
+			for _, st := range f.Stacks {
+				detail := st.State
+				if st.MaxWaitMinutes > 0 {
+					detail += ", waiting up to " + FormatWaitMinutes(st.MaxWaitMinutes)
+				}
+				if st.CreatedBy != "" {
+					detail += ", created by " + st.CreatedBy
+				}
+				w.blankLine()
+				w.line("%s goroutines in %s (%d frames):", FormatCount(st.Count), detail, st.Frames)
+				if w.markdown {
+					w.WriteString("```text\n")
+				}
+				for _, frame := range st.StackTrace {
+					w.WriteString("  " + frame + "\n")
+				}
+				if w.markdown {
+					w.WriteString("```\n")
+				}
+			}
+		}
+		return w.String(), nil
+
+	case "json":
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling stuck goroutine result to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
@@ -0,0 +1,55 @@
+package analyzer
+
+import (
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// SubtractStats describes the result of SubtractProfile.
+type SubtractStats struct {
+	Samples        int // Samples in the result
+	ClampedValues  int // Negative values (b larger than a for a stack) set to zero
+	DroppedSamples int // Stacks that only shrank, removed from the result (stacks cancelling out exactly are not counted)
+}
+
+// SubtractProfile returns a − b, like 'go tool pprof -base b a': b is scaled by -1 and merged into a, so
+// matching stacks cancel out. Negative values, i.e. stacks that shrank, are clamped to zero and stacks
+// without any remaining value are dropped, so the result is a valid profile for every other analysis.
+// Both profiles must have the same sample types; neither is modified.
+func SubtractProfile(a, b *profile.Profile) (*profile.Profile, SubtractStats, error) {
+	var stats SubtractStats
+	base := b.Copy()
+	base.Scale(-1)
+	diff, err := profile.Merge([]*profile.Profile{a, base})
+	if err != nil {
+		return nil, stats, fmt.Errorf("cannot subtract profiles: %w", err)
+	}
+
+	kept := diff.Sample[:0]
+	for _, s := range diff.Sample {
+		nonZero := false
+		for i, v := range s.Value {
+			if v < 0 {
+				s.Value[i] = 0
+				stats.ClampedValues++
+			} else if v > 0 {
+				nonZero = true
+			}
+		}
+		if !nonZero {
+			stats.DroppedSamples++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	diff.Sample = kept
+	stats.Samples = len(kept)
+
+	// Compact removes locations and functions only referenced by dropped samples. The merge sums
+	// durations; the result covers the period of a
+	result := diff.Compact()
+	result.TimeNanos = a.TimeNanos
+	result.DurationNanos = a.DurationNanos
+	return result, stats, nil
+}
@@ -0,0 +1,143 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// SuggestedCall is a follow-up tool call suggested after an analysis, ready to be issued by an agent.
+type SuggestedCall struct {
+	Tool      string                 `json:"tool"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Reason    string                 `json:"reason"`
+	Missing   []string               `json:"missing,omitempty"` // Required arguments the caller must provide (e.g. a later snapshot)
+}
+
+// SuggestionContext describes the analysis suggestions are made for.
+type SuggestionContext struct {
+	Tool           string // The tool that produced the analysis ("analyze_pprof" or "detect_memory_leaks")
+	ProfileURI     string // For detect_memory_leaks: the newer profile
+	BaseProfileURI string // For detect_memory_leaks: the older profile
+	ProfileType    string
+	OutputFormat   string
+	SampleType     string
+	AnalysisID     string
+}
+
+// hottestFunction returns the function with the largest flat value at valueIndex and the root-first stack of
+// its heaviest sample, or "" for profiles without symbolized samples.
+func hottestFunction(p *profile.Profile, valueIndex int) (string, []string) {
+	flat := make(map[string]int64)
+	heaviest := make(map[string]*profile.Sample)
+	for _, s := range p.Sample {
+		names := sampleFunctions(s)
+		if len(names) == 0 || len(s.Value) <= valueIndex {
+			continue
+		}
+		leaf := names[0]
+		flat[leaf] += s.Value[valueIndex]
+		if h := heaviest[leaf]; h == nil || s.Value[valueIndex] > h.Value[valueIndex] {
+			heaviest[leaf] = s
+		}
+	}
+	hot := ""
+	for name, v := range flat {
+		if v > 0 && (hot == "" || v > flat[hot] || (v == flat[hot] && name < hot)) {
+			hot = name
+		}
+	}
+	if hot == "" {
+		return "", nil
+	}
+	names := sampleFunctions(heaviest[hot])
+	stack := make([]string, len(names))
+	for i, name := range names {
+		stack[len(names)-1-i] = name
+	}
+	return hot, stack
+}
+
+// SuggestNextCalls suggests follow-up tool calls with their arguments after an analysis, e.g. a memory leak
+// comparison or a flame graph drill-down into the hottest function after a heap analysis, so agentic clients
+// can chain calls.
+func SuggestNextCalls(p *profile.Profile, sc SuggestionContext) []SuggestedCall {
+	suggestions := make([]SuggestedCall, 0)
+	add := func(tool, reason string, args map[string]interface{}, missing ...string) {
+		if sc.AnalysisID != "" {
+			args["analysis_id"] = sc.AnalysisID
+		}
+		suggestions = append(suggestions, SuggestedCall{Tool: tool, Arguments: args, Reason: reason, Missing: missing})
+	}
+
+	if sc.Tool == "detect_memory_leaks" {
+		add("subtract_profile", "Write the growth between the snapshots as a profile, to analyze it with any other tool",
+			map[string]interface{}{"profile_uri": sc.ProfileURI, "base_profile_uri": sc.BaseProfileURI})
+		add("compare_stack_sets", "List the allocation stacks that only appear in the newer snapshot",
+			map[string]interface{}{"profile_uri": sc.ProfileURI, "base_profile_uri": sc.BaseProfileURI, "operation": "only_in_profile", "level": "stack"})
+		add("analyze_pprof", "Summarize which packages own the memory in the newer snapshot",
+			map[string]interface{}{"profile_uri": sc.ProfileURI, "profile_type": "heap", "output_format": "text", "group_by": "package"})
+		return suggestions
+	}
+
+	valueIndex, err := sampleValueIndex(p, sc.SampleType)
+	if err != nil {
+		return suggestions
+	}
+	sampleType := p.SampleType[valueIndex].Type
+	hot, stack := hottestFunction(p, valueIndex)
+
+	if hot != "" {
+		if sc.OutputFormat == "flamegraph-json" && sc.AnalysisID != "" && isAnalyzableProfileType(sc.ProfileType) {
+			add("get_flamegraph_subtree", fmt.Sprintf("Drill into the flame graph along the heaviest stack of the hottest function %s", hot),
+				map[string]interface{}{"analysis_id": sc.AnalysisID, "path": "root;" + strings.Join(stack, ";"), "profile_type": sc.ProfileType})
+		}
+		add("query_profile", fmt.Sprintf("Find the entry points whose stacks reach the hottest function %s", hot),
+			map[string]interface{}{
+				"profile_uri": sc.ProfileURI,
+				"query":       fmt.Sprintf("SELECT %s WHERE function = %q GROUP BY root LIMIT 10", sampleType, hot),
+			})
+	}
+	add("generate_flamegraph", "Render a flame graph of the whole profile",
+		map[string]interface{}{"profile_uri": sc.ProfileURI, "profile_type": sc.ProfileType, "output_svg_path": sc.ProfileType + "-flamegraph.svg"})
+
+	switch sc.ProfileType {
+	case "cpu":
+		add("attribute_costs", "Attribute CPU time to HTTP/gRPC handlers",
+			map[string]interface{}{"profile_uri": sc.ProfileURI, "attribute_by": "handler"})
+	case "heap":
+		add("detect_memory_leaks", "Capture a later heap profile of the same process and compare it with this one to find growing allocations",
+			map[string]interface{}{"old_profile_uri": sc.ProfileURI, "output_format": "text"}, "new_profile_uri")
+		add("analyze_pprof", "Summarize which packages own the memory",
+			map[string]interface{}{"profile_uri": sc.ProfileURI, "profile_type": "heap", "output_format": "text", "group_by": "package"})
+	case "allocs":
+		add("analyze_pool_effectiveness", "Check whether sync.Pool usage actually reduces allocations",
+			map[string]interface{}{"profile_uri": sc.ProfileURI})
+		add("analyze_pprof", "Summarize which packages allocate the most",
+			map[string]interface{}{"profile_uri": sc.ProfileURI, "profile_type": "allocs", "output_format": "text", "group_by": "package"})
+	case "goroutine":
+		add("detect_stuck_goroutines", "Look for goroutines parked on the same channel or lock (deadlocks, stuck producers or consumers)",
+			map[string]interface{}{"profile_uri": sc.ProfileURI})
+		for _, pattern := range LeakPatternNames() {
+			add("detect_leak_patterns", fmt.Sprintf("Check the goroutines for the '%s' leak pattern", pattern),
+				map[string]interface{}{"pattern": pattern, "goroutine_profile_uri": sc.ProfileURI})
+		}
+	case "mutex", "block":
+		add("analyze_db_pool_contention", "Check whether the contention comes from waiting for database/sql connections",
+			map[string]interface{}{sc.ProfileType + "_profile_uri": sc.ProfileURI})
+	}
+	return suggestions
+}
+
+// FormatSuggestedCalls returns suggestions as a JSON object {"suggestedNextCalls": [...]}.
+func FormatSuggestedCalls(suggestions []SuggestedCall) (string, error) {
+	data, err := json.MarshalIndent(struct {
+		SuggestedNextCalls []SuggestedCall `json:"suggestedNextCalls"`
+	}{suggestions}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal suggested calls: %w", err)
+	}
+	return string(data), nil
+}
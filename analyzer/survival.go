@@ -0,0 +1,103 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// RetentionSurvivalThreshold is the survival ratio (inuse_objects / alloc_objects) at or above which
+// an allocation site is considered long-lived and reported as a retention candidate.
+const RetentionSurvivalThreshold = 0.9
+
+// SurvivalStat describes how many of the objects allocated at a site are still live.
+type SurvivalStat struct {
+	Site                string  `json:"site"`
+	AllocObjects        int64   `json:"allocObjects"`
+	InuseObjects        int64   `json:"inuseObjects"`
+	InuseBytes          int64   `json:"inuseBytes"`
+	InuseBytesFormatted string  `json:"inuseBytesFormatted"`
+	SurvivalRatio       float64 `json:"survivalRatio"`
+}
+
+// EstimateObjectSurvival estimates per-allocation-site object survival from a heap profile.
+// Go heap and allocs profiles carry the same four sample types (alloc_objects, alloc_space,
+// inuse_objects, inuse_space), so a single heap profile already holds the matching allocation data.
+// A ratio close to 1.0 means nearly every object allocated at the site is still retained.
+// Results are sorted by in-use bytes in descending order.
+func EstimateObjectSurvival(p *profile.Profile) ([]SurvivalStat, error) {
+	allocObjectsIndex, inuseObjectsIndex, inuseSpaceIndex := -1, -1, -1
+	for i, st := range p.SampleType {
+		switch {
+		case st.Type == "alloc_objects" && st.Unit == "count":
+			allocObjectsIndex = i
+		case st.Type == "inuse_objects" && st.Unit == "count":
+			inuseObjectsIndex = i
+		case st.Type == "inuse_space" && st.Unit == "bytes":
+			inuseSpaceIndex = i
+		}
+	}
+	if allocObjectsIndex == -1 || inuseObjectsIndex == -1 {
+		return nil, fmt.Errorf("profile must contain both alloc_objects and inuse_objects sample types")
+	}
+
+	allocObjects := make(map[string]int64)
+	inuseObjects := make(map[string]int64)
+	inuseBytes := make(map[string]int64)
+
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Value) <= allocObjectsIndex || len(s.Value) <= inuseObjectsIndex {
+			continue
+		}
+		// Attribute to the topmost function in the allocation stack, like the heap report does
+		for _, line := range s.Location[0].Line {
+			if line.Function != nil {
+				site := fmt.Sprintf("%s at %s:%d", line.Function.Name, line.Function.Filename, line.Line)
+				allocObjects[site] += s.Value[allocObjectsIndex]
+				inuseObjects[site] += s.Value[inuseObjectsIndex]
+				if inuseSpaceIndex >= 0 && len(s.Value) > inuseSpaceIndex {
+					inuseBytes[site] += s.Value[inuseSpaceIndex]
+				}
+				break
+			}
+		}
+	}
+
+	stats := make([]SurvivalStat, 0, len(allocObjects))
+	for site, allocated := range allocObjects {
+		if allocated <= 0 {
+			continue
+		}
+		inuse := inuseObjects[site]
+		stats = append(stats, SurvivalStat{
+			Site:                site,
+			AllocObjects:        allocated,
+			InuseObjects:        inuse,
+			InuseBytes:          inuseBytes[site],
+			InuseBytesFormatted: FormatBytes(inuseBytes[site]),
+			SurvivalRatio:       float64(inuse) / float64(allocated),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].InuseBytes != stats[j].InuseBytes {
+			return stats[i].InuseBytes > stats[j].InuseBytes
+		}
+		return stats[i].Site < stats[j].Site
+	})
+	return stats, nil
+}
+
+// retentionCandidates filters survival stats down to long-lived sites that still hold memory.
+func retentionCandidates(stats []SurvivalStat, limit int) []SurvivalStat {
+	candidates := make([]SurvivalStat, 0)
+	for _, stat := range stats {
+		if stat.SurvivalRatio >= RetentionSurvivalThreshold && stat.InuseObjects > 0 {
+			candidates = append(candidates, stat)
+			if len(candidates) >= limit {
+				break
+			}
+		}
+	}
+	return candidates
+}
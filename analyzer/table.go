@@ -0,0 +1,242 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI 转义序列，仅在 ValueFormat.Color 为 true 时用于 "text" 报告
+const (
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiReset = "\x1b[0m"
+)
+
+// reportRule 是 "text" 报告中表头上下的分隔线。
+const reportRule = "--------------------------------------------------"
+
+// columnKind 决定表格列的对齐方式和渲染方式。
+type columnKind int
+
+const (
+	columnText  columnKind = iota // 左对齐的文本
+	columnValue                   // 数值：按 DefaultValueFormat 对齐 (见 valueCell)，markdown 中右对齐
+	columnDelta                   // 带符号的数值，启用颜色时增长显示为红色、减少为绿色
+	columnName                    // 函数名、调用点等标识符，markdown 中以代码格式显示
+)
+
+// tableColumn 描述表格的一列。
+type tableColumn struct {
+	title string
+	kind  columnKind
+	width int // "text" 中的最小宽度；最后一列不填充
+}
+
+// valueColumn 返回宽度为 ValueColumnWidth 的数值列。
+func valueColumn(title string) tableColumn {
+	return tableColumn{title: title, kind: columnValue, width: ValueColumnWidth}
+}
+
+// deltaColumn 返回宽度为 ValueColumnWidth 的带符号数值列。
+func deltaColumn(title string) tableColumn {
+	return tableColumn{title: title, kind: columnDelta, width: ValueColumnWidth}
+}
+
+// textColumn 返回左对齐、最小宽度为 width 的文本列。
+func textColumn(title string, width int) tableColumn {
+	return tableColumn{title: title, kind: columnText, width: width}
+}
+
+// nameColumn 返回标识符列，通常是最后一列。
+func nameColumn(title string) tableColumn {
+	return tableColumn{title: title, kind: columnName}
+}
+
+// table 是一个报告表格：先收集所有行，再由 reportWriter 按输出格式渲染。
+type table struct {
+	columns []tableColumn
+	rows    [][]string
+	notes   [][]string // 每行之后的附加说明 (例如差异中的原路径)
+}
+
+// newTable 创建具有给定列的空表格。
+func newTable(columns ...tableColumn) *table {
+	return &table{columns: columns}
+}
+
+// add 追加一行；单元格数量必须与列数相同。
+func (t *table) add(cells ...string) {
+	if len(cells) != len(t.columns) {
+		panic(fmt.Sprintf("table row has %d cells, want %d", len(cells), len(t.columns)))
+	}
+	t.rows = append(t.rows, cells)
+	t.notes = append(t.notes, nil)
+}
+
+// note 为最后一行追加一条说明："text" 中单独成行 (调用方决定缩进)，markdown 中以换行附在最后一个单元格后。
+func (t *table) note(s string) {
+	last := len(t.rows) - 1
+	t.notes[last] = append(t.notes[last], s)
+}
+
+// reportWriter 按输出格式写出报告："text" 使用固定宽度的列 (ValueFormat.Color 为 true 时带 ANSI 颜色)，
+// "markdown" 使用标题和 GitHub 表格，使聊天客户端将其渲染为真正的表格而不是预格式化文本。
+type reportWriter struct {
+	strings.Builder
+	markdown bool
+	color    bool
+}
+
+// newReportWriter 返回 format ("text"、"markdown" 或 "markdown-compact") 的 reportWriter。
+func newReportWriter(format string) *reportWriter {
+	markdown := format == "markdown" || format == "markdown-compact"
+	return &reportWriter{markdown: markdown, color: !markdown && DefaultValueFormat().Color}
+}
+
+// title 写出报告的标题行。
+func (w *reportWriter) title(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	switch {
+	case w.markdown:
+		w.WriteString("### " + s + "\n\n")
+	case w.color:
+		w.WriteString(ansiBold + s + ansiReset + "\n")
+	default:
+		w.WriteString(s + "\n")
+	}
+}
+
+// heading 写出一节的标题，前面留一个空行。
+func (w *reportWriter) heading(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	w.blankLine()
+	switch {
+	case w.markdown:
+		w.WriteString("#### " + s + "\n\n")
+	case w.color:
+		w.WriteString(ansiBold + "=== " + s + " ===" + ansiReset + "\n")
+	default:
+		w.WriteString("=== " + s + " ===\n")
+	}
+}
+
+// line 写出一行文本；markdown 中以硬换行结尾，使相邻的行不会合并为一段。
+func (w *reportWriter) line(format string, args ...interface{}) {
+	s := fmt.Sprintf(format, args...)
+	if w.markdown && s != "" {
+		w.WriteString(s + "  \n")
+		return
+	}
+	w.WriteString(s + "\n")
+}
+
+// blankLine 确保接下来的内容前有一个空行 (报告开头除外)。
+func (w *reportWriter) blankLine() {
+	s := w.String()
+	if s != "" && !strings.HasSuffix(s, "\n\n") {
+		if !strings.HasSuffix(s, "\n") {
+			w.WriteString("\n")
+		}
+		w.WriteString("\n")
+	}
+}
+
+// table 渲染一个表格。markdown 表格前后各留一个空行，否则后面的文本会被当作表格的行。
+func (w *reportWriter) table(t *table) {
+	if w.markdown {
+		w.markdownTable(t)
+		return
+	}
+	w.WriteString(reportRule + "\n")
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.title
+	}
+	w.textRow(t.columns, header, true)
+	w.WriteString(reportRule + "\n")
+	for i, row := range t.rows {
+		w.textRow(t.columns, row, false)
+		for _, note := range t.notes[i] {
+			w.WriteString(note + "\n")
+		}
+	}
+}
+
+// textRow 写出 "text" 表格的一行。
+func (w *reportWriter) textRow(columns []tableColumn, cells []string, header bool) {
+	var b strings.Builder
+	for i, c := range columns {
+		cell := cells[i]
+		if i < len(columns)-1 {
+			cell = padCell(cell, c)
+		}
+		if w.color && !header && c.kind == columnDelta {
+			switch {
+			case strings.HasPrefix(strings.TrimSpace(cells[i]), "+"):
+				cell = ansiRed + cell + ansiReset
+			case strings.HasPrefix(strings.TrimSpace(cells[i]), "-"):
+				cell = ansiGreen + cell + ansiReset
+			}
+		}
+		b.WriteString(cell)
+	}
+	line := strings.TrimRight(b.String(), " ")
+	if w.color && header {
+		line = ansiBold + line + ansiReset
+	}
+	w.WriteString(line + "\n")
+}
+
+// padCell 将单元格填充到列宽，并保留一个空格与下一列分隔；数值列的对齐方式与 valueCell 相同。
+func padCell(s string, c tableColumn) string {
+	pad := c.width - utf8.RuneCountInString(s)
+	if pad < 0 {
+		pad = 0
+	}
+	if (c.kind == columnValue || c.kind == columnDelta) && DefaultValueFormat().AlignRight {
+		return strings.Repeat(" ", pad) + s + " "
+	}
+	return s + strings.Repeat(" ", pad) + " "
+}
+
+// markdownTable 将表格写成 GitHub markdown 表格。
+func (w *reportWriter) markdownTable(t *table) {
+	w.blankLine()
+	header := make([]string, len(t.columns))
+	align := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = markdownCell(c.title, false)
+		align[i] = "---"
+		if c.kind == columnValue || c.kind == columnDelta {
+			align[i] = "---:"
+		}
+	}
+	w.WriteString("| " + strings.Join(header, " | ") + " |\n")
+	w.WriteString("|" + strings.Join(align, "|") + "|\n")
+	for i, row := range t.rows {
+		cells := make([]string, len(row))
+		for j, c := range t.columns {
+			cells[j] = markdownCell(row[j], c.kind == columnName)
+		}
+		for _, note := range t.notes[i] {
+			last := len(cells) - 1
+			cells[last] += "<br>" + markdownCell(strings.TrimSpace(note), false)
+		}
+		w.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	w.WriteString("\n")
+}
+
+// markdownCell 转义 markdown 表格单元格中的竖线和换行；code 为 true 时以代码格式显示 (内容含反引号时除外)。
+func markdownCell(s string, code bool) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "\n", " ")
+	if code && s != "" && !strings.Contains(s, "`") {
+		return "`" + strings.ReplaceAll(s, "|", "\\|") + "`"
+	}
+	return markdownEscaper.Replace(s)
+}
+
+// markdownEscaper 转义在表格单元格中会改变渲染结果的字符。
+var markdownEscaper = strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`", "<", "&lt;")
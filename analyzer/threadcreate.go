@@ -0,0 +1,206 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// threadCreateCauses 按优先级排列的 OS 线程创建原因：堆栈中任一帧匹配的第一条规则决定原因。
+// 调度器帧 (如 runtime.handoffp) 也出现在系统调用等更具体原因的堆栈中，因此排在它们之后；
+// runtime.newm、runtime.startm 等线程创建机制本身出现在所有堆栈中，不参与归类。
+var threadCreateCauses = []struct {
+	cause     string
+	functions []string
+}{
+	{"LockOSThread", []string{"runtime.LockOSThread", "runtime.startTemplateThread", "runtime.newTemplateThread"}},
+	{"cgo call", []string{"runtime.cgocall", "runtime.cgocallbackg", "runtime.asmcgocall"}},
+	{"blocking syscall", []string{"runtime.entersyscallblock", "runtime.entersyscallblock_handoff", "runtime.retake",
+		"syscall.Syscall", "syscall.Syscall6", "syscall.RawSyscall", "syscall.syscall", "syscall.syscall6",
+		"internal/runtime/syscall.Syscall6", "runtime/internal/syscall.Syscall6"}},
+	{"GC", []string{"runtime.gcBgMarkStartWorkers", "runtime.gcStart", "runtime.gcenable"}},
+	{"scheduler", []string{"runtime.wakep", "runtime.ready", "runtime.newproc", "runtime.injectglist", "runtime.resetspinning"}},
+	{"runtime startup", []string{"runtime.main", "runtime.schedinit", "runtime.rt0_go"}},
+}
+
+// threadExplosionThreshold 是提示可能存在线程爆炸的 OS 线程数量。
+const threadExplosionThreshold = 200
+
+// goMaxThreads 是 Go 运行时默认的 OS 线程数量上限 (debug.SetMaxThreads)。
+const goMaxThreads = 10000
+
+// classifyThreadCreateStack 返回创建线程的堆栈的创建原因；空堆栈为 "no stack"，无法归类的为 "other"。
+func classifyThreadCreateStack(names []string) string {
+	if len(names) == 0 {
+		return "no stack"
+	}
+	for _, rule := range threadCreateCauses {
+		for _, name := range names {
+			for _, fn := range rule.functions {
+				if name == fn {
+					return rule.cause
+				}
+			}
+		}
+	}
+	return "other"
+}
+
+// SummarizeThreadCreateCauses 按创建原因统计 threadcreate profile 中的 OS 线程数量，按数量降序排列。
+func SummarizeThreadCreateCauses(p *profile.Profile) (int64, []ThreadCreateCauseCount) {
+	counts := make(map[string]int64)
+	total := int64(0)
+	for _, s := range p.Sample {
+		if len(s.Value) == 0 {
+			continue
+		}
+		total += s.Value[0]
+		counts[classifyThreadCreateStack(sampleFunctions(s))] += s.Value[0]
+	}
+	causes := make([]ThreadCreateCauseCount, 0, len(counts))
+	for cause, count := range counts {
+		causes = append(causes, ThreadCreateCauseCount{Cause: cause, Count: count})
+	}
+	sort.Slice(causes, func(i, j int) bool {
+		if causes[i].Count != causes[j].Count {
+			return causes[i].Count > causes[j].Count
+		}
+		return causes[i].Cause < causes[j].Cause
+	})
+	return total, causes
+}
+
+// FormatThreadCreateCauseSummary 生成一行摘要，例如 "120 total: 110 blocking syscall, 10 scheduler"。
+func FormatThreadCreateCauseSummary(total int64, causes []ThreadCreateCauseCount) string {
+	parts := make([]string, len(causes))
+	for i, c := range causes {
+		parts[i] = FormatCount(c.Count) + " " + c.Cause
+	}
+	if len(parts) == 0 {
+		return FormatCount(total) + " total"
+	}
+	return FormatCount(total) + " total: " + strings.Join(parts, ", ")
+}
+
+// threadCreateFindings 根据线程总数和主要创建原因给出排查建议。
+func threadCreateFindings(total int64, causes []ThreadCreateCauseCount) []string {
+	findings := make([]string, 0)
+	if total >= threadExplosionThreshold {
+		findings = append(findings, fmt.Sprintf("%s OS threads were created; the Go runtime aborts the process at %s (debug.SetMaxThreads). Threads are rarely released, so a burst leaves them behind.", FormatCount(total), FormatCount(goMaxThreads)))
+	}
+	for _, c := range causes {
+		if total == 0 || c.Count*2 < total {
+			continue // 只对占多数的原因给出建议
+		}
+		switch c.Cause {
+		case "blocking syscall":
+			findings = append(findings, "Most threads were created to replace threads blocked in system calls (e.g. file I/O, DNS lookups via cgo or getaddrinfo, disk-bound syscalls). Bound the concurrency of these calls, e.g. with a semaphore.")
+		case "cgo call":
+			findings = append(findings, "Most threads were created for cgo calls, each of which holds an OS thread while it runs. Bound the number of concurrent cgo calls.")
+		case "LockOSThread":
+			findings = append(findings, "Most threads were created for goroutines calling runtime.LockOSThread; a goroutine that exits while locked also terminates its thread. Check for lock/unlock imbalances.")
+		case "no stack":
+			findings = append(findings, "Most threads have no creation stack, a known limitation of the Go runtime's threadcreate profile. Compare with a goroutine profile (goroutines in 'syscall' state) to find the cause.")
+		}
+	}
+	return findings
+}
+
+// AnalyzeThreadCreateProfile 分析 threadcreate profile (OS 线程的创建堆栈) 并返回格式化结果。
+func AnalyzeThreadCreateProfile(p *profile.Profile, topN int, format string) (string, error) {
+	return analyzeThreadCreateProfile(p, NewOptions(WithTopN(topN), WithFormat(format)))
+}
+
+// analyzeThreadCreateProfile is the implementation of AnalyzeThreadCreateProfile, driven by Options (see Analyze).
+func analyzeThreadCreateProfile(p *profile.Profile, o Options) (string, error) {
+	topN, format := o.TopN, o.Format
+	log.Printf("Analyzing threadcreate profile (Top %d, Format: %s)", topN, format)
+
+	// threadcreate profile 只有一个样本类型："threadcreate" / "count"
+	valueIndex := 0
+	if len(p.SampleType) == 0 {
+		return "", fmt.Errorf("threadcreate profile 没有样本类型")
+	}
+	if p.SampleType[0].Type != "threadcreate" {
+		log.Printf("Warning: Expected 'threadcreate' sample type, found: %v. Using index 0.", p.SampleType)
+	}
+	valueType := p.SampleType[valueIndex].Type
+	valueUnit := p.SampleType[valueIndex].Unit
+
+	stats, _ := aggregateStacks(p, valueIndex) // 没有堆栈的样本不在其中，但计入原因摘要的 "no stack"
+	total, causes := SummarizeThreadCreateCauses(p)
+	summary := FormatThreadCreateCauseSummary(total, causes)
+	findings := threadCreateFindings(total, causes)
+	limit := topN
+	if limit > len(stats) {
+		limit = len(stats)
+	}
+	causeOf := func(stat *stackInfo) string {
+		names := make([]string, len(stat.Stack))
+		for i, line := range stat.Stack {
+			names[i] = strings.SplitN(line, "\n", 2)[0]
+		}
+		return classifyThreadCreateStack(names)
+	}
+
+	var b strings.Builder
+	switch format {
+	case "text", "markdown":
+		if format == "markdown" {
+			b.WriteString("```text\n")
+		}
+		b.WriteString(fmt.Sprintf("Thread Creation Profile Analysis (Top %d Stacks by Count)\n", topN))
+		b.WriteString(fmt.Sprintf("Total OS Threads Created (%s/%s): %s\n", valueType, valueUnit, FormatCount(total)))
+		b.WriteString(fmt.Sprintf("Causes: %s\n", summary))
+		for _, finding := range findings {
+			b.WriteString("- " + finding + "\n")
+		}
+		b.WriteString("--------------------------------------------------\n")
+		for i := 0; i < limit; i++ {
+			stat := stats[i]
+			b.WriteString(fmt.Sprintf("\n%s threads created by stack (%s, %d frames):\n", FormatCount(stat.Count), causeOf(stat), len(stat.Stack)))
+			for _, line := range truncatedStack(stat.Stack, o.MaxStackDepth) {
+				b.WriteString(fmt.Sprintf("  %s\n", line))
+			}
+			b.WriteString("--------------------------------------------------\n")
+		}
+		if format == "markdown" {
+			b.WriteString("```\n")
+		}
+	case "json":
+		result := ThreadCreateAnalysisResult{
+			ProfileType:  "threadcreate",
+			TotalThreads: total,
+			CauseSummary: summary,
+			Causes:       causes,
+			Findings:     findings,
+			TopN:         limit,
+			Stacks:       make([]ThreadCreateStackInfo, 0, limit),
+		}
+		for i := 0; i < limit; i++ {
+			stat := stats[i]
+			result.Stacks = append(result.Stacks, ThreadCreateStackInfo{
+				Count:      stat.Count,
+				Cause:      causeOf(stat),
+				StackTrace: truncatedStack(stat.Stack, o.MaxStackDepth),
+				Frames:     len(stat.Stack),
+			})
+		}
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling threadcreate analysis to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	return b.String(), nil
+}
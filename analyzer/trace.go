@@ -0,0 +1,438 @@
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// traceEventLine matches an event of 'go tool trace -d=parsed', the text dump of the events of a runtime/trace
+// file (Go 1.22+ format): "M=14886 P=1 G=40 StateTransition Time=24963990229440 GoID=40 Running->Waiting ...".
+var traceEventLine = regexp.MustCompile(`^M=(-?\d+) P=(-?\d+) G=(-?\d+) (\w+) Time=(-?\d+)(.*)$`)
+
+// traceGoroutineTransition and traceProcTransition match the rest of StateTransition events.
+var (
+	traceGoroutineTransition = regexp.MustCompile(`^ GoID=(\d+) (\w+)->(\w+) Reason="((?:[^"\\]|\\.)*)"`)
+	traceProcTransition      = regexp.MustCompile(`^ ProcID=(\d+) (\w+)->(\w+)`)
+	traceGoMaxProcsMetric    = regexp.MustCompile(`^ Name="/sched/gomaxprocs:threads" Value=Value\{Uint64\((\d+)\)\}`)
+)
+
+// TraceSyscallReason is the "reason" under which TraceSummary reports the time goroutines spend in system calls.
+const TraceSyscallReason = "syscall"
+
+// IsGoTrace reports whether data starts like a runtime/trace file: "go 1.22 trace\x00\x00\x00".
+func IsGoTrace(data []byte) bool {
+	header, _, found := bytes.Cut(data, []byte(" trace\x00"))
+	return found && bytes.HasPrefix(header, []byte("go 1.")) && len(header) <= len("go 1.999")
+}
+
+// IsTraceEventDump reports whether data starts like the output of 'go tool trace -d=parsed'.
+func IsTraceEventDump(data []byte) bool {
+	firstLine, _, _ := bytes.Cut(bytes.TrimLeft(data, "\r\n"), []byte("\n"))
+	return traceEventLine.Match(bytes.TrimRight(firstLine, "\r"))
+}
+
+// TraceDurationStats is the distribution of a kind of interval, in nanoseconds.
+type TraceDurationStats struct {
+	Count int   `json:"count"`
+	Total int64 `json:"totalNanos"`
+	P50   int64 `json:"p50Nanos"`
+	P90   int64 `json:"p90Nanos"`
+	P99   int64 `json:"p99Nanos"`
+	Max   int64 `json:"maxNanos"`
+}
+
+// TraceBlockingStats is the distribution of the blocking intervals with the same reason: a wait reason of the
+// runtime ("network", "sync", "chan receive", "sleep", ...) or TraceSyscallReason.
+type TraceBlockingStats struct {
+	Reason string `json:"reason"`
+	TraceDurationStats
+	Ongoing int `json:"ongoing"` // Intervals still open at the end of the trace, counted until then
+}
+
+// TraceBlockingSite is where goroutines blocked for a reason: the first frame outside the standard library
+// of the blocking stack, or its leaf when it has none.
+type TraceBlockingSite struct {
+	Reason   string `json:"reason"`
+	Function string `json:"function"`
+	Location string `json:"location"` // file:line
+	Count    int    `json:"count"`
+	Total    int64  `json:"totalNanos"`
+	Max      int64  `json:"maxNanos"`
+}
+
+// TraceProcUtilization is the time a P (logical processor) spent running goroutines.
+type TraceProcUtilization struct {
+	Proc        int     `json:"proc"`
+	Running     int64   `json:"runningNanos"`
+	Utilization float64 `json:"utilization"` // Percentage of the trace duration
+}
+
+// TraceSummary summarizes the events of a runtime/trace file (see ParseTraceEvents).
+type TraceSummary struct {
+	Duration   int64 `json:"durationNanos"`
+	Goroutines int   `json:"goroutines"` // Goroutines seen in the trace
+	Created    int   `json:"created"`
+	Ended      int   `json:"ended"`
+	GoMaxProcs int64 `json:"gomaxprocs"` // Last value of the /sched/gomaxprocs:threads metric, 0 if absent
+	// Procs are sorted by number; Utilization is the share of GOMAXPROCS (or of the Ps seen) kept busy
+	Procs       []TraceProcUtilization `json:"procs"`
+	Utilization float64                `json:"utilization"`
+	// SchedulerLatency covers the intervals goroutines were runnable before running; RunningSlices the
+	// intervals they ran before blocking, being preempted or ending
+	SchedulerLatency TraceDurationStats   `json:"schedulerLatency"`
+	RunningSlices    TraceDurationStats   `json:"runningSlices"`
+	Blocking         []TraceBlockingStats `json:"blocking"` // Sorted by total time
+	Sites            []TraceBlockingSite  `json:"sites"`    // Sorted by total time
+}
+
+// traceFrame is a frame of a stack printed after an event.
+type traceFrame struct {
+	function, location string
+}
+
+// traceGoroutine is the state of a goroutine while reading the events.
+type traceGoroutine struct {
+	state  string
+	since  int64
+	reason string       // Wait reason, for "Waiting", or TraceSyscallReason
+	frames []traceFrame // Blocking stack, for "Waiting" and "Syscall"
+}
+
+// durationStats computes the distribution of durations, sorting them.
+func durationStats(durations []int64) TraceDurationStats {
+	stats := TraceDurationStats{Count: len(durations)}
+	if len(durations) == 0 {
+		return stats
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	percentile := func(q float64) int64 {
+		i := int(q*float64(len(durations))+0.5) - 1
+		return durations[min(max(i, 0), len(durations)-1)]
+	}
+	for _, d := range durations {
+		stats.Total += d
+	}
+	stats.P50, stats.P90, stats.P99, stats.Max = percentile(0.5), percentile(0.9), percentile(0.99), durations[len(durations)-1]
+	return stats
+}
+
+// blockingSite picks the frame a blocking interval is attributed to (see TraceBlockingSite).
+func blockingSite(frames []traceFrame) traceFrame {
+	for _, f := range frames {
+		if pkg := PackageName(f.function); pkg != "" && !isStandardPackage(pkg) {
+			return f
+		}
+	}
+	if len(frames) > 0 {
+		return frames[0]
+	}
+	return traceFrame{function: "(no stack)"}
+}
+
+// traceStatsHeader and writeTraceStatsRow write a table of TraceDurationStats, one distribution per row.
+func traceStatsHeader(name string) string {
+	return fmt.Sprintf("%-24s %-8s %-12s %-12s %-12s %-12s %-12s\n", name, "Count", "Total", "p50", "p90", "p99", "Max")
+}
+
+func writeTraceStatsRow(b *strings.Builder, name string, st TraceDurationStats, extra string) {
+	ns := func(v int64) string { return FormatSampleValue(v, "nanoseconds") }
+	b.WriteString(fmt.Sprintf("%-24s %-8s %-12s %-12s %-12s %-12s %-12s%s\n", name, FormatCount(int64(st.Count)),
+		ns(st.Total), ns(st.P50), ns(st.P90), ns(st.P99), ns(st.Max), extra))
+}
+
+// ParseTraceEvents summarizes the output of 'go tool trace -d=parsed' read from r: goroutine scheduler latency
+// and running slices, blocking by wait reason (including network waits and system calls) and where it happens,
+// and the utilization of the Ps. Intervals still open at the end of the trace are counted until its last event.
+func ParseTraceEvents(r io.Reader) (*TraceSummary, error) {
+	goroutines := make(map[int64]*traceGoroutine)
+	procsRunning := make(map[int]int64)
+	procsSince := make(map[int]int64) // Start of the current running interval of each P
+	var schedLatency, running []int64
+	blocking := make(map[string][]int64)
+	type siteKey struct{ reason, function, location string }
+	sites := make(map[siteKey]*TraceBlockingSite)
+	summary := &TraceSummary{}
+	var first, last int64
+	events := 0
+
+	endInterval := func(g *traceGoroutine, now int64, to string) {
+		d := max(now-g.since, 0)
+		switch g.state {
+		case "Runnable":
+			if to == "Running" {
+				schedLatency = append(schedLatency, d)
+			}
+		case "Running":
+			running = append(running, d)
+		case "Waiting", "Syscall":
+			blocking[g.reason] = append(blocking[g.reason], d)
+			site := blockingSite(g.frames)
+			key := siteKey{g.reason, site.function, site.location}
+			s, ok := sites[key]
+			if !ok {
+				s = &TraceBlockingSite{Reason: g.reason, Function: site.function, Location: site.location}
+				sites[key] = s
+			}
+			s.Count++
+			s.Total += d
+			s.Max = max(s.Max, d)
+		}
+	}
+
+	var stackOwner *traceGoroutine // Goroutine whose blocking stack the next stack lines belong to
+	stackKind := ""
+	var frame *traceFrame
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			continue
+		case line == "Stack=" || line == "TransitionStack=":
+			// The stack of the transitioning goroutine is preferred over the one of the goroutine emitting it
+			if stackOwner != nil && (len(stackOwner.frames) == 0 || line == "TransitionStack=") {
+				stackKind = line
+				stackOwner.frames = stackOwner.frames[:0]
+			} else {
+				stackKind = ""
+			}
+			continue
+		case strings.HasPrefix(line, "\t\t"):
+			if stackKind != "" && frame != nil {
+				frame.location = strings.TrimSpace(line)
+			}
+			continue
+		case strings.HasPrefix(line, "\t"):
+			if stackKind != "" {
+				function, _, _ := strings.Cut(strings.TrimSpace(line), " @ ")
+				stackOwner.frames = append(stackOwner.frames, traceFrame{function: function})
+				frame = &stackOwner.frames[len(stackOwner.frames)-1]
+			}
+			continue
+		}
+
+		m := traceEventLine.FindStringSubmatch(line)
+		if m == nil {
+			continue // Lines of other events' attributes
+		}
+		if stackKind == "TransitionStack=" {
+			stackOwner = nil // A transition stack was read; the event's own stack does not replace it
+		}
+		stackKind, frame = "", nil
+		now, _ := strconv.ParseInt(m[5], 10, 64)
+		if events == 0 {
+			first = now
+		}
+		events++
+		last = max(last, now)
+		kind, rest := m[4], m[6]
+		if kind == "Metric" {
+			if gm := traceGoMaxProcsMetric.FindStringSubmatch(rest); gm != nil {
+				summary.GoMaxProcs, _ = strconv.ParseInt(gm[1], 10, 64)
+			}
+			stackOwner = nil
+			continue
+		}
+		if kind != "StateTransition" {
+			stackOwner = nil
+			continue
+		}
+		if pm := traceProcTransition.FindStringSubmatch(rest); pm != nil {
+			proc, _ := strconv.Atoi(pm[1])
+			if _, ok := procsRunning[proc]; !ok {
+				procsRunning[proc] = 0
+			}
+			if since, ok := procsSince[proc]; ok && pm[2] == "Running" {
+				procsRunning[proc] += max(now-since, 0)
+				delete(procsSince, proc)
+			}
+			if pm[3] == "Running" {
+				procsSince[proc] = now
+			}
+			stackOwner = nil
+			continue
+		}
+		gm := traceGoroutineTransition.FindStringSubmatch(rest)
+		if gm == nil {
+			stackOwner = nil
+			continue
+		}
+		id, _ := strconv.ParseInt(gm[1], 10, 64)
+		from, to, reason := gm[2], gm[3], gm[4]
+		g, ok := goroutines[id]
+		if !ok {
+			g = &traceGoroutine{}
+			goroutines[id] = g
+		} else if g.state == from {
+			endInterval(g, now, to)
+		}
+		if from == "NotExist" {
+			summary.Created++
+		}
+		if to == "NotExist" {
+			summary.Ended++
+		}
+		g.state, g.since, g.reason, g.frames = to, now, "", g.frames[:0]
+		stackOwner = nil
+		switch to {
+		case "Waiting":
+			g.reason = reason
+			if g.reason == "" {
+				g.reason = "unknown"
+			}
+			stackOwner = g
+		case "Syscall":
+			g.reason = TraceSyscallReason
+			stackOwner = g
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace events: %w", err)
+	}
+	if events == 0 {
+		return nil, fmt.Errorf("no trace events found")
+	}
+
+	// Close the intervals still open at the end of the trace
+	ongoing := make(map[string]int)
+	for _, g := range goroutines {
+		if g.state == "Waiting" || g.state == "Syscall" {
+			ongoing[g.reason]++
+			endInterval(g, last, "")
+		}
+	}
+	for proc, since := range procsSince {
+		procsRunning[proc] += max(last-since, 0)
+	}
+
+	summary.Duration = last - first
+	summary.Goroutines = len(goroutines)
+	summary.SchedulerLatency = durationStats(schedLatency)
+	summary.RunningSlices = durationStats(running)
+	var busy int64
+	for proc, runningTime := range procsRunning {
+		u := TraceProcUtilization{Proc: proc, Running: runningTime}
+		if summary.Duration > 0 {
+			u.Utilization = float64(runningTime) / float64(summary.Duration) * 100
+		}
+		summary.Procs = append(summary.Procs, u)
+		busy += runningTime
+	}
+	sort.Slice(summary.Procs, func(i, j int) bool { return summary.Procs[i].Proc < summary.Procs[j].Proc })
+	if procs := max(summary.GoMaxProcs, int64(len(summary.Procs))); procs > 0 && summary.Duration > 0 {
+		summary.Utilization = float64(busy) / float64(procs*summary.Duration) * 100
+	}
+	for reason, durations := range blocking {
+		summary.Blocking = append(summary.Blocking, TraceBlockingStats{Reason: reason, TraceDurationStats: durationStats(durations), Ongoing: ongoing[reason]})
+	}
+	sort.Slice(summary.Blocking, func(i, j int) bool {
+		if summary.Blocking[i].Total != summary.Blocking[j].Total {
+			return summary.Blocking[i].Total > summary.Blocking[j].Total
+		}
+		return summary.Blocking[i].Reason < summary.Blocking[j].Reason
+	})
+	for _, s := range sites {
+		summary.Sites = append(summary.Sites, *s)
+	}
+	sort.Slice(summary.Sites, func(i, j int) bool {
+		a, b := summary.Sites[i], summary.Sites[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Reason+a.Function+a.Location < b.Reason+b.Function+b.Location
+	})
+	return summary, nil
+}
+
+// FormatTraceSummary renders a trace summary as "text" or "json", with the topN blocking sites (all in JSON
+// for topN <= 0). Waits of system goroutines ("system goroutine wait", GC workers) are listed in the blocking
+// table but not among the sites.
+func FormatTraceSummary(s *TraceSummary, topN int, format string) (string, error) {
+	sites := make([]TraceBlockingSite, 0, len(s.Sites))
+	for _, site := range s.Sites {
+		if topN > 0 && len(sites) == topN {
+			break
+		}
+		if !strings.Contains(site.Reason, "system goroutine") && !strings.HasPrefix(site.Reason, "GC") {
+			sites = append(sites, site)
+		}
+	}
+	if format == "json" {
+		result := *s
+		result.Sites = sites
+		jsonBytes, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal trace summary to JSON: %w", err)
+		}
+		return string(jsonBytes), nil
+	}
+	if format != "text" {
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	ns := func(v int64) string { return FormatSampleValue(v, "nanoseconds") }
+	pct := func(v float64) string { return DefaultValueFormat().Float(v, 2) + "%" }
+	var b strings.Builder
+	b.WriteString("Execution Trace Analysis\n")
+	b.WriteString(fmt.Sprintf("Duration: %s, Goroutines: %s (%s created, %s ended during the trace)", ns(s.Duration),
+		FormatCount(int64(s.Goroutines)), FormatCount(int64(s.Created)), FormatCount(int64(s.Ended))))
+	if s.GoMaxProcs > 0 {
+		b.WriteString(fmt.Sprintf(", GOMAXPROCS: %d", s.GoMaxProcs))
+	}
+	b.WriteString("\n")
+
+	b.WriteString("\n=== Proc Utilization ===\n")
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(fmt.Sprintf("%-8s %-15s %s\n", "Proc", "Running", "Utilization"))
+	b.WriteString("--------------------------------------------------\n")
+	for _, p := range s.Procs {
+		b.WriteString(fmt.Sprintf("%-8s %-15s %s\n", fmt.Sprintf("P%d", p.Proc), ns(p.Running), pct(p.Utilization)))
+	}
+	procs := max(s.GoMaxProcs, int64(len(s.Procs)))
+	b.WriteString(fmt.Sprintf("Average: %s of %d procs kept busy over %s\n", pct(s.Utilization), procs, ns(s.Duration)))
+
+	row := func(name string, st TraceDurationStats, extra string) { writeTraceStatsRow(&b, name, st, extra) }
+	b.WriteString("\n=== Goroutine Latency ===\n")
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(traceStatsHeader("Interval"))
+	b.WriteString("--------------------------------------------------\n")
+	row("scheduler latency", s.SchedulerLatency, "")
+	row("running slices", s.RunningSlices, "")
+	b.WriteString("Scheduler latency: time runnable goroutines waited for a P; running slices: time they ran before blocking, being preempted or exiting.\n")
+
+	b.WriteString("\n=== Blocking by Reason ===\n")
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(traceStatsHeader("Reason"))
+	b.WriteString("--------------------------------------------------\n")
+	if len(s.Blocking) == 0 {
+		b.WriteString("No goroutine blocked during the trace.\n")
+	}
+	for _, st := range s.Blocking {
+		extra := ""
+		if st.Ongoing > 0 {
+			extra = fmt.Sprintf(" (%d still blocked at the end)", st.Ongoing)
+		}
+		row(st.Reason, st.TraceDurationStats, extra)
+	}
+
+	b.WriteString(fmt.Sprintf("\n=== Top %d Blocking Sites ===\n", len(sites)))
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(fmt.Sprintf("%-16s %-8s %-12s %-12s %s\n", "Reason", "Count", "Total", "Max", "Site"))
+	b.WriteString("--------------------------------------------------\n")
+	for _, site := range sites {
+		where := site.Function
+		if site.Location != "" {
+			where += " (" + site.Location + ")"
+		}
+		b.WriteString(fmt.Sprintf("%-16s %-8s %-12s %-12s %s\n", site.Reason, FormatCount(int64(site.Count)), ns(site.Total), ns(site.Max), where))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,342 @@
+package analyzer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// traceRange and traceUint64Metric match the rest of RangeBegin/RangeActive/RangeEnd and Metric events.
+var (
+	traceRange        = regexp.MustCompile(`^ Name="((?:[^"\\]|\\.)*)" Scope=(\S+)`)
+	traceUint64Metric = regexp.MustCompile(`^ Name="([^"]+)" Value=Value\{Uint64\((\d+)\)\}`)
+)
+
+// Names of the ranges and metrics of the runtime's GC in execution traces.
+const (
+	traceGCMarkRange    = "GC concurrent mark phase"
+	traceGCAssistRange  = "GC mark assist"
+	traceGCPausePrefix  = "stop-the-world (GC"
+	traceHeapGoalMetric = "/gc/heap/goal:bytes"
+	traceHeapLiveMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// DefaultTraceGCPoints is the default number of points of the heap time series of a TraceGCSummary.
+const DefaultTraceGCPoints = 200
+
+// TraceGCCycle is one garbage collection seen in the trace. Times are relative to the start of the trace.
+type TraceGCCycle struct {
+	Start        int64 `json:"startNanos"` // Start of the mark phase, which the runtime records before its first pause
+	Mark         int64 `json:"markNanos"`  // Duration of the concurrent mark phase
+	Pause        int64 `json:"pauseNanos"` // Stop-the-world pauses of the cycle
+	Assist       int64 `json:"assistNanos"`
+	HeapLive     int64 `json:"heapLiveBytes"` // Heap objects when the mark phase started
+	HeapGoal     int64 `json:"heapGoalBytes"` // Goal the cycle was triggered for
+	NextHeapGoal int64 `json:"nextHeapGoalBytes"`
+}
+
+// TraceHeapPoint is a point of the heap time series: the last values at Time, and the peak of the heap
+// objects since the previous point.
+type TraceHeapPoint struct {
+	Time     int64 `json:"timeNanos"`
+	HeapLive int64 `json:"heapLiveBytes"`
+	HeapPeak int64 `json:"heapPeakBytes"`
+	HeapGoal int64 `json:"heapGoalBytes"`
+}
+
+// TraceGCSummary summarizes the garbage collections of a trace (see ParseTraceGC).
+type TraceGCSummary struct {
+	Duration     int64              `json:"durationNanos"`
+	GoMaxProcs   int64              `json:"gomaxprocs"`
+	Pauses       TraceDurationStats `json:"pauses"` // Stop-the-world pauses of the GC
+	PausePercent float64            `json:"pausePercent"`
+	MarkPhases   TraceDurationStats `json:"markPhases"`
+	Assists      TraceDurationStats `json:"assists"`
+	Cycles       []TraceGCCycle     `json:"cycles"` // In order
+	Series       []TraceHeapPoint   `json:"series"`
+}
+
+// traceHeapBucket is the state of the heap during one bucket of a traceHeapDownsample.
+type traceHeapBucket struct {
+	heapLive, heapPeak, heapGoal int64
+	liveSet, goalSet             bool
+}
+
+// traceHeapDownsample keeps the heap metrics in at most 2*maxPoints buckets of equal width while the
+// events are read: when a sample falls beyond the last bucket, neighbouring buckets are merged and the width
+// doubles, so long traces with millions of samples need no more memory than short ones.
+type traceHeapDownsample struct {
+	origin, width int64
+	maxPoints     int
+	buckets       []traceHeapBucket
+}
+
+func (d *traceHeapDownsample) bucket(t int64) *traceHeapBucket {
+	i := int(max(t-d.origin, 0) / d.width)
+	for i >= 2*d.maxPoints {
+		d.merge()
+		i = int(max(t-d.origin, 0) / d.width)
+	}
+	for len(d.buckets) <= i {
+		d.buckets = append(d.buckets, traceHeapBucket{})
+	}
+	return &d.buckets[i]
+}
+
+// merge halves the number of buckets, keeping the last values and the peak of each pair.
+func (d *traceHeapDownsample) merge() {
+	merged := make([]traceHeapBucket, 0, (len(d.buckets)+1)/2)
+	for i := 0; i < len(d.buckets); i += 2 {
+		m := d.buckets[i]
+		if i+1 < len(d.buckets) {
+			next := d.buckets[i+1]
+			if next.liveSet {
+				m.heapLive, m.heapPeak, m.liveSet = next.heapLive, max(m.heapPeak, next.heapPeak), true
+			}
+			if next.goalSet {
+				m.heapGoal, m.goalSet = next.heapGoal, true
+			}
+		}
+		merged = append(merged, m)
+	}
+	d.buckets, d.width = merged, d.width*2
+}
+
+// ParseTraceGC summarizes the garbage collections in the output of 'go tool trace -d=parsed' read from r:
+// the stop-the-world pauses, concurrent mark phases and mark assists of the GC, each cycle with the heap
+// goal it ran for, and a time series of the heap objects and the heap goal downsampled to about maxPoints
+// points (DefaultTraceGCPoints for maxPoints <= 0). Ranges still open at the end of the trace are counted
+// until its last event.
+func ParseTraceGC(r io.Reader, maxPoints int) (*TraceGCSummary, error) {
+	if maxPoints <= 0 {
+		maxPoints = DefaultTraceGCPoints
+	}
+	summary := &TraceGCSummary{}
+	var pauses, marks, assists []int64
+	openRanges := make(map[string]int64) // Start of the open ranges, by name and scope
+	var heap *traceHeapDownsample
+	var first, last, heapLive, heapGoal int64
+	events := 0
+	var cycle *TraceGCCycle // Latest cycle
+	markEnded := false
+
+	endRange := func(name string, start, end int64) {
+		d := max(end-start, 0)
+		switch {
+		case name == traceGCMarkRange:
+			marks = append(marks, d)
+			if cycle != nil {
+				cycle.Mark = d
+			}
+			markEnded = true
+		case name == traceGCAssistRange:
+			assists = append(assists, d)
+			if cycle != nil {
+				cycle.Assist += d
+			}
+		case strings.HasPrefix(name, traceGCPausePrefix):
+			pauses = append(pauses, d)
+			if cycle != nil {
+				cycle.Pause += d
+			}
+		}
+	}
+	beginCycle := func(now int64) {
+		summary.Cycles = append(summary.Cycles, TraceGCCycle{Start: now, HeapGoal: heapGoal})
+		cycle = &summary.Cycles[len(summary.Cycles)-1]
+		markEnded = false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		m := traceEventLine.FindStringSubmatch(line)
+		if m == nil {
+			continue // Stacks and other attributes
+		}
+		now, _ := strconv.ParseInt(m[5], 10, 64)
+		if events == 0 {
+			first = now
+			heap = &traceHeapDownsample{origin: now, width: 1000, maxPoints: maxPoints}
+		}
+		events++
+		last = max(last, now)
+		kind, rest := m[4], m[6]
+		switch kind {
+		case "Metric":
+			mm := traceUint64Metric.FindStringSubmatch(rest)
+			if mm == nil {
+				continue
+			}
+			value, _ := strconv.ParseInt(mm[2], 10, 64)
+			switch mm[1] {
+			case "/sched/gomaxprocs:threads":
+				summary.GoMaxProcs = value
+			case traceHeapGoalMetric:
+				heapGoal = value
+				if cycle != nil && markEnded && cycle.NextHeapGoal == 0 {
+					cycle.NextHeapGoal = value
+				}
+				b := heap.bucket(now)
+				b.heapGoal, b.goalSet = value, true
+			case traceHeapLiveMetric:
+				heapLive = value
+				b := heap.bucket(now)
+				if !b.liveSet {
+					b.heapPeak = value
+				}
+				b.heapLive, b.heapPeak, b.liveSet = value, max(b.heapPeak, value), true
+			}
+		case "RangeBegin", "RangeActive", "RangeEnd":
+			rm := traceRange.FindStringSubmatch(rest)
+			if rm == nil {
+				continue
+			}
+			name, key := rm[1], rm[1]+"\x00"+rm[2]
+			if name != traceGCMarkRange && name != traceGCAssistRange && !strings.HasPrefix(name, traceGCPausePrefix) {
+				continue
+			}
+			if kind != "RangeEnd" {
+				if name == traceGCMarkRange {
+					beginCycle(now)
+					cycle.HeapLive = heapLive
+				}
+				if _, open := openRanges[key]; !open {
+					openRanges[key] = now
+				}
+				continue
+			}
+			start, open := openRanges[key]
+			if !open {
+				start = first // Active since before the trace started
+			}
+			delete(openRanges, key)
+			endRange(name, start, now)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace events: %w", err)
+	}
+	if events == 0 {
+		return nil, fmt.Errorf("no trace events found")
+	}
+	// Ranges are closed in order of their start, like they would have been
+	open := make([]string, 0, len(openRanges))
+	for key := range openRanges {
+		open = append(open, key)
+	}
+	sort.Slice(open, func(i, j int) bool { return openRanges[open[i]] < openRanges[open[j]] })
+	for _, key := range open {
+		name, _, _ := strings.Cut(key, "\x00")
+		endRange(name, openRanges[key], last)
+	}
+
+	summary.Duration = last - first
+	summary.Pauses = durationStats(pauses)
+	summary.MarkPhases = durationStats(marks)
+	summary.Assists = durationStats(assists)
+	if summary.Duration > 0 {
+		summary.PausePercent = float64(summary.Pauses.Total) / float64(summary.Duration) * 100
+	}
+	for i := range summary.Cycles {
+		summary.Cycles[i].Start -= first
+	}
+
+	for len(heap.buckets) > maxPoints {
+		heap.merge()
+	}
+	var point TraceHeapPoint
+	for i, b := range heap.buckets {
+		point.HeapPeak = point.HeapLive
+		if b.liveSet {
+			point.HeapLive, point.HeapPeak = b.heapLive, b.heapPeak
+		}
+		if b.goalSet {
+			point.HeapGoal = b.heapGoal
+		}
+		point.Time = min(int64(i+1)*heap.width, summary.Duration)
+		summary.Series = append(summary.Series, point)
+	}
+	return summary, nil
+}
+
+// FormatTraceGCSummary renders a GC summary as "text" or "json". The text lists the topN cycles with the
+// longest pauses (all for topN <= 0) and the range of the heap goal; the time series is only in JSON.
+func FormatTraceGCSummary(s *TraceGCSummary, topN int, format string) (string, error) {
+	if format == "json" {
+		jsonBytes, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal GC summary to JSON: %w", err)
+		}
+		return string(jsonBytes), nil
+	}
+	if format != "text" {
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+
+	ns := func(v int64) string { return FormatSampleValue(v, "nanoseconds") }
+	var b strings.Builder
+	b.WriteString("Execution Trace GC Analysis\n")
+	b.WriteString(fmt.Sprintf("Duration: %s, GC cycles: %s", ns(s.Duration), FormatCount(int64(len(s.Cycles)))))
+	if len(s.Cycles) > 1 {
+		b.WriteString(fmt.Sprintf(" (one every %s)", ns((s.Cycles[len(s.Cycles)-1].Start-s.Cycles[0].Start)/int64(len(s.Cycles)-1))))
+	}
+	if s.GoMaxProcs > 0 {
+		b.WriteString(fmt.Sprintf(", GOMAXPROCS: %d", s.GoMaxProcs))
+	}
+	b.WriteString("\n")
+
+	b.WriteString("\n=== GC Phases ===\n")
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(traceStatsHeader("Phase"))
+	b.WriteString("--------------------------------------------------\n")
+	writeTraceStatsRow(&b, "stop-the-world pauses", s.Pauses, "")
+	writeTraceStatsRow(&b, "concurrent mark", s.MarkPhases, "")
+	writeTraceStatsRow(&b, "mark assists", s.Assists, "")
+	b.WriteString(fmt.Sprintf("Pauses stopped the program for %s%% of the trace. Mark assists are time allocating goroutines spent marking instead of running.\n",
+		DefaultValueFormat().Float(s.PausePercent, 2)))
+
+	if len(s.Cycles) == 0 {
+		b.WriteString("\nNo garbage collection ran during the trace.\n")
+		return b.String(), nil
+	}
+	cycles := make([]int, len(s.Cycles))
+	for i := range cycles {
+		cycles[i] = i
+	}
+	sort.SliceStable(cycles, func(i, j int) bool { return s.Cycles[cycles[i]].Pause > s.Cycles[cycles[j]].Pause })
+	if topN > 0 && len(cycles) > topN {
+		cycles = cycles[:topN]
+	}
+	b.WriteString(fmt.Sprintf("\n=== Top %d GC Cycles by Pause ===\n", len(cycles)))
+	b.WriteString("--------------------------------------------------\n")
+	b.WriteString(fmt.Sprintf("%-6s %-12s %-12s %-12s %-12s %-12s %-12s %s\n", "Cycle", "Start", "Pause", "Mark", "Assist", "Heap Live", "Heap Goal", "Next Goal"))
+	b.WriteString("--------------------------------------------------\n")
+	for _, i := range cycles {
+		c := s.Cycles[i]
+		next := "-"
+		if c.NextHeapGoal > 0 {
+			next = FormatBytes(c.NextHeapGoal)
+		}
+		b.WriteString(fmt.Sprintf("%-6d %-12s %-12s %-12s %-12s %-12s %-12s %s\n", i+1, ns(c.Start), ns(c.Pause), ns(c.Mark), ns(c.Assist),
+			FormatBytes(c.HeapLive), FormatBytes(c.HeapGoal), next))
+	}
+
+	var minGoal, maxGoal, peak int64
+	for _, p := range s.Series {
+		if p.HeapGoal > 0 && (minGoal == 0 || p.HeapGoal < minGoal) {
+			minGoal = p.HeapGoal
+		}
+		maxGoal, peak = max(maxGoal, p.HeapGoal), max(peak, p.HeapPeak)
+	}
+	b.WriteString("\n=== Heap Goal Progression ===\n")
+	b.WriteString(fmt.Sprintf("Heap goal: %s to %s, heap objects peaked at %s. Request output_format 'json' for the time series (%d points).\n",
+		FormatBytes(minGoal), FormatBytes(maxGoal), FormatBytes(peak), len(s.Series)))
+	return b.String(), nil
+}
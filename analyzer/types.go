@@ -14,6 +14,9 @@ type CPUFunctionStat struct {
 	FlatValue          int64   `json:"flatValue"`          // 原始值
 	FlatValueFormatted string  `json:"flatValueFormatted"` // 格式化后的值 (e.g., "1.23s")
 	Percentage         float64 `json:"percentage"`         // 占总量的百分比
+	CumValue           int64   `json:"cumValue"`           // 累计值：函数出现在堆栈任意位置的样本值之和
+	CumValueFormatted  string  `json:"cumValueFormatted"`
+	CumPercentage      float64 `json:"cumPercentage"`
 }
 
 // CPUAnalysisResult 代表 CPU 分析的整体结果 (JSON)
@@ -25,6 +28,7 @@ type CPUAnalysisResult struct {
 	TotalValueFormatted string            `json:"totalValueFormatted"`          // 格式化后的总值
 	TotalDurationNanos  int64             `json:"totalDurationNanos,omitempty"` // 可选的总持续时间 (纳秒)
 	TopN                int               `json:"topN"`                         // 返回的 Top N 数量
+	SortBy              string            `json:"sortBy"`                       // "flat" 或 "cum"
 	Functions           []CPUFunctionStat `json:"functions"`                    // Top N 函数列表
 }
 
@@ -50,15 +54,71 @@ type HeapAnalysisResult struct {
 // GoroutineStackInfo 代表 Goroutine 分析中的单个堆栈信息 (JSON)
 type GoroutineStackInfo struct {
 	Count      int64    `json:"count"`      // 具有此堆栈的 Goroutine 数量
-	StackTrace []string `json:"stackTrace"` // 格式化的堆栈跟踪行
+	StackTrace []string `json:"stackTrace"` // 格式化的堆栈跟踪行，超过 max_stack_depth 时以 "… N more frames" 结尾
+	Frames     int      `json:"frames"`     // 完整堆栈的帧数 (截断前)
+}
+
+// GoroutineStateCount 代表处于某一等待原因 (或 running) 的 Goroutine 数量 (JSON)
+type GoroutineStateCount struct {
+	State string `json:"state"` // 例如 "chan receive"、"IO wait"、"running"
+	Count int64  `json:"count"`
 }
 
 // GoroutineAnalysisResult 代表 Goroutine 分析的整体结果 (JSON)
 type GoroutineAnalysisResult struct {
-	ProfileType     string               `json:"profileType"`
-	TotalGoroutines int64                `json:"totalGoroutines"`
-	TopN            int                  `json:"topN"`   // 返回的 Top N 数量
-	Stacks          []GoroutineStackInfo `json:"stacks"` // Top N 堆栈列表
+	ProfileType     string                `json:"profileType"`
+	TotalGoroutines int64                 `json:"totalGoroutines"`
+	StateSummary    string                `json:"stateSummary"` // 一行摘要，例如 "3240 total: 2100 chan receive, 240 running"
+	States          []GoroutineStateCount `json:"states"`       // 按数量降序的等待原因
+	TopN            int                   `json:"topN"`         // 返回的 Top N 数量
+	Stacks          []GoroutineStackInfo  `json:"stacks"`       // Top N 堆栈列表
+	// 以下字段仅用于完整的 goroutine 转储 (debug=2)，其中包含运行时报告的状态和等待时长
+	StateWaits     []GoroutineStateWait `json:"stateWaits,omitempty"`     // 按状态统计
+	LongestWaiting []GoroutineWaitStack `json:"longestWaiting,omitempty"` // 等待时间最长的 Top N 堆栈
+}
+
+// GoroutineStateWait 代表 goroutine 转储中处于某一状态的 goroutine 及其等待时长 (JSON)
+type GoroutineStateWait struct {
+	State          string `json:"state"`          // 运行时打印的状态，例如 "chan receive"、"IO wait"、"running"
+	Count          int64  `json:"count"`          // 处于该状态的 goroutine 数量
+	Waiting        int64  `json:"waiting"`        // 其中已等待至少 1 分钟的数量 (运行时只打印 1 分钟以上的等待时长)
+	MaxWaitMinutes int64  `json:"maxWaitMinutes"` // 最长等待时长 (分钟)
+}
+
+// GoroutineWaitStack 代表 goroutine 转储中处于同一状态、堆栈相同的一组 goroutine 及其等待时长 (JSON)
+type GoroutineWaitStack struct {
+	State          string   `json:"state"`
+	Count          int64    `json:"count"`               // 该组 goroutine 的数量
+	MinWaitMinutes int64    `json:"minWaitMinutes"`      // 最短等待时长 (分钟，未打印的视为 0)
+	MaxWaitMinutes int64    `json:"maxWaitMinutes"`      // 最长等待时长 (分钟)
+	CreatedBy      string   `json:"createdBy,omitempty"` // 启动这些 goroutine 的函数
+	StackTrace     []string `json:"stackTrace"`          // 格式化的堆栈跟踪行，超过 max_stack_depth 时以 "… N more frames" 结尾
+	Frames         int      `json:"frames"`              // 完整堆栈的帧数 (截断前)
+}
+
+// ThreadCreateStackInfo 代表 threadcreate 分析中创建线程的单个堆栈 (JSON)
+type ThreadCreateStackInfo struct {
+	Count      int64    `json:"count"`      // 由此堆栈创建的 OS 线程数量
+	Cause      string   `json:"cause"`      // 例如 "blocking syscall"、"cgo call"、"scheduler"
+	StackTrace []string `json:"stackTrace"` // 格式化的堆栈跟踪行，超过 max_stack_depth 时以 "… N more frames" 结尾
+	Frames     int      `json:"frames"`     // 完整堆栈的帧数 (截断前)
+}
+
+// ThreadCreateCauseCount 代表因某一原因创建的 OS 线程数量 (JSON)
+type ThreadCreateCauseCount struct {
+	Cause string `json:"cause"`
+	Count int64  `json:"count"`
+}
+
+// ThreadCreateAnalysisResult 代表 threadcreate 分析的整体结果 (JSON)
+type ThreadCreateAnalysisResult struct {
+	ProfileType  string                   `json:"profileType"`
+	TotalThreads int64                    `json:"totalThreads"`
+	CauseSummary string                   `json:"causeSummary"` // 一行摘要，例如 "120 total: 110 blocking syscall, 10 scheduler"
+	Causes       []ThreadCreateCauseCount `json:"causes"`       // 按数量降序的创建原因
+	Findings     []string                 `json:"findings,omitempty"`
+	TopN         int                      `json:"topN"`
+	Stacks       []ThreadCreateStackInfo  `json:"stacks"`
 }
 
 // FlameGraphNode 代表火焰图中的一个节点 (JSON)
@@ -76,17 +136,20 @@ type FlameGraphNode struct {
 	AvgSize          int64  `json:"avgSize,omitempty"`
 	AvgSizeFormatted string `json:"avgSizeFormatted,omitempty"`
 	Type             string `json:"type,omitempty"`
+	HiddenChildren   int    `json:"hiddenChildren,omitempty"` // 因深度限制而省略的子节点数量 (仅用于子树查询)
+	// 每个标签 (pprof tag) 占比最高的值，供渲染器显示提示，例如 "90% of this frame has tenant=acme"
+	Labels map[string]FlameGraphLabel `json:"labels,omitempty"`
+}
+
+// FlameGraphLabel 是某个标签在火焰图节点中占比最高的值
+type FlameGraphLabel struct {
+	Value      string  `json:"value"`
+	Percentage float64 `json:"percentage"` // 带有该值的样本占节点值的百分比
 }
 
 // --- 内部辅助结构体 ---
 
-// functionStat 保存函数的聚合统计信息。
-// 注意：保持未导出，因为它只在包内部使用。
-type functionStat struct {
-	Name string
-	Flat int64 // 函数自身的消耗值 (例如 CPU 时间、内存分配)
-	Cum  int64 // 函数及其调用链的总消耗值 (当前未使用)
-}
+// 按函数/分配位置的聚合统计见 aggregate 包 (aggregate.Stat)。
 
 // stackInfo 结构体已移至 goroutine.go
 
@@ -0,0 +1,138 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// A replica is an outlier for a function when its share is at least ReplicaOutlierRatio times the mean share
+// of the other replicas and exceeds it by at least ReplicaOutlierMinShare (1 percentage point). Comparing
+// against the other replicas rather than a z-score keeps the rule usable with only two or three replicas.
+const (
+	ReplicaOutlierRatio    = 2.0
+	ReplicaOutlierMinShare = 0.01
+)
+
+// FunctionVariance describes how one function's share of the profile varies across replicas.
+type FunctionVariance struct {
+	Function  string    `json:"function"`
+	Shares    []float64 `json:"shares"` // Flat share per replica, in the order of ReplicaVarianceReport.Replicas
+	MeanShare float64   `json:"meanShare"`
+	StdDev    float64   `json:"stdDev"` // Population standard deviation of Shares
+	CoeffVar  float64   `json:"coefficientOfVariation"`
+	Outliers  []string  `json:"outliers,omitempty"` // Replicas where the function is disproportionately hot
+	Scope     string    `json:"scope"`              // "systemic" (hot everywhere) or "localized" (driven by outliers)
+}
+
+// ReplicaVarianceReport is the per-function variance across the profiles of several replicas of one service.
+type ReplicaVarianceReport struct {
+	SampleType string             `json:"sampleType"`
+	Unit       string             `json:"unit"`
+	Replicas   []string           `json:"replicas"`
+	Totals     []int64            `json:"totals"`
+	Functions  []FunctionVariance `json:"functions"`
+}
+
+// BuildReplicaVariance compares the flat share of the topN hottest functions (see BuildHeatmap) across
+// replicas. Shares rather than absolute values are compared, so replicas with different load or capture
+// lengths remain comparable.
+func BuildReplicaVariance(replicas []*profile.Profile, labels []string, sampleType string, topN int) (*ReplicaVarianceReport, error) {
+	if len(replicas) < 2 {
+		return nil, fmt.Errorf("variance needs at least 2 replicas, got %d", len(replicas))
+	}
+	heatmap, err := BuildHeatmap(replicas, labels, sampleType, topN)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReplicaVarianceReport{
+		SampleType: heatmap.SampleType,
+		Unit:       heatmap.Unit,
+		Replicas:   heatmap.Snapshots,
+		Totals:     heatmap.ColumnTotals,
+		Functions:  make([]FunctionVariance, 0, len(heatmap.Functions)),
+	}
+	n := float64(len(replicas))
+	for i, name := range heatmap.Functions {
+		shares := heatmap.Normalized[i]
+		sum := 0.0
+		for _, s := range shares {
+			sum += s
+		}
+		mean := sum / n
+		variance := 0.0
+		for _, s := range shares {
+			variance += (s - mean) * (s - mean)
+		}
+		fv := FunctionVariance{
+			Function:  name,
+			Shares:    shares,
+			MeanShare: mean,
+			StdDev:    math.Sqrt(variance / n),
+			Scope:     "systemic",
+		}
+		if mean > 0 {
+			fv.CoeffVar = fv.StdDev / mean
+		}
+		for j, s := range shares {
+			othersMean := (sum - s) / (n - 1)
+			if s >= ReplicaOutlierRatio*othersMean && s-othersMean >= ReplicaOutlierMinShare {
+				fv.Outliers = append(fv.Outliers, labels[j])
+			}
+		}
+		if len(fv.Outliers) > 0 {
+			fv.Scope = "localized"
+		}
+		report.Functions = append(report.Functions, fv)
+	}
+	return report, nil
+}
+
+// FormatReplicaVariance builds the variance report (see BuildReplicaVariance) in the given format
+// ("text", "markdown" or "json").
+func FormatReplicaVariance(replicas []*profile.Profile, labels []string, sampleType string, topN int, format string) (string, error) {
+	log.Printf("Computing per-replica variance over %d replicas (SampleType: %s, Top %d, Format: %s)", len(replicas), sampleType, topN, format)
+	report, err := BuildReplicaVariance(replicas, labels, sampleType, topN)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case "text", "markdown":
+		w := newReportWriter(format)
+		w.title("Per-Replica Variance (Top %d Functions, Sample Type: %s)", len(report.Functions), report.SampleType)
+		for j, label := range report.Replicas {
+			w.line("  [%d] %s (total %s)", j+1, label, FormatSampleValue(report.Totals[j], report.Unit))
+		}
+		t := newTable(valueColumn("Mean%"), valueColumn("StdDev%"), valueColumn("CV"), textColumn("Scope", 10), nameColumn("Function Name"))
+		for _, fv := range report.Functions {
+			t.add(percentString(fv.MeanShare*100), percentString(fv.StdDev*100), DefaultValueFormat().Float(fv.CoeffVar, 2), fv.Scope, fv.Function)
+			shares := make([]string, len(fv.Shares))
+			for j, s := range fv.Shares {
+				shares[j] = fmt.Sprintf("[%d] %.2f%%", j+1, s*100)
+			}
+			t.note("         per replica: " + strings.Join(shares, ", "))
+			if len(fv.Outliers) > 0 {
+				t.note("         outliers: " + strings.Join(fv.Outliers, ", "))
+			}
+		}
+		w.table(t)
+		return w.String(), nil
+	case "json":
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling replica variance to JSON: %v", err)
+			errorResult := ErrorResult{Error: fmt.Sprintf("Failed to marshal result to JSON: %v", err)}
+			errJsonBytes, _ := json.Marshal(errorResult)
+			return string(errJsonBytes), nil
+		}
+		return string(jsonBytes), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
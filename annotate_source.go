@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// handleAnnotateSource lists the source lines of matching functions with their flat and cum values, like
+// 'go tool pprof -list'.
+func handleAnnotateSource(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	functionRegex, ok := args["function_regex"].(string)
+	if !ok || functionRegex == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: function_regex (string)")
+	}
+	sourceRoot, _ := args["source_root"].(string) // 为空时按 profile 中记录的路径读取源码
+	sampleType, _ := args["sample_type"].(string)
+	limitFloat, ok := args["limit"].(float64)
+	if !ok {
+		limitFloat = float64(analyzer.DefaultAnnotateFunctions)
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "markdown"
+	}
+
+	log.Printf("Handling annotate_source: URI=%s, Regex=%q, SourceRoot=%q, SampleType=%q, Limit=%d, Format=%s",
+		profileURIStr, functionRegex, sourceRoot, sampleType, int(limitFloat), outputFormat)
+
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	annotated, err := analyzer.AnnotateSource(prof, functionRegex, sourceRoot, sampleType, int(limitFloat))
+	if err != nil {
+		return nil, err
+	}
+	result, err := analyzer.FormatAnnotatedSource(annotated, outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "annotate_source", outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), prof), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+)
+
+// artifactMetadataNamespace is the XML namespace of the metadata embedded in generated SVGs.
+const artifactMetadataNamespace = "https://github.com/ZephyrDeng/pprof-analyzer-mcp"
+
+// artifactMetadata describes how an artifact was generated, so it stays self-describing when it is passed
+// around detached from its analysis. It is embedded in SVGs as the content of a <metadata> element.
+type artifactMetadata struct {
+	XMLName       xml.Name            `xml:"pprof-analyzer"`
+	Namespace     string              `xml:"xmlns,attr"`
+	ArtifactID    string              `xml:"artifact-id,attr"` // Random, unique to each generated artifact
+	AnalysisID    string              `xml:"analysis-id,attr,omitempty"`
+	Tool          string              `xml:"tool,attr"`
+	ServerVersion string              `xml:"server-version,attr"`
+	CreatedAt     string              `xml:"created-at,attr"` // RFC 3339, UTC
+	Profile       artifactProfile     `xml:"profile"`
+	Parameters    []artifactParameter `xml:"parameter"`
+}
+
+// artifactProfile identifies the profile an artifact was generated from.
+type artifactProfile struct {
+	URI    string `xml:"uri,attr"` // URL passwords are masked
+	SHA256 string `xml:"sha256,attr,omitempty"`
+}
+
+// artifactParameter is a tool argument that shaped the artifact, e.g. a focus regex.
+type artifactParameter struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// newArtifactID returns a random identifier for a generated artifact.
+func newArtifactID() string {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("Warning: failed to generate an artifact ID: %v", err)
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(raw)
+}
+
+// newArtifactMetadata describes an artifact generated by tool from the profile at profilePath (read from
+// profileURI). The arguments listed in params are recorded when set, in that order.
+func newArtifactMetadata(tool, analysisID, profileURI, profilePath string, args map[string]interface{}, params ...string) artifactMetadata {
+	meta := artifactMetadata{
+		Namespace:     artifactMetadataNamespace,
+		ArtifactID:    newArtifactID(),
+		AnalysisID:    analysisID,
+		Tool:          tool,
+		ServerVersion: serverVersion,
+		CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+		Profile:       artifactProfile{URI: profileURI},
+	}
+	if u, err := url.Parse(profileURI); err == nil && u.User != nil {
+		meta.Profile.URI = u.Redacted()
+	}
+	if data, err := os.ReadFile(profilePath); err == nil {
+		sum := sha256.Sum256(data)
+		meta.Profile.SHA256 = hex.EncodeToString(sum[:])
+	}
+	for _, name := range params {
+		switch value := args[name].(type) {
+		case string:
+			if value != "" {
+				meta.Parameters = append(meta.Parameters, artifactParameter{Name: name, Value: value})
+			}
+		case bool, float64:
+			meta.Parameters = append(meta.Parameters, artifactParameter{Name: name, Value: fmt.Sprint(value)})
+		}
+	}
+	return meta
+}
+
+// embedSVGMetadata returns svg with meta inserted as a <metadata> element, the first child of the root
+// <svg> element.
+func embedSVGMetadata(svg []byte, meta artifactMetadata) ([]byte, error) {
+	start := bytes.Index(svg, []byte("<svg"))
+	if start < 0 {
+		return nil, fmt.Errorf("no <svg> element found")
+	}
+	end := bytes.IndexByte(svg[start:], '>')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated <svg> element")
+	}
+	end += start + 1
+	data, err := xml.MarshalIndent(meta, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal artifact metadata: %w", err)
+	}
+	var b bytes.Buffer
+	b.Grow(len(svg) + len(data) + 64)
+	b.Write(svg[:end])
+	b.WriteString("\n<metadata id=\"pprof-analyzer-metadata\">\n")
+	b.Write(data)
+	b.WriteString("\n</metadata>")
+	b.Write(svg[end:])
+	return b.Bytes(), nil
+}
+
+// embedSVGMetadataFile embeds meta into the SVG file at path (see embedSVGMetadata).
+func embedSVGMetadataFile(path string, meta artifactMetadata) error {
+	svg, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	svg, err = embedSVGMetadata(svg, meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, svg, 0o600)
+}
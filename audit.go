@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// auditLogEnv enables the audit log: every tool call (who, when, which tool and profiles, and its outcome) is
+// appended to this file as one JSON line. Unlike the record file (recordFileEnv), it holds no arguments or
+// outputs beyond the profile URIs, so it can be kept and shipped to a log pipeline for shared deployments.
+const auditLogEnv = "PPROF_ANALYZER_AUDIT_LOG"
+
+// maxAuditErrorLength is the number of bytes of an error message kept in an audit entry.
+const maxAuditErrorLength = 512
+
+// auditClient identifies the MCP client of a session, as sent in its initialize request.
+type auditClient struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time       time.Time    `json:"time"`
+	Session    string       `json:"session"`          // MCP session ID ("stdio" for the stdio transport)
+	Client     *auditClient `json:"client,omitempty"` // Unknown when the call precedes initialization
+	User       string       `json:"user"`             // OS user running the server
+	Host       string       `json:"host"`
+	ServerPID  int          `json:"serverPid"`
+	Tool       string       `json:"tool"`
+	AnalysisID string       `json:"analysisId,omitempty"`
+	URIs       []string     `json:"uris,omitempty"`
+	Status     string       `json:"status"`              // "ok" or "error"
+	ErrorCode  string       `json:"errorCode,omitempty"` // Code of structured tool errors, e.g. "confirmation_required"
+	Error      string       `json:"error,omitempty"`
+	DurationMs int64        `json:"durationMs"`
+}
+
+// auditLog is the open audit log file; nil when auditing is disabled.
+var auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	user string
+	host string
+}
+
+// auditClients maps session IDs to the client that initialized them.
+var auditClients sync.Map
+
+// initAuditLog opens the audit log configured by auditLogEnv. main calls it at startup and exits on error:
+// a shared instance must not serve calls it cannot account for.
+func initAuditLog() error {
+	path := strings.TrimSpace(os.Getenv(auditLogEnv))
+	if path == "" {
+		return nil
+	}
+	// 只追加写入：既有的记录不会被截断或改写
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", auditLogEnv, err)
+	}
+	auditLog.file = file
+	auditLog.user = "unknown"
+	if u, err := user.Current(); err == nil {
+		auditLog.user = u.Username
+	}
+	auditLog.host, _ = os.Hostname()
+	log.Printf("Auditing tool calls to %s", path)
+	return nil
+}
+
+// auditHooks remembers the client of every session, for the 'client' field of its audit entries.
+func auditHooks() *server.Hooks {
+	hooks := &server.Hooks{}
+	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
+		auditClients.Store(auditSessionID(ctx), &auditClient{
+			Name:    message.Params.ClientInfo.Name,
+			Version: message.Params.ClientInfo.Version,
+		})
+	})
+	return hooks
+}
+
+// auditSessionID returns the ID of the MCP session of a request.
+func auditSessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return "unknown"
+}
+
+// auditURIs lists the profile URIs of a call ('*_uri' and '*_uris' arguments) and the live endpoints it
+// contacts ('targets' of capture_fleet, 'base_url' of capture_profile and snapshot schedules), with passwords
+// removed from URLs; the returned replacer removes them from other text, such as error messages quoting the
+// URI. Inline profiles are listed by their argument name, as they have no URI.
+func auditURIs(args map[string]interface{}) ([]string, *strings.Replacer) {
+	names := make([]string, 0)
+	for name := range args {
+		if strings.HasSuffix(name, "_uri") || strings.HasSuffix(name, "_uris") || name == "targets" || name == "base_url" ||
+			name == inlineProfileArg || name == profileCommandArg {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	uris := make([]string, 0, len(names))
+	redactions := make([]string, 0)
+	for _, name := range names {
+		value, ok := args[name].(string)
+		if !ok || value == "" {
+			continue
+		}
+		if name == inlineProfileArg {
+			uris = append(uris, "inline:"+inlineProfileArg)
+			continue
+		}
+		if name == profileCommandArg {
+			uris = append(uris, "command:"+value)
+			continue
+		}
+		for _, uriStr := range parseFleetTargets(value) {
+			if u, err := url.Parse(uriStr); err == nil && u.User != nil {
+				if password, ok := u.User.Password(); ok {
+					redactions = append(redactions, uriStr, u.Redacted(), ":"+password+"@", ":xxxxx@")
+				}
+				uriStr = u.Redacted()
+			}
+			uris = append(uris, uriStr)
+		}
+	}
+	return uris, strings.NewReplacer(redactions...)
+}
+
+// auditOutcome returns the status, structured error code and error message of a call.
+func auditOutcome(result *mcp.CallToolResult, err error) (status, code, message string) {
+	switch {
+	case err != nil:
+		status, message = "error", err.Error()
+	case result != nil && result.IsError:
+		status = "error"
+		for _, c := range result.Content {
+			if text, ok := c.(mcp.TextContent); ok {
+				message = text.Text
+				break
+			}
+		}
+		// 结构化的工具错误 (例如 confirmation_required、profile_too_large) 记录其错误码
+		var structured struct {
+			Code    string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal([]byte(message), &structured) == nil && structured.Code != "" {
+			code, message = structured.Code, structured.Message
+		}
+	default:
+		return "ok", "", ""
+	}
+	if len(message) > maxAuditErrorLength {
+		message = message[:maxAuditErrorLength] + "..."
+	}
+	return status, code, message
+}
+
+// auditedHandler wraps handler to append every call to the audit log, when enabled. It is the outermost
+// wrapper, so calls rejected by validation, confirmation or the memory guard are audited too.
+func auditedHandler(toolName string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if auditLog.file == nil {
+			return handler(ctx, request)
+		}
+		entry := auditEntry{
+			Time:      time.Now().UTC(),
+			Session:   auditSessionID(ctx),
+			User:      auditLog.user,
+			Host:      auditLog.host,
+			ServerPID: os.Getpid(),
+			Tool:      toolName,
+		}
+		// 处理器会就地修改参数，因此先记录
+		var redact *strings.Replacer
+		entry.URIs, redact = auditURIs(request.Params.Arguments)
+		entry.AnalysisID, _ = request.Params.Arguments["analysis_id"].(string)
+		if client, ok := auditClients.Load(entry.Session); ok {
+			entry.Client = client.(*auditClient)
+		}
+
+		result, err := handler(ctx, request)
+		entry.DurationMs = time.Since(entry.Time).Milliseconds()
+		entry.Status, entry.ErrorCode, entry.Error = auditOutcome(result, err)
+		entry.Error = redact.Replace(entry.Error)
+		if appendErr := appendAuditEntry(entry); appendErr != nil {
+			log.Printf("Error: failed to write the audit entry of a %s call: %v", toolName, appendErr)
+		}
+		return result, err
+	}
+}
+
+// appendAuditEntry appends one entry to the audit log as a single write, so concurrent calls (and other
+// server processes sharing the file) never interleave lines.
+func appendAuditEntry(entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	if _, err := auditLog.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return auditLog.file.Sync()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// bundleDirs maps artifact kinds to directories inside an exported bundle.
+var bundleDirs = map[string]string{
+	"input":      "profiles",
+	"profile":    "profiles",
+	"analysis":   "analyses",
+	"flamegraph": "flamegraphs",
+}
+
+// addFileToZip copies the file at path into the archive under name. Encrypted files (see sealFileData) are
+// decrypted: the bundle is meant to be read away from the server.
+func addFileToZip(zw *zip.Writer, name, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := readSealedFile(path)
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	dst, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(data)
+	return err
+}
+
+// addArtifactToZip adds an artifact's file to the archive, or its copy in the workspace store when the file
+// is gone, e.g. after a restart or when the analysis ran on another instance.
+func addArtifactToZip(zw *zip.Writer, name string, artifact AnalysisArtifact) error {
+	err := addFileToZip(zw, name, artifact.Path)
+	if !os.IsNotExist(err) || artifact.StoredKey == "" {
+		return err
+	}
+	ctx, cancel := storageContext()
+	defer cancel()
+	data, storeErr := workspaceStore().Get(ctx, artifact.StoredKey)
+	if storeErr != nil {
+		return fmt.Errorf("%v; stored copy: %v", err, storeErr)
+	}
+	if data, err = openFileData(artifact.StoredKey, data); err != nil {
+		return err
+	}
+	log.Printf("Bundling stored copy %s of missing artifact %s", artifact.StoredKey, artifact.Path)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: artifact.CreatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// handleExportBundle zips the input profiles, generated analyses, flame graphs and the manifest of an
+// analysis into a single archive, ready to be attached to an incident ticket.
+func handleExportBundle(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if analysisID == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: analysis_id (string)")
+	}
+	outputPath, ok := args["output_path"].(string)
+	if !ok || outputPath == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: output_path (string)")
+	}
+	if !strings.HasSuffix(strings.ToLower(outputPath), ".zip") {
+		outputPath += ".zip"
+	}
+	if !filepath.IsAbs(outputPath) {
+		if cwd, err := os.Getwd(); err == nil {
+			outputPath = filepath.Join(cwd, outputPath)
+		}
+	}
+
+	returnInline, _ := args["return_inline"].(bool)
+	overwrite, _ := args["overwrite"].(bool)
+
+	log.Printf("Handling export_bundle: AnalysisID=%s, Output=%s, Inline=%t", analysisID, outputPath, returnInline)
+
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Artifacts) == 0 {
+		return nil, fmt.Errorf("no artifacts recorded for analysis '%s'; pass analysis_id to the analysis tools first", analysisID)
+	}
+
+	if confirmErr := confirmWrite("export_bundle", args, outputPath); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+	if existsErr := checkOutputPath("export_bundle", outputPath, overwrite); existsErr != nil {
+		return existsErr.toolResult(), nil
+	}
+	// The archive is written next to outputPath and renamed into place once complete
+	tempPath, err := tempOutputPath(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.Create(tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("failed to create bundle '%s': %w", outputPath, err)
+	}
+	zw := zip.NewWriter(out)
+
+	// Entries are named <dir>/<file name>; the bundled manifest is rewritten to point at them
+	bundled := AnalysisManifest{AnalysisID: manifest.AnalysisID, CreatedAt: manifest.CreatedAt, Artifacts: []AnalysisArtifact{}}
+	used := make(map[string]bool)
+	skipped := []string{}
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Kind == "bundle" {
+			continue // Earlier exports
+		}
+		dir, ok := bundleDirs[artifact.Kind]
+		if !ok {
+			dir = "other"
+		}
+		name := dir + "/" + filepath.Base(artifact.Path)
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s/%d-%s", dir, i, filepath.Base(artifact.Path))
+		}
+		if err := addArtifactToZip(zw, name, artifact); err != nil {
+			log.Printf("Warning: skipping artifact '%s' of analysis '%s': %v", artifact.Path, analysisID, err)
+			skipped = append(skipped, fmt.Sprintf("%s (%v)", artifact.Path, err))
+			continue
+		}
+		used[name] = true
+		entry := artifact
+		entry.Path = name
+		bundled.Artifacts = append(bundled.Artifacts, entry)
+	}
+
+	manifestBytes, err := json.MarshalIndent(bundled, "", "  ")
+	if err == nil {
+		var w io.Writer
+		if w, err = zw.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: time.Now()}); err == nil {
+			_, err = w.Write(manifestBytes)
+		}
+	}
+	closeErr := zw.Close()
+	if fileCloseErr := out.Close(); closeErr == nil {
+		closeErr = fileCloseErr
+	}
+	if err != nil || closeErr != nil {
+		os.Remove(tempPath)
+		return nil, fmt.Errorf("failed to write bundle '%s': %v %v", outputPath, err, closeErr)
+	}
+	if err := commitOutputFile("export_bundle", tempPath, outputPath, overwrite); err != nil {
+		var existsErr *fileExistsError
+		if errors.As(err, &existsErr) {
+			return existsErr.toolResult(), nil
+		}
+		return nil, err
+	}
+
+	artifact := AnalysisArtifact{Path: outputPath, Kind: "bundle", Source: "export_bundle"}
+	if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	hookReport := runPostProcessHook(ctx, analysisID, artifact)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Exported %d artifact(s) of analysis '%s' to %s\n", len(bundled.Artifacts), analysisID, outputPath))
+	for _, artifact := range bundled.Artifacts {
+		b.WriteString(fmt.Sprintf("  - %s\n", artifact.Path))
+	}
+	b.WriteString("  - manifest.json\n")
+	if len(skipped) > 0 {
+		b.WriteString(fmt.Sprintf("Skipped %d missing or unreadable artifact(s):\n", len(skipped)))
+		for _, s := range skipped {
+			b.WriteString(fmt.Sprintf("  - %s\n", s))
+		}
+	}
+
+	content := []mcp.Content{
+		mcp.TextContent{
+			Type: "text",
+			Text: b.String(),
+		},
+	}
+	if returnInline {
+		content = append(content, inlineArtifact(outputPath, "application/zip"))
+	}
+	return withPostProcessReports(&mcp.CallToolResult{Content: content}, hookReport), nil
+}
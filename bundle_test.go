@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestExportBundle(t *testing.T) {
+	useTestStore(t)
+	enc, err := newEncryptedStore(nil, bytes.Repeat([]byte{5}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := fileEncryption
+	fileEncryption = enc
+	t.Cleanup(func() { fileEncryption = saved })
+
+	dir := t.TempDir()
+	t.Setenv(workspaceEnv, dir)
+	t.Setenv(confirmEnv, "off")
+	analysisID := "bundle-" + newArtifactID()[:8]
+
+	write := func(name, content string, sealed bool) string {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		var err error
+		if sealed {
+			err = writeSealedFile(path, []byte(content))
+		} else {
+			err = os.WriteFile(path, []byte(content), 0o600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	record := func(path, kind string) {
+		if err := recordAnalysisArtifact(analysisID, AnalysisArtifact{Path: path, Kind: kind, Temporary: kind != "input"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record(write("cpu.pprof", "input profile", false), "input")
+	record(write("pprof-result.json", `{"top": "main.hot"}`, true), "analysis") // Encrypted on disk
+	record(write("flame.svg", "<svg/>", false), "flamegraph")
+	record(write("other/flame.svg", "<svg>2</svg>", false), "flamegraph") // Same name as the first
+	gone := write("pprof-gone.md", "# Report", true)
+	record(gone, "analysis") // Its stored copy is bundled once the file is gone
+	os.Remove(gone)
+	record(filepath.Join(dir, "missing.pb.gz"), "profile") // Neither file nor copy
+
+	export := func(args map[string]interface{}) *mcp.CallToolResult {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"analysis_id": analysisID, "output_path": filepath.Join(dir, "incident")}
+		for name, value := range args {
+			request.Params.Arguments[name] = value
+		}
+		result, err := handleExportBundle(context.Background(), request)
+		if err != nil {
+			t.Fatalf("export_bundle failed: %v", err)
+		}
+		return result
+	}
+	result := export(nil)
+	bundlePath := filepath.Join(dir, "incident.zip")
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "Exported 5 artifact(s) of analysis '"+analysisID+"' to "+bundlePath) ||
+		!strings.Contains(text, "Skipped 1 missing or unreadable artifact(s)") || !strings.Contains(text, "missing.pb.gz") {
+		t.Errorf("Unexpected result:\n%s", text)
+	}
+
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	entries := make(map[string]string)
+	names := make([]string, 0)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(data)
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := map[string]string{
+		"profiles/cpu.pprof":         "input profile",
+		"analyses/pprof-result.json": `{"top": "main.hot"}`,
+		"analyses/pprof-gone.md":     "# Report",
+		"flamegraphs/flame.svg":      "<svg/>",
+		"flamegraphs/2-flame.svg":    "<svg>2</svg>",
+	}
+	for name, content := range want {
+		if entries[name] != content {
+			t.Errorf("Expected entry %s with %q, got %q (entries %v)", name, content, entries[name], names)
+		}
+	}
+	if len(entries) != len(want)+1 {
+		t.Errorf("Expected %d entries and the manifest, got %v", len(want), names)
+	}
+
+	// The bundled manifest points at the entries
+	var manifest AnalysisManifest
+	if err := json.Unmarshal([]byte(entries["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("Invalid bundled manifest: %v", err)
+	}
+	paths, wantPaths := make([]string, 0), make([]string, 0)
+	kinds := make(map[string]string)
+	for _, artifact := range manifest.Artifacts {
+		paths = append(paths, artifact.Path)
+		kinds[artifact.Path] = artifact.Kind
+	}
+	for name := range want {
+		wantPaths = append(wantPaths, name)
+	}
+	sort.Strings(paths)
+	sort.Strings(wantPaths)
+	if manifest.AnalysisID != analysisID || !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("Expected the manifest to list the entries %v, got %v", wantPaths, paths)
+	}
+	if kinds["flamegraphs/2-flame.svg"] != "flamegraph" || kinds["profiles/cpu.pprof"] != "input" {
+		t.Errorf("Expected the kinds to be kept, got %v", kinds)
+	}
+
+	// An existing bundle is only replaced with overwrite; the earlier bundle is not bundled again
+	if text := export(nil).Content[0].(mcp.TextContent).Text; !strings.Contains(text, "file_exists") {
+		t.Errorf("Expected a file_exists error, got:\n%s", text)
+	}
+	result = export(map[string]interface{}{"overwrite": true, "return_inline": true})
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "Exported 5 artifact(s)") {
+		t.Errorf("Expected the earlier bundle to be left out, got:\n%s", text)
+	}
+	if len(result.Content) != 2 {
+		t.Errorf("Expected the bundle inline, got %d content items", len(result.Content))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// captureHistoryTargets caps the number of live targets whose previous capture is kept in memory; the
+// target captured least recently is forgotten first.
+const captureHistoryTargets = 64
+
+// captureChangeTopN is the number of functions that grew and that shrank shown in the change section.
+const captureChangeTopN = 3
+
+// previousCapture is the last profile analyze_pprof fetched from a live target.
+type previousCapture struct {
+	prof *profile.Profile // Shared with the profile pool: never modified
+	key  string           // Cache key of the capture (see loadProfileWithKey): equal keys mean equal content
+	time time.Time
+}
+
+var (
+	captureHistoryMutex sync.Mutex
+	captureHistory      = make(map[string]previousCapture)
+)
+
+// captureTarget identifies the live target of an http(s) profile URI by its scheme, host and path: query
+// parameters such as 'seconds' and 'hz' change the capture, not the target. It returns "" for other URIs.
+func captureTarget(uriStr string) string {
+	u, err := url.Parse(uriStr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// swapPreviousCapture keeps capture as the latest of target and returns the one it replaces, if any.
+func swapPreviousCapture(target string, capture previousCapture) (previousCapture, bool) {
+	captureHistoryMutex.Lock()
+	defer captureHistoryMutex.Unlock()
+	previous, ok := captureHistory[target]
+	captureHistory[target] = capture
+	for len(captureHistory) > captureHistoryTargets {
+		oldest := ""
+		for t, c := range captureHistory {
+			if oldest == "" || c.time.Before(captureHistory[oldest].time) {
+				oldest = t
+			}
+		}
+		delete(captureHistory, oldest)
+	}
+	return previous, ok
+}
+
+// captureChangeFormat is the format of the change section for an analyze_pprof output format: machine-readable
+// outputs get JSON, and folded stacks and GraphML, which are read by other tools, get none ("").
+func captureChangeFormat(outputFormat string) string {
+	switch outputFormat {
+	case "text", "markdown", "markdown-compact":
+		return outputFormat
+	case "folded", "graphml":
+		return ""
+	case "callgraph-dot", "centrality", "edges":
+		return "text"
+	default:
+		return "json"
+	}
+}
+
+// changeSinceLastCapture keeps prof as the latest capture of the live target of uriStr and, with compare,
+// returns the "change since last capture" section comparing it with the previous one. It returns "" when
+// uriStr is not a live target, for the first capture, when the content did not change (e.g. a static file
+// served over http) or when the captures cannot be compared; comparison errors are only logged, as the
+// section is a convenience.
+func changeSinceLastCapture(uriStr string, prof *profile.Profile, cacheKey, profileType, sampleType, outputFormat string, compare bool) string {
+	target := captureTarget(uriStr)
+	if target == "" {
+		return ""
+	}
+	now := time.Now()
+	previous, ok := swapPreviousCapture(target, previousCapture{prof: prof, key: cacheKey, time: now})
+	format := captureChangeFormat(outputFormat)
+	if !compare || !ok || previous.key == cacheKey || format == "" {
+		return ""
+	}
+	change, err := analyzer.CompareWithPreviousCapture(previous.prof, prof, profileType, sampleType, previous.time, now, captureChangeTopN)
+	if err != nil {
+		log.Printf("Warning: cannot compare the capture of '%s' with the previous one: %v", target, err)
+		return ""
+	}
+	section, err := analyzer.FormatCaptureChange(change, format)
+	if err != nil {
+		log.Printf("Warning: cannot format the change since the last capture of '%s': %v", target, err)
+		return ""
+	}
+	return section
+}
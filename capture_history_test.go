@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCaptureTarget(t *testing.T) {
+	cases := []struct{ uri, want string }{
+		{"http://host:6060/debug/pprof/profile?seconds=10&hz=200", "http://host:6060/debug/pprof/profile"},
+		{"https://host/debug/pprof/heap", "https://host/debug/pprof/heap"},
+		{"file:///tmp/cpu.pprof", ""},
+		{"cpu.pprof", ""},
+		{"cache/cpu.pprof|http://host/debug/pprof/profile", ""},
+	}
+	for _, tc := range cases {
+		if got := captureTarget(tc.uri); got != tc.want {
+			t.Errorf("captureTarget(%q): expected %q, got %q", tc.uri, tc.want, got)
+		}
+	}
+}
+
+func TestAnalyzeChangeSinceLastCapture(t *testing.T) {
+	captures := [][]int64{{100}, {100}, {100, 200}, {50}}
+	served := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := poolTestProfile("main.hot", captures[min(served, len(captures)-1)]...).Write(w); err != nil {
+			t.Error(err)
+		}
+		served++
+	}))
+	defer target.Close()
+
+	analyze := func(args map[string]interface{}) []mcp.Content {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]interface{}{"profile_uri": target.URL + "/debug/pprof/profile", "profile_type": "cpu", "output_format": "text"}
+		for name, value := range args {
+			request.Params.Arguments[name] = value
+		}
+		result, err := handleAnalyzePprof(context.Background(), request)
+		if err != nil {
+			t.Fatalf("handleAnalyzePprof failed: %v", err)
+		}
+		return result.Content
+	}
+	hasChange := func(content []mcp.Content) string {
+		for _, c := range content {
+			if text := c.(mcp.TextContent).Text; strings.HasPrefix(text, "Change since last capture") {
+				return text
+			}
+		}
+		return ""
+	}
+
+	if section := hasChange(analyze(nil)); section != "" {
+		t.Errorf("Expected no change section for the first capture, got:\n%s", section)
+	}
+	if section := hasChange(analyze(nil)); section != "" {
+		t.Errorf("Expected no change section for an unchanged capture, got:\n%s", section)
+	}
+	section := hasChange(analyze(nil))
+	for _, want := range []string{"Change since last capture (0 seconds ago)", "Total cpu: 100ns → 300ns (+200ns, +200.00%)", "main.hot"} {
+		if !strings.Contains(section, want) {
+			t.Errorf("Expected %q in the change section:\n%s", want, section)
+		}
+	}
+	if section := hasChange(analyze(map[string]interface{}{"compare_previous_capture": false, "seconds": 1.0})); section != "" {
+		t.Errorf("Expected no change section with compare_previous_capture false, got:\n%s", section)
+	}
+}
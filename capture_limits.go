@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxCaptureSecondsEnv caps the duration of CPU profiles captured from targets (default 300).
+const maxCaptureSecondsEnv = "PPROF_ANALYZER_MAX_CAPTURE_SECONDS"
+
+// maxCaptureHzEnv caps the CPU profiling rate requested from targets (default 1000).
+const maxCaptureHzEnv = "PPROF_ANALYZER_MAX_CAPTURE_HZ"
+
+const (
+	defaultCaptureSeconds    = 10
+	defaultMaxCaptureSeconds = 300
+	defaultMaxCaptureHz      = 1000
+	// defaultCPUProfileHz is the rate of runtime/pprof, used by targets that ignore the 'hz' parameter.
+	defaultCPUProfileHz = 100
+)
+
+// captureLimit reads a positive limit from an environment variable, falling back to def.
+func captureLimit(env string, def int) int {
+	if value := os.Getenv(env); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err == nil && limit > 0 {
+			return limit
+		}
+		log.Printf("Warning: ignoring invalid %s=%q", env, value)
+	}
+	return def
+}
+
+// maxCaptureSeconds is the longest CPU profile a capture tool may request.
+func maxCaptureSeconds() int {
+	return captureLimit(maxCaptureSecondsEnv, defaultMaxCaptureSeconds)
+}
+
+// maxCaptureHz is the highest CPU profiling rate a capture tool may request.
+func maxCaptureHz() int {
+	return captureLimit(maxCaptureHzEnv, defaultMaxCaptureHz)
+}
+
+// withCaptureSeconds declares the 'seconds' argument of a tool capturing CPU profiles from targets.
+func withCaptureSeconds() mcp.ToolOption {
+	maxSeconds := maxCaptureSeconds()
+	return mcp.WithNumber("seconds",
+		mcp.Description(fmt.Sprintf("The duration of each CPU profile, in seconds (1-%d, limited by %s): e.g. 5 for a short targeted capture or 120 to catch intermittent load. Some targets reject long durations (e.g. above their HTTP server's WriteTimeout); the error they return is reported.", maxSeconds, maxCaptureSecondsEnv)),
+		mcp.DefaultNumber(float64(defaultCaptureSeconds)),
+		mcp.Min(1),
+		mcp.Max(float64(maxSeconds)),
+	)
+}
+
+// withCaptureHz declares the 'hz' argument of a tool capturing CPU profiles from targets.
+func withCaptureHz() mcp.ToolOption {
+	maxHz := maxCaptureHz()
+	return mcp.WithNumber("hz",
+		mcp.Description(fmt.Sprintf("The CPU sampling rate to request, in samples per second (1-%d, limited by %s), sent as the 'hz' query parameter. Only targets whose profile endpoint supports it apply it; the standard net/http/pprof handler always samples at %d Hz, which is reported when the captured profile shows a different rate. Omit it to use the target's default.", maxHz, maxCaptureHzEnv, defaultCPUProfileHz)),
+		mcp.Min(1),
+		mcp.Max(float64(maxHz)),
+	)
+}
+
+// withLiveCaptureSeconds declares the 'seconds' argument of a tool loading one profile, which may be captured
+// live from a target's profile endpoint.
+func withLiveCaptureSeconds() mcp.ToolOption {
+	maxSeconds := maxCaptureSeconds()
+	return mcp.WithNumber("seconds",
+		mcp.Description(fmt.Sprintf("For an http(s) 'profile_uri' of a live profile endpoint (e.g. '/debug/pprof/profile'): the capture duration in seconds (1-%d, limited by %s), sent as the 'seconds' query parameter, e.g. 5 for a short targeted capture or 120 to catch intermittent load. Omit it to keep the URI's own parameter or the target's default.", maxSeconds, maxCaptureSecondsEnv)),
+		mcp.Min(1),
+		mcp.Max(float64(maxSeconds)),
+	)
+}
+
+// liveCaptureURI applies the optional 'seconds' and 'hz' arguments to an http(s) profile URI as query
+// parameters. It returns the URI unchanged when neither is given, and the requested duration and rate (0 when
+// not given) for captureWarning.
+func liveCaptureURI(uriStr string, args map[string]interface{}) (string, int, int, error) {
+	_, hasSeconds := args["seconds"].(float64)
+	_, hasHz := args["hz"].(float64)
+	if !hasSeconds && !hasHz {
+		return uriStr, 0, 0, nil
+	}
+	u, err := url.Parse(uriStr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", 0, 0, fmt.Errorf("'seconds' and 'hz' only apply to an http(s) profile_uri of a live target, got '%s'", uriStr)
+	}
+	seconds, hz, err := captureRateFromArgs(args)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	query := u.Query()
+	if hasSeconds {
+		query.Set("seconds", strconv.Itoa(seconds))
+	} else {
+		seconds = 0
+	}
+	if hz > 0 {
+		query.Set("hz", strconv.Itoa(hz))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), seconds, hz, nil
+}
+
+// captureRateFromArgs returns the validated 'seconds' and 'hz' arguments; hz is 0 when not given.
+func captureRateFromArgs(args map[string]interface{}) (int, int, error) {
+	secondsFloat, ok := args["seconds"].(float64)
+	if !ok {
+		secondsFloat = defaultCaptureSeconds
+	}
+	seconds := int(secondsFloat)
+	if maxSeconds := maxCaptureSeconds(); seconds <= 0 || seconds > maxSeconds {
+		return 0, 0, fmt.Errorf("invalid seconds %d: must be between 1 and %d (%s)", seconds, maxSeconds, maxCaptureSecondsEnv)
+	}
+	hzFloat, _ := args["hz"].(float64)
+	hz := int(hzFloat)
+	if maxHz := maxCaptureHz(); hz < 0 || hz > maxHz {
+		return 0, 0, fmt.Errorf("invalid hz %d: must be between 1 and %d (%s)", hz, maxHz, maxCaptureHzEnv)
+	}
+	return seconds, hz, nil
+}
+
+// captureWarning tells when a captured CPU profile does not match the request: a much shorter duration (the
+// target cut the capture short) or a different sampling rate (the target ignored 'hz'). It returns "" otherwise.
+func captureWarning(p *profile.Profile, seconds, hz int) string {
+	requested := time.Duration(seconds) * time.Second
+	if p.DurationNanos > 0 && time.Duration(p.DurationNanos) < requested*9/10 {
+		return fmt.Sprintf("captured %s instead of %s", time.Duration(p.DurationNanos).Round(time.Millisecond), requested)
+	}
+	if hz > 0 && p.Period > 0 && p.PeriodType != nil && p.PeriodType.Unit == "nanoseconds" {
+		if actual := int(time.Second / time.Duration(p.Period)); actual != hz {
+			return fmt.Sprintf("sampled at %d Hz instead of %d Hz (the target does not support 'hz')", actual, hz)
+		}
+	}
+	return ""
+}
+
+const (
+	defaultIdleRetries = 1
+	maxIdleRetries     = 3
+	// idleRetryFactor sets the default retry duration, relative to 'seconds'.
+	idleRetryFactor = 3
+)
+
+// withIdleRetries declares the 'idle_retries' argument of a tool capturing CPU profiles from targets.
+func withIdleRetries() mcp.ToolOption {
+	return mcp.WithNumber("idle_retries",
+		mcp.Description(fmt.Sprintf("How many times to capture again, for 'idle_retry_seconds', when a CPU profile comes back empty (no samples: the service was idle). 0 disables the retry. At most %d.", maxIdleRetries)),
+		mcp.DefaultNumber(float64(defaultIdleRetries)),
+		mcp.Min(0),
+		mcp.Max(maxIdleRetries),
+	)
+}
+
+// withIdleRetrySeconds declares the 'idle_retry_seconds' argument of a tool capturing CPU profiles from targets.
+func withIdleRetrySeconds() mcp.ToolOption {
+	maxSeconds := maxCaptureSeconds()
+	return mcp.WithNumber("idle_retry_seconds",
+		mcp.Description(fmt.Sprintf("The duration of the retries of empty CPU profiles, in seconds (1-%d, limited by %s). Defaults to %d times 'seconds', capped at the limit.", maxSeconds, maxCaptureSecondsEnv, idleRetryFactor)),
+		mcp.Min(1),
+		mcp.Max(float64(maxSeconds)),
+	)
+}
+
+// idleRetryFromArgs returns the validated 'idle_retries' and 'idle_retry_seconds' arguments, the latter
+// defaulting to idleRetryFactor times seconds.
+func idleRetryFromArgs(args map[string]interface{}, seconds int) (int, int, error) {
+	retriesFloat, ok := args["idle_retries"].(float64)
+	if !ok {
+		retriesFloat = defaultIdleRetries
+	}
+	retries := int(retriesFloat)
+	if retries < 0 || retries > maxIdleRetries {
+		return 0, 0, fmt.Errorf("invalid idle_retries %d: must be between 0 and %d", retries, maxIdleRetries)
+	}
+	maxSeconds := maxCaptureSeconds()
+	retrySeconds := seconds * idleRetryFactor
+	if retrySeconds > maxSeconds {
+		retrySeconds = maxSeconds
+	}
+	if retrySecondsFloat, ok := args["idle_retry_seconds"].(float64); ok {
+		retrySeconds = int(retrySecondsFloat)
+		if retrySeconds <= 0 || retrySeconds > maxSeconds {
+			return 0, 0, fmt.Errorf("invalid idle_retry_seconds %d: must be between 1 and %d (%s)", retrySeconds, maxSeconds, maxCaptureSecondsEnv)
+		}
+	}
+	return retries, retrySeconds, nil
+}
+
+// isIdleCPUProfile reports whether a CPU profile holds no CPU time: the target was idle during the capture.
+func isIdleCPUProfile(p *profile.Profile) bool {
+	for _, s := range p.Sample {
+		for _, v := range s.Value {
+			if v != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
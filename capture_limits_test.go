@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCaptureLimitEnv(t *testing.T) {
+	t.Setenv(maxCaptureSecondsEnv, "")
+	t.Setenv(maxCaptureHzEnv, "")
+	if maxCaptureSeconds() != defaultMaxCaptureSeconds || maxCaptureHz() != defaultMaxCaptureHz {
+		t.Errorf("Expected the default limits, got %d s and %d Hz", maxCaptureSeconds(), maxCaptureHz())
+	}
+	t.Setenv(maxCaptureSecondsEnv, "60")
+	t.Setenv(maxCaptureHzEnv, "500")
+	if maxCaptureSeconds() != 60 || maxCaptureHz() != 500 {
+		t.Errorf("Expected the configured limits, got %d s and %d Hz", maxCaptureSeconds(), maxCaptureHz())
+	}
+	// Invalid limits fall back to the defaults instead of disabling the cap
+	for _, value := range []string{"0", "-5", "abc", "1.5"} {
+		t.Setenv(maxCaptureSecondsEnv, value)
+		if got := maxCaptureSeconds(); got != defaultMaxCaptureSeconds {
+			t.Errorf("%s=%q: expected the default limit, got %d", maxCaptureSecondsEnv, value, got)
+		}
+	}
+
+	// The tool schemas advertise the configured limits
+	t.Setenv(maxCaptureSecondsEnv, "60")
+	tool := mcp.NewTool("capture", withCaptureSeconds(), withCaptureHz(), withIdleRetrySeconds())
+	for name, want := range map[string]float64{"seconds": 60, "hz": 500, "idle_retry_seconds": 60} {
+		if max := tool.InputSchema.Properties[name].(map[string]interface{})["maximum"]; max != want {
+			t.Errorf("Expected the maximum of %s to be %v, got %v", name, want, max)
+		}
+	}
+}
+
+func TestCaptureRateFromArgs(t *testing.T) {
+	t.Setenv(maxCaptureSecondsEnv, "60")
+	t.Setenv(maxCaptureHzEnv, "500")
+	tests := []struct {
+		args        map[string]interface{}
+		seconds, hz int
+		wantErr     string
+	}{
+		{map[string]interface{}{}, defaultCaptureSeconds, 0, ""},
+		{map[string]interface{}{"seconds": 5.0, "hz": 200.0}, 5, 200, ""},
+		{map[string]interface{}{"seconds": 60.0, "hz": 500.0}, 60, 500, ""},
+		{map[string]interface{}{"seconds": 12.9}, 12, 0, ""},
+		{map[string]interface{}{"seconds": 61.0}, 0, 0, "invalid seconds 61: must be between 1 and 60 (" + maxCaptureSecondsEnv + ")"},
+		{map[string]interface{}{"seconds": 0.0}, 0, 0, "invalid seconds 0"},
+		{map[string]interface{}{"seconds": 5.0, "hz": 501.0}, 0, 0, "invalid hz 501: must be between 1 and 500 (" + maxCaptureHzEnv + ")"},
+		{map[string]interface{}{"seconds": 5.0, "hz": -1.0}, 0, 0, "invalid hz -1"},
+	}
+	for _, tc := range tests {
+		seconds, hz, err := captureRateFromArgs(tc.args)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%v: expected an error containing %q, got %v", tc.args, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || seconds != tc.seconds || hz != tc.hz {
+			t.Errorf("%v: expected %d s at %d Hz, got %d s at %d Hz (%v)", tc.args, tc.seconds, tc.hz, seconds, hz, err)
+		}
+	}
+}
+
+func TestLiveCaptureURI(t *testing.T) {
+	t.Setenv(maxCaptureSecondsEnv, "60")
+	uri := "http://localhost:6060/debug/pprof/profile?seconds=30"
+	if got, seconds, hz, err := liveCaptureURI(uri, map[string]interface{}{}); err != nil || got != uri || seconds != 0 || hz != 0 {
+		t.Errorf("Expected the URI unchanged without seconds and hz, got %q, %d, %d, %v", got, seconds, hz, err)
+	}
+	got, seconds, hz, err := liveCaptureURI(uri, map[string]interface{}{"seconds": 5.0, "hz": 250.0})
+	if err != nil || got != "http://localhost:6060/debug/pprof/profile?hz=250&seconds=5" || seconds != 5 || hz != 250 {
+		t.Errorf("Expected the arguments to replace the query, got %q, %d, %d, %v", got, seconds, hz, err)
+	}
+	// Only 'hz': the target's duration is kept
+	if got, seconds, _, err := liveCaptureURI(uri, map[string]interface{}{"hz": 250.0}); err != nil || got != "http://localhost:6060/debug/pprof/profile?hz=250&seconds=30" || seconds != 0 {
+		t.Errorf("Expected only hz to be set, got %q, %d, %v", got, seconds, err)
+	}
+	if _, _, _, err := liveCaptureURI(uri, map[string]interface{}{"seconds": 120.0}); err == nil || !strings.Contains(err.Error(), "between 1 and 60") {
+		t.Errorf("Expected the duration cap to be enforced, got %v", err)
+	}
+	if _, _, _, err := liveCaptureURI("/tmp/cpu.pb.gz", map[string]interface{}{"seconds": 5.0}); err == nil || !strings.Contains(err.Error(), "only apply to an http(s) profile_uri") {
+		t.Errorf("Expected an error for a local file, got %v", err)
+	}
+}
+
+func TestIdleRetryFromArgs(t *testing.T) {
+	t.Setenv(maxCaptureSecondsEnv, "60")
+	tests := []struct {
+		args                  map[string]interface{}
+		seconds               int
+		retries, retrySeconds int
+		wantErr               string
+	}{
+		{map[string]interface{}{}, 10, defaultIdleRetries, 10 * idleRetryFactor, ""},
+		// The default retry duration is clamped to the cap
+		{map[string]interface{}{}, 30, defaultIdleRetries, 60, ""},
+		{map[string]interface{}{"idle_retries": 0.0, "idle_retry_seconds": 45.0}, 10, 0, 45, ""},
+		{map[string]interface{}{"idle_retries": float64(maxIdleRetries)}, 10, maxIdleRetries, 30, ""},
+		{map[string]interface{}{"idle_retries": float64(maxIdleRetries + 1)}, 10, 0, 0, "invalid idle_retries 4: must be between 0 and 3"},
+		{map[string]interface{}{"idle_retry_seconds": 61.0}, 10, 0, 0, "invalid idle_retry_seconds 61: must be between 1 and 60"},
+	}
+	for _, tc := range tests {
+		retries, retrySeconds, err := idleRetryFromArgs(tc.args, tc.seconds)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%v: expected an error containing %q, got %v", tc.args, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || retries != tc.retries || retrySeconds != tc.retrySeconds {
+			t.Errorf("%v with %d s: expected %d retries of %d s, got %d of %d s (%v)", tc.args, tc.seconds, tc.retries, tc.retrySeconds, retries, retrySeconds, err)
+		}
+	}
+}
+
+func TestCaptureWarning(t *testing.T) {
+	p := poolTestProfile("main.hot", 100)
+	p.DurationNanos = int64(10 * time.Second)
+	p.PeriodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+	p.Period = int64(time.Second / defaultCPUProfileHz)
+	if warning := captureWarning(p, 10, defaultCPUProfileHz); warning != "" {
+		t.Errorf("Expected no warning for a matching capture, got %q", warning)
+	}
+	if warning := captureWarning(p, 30, 0); warning != "captured 10s instead of 30s" {
+		t.Errorf("Expected a short capture warning, got %q", warning)
+	}
+	if warning := captureWarning(p, 10, 500); !strings.Contains(warning, "sampled at 100 Hz instead of 500 Hz") {
+		t.Errorf("Expected a sampling rate warning, got %q", warning)
+	}
+}
+
+func TestCaptureLimitsEnforced(t *testing.T) {
+	var requests atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		poolTestProfile("main.hot", 100).Write(w)
+	}))
+	defer target.Close()
+	t.Setenv(workspaceEnv, t.TempDir())
+	t.Setenv(maxCaptureSecondsEnv, "60")
+	t.Setenv(maxCaptureHzEnv, "500")
+
+	handlers := map[string]struct {
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]interface{}
+	}{
+		"capture_profile": {handleCaptureProfile, map[string]interface{}{"base_url": target.URL, "profile_type": "cpu"}},
+		"analyze_pprof":   {handleAnalyzePprof, map[string]interface{}{"profile_uri": target.URL + "/debug/pprof/profile", "profile_type": "cpu"}},
+		"capture_fleet":   {handleCaptureFleet, map[string]interface{}{"targets": target.URL}},
+	}
+	for name, h := range handlers {
+		for _, limit := range []map[string]interface{}{{"seconds": 61.0}, {"seconds": 5.0, "hz": 1000.0}} {
+			var request mcp.CallToolRequest
+			request.Params.Arguments = map[string]interface{}{}
+			for arg, value := range h.args {
+				request.Params.Arguments[arg] = value
+			}
+			for arg, value := range limit {
+				request.Params.Arguments[arg] = value
+			}
+			if _, err := h.handler(context.Background(), request); err == nil || !strings.Contains(err.Error(), "must be between 1 and") {
+				t.Errorf("%s %v: expected the limit to be enforced, got %v", name, limit, err)
+			}
+		}
+	}
+	// Requests over the limits never reach the target
+	if n := requests.Load(); n != 0 {
+		t.Errorf("Expected no request to the target, got %d", n)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// captureEndpoints maps the profile types of capture_profile to their net/http/pprof endpoint.
+var captureEndpoints = map[string]string{
+	"cpu":          "profile",
+	"heap":         "heap",
+	"allocs":       "allocs",
+	"goroutine":    "goroutine",
+	"mutex":        "mutex",
+	"block":        "block",
+	"threadcreate": "threadcreate",
+	"trace":        "trace",
+}
+
+// captureProfileURL returns the URL capturing profileType from a net/http/pprof base URL. A bare host gets the
+// standard '/debug/pprof' prefix; any other path is taken as the prefix the handlers are mounted at. The
+// 'seconds' query parameter is set when seconds is not 0, 'hz' when hz is not 0.
+func captureProfileURL(baseURL, profileType string, seconds, hz int) (string, error) {
+	endpoint, ok := captureEndpoints[profileType]
+	if !ok {
+		return "", fmt.Errorf("unsupported profile_type: '%s'", profileType)
+	}
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base_url '%s': %w", baseURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid base_url '%s': only http and https targets can be captured", baseURL)
+	}
+	prefix := strings.TrimSuffix(u.Path, "/")
+	if prefix == "" {
+		prefix = "/debug/pprof"
+	}
+	u.Path = prefix + "/" + endpoint
+	query := u.Query()
+	if seconds > 0 {
+		query.Set("seconds", strconv.Itoa(seconds))
+	}
+	if hz > 0 {
+		query.Set("hz", strconv.Itoa(hz))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// captureFileName names a captured profile after its type, its target and the capture time, e.g.
+// "cpu-localhost_6060-20240102T150405Z.pb.gz". Traces get the ".trace" extension.
+func captureFileName(profileType, target string, at time.Time) string {
+	host := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, target)
+	ext := ".pb.gz"
+	if profileType == "trace" {
+		ext = ".trace"
+	}
+	return fmt.Sprintf("%s-%s-%s%s", profileType, host, at.UTC().Format("20060102T150405Z"), ext)
+}
+
+// captureToFile downloads a capture of profileType from captureURL and saves it in outputDir, named by
+// captureFileName. Responses that are not a profile (or a trace) are rejected without saving anything. It
+// returns the saved path, its content and, except for traces, the parsed profile.
+func captureToFile(ctx context.Context, captureURL, profileType, outputDir string) (string, []byte, *profile.Profile, error) {
+	// 下载的临时文件不记录到分析中：记录的是保存到工作区的文件
+	downloadPath, cleanup, err := getSingleProfileAsFile(ctx, captureURL, "")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer cleanup()
+	data, err := os.ReadFile(downloadPath)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read the capture from '%s': %w", captureURL, err)
+	}
+	var prof *profile.Profile
+	if profileType == "trace" {
+		if !analyzer.IsGoTrace(data) {
+			return "", nil, nil, fmt.Errorf("'%s' did not return a runtime/trace file", captureURL)
+		}
+	} else if prof, err = profile.ParseData(data); err != nil {
+		return "", nil, nil, fmt.Errorf("'%s' did not return a profile: %w", captureURL, err)
+	}
+
+	u, err := url.Parse(captureURL)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid capture URL '%s': %w", captureURL, err)
+	}
+	outputPath := filepath.Join(outputDir, captureFileName(profileType, u.Host, time.Now()))
+	tempPath, err := tempOutputPath(outputPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if err := writeSealedFile(tempPath, data); err != nil {
+		os.Remove(tempPath)
+		return "", nil, nil, fmt.Errorf("failed to write the capture to '%s': %w", tempPath, err)
+	}
+	if err := commitOutputFile("capture_profile", tempPath, outputPath, false); err != nil {
+		return "", nil, nil, err
+	}
+	return outputPath, data, prof, nil
+}
+
+// handleCaptureProfile captures one profile (or execution trace) from a live net/http/pprof endpoint, saves it in
+// a workspace directory so it can be passed to the other tools, and optionally analyzes it right away.
+func handleCaptureProfile(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	baseURL, ok := args["base_url"].(string)
+	if !ok || baseURL == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: base_url (string)")
+	}
+	profileType, ok := args["profile_type"].(string)
+	if !ok || profileType == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_type (string)")
+	}
+	seconds, hz, err := captureRateFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	// CPU profiles and traces are always captured for a duration; for the other types, 'seconds' requests a
+	// delta profile over that window instead of the snapshot since the process started
+	if _, hasSeconds := args["seconds"].(float64); !hasSeconds && profileType != "cpu" && profileType != "trace" {
+		seconds = 0
+	}
+	if hz > 0 && profileType != "cpu" {
+		return nil, fmt.Errorf("'hz' only applies to cpu profiles")
+	}
+	outputDir, _ := args["output_dir"].(string)
+	if outputDir == "" {
+		outputDir = workspaceDirs()[0]
+	} else if !filepath.IsAbs(outputDir) {
+		if cwd, err := os.Getwd(); err == nil {
+			outputDir = filepath.Join(cwd, outputDir)
+		}
+	}
+	analyze, _ := args["analyze"].(bool)
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 10.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 10
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	captureURL, err := captureProfileURL(baseURL, profileType, seconds, hz)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Handling capture_profile: URL=%s, Type=%s, Seconds=%d, Hz=%d, OutputDir=%s, Analyze=%t, TopN=%d, Format=%s",
+		captureURL, profileType, seconds, hz, outputDir, analyze, topN, outputFormat)
+	// 文件名包含采集时间，因此按目录确认，使确认后的重试不会再次要求确认
+	if confirmErr := confirmWrite("capture_profile", args, outputDir); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+
+	outputPath, data, prof, err := captureToFile(ctx, captureURL, profileType, outputDir)
+	if err != nil {
+		var existsErr *fileExistsError
+		if errors.As(err, &existsErr) {
+			return existsErr.toolResult(), nil
+		}
+		return nil, err
+	}
+	kind := "profile"
+	if profileType == "trace" {
+		kind = "trace"
+	}
+	artifact := AnalysisArtifact{Path: outputPath, Kind: kind, Source: captureURL}
+	if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	hookReports := []*postProcessReport{runPostProcessHook(ctx, analysisID, artifact)}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Captured %s from %s\n", profileType, captureURL))
+	b.WriteString(fmt.Sprintf("Saved to: %s (%s)\n", outputPath, analyzer.FormatBytes(int64(len(data)))))
+	if prof != nil {
+		for _, st := range analyzer.SummarizeSampleTypes(prof) {
+			b.WriteString(fmt.Sprintf("  %s: %s\n", st.Type, st.TotalFormatted))
+		}
+		// 目标缩短了采集或忽略了 'hz' 时说明原因
+		if profileType == "cpu" {
+			if warning := captureWarning(prof, seconds, hz); warning != "" {
+				b.WriteString("Note: the target " + warning + ".\n")
+			}
+		}
+		b.WriteString("Pass it as 'profile_uri' to any other tool.\n")
+	} else {
+		b.WriteString("Pass it as 'trace_uri' to analyze_trace.\n")
+	}
+	result := &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}
+
+	// 分析结果作为单独的内容返回，json 输出保持可解析
+	if analyze && prof != nil {
+		analysisResult, err := analyzer.Analyze(prof, profileType, analyzer.WithTopN(topN), analyzer.WithFormat(outputFormat), analyzer.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		hookReports = append(hookReports, saveAnalysisResult(ctx, analysisID, "capture_profile-"+profileType, outputFormat, analysisResult))
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: analysisResult})
+	} else if analyze {
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: "Traces are not analyzed by capture_profile; pass the saved file to analyze_trace."})
+	}
+	return withPostProcessReports(result, hookReports...), nil
+}
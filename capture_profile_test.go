@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCaptureProfileURL(t *testing.T) {
+	cases := []struct {
+		base, profileType string
+		seconds, hz       int
+		want              string
+	}{
+		{"localhost:6060", "cpu", 30, 0, "http://localhost:6060/debug/pprof/profile?seconds=30"},
+		{"http://host:6060/debug/pprof/", "heap", 0, 0, "http://host:6060/debug/pprof/heap"},
+		{"https://host/admin/pprof", "mutex", 60, 0, "https://host/admin/pprof/mutex?seconds=60"},
+		{"http://host:6060/debug/pprof", "cpu", 5, 200, "http://host:6060/debug/pprof/profile?hz=200&seconds=5"},
+	}
+	for _, tc := range cases {
+		got, err := captureProfileURL(tc.base, tc.profileType, tc.seconds, tc.hz)
+		if err != nil || got != tc.want {
+			t.Errorf("captureProfileURL(%q, %q): expected %q, got %q (%v)", tc.base, tc.profileType, tc.want, got, err)
+		}
+	}
+	if _, err := captureProfileURL("ftp://host", "cpu", 10, 0); err == nil {
+		t.Error("Expected an error for a non-http base_url")
+	}
+}
+
+func TestHandleCaptureProfile(t *testing.T) {
+	var requested []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RequestURI())
+		if r.URL.Path != "/debug/pprof/profile" {
+			w.Write([]byte("<html>not a profile</html>"))
+			return
+		}
+		if err := poolTestProfile("main.hot", 100, 200).Write(w); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer target.Close()
+	workspace := t.TempDir()
+	t.Setenv(workspaceEnv, workspace)
+
+	call := func(args map[string]interface{}) (*mcp.CallToolResult, error) {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"base_url": target.URL + "/debug/pprof"}
+		for name, value := range args {
+			request.Params.Arguments[name] = value
+		}
+		return handleCaptureProfile(context.Background(), request)
+	}
+
+	result, err := call(map[string]interface{}{"profile_type": "cpu", "seconds": 2.0, "analyze": true})
+	if err != nil {
+		t.Fatalf("handleCaptureProfile failed: %v", err)
+	}
+	if len(requested) != 1 || requested[0] != "/debug/pprof/profile?seconds=2" {
+		t.Errorf("Expected one request for a 2s CPU profile, got %v", requested)
+	}
+	files, _ := filepath.Glob(filepath.Join(workspace, "cpu-127.0.0.1_*.pb.gz"))
+	if len(files) != 1 {
+		t.Fatalf("Expected the capture to be saved in the workspace, got %v", files)
+	}
+	if summary := result.Content[0].(mcp.TextContent).Text; !strings.Contains(summary, "Saved to: "+files[0]) {
+		t.Errorf("Expected the saved path in the summary:\n%s", summary)
+	}
+	if len(result.Content) != 2 || !strings.Contains(result.Content[1].(mcp.TextContent).Text, "main.hot") {
+		t.Errorf("Expected the analysis of the capture, got %+v", result.Content)
+	}
+
+	if _, err := call(map[string]interface{}{"profile_type": "heap"}); err == nil || !strings.Contains(err.Error(), "did not return a profile") {
+		t.Errorf("Expected an error for a response that is not a profile, got %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(workspace, "heap-*")); len(files) != 0 {
+		t.Errorf("Expected nothing saved for an invalid response, got %v", files)
+	}
+
+	t.Setenv(confirmEnv, "on")
+	outside := t.TempDir()
+	result, err = call(map[string]interface{}{"profile_type": "cpu", "output_dir": outside})
+	if err != nil || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "confirmation_required") {
+		t.Errorf("Expected a confirmation request for an output_dir outside the workspace, got %+v (%v)", result, err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing written before confirmation, got %v", entries)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// defaultCIMaxProfiles caps the profiles analyze_ci_artifacts loads per call, so a log referencing hundreds
+// of profiles does not load all of them by accident.
+const defaultCIMaxProfiles = 20
+
+// ciProfileRef is a profile referenced by CI output, with the Go package it belongs to ("" when unknown).
+type ciProfileRef struct {
+	Package string
+	Path    string
+}
+
+// ciTestEvent is the part of a 'go test -json' event (see 'go doc test2json') used to find profiles.
+type ciTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+// ciProfileFlagRe matches the profiling flags of 'go test' (and of test binaries, '-test.cpuprofile'), whose
+// values are profiles whatever their name.
+var ciProfileFlagRe = regexp.MustCompile(`-(?:test\.)?(?:cpu|mem|block|mutex)profile(?:=|\s+)([^\s"'=]+)`)
+
+// ciTokenRe splits CI output into candidate paths.
+var ciTokenRe = regexp.MustCompile(`[^\s"'<>()\[\]{},;=]+`)
+
+// ciProfileNames are the profile name prefixes accepted with a generic '.out' extension, as in the
+// 'go test -cpuprofile cpu.out' examples of the Go documentation.
+var ciProfileNames = []string{"cpu", "mem", "heap", "alloc", "block", "mutex", "goroutine", "threadcreate"}
+
+// looksLikeProfilePath reports whether a token of CI output names a profile file: '.pprof', '.prof' and
+// '.pb.gz' files (optionally gzipped), and '.out' files named after a profile type (cpu.out, mem.out, ...).
+func looksLikeProfilePath(token string) bool {
+	if i := strings.IndexAny(token, "?#"); i >= 0 && strings.Contains(token, "://") {
+		token = token[:i]
+	}
+	base := strings.ToLower(path.Base(strings.ReplaceAll(token, "\\", "/")))
+	gzipped := strings.HasSuffix(base, ".gz")
+	base = strings.TrimSuffix(base, ".gz")
+	switch {
+	case strings.HasSuffix(base, ".pprof"), strings.HasSuffix(base, ".prof"):
+		return len(base) > len(path.Ext(base))
+	case strings.HasSuffix(base, ".pb"):
+		return gzipped && len(base) > len(".pb") // 未压缩的 .pb 文件太常见，不一定是 profile
+	case strings.HasSuffix(base, ".out"):
+		for _, name := range ciProfileNames {
+			if strings.HasPrefix(base, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scanProfilePaths returns the profile paths mentioned in a piece of CI output, in order of appearance.
+func scanProfilePaths(text string) []string {
+	paths := make([]string, 0)
+	for _, m := range ciProfileFlagRe.FindAllStringSubmatch(text, -1) {
+		paths = append(paths, m[1])
+	}
+	for _, token := range ciTokenRe.FindAllString(text, -1) {
+		// 句末的标点 (例如 "wrote cpu.pprof.") 和 "file:line" 中的冒号不属于路径
+		token = strings.TrimRight(token, ".:`")
+		if looksLikeProfilePath(token) {
+			paths = append(paths, token)
+		}
+	}
+	return paths
+}
+
+// extractCIProfileRefs finds the profiles referenced by CI output, in order of appearance and without
+// duplicates. It understands 'go test -json' output (profiles mentioned in a package's or test's output
+// belong to that package), JSON artifact manifests (any object or array naming profile files; a "package",
+// "pkg" or "importPath" field applies to the files below it) and plain text such as 'go test -v' logs or
+// file listings. The second result maps packages to their 'go test -json' result.
+func extractCIProfileRefs(data []byte) ([]ciProfileRef, map[string]string) {
+	refs := make([]ciProfileRef, 0)
+	seen := make(map[ciProfileRef]bool)
+	add := func(pkg, p string) {
+		ref := ciProfileRef{Package: pkg, Path: p}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	statuses := make(map[string]string)
+
+	if events, ok := parseTestEvents(data); ok {
+		for _, ev := range events {
+			for _, p := range scanProfilePaths(ev.Output) {
+				add(ev.Package, p)
+			}
+			if ev.Test == "" && (ev.Action == "pass" || ev.Action == "fail" || ev.Action == "skip") {
+				statuses[ev.Package] = ev.Action
+			}
+		}
+		return refs, statuses
+	}
+
+	var manifest interface{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Unmarshal(trimmed, &manifest) == nil {
+		walkCIManifest(manifest, "", add)
+		return refs, statuses
+	}
+
+	for _, p := range scanProfilePaths(string(data)) {
+		add("", p)
+	}
+	return refs, statuses
+}
+
+// parseTestEvents parses 'go test -json' output. Lines that are not JSON (e.g. build errors printed by
+// 'go test' itself) are skipped; the output is only taken as test events when at least one line is one.
+func parseTestEvents(data []byte) ([]ciTestEvent, bool) {
+	events := make([]ciTestEvent, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var ev ciTestEvent
+		if err := json.Unmarshal(line, &ev); err != nil || ev.Action == "" {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, len(events) > 0
+}
+
+// walkCIManifest collects the profile files named anywhere in a decoded JSON manifest.
+func walkCIManifest(v interface{}, pkg string, add func(pkg, path string)) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, key := range []string{"package", "pkg", "importPath", "ImportPath"} {
+			if name, ok := v[key].(string); ok && name != "" {
+				pkg = name
+				break
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // 对象的键无序，按名称遍历使结果稳定
+		for _, key := range keys {
+			walkCIManifest(v[key], pkg, add)
+		}
+	case []interface{}:
+		for _, item := range v {
+			walkCIManifest(item, pkg, add)
+		}
+	case string:
+		if looksLikeProfilePath(v) {
+			add(pkg, v)
+		}
+	}
+}
+
+// resolveCIProfileURI resolves a profile path found in CI output against base: a URL (relative paths are
+// resolved like links) or a directory. URIs and absolute paths are returned unchanged.
+func resolveCIProfileURI(p, base string) string {
+	if strings.Contains(p, "://") || filepath.IsAbs(p) || base == "" {
+		return p
+	}
+	if strings.Contains(base, "://") {
+		baseURL, err := url.Parse(base)
+		if err != nil {
+			return p
+		}
+		ref, err := url.Parse(filepath.ToSlash(p))
+		if err != nil {
+			return p
+		}
+		return baseURL.ResolveReference(ref).String()
+	}
+	return filepath.Join(base, p)
+}
+
+// ciPackageName returns the package a profile is summarized under: its Go package, or the directory of the
+// profile file for manifests and logs that do not name packages.
+func ciPackageName(ref ciProfileRef) string {
+	if ref.Package != "" {
+		return ref.Package
+	}
+	dir := path.Dir(filepath.ToSlash(ref.Path))
+	if dir == "." {
+		return "(no package)"
+	}
+	return dir
+}
+
+// handleAnalyzeCIArtifacts finds the profiles referenced by 'go test -json' output, a CI artifact manifest or
+// a plain CI log, analyzes each and returns a per-package summary, so CI logs can be fed to the server as
+// they are.
+func handleAnalyzeCIArtifacts(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	artifactURI, _ := args["artifact_uri"].(string)
+	ciOutput, _ := args["ci_output"].(string)
+	if (artifactURI == "") == (ciOutput == "") {
+		return nil, fmt.Errorf("exactly one of artifact_uri (string) and ci_output (string) must be given")
+	}
+	baseDir, _ := args["base_dir"].(string)
+
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 3.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 3
+	}
+	maxProfilesFloat, ok := args["max_profiles"].(float64)
+	if !ok {
+		maxProfilesFloat = defaultCIMaxProfiles
+	}
+	maxProfiles := int(maxProfilesFloat)
+	if maxProfiles <= 0 {
+		maxProfiles = defaultCIMaxProfiles
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling analyze_ci_artifacts: ArtifactURI=%s, InlineOutput=%d bytes, BaseDir=%s, TopN=%d, MaxProfiles=%d, Format=%s",
+		artifactURI, len(ciOutput), baseDir, topN, maxProfiles, outputFormat)
+
+	source := "inline CI output"
+	data := []byte(ciOutput)
+	if artifactURI != "" {
+		source = artifactURI
+		// 日志或清单本身不是 profile：不记录到分析中，以免 profile_heatmap 等工具把它当作 profile 加载
+		filePath, cleanup, err := getProfileAsFile(ctx, artifactURI, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CI output: %w", err)
+		}
+		data, err = os.ReadFile(filePath)
+		cleanup()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CI output '%s': %w", artifactURI, err)
+		}
+		// 相对路径默认相对于日志所在的位置：本地目录，或下载 URL (按链接解析)
+		if baseDir == "" {
+			if strings.Contains(artifactURI, "://") && !strings.HasPrefix(artifactURI, "file://") {
+				baseDir = artifactURI
+			} else {
+				baseDir = filepath.Dir(filePath)
+			}
+		}
+	}
+
+	refs, statuses := extractCIProfileRefs(data)
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no profile files are referenced in %s (looked for .pprof, .prof and .pb.gz files, cpu.out-style names and -cpuprofile/-memprofile/-blockprofile/-mutexprofile flags)", source)
+	}
+
+	summary := &analyzer.CISummary{Source: source}
+	if len(refs) > maxProfiles {
+		summary.Skipped = len(refs) - maxProfiles
+		refs = refs[:maxProfiles]
+	}
+	packages := make(map[string]int)
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		uri := resolveCIProfileURI(ref.Path, baseDir)
+		var profileSummary analyzer.CIProfile
+		if p, err := loadProfile(ctx, uri, analysisID); err != nil {
+			log.Printf("Warning: failed to load profile '%s' referenced by CI output: %v", uri, err)
+			profileSummary = analyzer.CIProfile{Path: ref.Path, Error: err.Error()}
+		} else {
+			profileSummary = analyzer.SummarizeCIProfile(p, ref.Path, topN)
+		}
+
+		name := ciPackageName(ref)
+		i, ok := packages[name]
+		if !ok {
+			i = len(summary.Packages)
+			packages[name] = i
+			summary.Packages = append(summary.Packages, analyzer.CIPackage{Package: name, Status: statuses[ref.Package]})
+		}
+		summary.Packages[i].Profiles = append(summary.Packages[i].Profiles, profileSummary)
+	}
+
+	result, err := analyzer.FormatCISummary(summary, outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "analyze_ci_artifacts", outputFormat, result)
+	return withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestExtractCIProfileRefs(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		want     []ciProfileRef
+		statuses map[string]string
+	}{
+		{
+			name: "GoTestJSON",
+			data: `{"Action":"start","Package":"example.com/a"}
+{"Action":"output","Package":"example.com/a","Test":"TestX","Output":"    x_test.go:12: wrote out/cpu.pprof.\n"}
+{"Action":"output","Package":"example.com/a","Output":"ok  \texample.com/a\t0.1s\n"}
+{"Action":"pass","Package":"example.com/a","Test":"TestX"}
+{"Action":"fail","Package":"example.com/a"}
+build output that is not JSON: heap.pb.gz
+{"Action":"output","Package":"example.com/b","Output":"go test -test.memprofile=/tmp/b-mem -cpuprofile cpu.out\n"}
+{"Action":"output","Package":"example.com/b","Output":"again: cpu.out\n"}
+{"Action":"pass","Package":"example.com/b"}`,
+			want: []ciProfileRef{
+				{Package: "example.com/a", Path: "out/cpu.pprof"},
+				{Package: "example.com/b", Path: "/tmp/b-mem"},
+				{Package: "example.com/b", Path: "cpu.out"},
+			},
+			statuses: map[string]string{"example.com/a": "fail", "example.com/b": "pass"},
+		},
+		{
+			name: "Manifest",
+			data: `{"artifacts": [{"package": "example.com/c", "files": ["c/block.prof", "c/report.txt"]}, {"path": "https://ci.example.com/x/heap.pb.gz?sig=1"}, "data.pb", "notes.out"]}`,
+			want: []ciProfileRef{
+				{Package: "example.com/c", Path: "c/block.prof"},
+				{Package: "", Path: "https://ci.example.com/x/heap.pb.gz?sig=1"},
+			},
+			statuses: map[string]string{},
+		},
+		{
+			name: "PlainLog",
+			data: "Uploading artifacts/pkg/mem.out (12 kB)\nsaved `trace.out` and artifacts/pkg/cpu.prof.gz: done\n.pprof\n",
+			want: []ciProfileRef{
+				{Path: "artifacts/pkg/mem.out"},
+				{Path: "artifacts/pkg/cpu.prof.gz"},
+			},
+			statuses: map[string]string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			refs, statuses := extractCIProfileRefs([]byte(tc.data))
+			if !reflect.DeepEqual(refs, tc.want) {
+				t.Errorf("Expected refs %+v, got %+v", tc.want, refs)
+			}
+			if !reflect.DeepEqual(statuses, tc.statuses) {
+				t.Errorf("Expected statuses %v, got %v", tc.statuses, statuses)
+			}
+		})
+	}
+}
+
+func TestResolveCIProfileURI(t *testing.T) {
+	cases := []struct{ path, base, want string }{
+		{"cpu.pprof", "", "cpu.pprof"},
+		{"pkg/cpu.pprof", "/ci/artifacts", "/ci/artifacts/pkg/cpu.pprof"},
+		{"/abs/cpu.pprof", "/ci/artifacts", "/abs/cpu.pprof"},
+		{"pkg/cpu.pprof", "https://ci.example.com/runs/1/test.json", "https://ci.example.com/runs/1/pkg/cpu.pprof"},
+		{"http://other/cpu.pprof", "/ci", "http://other/cpu.pprof"},
+	}
+	for _, tc := range cases {
+		if got := resolveCIProfileURI(tc.path, tc.base); got != tc.want {
+			t.Errorf("resolveCIProfileURI(%q, %q): expected %q, got %q", tc.path, tc.base, tc.want, got)
+		}
+	}
+}
+
+func TestHandleAnalyzeCIArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "pkg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(dir, "pkg", "cpu.pprof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := poolTestProfile("main.hot", 7, 8).Write(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	ciOutput := `{"Action":"output","Package":"example.com/pkg","Output":"profile: pkg/cpu.pprof, pkg/gone.pprof\n"}
+{"Action":"pass","Package":"example.com/pkg"}
+`
+	logPath := filepath.Join(dir, "test.json")
+	if err := os.WriteFile(logPath, []byte(ciOutput), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"artifact_uri": logPath}
+	result, err := handleAnalyzeCIArtifacts(context.Background(), request)
+	if err != nil {
+		t.Fatalf("handleAnalyzeCIArtifacts failed: %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	for _, want := range []string{"=== example.com/pkg (pass) ===", "pkg/cpu.pprof: cpu profile, total 15ns cpu", "main.hot", "pkg/gone.pprof: ERROR:"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the summary:\n%s", want, text)
+		}
+	}
+
+	request.Params.Arguments = map[string]interface{}{"ci_output": "nothing to see here"}
+	if _, err := handleAnalyzeCIArtifacts(context.Background(), request); err == nil || !strings.Contains(err.Error(), "no profile files are referenced") {
+		t.Errorf("Expected an error for output without profiles, got %v", err)
+	}
+	request.Params.Arguments = map[string]interface{}{"ci_output": "cpu.pprof", "artifact_uri": logPath}
+	if _, err := handleAnalyzeCIArtifacts(context.Background(), request); err == nil {
+		t.Errorf("Expected an error when both artifact_uri and ci_output are given")
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// cliCommand is a subcommand that runs one tool handler from the command line, without an MCP client.
+type cliCommand struct {
+	Usage       string
+	Description string
+	// Parse turns command-line arguments into the handler's tool arguments.
+	Parse   func(fs *flag.FlagSet, args []string) (map[string]interface{}, error)
+	Handler server.ToolHandlerFunc
+}
+
+// cliCommands are the supported subcommands, e.g. 'pprof-analyzer-mcp analyze -type heap heap.pb.gz'.
+var cliCommands = map[string]cliCommand{
+	"analyze": {
+		Usage:       "analyze [-type cpu] [-top 5] [-format text] [-focus regex] [-ignore regex] [-tag key=regex] [-exclude_test_frames] [-min_sample_value 0.1%] [-group_by_label key] [-aggregation_level package] <profile_uri>",
+		Description: "Analyze a profile and print the report (same as the analyze_pprof tool).",
+		Parse:       parseAnalyzeArgs,
+		Handler:     handleAnalyzePprof,
+	},
+	"flamegraph": {
+		Usage:       "flamegraph [-type cpu] [-o flamegraph.svg | -json] [-focus regex] [-ignore regex] [-exclude_test_frames] <profile_uri>",
+		Description: "Write an SVG flame graph via 'go tool pprof' (same as generate_flamegraph), or print flame graph JSON with -json.",
+		Parse:       parseFlamegraphArgs,
+		Handler:     handleCLIFlamegraph,
+	},
+	"diff": {
+		Usage:       "diff [-threshold 0.1] [-limit 10] [-top_k 5] [-ignore regex,...] [-format text] <old_profile_uri> <new_profile_uri>",
+		Description: "Compare two heap profiles (same as the detect_memory_leaks tool).",
+		Parse:       parseDiffArgs,
+		Handler:     handleDetectMemoryLeaks,
+	},
+	"ci": {
+		Usage:       "ci [-top 3] [-max_profiles 20] [-base_dir dir] [-format text] <go_test_json_or_manifest_uri | ->",
+		Description: "Summarize per package the profiles referenced by 'go test -json' output, a CI artifact manifest or a CI log; '-' reads it from stdin (same as the analyze_ci_artifacts tool).",
+		Parse:       parseCIArgs,
+		Handler:     handleAnalyzeCIArtifacts,
+	},
+}
+
+// cliUsage prints the available subcommands.
+func cliUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [<command> [flags] <args>]\n\n", os.Args[0])
+	fmt.Fprintln(w, "Without a command, the MCP server is started on stdio. Commands:")
+	for _, name := range []string{"analyze", "flamegraph", "diff", "ci"} {
+		cmd := cliCommands[name]
+		fmt.Fprintf(w, "  %s\n      %s\n", cmd.Usage, cmd.Description)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// runCLI executes a subcommand and returns the process exit code.
+// Reports go to stdout; logs are only shown with -v so the output can be piped.
+func runCLI(name string, args []string) int {
+	cmd := cliCommands[name]
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "Print logs to stderr")
+	defaultFormat := analyzer.DefaultValueFormat()
+	units := fs.String("units", string(defaultFormat.ByteUnits), "Byte units: jedec (KB, 1024), iec (KiB) or si (kB, 1000) (default from $"+byteUnitsEnv+")")
+	locale := fs.String("locale", defaultFormat.Locale.Name, "Number separators: c, en, de, fr or ch (default from $"+numberLocaleEnv+")")
+	counts := fs.String("counts", string(defaultFormat.Counts), "Counts: grouped (123,456,789), plain (123456789) or human (123.5M) (default from $"+countStyleEnv+")")
+	align := fs.Bool("align", defaultFormat.AlignRight, "Right-align value columns in text reports (default from $"+alignValuesEnv+")")
+	colorMode := fs.String("color", "auto", "Color text reports: auto (when stdout is a terminal and $NO_COLOR is unset), always or never")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n%s\n\nFlags:\n", os.Args[0], cmd.Usage, cmd.Description)
+		fs.PrintDefaults()
+	}
+
+	toolArgs, err := cmd.Parse(fs, args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fs.Usage()
+		return 2
+	}
+	valueFormat, err := parseValueFormat(*units, *locale, *counts, *align)
+	if err == nil {
+		valueFormat.Color, err = useColor(*colorMode)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	analyzer.SetDefaultValueFormat(valueFormat)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	normalizeProfileTypeArg(toolArgs)
+	os.Setenv(confirmEnv, "off") // 命令由用户本人输入，视为已确认 (见 confirmation.go)，令牌模式也不例外
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = name
+	request.Params.Arguments = toolArgs
+	result, err := confirmableHandler(name, cmd.Handler)(context.Background(), request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			fmt.Fprintln(os.Stdout, text.Text)
+		}
+	}
+	if result.IsError {
+		return 1
+	}
+	return 0
+}
+
+// parseAnalyzeArgs parses the flags of the 'analyze' command.
+func parseAnalyzeArgs(fs *flag.FlagSet, args []string) (map[string]interface{}, error) {
+	profileType := fs.String("type", "cpu", "Profile type: cpu, heap, goroutine, allocs, mutex, block or threadcreate")
+	topN := fs.Int("top", 5, "Number of top entries to show")
+	format := fs.String("format", "text", "Output format: text, markdown, markdown-compact, json, flamegraph-json, callgraph, callgraph-dot, graphml, centrality, edges or folded")
+	maxChars := fs.Int("max_chars", 4000, "Character budget of markdown-compact reports")
+	groupBy := fs.String("group_by", "function", "Roll heap/allocs profiles up by function or package")
+	threshold := fs.Float64("ownership_threshold", 20, "Flag packages owning more than this percent (with -group_by package)")
+	maxStackDepth := fs.Int("max_stack_depth", 0, "Frames shown per goroutine/compact stack (0 for all)")
+	sortBy := fs.String("sort_by", "flat", "Sort CPU functions by flat or cum time")
+	focus := fs.String("focus", "", "Only keep samples with a function matching this regex (like pprof -focus)")
+	ignore := fs.String("ignore", "", "Drop samples with a function matching this regex (like pprof -ignore)")
+	tag := fs.String("tag", "", "Only keep samples whose labels match these comma-separated key=regex (or key!=regex) conditions")
+	excludeTestFrames := fs.Bool("exclude_test_frames", false, "Remove frames of _test.go files and mock packages from stacks")
+	minSampleValue := fs.String("min_sample_value", "", "Drop samples below this value (in the sample type's unit) or percentage of the total (e.g. 0.1%)")
+	groupByLabel := fs.String("group_by_label", "", "Break the report down by the values of this label key")
+	aggregationLevel := fs.String("aggregation_level", "function", "Roll top-N lists up by function, file or package")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("expected exactly one profile URI, got %d", fs.NArg())
+	}
+	return map[string]interface{}{
+		"profile_uri":         fs.Arg(0),
+		"profile_type":        *profileType,
+		"top_n":               float64(*topN),
+		"output_format":       *format,
+		"max_chars":           float64(*maxChars),
+		"group_by":            *groupBy,
+		"ownership_threshold": *threshold,
+		"max_stack_depth":     float64(*maxStackDepth),
+		"sort_by":             *sortBy,
+		"focus_regex":         *focus,
+		"ignore_regex":        *ignore,
+		"tag_filter":          *tag,
+		"exclude_test_frames": *excludeTestFrames,
+		"min_sample_value":    *minSampleValue,
+		"group_by_label":      *groupByLabel,
+		"aggregation_level":   *aggregationLevel,
+	}, nil
+}
+
+// parseFlamegraphArgs parses the flags of the 'flamegraph' command.
+func parseFlamegraphArgs(fs *flag.FlagSet, args []string) (map[string]interface{}, error) {
+	profileType := fs.String("type", "cpu", "Profile type: cpu, heap, goroutine, allocs, mutex, block or threadcreate")
+	output := fs.String("o", "flamegraph.svg", "Path of the SVG file to write")
+	asJSON := fs.Bool("json", false, "Print flame graph JSON to stdout instead of writing an SVG (no Graphviz required)")
+	overwrite := fs.Bool("overwrite", false, "Replace the SVG file if it already exists")
+	focus := fs.String("focus", "", "Only keep samples with a function matching this regex (like pprof -focus)")
+	ignore := fs.String("ignore", "", "Drop samples with a function matching this regex (like pprof -ignore)")
+	excludeTestFrames := fs.Bool("exclude_test_frames", false, "Remove frames of _test.go files and mock packages from stacks")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("expected exactly one profile URI, got %d", fs.NArg())
+	}
+	return map[string]interface{}{
+		"profile_uri":         fs.Arg(0),
+		"profile_type":        *profileType,
+		"output_svg_path":     *output,
+		"overwrite":           *overwrite,
+		"json":                *asJSON,
+		"focus_regex":         *focus,
+		"ignore_regex":        *ignore,
+		"exclude_test_frames": *excludeTestFrames,
+	}, nil
+}
+
+// handleCLIFlamegraph dispatches the 'flamegraph' command to generate_flamegraph, or to analyze_pprof
+// with the flamegraph-json format when -json is given.
+func handleCLIFlamegraph(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if asJSON, _ := request.Params.Arguments["json"].(bool); asJSON {
+		request.Params.Arguments["output_format"] = "flamegraph-json"
+		result, err := handleAnalyzePprof(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		// Only the flame graph JSON goes to stdout, so it can be piped; warnings and the sample type table go to stderr
+		var graph []mcp.Content
+		for _, content := range result.Content {
+			text, ok := content.(mcp.TextContent)
+			if ok && !strings.HasPrefix(strings.TrimSpace(text.Text), "{") {
+				fmt.Fprintln(os.Stderr, text.Text)
+				continue
+			}
+			graph = append(graph, content)
+		}
+		result.Content = graph
+		return result, nil
+	}
+	return handleGenerateFlamegraph(ctx, request)
+}
+
+// parseDiffArgs parses the flags of the 'diff' command.
+func parseDiffArgs(fs *flag.FlagSet, args []string) (map[string]interface{}, error) {
+	threshold := fs.Float64("threshold", 0.1, "Growth threshold for reporting a type (0.1 = 10%)")
+	limit := fs.Int("limit", 10, "Maximum number of growing types to report")
+	topK := fs.Int("top_k", 5, "Number of top regressions and improvements to list")
+	ignore := fs.String("ignore", "", "Comma-separated regexes of noisy functions to exclude (added to $"+diffIgnoreEnv+")")
+	format := fs.String("format", "text", "Output format: text, markdown or heatmap-json")
+	matchRenamed := fs.Bool("match_renamed_functions", true, "Match functions renamed between the profiles (e.g. by a module major version upgrade)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 2 {
+		return nil, fmt.Errorf("expected an old and a new profile URI, got %d argument(s)", fs.NArg())
+	}
+	return map[string]interface{}{
+		"old_profile_uri":         fs.Arg(0),
+		"new_profile_uri":         fs.Arg(1),
+		"threshold":               *threshold,
+		"limit":                   float64(*limit),
+		"top_k":                   float64(*topK),
+		"ignore_functions":        *ignore,
+		"output_format":           *format,
+		"match_renamed_functions": *matchRenamed,
+	}, nil
+}
+
+// parseCIArgs parses the flags of the 'ci' command.
+func parseCIArgs(fs *flag.FlagSet, args []string) (map[string]interface{}, error) {
+	topN := fs.Int("top", 3, "Number of hottest functions shown per profile")
+	maxProfiles := fs.Int("max_profiles", defaultCIMaxProfiles, "Maximum number of profiles to analyze")
+	baseDir := fs.String("base_dir", "", "Directory or URL relative profile paths are resolved against (default: the location of the CI output)")
+	format := fs.String("format", "text", "Output format: text, markdown or json")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("expected exactly one CI output URI (or '-' for stdin), got %d", fs.NArg())
+	}
+	toolArgs := map[string]interface{}{
+		"base_dir":      *baseDir,
+		"top_n":         float64(*topN),
+		"max_profiles":  float64(*maxProfiles),
+		"output_format": *format,
+	}
+	if fs.Arg(0) != "-" {
+		toolArgs["artifact_uri"] = fs.Arg(0)
+		return toolArgs, nil
+	}
+	// 'go test -json ./... | pprof-analyzer-mcp ci -'
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CI output from stdin: %w", err)
+	}
+	toolArgs["ci_output"] = string(data)
+	return toolArgs, nil
+}
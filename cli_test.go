@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestParseCLIArgs(t *testing.T) {
+	args, err := parseAnalyzeArgs(flag.NewFlagSet("analyze", flag.ContinueOnError),
+		[]string{"-type", "heap", "-top", "3", "-format", "json", "-focus", "main\\.", "-exclude_test_frames", "-min_sample_value", "0.1%", "heap.pb.gz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]interface{}{
+		"profile_uri": "heap.pb.gz", "profile_type": "heap", "top_n": 3.0, "output_format": "json", "focus_regex": "main\\.",
+		"ignore_regex": "", "exclude_test_frames": true, "min_sample_value": "0.1%", "sort_by": "flat", "aggregation_level": "function",
+	} {
+		if args[name] != want {
+			t.Errorf("analyze: expected %s=%v, got %v", name, want, args[name])
+		}
+	}
+
+	args, err = parseDiffArgs(flag.NewFlagSet("diff", flag.ContinueOnError), []string{"-threshold", "0.2", "-match_renamed_functions=false", "old.pb.gz", "new.pb.gz"})
+	if err != nil || args["old_profile_uri"] != "old.pb.gz" || args["new_profile_uri"] != "new.pb.gz" || args["threshold"] != 0.2 ||
+		args["match_renamed_functions"] != false || args["top_k"] != 5.0 {
+		t.Errorf("diff: unexpected arguments %v (%v)", args, err)
+	}
+	args, err = parseFlamegraphArgs(flag.NewFlagSet("flamegraph", flag.ContinueOnError), []string{"cpu.pb.gz"})
+	if err != nil || args["output_svg_path"] != "flamegraph.svg" || args["json"] != false || args["overwrite"] != false {
+		t.Errorf("flamegraph: unexpected defaults %v (%v)", args, err)
+	}
+
+	errorCases := []struct {
+		parse func(*flag.FlagSet, []string) (map[string]interface{}, error)
+		args  []string
+		want  string
+	}{
+		{parseAnalyzeArgs, nil, "expected exactly one profile URI, got 0"},
+		{parseAnalyzeArgs, []string{"a.pb.gz", "b.pb.gz"}, "expected exactly one profile URI, got 2"},
+		{parseAnalyzeArgs, []string{"-top", "many", "a.pb.gz"}, "invalid value"},
+		{parseDiffArgs, []string{"old.pb.gz"}, "expected an old and a new profile URI, got 1"},
+		{parseFlamegraphArgs, []string{"-unknown", "cpu.pb.gz"}, "flag provided but not defined"},
+	}
+	for _, tc := range errorCases {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		if _, err := tc.parse(fs, tc.args); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: expected an error containing %q, got %v", tc.args, tc.want, err)
+		}
+	}
+}
+
+// runCLIOutput runs a CLI command and returns its exit code, standard output and standard error.
+func runCLIOutput(t *testing.T, name string, args ...string) (int, string, string) {
+	t.Helper()
+	// runCLI sets the value format, the log output and the confirmation mode of the process
+	format := analyzer.DefaultValueFormat()
+	t.Setenv(confirmEnv, "")
+	defer func() {
+		analyzer.SetDefaultValueFormat(format)
+		log.SetOutput(os.Stderr)
+	}()
+
+	capture := func(file **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := *file
+		*file = w
+		output := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			output <- string(data)
+		}()
+		return func() string {
+			*file = saved
+			w.Close()
+			return <-output
+		}
+	}
+	stdout, stderr := capture(&os.Stdout), capture(&os.Stderr)
+	code := runCLI(name, args)
+	return code, stdout(), stderr()
+}
+
+func TestRunCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, fn string, values ...int64) string {
+		path := filepath.Join(dir, name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := poolTestProfile(fn, values...).Write(file); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	cpu := write("cpu.pb.gz", "main.hot", 3e9, 1e9)
+
+	code, output, _ := runCLIOutput(t, "analyze", "-type", "cpu", "-top", "3", "-color", "never", cpu)
+	if code != 0 || !strings.Contains(output, "main.hot") || !strings.Contains(output, "4s") {
+		t.Errorf("Expected the CPU report of the fixture, got exit code %d:\n%s", code, output)
+	}
+
+	// Only the flame graph JSON goes to stdout
+	code, output, errOutput := runCLIOutput(t, "flamegraph", "-json", cpu)
+	var graph analyzer.FlameGraphNode
+	if err := json.Unmarshal([]byte(output), &graph); code != 0 || err != nil || graph.Value != 4e9 {
+		t.Errorf("Expected flame graph JSON, got exit code %d (%v):\n%s", code, err, output)
+	}
+	if !strings.Contains(errOutput, "Sample Types") {
+		t.Errorf("Expected the sample type table on stderr, got:\n%s", errOutput)
+	}
+
+	// Usage errors exit with 2, failures with 1
+	if code, _, errOutput = runCLIOutput(t, "analyze", "-format"); code != 2 || !strings.Contains(errOutput, "Usage:") {
+		t.Errorf("Expected a usage error, got exit code %d:\n%s", code, errOutput)
+	}
+	if code, _, _ = runCLIOutput(t, "analyze", "-h"); code != 0 {
+		t.Errorf("Expected -h to exit with 0, got %d", code)
+	}
+	if code, _, errOutput = runCLIOutput(t, "analyze", filepath.Join(dir, "missing.pb.gz")); code != 1 || !strings.Contains(errOutput, "Error:") {
+		t.Errorf("Expected a missing profile to fail, got exit code %d:\n%s", code, errOutput)
+	}
+}
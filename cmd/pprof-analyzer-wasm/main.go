@@ -0,0 +1,82 @@
+//go:build js && wasm
+
+// Command pprof-analyzer-wasm exposes the analyzer core to JavaScript, so in-browser frontends aggregate
+// profiles and build flame graph trees with exactly the logic of the MCP tools. Build it with
+// scripts/build-wasm.sh and load it with the wasm_exec.js of the same Go version:
+//
+//	const go = new Go();
+//	const { instance } = await WebAssembly.instantiateStreaming(fetch("pprof-analyzer.wasm"), go.importObject);
+//	go.run(instance);
+//	const tree = JSON.parse(pprofAnalyzer.flameGraphTree(new Uint8Array(await file.arrayBuffer()), {}));
+//
+// Every function takes the bytes of a profile file (gzipped or not) as a Uint8Array and an optional options
+// object, and returns a JSON string: the result, or {"error": "..."} when the profile cannot be analyzed.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func main() {
+	js.Global().Set("pprofAnalyzer", js.ValueOf(map[string]interface{}{
+		// aggregate(profile, {sampleType, by: "function" | "site", topN})
+		"aggregate": jsFunc(func(data []byte, options js.Value) (string, error) {
+			return analyzer.AggregateProfileJSON(data, stringOption(options, "sampleType"), stringOption(options, "by"), intOption(options, "topN"))
+		}),
+		// flameGraphTree(profile, {sampleType})
+		"flameGraphTree": jsFunc(func(data []byte, options js.Value) (string, error) {
+			return analyzer.FlameGraphTreeJSON(data, stringOption(options, "sampleType"))
+		}),
+	}))
+	select {} // Keep the functions callable
+}
+
+// jsFunc wraps fn as a JavaScript function taking a Uint8Array and an options object.
+func jsFunc(fn func(data []byte, options js.Value) (string, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = errorJSON(fmt.Errorf("panic: %v", r))
+			}
+		}()
+		if len(args) == 0 || args[0].Type() != js.TypeObject || args[0].Get("byteLength").Type() != js.TypeNumber {
+			return errorJSON(fmt.Errorf("expected the profile as a Uint8Array"))
+		}
+		data := make([]byte, args[0].Get("byteLength").Int())
+		js.CopyBytesToGo(data, args[0])
+		options := js.Undefined()
+		if len(args) > 1 {
+			options = args[1]
+		}
+		out, err := fn(data, options)
+		if err != nil {
+			return errorJSON(err)
+		}
+		return out
+	})
+}
+
+// errorJSON returns {"error": "..."} for err.
+func errorJSON(err error) string {
+	jsonBytes, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(jsonBytes)
+}
+
+// stringOption and intOption read an option, returning the zero value when it is not set.
+func stringOption(options js.Value, name string) string {
+	if options.Type() != js.TypeObject || options.Get(name).Type() != js.TypeString {
+		return ""
+	}
+	return options.Get(name).String()
+}
+
+func intOption(options js.Value, name string) int {
+	if options.Type() != js.TypeObject || options.Get(name).Type() != js.TypeNumber {
+		return 0
+	}
+	return options.Get(name).Int()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// confirmEnv selects how tools that run external commands or write outside the workspace are confirmed.
+// By default ("off") every action is performed without asking, as before confirmations existed, so that
+// generate_flamegraph and open_interactive_pprof keep working with clients that never set 'confirm'.
+// With "on" they return a 'confirmation_required' result and only act when called again with 'confirm'.
+// The client sets that argument, so this only protects users whose client shows such results before
+// retrying: the model can set 'confirm' on its own, and the MCP version implemented by the server has no
+// elicitation request to ask the user directly. "token" closes that gap: the approval is a one-time token
+// printed only in the server log (stderr), which the user must pass on as 'confirm_token'.
+const confirmEnv = "PPROF_ANALYZER_CONFIRM"
+
+// confirmTokenTTL is how long a token of the "token" confirmation mode stays valid.
+const confirmTokenTTL = 10 * time.Minute
+
+// workspaceEnv lists the directories (separated like PATH) tools may write to without confirmation; the
+// default is the server's working directory.
+const workspaceEnv = "PPROF_ANALYZER_WORKSPACE"
+
+// withConfirm declares the 'confirm' and 'confirm_token' arguments of a tool that may run external commands
+// or write outside the workspace.
+func withConfirm() mcp.ToolOption {
+	return func(t *mcp.Tool) {
+		mcp.WithBoolean("confirm",
+			mcp.Description("When the server asks for confirmation ("+confirmEnv+"=on): set to true only after the user approved the action described by a 'confirmation_required' result (running an external command, writing outside the workspace, or sending a profile to an endpoint). Without it, such actions are not performed; the result describes them so the client can ask the user first."),
+			mcp.DefaultBool(false),
+		)(t)
+		mcp.WithString("confirm_token",
+			mcp.Description("When the server runs with "+confirmEnv+"=token: the one-time token the user read from the server log to approve a 'confirmation_required' action. 'confirm' is not enough in that mode."),
+		)(t)
+	}
+}
+
+// confirmationRequired reports an action that needs the user's approval before it is performed. The MCP
+// version implemented by the server has no elicitation requests, so the client is asked through this
+// structured tool result instead, like fileExistsError. It is also an error, so code deep below a handler
+// (e.g. the perf.data conversion) can return it; confirmableHandler turns it into the result.
+type confirmationRequired struct {
+	Code       string   `json:"error"`  // Always "confirmation_required"
+	Action     string   `json:"action"` // "spawn_process", "write_outside_workspace" or "send_to_endpoint"
+	Message    string   `json:"message"`
+	Tool       string   `json:"tool"`
+	Command    []string `json:"command,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	Workspace  []string `json:"workspace,omitempty"`
+	Suggestion string   `json:"suggestion"`
+}
+
+func (c *confirmationRequired) Error() string {
+	return c.Code + ": " + c.Message
+}
+
+// toolResult returns the confirmation request as a structured tool error.
+func (c *confirmationRequired) toolResult() *mcp.CallToolResult {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(c.Message)
+	}
+	return mcp.NewToolResultError(string(data))
+}
+
+// confirmMode returns the confirmation mode: "off" (the default), "on" or "token". An invalid value asks
+// for confirmation, since whoever set it meant to enable it.
+func confirmMode() string {
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv(confirmEnv))); value {
+	case "", "off":
+		return "off"
+	case "on", "token":
+		return value
+	default:
+		log.Printf("Warning: invalid %s=%q (expected 'off', 'on' or 'token'), asking for confirmation as with 'on'", confirmEnv, value)
+		return "on"
+	}
+}
+
+// pendingConfirmation is an action waiting for its token in the "token" confirmation mode.
+type pendingConfirmation struct {
+	action  string // See confirmationAction
+	expires time.Time
+}
+
+var (
+	confirmTokensMutex sync.Mutex
+	confirmTokens      = make(map[string]pendingConfirmation) // Token -> action
+)
+
+// confirmationAction identifies an action, so a token only approves the action it was issued for.
+func confirmationAction(tool, kind, detail string) string {
+	return tool + "\x00" + kind + "\x00" + detail
+}
+
+// confirmed reports whether the call carries the user's approval of an action. In the "token" mode, a
+// matching token is used up.
+func confirmed(args map[string]interface{}, action string) bool {
+	switch confirmMode() {
+	case "off":
+		return true
+	case "token":
+		token, _ := args["confirm_token"].(string)
+		confirmTokensMutex.Lock()
+		defer confirmTokensMutex.Unlock()
+		pending, ok := confirmTokens[token]
+		if !ok || pending.action != action || time.Now().After(pending.expires) {
+			return false
+		}
+		delete(confirmTokens, token)
+		return true
+	default:
+		confirm, _ := args["confirm"].(bool)
+		return confirm
+	}
+}
+
+// askForConfirmation sets the suggestion of a confirmation request, ending with alternative. In the "token"
+// mode it issues a token for the action and prints it only to the server log, out of the model's reach.
+func askForConfirmation(c *confirmationRequired, action, alternative string) *confirmationRequired {
+	c.Suggestion = fmt.Sprintf("Ask the user to approve it, then retry %s with 'confirm': true%s", c.Tool, alternative)
+	if confirmMode() != "token" {
+		return c
+	}
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("Warning: failed to generate a confirmation token: %v", err)
+		return c
+	}
+	token := hex.EncodeToString(raw)
+	confirmTokensMutex.Lock()
+	for t, pending := range confirmTokens {
+		if time.Now().After(pending.expires) {
+			delete(confirmTokens, t)
+		}
+	}
+	confirmTokens[token] = pendingConfirmation{action: action, expires: time.Now().Add(confirmTokenTTL)}
+	confirmTokensMutex.Unlock()
+	log.Printf("CONFIRMATION TOKEN for %s: %s (valid %s, one use). Give it to the client only if you approve: %s",
+		c.Tool, token, confirmTokenTTL, c.Message)
+	c.Suggestion = fmt.Sprintf("Ask the user to approve it and to provide the confirmation token printed in the server log, then retry %s with 'confirm_token' set to it%s", c.Tool, alternative)
+	return c
+}
+
+// workspaceDirs returns the directories of the workspace, with symlinks resolved.
+func workspaceDirs() []string {
+	dirs := make([]string, 0)
+	for _, dir := range filepath.SplitList(os.Getenv(workspaceEnv)) {
+		if dir != "" {
+			dirs = append(dirs, resolvePath(dir))
+		}
+	}
+	if len(dirs) == 0 {
+		if cwd, err := os.Getwd(); err == nil {
+			dirs = append(dirs, resolvePath(cwd))
+		}
+	}
+	return dirs
+}
+
+// resolvePath returns the absolute path with the symlinks of its longest existing prefix resolved, so a
+// symlink inside the workspace cannot point a write outside of it.
+func resolvePath(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	rest := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return path
+		} else {
+			rest = filepath.Join(filepath.Base(dir), rest)
+		}
+	}
+}
+
+// insideWorkspace reports whether path is in one of the workspace directories.
+func insideWorkspace(path string, workspace []string) bool {
+	resolved := resolvePath(path)
+	for _, dir := range workspace {
+		if rel, err := filepath.Rel(dir, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmSpawn returns a confirmation request for running an external command, unless the call is
+// confirmed. background tells whether the process keeps running after the call returns. command is shown to
+// the user and identifies the action, so it must not contain per-call temporary file names.
+func confirmSpawn(tool string, args map[string]interface{}, command []string, background bool) *confirmationRequired {
+	action := confirmationAction(tool, "spawn_process", strings.Join(command, "\x00"))
+	if confirmed(args, action) {
+		return nil
+	}
+	log.Printf("%s: asking for confirmation before running: %s", tool, strings.Join(command, " "))
+	message := fmt.Sprintf("%s runs an external command: %s", tool, strings.Join(command, " "))
+	if background {
+		message = fmt.Sprintf("%s starts a background process that keeps running until disconnect_pprof_session is called: %s", tool, strings.Join(command, " "))
+	}
+	return askForConfirmation(&confirmationRequired{
+		Code:    "confirmation_required",
+		Action:  "spawn_process",
+		Message: message,
+		Tool:    tool,
+		Command: command,
+	}, action, fmt.Sprintf(" (or set %s=off on the server)", confirmEnv))
+}
+
+// confirmWrite returns a confirmation request for writing path when it is outside the workspace, unless
+// the call is confirmed.
+func confirmWrite(tool string, args map[string]interface{}, path string) *confirmationRequired {
+	workspace := workspaceDirs()
+	if insideWorkspace(path, workspace) {
+		return nil
+	}
+	action := confirmationAction(tool, "write_outside_workspace", path)
+	if confirmed(args, action) {
+		return nil
+	}
+	log.Printf("%s: asking for confirmation before writing outside the workspace: %s", tool, path)
+	return askForConfirmation(&confirmationRequired{
+		Code:      "confirmation_required",
+		Action:    "write_outside_workspace",
+		Message:   fmt.Sprintf("%s would write '%s', which is outside the workspace", tool, path),
+		Tool:      tool,
+		Path:      path,
+		Workspace: workspace,
+	}, action, fmt.Sprintf(", or choose a path inside the workspace (%s)", workspaceEnv))
+}
+
+// confirmPush returns a confirmation request for sending profile data to endpoint, outside the machine,
+// unless the call is confirmed.
+func confirmPush(tool string, args map[string]interface{}, endpoint string) *confirmationRequired {
+	action := confirmationAction(tool, "send_to_endpoint", endpoint)
+	if confirmed(args, action) {
+		return nil
+	}
+	log.Printf("%s: asking for confirmation before sending the profile to: %s", tool, endpoint)
+	return askForConfirmation(&confirmationRequired{
+		Code:     "confirmation_required",
+		Action:   "send_to_endpoint",
+		Message:  fmt.Sprintf("%s would send the profile (function names, file paths and labels) to '%s'", tool, endpoint),
+		Tool:     tool,
+		Endpoint: endpoint,
+	}, action, fmt.Sprintf(" (or set %s=off on the server)", confirmEnv))
+}
+
+// confirmationScope is the tool call that code below a handler asks confirmations for.
+type confirmationScope struct {
+	tool string
+	args map[string]interface{}
+}
+
+type confirmationScopeKey struct{}
+
+// withConfirmationScope returns ctx carrying the tool call, for confirmSpawnContext.
+func withConfirmationScope(ctx context.Context, tool string, args map[string]interface{}) context.Context {
+	return context.WithValue(ctx, confirmationScopeKey{}, confirmationScope{tool: tool, args: args})
+}
+
+// confirmSpawnContext is confirmSpawn for the tool call in ctx, for code that has no access to the arguments,
+// such as loaders and the post-processing hook. Without a tool call in ctx, the action is never confirmed.
+func confirmSpawnContext(ctx context.Context, command []string) *confirmationRequired {
+	scope, _ := ctx.Value(confirmationScopeKey{}).(confirmationScope)
+	return confirmSpawn(scope.tool, scope.args, command, false)
+}
+
+// confirmableHandler puts the tool call in the handler's context (see confirmSpawnContext) and returns a
+// *confirmationRequired error as the structured result.
+func confirmableHandler(toolName string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := handler(withConfirmationScope(ctx, toolName, request.Params.Arguments), request)
+		var confirmErr *confirmationRequired
+		if errors.As(err, &confirmErr) {
+			return confirmErr.toolResult(), nil
+		}
+		return result, err
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmMode(t *testing.T) {
+	for value, want := range map[string]string{"": "off", "off": "off", "ON": "on", " token ": "token", "yes": "on"} {
+		t.Setenv(confirmEnv, value)
+		if got := confirmMode(); got != want {
+			t.Errorf("%s=%q: expected mode %s, got %s", confirmEnv, value, want, got)
+		}
+	}
+
+	// By default, the tools that always ran go tool pprof do so without asking
+	command := []string{"go", "tool", "pprof", "-http=localhost:0", "cpu.pprof"}
+	t.Setenv(confirmEnv, "")
+	for _, tool := range []string{"generate_flamegraph", "open_interactive_pprof"} {
+		if c := confirmSpawn(tool, map[string]interface{}{}, command, true); c != nil {
+			t.Errorf("Expected %s not to ask for confirmation by default, got %v", tool, c)
+		}
+	}
+	t.Setenv(confirmEnv, "on")
+	if c := confirmSpawn("open_interactive_pprof", map[string]interface{}{}, command, true); c == nil || c.Action != "spawn_process" {
+		t.Errorf("Expected a confirmation request with confirmations on, got %v", c)
+	}
+	if c := confirmSpawn("open_interactive_pprof", map[string]interface{}{"confirm": true}, command, true); c != nil {
+		t.Errorf("Expected 'confirm' to approve the command, got %v", c)
+	}
+}
+
+// issueToken asks for confirmation of command in the "token" mode and returns the token the server logged.
+func issueToken(t *testing.T, command []string) string {
+	t.Helper()
+	if c := confirmSpawn("generate_flamegraph", map[string]interface{}{}, command, false); c == nil || !strings.Contains(c.Suggestion, "confirm_token") {
+		t.Fatalf("Expected a request for a confirmation token, got %v", c)
+	}
+	action := confirmationAction("generate_flamegraph", "spawn_process", strings.Join(command, "\x00"))
+	confirmTokensMutex.Lock()
+	defer confirmTokensMutex.Unlock()
+	for token, pending := range confirmTokens {
+		if pending.action == action {
+			return token
+		}
+	}
+	t.Fatal("Expected a token for the command")
+	return ""
+}
+
+func TestConfirmationTokens(t *testing.T) {
+	t.Setenv(confirmEnv, "token")
+	command := []string{"go", "tool", "pprof", "-svg", "cpu.pprof"}
+	spawn := func(args map[string]interface{}, command []string) *confirmationRequired {
+		return confirmSpawn("generate_flamegraph", args, command, false)
+	}
+
+	token := issueToken(t, command)
+	// 'confirm' is not enough, nor is the token of another command
+	if c := spawn(map[string]interface{}{"confirm": true}, command); c == nil {
+		t.Error("Expected 'confirm' not to approve the command in the token mode")
+	}
+	if c := spawn(map[string]interface{}{"confirm_token": token}, []string{"sh", "-c", "rm -rf /"}); c == nil {
+		t.Error("Expected the token not to approve another command")
+	}
+	if c := spawn(map[string]interface{}{"confirm_token": token}, command); c != nil {
+		t.Errorf("Expected the token to approve the command, got %v", c)
+	}
+	// A token is used up by the call it approved
+	if c := spawn(map[string]interface{}{"confirm_token": token}, command); c == nil {
+		t.Error("Expected a reused token to be rejected")
+	}
+
+	// An expired token is rejected, and dropped when the next one is issued
+	token = issueToken(t, command)
+	confirmTokensMutex.Lock()
+	confirmTokens[token] = pendingConfirmation{action: confirmTokens[token].action, expires: time.Now().Add(-time.Second)}
+	confirmTokensMutex.Unlock()
+	if c := spawn(map[string]interface{}{"confirm_token": token}, command); c == nil {
+		t.Error("Expected an expired token to be rejected")
+	}
+	issueToken(t, command)
+	confirmTokensMutex.Lock()
+	_, kept := confirmTokens[token]
+	confirmTokensMutex.Unlock()
+	if kept {
+		t.Error("Expected the expired token to be dropped")
+	}
+}
+
+func TestConfirmWriteWorkspace(t *testing.T) {
+	t.Setenv(confirmEnv, "on")
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	t.Setenv(workspaceEnv, workspace)
+	if err := os.Mkdir(filepath.Join(workspace, "out"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// A symlink inside the workspace pointing outside of it
+	if err := os.Symlink(outside, filepath.Join(workspace, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path   string
+		inside bool
+	}{
+		{filepath.Join(workspace, "flame.svg"), true},
+		{filepath.Join(workspace, "out", "new", "flame.svg"), true}, // Directories that do not exist yet
+		{filepath.Join(workspace, "out", "..", "flame.svg"), true},
+		{filepath.Join(workspace, "..", filepath.Base(outside), "flame.svg"), false},
+		{workspace + "/out/../../" + filepath.Base(outside) + "/flame.svg", false},
+		{workspace + "-sibling/flame.svg", false}, // Shares the prefix of the workspace
+		{filepath.Join(workspace, "escape", "flame.svg"), false},
+		{filepath.Join(workspace, "escape", "new", "flame.svg"), false},
+		{filepath.Join(outside, "flame.svg"), false},
+	}
+	for _, tc := range cases {
+		if got := insideWorkspace(tc.path, workspaceDirs()); got != tc.inside {
+			t.Errorf("insideWorkspace(%s): expected %v, got %v", tc.path, tc.inside, got)
+		}
+		c := confirmWrite("generate_flamegraph", map[string]interface{}{}, tc.path)
+		if asked := c != nil; asked == tc.inside {
+			t.Errorf("confirmWrite(%s): expected a confirmation request %v, got %v", tc.path, !tc.inside, c)
+		}
+	}
+
+	// A workspace given through a symlink is resolved too
+	link := filepath.Join(t.TempDir(), "workspace")
+	if err := os.Symlink(workspace, link); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(workspaceEnv, link+string(filepath.ListSeparator)+outside)
+	for _, path := range []string{filepath.Join(workspace, "flame.svg"), filepath.Join(outside, "flame.svg")} {
+		if !insideWorkspace(path, workspaceDirs()) {
+			t.Errorf("Expected %s inside the workspace %s", path, os.Getenv(workspaceEnv))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// handleDiffFlamegraphStructure compares the call trees of two profiles, reporting subtrees that moved to another
+// parent separately from value changes.
+func handleDiffFlamegraphStructure(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	oldURIStr, ok := args["old_profile_uri"].(string)
+	if !ok || oldURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: old_profile_uri (string)")
+	}
+	newURIStr, ok := args["new_profile_uri"].(string)
+	if !ok || newURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: new_profile_uri (string)")
+	}
+	profileType, ok := args["profile_type"].(string)
+	if !ok || profileType == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_type (string)")
+	}
+	sampleType, _ := args["sample_type"].(string)
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok || topNFloat <= 0 {
+		topNFloat = 10.0
+	}
+	topN := int(topNFloat)
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling diff_flamegraph_structure: OldURI=%s, NewURI=%s, Type=%s, SampleType=%s, TopN=%d, Format=%s",
+		oldURIStr, newURIStr, profileType, sampleType, topN, outputFormat)
+
+	oldProf, err := loadProfile(ctx, oldURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	newProf, err := loadProfile(ctx, newURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与 diff_profiles 相同：以旧 profile 为基准匹配改名的函数，避免其被误报为移动或新增
+	mappedNew, matches := mapRenamedFunctions(args, newProf, oldProf)
+	diff, err := analyzer.DiffFlameGraphs(oldProf, mappedNew, profileType, sampleType, topN)
+	if err != nil {
+		return nil, err
+	}
+	result, err := analyzer.FormatFlameGraphDiff(diff, outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "diff_flamegraph_structure-"+diff.ProfileType, outputFormat, result)
+
+	return withSameProfileWarning(withRecoveryWarnings(withFunctionMatches(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), matches), oldProf, newProf), oldProf, newProf), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// diffIgnoreEnv holds the server-wide, comma-separated list of function regexes excluded from diff reports,
+// e.g. "runtime\.futex,runtime\.netpoll,syscall\.Syscall". It is read on every request.
+const diffIgnoreEnv = "PPROF_ANALYZER_DIFF_IGNORE"
+
+// diffIgnorePatterns combines the server-wide ignore list with the per-request 'ignore_functions' argument.
+// Setting 'use_default_ignore' to false drops the server-wide list for one request.
+func diffIgnorePatterns(args map[string]interface{}) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0)
+	if useDefault, ok := args["use_default_ignore"].(bool); !ok || useDefault {
+		defaults, err := analyzer.ParseIgnoreList(os.Getenv(diffIgnoreEnv))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", diffIgnoreEnv, err)
+		}
+		patterns = append(patterns, defaults...)
+	}
+	if spec, ok := args["ignore_functions"].(string); ok {
+		extra, err := analyzer.ParseIgnoreList(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore_functions: %w", err)
+		}
+		patterns = append(patterns, extra...)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// handleDiffFlamegraph merges the flame graphs of two profiles into one differential flame graph JSON tree,
+// each node carrying its base and new value and their delta, for red/blue differential rendering.
+func handleDiffFlamegraph(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	oldURIStr, ok := args["old_profile_uri"].(string)
+	if !ok || oldURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: old_profile_uri (string)")
+	}
+	newURIStr, ok := args["new_profile_uri"].(string)
+	if !ok || newURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: new_profile_uri (string)")
+	}
+	profileType, ok := args["profile_type"].(string)
+	if !ok || profileType == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_type (string)")
+	}
+	sampleType, _ := args["sample_type"].(string)
+	normalize, _ := args["normalize"].(bool)
+
+	log.Printf("Handling diff_flamegraph: OldURI=%s, NewURI=%s, Type=%s, SampleType=%s, Normalize=%t",
+		oldURIStr, newURIStr, profileType, sampleType, normalize)
+
+	oldProf, err := loadProfile(ctx, oldURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	newProf, err := loadProfile(ctx, newURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与 diff_flamegraph_structure 相同：以旧 profile 为基准匹配改名的函数，使其调用路径在两棵树中对齐
+	mappedNew, matches := mapRenamedFunctions(args, newProf, oldProf)
+	result, err := analyzer.FormatDiffFlameGraphJSON(oldProf, mappedNew, profileType, sampleType, normalize)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "diff_flamegraph-"+analyzer.ResolveProfileType(profileType), "json", result)
+
+	return withSameProfileWarning(withRecoveryWarnings(withFunctionMatches(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), matches), oldProf, newProf), oldProf, newProf), nil
+}
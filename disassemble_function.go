@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxDisassemblyLines caps the returned assembly: a broad regex can match hundreds of functions.
+const maxDisassemblyLines = 2000
+
+// handleDisassembleFunction returns the assembly of the functions matching a regex, annotated with the
+// profile's flat and cum values per instruction, by running 'go tool pprof -disasm' against the binary.
+func handleDisassembleFunction(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	binaryPath, ok := args["binary_path"].(string)
+	if !ok || binaryPath == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: binary_path (string)")
+	}
+	functionRegex, ok := args["function_regex"].(string)
+	if !ok || functionRegex == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: function_regex (string)")
+	}
+	sampleType, _ := args["sample_type"].(string)
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "markdown"
+	}
+	if outputFormat != "text" && outputFormat != "markdown" {
+		return nil, fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+
+	log.Printf("Handling disassemble_function: URI=%s, Binary=%s, Regex=%q, SampleType=%q, Format=%s",
+		profileURIStr, binaryPath, functionRegex, sampleType, outputFormat)
+
+	binaryPath, err = filepath.Abs(binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for '%s': %w", binaryPath, err)
+	}
+	if info, err := os.Stat(binaryPath); err != nil {
+		return nil, fmt.Errorf("invalid binary_path: %w", err)
+	} else if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("invalid binary_path '%s': not a regular file", binaryPath)
+	}
+
+	inputFilePath, cleanup, err := getProfileAsFile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile file for disassembly: %w", err)
+	}
+	defer cleanup()
+
+	cmdArgs := []string{"tool", "pprof", "-disasm=" + functionRegex}
+	if sampleType != "" {
+		cmdArgs = append(cmdArgs, "-sample_index="+sampleType)
+	}
+	// 运行 go tool pprof 前需要用户确认；预览中以原始 URI 代替临时文件
+	preview := append([]string{"go"}, cmdArgs...)
+	preview = append(preview, binaryPath, profileURIStr)
+	if confirmErr := confirmSpawn("disassemble_function", args, preview, false); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+	cmdArgs = append(cmdArgs, binaryPath, inputFilePath)
+
+	log.Printf("Executing command: go %s", strings.Join(cmdArgs, " "))
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", cmdArgs...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		log.Printf("Error executing 'go tool pprof': %v\nOutput:\n%s", err, stderr.String())
+		return nil, fmt.Errorf("failed to disassemble '%s': %w. Output: %s", functionRegex, err, strings.TrimSpace(stderr.String()))
+	}
+
+	result := formatDisassembly(string(output), outputFormat)
+	hookReport := saveAnalysisResult(ctx, analysisID, "disassemble_function", outputFormat, result)
+
+	content := []mcp.Content{mcp.TextContent{Type: "text", Text: result}}
+	// 二进制与 profile 不匹配时，地址对应的指令是错的：提醒而不是静默返回
+	if warning := binaryMismatchWarning(inputFilePath, binaryPath); warning != "" {
+		content = append([]mcp.Content{mcp.TextContent{Type: "text", Text: "WARNING: " + warning}}, content...)
+	}
+	if pprofWarnings := strings.TrimSpace(stderr.String()); pprofWarnings != "" {
+		content = append(content, mcp.TextContent{Type: "text", Text: "pprof output:\n" + pprofWarnings})
+	}
+	return withPostProcessReports(&mcp.CallToolResult{Content: content}, hookReport), nil
+}
+
+// formatDisassembly caps the output of 'go tool pprof -disasm' at maxDisassemblyLines lines and wraps it in
+// a code block for "markdown".
+func formatDisassembly(output, format string) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > maxDisassemblyLines {
+		omitted := len(lines) - maxDisassemblyLines
+		lines = append(lines[:maxDisassemblyLines], fmt.Sprintf("... %d more lines omitted; use a more specific function_regex", omitted))
+	}
+	text := strings.Join(lines, "\n") + "\n"
+	if format == "markdown" {
+		return "```text\n" + text + "```\n"
+	}
+	return text
+}
+
+// binaryMismatchWarning compares the build ID of the profile's main binary with the one of binaryPath. It
+// returns a warning when both are known and differ, as pprof then disassembles the wrong code silently.
+func binaryMismatchWarning(profilePath, binaryPath string) string {
+	f, err := os.Open(profilePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil || len(p.Mapping) == 0 || p.Mapping[0].BuildID == "" {
+		return ""
+	}
+	binaryID, err := elfBuildID(binaryPath)
+	if err != nil || binaryID == "" {
+		return ""
+	}
+	if profileID := p.Mapping[0].BuildID; !strings.EqualFold(profileID, binaryID) {
+		return fmt.Sprintf("the profile was recorded from a binary with build ID %s, but '%s' has build ID %s; the annotations are only correct for the exact binary that was profiled", profileID, binaryPath, binaryID)
+	}
+	return ""
+}
+
+// elfBuildID returns the GNU build ID of an ELF binary, as recorded in profile mappings, or "" when it has
+// none. Other binary formats are not supported.
+func elfBuildID(path string) (string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	section := f.Section(".note.gnu.build-id")
+	if section == nil {
+		return "", nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return "", err
+	}
+	// Note layout: namesz, descsz, type (4 bytes each), name padded to 4 bytes, then the ID
+	if len(data) < 12 {
+		return "", nil
+	}
+	nameSize := f.ByteOrder.Uint32(data[0:4])
+	descSize := f.ByteOrder.Uint32(data[4:8])
+	start := 12 + (uint64(nameSize)+3)&^3
+	if uint64(len(data)) < start+uint64(descSize) {
+		return "", nil
+	}
+	return hex.EncodeToString(data[start : start+uint64(descSize)]), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestFormatDisassembly(t *testing.T) {
+	if got := formatDisassembly("ROUTINE main.f\n  MOVQ AX, BX\n", "markdown"); got != "```text\nROUTINE main.f\n  MOVQ AX, BX\n```\n" {
+		t.Errorf("Unexpected markdown output: %q", got)
+	}
+	long := strings.Repeat("  NOPL 0(AX)\n", maxDisassemblyLines+5)
+	lines := strings.Split(strings.TrimRight(formatDisassembly(long, "text"), "\n"), "\n")
+	if len(lines) != maxDisassemblyLines+1 || !strings.HasPrefix(lines[maxDisassemblyLines], "... 5 more lines omitted") {
+		t.Errorf("Expected the output to be capped, got %d lines ending with %q", len(lines), lines[len(lines)-1])
+	}
+}
+
+func TestBinaryMismatchWarning(t *testing.T) {
+	// The test binary itself is an ELF binary with a GNU build ID on Linux
+	binaryPath, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryID, err := elfBuildID(binaryPath)
+	if err != nil || binaryID == "" {
+		t.Skipf("No GNU build ID in the test binary: %v", err)
+	}
+
+	writeProfile := func(buildID string) string {
+		p := &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+			Mapping:    []*profile.Mapping{{ID: 1, File: binaryPath, BuildID: buildID}},
+		}
+		path := filepath.Join(t.TempDir(), fmt.Sprintf("%s.pb.gz", buildID))
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := p.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	if warning := binaryMismatchWarning(writeProfile(strings.ToUpper(binaryID)), binaryPath); warning != "" {
+		t.Errorf("Expected no warning for the same build ID, got %q", warning)
+	}
+	if warning := binaryMismatchWarning(writeProfile("0123abcd"), binaryPath); !strings.Contains(warning, "build ID 0123abcd") {
+		t.Errorf("Expected a build ID mismatch warning, got %q", warning)
+	}
+	// Unknown build IDs are not reported
+	if warning := binaryMismatchWarning(writeProfile(""), binaryPath); warning != "" {
+		t.Errorf("Expected no warning without a build ID, got %q", warning)
+	}
+}
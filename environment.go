@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Timeouts of the checks of check_environment: a slow dependency is reported, not waited for.
+const (
+	environmentCommandTimeout  = 10 * time.Second
+	environmentEndpointTimeout = 5 * time.Second
+)
+
+// Statuses of an environment check.
+const (
+	checkOK            = "ok"
+	checkMissing       = "missing"        // An external tool is not installed
+	checkError         = "error"          // Installed or configured, but not working
+	checkNotConfigured = "not_configured" // An optional setting is not set
+)
+
+// environmentCheck is the outcome of one check of check_environment.
+type environmentCheck struct {
+	Name     string `json:"name"`
+	Category string `json:"category"` // "tool", "storage" or "endpoint"
+	Status   string `json:"status"`
+	Detail   string `json:"detail"` // Version, path or error
+	Fix      string `json:"fix,omitempty"`
+}
+
+// environmentFeature is a row of the readiness matrix: a feature and the checks it needs.
+type environmentFeature struct {
+	Feature string   `json:"feature"`
+	UsedBy  string   `json:"used_by"`
+	Needs   []string `json:"needs"`
+	Ready   bool     `json:"ready"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// environmentReport is the result of check_environment.
+type environmentReport struct {
+	Ready    bool                 `json:"ready"` // Every configured setting works (missing optional tools aside)
+	Checks   []environmentCheck   `json:"checks"`
+	Features []environmentFeature `json:"features"`
+}
+
+// environmentFeatures lists the features depending on external tools or settings, with the checks they need.
+// The other analyses are done in-process and are always available.
+var environmentFeatures = []environmentFeature{
+	{Feature: "In-process analyses", UsedBy: "analyze_pprof and the other analysis tools", Needs: []string{"temporary directory"}},
+	{Feature: "SVG flame graphs", UsedBy: "generate_flamegraph", Needs: []string{"go", "dot"}},
+	{Feature: "Interactive pprof UI", UsedBy: "open_interactive_pprof", Needs: []string{"go", "dot"}},
+	{Feature: "Disassembly", UsedBy: "disassemble_function", Needs: []string{"go"}},
+	{Feature: "Execution trace files", UsedBy: "analyze_trace", Needs: []string{"go"}},
+	{Feature: "perf.data files", UsedBy: "profile_uri", Needs: []string{"perf_to_profile"}},
+	{Feature: "Java Flight Recorder recordings", UsedBy: "profile_uri", Needs: []string{"jfr"}},
+	{Feature: "Persisted analyses", UsedBy: "analysis_id, export_bundle", Needs: []string{"storage"}},
+}
+
+// checkCommand looks the tool up (at path when it is configured, in PATH otherwise) and runs it with
+// versionArgs, returning its first line of output as the version. Only these fixed, read-only version
+// commands are run, so the check needs no confirmation.
+func checkCommand(ctx context.Context, name, path, fix string, versionArgs ...string) environmentCheck {
+	check := environmentCheck{Name: name, Category: "tool"}
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath(name); err != nil {
+			check.Status, check.Detail, check.Fix = checkMissing, "not found in PATH", fix
+			return check
+		}
+	} else if _, err := exec.LookPath(path); err != nil {
+		check.Status, check.Detail, check.Fix = checkError, fmt.Sprintf("configured path: %v", err), fix
+		return check
+	}
+	if len(versionArgs) == 0 {
+		check.Status, check.Detail = checkOK, path
+		return check
+	}
+	ctx, cancel := context.WithTimeout(ctx, environmentCommandTimeout)
+	defer cancel()
+	// dot -V writes its version to stderr
+	output, err := exec.CommandContext(ctx, path, versionArgs...).CombinedOutput()
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if err != nil {
+		check.Status, check.Fix = checkError, fix
+		check.Detail = fmt.Sprintf("%s: '%s %s' failed: %v", path, name, strings.Join(versionArgs, " "), err)
+		if firstLine != "" {
+			check.Detail += ": " + firstLine
+		}
+		return check
+	}
+	check.Status, check.Detail = checkOK, fmt.Sprintf("%s (%s)", firstLine, path)
+	return check
+}
+
+// checkTempDir verifies that temporary files (downloads, inline profiles, conversions) can be written.
+func checkTempDir() environmentCheck {
+	check := environmentCheck{Name: "temporary directory", Category: "storage"}
+	file, err := os.CreateTemp("", "pprof-check-*")
+	if err != nil {
+		check.Status, check.Detail = checkError, fmt.Sprintf("%s is not writable: %v", os.TempDir(), err)
+		check.Fix = "set TMPDIR to a writable directory"
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+	check.Status, check.Detail = checkOK, os.TempDir()+" is writable"
+	return check
+}
+
+// checkStorage verifies that the workspace store (storageEnv) is reachable, writable and readable by putting,
+// getting and deleting a probe object.
+func checkStorage(ctx context.Context) environmentCheck {
+	check := environmentCheck{Name: "storage", Category: "storage"}
+	if err := initWorkspaceStore(); err != nil {
+		check.Status, check.Detail, check.Fix = checkError, err.Error(), "fix "+storageEnv
+		return check
+	}
+	store := workspaceStore()
+	if !artifactsStored() {
+		check.Status = checkNotConfigured
+		check.Detail = fmt.Sprintf("manifests are kept in %s and artifacts are not copied", store.Name())
+		check.Fix = "set " + storageEnv + " to keep analyses across restarts and hosts"
+		return check
+	}
+	ctx, cancel := context.WithTimeout(ctx, environmentEndpointTimeout)
+	defer cancel()
+	const probeKey = "check_environment/probe"
+	err := store.Put(ctx, probeKey, []byte("probe"))
+	if err == nil {
+		if _, err = store.Get(ctx, probeKey); err == nil {
+			err = store.Delete(ctx, probeKey)
+		}
+	}
+	if err != nil {
+		check.Status, check.Detail = checkError, fmt.Sprintf("%s: %v", store.Name(), err)
+		check.Fix = "check the credentials and permissions, and " + storageEndpointEnv + " for custom endpoints"
+		return check
+	}
+	check.Status, check.Detail = checkOK, store.Name()+" is writable and readable"
+	return check
+}
+
+// checkWritableFile verifies that the file configured by env can be appended to, without creating it.
+func checkWritableFile(env string) environmentCheck {
+	check := environmentCheck{Name: env, Category: "storage"}
+	path := strings.TrimSpace(os.Getenv(env))
+	if path == "" {
+		check.Status, check.Detail = checkNotConfigured, "not set"
+		return check
+	}
+	if _, err := os.Stat(path); err == nil {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			check.Status, check.Detail = checkError, err.Error()
+			return check
+		}
+		file.Close()
+	} else if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+		check.Status, check.Detail = checkError, fmt.Sprintf("the directory of '%s' does not exist", path)
+		return check
+	}
+	check.Status, check.Detail = checkOK, path
+	return check
+}
+
+// checkEndpoint verifies that an HTTP endpoint answers; any HTTP response, even an error status, shows it is
+// reachable, and the status is reported.
+func checkEndpoint(ctx context.Context, endpoint string) environmentCheck {
+	check := environmentCheck{Name: endpoint, Category: "endpoint"}
+	ctx, cancel := context.WithTimeout(ctx, environmentEndpointTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil || (req.URL.Scheme != "http" && req.URL.Scheme != "https") {
+		check.Status, check.Detail = checkError, "not an http:// or https:// URL"
+		return check
+	}
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		check.Status, check.Detail = checkError, fmt.Sprintf("unreachable: %v", err)
+		return check
+	}
+	resp.Body.Close()
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("reachable: %s in %s", resp.Status, time.Since(start).Round(time.Millisecond))
+	return check
+}
+
+// checkEnvironment runs all checks concurrently and computes the readiness matrix.
+func checkEnvironment(ctx context.Context, endpoints []string) *environmentReport {
+	perfToProfile := os.Getenv(perfToProfileEnv)
+	jfr, _ := jfrTool()
+	checks := []func() environmentCheck{
+		func() environmentCheck {
+			return checkCommand(ctx, "go", "", "install Go (https://go.dev/dl/) and add it to PATH", "version")
+		},
+		func() environmentCheck {
+			return checkCommand(ctx, "dot", "", "install Graphviz, e.g. 'brew install graphviz' or 'apt-get install graphviz'", "-V")
+		},
+		func() environmentCheck {
+			return checkCommand(ctx, "perf_to_profile", perfToProfile, "install https://github.com/google/perf_data_converter or set "+perfToProfileEnv)
+		},
+		func() environmentCheck {
+			return checkCommand(ctx, "jfr", jfr, "install a JDK 11+ and add its bin directory to PATH, set JAVA_HOME or "+jfrToolEnv, "version")
+		},
+		checkTempDir,
+		func() environmentCheck { return checkStorage(ctx) },
+		func() environmentCheck { return checkWritableFile(recordFileEnv) },
+	}
+	for _, endpoint := range endpoints {
+		endpoint := endpoint
+		checks = append(checks, func() environmentCheck { return checkEndpoint(ctx, endpoint) })
+	}
+
+	report := &environmentReport{Ready: true, Checks: make([]environmentCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() environmentCheck) {
+			defer wg.Done()
+			report.Checks[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	status := make(map[string]string, len(report.Checks))
+	for _, check := range report.Checks {
+		status[check.Name] = check.Status
+		// Optional tools may be missing and settings unset; what is configured must work
+		if check.Status == checkError {
+			report.Ready = false
+		}
+	}
+	for _, feature := range environmentFeatures {
+		feature.Ready = true
+		for _, need := range feature.Needs {
+			// Without storageEnv, analyses are kept in the temporary directory: persisted only on this host
+			if s := status[need]; s != checkOK && !(need == "storage" && s == checkNotConfigured) {
+				feature.Ready = false
+				feature.Missing = append(feature.Missing, need)
+			}
+		}
+		report.Features = append(report.Features, feature)
+	}
+	return report
+}
+
+// formatEnvironmentReport formats the report as text: the checks, then the readiness matrix.
+func formatEnvironmentReport(report *environmentReport) string {
+	var b strings.Builder
+	if report.Ready {
+		b.WriteString("Environment: ready\n")
+	} else {
+		b.WriteString("Environment: NOT ready (see the errors below)\n")
+	}
+	b.WriteString("\nChecks:\n")
+	for _, check := range report.Checks {
+		fmt.Fprintf(&b, "- [%s] %s (%s): %s\n", check.Status, check.Name, check.Category, check.Detail)
+		if check.Fix != "" && check.Status != checkOK {
+			fmt.Fprintf(&b, "  Fix: %s\n", check.Fix)
+		}
+	}
+	b.WriteString("\nFeatures:\n")
+	for _, feature := range report.Features {
+		state := "ready"
+		if !feature.Ready {
+			state = "unavailable, needs " + strings.Join(feature.Missing, ", ")
+		}
+		fmt.Fprintf(&b, "- %s (%s): %s\n", feature.Feature, feature.UsedBy, state)
+	}
+	return b.String()
+}
+
+// handleCheckEnvironment verifies the external dependencies (go, dot, perf_to_profile, jfr), the temporary
+// directory, the configured storage and record file, and optionally the reachability of endpoints, so setup
+// problems are diagnosed in one call instead of failing in the middle of an analysis.
+func handleCheckEnvironment(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+	endpointsSpec, _ := args["endpoints"].(string)
+	outputFormat, _ := args["output_format"].(string)
+	endpoints := parseFleetTargets(endpointsSpec)
+	log.Printf("Handling check_environment: %d endpoints", len(endpoints))
+
+	report := checkEnvironment(ctx, endpoints)
+	if outputFormat == "json" {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return nil, fmt.Errorf("failed to encode the environment report: %w", err)
+		}
+		return mcp.NewToolResultText(buf.String()), nil
+	}
+	return mcp.NewToolResultText(formatEnvironmentReport(report)), nil
+}
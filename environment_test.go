@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	bin := t.TempDir()
+	scripts := map[string]string{
+		"go":  "#!/bin/sh\necho 'go version go1.23.3 linux/amd64'\n",
+		"dot": "#!/bin/sh\necho 'dot - graphviz version 2.43.0 (0)' >&2\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("JAVA_HOME", "")
+	t.Setenv(jfrToolEnv, "")
+	t.Setenv(perfToProfileEnv, filepath.Join(bin, "perf_to_profile"))
+	t.Setenv(recordFileEnv, filepath.Join(t.TempDir(), "record.jsonl"))
+
+	target := httptest.NewServer(http.NotFoundHandler())
+	defer target.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	report := checkEnvironment(context.Background(), []string{target.URL, closed.URL, "ftp://example.com"})
+	checks := make(map[string]environmentCheck)
+	for _, check := range report.Checks {
+		checks[check.Name] = check
+	}
+	for name, want := range map[string]string{
+		"go":                  checkOK,
+		"dot":                 checkOK,
+		"perf_to_profile":     checkError, // Configured, but not there
+		"jfr":                 checkMissing,
+		"temporary directory": checkOK,
+		recordFileEnv:         checkOK,
+		target.URL:            checkOK,
+		closed.URL:            checkError,
+		"ftp://example.com":   checkError,
+	} {
+		if got := checks[name].Status; got != want {
+			t.Errorf("Check %s: status %q (%s), want %q", name, got, checks[name].Detail, want)
+		}
+	}
+	if !strings.Contains(checks["go"].Detail, "go1.23.3") || !strings.Contains(checks["dot"].Detail, "graphviz version 2.43.0") {
+		t.Errorf("Expected the versions of go and dot, got %q and %q", checks["go"].Detail, checks["dot"].Detail)
+	}
+	if !strings.Contains(checks[target.URL].Detail, "404") {
+		t.Errorf("Expected the status of a reachable endpoint, got %q", checks[target.URL].Detail)
+	}
+	if checks["jfr"].Fix == "" {
+		t.Errorf("Expected a fix for the missing jfr tool")
+	}
+	if report.Ready {
+		t.Errorf("Expected the environment not to be ready with failing checks")
+	}
+
+	features := make(map[string]environmentFeature)
+	for _, feature := range report.Features {
+		features[feature.Feature] = feature
+	}
+	if !features["SVG flame graphs"].Ready || !features["In-process analyses"].Ready {
+		t.Errorf("Expected flame graphs and analyses to be ready: %+v", report.Features)
+	}
+	if f := features["Java Flight Recorder recordings"]; f.Ready || len(f.Missing) != 1 || f.Missing[0] != "jfr" {
+		t.Errorf("Expected JFR recordings to need jfr, got %+v", f)
+	}
+
+	text := formatEnvironmentReport(report)
+	if !strings.Contains(text, "Environment: NOT ready") || !strings.Contains(text, "- [missing] jfr (tool): not found in PATH") ||
+		!strings.Contains(text, "Java Flight Recorder recordings (profile_uri): unavailable, needs jfr") {
+		t.Errorf("Unexpected report:\n%s", text)
+	}
+}
+
+func TestHandleCheckEnvironmentJSON(t *testing.T) {
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{"output_format": "json"}
+	result, err := handleCheckEnvironment(context.Background(), request)
+	if err != nil {
+		t.Fatalf("handleCheckEnvironment failed: %v", err)
+	}
+	var report environmentReport
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+		t.Fatalf("Expected a JSON report: %v", err)
+	}
+	if len(report.Checks) != 7 || len(report.Features) != len(environmentFeatures) {
+		t.Errorf("Expected 7 checks and %d features, got %d and %d", len(environmentFeatures), len(report.Checks), len(report.Features))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// parseMergeProfileURIs splits the 'merge_profile_uris' argument, separated by commas or newlines (not spaces,
+// which may surround the '|' of candidate lists).
+func parseMergeProfileURIs(s string) []string {
+	uris := make([]string, 0)
+	for _, uri := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// handleExportProfile merges, filters and trims a profile like the analysis tools do in memory, and writes the
+// result back as a gzipped pprof proto, so that it can be saved or consumed by other tools.
+func handleExportProfile(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	mergeURIsStr, _ := args["merge_profile_uris"].(string)
+	mergeURIs := parseMergeProfileURIs(mergeURIsStr)
+	for _, uri := range mergeURIs {
+		if err := validateProfileURI(uri); err != nil {
+			return nil, fmt.Errorf("invalid merge_profile_uris entry '%s': %w", uri, err)
+		}
+	}
+	outputPath, ok := args["output_path"].(string)
+	if !ok || outputPath == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: output_path (string)")
+	}
+	if !filepath.IsAbs(outputPath) {
+		if cwd, err := os.Getwd(); err == nil {
+			outputPath = filepath.Join(cwd, outputPath)
+		}
+	}
+	overwrite, _ := args["overwrite"].(bool)
+	sampleType, _ := args["sample_type"].(string) // 降采样时按该样本类型的值加权，为空时使用默认样本类型
+	maxSamplesFloat, _ := args["max_samples"].(float64)
+	maxSamples := int(maxSamplesFloat)
+	if maxSamples < 0 {
+		return nil, fmt.Errorf("invalid max_samples %d: must not be negative", maxSamples)
+	}
+	seedFloat, _ := args["sampling_seed"].(float64)
+	filters, err := sampleFiltersFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	minSampleValue, err := minSampleValueFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Handling export_profile: URI=%s, Merge=%d, Output=%s, MaxSamples=%d, Focus=%q, Ignore=%q, Tags=%q",
+		profileURIStr, len(mergeURIs), outputPath, maxSamples, filters.Focus, filters.Ignore, filters.Tags)
+	if confirmErr := confirmWrite("export_profile", args, outputPath); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+	if existsErr := checkOutputPath("export_profile", outputPath, overwrite); existsErr != nil {
+		log.Printf("Refusing to overwrite existing file: %s", outputPath)
+		return existsErr.toolResult(), nil
+	}
+
+	inputs := make([]*profile.Profile, 0, 1+len(mergeURIs))
+	for _, uri := range append([]string{profileURIStr}, mergeURIs...) {
+		prof, err := loadProfile(ctx, uri, analysisID)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, prof)
+	}
+	prof := inputs[0]
+	if len(inputs) > 1 {
+		// Merge 返回新的 profile，池中共享的输入不会被修改
+		if prof, err = profile.Merge(inputs); err != nil {
+			return nil, fmt.Errorf("failed to merge the profiles: %w", err)
+		}
+	}
+
+	exported, stats, err := analyzer.PrepareProfile(prof,
+		analyzer.WithFilters(filters),
+		analyzer.WithSampleType(sampleType),
+		analyzer.WithDownsampling(maxSamples, int64(seedFloat)),
+		analyzer.WithMinSampleValue(minSampleValue),
+		analyzer.WithContext(ctx),
+	)
+	if err != nil {
+		return nil, err
+	}
+	path, err := writeProfileFile("export_profile", exported, outputPath, analysisID, overwrite)
+	if err != nil {
+		var existsErr *fileExistsError
+		if errors.As(err, &existsErr) {
+			return existsErr.toolResult(), nil
+		}
+		return nil, err
+	}
+	artifact := AnalysisArtifact{Path: path, Kind: "profile", Source: "export_profile"}
+	if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	hookReport := runPostProcessHook(ctx, analysisID, artifact)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Exported profile written to: %s\n", path))
+	if len(mergeURIs) > 0 {
+		b.WriteString(fmt.Sprintf("  Merged %d profiles: %s\n", len(inputs), strings.Join(append([]string{profileURIStr}, mergeURIs...), ", ")))
+	}
+	steps := make([]string, 0, 3)
+	if !filters.IsZero() {
+		steps = append(steps, "filtering")
+	}
+	if mv := stats.MinValue; mv != nil && mv.DroppedSamples > 0 {
+		steps = append(steps, fmt.Sprintf("dropping %s samples below %s (%s%% of the total)",
+			analyzer.FormatCount(int64(mv.DroppedSamples)), analyzer.FormatSampleValue(mv.Threshold, mv.Unit), analyzer.DefaultValueFormat().Float(mv.DroppedPercent(), 2)))
+	}
+	if ds := stats.Downsampled; ds != nil {
+		steps = append(steps, fmt.Sprintf("downsampling (seed %d; the values of light samples are scaled estimates)", ds.Seed))
+	}
+	b.WriteString(fmt.Sprintf("Samples: %d of %d kept", stats.Samples, stats.OriginalSamples))
+	if len(steps) > 0 {
+		b.WriteString(" after " + strings.Join(steps, " and "))
+	}
+	b.WriteString("\n")
+	for _, st := range analyzer.SummarizeSampleTypes(exported) {
+		b.WriteString(fmt.Sprintf("  %s: %s\n", st.Type, st.TotalFormatted))
+	}
+	b.WriteString("Pass it as 'profile_uri' to any other tool.\n")
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, hookReport), inputs...), nil
+}
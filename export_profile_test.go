@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseMergeProfileURIs(t *testing.T) {
+	got := parseMergeProfileURIs("a.pprof, b.pprof\ncache/c.pprof | http://host/c.pprof,,")
+	want := []string{"a.pprof", "b.pprof", "cache/c.pprof | http://host/c.pprof"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestHandleExportProfile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(workspaceEnv, dir)
+	write := func(name string, p *profile.Profile) string {
+		path := filepath.Join(dir, name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := p.Write(file); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first := write("first.pprof", poolTestProfile("main.hot", 10, 20))
+	second := write("second.pprof", poolTestProfile("main.cold", 5))
+	output := filepath.Join(dir, "out", "exported.pb.gz")
+
+	export := func(args map[string]interface{}) (string, error) {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handleExportProfile(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		return result.Content[0].(mcp.TextContent).Text, nil
+	}
+	text, err := export(map[string]interface{}{"profile_uri": first, "merge_profile_uris": second, "output_path": output, "focus_regex": "main\\.cold"})
+	if err != nil {
+		t.Fatalf("handleExportProfile failed: %v", err)
+	}
+	for _, want := range []string{"Exported profile written to: " + output, "Merged 2 profiles", "Samples: 1 of 2 kept after filtering", "cpu: 5ns"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the result:\n%s", want, text)
+		}
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("Exported profile not written: %v", err)
+	}
+	defer file.Close()
+	exported, err := profile.Parse(file)
+	if err != nil {
+		t.Fatalf("Exported profile cannot be parsed: %v", err)
+	}
+	if len(exported.Sample) != 1 || len(exported.Function) != 1 || exported.Function[0].Name != "main.cold" || exported.Sample[0].Value[0] != 5 {
+		t.Errorf("Expected only the focused sample and its function, got %d samples and functions %v", len(exported.Sample), exported.Function)
+	}
+
+	// An existing file is only replaced with overwrite
+	if text, err := export(map[string]interface{}{"profile_uri": first, "output_path": output}); err != nil || !strings.Contains(text, "file_exists") {
+		t.Errorf("Expected a file_exists result, got %q (%v)", text, err)
+	}
+	if text, err := export(map[string]interface{}{"profile_uri": first, "output_path": output, "overwrite": true}); err != nil || !strings.Contains(text, "Samples: 2 of 2 kept\n") {
+		t.Errorf("Expected the whole profile to be exported, got %q (%v)", text, err)
+	}
+	if _, err := export(map[string]interface{}{"profile_uri": first, "output_path": output, "overwrite": true, "focus_regex": "nothing"}); err == nil {
+		t.Errorf("Expected an error when no sample is left")
+	}
+}
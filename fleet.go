@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// fleetCapture is the CPU profile captured from one replica.
+type fleetCapture struct {
+	Target   string
+	URL      string
+	Seconds  int // Duration of the kept capture
+	Attempts int
+	Idle     bool // No CPU samples, even after the retries
+	Profile  *profile.Profile
+	Err      error
+}
+
+// parseFleetTargets splits the 'targets' argument on commas, whitespace or newlines.
+func parseFleetTargets(spec string) []string {
+	targets := make([]string, 0)
+	for _, target := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// fleetProfileURL returns the CPU profile URL of a replica. A bare host (or a URL ending in /debug/pprof)
+// gets the standard net/http/pprof path; the 'seconds' query parameter is always set, 'hz' when it is not 0.
+func fleetProfileURL(target string, seconds, hz int) (string, error) {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target '%s': %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid target '%s': only http and https replicas can be captured", target)
+	}
+	switch path := strings.TrimSuffix(u.Path, "/"); {
+	case path == "":
+		u.Path = "/debug/pprof/profile"
+	case strings.HasSuffix(path, "/debug/pprof"):
+		u.Path = path + "/profile"
+	}
+	query := u.Query()
+	query.Set("seconds", fmt.Sprintf("%d", seconds))
+	if hz > 0 {
+		query.Set("hz", fmt.Sprintf("%d", hz))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// captureFleetReplica captures the CPU profile of one replica, capturing again for retrySeconds (up to retries
+// times) while the profile comes back empty.
+func captureFleetReplica(ctx context.Context, c *fleetCapture, seconds, hz, retries, retrySeconds int, analysisID string) {
+	for {
+		profileURL, err := fleetProfileURL(c.Target, seconds, hz)
+		if err != nil {
+			c.Err = err
+			return
+		}
+		c.URL = profileURL
+		c.Seconds = seconds
+		c.Attempts++
+		log.Printf("Capturing %ds CPU profile from %s", seconds, c.URL)
+		c.Profile, c.Err = loadProfile(ctx, c.URL, analysisID)
+		if c.Err != nil {
+			return
+		}
+		c.Idle = isIdleCPUProfile(c.Profile)
+		if !c.Idle || c.Attempts > retries || ctx.Err() != nil {
+			return
+		}
+		log.Printf("CPU profile from %s is empty (idle service); capturing again for %ds", c.Target, retrySeconds)
+		seconds = retrySeconds
+	}
+}
+
+// captureFleet captures CPU profiles from all targets concurrently, retrying empty ones (see
+// captureFleetReplica). The result keeps the order of targets.
+func captureFleet(ctx context.Context, targets []string, seconds, hz, retries, retrySeconds int, analysisID string) []fleetCapture {
+	captures := make([]fleetCapture, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		captures[i].Target = target
+		// Invalid targets fail right away, without waiting for the others
+		if _, err := fleetProfileURL(target, seconds, hz); err != nil {
+			captures[i].Err = err
+			continue
+		}
+		wg.Add(1)
+		go func(c *fleetCapture) {
+			defer wg.Done()
+			captureFleetReplica(ctx, c, seconds, hz, retries, retrySeconds, analysisID)
+		}(&captures[i])
+	}
+	wg.Wait()
+	return captures
+}
+
+// saveMergedProfile writes the merged fleet profile next to the analysis' other files, so it can be
+// passed to the other tools. It is a no-op without an analysis ID.
+func saveMergedProfile(analysisID string, merged *profile.Profile) string {
+	if analysisID == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := merged.Write(&buf); err != nil {
+		log.Printf("Warning: failed to save merged fleet profile for analysis '%s': %v", analysisID, err)
+		return ""
+	}
+	path, err := writeSealedTemp(analysisTempPattern(analysisID, "fleet")+".pb.gz", buf.Bytes())
+	if err != nil {
+		log.Printf("Warning: failed to save merged fleet profile for analysis '%s': %v", analysisID, err)
+		return ""
+	}
+	if err := recordAnalysisArtifact(analysisID, AnalysisArtifact{Path: path, Kind: "profile", Source: "capture_fleet", Temporary: true}); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	return path
+}
+
+// handleCaptureFleet captures CPU profiles from several replicas of one service concurrently, merges them
+// and analyzes the merged profile, giving a fleet-wide view in one call. Text reports also include the
+// per-replica variance of the hottest functions; 'variance-json' returns only that.
+func handleCaptureFleet(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	targetsStr, ok := args["targets"].(string)
+	if !ok || targetsStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: targets (string)")
+	}
+	targets := parseFleetTargets(targetsStr)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("missing or invalid required argument: targets (string)")
+	}
+	seconds, hz, err := captureRateFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	idleRetries, idleRetrySeconds, err := idleRetryFromArgs(args, seconds)
+	if err != nil {
+		return nil, err
+	}
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 10.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 10
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling capture_fleet: Targets=%d, Seconds=%d, Hz=%d, IdleRetries=%d, IdleRetrySeconds=%d, TopN=%d, Format=%s",
+		len(targets), seconds, hz, idleRetries, idleRetrySeconds, topN, outputFormat)
+
+	captures := captureFleet(ctx, targets, seconds, hz, idleRetries, idleRetrySeconds, analysisID)
+	profiles := make([]*profile.Profile, 0, len(captures))
+	labels := make([]string, 0, len(captures))
+	failed := make([]string, 0)
+	idle := 0
+	for _, c := range captures {
+		if c.Err != nil {
+			log.Printf("Warning: capture from %s failed: %v", c.Target, c.Err)
+			failed = append(failed, fmt.Sprintf("%s: %v", c.Target, c.Err))
+			continue
+		}
+		if c.Idle {
+			idle++
+		}
+		profiles = append(profiles, c.Profile)
+		labels = append(labels, c.Target)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("capture failed on all %d replicas:\n  %s", len(targets), strings.Join(failed, "\n  "))
+	}
+	// An analysis of empty profiles is empty; say why instead
+	if idle == len(profiles) {
+		result := formatIdleFleet(captures, len(targets))
+		hookReport := saveAnalysisResult(ctx, analysisID, "capture_fleet", "text", result)
+		return withPostProcessReports(&mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: result,
+				},
+			},
+		}, hookReport), nil
+	}
+
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge replica profiles: %w", err)
+	}
+	mergedPath := saveMergedProfile(analysisID, merged)
+
+	if outputFormat == "variance-json" {
+		result, err := analyzer.FormatReplicaVariance(profiles, labels, "", topN, "json")
+		if err != nil {
+			return nil, err
+		}
+		hookReport := saveAnalysisResult(ctx, analysisID, "capture_fleet-variance", "json", result)
+		return withPostProcessReports(&mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: result,
+				},
+			},
+		}, hookReport), nil
+	}
+
+	result, err := analyzer.Analyze(merged, "cpu", analyzer.WithTopN(topN), analyzer.WithFormat(outputFormat), analyzer.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// Machine-readable formats are returned unchanged; the capture summary is only added to text reports
+	if outputFormat == "text" || outputFormat == "markdown" {
+		var b strings.Builder
+		rate := ""
+		if hz > 0 {
+			rate = fmt.Sprintf(" at %d Hz", hz)
+		}
+		b.WriteString(fmt.Sprintf("Fleet capture: %d/%d replicas, %ds CPU profile each%s\n", len(profiles), len(targets), seconds, rate))
+		for _, c := range captures {
+			status := "ok"
+			switch {
+			case c.Err != nil:
+				status = "FAILED: " + c.Err.Error()
+			case c.Idle:
+				status = "idle: " + idleCaptureSummary(c)
+			default:
+				if warning := captureWarning(c.Profile, c.Seconds, hz); warning != "" {
+					status = "ok, but " + warning
+				} else if c.Attempts > 1 {
+					status = fmt.Sprintf("ok after %d empty capture(s), %ds", c.Attempts-1, c.Seconds)
+				}
+			}
+			b.WriteString(fmt.Sprintf("  - %s (%s)\n", c.Target, status))
+		}
+		if mergedPath != "" {
+			b.WriteString(fmt.Sprintf("Merged profile: %s\n", mergedPath))
+		}
+		b.WriteString("\n")
+		b.WriteString(result)
+		// The variance shows whether a hotspot is systemic or limited to a few replicas
+		if len(profiles) >= 2 {
+			variance, err := analyzer.FormatReplicaVariance(profiles, labels, "", topN, outputFormat)
+			if err != nil {
+				log.Printf("Warning: failed to compute per-replica variance: %v", err)
+			} else {
+				b.WriteString("\n")
+				b.WriteString(variance)
+			}
+		}
+		result = b.String()
+	}
+
+	hookReport := saveAnalysisResult(ctx, analysisID, "capture_fleet", outputFormat, result)
+	return withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), nil
+}
+
+// idleCaptureSummary describes the attempts of an idle replica, e.g. "no CPU samples in 10s, nor in 1 retry of 30s".
+func idleCaptureSummary(c fleetCapture) string {
+	if c.Attempts <= 1 {
+		return fmt.Sprintf("no CPU samples in %ds", c.Seconds)
+	}
+	return fmt.Sprintf("no CPU samples in the first capture, nor in %d retry capture(s) of %ds", c.Attempts-1, c.Seconds)
+}
+
+// formatIdleFleet is the result of a fleet capture in which every replica that could be captured was idle.
+func formatIdleFleet(captures []fleetCapture, targets int) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Service was idle: the CPU profiles of all captured replicas (%d of %d) contain no samples, so there is nothing to analyze.\n", targets-countFailed(captures), targets))
+	for _, c := range captures {
+		if c.Err != nil {
+			b.WriteString(fmt.Sprintf("  - %s (FAILED: %v)\n", c.Target, c.Err))
+		} else {
+			b.WriteString(fmt.Sprintf("  - %s (%s)\n", c.Target, idleCaptureSummary(c)))
+		}
+	}
+	b.WriteString("\nCapture again while the service is handling load, or raise 'idle_retries' / 'idle_retry_seconds' to wait longer for activity.\n")
+	return b.String()
+}
+
+// countFailed returns the number of captures that failed.
+func countFailed(captures []fleetCapture) int {
+	n := 0
+	for _, c := range captures {
+		if c.Err != nil {
+			n++
+		}
+	}
+	return n
+}
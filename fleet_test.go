@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCaptureFleet(t *testing.T) {
+	replica := func(fn string, values ...int64) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/debug/pprof/profile" || r.URL.Query().Get("seconds") != "1" {
+				http.NotFound(w, r)
+				return
+			}
+			p := poolTestProfile(fn, values...)
+			p.DurationNanos = 1e9
+			if err := p.Write(w); err != nil {
+				t.Error(err)
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	first := replica("main.hot", 100, 200)
+	second := replica("main.hot", 300)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "profiling disabled", http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+
+	analysisID := "fleet-" + newArtifactID()[:8]
+	t.Cleanup(func() {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"analysis_id": analysisID}
+		handleCleanupAnalysis(context.Background(), request)
+	})
+	capture := func(targets string) (string, error) {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"targets": targets, "seconds": 1.0, "analysis_id": analysisID}
+		result, err := handleCaptureFleet(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		return result.Content[0].(mcp.TextContent).Text, nil
+	}
+
+	// The failing replica is reported, the others are merged
+	text, err := capture(strings.Join([]string{first.URL, failing.URL, strings.TrimPrefix(second.URL, "http://")}, ", "))
+	if err != nil {
+		t.Fatalf("capture_fleet failed: %v", err)
+	}
+	for _, want := range []string{
+		"Fleet capture: 2/3 replicas, 1s CPU profile each",
+		"  - " + first.URL + " (ok)",
+		"  - " + failing.URL + " (FAILED: ",
+		"profiling disabled",
+		"main.hot",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the result:\n%s", want, text)
+		}
+	}
+
+	// The merged profile holds the samples of both replicas
+	_, after, ok := strings.Cut(text, "Merged profile: ")
+	if !ok {
+		t.Fatalf("Expected the merged profile path in the result:\n%s", text)
+	}
+	data, err := readSealedFile(strings.TrimSpace(strings.SplitN(after, "\n", 2)[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := profile.ParseData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, s := range merged.Sample {
+		total += s.Value[0]
+	}
+	if total != 600 {
+		t.Errorf("Expected the merged samples to total 600, got %d", total)
+	}
+
+	// Without any replica to merge, every failure is listed
+	if _, err := capture(failing.URL + " ftp://replica"); err == nil || !strings.Contains(err.Error(), "capture failed on all 2 replicas") ||
+		!strings.Contains(err.Error(), "profiling disabled") || !strings.Contains(err.Error(), "only http and https") {
+		t.Errorf("Expected all failures to be reported, got %v", err)
+	}
+}
+
+// idleReplica serves empty CPU profiles for the first idle requests, then a profile with samples, and
+// records the requested durations.
+func idleReplica(t *testing.T, idle int) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var durations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		durations = append(durations, r.URL.Query().Get("seconds"))
+		n := len(durations)
+		mu.Unlock()
+		value := int64(0)
+		if n > idle {
+			value = 100
+		}
+		if err := poolTestProfile("main.busy", value).Write(w); err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &durations
+}
+
+func TestCaptureFleetReplicaIdleRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		idle, retries int
+		attempts      int
+		wantIdle      bool
+		durations     string
+	}{
+		{"NotIdle", 0, 3, 1, false, "2"},
+		{"IdleTwice", 2, 3, 3, false, "2,6,6"},
+		{"RetriesExhausted", 5, 2, 3, true, "2,6,6"},
+		{"NoRetry", 5, 0, 1, true, "2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server, durations := idleReplica(t, tc.idle)
+			c := &fleetCapture{Target: server.URL}
+			captureFleetReplica(context.Background(), c, 2, 0, tc.retries, 6, "")
+			if c.Err != nil || c.Attempts != tc.attempts || c.Idle != tc.wantIdle {
+				t.Errorf("Expected %d attempts (idle: %v), got %d (idle: %v, %v)", tc.attempts, tc.wantIdle, c.Attempts, c.Idle, c.Err)
+			}
+			if got := strings.Join(*durations, ","); got != tc.durations {
+				t.Errorf("Expected captures of %s seconds, got %s", tc.durations, got)
+			}
+		})
+	}
+}
+
+func TestCaptureFleetReplicaErrorsNotRetried(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusForbidden, http.StatusInternalServerError} {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			http.Error(w, "no profile here", status)
+		}))
+		c := &fleetCapture{Target: server.URL}
+		captureFleetReplica(context.Background(), c, 1, 0, maxIdleRetries, 3, "")
+		server.Close()
+		// Only empty profiles are captured again: errors are reported right away
+		if c.Err == nil || !strings.Contains(c.Err.Error(), "no profile here") || c.Attempts != 1 || requests.Load() != 1 {
+			t.Errorf("Status %d: expected one failed attempt, got %d attempts, %d requests (%v)", status, c.Attempts, requests.Load(), c.Err)
+		}
+	}
+
+	// A canceled capture is not retried either
+	server, durations := idleReplica(t, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &fleetCapture{Target: server.URL}
+	captureFleetReplica(ctx, c, 1, 0, maxIdleRetries, 3, "")
+	if c.Attempts != 1 || len(*durations) > 1 {
+		t.Errorf("Expected a single attempt once canceled, got %d attempts, %d requests (%v)", c.Attempts, len(*durations), c.Err)
+	}
+}
+
+func TestCaptureFleetIdleRetryCap(t *testing.T) {
+	t.Setenv(maxCaptureSecondsEnv, "45")
+	server, durations := idleReplica(t, 1)
+	var request mcp.CallToolRequest
+	// The default retry duration, 3 times 'seconds', is capped at the limit
+	request.Params.Arguments = map[string]interface{}{"targets": server.URL, "seconds": 30.0, "idle_retries": 2.0}
+	result, err := handleCaptureFleet(context.Background(), request)
+	if err != nil {
+		t.Fatalf("capture_fleet failed: %v", err)
+	}
+	if got := strings.Join(*durations, ","); got != "30,45" {
+		t.Errorf("Expected captures of 30 then 45 seconds, got %s", got)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "(ok after 1 empty capture(s), 45s)") {
+		t.Errorf("Expected the retry in the result:\n%s", text)
+	}
+
+	request.Params.Arguments = map[string]interface{}{"targets": server.URL, "idle_retries": float64(maxIdleRetries + 1)}
+	if _, err := handleCaptureFleet(context.Background(), request); err == nil || !strings.Contains(err.Error(), "invalid idle_retries") {
+		t.Errorf("Expected too many retries to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// maxListedFunctionMatches limits the renamed functions listed in a tool result.
+const maxListedFunctionMatches = 10
+
+// withMatchRenamedFunctions declares the 'match_renamed_functions' argument on a tool diffing two profiles.
+func withMatchRenamedFunctions() mcp.ToolOption {
+	return mcp.WithBoolean("match_renamed_functions",
+		mcp.Description("Whether functions that only exist under a different name in the base profile are matched to it (best effort, by build ID and address, by name after removing module major versions, vendor paths, generic type arguments and closure numbers, or by file basename and name), so that e.g. a v1 → v2 module upgrade or renumbered closures do not show up as removed and added code. Matches are listed in the result."),
+		mcp.DefaultBool(true),
+	)
+}
+
+// mapRenamedFunctions returns p with its functions renamed to the matching functions of base (see
+// analyzer.MapFunctionIdentities), unless 'match_renamed_functions' is false. p is not modified, so it may
+// come from the profile cache.
+func mapRenamedFunctions(args map[string]interface{}, p, base *profile.Profile) (*profile.Profile, []analyzer.FunctionMatch) {
+	if match, ok := args["match_renamed_functions"].(bool); ok && !match {
+		return p, nil
+	}
+	return analyzer.MapFunctionIdentities(p, base)
+}
+
+// withFunctionMatches appends the renamed functions matched by mapRenamedFunctions to a tool result.
+func withFunctionMatches(result *mcp.CallToolResult, matches []analyzer.FunctionMatch) *mcp.CallToolResult {
+	if len(matches) == 0 {
+		return result
+	}
+	result.Content = append(result.Content, mcp.TextContent{
+		Type: "text",
+		Text: analyzer.FormatFunctionMatches(matches, maxListedFunctionMatches),
+	})
+	return result
+}
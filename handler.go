@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -19,10 +21,20 @@ import (
 func handleAnalyzePprof(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.Params.Arguments
 
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
 	profileURIStr, ok := args["profile_uri"].(string)
 	if !ok || profileURIStr == "" {
 		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
 	}
+	// 从目标实时采集时可以指定采集时长和采样频率 (作为 URL 的查询参数)
+	profileURIStr, captureSeconds, captureHz, err := liveCaptureURI(profileURIStr, args)
+	if err != nil {
+		return nil, err
+	}
 	profileType, ok := args["profile_type"].(string)
 	if !ok || profileType == "" {
 		return nil, fmt.Errorf("missing or invalid required argument: profile_type (string)")
@@ -39,48 +51,60 @@ func handleAnalyzePprof(ctx context.Context, request mcp.CallToolRequest) (*mcp.
 	if topN <= 0 {
 		topN = 5
 	}
-
-	log.Printf("Handling analyze_pprof: URI=%s, Type=%s, TopN=%d, Format=%s", profileURIStr, profileType, topN, outputFormat)
-
-	filePath, cleanup, err := getProfileAsFile(profileURIStr) // Calls function from profile_utils.go
-	if err != nil {
-		return nil, fmt.Errorf("failed to get profile file: %w", err)
+	maxSamplesFloat, _ := args["max_samples"].(float64) // 0 表示不降采样
+	maxSamples := int(maxSamplesFloat)
+	if maxSamples < 0 {
+		return nil, fmt.Errorf("invalid max_samples %d: must not be negative", maxSamples)
+	}
+	seedFloat, _ := args["sampling_seed"].(float64)
+	maxCharsFloat, _ := args["max_chars"].(float64)                // 仅用于 markdown-compact；0 表示使用默认预算
+	sampleType, _ := args["sample_type"].(string)                  // 为空时使用该 profile 类型的默认样本类型
+	groupBy, _ := args["group_by"].(string)                        // 'package' 时生成按包汇总的内存归属摘要
+	ownershipThreshold, _ := args["ownership_threshold"].(float64) // 0 表示使用默认阈值
+	suggestNext, _ := args["suggest_next"].(bool)
+	comparePrevious, ok := args["compare_previous_capture"].(bool)
+	if !ok {
+		comparePrevious = true
 	}
-	defer cleanup()
-
-	file, err := os.Open(filePath)
+	maxStackDepthFloat, _ := args["max_stack_depth"].(float64) // 0 表示显示完整堆栈
+	sortBy, _ := args["sort_by"].(string)                      // 为空时按 flat 排序
+	groupByLabel, _ := args["group_by_label"].(string)         // 非空时按该标签的取值分组
+	aggregationLevel, _ := args["aggregation_level"].(string)  // 为空时按函数汇总
+	filters, err := sampleFiltersFromArgs(args)
 	if err != nil {
-		log.Printf("Error opening profile file '%s' (might be temporary): %v", filePath, err)
-		return nil, fmt.Errorf("failed to open profile file '%s': %w", filePath, err)
+		return nil, err
 	}
-	defer file.Close()
-
-	prof, err := profile.Parse(file)
+	minSampleValue, err := minSampleValueFromArgs(args)
 	if err != nil {
-		log.Printf("Error parsing profile file '%s': %v", filePath, err)
-		return nil, fmt.Errorf("failed to parse profile file '%s': %w", filePath, err)
+		return nil, err
 	}
-	log.Printf("Successfully parsed profile file from path: %s", filePath)
 
-	var analysisResult string
-	var analysisErr error
+	log.Printf("Handling analyze_pprof: URI=%s, Type=%s, TopN=%d, Format=%s, MaxSamples=%d, MinSampleValue=%s, Focus=%q, Ignore=%q, Tags=%q, GroupByLabel=%q, AggregationLevel=%q",
+		profileURIStr, profileType, topN, outputFormat, maxSamples, minSampleValue, filters.Focus, filters.Ignore, filters.Tags, groupByLabel, aggregationLevel)
 
-	switch profileType {
-	case "cpu":
-		analysisResult, analysisErr = analyzer.AnalyzeCPUProfile(prof, topN, outputFormat)
-	case "heap":
-		analysisResult, analysisErr = analyzer.AnalyzeHeapProfile(prof, topN, outputFormat)
-	case "goroutine":
-		analysisResult, analysisErr = analyzer.AnalyzeGoroutineProfile(prof, topN, outputFormat)
-	case "allocs":
-		analysisResult, analysisErr = analyzer.AnalyzeAllocsProfile(prof, topN, outputFormat)
-	case "mutex":
-		analysisResult, analysisErr = analyzer.AnalyzeMutexProfile(prof, topN, outputFormat)
-	case "block":
-		analysisResult, analysisErr = analyzer.AnalyzeBlockProfile(prof, topN, outputFormat)
-	default:
-		analysisErr = fmt.Errorf("unsupported profile type: '%s'", profileType)
-	}
+	// 缓存键使重复的分析 (例如仅 top_n 不同) 可以复用已计算的聚合结果和火焰图
+	prof, cacheKey, err := loadProfileWithKey(ctx, profileURIStr, analysisID) // Calls function from profile_utils.go
+	if err != nil {
+		return nil, err
+	}
+
+	analysisResult, analysisErr := analyzer.Analyze(prof, profileType,
+		analyzer.WithTopN(topN),
+		analyzer.WithFormat(outputFormat),
+		analyzer.WithDownsampling(maxSamples, int64(seedFloat)),
+		analyzer.WithMinSampleValue(minSampleValue),
+		analyzer.WithCacheKey(cacheKey),
+		analyzer.WithCharBudget(int(maxCharsFloat)),
+		analyzer.WithSampleType(sampleType),
+		analyzer.WithGroupBy(groupBy),
+		analyzer.WithOwnershipThreshold(ownershipThreshold),
+		analyzer.WithMaxStackDepth(int(maxStackDepthFloat)),
+		analyzer.WithSortBy(sortBy),
+		analyzer.WithFilters(filters),
+		analyzer.WithGroupByLabel(groupByLabel),
+		analyzer.WithAggregationLevel(aggregationLevel),
+		analyzer.WithContext(ctx),
+	)
 
 	if analysisErr != nil {
 		log.Printf("Analysis error for type '%s': %v", profileType, analysisErr)
@@ -88,20 +112,71 @@ func handleAnalyzePprof(ctx context.Context, request mcp.CallToolRequest) (*mcp.
 	}
 
 	log.Printf("Analysis successful for type '%s'. Result length: %d", profileType, len(analysisResult))
-	return &mcp.CallToolResult{
+	resultName := "analyze_pprof-" + profileType
+	if groupByLabel != "" {
+		resultName += "-by-label"
+	} else if outputFormat == "flamegraph-json" {
+		resultName += "-flamegraph" // get_flamegraph_subtree 通过该名称查找缓存的火焰图
+	} else if groupBy == "package" && (profileType == "heap" || profileType == "allocs") {
+		resultName += "-ownership"
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, resultName, outputFormat, analysisResult)
+	result := withPostProcessReports(&mcp.CallToolResult{
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
 				Text: analysisResult,
 			},
 		},
-	}, nil
+	}, hookReport)
+	// 目标缩短了采集或忽略了 'hz' 时说明原因
+	if warning := captureWarning(prof, captureSeconds, captureHz); warning != "" {
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: "Note: the target " + warning + "."})
+	}
+	// 从实时目标获取的 profile 与该目标的上一次采集比较，无需单独调用 diff_profiles
+	if section := changeSinceLastCapture(profileURIStr, prof, cacheKey, profileType, sampleType, outputFormat, comparePrevious); section != "" {
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: section})
+	}
+	// flamegraph-json 和调用图的格式是固定的：样本类型表和恢复警告作为单独的内容返回。
+	// folded 和 graphml 输出通常直接交给 flamegraph.pl、Gephi 等工具 (例如通过 CLI 管道)，因此不附加样本类型表
+	if !analyzer.ReportsSampleTypes(outputFormat) {
+		if table := analyzer.FormatSampleTypes(prof, "text"); table != "" && outputFormat != "folded" && outputFormat != "graphml" {
+			result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: table})
+		}
+		result = withRecoveryWarnings(result, prof)
+	}
+	if suggestNext {
+		return withSuggestedNextCalls(result, analyzer.SuggestNextCalls(prof, analyzer.SuggestionContext{
+			Tool:         "analyze_pprof",
+			ProfileURI:   profileURIStr,
+			ProfileType:  profileType,
+			OutputFormat: outputFormat,
+			SampleType:   sampleType,
+			AnalysisID:   analysisID,
+		}))
+	}
+	return result, nil
+}
+
+// withSuggestedNextCalls appends suggested follow-up tool calls to a tool result as a separate JSON content item.
+func withSuggestedNextCalls(result *mcp.CallToolResult, suggestions []analyzer.SuggestedCall) (*mcp.CallToolResult, error) {
+	text, err := analyzer.FormatSuggestedCalls(suggestions)
+	if err != nil {
+		return nil, err
+	}
+	result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: text})
+	return result, nil
 }
 
 // handleDetectMemoryLeaks handles requests for memory leak detection.
 func handleDetectMemoryLeaks(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.Params.Arguments
 
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
 	oldProfileURIStr, ok := args["old_profile_uri"].(string)
 	if !ok || oldProfileURIStr == "" {
 		return nil, fmt.Errorf("missing or invalid required argument: old_profile_uri (string)")
@@ -126,73 +201,93 @@ func handleDetectMemoryLeaks(ctx context.Context, request mcp.CallToolRequest) (
 		limit = 10
 	}
 
-	log.Printf("Handling detect_memory_leaks: OldURI=%s, NewURI=%s, Threshold=%.2f, Limit=%d",
-		oldProfileURIStr, newProfileURIStr, thresholdFloat, limit)
-
-	// Get the old profile file
-	oldFilePath, oldCleanup, err := getProfileAsFile(oldProfileURIStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get old profile file: %w", err)
+	topKFloat, ok := args["top_k"].(float64)
+	if !ok {
+		topKFloat = float64(analyzer.DefaultTopChanges)
 	}
-	defer oldCleanup()
+	topK := int(topKFloat)
 
-	oldFile, err := os.Open(oldFilePath)
+	ignore, err := diffIgnorePatterns(args)
 	if err != nil {
-		log.Printf("Error opening old profile file '%s': %v", oldFilePath, err)
-		return nil, fmt.Errorf("failed to open old profile file '%s': %w", oldFilePath, err)
+		return nil, err
 	}
-	defer oldFile.Close()
 
-	oldProf, err := profile.Parse(oldFile)
-	if err != nil {
-		log.Printf("Error parsing old profile file '%s': %v", oldFilePath, err)
-		return nil, fmt.Errorf("failed to parse old profile file '%s': %w", oldFilePath, err)
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
 	}
-	log.Printf("Successfully parsed old profile file from path: %s", oldFilePath)
 
-	// Get the new profile file
-	newFilePath, newCleanup, err := getProfileAsFile(newProfileURIStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get new profile file: %w", err)
-	}
-	defer newCleanup()
+	log.Printf("Handling detect_memory_leaks: OldURI=%s, NewURI=%s, Threshold=%.2f, Limit=%d, TopK=%d, Ignore=%d pattern(s), Format=%s",
+		oldProfileURIStr, newProfileURIStr, thresholdFloat, limit, topK, len(ignore), outputFormat)
 
-	newFile, err := os.Open(newFilePath)
+	// 与其他工具一样经由 loadProfile 加载：共享已解析的 profile、转换 perf.data、检查内存预算、恢复截断的文件，
+	// 并应用导入的 pprof 配置。返回的 profile 可能被共享，下面的分析都不修改它们
+	oldProf, err := loadProfile(ctx, oldProfileURIStr, analysisID)
 	if err != nil {
-		log.Printf("Error opening new profile file '%s': %v", newFilePath, err)
-		return nil, fmt.Errorf("failed to open new profile file '%s': %w", newFilePath, err)
+		return nil, fmt.Errorf("failed to load old profile: %w", err)
 	}
-	defer newFile.Close()
-
-	newProf, err := profile.Parse(newFile)
+	newProf, err := loadProfile(ctx, newProfileURIStr, analysisID)
 	if err != nil {
-		log.Printf("Error parsing new profile file '%s': %v", newFilePath, err)
-		return nil, fmt.Errorf("failed to parse new profile file '%s': %w", newFilePath, err)
+		return nil, fmt.Errorf("failed to load new profile: %w", err)
 	}
-	log.Printf("Successfully parsed new profile file from path: %s", newFilePath)
+
+	// Match renamed functions of the new profile to the old one; the old profile is the base of the diff
+	mappedNew, matches := mapRenamedFunctions(args, newProf, oldProf)
 
 	// Detect memory leaks
-	result, err := analyzer.DetectPotentialMemoryLeaks(oldProf, newProf, thresholdFloat, limit)
+	var result string
+	switch outputFormat {
+	case "text", "markdown":
+		result, err = analyzer.DetectPotentialMemoryLeaksWithOptions(oldProf, mappedNew, thresholdFloat, limit,
+			analyzer.WithTopN(topK), analyzer.WithIgnoreFunctions(ignore), analyzer.WithFormat(outputFormat))
+	case "heatmap-json":
+		filteredOld, _ := analyzer.DropIgnoredSamples(oldProf, ignore)
+		filteredNew, _ := analyzer.DropIgnoredSamples(mappedNew, ignore)
+		result, err = analyzer.FormatHeatmapJSON([]*profile.Profile{filteredOld, filteredNew},
+			[]string{oldProfileURIStr, newProfileURIStr}, "inuse_space", limit)
+	default:
+		err = fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
 	if err != nil {
 		log.Printf("Error detecting memory leaks: %v", err)
 		return nil, fmt.Errorf("failed to detect memory leaks: %w", err)
 	}
 
 	log.Printf("Memory leak detection completed successfully. Result length: %d", len(result))
-	return &mcp.CallToolResult{
+	hookReport := saveAnalysisResult(ctx, analysisID, "detect_memory_leaks", outputFormat, result)
+	toolResult := withPostProcessReports(&mcp.CallToolResult{
 		Content: []mcp.Content{
 			mcp.TextContent{
 				Type: "text",
 				Text: result,
 			},
 		},
-	}, nil
+	}, hookReport)
+	toolResult = withRecoveryWarnings(withFunctionMatches(toolResult, matches), oldProf, newProf)
+	// 同一文件被误传两次时比较没有意义 (忽略列表对两个 profile 的修改相同，不影响该判断)
+	toolResult = withSameProfileWarning(toolResult, oldProf, newProf)
+	if suggestNext, _ := args["suggest_next"].(bool); suggestNext {
+		return withSuggestedNextCalls(toolResult, analyzer.SuggestNextCalls(newProf, analyzer.SuggestionContext{
+			Tool:           "detect_memory_leaks",
+			ProfileURI:     newProfileURIStr,
+			BaseProfileURI: oldProfileURIStr,
+			ProfileType:    "heap",
+			OutputFormat:   outputFormat,
+			AnalysisID:     analysisID,
+		}))
+	}
+	return toolResult, nil
 }
 
 // handleGenerateFlamegraph handles requests to generate flame graphs.
 func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.Params.Arguments
 
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
 	profileURIStr, ok := args["profile_uri"].(string)
 	if !ok || profileURIStr == "" {
 		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
@@ -205,10 +300,17 @@ func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest)
 	if !ok || outputSvgPath == "" {
 		return nil, fmt.Errorf("missing or invalid required argument: output_svg_path (string)")
 	}
+	returnInline, _ := args["return_inline"].(bool)
+	overwrite, _ := args["overwrite"].(bool)
+	filters, err := sampleFiltersFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("Handling generate_flamegraph: URI=%s, Type=%s, Output=%s", profileURIStr, profileType, outputSvgPath)
+	log.Printf("Handling generate_flamegraph: URI=%s, Type=%s, Output=%s, Inline=%t, Focus=%q, Ignore=%q",
+		profileURIStr, profileType, outputSvgPath, returnInline, filters.Focus, filters.Ignore)
 
-	inputFilePath, cleanup, err := getProfileAsFile(profileURIStr) // Calls function from profile_utils.go
+	inputFilePath, cleanup, err := getProfileAsFile(ctx, profileURIStr, analysisID) // Calls function from profile_utils.go
 	if err != nil {
 		return nil, fmt.Errorf("failed to get profile file for flamegraph: %w", err)
 	}
@@ -223,6 +325,13 @@ func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest)
 			log.Printf("将相对输出路径转换为绝对路径: %s", outputSvgPath)
 		}
 	}
+	if confirmErr := confirmWrite("generate_flamegraph", args, outputSvgPath); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+	if existsErr := checkOutputPath("generate_flamegraph", outputSvgPath, overwrite); existsErr != nil {
+		log.Printf("Refusing to overwrite existing file: %s", outputSvgPath)
+		return existsErr.toolResult(), nil
+	}
 
 	cmdArgs := []string{"tool", "pprof"}
 	switch profileType {
@@ -230,12 +339,25 @@ func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest)
 		cmdArgs = append(cmdArgs, "-inuse_space")
 	case "allocs":
 		cmdArgs = append(cmdArgs, "-alloc_space")
-	case "cpu", "goroutine", "mutex", "block":
+	case "cpu", "goroutine", "mutex", "block", "threadcreate":
 		// No extra flags needed
 	default:
 		return nil, fmt.Errorf("unsupported profile type for flamegraph: '%s'", profileType)
 	}
-	cmdArgs = append(cmdArgs, "-svg", "-output", outputSvgPath, inputFilePath)
+	// 先写入同目录下的临时文件，完成后再重命名，目标路径上不会出现写了一半的 SVG
+	tempSvgPath, err := tempOutputPath(outputSvgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempSvgPath) // 成功重命名后为空操作
+	cmdArgs = append(cmdArgs, pprofFilterFlags(filters)...)
+	// 运行 go tool pprof 前需要用户确认；预览中以目标路径和原始 URI 代替临时文件
+	preview := append([]string{"go"}, cmdArgs...)
+	preview = append(preview, "-svg", "-output", outputSvgPath, profileURIStr)
+	if confirmErr := confirmSpawn("generate_flamegraph", args, preview, false); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+	cmdArgs = append(cmdArgs, "-svg", "-output", tempSvgPath, inputFilePath)
 
 	log.Printf("Executing command: go %s", strings.Join(cmdArgs, " "))
 
@@ -259,9 +381,28 @@ func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest)
 		log.Printf("Error executing 'go tool pprof': %v\nOutput:\n%s", err, string(cmdOutput))
 		return nil, fmt.Errorf("failed to generate flamegraph: %w. Output: %s", err, string(cmdOutput))
 	}
+	// 嵌入生成参数，使脱离上下文传递的 SVG 仍可追溯到其 profile 和分析；失败时仍保留 SVG
+	metadata := newArtifactMetadata("generate_flamegraph", analysisID, profileURIStr, inputFilePath, args,
+		"profile_type", "focus_regex", "ignore_regex", "tag_filter", "exclude_test_frames")
+	if err := embedSVGMetadataFile(tempSvgPath, metadata); err != nil {
+		log.Printf("Warning: failed to embed metadata in '%s': %v", outputSvgPath, err)
+		metadata.ArtifactID = ""
+	}
+	if err := commitOutputFile("generate_flamegraph", tempSvgPath, outputSvgPath, overwrite); err != nil {
+		var existsErr *fileExistsError
+		if errors.As(err, &existsErr) {
+			return existsErr.toolResult(), nil
+		}
+		return nil, err
+	}
 
 	log.Printf("Successfully generated flamegraph: %s", outputSvgPath)
 	log.Printf("pprof output:\n%s", string(cmdOutput))
+	artifact := AnalysisArtifact{Path: outputSvgPath, Kind: "flamegraph", Source: profileURIStr, ArtifactID: metadata.ArtifactID}
+	if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	hookReport := runPostProcessHook(ctx, analysisID, artifact) // 例如上传到内部存储或转换格式
 
 	resultText := fmt.Sprintf("火焰图已成功生成并保存到: %s", outputSvgPath)
 	textContent := mcp.TextContent{
@@ -269,12 +410,19 @@ func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest)
 		Text: resultText,
 	}
 
+	if returnInline {
+		// 远程客户端无法读取服务器上的路径：以 base64 blob 返回 SVG，代替原始 SVG 文本
+		return withPostProcessReports(&mcp.CallToolResult{
+			Content: []mcp.Content{textContent, inlineArtifact(outputSvgPath, "image/svg+xml")},
+		}, hookReport), nil
+	}
+
 	svgBytes, readErr := os.ReadFile(outputSvgPath)
 	if readErr != nil {
 		log.Printf("成功生成 SVG 文件 '%s' 但读取失败: %v", outputSvgPath, readErr)
-		return &mcp.CallToolResult{
+		return withPostProcessReports(&mcp.CallToolResult{
 			Content: []mcp.Content{textContent},
-		}, nil
+		}, hookReport), nil
 	}
 
 	svgContentStr := string(svgBytes)
@@ -283,10 +431,575 @@ func handleGenerateFlamegraph(ctx context.Context, request mcp.CallToolRequest)
 		Text: svgContentStr,
 	}
 
-	return &mcp.CallToolResult{
+	return withPostProcessReports(&mcp.CallToolResult{
 		Content: []mcp.Content{
 			textContent,
 			svgContent,
 		},
+	}, hookReport), nil
+}
+
+// handleAnalyzePoolEffectiveness handles requests to analyze how much allocation sync.Pool usage saves.
+func handleAnalyzePoolEffectiveness(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	baselineURIStr, _ := args["baseline_profile_uri"].(string)
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 5.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 5
+	}
+
+	log.Printf("Handling analyze_pool_effectiveness: URI=%s, BaselineURI=%s, TopN=%d, Format=%s",
+		profileURIStr, baselineURIStr, topN, outputFormat)
+
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	var baselineProf *profile.Profile
+	if baselineURIStr != "" {
+		baselineProf, err = loadProfile(ctx, baselineURIStr, analysisID)
+		if err != nil {
+			return nil, fmt.Errorf("baseline profile: %w", err)
+		}
+	}
+
+	result, err := analyzer.AnalyzePoolEffectiveness(prof, baselineProf, topN, outputFormat)
+	if err != nil {
+		log.Printf("Error analyzing sync.Pool effectiveness: %v", err)
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "analyze_pool_effectiveness", outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), prof, baselineProf), nil
+}
+
+// loadOptionalProfile loads the profile referenced by an optional URI argument, returning nil if it is absent.
+func loadOptionalProfile(ctx context.Context, args map[string]interface{}, key string) (*profile.Profile, error) {
+	uriStr, ok := args[key].(string)
+	if !ok || uriStr == "" {
+		return nil, nil
+	}
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	prof, err := loadProfile(ctx, uriStr, analysisID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return prof, nil
+}
+
+// handleDetectLeakPatterns handles requests to detect well-known leak patterns in goroutine/heap snapshots.
+func handleDetectLeakPatterns(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	pattern, ok := args["pattern"].(string)
+	if !ok || pattern == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: pattern (string)")
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	limitFloat, ok := args["limit"].(float64)
+	if !ok {
+		limitFloat = 10.0
+	}
+	limit := int(limitFloat)
+	if limit <= 0 {
+		limit = 10
+	}
+
+	log.Printf("Handling detect_leak_patterns: Pattern=%s, Limit=%d, Format=%s", pattern, limit, outputFormat)
+
+	var profiles analyzer.LeakPatternProfiles
+	for key, target := range map[string]**profile.Profile{
+		"goroutine_profile_uri":     &profiles.Goroutine,
+		"old_goroutine_profile_uri": &profiles.OldGoroutine,
+		"heap_profile_uri":          &profiles.Heap,
+		"old_heap_profile_uri":      &profiles.OldHeap,
+	} {
+		if *target, err = loadOptionalProfile(ctx, args, key); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := analyzer.DetectLeakPattern(pattern, profiles, limit, outputFormat)
+	if err != nil {
+		log.Printf("Error detecting leak pattern '%s': %v", pattern, err)
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "detect_leak_patterns-"+pattern, outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), profiles.Goroutine, profiles.OldGoroutine, profiles.Heap, profiles.OldHeap), nil
+}
+
+// handleDetectStuckGoroutines handles requests to detect deadlocks and stuck goroutines in a goroutine profile or dump.
+func handleDetectStuckGoroutines(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	criteria := analyzer.StuckGoroutineCriteria{MinGoroutines: 10, MinWaitMinutes: 5}
+	if v, ok := args["min_goroutines"].(float64); ok && v >= 1 {
+		criteria.MinGoroutines = int64(v)
+	}
+	if v, ok := args["min_wait_minutes"].(float64); ok && v >= 0 {
+		criteria.MinWaitMinutes = int64(v)
+	}
+	limitFloat, ok := args["limit"].(float64)
+	if !ok {
+		limitFloat = 10.0
+	}
+	limit := int(limitFloat)
+	if limit <= 0 {
+		limit = 10
+	}
+	maxStackDepthFloat, _ := args["max_stack_depth"].(float64)
+	maxStackDepth := int(maxStackDepthFloat)
+
+	log.Printf("Handling detect_stuck_goroutines: URI=%s, MinGoroutines=%d, MinWaitMinutes=%d, Limit=%d, Format=%s",
+		profileURIStr, criteria.MinGoroutines, criteria.MinWaitMinutes, limit, outputFormat)
+
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := analyzer.DetectStuckGoroutines(prof, criteria, limit, maxStackDepth, outputFormat)
+	if err != nil {
+		log.Printf("Error detecting stuck goroutines: %v", err)
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "detect_stuck_goroutines", outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), prof), nil
+}
+
+// handleAnalyzeDBPoolContention handles requests to analyze database/sql connection pool contention.
+func handleAnalyzeDBPoolContention(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	limitFloat, ok := args["limit"].(float64)
+	if !ok {
+		limitFloat = 10.0
+	}
+	limit := int(limitFloat)
+	if limit <= 0 {
+		limit = 10
+	}
+
+	log.Printf("Handling analyze_db_pool_contention: Limit=%d, Format=%s", limit, outputFormat)
+
+	var profiles analyzer.DBPoolProfiles
+	for key, target := range map[string]**profile.Profile{
+		"block_profile_uri":     &profiles.Block,
+		"mutex_profile_uri":     &profiles.Mutex,
+		"goroutine_profile_uri": &profiles.Goroutine,
+	} {
+		if *target, err = loadOptionalProfile(ctx, args, key); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := analyzer.AnalyzeDBPoolContention(profiles, limit, outputFormat)
+	if err != nil {
+		log.Printf("Error analyzing database pool contention: %v", err)
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "analyze_db_pool_contention", outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), profiles.Block, profiles.Mutex, profiles.Goroutine), nil
+}
+
+// handleAttributeCosts handles requests to attribute profile costs to handlers, RPC methods or tests.
+func handleAttributeCosts(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	attributeBy, ok := args["attribute_by"].(string)
+	if !ok || attributeBy == "" {
+		attributeBy = "handler"
+	}
+	sampleType, _ := args["sample_type"].(string)
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 10.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 10
+	}
+
+	log.Printf("Handling attribute_costs: URI=%s, AttributeBy=%s, SampleType=%s, TopN=%d, Format=%s",
+		profileURIStr, attributeBy, sampleType, topN, outputFormat)
+
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := analyzer.AttributeCosts(prof, attributeBy, sampleType, topN, outputFormat)
+	if err != nil {
+		log.Printf("Error attributing costs by %s: %v", attributeBy, err)
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "attribute_costs-"+attributeBy, outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), prof), nil
+}
+
+// handleQueryProfile handles ad-hoc queries evaluated directly over a parsed profile.
+func handleQueryProfile(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	query, ok := args["query"].(string)
+	if !ok || strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: query (string)")
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling query_profile: URI=%s, Query=%s, Format=%s", profileURIStr, query, outputFormat)
+
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := analyzer.QueryProfile(prof, query, outputFormat)
+	if err != nil {
+		log.Printf("Error evaluating profile query: %v", err)
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "query_profile", outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), prof), nil
+}
+
+// handleCompareStackSets reports the functions or stacks present in both of two profiles, or only in one of them.
+func handleCompareStackSets(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	baseURIStr, ok := args["base_profile_uri"].(string)
+	if !ok || baseURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: base_profile_uri (string)")
+	}
+	operation, ok := args["operation"].(string)
+	if !ok || operation == "" {
+		operation = "only_in_profile"
+	}
+	level, ok := args["level"].(string)
+	if !ok || level == "" {
+		level = "function"
+	}
+	sampleType, _ := args["sample_type"].(string)
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 20.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 20
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling compare_stack_sets: URI=%s, Base=%s, Operation=%s, Level=%s, TopN=%d, Format=%s", profileURIStr, baseURIStr, operation, level, topN, outputFormat)
+
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	base, err := loadProfile(ctx, baseURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, matches := mapRenamedFunctions(args, prof, base)
+	comparison, err := analyzer.CompareStackSets(mapped, base, operation, level, sampleType, topN)
+	if err != nil {
+		return nil, err
+	}
+	result, err := analyzer.FormatStackSetResult(comparison, outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "compare_stack_sets-"+operation, outputFormat, result)
+
+	return withSameProfileWarning(withRecoveryWarnings(withFunctionMatches(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), matches), prof, base), prof, base), nil
+}
+
+// handleDiffProfiles compares two profiles of any type function by function (detect_memory_leaks only
+// handles heap profiles).
+func handleDiffProfiles(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	oldURIStr, ok := args["old_profile_uri"].(string)
+	if !ok || oldURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: old_profile_uri (string)")
+	}
+	newURIStr, ok := args["new_profile_uri"].(string)
+	if !ok || newURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: new_profile_uri (string)")
+	}
+	profileType, ok := args["profile_type"].(string)
+	if !ok || profileType == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_type (string)")
+	}
+	sampleType, _ := args["sample_type"].(string)
+	sortBy, ok := args["sort_by"].(string)
+	if !ok || sortBy == "" {
+		sortBy = "flat"
+	}
+	topNFloat, ok := args["top_n"].(float64)
+	if !ok {
+		topNFloat = 10.0
+	}
+	topN := int(topNFloat)
+	if topN <= 0 {
+		topN = 10
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling diff_profiles: OldURI=%s, NewURI=%s, Type=%s, SampleType=%s, SortBy=%s, TopN=%d, Format=%s",
+		oldURIStr, newURIStr, profileType, sampleType, sortBy, topN, outputFormat)
+
+	oldProf, err := loadProfile(ctx, oldURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	newProf, err := loadProfile(ctx, newURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The old profile is the base of the diff
+	mappedNew, matches := mapRenamedFunctions(args, newProf, oldProf)
+	diff, err := analyzer.DiffProfiles(oldProf, mappedNew, profileType, sampleType, sortBy, topN)
+	if err != nil {
+		return nil, err
+	}
+	result, err := analyzer.FormatProfileDiff(diff, outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "diff_profiles-"+diff.ProfileType, outputFormat, result)
+
+	return withSameProfileWarning(withRecoveryWarnings(withFunctionMatches(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), matches), oldProf, newProf), oldProf, newProf), nil
+}
+
+// handleGetFlamegraphSubtree returns one subtree of a flame graph cached for an analysis ID.
+func handleGetFlamegraphSubtree(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if analysisID == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: analysis_id (string)")
+	}
+	nodePath, ok := args["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid required argument: path (string)")
+	}
+	profileType, _ := args["profile_type"].(string)
+	maxDepthFloat, ok := args["max_depth"].(float64)
+	if !ok {
+		maxDepthFloat = 3.0
+	}
+	maxDepth := int(maxDepthFloat)
+
+	log.Printf("Handling get_flamegraph_subtree: AnalysisID=%s, Path=%s, Type=%s, MaxDepth=%d", analysisID, nodePath, profileType, maxDepth)
+
+	// Use the most recent flame graph JSON recorded for the analysis (optionally of the given profile type)
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil {
+		return nil, err
+	}
+	var flamegraph *AnalysisArtifact
+	for i, artifact := range manifest.Artifacts {
+		if artifact.Kind != "analysis" || !strings.HasPrefix(artifact.Source, "analyze_pprof-") || !strings.HasSuffix(artifact.Source, "-flamegraph") {
+			continue
+		}
+		if profileType != "" && artifact.Source != "analyze_pprof-"+profileType+"-flamegraph" {
+			continue
+		}
+		flamegraph = &manifest.Artifacts[i]
+	}
+	if flamegraph == nil {
+		return nil, fmt.Errorf("no cached flame graph for analysis '%s'; run analyze_pprof with output_format 'flamegraph-json' and this analysis_id first", analysisID)
+	}
+
+	// 本地文件已删除时 (例如重启后或在其他实例上分析) 使用存储中的副本
+	data, err := readArtifactFile(*flamegraph)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached flame graph '%s': %w", flamegraph.Path, err)
+	}
+	var root analyzer.FlameGraphNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse cached flame graph '%s': %w", flamegraph.Path, err)
+	}
+
+	subtree, err := analyzer.FindFlameGraphSubtree(&root, nodePath, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	jsonBytes, err := json.Marshal(subtree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal flame graph subtree: %w", err)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: string(jsonBytes),
+			},
+		},
 	}, nil
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestGetFlamegraphSubtreeStoredCopy(t *testing.T) {
+	analysisID := "subtree-" + newArtifactID()[:8]
+	root := &analyzer.FlameGraphNode{Name: "root", Value: 100, Children: []*analyzer.FlameGraphNode{
+		{Name: "main.main", Value: 100, Children: []*analyzer.FlameGraphNode{{Name: "main.work", Value: 60}}},
+	}}
+	data, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "pprof-"+analysisID+"-analysis-analyze_pprof-cpu-flamegraph.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The flame graph is recorded with a copy in the workspace store, as with a storage backend
+	ctx, cancel := storageContext()
+	defer cancel()
+	artifact := AnalysisArtifact{Path: path, Kind: "analysis", Source: "analyze_pprof-cpu-flamegraph", Temporary: true,
+		CreatedAt: time.Now(), StoredKey: analysisArtifactKey(analysisID, path), StoredAt: time.Now()}
+	if err := workspaceStore().Put(ctx, artifact.StoredKey, data); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &AnalysisManifest{AnalysisID: analysisID, CreatedAt: time.Now(), Artifacts: []AnalysisArtifact{artifact}}
+	if err := writeAnalysisManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		workspaceStore().Delete(ctx, artifact.StoredKey)
+		workspaceStore().Delete(ctx, analysisManifestKey(analysisID))
+	})
+
+	subtree := func() (string, error) {
+		var request mcp.CallToolRequest
+		request.Params.Arguments = map[string]interface{}{"analysis_id": analysisID, "path": "main.main;main.work"}
+		result, err := handleGetFlamegraphSubtree(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		return result.Content[0].(mcp.TextContent).Text, nil
+	}
+	if text, err := subtree(); err != nil || !strings.Contains(text, `"main.work"`) {
+		t.Fatalf("Expected the subtree from the local file, got %q, %v", text, err)
+	}
+
+	// Once the temporary file is gone, the stored copy is used
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := subtree(); err != nil || !strings.Contains(text, `"value":60`) {
+		t.Fatalf("Expected the subtree from the stored copy, got %q, %v", text, err)
+	}
+
+	workspaceStore().Delete(ctx, artifact.StoredKey)
+	if _, err := subtree(); err == nil || !strings.Contains(err.Error(), "stored copy") {
+		t.Errorf("Expected an error naming the missing stored copy, got %v", err)
+	}
+}
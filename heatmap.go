@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// heatmapSnapshot is one column of a heatmap: the URI to load and the label shown for it.
+type heatmapSnapshot struct {
+	uri   string
+	label string
+}
+
+// analysisProfiles returns the profiles recorded in an analysis, in the order they were first recorded:
+// the caller's local inputs, and the profiles the server downloaded, decoded or produced for it.
+func analysisProfiles(analysisID string) ([]heatmapSnapshot, error) {
+	manifest, err := readAnalysisManifest(analysisID)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]heatmapSnapshot, 0)
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Kind != "input" && artifact.Kind != "profile" {
+			continue
+		}
+		// 下载的 profile 以原始 URL 标注；由工具生成的 (如 capture_fleet、subtract_profile) 以文件路径标注
+		label := artifact.Path
+		if artifact.Kind == "input" || strings.Contains(artifact.Source, "://") {
+			label = artifact.Source
+		}
+		snapshots = append(snapshots, heatmapSnapshot{uri: artifact.Path, label: label})
+	}
+	return snapshots, nil
+}
+
+// handleProfileHeatmap builds a function × snapshot heatmap from any number of profiles, e.g. heap snapshots
+// taken over hours, to show how hotspots evolve.
+func handleProfileHeatmap(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []heatmapSnapshot
+	uriList, _ := args["profile_uris"].(string)
+	for _, uri := range parseFleetTargets(uriList) {
+		snapshots = append(snapshots, heatmapSnapshot{uri: uri, label: uri})
+	}
+	if len(snapshots) == 0 {
+		if analysisID == "" {
+			return nil, fmt.Errorf("missing required argument: profile_uris (string) or analysis_id (string)")
+		}
+		if snapshots, err = analysisProfiles(analysisID); err != nil {
+			return nil, err
+		}
+		if len(snapshots) == 0 {
+			return nil, fmt.Errorf("analysis '%s' has no recorded profiles; pass profile_uris instead", analysisID)
+		}
+	}
+
+	sampleType, _ := args["sample_type"].(string)
+
+	limitFloat, ok := args["limit"].(float64)
+	if !ok {
+		limitFloat = 10.0
+	}
+	limit := int(limitFloat)
+	if limit <= 0 {
+		limit = 10
+	}
+
+	log.Printf("Handling profile_heatmap: Snapshots=%d, SampleType=%s, Limit=%d, AnalysisID=%s",
+		len(snapshots), sampleType, limit, analysisID)
+
+	profiles := make([]*profile.Profile, len(snapshots))
+	labels := make([]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		// loadProfile 返回的 profile 可能被共享，BuildHeatmap 只读取它们
+		if profiles[i], err = loadProfile(ctx, snapshot.uri, analysisID); err != nil {
+			return nil, fmt.Errorf("failed to load snapshot '%s': %w", snapshot.label, err)
+		}
+		labels[i] = snapshot.label
+	}
+
+	result, err := analyzer.FormatHeatmapJSON(profiles, labels, sampleType, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build heatmap: %w", err)
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "profile_heatmap", "json", result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), profiles...), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxInlineEnv bounds the size of artifacts returned inline ('return_inline'), in MB.
+const maxInlineEnv = "PPROF_ANALYZER_MAX_INLINE_MB"
+
+// defaultMaxInlineMB keeps inline artifacts well below common MCP client message limits.
+const defaultMaxInlineMB = 8
+
+// withReturnInline declares the 'return_inline' argument on a tool producing a file.
+func withReturnInline(what string) mcp.ToolOption {
+	return mcp.WithBoolean("return_inline",
+		mcp.Description(fmt.Sprintf("If true, the %s is also returned base64-encoded in the response (an embedded blob resource), for remote clients that cannot read the server's filesystem. Artifacts larger than $%s (default %d MB) are not inlined.", what, maxInlineEnv, defaultMaxInlineMB)),
+		mcp.DefaultBool(false),
+	)
+}
+
+// maxInlineBytes returns the inline artifact limit in bytes from $PPROF_ANALYZER_MAX_INLINE_MB, or the default.
+func maxInlineBytes() int64 {
+	mb := int64(defaultMaxInlineMB)
+	if value := os.Getenv(maxInlineEnv); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			log.Printf("Warning: ignoring invalid %s=%q, using %d MB", maxInlineEnv, value, mb)
+		} else {
+			mb = parsed
+		}
+	}
+	return mb << 20
+}
+
+// inlineArtifact returns the file at path as an embedded base64 blob resource. Files over maxInlineBytes
+// (or unreadable ones) yield a text note instead, so the call still succeeds with the artifact left on disk.
+func inlineArtifact(path, mimeType string) mcp.Content {
+	limit := maxInlineBytes()
+	info, err := os.Stat(path)
+	if err == nil && info.Size() > limit {
+		log.Printf("Not inlining '%s': %d bytes exceed the %d byte limit", path, info.Size(), limit)
+		return mcp.TextContent{Type: "text", Text: fmt.Sprintf("Not returned inline: %s is %d bytes, above the %d byte limit (raise $%s). It remains at %s on the server.",
+			filepath.Base(path), info.Size(), limit, maxInlineEnv, path)}
+	}
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		log.Printf("Failed to read artifact '%s' for inlining: %v", path, err)
+		return mcp.TextContent{Type: "text", Text: fmt.Sprintf("Not returned inline: failed to read %s: %v", path, err)}
+	}
+	return mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+		URI:      "file://" + filepath.ToSlash(path),
+		MIMEType: mimeType,
+		Blob:     base64.StdEncoding.EncodeToString(data),
+	})
+}
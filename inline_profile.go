@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// inlineProfileArg is the alternative to 'profile_uri' for clients that already hold the profile bytes
+// (e.g. captured themselves) and share no filesystem or URL with the server.
+const inlineProfileArg = "profile_data_base64"
+
+// maxInlineProfileEnv caps the decoded size of inline profiles, in MB (default 64). Larger profiles should be
+// passed by 'profile_uri', so they are not held in memory as JSON and base64 as well.
+const maxInlineProfileEnv = "PPROF_ANALYZER_MAX_INLINE_PROFILE_MB"
+
+const defaultMaxInlineProfileMB = 64
+
+// withInlineProfileData declares the inline profile argument on a tool whose 'profile_uri' it replaces.
+func withInlineProfileData() mcp.ToolOption {
+	return mcp.WithString(inlineProfileArg,
+		mcp.Description(fmt.Sprintf("The profile itself, base64-encoded (standard or URL-safe alphabet, gzipped or not), instead of 'profile_uri'. Exactly one of the two must be given. Profiles above %d MB (%s) must be passed by 'profile_uri'.",
+			captureLimit(maxInlineProfileEnv, defaultMaxInlineProfileMB), maxInlineProfileEnv)),
+	)
+}
+
+// materializeInlineProfile writes an inline profile argument to a temporary file and replaces it with a
+// 'profile_uri' pointing there, so handlers load it like any local profile. The returned function removes the
+// file, except for files belonging to an analysis: those are kept until cleanup_analysis, like downloads.
+func materializeInlineProfile(tool mcp.Tool, args map[string]interface{}) (cleanup func(), err error) {
+	cleanup = func() {}
+	if _, ok := tool.InputSchema.Properties[inlineProfileArg]; !ok {
+		return cleanup, nil
+	}
+	value, present := args[inlineProfileArg]
+	uriStr, _ := args["profile_uri"].(string)
+	if !present || value == nil || value == "" {
+		if uriStr == "" {
+			if _, ok := tool.InputSchema.Properties[profileCommandArg]; ok {
+				return nil, fmt.Errorf("missing required argument: profile_uri (string), %s (string) or %s (string)", inlineProfileArg, profileCommandArg)
+			}
+			return nil, fmt.Errorf("missing required argument: profile_uri (string) or %s (string)", inlineProfileArg)
+		}
+		return cleanup, nil
+	}
+	encoded, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid argument %s: expected a string, got %s", inlineProfileArg, jsonTypeName(value))
+	}
+	if uriStr != "" {
+		return nil, fmt.Errorf("profile_uri and %s are mutually exclusive: pass only one of them", inlineProfileArg)
+	}
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decodeBase64Profile(encoded, int64(captureLimit(maxInlineProfileEnv, defaultMaxInlineProfileMB))<<20)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument %s: %w", inlineProfileArg, err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid argument %s: the decoded profile is empty", inlineProfileArg)
+	}
+
+	// 与下载的 profile 命名相同；配置了密钥时加密保存，读取时解密 (见 openProfileFile)
+	filePath, err := writeSealedTemp(analysisTempPattern(analysisID, "profile"), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the inline profile to a temporary file: %w", err)
+	}
+	removeTemp := func() {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove temporary file '%s': %v", filePath, err)
+		}
+	}
+	log.Printf("Wrote inline profile (%d bytes) to temporary file: %s", len(data), filePath)
+
+	cleanup = removeTemp
+	if analysisID != "" {
+		cleanup = func() {}
+		if err := recordAnalysisArtifact(analysisID, AnalysisArtifact{Path: filePath, Kind: "profile", Source: inlineProfileArg, Temporary: true}); err != nil {
+			log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+		}
+	}
+	delete(args, inlineProfileArg)
+	args["profile_uri"] = filePath
+	return cleanup, nil
+}
+
+// decodeBase64Profile accepts the standard and URL-safe alphabets, with or without padding, ignoring
+// whitespace and line breaks added by encoders such as 'base64' without '-w0'. Profiles that would decode to
+// more than maxBytes are rejected before decoding. Gzipped and raw profiles are both accepted as is; the
+// profile parser detects the compression.
+func decodeBase64Profile(encoded string, maxBytes int64) ([]byte, error) {
+	encoded = strings.Join(strings.Fields(encoded), "")
+	encoded = strings.TrimSuffix(strings.TrimSuffix(encoded, "="), "=")
+	if size := int64(base64.RawStdEncoding.DecodedLen(len(encoded))); size > maxBytes {
+		return nil, fmt.Errorf("the profile is %d bytes, more than the limit of %d MB (%s); pass it by profile_uri instead", size, maxBytes>>20, maxInlineProfileEnv)
+	}
+	if strings.ContainsAny(encoded, "-_") {
+		return base64.RawURLEncoding.DecodeString(encoded)
+	}
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestDecodeBase64Profile(t *testing.T) {
+	data := []byte("\x1f\x8b\x08\x00profile bytes\xfb\xff")
+	std := base64.StdEncoding.EncodeToString(data)
+	cases := []struct {
+		name     string
+		encoded  string
+		maxBytes int64
+		want     []byte
+		err      string // Expected substring of the error; empty when decoding succeeds
+	}{
+		{name: "Standard", encoded: std, maxBytes: 1 << 20, want: data},
+		{name: "Unpadded", encoded: strings.TrimRight(std, "="), maxBytes: 1 << 20, want: data},
+		{name: "URLSafe", encoded: base64.URLEncoding.EncodeToString(data), maxBytes: 1 << 20, want: data},
+		{name: "LineBreaks", encoded: std[:8] + "\n" + std[8:16] + "\r\n  " + std[16:], maxBytes: 1 << 20, want: data},
+		{name: "InvalidCharacter", encoded: "cHJv*mlsZQ", maxBytes: 1 << 20, err: "illegal base64 data"},
+		{name: "MixedAlphabets", encoded: "ab+c-d", maxBytes: 1 << 20, err: "illegal base64 data"},
+		{name: "Truncated", encoded: "a", maxBytes: 1 << 20, err: "illegal base64 data"},
+		{name: "AtLimit", encoded: std, maxBytes: int64(len(data)), want: data},
+		{name: "OverLimit", encoded: std, maxBytes: int64(len(data)) - 1, err: "pass it by profile_uri instead"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeBase64Profile(tc.encoded, tc.maxBytes)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("Decoded %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaterializeInlineProfile(t *testing.T) {
+	p := poolTestProfile("main.inline", 7, 8)
+	var gzipped bytes.Buffer
+	if err := p.Write(&gzipped); err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	if err := p.WriteUncompressed(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if gzipped.Bytes()[0] != 0x1f || raw.Bytes()[0] == 0x1f {
+		t.Fatal("Expected one gzipped and one raw encoding of the profile")
+	}
+	tool := mcp.NewTool("inline_tool", mcp.WithString("profile_uri"), withInlineProfileData())
+
+	cases := []struct {
+		name string
+		args map[string]interface{}
+		err  string // Expected substring of the error; empty when the profile is materialized
+	}{
+		// The profile parser detects gzip, so both encodings load to the same profile
+		{name: "Gzipped", args: map[string]interface{}{inlineProfileArg: base64.StdEncoding.EncodeToString(gzipped.Bytes())}},
+		{name: "Raw", args: map[string]interface{}{inlineProfileArg: base64.StdEncoding.EncodeToString(raw.Bytes())}},
+		{name: "Missing", args: map[string]interface{}{}, err: "missing required argument"},
+		{name: "Both", args: map[string]interface{}{"profile_uri": "cpu.pb.gz", inlineProfileArg: "AAAA"}, err: "mutually exclusive"},
+		{name: "NotAString", args: map[string]interface{}{inlineProfileArg: 1.0}, err: "expected a string, got a number"},
+		{name: "InvalidBase64", args: map[string]interface{}{inlineProfileArg: "not base64!"}, err: "invalid argument " + inlineProfileArg},
+		{name: "Empty", args: map[string]interface{}{inlineProfileArg: " \n "}, err: "the decoded profile is empty"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cleanup, err := materializeInlineProfile(tool, tc.args)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer cleanup()
+			if _, ok := tc.args[inlineProfileArg]; ok {
+				t.Errorf("Expected %s to be replaced by profile_uri", inlineProfileArg)
+			}
+			uri, _ := tc.args["profile_uri"].(string)
+			loaded, err := loadProfile(context.Background(), uri, "")
+			if err != nil {
+				t.Fatalf("Failed to load the materialized profile: %v", err)
+			}
+			if len(loaded.Sample) != 2 || loaded.Function[0].Name != "main.inline" {
+				t.Errorf("Expected the inline profile, got %d samples", len(loaded.Sample))
+			}
+		})
+	}
+
+	t.Run("OverLimit", func(t *testing.T) {
+		t.Setenv(maxInlineProfileEnv, "1")
+		args := map[string]interface{}{inlineProfileArg: strings.Repeat("A", 2<<20)}
+		if _, err := materializeInlineProfile(tool, args); err == nil || !strings.Contains(err.Error(), "more than the limit of 1 MB") {
+			t.Errorf("Expected the size limit to be enforced, got %v", err)
+		}
+	})
+}
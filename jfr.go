@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// jfrMagic starts every Java Flight Recorder chunk.
+var jfrMagic = []byte("FLR\x00")
+
+// jfrToolEnv points to the JDK's jfr tool when it is neither in PATH nor in $JAVA_HOME/bin.
+const jfrToolEnv = "PPROF_ANALYZER_JFR"
+
+// jfrStackDepth is the number of frames 'jfr print' keeps per stack; its default of 5 is too shallow for
+// cumulative analyses.
+const jfrStackDepth = 2048
+
+// jfrConversionTimeout bounds the conversion of one recording.
+const jfrConversionTimeout = 5 * time.Minute
+
+// isJFR reports whether data is a binary Java Flight Recorder recording (.jfr).
+func isJFR(data []byte) bool {
+	return bytes.HasPrefix(data, jfrMagic)
+}
+
+// jfrTool returns the path of the JDK's jfr tool: $PPROF_ANALYZER_JFR, jfr in PATH, then $JAVA_HOME/bin/jfr.
+func jfrTool() (string, bool) {
+	if tool := os.Getenv(jfrToolEnv); tool != "" {
+		return tool, true
+	}
+	if tool, err := exec.LookPath("jfr"); err == nil {
+		return tool, true
+	}
+	if home := os.Getenv("JAVA_HOME"); home != "" {
+		tool := filepath.Join(home, "bin", "jfr")
+		if _, err := os.Stat(tool); err == nil {
+			return tool, true
+		}
+	}
+	return "", false
+}
+
+// convertJFR converts the Java Flight Recorder recording at filePath to a pprof profile: the JDK's jfr tool
+// prints its CPU and allocation events as JSON (see analyzer.JFREvents), which analyzer.ParseJFRJSON turns
+// into samples. The profile is returned as serialized protobuf, like the output of convertPerfData.
+func convertJFR(ctx context.Context, filePath string) ([]byte, error) {
+	tool, ok := jfrTool()
+	if !ok {
+		return nil, fmt.Errorf("'%s' is a Java Flight Recorder recording; converting it requires the JDK's jfr tool "+
+			"(JDK 11+) in PATH, in $JAVA_HOME/bin or %s pointing to it, or pass the output of "+
+			"'jfr print --json --stack-depth %d --events %s %s' instead",
+			filePath, jfrToolEnv, jfrStackDepth, strings.Join(analyzer.JFREvents, ","), filePath)
+	}
+	args := []string{"print", "--json", "--stack-depth", strconv.Itoa(jfrStackDepth), "--events", strings.Join(analyzer.JFREvents, ","), filePath}
+	if confirmErr := confirmSpawnContext(ctx, append([]string{tool}, args...)); confirmErr != nil {
+		return nil, confirmErr
+	}
+
+	// The JSON of long recordings is large: keep it on disk rather than in memory
+	out, err := os.CreateTemp("", "pprof-jfr-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for the converted recording: %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, jfrConversionTimeout)
+	defer cancel()
+	log.Printf("Converting JFR recording '%s' with %s", filePath, tool)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stdout, cmd.Stderr = out, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to convert JFR recording '%s': %w. Output: %s", filePath, err, stderr.String())
+	}
+	if _, err := out.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("failed to read converted recording '%s': %w", filePath, err)
+	}
+	p, err := analyzer.ParseJFRJSON(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert JFR recording '%s': %w", filePath, err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize the profile converted from '%s': %w", filePath, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// convertJFRJSON converts the output of 'jfr print --json' read from filePath, e.g. printed where the
+// recording was taken, to a pprof profile like convertJFR, without running the jfr tool.
+func convertJFRJSON(filePath string, data []byte) ([]byte, error) {
+	log.Printf("Converting jfr print --json output '%s'", filePath)
+	p, err := analyzer.ParseJFRJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert jfr print output '%s': %w", filePath, err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize the profile converted from '%s': %w", filePath, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testJFRJSON = `{"recording": {"events": [{"type": "jdk.ExecutionSample", "values": {
+	"startTime": "2024-05-01T10:00:00Z", "sampledThread": {"javaName": "main"},
+	"stackTrace": {"frames": [{"method": {"type": {"name": "com.example.App"}, "name": "work"}, "lineNumber": 7}]}}}]}}`
+
+func TestLoadProfileJFR(t *testing.T) {
+	dir := t.TempDir()
+
+	// Output of 'jfr print --json' is parsed without the jfr tool
+	jsonPath := filepath.Join(dir, "recording.json")
+	if err := os.WriteFile(jsonPath, []byte(testJFRJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prof, err := loadProfile(context.Background(), jsonPath, "")
+	if err != nil {
+		t.Fatalf("loadProfile failed on jfr print output: %v", err)
+	}
+	if len(prof.Sample) != 1 || prof.Sample[0].Location[0].Line[0].Function.Name != "com.example.App.work" {
+		t.Errorf("Unexpected converted profile: %d samples", len(prof.Sample))
+	}
+
+	// A binary recording goes through the jfr tool, here a script printing the same JSON
+	jfrPath := filepath.Join(dir, "recording.jfr")
+	if err := os.WriteFile(jfrPath, []byte("FLR\x00\x00\x02\x00\x01 binary chunk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	argsPath := filepath.Join(dir, "args")
+	tool := filepath.Join(dir, "jfr")
+	script := "#!/bin/sh\necho \"$@\" > " + argsPath + "\ncat " + jsonPath + "\n"
+	if err := os.WriteFile(tool, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(jfrToolEnv, tool)
+	t.Setenv(confirmEnv, "on")
+
+	ctx := withConfirmationScope(context.Background(), "analyze_pprof", map[string]interface{}{"profile_uri": jfrPath})
+	var confirmErr *confirmationRequired
+	if _, err := loadProfile(ctx, jfrPath, ""); !errors.As(err, &confirmErr) {
+		t.Fatalf("Expected a confirmation request before running the jfr tool, got %v", err)
+	}
+
+	ctx = withConfirmationScope(context.Background(), "analyze_pprof", map[string]interface{}{"profile_uri": jfrPath, "confirm": true})
+	prof, err = loadProfile(ctx, jfrPath, "")
+	if err != nil {
+		t.Fatalf("loadProfile failed on a JFR recording: %v", err)
+	}
+	if len(prof.Sample) != 1 || prof.DefaultSampleType != "samples" {
+		t.Errorf("Unexpected converted recording: %d samples, default sample type %q", len(prof.Sample), prof.DefaultSampleType)
+	}
+	args, _ := os.ReadFile(argsPath)
+	if !strings.HasPrefix(string(args), "print --json --stack-depth 2048 --events jdk.ExecutionSample,") || !strings.Contains(string(args), jfrPath) {
+		t.Errorf("Unexpected jfr arguments: %q", args)
+	}
+}
@@ -1,21 +1,61 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
 )
 
 // handleAnalyzePprof 函数已移至 handler.go
 
+// serverVersion 是报告给 MCP 客户端的服务器版本，也会嵌入生成的 SVG 中 (见 artifact_metadata.go)
+const serverVersion = "0.1.0"
+
 func main() {
+	// 内部子命令：以独立进程运行 speedscope UI 服务器 (由 open_interactive_pprof 启动)
+	if len(os.Args) > 1 && os.Args[1] == serveSpeedscopeCommand {
+		if err := runSpeedscopeServer(os.Args[2:]); err != nil {
+			log.Fatalf("speedscope server error: %v", err)
+		}
+		return
+	}
+
+	// 所有分析器共用的数值格式 (字节单位制、千位分隔符、对齐方式)，CLI 可通过参数覆盖
+	configureValueFormatFromEnv()
+
+	// 分析清单与产物的持久化位置 (PPROF_ANALYZER_STORAGE)，配置错误时直接退出，而不是悄悄退回临时目录
+	if err := initWorkspaceStore(); err != nil {
+		log.Fatalf("Storage error: %v", err)
+	}
+
+	// 命令行模式：analyze / flamegraph / diff 子命令直接复用工具处理器，无需 MCP 客户端
+	if len(os.Args) > 1 {
+		if _, ok := cliCommands[os.Args[1]]; ok {
+			os.Exit(runCLI(os.Args[1], os.Args[2:]))
+		}
+		if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+			cliUsage(os.Stdout)
+			return
+		}
+	}
+
+	// MCP 工具调用的审计日志 (PPROF_ANALYZER_AUDIT_LOG)，无法打开时直接退出，而不是在无审计的情况下提供服务
+	if err := initAuditLog(); err != nil {
+		log.Fatalf("Audit log error: %v", err)
+	}
+
 	// 1. 初始化 MCP 服务器
 	mcpServer := server.NewMCPServer(
-		"PprofAnalyzer",       // 服务器名称
-		"0.1.0",               // 服务器版本
-		server.WithLogging(),  // 启用日志记录
-		server.WithRecovery(), // 启用 panic 恢复
+		"PprofAnalyzer",                // 服务器名称
+		serverVersion,                  // 服务器版本
+		server.WithLogging(),           // 启用日志记录
+		server.WithRecovery(),          // 启用 panic 恢复
+		server.WithHooks(auditHooks()), // 记录每个会话的客户端，用于审计日志
 	)
 
 	// 2. 定义 analyze_pprof 工具及其参数
@@ -26,22 +66,88 @@ func main() {
 
 		mcp.WithString("profile_uri", // 参数名称
 			mcp.Description("要分析的 pprof 文件的 URI (支持 'file://', 'http://', 'https://' 协议)。例如 'file:///path/to/profile.pb.gz' 或 'https://example.com/profile.pb.gz'。"),
-			mcp.Required(),
 		),
+		withInlineProfileData(),
+		withProfileCommand(),
 		mcp.WithString("profile_type", // 参数名称
-			mcp.Description("要分析的 pprof profile 的类型。"),
+			mcp.Description("要分析的 pprof profile 的类型。也接受常见别名，例如 'memory' (heap)、'contention' (mutex)、'blocking' (block)、'goroutines' (goroutine)、'threads' (threadcreate)。"),
 			mcp.Required(),
-			mcp.Enum("cpu", "heap", "goroutine", "allocs", "mutex", "block"),
+			mcp.Enum(analyzer.ProfileTypeNames("cpu", "heap", "goroutine", "allocs", "mutex", "block", "threadcreate")...),
 		),
 		mcp.WithNumber("top_n", // 参数名称
 			mcp.Description("返回结果的数量上限 (例如 Top 5, Top 10)。"),
 			mcp.DefaultNumber(5.0), // MCP Go SDK 使用 float64 表示数字，默认为 5
+			mcp.Min(1),
 		),
 		mcp.WithString("output_format", // 参数名称
-			mcp.Description("分析结果的输出格式。'flamegraph-json' 仅适用于 'cpu' 和 'heap' 类型，用于生成层级化的 JSON 数据。"),
-			mcp.DefaultString("flamegraph-json"),                    // 将默认值改为 flamegraph-json
-			mcp.Enum("text", "markdown", "json", "flamegraph-json"), // 添加新格式
+			mcp.Description("分析结果的输出格式。'flamegraph-json' 生成层级化的 JSON 火焰图数据，适用于所有类型：'goroutine'、'mutex'、'block' 和 'threadcreate' 为按所选样本类型 (默认为 goroutine/线程数或 delay) 加权的调用栈树。'markdown-compact' 为节省 LLM 上下文而设计 (缩写路径、合并列、仅包含热点函数和调用栈)，长度受 'max_chars' 限制，适用于所有类型。'callgraph' 输出调用图的节点 (函数的 flat/cum 值) 和带权重的边 (调用方→被调用方)，'callgraph-dot' 输出同一调用图的 Graphviz DOT 文本，'graphml' 输出 GraphML (可在 Gephi/yEd 中打开，节点带有 flat/cum 值和所属包等属性)；三者都适用于所有类型，节点数由 'top_n' 决定 (按 cum 值保留)。'centrality' 计算调用图的中心性：每个函数的 flow-through (既非叶子也非根时所在样本的值，即加权介数)、主要调用方/被调用方数量，并列出多数开销流经的瓶颈函数 (chokepoint)，作为 flat/cum 排名的补充；适用于所有类型。'edges' 列出最重的 top_n 条调用方→被调用方的边，包括其权重、占总量的比例和占被调用方 cum 值的比例；设置 'focus_regex' 时只列出进入匹配函数的边，用于回答“谁导致了对 X 的调用”；适用于所有类型。'folded' 输出 Brendan Gregg 风格的折叠调用栈 (每行 'main;foo;bar 123')，可直接用于 flamegraph.pl 等工具，适用于 'cpu'、'heap'、'allocs'、'mutex' 和 'block' 类型。"),
+			mcp.DefaultString("flamegraph-json"), // 将默认值改为 flamegraph-json
+			mcp.Enum("text", "markdown", "markdown-compact", "json", "flamegraph-json", "callgraph", "callgraph-dot", "graphml", "centrality", "edges", "folded"), // 添加新格式
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("要分析的样本类型 (例如 'alloc_objects')，默认为该 profile 类型的默认样本类型。可用的样本类型会列在分析结果末尾的 Sample Types 表中。适用于 'cpu'、'heap' 和 'allocs' 类型以及 'markdown-compact' 格式。"),
+		),
+		mcp.WithString("group_by",
+			mcp.Description("'heap' 和 'allocs' 的汇总粒度。'package' 将内存按包汇总 (归属于调用栈中第一个非标准库的包)，生成简短的“内存归属”摘要，并标记占比超过 'ownership_threshold' 的包，适用于容量评审。"),
+			mcp.DefaultString("function"),
+			mcp.Enum("function", "package"),
+		),
+		mcp.WithString("aggregation_level",
+			mcp.Description("Top N 列表、调用栈和调用图的汇总单位：'function' (默认) 按函数；'file' 按源文件；'package' 按函数名得出的 Go 包路径，便于在大型代码库中看出成本归属于哪个模块。flat 和 cum 值都按该单位汇总。不能与 group_by 'package' 同时使用。"),
+			mcp.DefaultString("function"),
+			mcp.Enum(analyzer.AggregationLevels()...),
+		),
+		mcp.WithNumber("ownership_threshold",
+			mcp.Description("group_by 为 'package' 时，单个包占总量的百分比超过该值即被标记。"),
+			mcp.DefaultNumber(20.0),
+			mcp.Min(0),
+			mcp.Max(100),
+		),
+		mcp.WithNumber("max_chars",
+			mcp.Description("'markdown-compact' 输出的目标字符预算；超出预算的行会被省略并注明。"),
+			mcp.DefaultNumber(4000.0),
+			mcp.Min(200),
+		),
+		mcp.WithNumber("max_samples",
+			mcp.Description("超过该样本数的 profile 会在构建调用树之前被确定性地降采样到约该数量 (保留热点和总值，其余为估算值)，以精度换取交互速度。数值越大越精确；0 表示不降采样。"),
+			mcp.DefaultNumber(0.0),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("sampling_seed",
+			mcp.Description("降采样使用的随机种子；相同的种子总是得到相同的结果。"),
+			mcp.DefaultNumber(0.0),
+		),
+		mcp.WithString("sort_by",
+			mcp.Description("CPU 分析中 Top N 函数的排序方式：'flat' 按函数自身耗时 (叶子帧)，'cum' 按累计耗时 (函数出现在堆栈任意位置，包含其调用的函数)。两列都会输出；其他 profile 类型始终按 flat 排序。"),
+			mcp.DefaultString("flat"),
+			mcp.Enum("flat", "cum"),
+		),
+		withFocusRegex(),
+		withIgnoreRegex(),
+		withTagFilter(),
+		withExcludeTestFrames(),
+		withMinSampleValue(),
+		withGroupByLabel(),
+		mcp.WithNumber("max_stack_depth",
+			mcp.Description("goroutine 分析和 'markdown-compact' 输出中每个堆栈显示的最大帧数 (从叶子开始)；更深的堆栈以 '… N more frames' 结尾，并保留完整帧数。0 表示显示全部帧 ('markdown-compact' 默认为 8)。"),
+			mcp.DefaultNumber(0.0),
+			mcp.Min(0),
 		),
+		mcp.WithBoolean("suggest_next",
+			mcp.Description("为 true 时在结果后附加机器可读的后续工具调用建议 (JSON，包含工具名、参数和原因)，例如 heap 分析后建议 detect_memory_leaks 或聚焦热点函数的火焰图，便于智能体客户端串联调用。"),
+			mcp.DefaultBool(false),
+		),
+		withLiveCaptureSeconds(),
+		withCaptureHz(),
+		mcp.WithBoolean("compare_previous_capture",
+			mcp.Description("profile_uri 为实时目标的 http(s) URL 时，服务器会保留该目标 (scheme、主机和路径) 的上一次采集；为 true 时在结果后附加简短的“自上次采集以来的变化 (N minutes ago)”部分 (总量变化及增长和减少最多的函数)，无需单独调用 diff_profiles。首次采集或内容未变时不附加。"),
+			mcp.DefaultBool(true),
+		),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
 	)
 
 	// 3. 定义 generate_flamegraph 工具
@@ -49,17 +155,28 @@ func main() {
 		mcp.WithDescription("使用 'go tool pprof' 为指定的 pprof 文件生成火焰图 (SVG 格式)，将其保存到指定路径，并返回路径和 SVG 内容。"), // 更新描述
 		mcp.WithString("profile_uri",
 			mcp.Description("要生成火焰图的 pprof 文件的 URI (支持 'file://', 'http://', 'https://' 协议)。"),
-			mcp.Required(),
 		),
+		withInlineProfileData(),
+		withProfileCommand(),
 		mcp.WithString("profile_type",
-			mcp.Description("要生成火焰图的 pprof profile 的类型。"),
+			mcp.Description("要生成火焰图的 pprof profile 的类型。也接受常见别名，例如 'memory' (heap)、'contention' (mutex)、'blocking' (block)、'goroutines' (goroutine)、'threads' (threadcreate)。"),
 			mcp.Required(),
-			mcp.Enum("cpu", "heap", "allocs", "goroutine", "mutex", "block"), // 支持的类型
+			mcp.Enum(analyzer.ProfileTypeNames("cpu", "heap", "allocs", "goroutine", "mutex", "block", "threadcreate")...), // 支持的类型 (含别名)
 		),
 		mcp.WithString("output_svg_path",
 			mcp.Description("生成的 SVG 火焰图文件的保存路径 (必须是绝对路径或相对于工作区的路径)。"),
 			mcp.Required(),
 		),
+		withOverwrite(),
+		withReturnInline("SVG flame graph (instead of the raw SVG text)"),
+		withConfirm(),
+		withFocusRegex(),
+		withIgnoreRegex(),
+		withExcludeTestFrames(),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
 	)
 
 	// 4. detect_memory_leaks
@@ -76,11 +193,40 @@ func main() {
 		mcp.WithNumber("threshold",
 			mcp.Description("The growth threshold for detecting memory leaks (0.1 represents a 10% increase)."),
 			mcp.DefaultNumber(0.1),
+			mcp.Min(0),
 		),
 		mcp.WithNumber("limit",
 			mcp.Description("The maximum number of potential memory leak types to return."),
 			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("top_k",
+			mcp.Description("The number of top regressions and improvements to list, ranked by materiality (|delta| × |delta %|)."),
+			mcp.DefaultNumber(5.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("ignore_functions",
+			mcp.Description("Comma-separated function regular expressions of known noise (e.g. 'runtime\\.futex,runtime\\.netpoll'); samples with a matching frame are excluded from the report. Added to the server-wide list in PPROF_ANALYZER_DIFF_IGNORE."),
+		),
+		mcp.WithBoolean("use_default_ignore",
+			mcp.Description("Whether to apply the server-wide ignore list from PPROF_ANALYZER_DIFF_IGNORE."),
+			mcp.DefaultBool(true),
+		),
+		mcp.WithBoolean("suggest_next",
+			mcp.Description("Append machine-readable suggested follow-up tool calls (JSON with tool, arguments and reason), e.g. subtracting the snapshots or comparing their stack sets."),
+			mcp.DefaultBool(false),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format: 'text' or 'markdown' (with markdown tables) for the leak report, or 'heatmap-json' for a function × snapshot matrix of inuse_space (values normalized per snapshot) for rendering heatmaps of hotspot evolution; 'limit' caps the number of functions. For more than two snapshots, use 'profile_heatmap'."),
+			mcp.Enum("text", "markdown", "heatmap-json"),
+			mcp.DefaultString("text"),
 		),
+		withMatchRenamedFunctions(),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
 	)
 
 	// 5. 定义 open_interactive_pprof 工具 (仅限 macOS)
@@ -94,6 +240,19 @@ func main() {
 			mcp.Description("指定 pprof Web UI 的监听地址和端口 (例如 ':8081')。如果省略，默认为 ':8081'。"),
 			// mcp.Optional(), // 不提供 Required() 即为可选
 		),
+		mcp.WithString("ui",
+			mcp.Description("交互式前端类型。'pprof' 使用 'go tool pprof' 的 Web UI；'speedscope' 将 profile 转换为 speedscope 格式并在本地提供服务 (设置 PPROF_ANALYZER_SPEEDSCOPE_DIR 可使用本地 speedscope 包，否则使用 speedscope.app)。默认为 'pprof'。"),
+			mcp.DefaultString("pprof"),
+			mcp.Enum("pprof", "speedscope"),
+		),
+		mcp.WithBoolean("open_browser",
+			mcp.Description("是否在服务器主机上使用默认浏览器打开 UI，与 'go tool pprof -http' 一样默认为 true；设为 false 则传入 -no_browser。远程运行时不会打开浏览器，而是返回可点击的 URL。"),
+			mcp.DefaultBool(true),
+		),
+		withConfirm(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
 	)
 
 	// 6. 定义 disconnect_pprof_session 工具
@@ -102,6 +261,7 @@ func main() {
 		mcp.WithNumber("pid", // 使用 Number 类型，因为 JSON 通常将数字表示为 float64
 			mcp.Description("要终止的后台 pprof 进程的 PID (由 'open_interactive_pprof' 返回)。"),
 			mcp.Required(),
+			mcp.Min(1),
 		),
 		mcp.WithString("http_address", // 可选参数
 			mcp.Description("指定 pprof Web UI 的监听地址和端口 (例如 ':8081')。如果省略，pprof 会自动选择。"),
@@ -109,17 +269,874 @@ func main() {
 		),
 	)
 
-	// 7. 将所有工具及其处理器函数添加到服务器
-	mcpServer.AddTool(analyzeTool, handleAnalyzePprof)
-	mcpServer.AddTool(flamegraphTool, handleGenerateFlamegraph)
-	mcpServer.AddTool(memoryLeakTool, handleDetectMemoryLeaks)
-	mcpServer.AddTool(openInteractiveTool, handleOpenInteractivePprof)
-	mcpServer.AddTool(disconnectTool, handleDisconnectPprofSession) // 注册断开连接工具
+	// 7. get_pprof_session_logs
+	sessionLogsTool := mcp.NewTool("get_pprof_session_logs",
+		mcp.WithDescription("Return the captured stdout/stderr of a background pprof process started by 'open_interactive_pprof', useful when the web UI fails to start."),
+		mcp.WithNumber("pid",
+			mcp.Description("The PID of the background pprof process (returned by 'open_interactive_pprof')."),
+			mcp.Required(),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("tail_lines",
+			mcp.Description("Only return the last N lines of output. If omitted or 0, all buffered output is returned."),
+			mcp.Min(0),
+		),
+	)
+
+	// 8. analyze_pool_effectiveness
+	poolTool := mcp.NewTool("analyze_pool_effectiveness",
+		mcp.WithDescription("Analyze an allocs profile for allocations flowing through sync.Pool (pool misses calling New) versus direct allocation. With a baseline profile taken before pooling, estimates how much allocation each pool saves."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The URI of the allocs (or heap) profile to analyze, supporting 'file://', 'http://', 'https://' protocols or a local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("baseline_profile_uri",
+			mcp.Description("Optional URI of an allocs profile captured before sync.Pool was introduced, used to estimate savings. When both profiles have a duration (delta profiles), the baseline is scaled to the duration of the profile; otherwise both must cover the same duration and load."),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The maximum number of pools to report."),
+			mcp.DefaultNumber(5.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 9. detect_leak_patterns
+	leakPatternTool := mcp.NewTool("detect_leak_patterns",
+		mcp.WithDescription("Detect well-known resource leak patterns (e.g. leaked time.Ticker/time.After, un-closed HTTP response bodies, contexts without cancel()) from goroutine and heap profiles, reporting the responsible call sites. Provide old snapshots as well to detect growth."),
+		mcp.WithString("pattern",
+			mcp.Description("The leak pattern to detect."),
+			mcp.Required(),
+			mcp.Enum(analyzer.LeakPatternNames()...),
+		),
+		mcp.WithString("goroutine_profile_uri",
+			mcp.Description("The URI of the (newer) goroutine profile."),
+		),
+		mcp.WithString("old_goroutine_profile_uri",
+			mcp.Description("The URI of an older goroutine profile, used to detect growth."),
+		),
+		mcp.WithString("heap_profile_uri",
+			mcp.Description("The URI of the (newer) heap profile."),
+		),
+		mcp.WithString("old_heap_profile_uri",
+			mcp.Description("The URI of an older heap profile, used to detect growth."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of call sites to report per profile kind."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 10. analyze_db_pool_contention
+	dbPoolTool := mcp.NewTool("analyze_db_pool_contention",
+		mcp.WithDescription("Analyzes database/sql connection pool contention from block, mutex and goroutine profiles: time spent waiting for a free connection, goroutines queued for one, and the query call sites driving the waits."),
+		mcp.WithString("block_profile_uri",
+			mcp.Description("The URI of a block profile."),
+		),
+		mcp.WithString("mutex_profile_uri",
+			mcp.Description("The URI of a mutex profile."),
+		),
+		mcp.WithString("goroutine_profile_uri",
+			mcp.Description("The URI of a goroutine profile."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of call sites to report per profile kind."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 11. attribute_costs
+	attributionTool := mcp.NewTool("attribute_costs",
+		mcp.WithDescription("Attributes the costs of a CPU or heap profile to gRPC methods or net/http handlers (attribute_by=handler) or, for profiles captured with 'go test', to Test*/Benchmark*/Fuzz* functions (attribute_by=test), producing a per-entity cost ranking without requiring pprof labels."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The URI of the profile to analyze, supporting 'file://', 'http://', 'https://' protocols or a local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("attribute_by",
+			mcp.Description("What to attribute costs to."),
+			mcp.DefaultString("handler"),
+			mcp.Enum(analyzer.AttributionModes()...),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("Optional sample type to attribute (e.g. 'cpu', 'inuse_space', 'alloc_space'). Defaults to the profile's default sample type."),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The maximum number of entries to report."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 12. cleanup_analysis
+	cleanupAnalysisTool := mcp.NewTool("cleanup_analysis",
+		mcp.WithDescription("Removes all temporary files (downloaded profiles, ...) recorded for an analysis ID, together with its manifest. The caller's own input profiles are never removed."),
+		mcp.WithString("analysis_id",
+			mcp.Description("The investigation ID passed to the other tools."),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("remove_outputs",
+			mcp.Description("Also remove outputs written to caller-chosen paths, such as flame graph SVGs."),
+			mcp.DefaultBool(false),
+		),
+	)
+
+	// 13. export_bundle
+	exportBundleTool := mcp.NewTool("export_bundle",
+		mcp.WithDescription("Zips the input profiles, all generated analyses (text/json/markdown), flame graphs and the manifest recorded for an analysis ID into a single archive, ready to attach to an incident ticket."),
+		mcp.WithString("analysis_id",
+			mcp.Description("The investigation ID passed to the other tools."),
+			mcp.Required(),
+		),
+		mcp.WithString("output_path",
+			mcp.Description("The path of the .zip archive to write."),
+			mcp.Required(),
+		),
+		withOverwrite(),
+		withReturnInline(".zip archive"),
+		withConfirm(),
+	)
+
+	// 14. import_pprof_config
+	importConfigTool := mcp.NewTool("import_pprof_config",
+		mcp.WithDescription("Imports a config saved from the 'go tool pprof' web UI (focus/ignore/hide/show/show_from/prune_from regexes, granularity, noinlines, drop_negative) and applies it as the default for all later analyses of the same profile URI."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile URI the config applies to; later tool calls must use the same URI."),
+			mcp.Required(),
+		),
+		mcp.WithString("config_path",
+			mcp.Description("Path of the pprof settings file or of a single config JSON object. Defaults to pprof's settings.json in the user config directory."),
+		),
+		mcp.WithString("config_name",
+			mcp.Description("The name of the saved config to use when the settings file contains several."),
+		),
+		mcp.WithBoolean("clear",
+			mcp.Description("Remove the imported config for this profile instead of importing one."),
+			mcp.DefaultBool(false),
+		),
+		withConfirm(),
+	)
+
+	// 15. query_profile
+	queryTool := mcp.NewTool("query_profile",
+		mcp.WithDescription("Evaluates an ad-hoc query directly over a parsed profile, for questions none of the canned analyses answer. Syntax: SELECT <sample type>[, ...] | * [WHERE <field> <op> <value> [AND ...]] [GROUP BY <key>[, ...]] [ORDER BY <sample type> [ASC|DESC]] [LIMIT n]. Fields/keys: function (WHERE: any frame, GROUP BY: leaf), leaf, root, file, label.<key>, numlabel.<key>. Operators: =, !=, ~ and !~ (regex) for strings; =, !=, >, >=, <, <= for numlabels. Example: SELECT alloc_space WHERE function ~ \"json\" AND label.handler = \"/api\" GROUP BY leaf LIMIT 10"),
+		mcp.WithString("profile_uri",
+			mcp.Description("The URI of the profile to query, supporting 'file://', 'http://', 'https://' protocols or a local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("query",
+			mcp.Description("The query to evaluate."),
+			mcp.Required(),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 16. get_flamegraph_subtree
+	subtreeTool := mcp.NewTool("get_flamegraph_subtree",
+		mcp.WithDescription("Returns only one subtree of a flame graph cached for an analysis ID (produced by analyze_pprof with output_format 'flamegraph-json' and the same analysis_id), enabling progressive drill-down without resending the full tree. Nodes whose children were cut off by max_depth report them in 'hiddenChildren'."),
+		mcp.WithString("analysis_id",
+			mcp.Description("The investigation ID the flame graph was generated with."),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("The node path, frame names separated by ';' (e.g. 'root;main.main;main.work'). The leading 'root' may be omitted; an empty path returns the root."),
+			mcp.Required(),
+		),
+		mcp.WithString("profile_type",
+			mcp.Description("Selects the flame graph of this profile type when the analysis holds several; defaults to the most recent one. Aliases such as 'memory' (heap) are accepted."),
+			mcp.Enum(analyzer.ProfileTypeNames("cpu", "heap", "allocs", "goroutine", "mutex", "block", "threadcreate")...),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("The number of levels below the node to include (0 for the whole subtree)."),
+			mcp.DefaultNumber(3.0),
+			mcp.Min(0),
+		),
+	)
+
+	// 17. capture_fleet
+	fleetTool := mcp.NewTool("capture_fleet",
+		mcp.WithDescription("Captures CPU profiles concurrently from several replicas of one service (each exposing net/http/pprof), merges them and analyzes the merged profile, giving a fleet-wide view in one call. All replicas are captured at the same time, for 'seconds' each. Replicas that fail are reported and left out of the merge. Replicas whose profile comes back empty (an idle service) are captured again for longer (see 'idle_retries'); when all of them stay idle, a 'service was idle' result is returned instead of an empty analysis."),
+		mcp.WithString("targets",
+			mcp.Description("The replicas to capture from, separated by commas or whitespace. Each is a base URL (e.g. 'http://10.0.0.1:6060'), a '/debug/pprof' URL, or a full CPU profile URL; the 'seconds' (and 'hz') query parameters are set automatically."),
+			mcp.Required(),
+		),
+		withCaptureSeconds(),
+		withCaptureHz(),
+		withIdleRetries(),
+		withIdleRetrySeconds(),
+		mcp.WithNumber("top_n",
+			mcp.Description("The number of top functions to show in the merged analysis."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the merged analysis. The per-replica capture summary and the per-replica variance of the top functions (mean, stddev, outlier replicas) are only included in 'text' and 'markdown'; 'variance-json' returns only the variance report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json", "flamegraph-json", "variance-json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription+" The merged profile is also saved so it can be passed to the other tools."),
+		),
+		withConfirm(),
+	)
+
+	// 18. subtract_profile
+	subtractTool := mcp.NewTool("subtract_profile",
+		mcp.WithDescription("Computes profile_uri − base_profile_uri like 'go tool pprof -base': matching stacks cancel out and stacks that shrank are clamped to zero. The result is written as a profile file whose path can be passed as 'profile_uri' to any other tool; repeating the same subtraction reuses it."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile to subtract from (e.g. the later snapshot), as a 'file://', 'http://', 'https://' URI or local path."),
+			mcp.Required(),
+		),
+		mcp.WithString("base_profile_uri",
+			mcp.Description("The base profile to subtract (e.g. the earlier snapshot). It must have the same sample types."),
+			mcp.Required(),
+		),
+		mcp.WithString("output_path",
+			mcp.Description("Where to write the resulting profile (.pb.gz). Defaults to a temporary file, removed by 'cleanup_analysis' when an analysis_id is given."),
+		),
+		withOverwrite(),
+		withConfirm(),
+		withMatchRenamedFunctions(),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+	)
+
+	// 19. compare_stack_sets
+	stackSetsTool := mcp.NewTool("compare_stack_sets",
+		mcp.WithDescription("Compares the functions or complete stacks of two profiles as sets, to quickly find code paths that appear only after a change (only_in_profile), disappeared (only_in_base) or are shared (intersection), with their values in both profiles."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile to compare (e.g. after the change), as a 'file://', 'http://', 'https://' URI or local path."),
+			mcp.Required(),
+		),
+		mcp.WithString("base_profile_uri",
+			mcp.Description("The base profile to compare against (e.g. before the change)."),
+			mcp.Required(),
+		),
+		mcp.WithString("operation",
+			mcp.Description("'intersection': present in both; 'only_in_profile': only in profile_uri; 'only_in_base': only in base_profile_uri; 'union': all, with where each is present."),
+			mcp.DefaultString("only_in_profile"),
+			mcp.Enum(analyzer.StackSetOperations...),
+		),
+		mcp.WithString("level",
+			mcp.Description("Compare functions (anywhere on a stack, with cumulative values) or complete stacks (root to leaf, with flat values)."),
+			mcp.DefaultString("function"),
+			mcp.Enum("function", "stack"),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type to report, present in both profiles (e.g. 'alloc_space'). Defaults to the profile's default sample type."),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The maximum number of entries to return, largest first."),
+			mcp.DefaultNumber(20.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		withMatchRenamedFunctions(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 20. is_same_profile
+	sameProfileTool := mcp.NewTool("is_same_profile",
+		mcp.WithDescription("Tells whether two URIs refer to byte-identical profiles (same SHA256) or semantically identical ones (same sample types and the same samples after normalizing IDs, sample order and capture time), e.g. before diffing two snapshots, to avoid comparing a file against itself by mistake."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The first profile, as a 'file://', 'http://', 'https://' URI or local path."),
+			mcp.Required(),
+		),
+		mcp.WithString("other_profile_uri",
+			mcp.Description("The profile to compare it with."),
+			mcp.Required(),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 21. diff_profiles
+	diffProfilesTool := mcp.NewTool("diff_profiles",
+		mcp.WithDescription("Compares two profiles of the same type (cpu, heap, allocs, goroutine, mutex or block) function by function, like 'go tool pprof -diff_base', e.g. before and after a change. Returns the functions that grew the most (regressions) and shrank the most (improvements), with absolute and percentage deltas, sorted by the size of the change. Flags profiles from builds with different optimization flags (e.g. a -gcflags=\"-N -l\" debug build vs a release build), detected from their inlining, so the diff is not mistaken for code changes. For heap growth by object type, see 'detect_memory_leaks'."),
+		mcp.WithString("old_profile_uri",
+			mcp.Description("The base profile (e.g. before the change), as a 'file://', 'http://', 'https://' URI or local path."),
+			mcp.Required(),
+		),
+		mcp.WithString("new_profile_uri",
+			mcp.Description("The profile to compare with it (e.g. after the change)."),
+			mcp.Required(),
+		),
+		mcp.WithString("profile_type",
+			mcp.Description("The type of both profiles. It selects the compared sample type: 'cpu' for cpu, 'inuse_space' for heap, 'alloc_space' for allocs, 'goroutine' for goroutine and 'delay' for mutex and block. Aliases such as 'contention' (mutex) are accepted."),
+			mcp.Required(),
+			mcp.Enum(analyzer.ProfileTypeNames(analyzer.DiffProfileTypes...)...),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type to compare instead, present in both profiles (e.g. 'contentions' or 'alloc_objects')."),
+		),
+		mcp.WithString("sort_by",
+			mcp.Description("Compare flat values (the function itself) or cum values (the function and everything it calls)."),
+			mcp.DefaultString("flat"),
+			mcp.Enum("flat", "cum"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The number of regressions and of improvements to return."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		withMatchRenamedFunctions(),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 22. replay_analysis
+	replayTool := mcp.NewTool(replayToolName,
+		mcp.WithDescription("Reproduces a past investigation from a record file: runs its recorded tool calls again, in order, with the same arguments, and reports for each whether the output is identical to the recorded one (or where it first differs). Calls are recorded when the server runs with "+recordFileEnv+" set. Only read-only analysis tools are replayed: calls that start processes, write or delete files, or capture profiles are skipped, as are calls whose local input profiles changed since the recording (different SHA256); remote profiles are fetched again. Useful for audits and for bug reports against the analyzer."),
+		mcp.WithString("record_path",
+			mcp.Description("The record file to replay. Defaults to the current "+recordFileEnv+"."),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description("Only replay the calls made with this investigation ID. Replayed calls write their results under the same ID again."),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the replay report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "json"),
+		),
+	)
+
+	// 23. profile_heatmap
+	heatmapTool := mcp.NewTool("profile_heatmap",
+		mcp.WithDescription("Builds a function × snapshot heatmap from any number of profiles, e.g. heap snapshots taken over hours, to show how hotspots evolve. Returns JSON with the top functions, the snapshot labels, the raw flat values and their per-snapshot normalized shares."),
+		mcp.WithString("profile_uris",
+			mcp.Description("The snapshots in column order, as 'file://', 'http://', 'https://' URIs or local paths separated by commas, spaces or newlines. When omitted, the profiles recorded in 'analysis_id' are used, in the order they were first loaded."),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type to compare (e.g. 'inuse_space', 'alloc_objects', 'cpu'). Defaults to the first snapshot's default sample type, 'inuse_space' for heap profiles."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of functions (rows), ranked by their highest normalized value in any snapshot."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription+" Without 'profile_uris', the heatmap covers the profiles recorded in it."),
+		),
+		withConfirm(),
+	)
+
+	// 24. annotate_source
+	annotateSourceTool := mcp.NewTool("annotate_source",
+		mcp.WithDescription("Lists the source lines of the functions matching a regular expression with the flat and cum values of every line, like 'go tool pprof -list', to find the hot lines of a hot function. Functions are ordered by cum value."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile, as a 'file://', 'http://', 'https://' URI or local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("function_regex",
+			mcp.Description("Regular expression matched against function names, e.g. 'main\\.handle' or 'json\\.\\(\\*decodeState\\)'."),
+			mcp.Required(),
+		),
+		mcp.WithString("source_root",
+			mcp.Description("Directory holding the source code, e.g. a checkout of the repository. The file paths recorded in the profile, and ever shorter suffixes of them, are looked up below it, so binaries built elsewhere or with -trimpath are resolved. Without it, the recorded paths are read as they are. Functions whose source is not found list only their sampled lines."),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type whose values are shown (e.g. 'alloc_space'); defaults to the profile's default sample type."),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of matching functions to list."),
+			mcp.DefaultNumber(float64(analyzer.DefaultAnnotateFunctions)),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("markdown"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 25. diff_flamegraph_structure
+	diffFlamegraphStructureTool := mcp.NewTool("diff_flamegraph_structure",
+		mcp.WithDescription("Compares the flame graphs (call trees) of two profiles of the same type structurally, to tell refactors from regressions. Subtrees whose call path changed, i.e. that moved to a different parent (code extracted into a helper, called through a new layer, ...), are matched by function name and shape and reported as moves, separately from pure value changes (self values that changed at a call path present in both) and from subtrees that were really added or removed. Paths use the node path syntax of 'get_flamegraph_subtree'. For per-function deltas, see 'diff_profiles'; for the whole differential (red/blue) flame graph tree, see 'diff_flamegraph'."),
+		mcp.WithString("old_profile_uri",
+			mcp.Description("The base profile (e.g. before the change), as a 'file://', 'http://', 'https://' URI or local path."),
+			mcp.Required(),
+		),
+		mcp.WithString("new_profile_uri",
+			mcp.Description("The profile to compare with it (e.g. after the change)."),
+			mcp.Required(),
+		),
+		mcp.WithString("profile_type",
+			mcp.Description("The type of both profiles. It selects the compared sample type like in 'diff_profiles'."),
+			mcp.Required(),
+			mcp.Enum(analyzer.ProfileTypeNames(analyzer.DiffProfileTypes...)...),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type to compare instead, present in both profiles (e.g. 'contentions' or 'alloc_objects')."),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The number of entries to return in each list (moves, value changes, added and removed subtrees)."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		withMatchRenamedFunctions(),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 26. disassemble_function
+	disassembleTool := mcp.NewTool("disassemble_function",
+		mcp.WithDescription("Returns the assembly of the functions matching a regular expression, annotated with the flat and cum values of every instruction, by running 'go tool pprof -disasm' with the profile and the binary it was recorded from. For micro-optimizations, e.g. finding bounds checks, missed inlining or spills in a hot loop. Requires the Go toolchain on the server; for line-level annotations without the binary, see 'annotate_source'."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile, as a 'file://', 'http://', 'https://' URI or local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("binary_path",
+			mcp.Description("Local path of the exact binary the profile was recorded from (same build). A warning is returned when its build ID differs from the one recorded in the profile."),
+			mcp.Required(),
+		),
+		mcp.WithString("function_regex",
+			mcp.Description("Regular expression matched against function names, e.g. 'main\\.parseLine'. Keep it specific: the output is capped at "+fmt.Sprint(maxDisassemblyLines)+" lines."),
+			mcp.Required(),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type whose values are shown (pprof's -sample_index, e.g. 'alloc_space' or 'samples'); defaults to the profile's default sample type."),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the result."),
+			mcp.DefaultString("markdown"),
+			mcp.Enum("text", "markdown"),
+		),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 27. analyze_ci_artifacts
+	ciArtifactsTool := mcp.NewTool("analyze_ci_artifacts",
+		mcp.WithDescription("Finds the profiles referenced by CI output and analyzes each, returning a per-package summary (inferred profile type, total and hottest functions by flat value), so CI logs can be fed to the server as they are. Understands 'go test -json' output (profiles mentioned in a package's output are attributed to it, along with the package's test result), JSON artifact manifests (any object or array naming profile files, with an optional \"package\" field) and plain logs or file listings. Profiles are '.pprof', '.prof' and '.pb.gz' files, cpu.out-style names and the values of -cpuprofile/-memprofile/-blockprofile/-mutexprofile flags. Heap and allocs, and mutex and block profiles, are told apart by their file names. For a full report of one profile, pass it to 'analyze_pprof'."),
+		mcp.WithString("artifact_uri",
+			mcp.Description("The CI output ('go test -json' output, an artifact manifest or a log), as a 'file://', 'http://', 'https://' URI or local path. Exactly one of 'artifact_uri' and 'ci_output' must be given."),
+		),
+		mcp.WithString("ci_output",
+			mcp.Description("The CI output itself, instead of 'artifact_uri'."),
+		),
+		mcp.WithString("base_dir",
+			mcp.Description("Directory or URL that relative profile paths are resolved against, e.g. the checkout or the extracted artifacts. Defaults to the location of 'artifact_uri' (its directory, or its URL), or the server's working directory for 'ci_output'."),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The number of hottest functions shown per profile."),
+			mcp.DefaultNumber(3.0),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("max_profiles",
+			mcp.Description("The maximum number of profiles to analyze, in order of appearance; the rest are counted but not loaded."),
+			mcp.DefaultNumber(float64(defaultCIMaxProfiles)),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the summary."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 28. diff_flamegraph
+	diffFlamegraphTool := mcp.NewTool("diff_flamegraph",
+		mcp.WithDescription("Builds a differential flame graph of two profiles of the same type, for red/blue rendering: their call trees are merged by function name along the call path into one JSON tree whose nodes carry 'baseValue', 'newValue', 'delta' (new - base), 'selfDelta' and 'deltaPercent'. 'value' is the new value, as d3-flame-graph's differential mode expects; frames only in the base profile have a value of 0 (swap the profiles for the negated view). For a report of moved subtrees and the largest changes instead of the whole tree, see 'diff_flamegraph_structure'."),
+		mcp.WithString("old_profile_uri",
+			mcp.Description("The base profile (e.g. before the change), as a 'file://', 'http://', 'https://' URI or local path."),
+			mcp.Required(),
+		),
+		mcp.WithString("new_profile_uri",
+			mcp.Description("The profile to compare with it (e.g. after the change)."),
+			mcp.Required(),
+		),
+		mcp.WithString("profile_type",
+			mcp.Description("The type of both profiles. It selects the compared sample type like in 'diff_profiles'."),
+			mcp.Required(),
+			mcp.Enum(analyzer.ProfileTypeNames(analyzer.DiffProfileTypes...)...),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type to compare instead, present in both profiles (e.g. 'contentions' or 'alloc_objects')."),
+		),
+		mcp.WithBoolean("normalize",
+			mcp.Description("Scale the base values so both profiles have the same total, to compare the shape of profiles captured for different durations or under different load."),
+			mcp.DefaultBool(false),
+		),
+		withMatchRenamedFunctions(),
+		withPprofCommands(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 29. export_profile
+	exportProfileTool := mcp.NewTool("export_profile",
+		mcp.WithDescription("Writes a profile back to a pprof file (gzipped proto, .pb.gz) after merging, filtering and trimming it in memory like the analysis tools do, so the result can be saved and read by 'go tool pprof' or any other tool. Functions, locations and mappings no remaining sample refers to are dropped."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile to export, as a 'file://', 'http://', 'https://' URI or local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("merge_profile_uris",
+			mcp.Description("More profiles of the same type to merge into profile_uri before filtering (values of identical stacks are summed), separated by commas or newlines."),
+		),
+		mcp.WithString("output_path",
+			mcp.Description("Where to write the exported profile (.pb.gz)."),
+			mcp.Required(),
+		),
+		withFocusRegex(),
+		withIgnoreRegex(),
+		withTagFilter(),
+		withExcludeTestFrames(),
+		withMinSampleValue(),
+		mcp.WithNumber("max_samples",
+			mcp.Description("Downsample profiles with more samples to about this many (see 'analyze_pprof'), to trim very large profiles. The heaviest samples are kept exactly; the values of lighter ones are scaled estimates. 0 keeps every sample."),
+			mcp.DefaultNumber(0.0),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("sampling_seed",
+			mcp.Description("The seed of the downsampling; the same seed always gives the same result."),
+			mcp.DefaultNumber(0.0),
+		),
+		mcp.WithString("sample_type",
+			mcp.Description("The sample type whose values weigh samples when downsampling (e.g. 'alloc_space'); defaults to the profile's default sample type. Every sample type is exported."),
+		),
+		withOverwrite(),
+		withConfirm(),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+	)
+
+	// 30. export_otlp
+	exportOTLPTool := mcp.NewTool("export_otlp",
+		mcp.WithDescription("Converts a profile into the OpenTelemetry profiles format (pprofextended, opentelemetry-proto's v1experimental schema) as an OTLP/HTTP JSON export request, and returns it or pushes it to an OTLP endpoint (e.g. an OpenTelemetry Collector or an observability backend). Sample labels become attributes."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The profile to export, as a 'file://', 'http://', 'https://' URI or local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithString("otlp_endpoint",
+			mcp.Description("The OTLP/HTTP endpoint to push the profile to, e.g. 'http://collector:4318'; '/v1experimental/profiles' is appended when the URL has no path. Without it, the OTLP JSON is returned instead."),
+		),
+		mcp.WithString("otlp_headers",
+			mcp.Description("Headers sent with the push as comma-separated 'key=value' pairs, like OTEL_EXPORTER_OTLP_HEADERS (e.g. 'authorization=Bearer abc')."),
+		),
+		mcp.WithString("service_name",
+			mcp.Description("The 'service.name' resource attribute of the exported profile."),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 31. check_environment
+	checkEnvironmentTool := mcp.NewTool("check_environment",
+		mcp.WithDescription("Verifies the server's environment in one call: the presence and versions of the external tools (go, Graphviz dot, perf_to_profile, jfr), that the temporary directory, the configured storage and the record file are writable, and optionally that endpoints are reachable. Returns every check with a suggested fix, and a readiness matrix of the features depending on them, so setup problems are found before an analysis fails halfway. Only fixed version commands are run."),
+		mcp.WithString("endpoints",
+			mcp.Description("HTTP endpoints to check for reachability, separated by commas or whitespace, e.g. profiling targets ('http://10.0.0.1:6060/debug/pprof/') or an OTLP collector. Any HTTP response counts as reachable; pass base URLs rather than profile URLs, which would capture a profile."),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format: 'text' or 'json'."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "json"),
+		),
+	)
+
+	// 32. analyze_trace
+	analyzeTraceTool := mcp.NewTool("analyze_trace",
+		mcp.WithDescription("Summarizes a runtime/trace execution trace (written by runtime/trace.Start, 'go test -trace' or '/debug/pprof/trace'): the distributions (p50/p90/p99/max) of goroutine scheduler latency and running slices, the time goroutines spent blocked per reason (network, system calls, channels, sync, sleep, ...) and the code blocking the longest, and the utilization of each P. Answers latency questions CPU profiles cannot, e.g. whether goroutines wait for a P or for the network. With mode 'gc_analysis', reports GC pauses, mark assist time and the progression of the heap goal instead. Trace files are decoded by 'go tool trace -d=parsed', which requires the Go toolchain on the server (Go 1.22+ traces); its saved output is also accepted."),
+		mcp.WithString("trace_uri",
+			mcp.Description("The trace file, or the output of 'go tool trace -d=parsed', as a 'file://', 'http://', 'https://' URI or local path, e.g. 'http://localhost:6060/debug/pprof/trace?seconds=5'."),
+			mcp.Required(),
+		),
+		mcp.WithString("mode",
+			mcp.Description("'summary' for goroutine latency, blocking and proc utilization; 'gc_analysis' for the garbage collections: stop-the-world pause counts and durations, mark phases, mark assist time, each GC cycle with its heap goal, and a time series of the heap and the heap goal over the trace (in JSON output)."),
+			mcp.DefaultString("summary"),
+			mcp.Enum("summary", "gc_analysis"),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("The number of blocking sites shown, ranked by total blocked time; with 'gc_analysis', the number of GC cycles shown, ranked by pause time."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format: 'text' or 'json'."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "json"),
+		),
+		mcp.WithNumber("max_points",
+			mcp.Description("With 'gc_analysis', the maximum number of points of the heap time series; samples are merged into equal time buckets, keeping the last values and the peak of each."),
+			mcp.DefaultNumber(float64(analyzer.DefaultTraceGCPoints)),
+			mcp.Min(1),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 33. capture_profile
+	captureProfileTool := mcp.NewTool("capture_profile",
+		mcp.WithDescription("Captures a profile (or an execution trace) from a live service exposing net/http/pprof and saves it in a workspace directory, so it can be passed to the other tools; optionally analyzes it right away. CPU profiles and traces are captured for 'seconds'; for heap, allocs, mutex and block profiles, 'seconds' requests a delta profile over that window instead of the totals since the process started."),
+		mcp.WithString("base_url",
+			mcp.Description("The base URL the pprof handlers are served at, e.g. 'http://localhost:6060/debug/pprof'. A bare host (e.g. 'localhost:6060') uses the standard '/debug/pprof' path."),
+			mcp.Required(),
+		),
+		mcp.WithString("profile_type",
+			mcp.Description("The kind of profile to capture."),
+			mcp.Required(),
+			mcp.Enum("cpu", "heap", "allocs", "goroutine", "mutex", "block", "threadcreate", "trace"),
+		),
+		mcp.WithNumber("seconds",
+			mcp.Description(fmt.Sprintf("The capture duration in seconds (1-%d, limited by %s), sent as the 'seconds' query parameter. Defaults to %d for cpu and trace; for heap, allocs, mutex and block it requests a delta profile and is omitted by default.", maxCaptureSeconds(), maxCaptureSecondsEnv, defaultCaptureSeconds)),
+			mcp.Min(1),
+			mcp.Max(float64(maxCaptureSeconds())),
+		),
+		withCaptureHz(),
+		mcp.WithString("output_dir",
+			mcp.Description("The directory the capture is saved in, as '<profile_type>-<host>-<time>.pb.gz' ('.trace' for traces). Defaults to the workspace (the first directory of PPROF_ANALYZER_WORKSPACE, or the server's working directory); a directory outside it asks for confirmation."),
+		),
+		mcp.WithBoolean("analyze",
+			mcp.Description("Whether to analyze the captured profile right away, like analyze_pprof. Traces are only saved; pass them to analyze_trace."),
+			mcp.DefaultBool(false),
+		),
+		mcp.WithNumber("top_n",
+			mcp.Description("With 'analyze', the number of top functions to show."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("With 'analyze', the output format of the analysis."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json", "flamegraph-json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription+" The saved capture is recorded in it."),
+		),
+		withConfirm(),
+	)
+
+	// 34. start_snapshot_schedule
+	startSnapshotTool := mcp.NewTool("start_snapshot_schedule",
+		mcp.WithDescription(fmt.Sprintf("Starts capturing snapshot profiles (heap and goroutine by default) from a live service exposing net/http/pprof every 'interval_minutes', into a directory of the workspace, as the basis for trend and leak analysis over time. The first snapshots are captured right away. The schedule keeps running after the call returns, until stop_snapshot_schedule is called or the server exits; list_snapshot_schedules shows its progress. At most %d schedules run at once.", maxSnapshotSchedules)),
+		mcp.WithString("base_url",
+			mcp.Description("The base URL the pprof handlers are served at, e.g. 'http://localhost:6060/debug/pprof'. A bare host (e.g. 'localhost:6060') uses the standard '/debug/pprof' path."),
+			mcp.Required(),
+		),
+		mcp.WithString("profile_types",
+			mcp.Description("The profiles captured at each interval, separated by commas: heap, allocs, goroutine, mutex, block or threadcreate."),
+			mcp.DefaultString(defaultSnapshotTypes),
+		),
+		mcp.WithNumber("interval_minutes",
+			mcp.Description("The interval between snapshots, in minutes."),
+			mcp.DefaultNumber(defaultSnapshotIntervalMinutes),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("max_snapshots",
+			mcp.Description("The number of snapshot files the schedule keeps: once reached, the oldest are deleted (and removed from the analysis) after each capture."),
+			mcp.DefaultNumber(defaultMaxSnapshots),
+			mcp.Min(1),
+		),
+		mcp.WithString("output_dir",
+			mcp.Description("The directory the schedule's own subdirectory (named after its ID) is created in. Defaults to '"+snapshotsDirName+"' in the workspace; a directory outside it asks for confirmation."),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription+" Every snapshot is recorded in it, so the series of a single profile type can be passed to profile_heatmap with only the analysis_id."),
+		),
+		withConfirm(),
+	)
+
+	// 35. stop_snapshot_schedule
+	stopSnapshotTool := mcp.NewTool("stop_snapshot_schedule",
+		mcp.WithDescription("Stops a schedule started by start_snapshot_schedule, waiting for a capture in progress, and reports its snapshots. The saved snapshots are kept."),
+		mcp.WithString("schedule_id",
+			mcp.Description("The ID returned by start_snapshot_schedule."),
+			mcp.Required(),
+		),
+	)
+
+	// 36. list_snapshot_schedules
+	listSnapshotsTool := mcp.NewTool("list_snapshot_schedules",
+		mcp.WithDescription("Lists the running snapshot schedules: target, profile types, interval, directory, the number of snapshots saved and failed, the last error and the most recent files."),
+		mcp.WithString("output_format",
+			mcp.Description("The output format: 'text' or 'json'."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "json"),
+		),
+	)
+
+	// 37. detect_stuck_goroutines
+	stuckGoroutinesTool := mcp.NewTool("detect_stuck_goroutines",
+		mcp.WithDescription("Detects deadlocks and stuck goroutines in a goroutine profile or a full goroutine dump (goroutine?debug=2, or a crash traceback): call sites where many goroutines are parked on the same kind of channel operation, lock, select, WaitGroup or Cond, or, in dumps, have been waiting for long. Each finding has a severity, the suspect stacks and a likely-cause classification (e.g. a producer that exited, a missing Unlock, a missing WaitGroup.Done, a nil channel)."),
+		mcp.WithString("profile_uri",
+			mcp.Description("The URI of the goroutine profile or goroutine dump, supporting 'file://', 'http://', 'https://' protocols or a local path."),
+		),
+		withInlineProfileData(),
+		withProfileCommand(),
+		mcp.WithNumber("min_goroutines",
+			mcp.Description("The number of goroutines parked at the same call site from which it is reported."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("min_wait_minutes",
+			mcp.Description("Goroutine dumps only: a call site whose goroutines have been waiting at least this long is reported whatever their number. 0 disables it."),
+			mcp.DefaultNumber(5.0),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("The maximum number of findings to report."),
+			mcp.DefaultNumber(10.0),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("max_stack_depth",
+			mcp.Description("The maximum number of frames shown per stack (leaf first); 0 shows them all."),
+			mcp.DefaultNumber(0.0),
+			mcp.Min(0),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("The output format of the report."),
+			mcp.DefaultString("text"),
+			mcp.Enum("text", "markdown", "json"),
+		),
+		mcp.WithString("analysis_id",
+			mcp.Description(analysisIDDescription),
+		),
+		withConfirm(),
+	)
+
+	// 38. 将所有工具及其处理器函数添加到服务器
+	addTool(mcpServer, analyzeTool, handleAnalyzePprof)
+	addTool(mcpServer, flamegraphTool, handleGenerateFlamegraph)
+	addTool(mcpServer, memoryLeakTool, handleDetectMemoryLeaks)
+	addTool(mcpServer, openInteractiveTool, handleOpenInteractivePprof)
+	addTool(mcpServer, disconnectTool, handleDisconnectPprofSession) // 注册断开连接工具
+	addTool(mcpServer, sessionLogsTool, handleGetPprofSessionLogs)
+	addTool(mcpServer, poolTool, handleAnalyzePoolEffectiveness)
+	addTool(mcpServer, leakPatternTool, handleDetectLeakPatterns)
+	addTool(mcpServer, dbPoolTool, handleAnalyzeDBPoolContention)
+	addTool(mcpServer, attributionTool, handleAttributeCosts)
+	addTool(mcpServer, cleanupAnalysisTool, handleCleanupAnalysis)
+	addTool(mcpServer, exportBundleTool, handleExportBundle)
+	addTool(mcpServer, importConfigTool, handleImportPprofConfig)
+	addTool(mcpServer, queryTool, handleQueryProfile)
+	addTool(mcpServer, subtreeTool, handleGetFlamegraphSubtree)
+	addTool(mcpServer, fleetTool, handleCaptureFleet)
+	addTool(mcpServer, subtractTool, handleSubtractProfile)
+	addTool(mcpServer, stackSetsTool, handleCompareStackSets)
+	addTool(mcpServer, sameProfileTool, handleIsSameProfile)
+	addTool(mcpServer, diffProfilesTool, handleDiffProfiles)
+	addTool(mcpServer, replayTool, handleReplayAnalysis)
+	addTool(mcpServer, heatmapTool, handleProfileHeatmap)
+	addTool(mcpServer, annotateSourceTool, handleAnnotateSource)
+	addTool(mcpServer, diffFlamegraphStructureTool, handleDiffFlamegraphStructure)
+	addTool(mcpServer, disassembleTool, handleDisassembleFunction)
+	addTool(mcpServer, ciArtifactsTool, handleAnalyzeCIArtifacts)
+	addTool(mcpServer, diffFlamegraphTool, handleDiffFlamegraph)
+	addTool(mcpServer, exportProfileTool, handleExportProfile)
+	addTool(mcpServer, exportOTLPTool, handleExportOTLP)
+	addTool(mcpServer, checkEnvironmentTool, handleCheckEnvironment)
+	addTool(mcpServer, analyzeTraceTool, handleAnalyzeTrace)
+	addTool(mcpServer, captureProfileTool, handleCaptureProfile)
+	addTool(mcpServer, startSnapshotTool, handleStartSnapshotSchedule)
+	addTool(mcpServer, stopSnapshotTool, handleStopSnapshotSchedule)
+	addTool(mcpServer, listSnapshotsTool, handleListSnapshotSchedules)
+	addTool(mcpServer, stuckGoroutinesTool, handleDetectStuckGoroutines)
 
-	// 8. 设置信号处理程序以进行清理
+	// 39. 设置信号处理程序以进行清理
 	setupSignalHandler() // 在服务器启动前设置
 
-	// 9. Start the server using stdio transport
+	// 40. Start the server using stdio transport
 	log.Println("Starting PprofAnalyzer MCP server via stdio...")
 	if err := server.ServeStdio(mcpServer); err != nil {
 		log.Fatalf("Server error: %v", err)
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// memoryBudgetEnv sets the memory budget of the analyzer in MB. Requests that would exceed it are aborted
+// with a "profile too large" error instead of the server being OOM-killed. Without it the budget is 90% of
+// GOMEMLIMIT when that is set; 0 disables the guard.
+const memoryBudgetEnv = "PPROF_ANALYZER_MEMORY_BUDGET_MB"
+
+// memoryCheckInterval is how often the heap is checked while a request runs.
+const memoryCheckInterval = 50 * time.Millisecond
+
+// parseMemoryFactor roughly estimates the heap needed to parse a profile per byte of uncompressed protobuf.
+const parseMemoryFactor = 6
+
+// heapObjectsMetric is the runtime metric for the memory occupied by live and not yet swept heap objects.
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// memoryBudget is the memory budget in bytes, 0 if the guard is disabled. Handlers are guarded when they are
+// registered, so tests set it before calling memoryGuardedHandler.
+var memoryBudget = initMemoryBudget()
+
+// initMemoryBudget reads the budget from $PPROF_ANALYZER_MEMORY_BUDGET_MB or GOMEMLIMIT. An explicit budget
+// also becomes the GC's soft memory limit when GOMEMLIMIT is not set, so garbage is collected before the
+// guard mistakes it for live data.
+func initMemoryBudget() int64 {
+	limit := debug.SetMemoryLimit(-1) // -1 只读取当前值
+	if value := os.Getenv(memoryBudgetEnv); value != "" {
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && mb >= 0 {
+			budget := mb << 20
+			if budget > 0 && limit == math.MaxInt64 {
+				debug.SetMemoryLimit(budget)
+			}
+			return budget
+		}
+		log.Printf("Warning: ignoring invalid %s=%q", memoryBudgetEnv, value)
+	}
+	if limit != math.MaxInt64 {
+		return limit / 10 * 9
+	}
+	return 0
+}
+
+// memoryBudgetError reports a request aborted because it would exceed the memory budget.
+type memoryBudgetError struct {
+	Code        string `json:"error"` // Always "profile_too_large"
+	Message     string `json:"message"`
+	Tool        string `json:"tool,omitempty"`
+	HeapBytes   int64  `json:"heapBytes"`
+	NeededBytes int64  `json:"neededBytes,omitempty"` // Estimated additional memory, for checks before parsing
+	BudgetBytes int64  `json:"budgetBytes"`
+	Suggestion  string `json:"suggestion"`
+}
+
+func newMemoryBudgetError(message string, heap, needed int64) *memoryBudgetError {
+	return &memoryBudgetError{
+		Code:        "profile_too_large",
+		Message:     message,
+		HeapBytes:   heap,
+		NeededBytes: needed,
+		BudgetBytes: memoryBudget,
+		Suggestion: fmt.Sprintf("Retry analyze_pprof with 'max_samples' (e.g. 100000) to downsample the profile, "+
+			"narrow it down with query_profile, capture a shorter profile, or raise %s", memoryBudgetEnv),
+	}
+}
+
+func (e *memoryBudgetError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// toolResult returns the error as a structured tool error, so clients can tell it from other failures.
+func (e *memoryBudgetError) toolResult() *mcp.CallToolResult {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(e.Message)
+	}
+	return mcp.NewToolResultError(string(data))
+}
+
+// heapInUse returns the bytes currently occupied by heap objects. Tests replace it to make the heap grow
+// over the budget.
+var heapInUse = readHeapObjects
+
+// readHeapObjects reads the bytes occupied by heap objects from the runtime metrics.
+func readHeapObjects() int64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(sample[0].Value.Uint64())
+}
+
+// checkMemoryBudget returns a *memoryBudgetError if allocating needed more bytes would exceed the budget.
+// Pooled profiles are released and garbage is collected before giving up, since the heap metric includes
+// unreachable objects.
+func checkMemoryBudget(what string, needed int64) error {
+	if memoryBudget <= 0 {
+		return nil
+	}
+	heap := heapInUse()
+	if heap+needed <= memoryBudget {
+		return nil
+	}
+	// 先清空解析池并回收垃圾，再判断是否真的超出预算
+	parsedProfiles.purge()
+	runtime.GC()
+	if heap = heapInUse(); heap+needed <= memoryBudget {
+		return nil
+	}
+	return newMemoryBudgetError(fmt.Sprintf("%s needs about %d MB, but %d MB of the %d MB memory budget are in use",
+		what, needed>>20, heap>>20, memoryBudget>>20), heap, needed)
+}
+
+// estimateParseMemory estimates the heap needed to parse profile data. For gzip data the uncompressed size
+// is read from the gzip trailer (ISIZE, the size modulo 2^32).
+func estimateParseMemory(data []byte) int64 {
+	size := int64(len(data))
+	if len(data) >= 18 && data[0] == 0x1f && data[1] == 0x8b {
+		if uncompressed := int64(binary.LittleEndian.Uint32(data[len(data)-4:])); uncompressed > size {
+			size = uncompressed
+		}
+	}
+	return size * parseMemoryFactor
+}
+
+// guardedRequest is a request running under memoryGuardedHandler.
+type guardedRequest struct {
+	tool    string
+	cancel  context.CancelFunc
+	aborted chan *memoryBudgetError // Receives the error when the request is aborted (buffered)
+	abort   bool                    // Aborted; its handler has not returned yet
+}
+
+var (
+	guardMutex      sync.Mutex
+	guardedRequests []*guardedRequest // Requests whose handlers are running, oldest first
+	heapOverBudget  bool              // The heap was over the budget at the last check
+	monitorRunning  bool              // monitorHeap is running
+)
+
+// startGuardedRequest registers a running request, starting the heap monitor for the first one.
+func startGuardedRequest(tool string, cancel context.CancelFunc) *guardedRequest {
+	req := &guardedRequest{tool: tool, cancel: cancel, aborted: make(chan *memoryBudgetError, 1)}
+	guardMutex.Lock()
+	defer guardMutex.Unlock()
+	guardedRequests = append(guardedRequests, req)
+	if !monitorRunning {
+		monitorRunning = true
+		go monitorHeap()
+	}
+	return req
+}
+
+// finishGuardedRequest unregisters a request once its handler returned.
+func finishGuardedRequest(req *guardedRequest) {
+	guardMutex.Lock()
+	defer guardMutex.Unlock()
+	for i, r := range guardedRequests {
+		if r == req {
+			guardedRequests = append(guardedRequests[:i], guardedRequests[i+1:]...)
+			break
+		}
+	}
+}
+
+// monitorHeap checks the heap every memoryCheckInterval while requests are running.
+func monitorHeap() {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !checkGuardedRequests() {
+			return
+		}
+	}
+}
+
+// checkGuardedRequests aborts a request when the heap is over the budget, and reports whether requests are
+// still running. Pooled profiles are released and garbage is collected once when the heap crosses the budget,
+// not on every check. The heap is shared by all requests, so only the newest one is aborted, as the most
+// likely to still be allocating and the cheapest to retry; the next one is only aborted once that one's
+// handler returned (see memoryGuardedHandler) and the heap is still over the budget.
+func checkGuardedRequests() bool {
+	guardMutex.Lock()
+	defer guardMutex.Unlock()
+	if len(guardedRequests) == 0 {
+		heapOverBudget, monitorRunning = false, false
+		return false
+	}
+	heap := heapInUse()
+	if heap <= memoryBudget {
+		heapOverBudget = false
+		return true
+	}
+	if !heapOverBudget {
+		heapOverBudget = true
+		// 刚超出预算：先清空解析池并回收垃圾 (每次越界只做一次)，再判断是否真的超出
+		parsedProfiles.purge()
+		runtime.GC()
+		if heap = heapInUse(); heap <= memoryBudget {
+			heapOverBudget = false
+			return true
+		}
+	}
+	var newest *guardedRequest
+	for _, req := range guardedRequests {
+		if req.abort {
+			return true // Wait for the aborted request to release its memory
+		}
+		newest = req
+	}
+	newest.abort = true
+	budgetErr := newMemoryBudgetError(fmt.Sprintf("the heap grew to %d MB, over the %d MB memory budget, while %d request(s) were running; this one, the newest, was aborted",
+		heap>>20, memoryBudget>>20, len(guardedRequests)), heap, 0)
+	budgetErr.Tool = newest.tool
+	log.Printf("Aborting %s: %s", newest.tool, budgetErr.Message)
+	newest.cancel()
+	newest.aborted <- budgetErr
+	return true
+}
+
+// memoryGuardedHandler aborts a request when the heap exceeds the memory budget while it runs (see
+// checkGuardedRequests). The handler's context is canceled on abort, so loaders and analyzers stop at their
+// next check; its late result is discarded.
+func memoryGuardedHandler(toolName string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	if memoryBudget <= 0 {
+		return handler
+	}
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		type outcome struct {
+			result *mcp.CallToolResult
+			err    error
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		req := startGuardedRequest(toolName, cancel)
+		done := make(chan outcome, 1)
+		go func() {
+			defer finishGuardedRequest(req)
+			// 处理器在单独的 goroutine 中运行，服务器的 panic 恢复不覆盖这里
+			defer func() {
+				if r := recover(); r != nil {
+					done <- outcome{nil, fmt.Errorf("panic recovered in %s tool handler: %v", toolName, r)}
+				}
+			}()
+			result, err := handler(ctx, request)
+			done <- outcome{result, err}
+		}()
+
+		select {
+		case o := <-done:
+			var budgetErr *memoryBudgetError
+			if errors.As(o.err, &budgetErr) {
+				budgetErr.Tool = toolName
+				log.Printf("Refused %s: %v", toolName, budgetErr)
+				return budgetErr.toolResult(), nil
+			}
+			return o.result, o.err
+		case budgetErr := <-req.aborted:
+			return budgetErr.toolResult(), nil
+		}
+	}
+}
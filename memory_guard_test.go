@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"math"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestInitMemoryBudget(t *testing.T) {
+	limit := debug.SetMemoryLimit(math.MaxInt64)
+	t.Cleanup(func() { debug.SetMemoryLimit(limit) })
+
+	cases := []struct {
+		name       string
+		env        string
+		gomemlimit int64 // Soft memory limit before the call, as set by GOMEMLIMIT
+		want       int64
+		wantLimit  int64 // Soft memory limit after the call
+	}{
+		{name: "Unset", gomemlimit: math.MaxInt64, want: 0, wantLimit: math.MaxInt64},
+		{name: "Env", env: "512", gomemlimit: math.MaxInt64, want: 512 << 20, wantLimit: 512 << 20},
+		{name: "EnvWithGOMEMLIMIT", env: "256", gomemlimit: 1 << 30, want: 256 << 20, wantLimit: 1 << 30},
+		{name: "Disabled", env: "0", gomemlimit: 1 << 30, want: 0, wantLimit: 1 << 30},
+		{name: "GOMEMLIMIT", gomemlimit: 1000 << 20, want: 900 << 20, wantLimit: 1000 << 20},
+		{name: "Invalid", env: "lots", gomemlimit: 1000 << 20, want: 900 << 20, wantLimit: 1000 << 20},
+		{name: "Negative", env: "-1", gomemlimit: math.MaxInt64, want: 0, wantLimit: math.MaxInt64},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(memoryBudgetEnv, tc.env)
+			debug.SetMemoryLimit(tc.gomemlimit)
+			if got := initMemoryBudget(); got != tc.want {
+				t.Errorf("Expected a budget of %d, got %d", tc.want, got)
+			}
+			if got := debug.SetMemoryLimit(-1); got != tc.wantLimit {
+				t.Errorf("Expected a memory limit of %d, got %d", tc.wantLimit, got)
+			}
+		})
+	}
+}
+
+func TestEstimateParseMemory(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(bytes.Repeat([]byte("profile "), 1<<16))
+	w.Close()
+	gzipped := compressed.Bytes()
+
+	cases := []struct {
+		name string
+		data []byte
+		want int64
+	}{
+		{name: "Protobuf", data: make([]byte, 1000), want: 1000 * parseMemoryFactor},
+		{name: "Gzip", data: gzipped, want: 8 << 16 * parseMemoryFactor}, // ISIZE, the uncompressed size
+		{name: "ShortGzip", data: []byte{0x1f, 0x8b, 8, 0, 0xff, 0xff, 0xff, 0xff}, want: 8 * parseMemoryFactor},
+		// A trailer claiming less than the data itself is not trusted
+		{name: "SmallISIZE", data: append(append([]byte{0x1f, 0x8b}, make([]byte, 100)...), 1, 0, 0, 0), want: 106 * parseMemoryFactor},
+		{name: "Empty", data: nil, want: 0},
+	}
+	for _, tc := range cases {
+		if got := estimateParseMemory(tc.data); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+// fakeHeap sets the memory budget to budget and makes heapInUse report the returned value, until the test
+// and the heap monitor are done.
+func fakeHeap(t *testing.T, budget int64) *atomic.Int64 {
+	t.Helper()
+	heap := new(atomic.Int64)
+	oldBudget, oldHeap := memoryBudget, heapInUse
+	memoryBudget = budget
+	heapInUse = heap.Load
+	t.Cleanup(func() {
+		// The monitor stops at its first check without running requests
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(memoryCheckInterval) {
+			guardMutex.Lock()
+			running := monitorRunning
+			guardMutex.Unlock()
+			if !running {
+				break
+			}
+		}
+		memoryBudget, heapInUse = oldBudget, oldHeap
+	})
+	return heap
+}
+
+// resultText returns the text of a tool result.
+func resultText(result *mcp.CallToolResult) string {
+	if result == nil || len(result.Content) == 0 {
+		return ""
+	}
+	return result.Content[0].(mcp.TextContent).Text
+}
+
+func TestMemoryGuardedHandlerAbort(t *testing.T) {
+	heap := fakeHeap(t, 100<<20)
+	heap.Store(10 << 20)
+
+	// Both handlers run until their context is canceled or they are released
+	release := make(chan struct{})
+	handler := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		select {
+		case <-ctx.Done():
+			heap.Store(50 << 20) // The aborted request's memory is released
+			return mcp.NewToolResultText("late result"), nil
+		case <-release:
+			return mcp.NewToolResultText("done"), nil
+		}
+	}
+	type outcome struct {
+		result *mcp.CallToolResult
+		err    error
+	}
+	run := func(tool string) chan outcome {
+		done := make(chan outcome, 1)
+		guarded := memoryGuardedHandler(tool, handler)
+		go func() {
+			result, err := guarded(context.Background(), mcp.CallToolRequest{})
+			done <- outcome{result, err}
+		}()
+		// Wait for the request to be registered, so the order of the requests is known
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			guardMutex.Lock()
+			registered := len(guardedRequests) > 0 && guardedRequests[len(guardedRequests)-1].tool == tool
+			guardMutex.Unlock()
+			if registered {
+				break
+			}
+		}
+		return done
+	}
+	older := run("older_tool")
+	newer := run("newer_tool")
+
+	heap.Store(200 << 20)
+	select {
+	case o := <-newer:
+		text := resultText(o.result)
+		if o.err != nil || !o.result.IsError || !strings.Contains(text, `"error": "profile_too_large"`) ||
+			!strings.Contains(text, `"tool": "newer_tool"`) || !strings.Contains(text, `"budgetBytes": 104857600`) {
+			t.Errorf("Expected a structured profile_too_large result for the newest request, got %q (%v)", text, o.err)
+		}
+	case o := <-older:
+		t.Fatalf("Expected the newest request to be aborted, the oldest returned %q (%v)", resultText(o.result), o.err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a request to be aborted")
+	}
+
+	// Once the aborted request released its memory, the other one completes
+	time.Sleep(3 * memoryCheckInterval)
+	close(release)
+	if o := <-older; o.err != nil || resultText(o.result) != "done" {
+		t.Errorf("Expected the older request to complete, got %q (%v)", resultText(o.result), o.err)
+	}
+}
+
+func TestMemoryGuardedHandlerErrors(t *testing.T) {
+	fakeHeap(t, 100<<20)
+
+	// A request refused before parsing gets the same structured result
+	refuse := memoryGuardedHandler("analyze_pprof", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return nil, errors.Join(errors.New("failed to load profile"), newMemoryBudgetError("parsing needs about 500 MB", 0, 500<<20))
+	})
+	result, err := refuse(context.Background(), mcp.CallToolRequest{})
+	if text := resultText(result); err != nil || !strings.Contains(text, "profile_too_large") || !strings.Contains(text, `"tool": "analyze_pprof"`) {
+		t.Errorf("Expected a structured profile_too_large result, got %q (%v)", text, err)
+	}
+
+	// A panic in the handler's goroutine is recovered
+	panics := memoryGuardedHandler("analyze_pprof", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		panic("index out of range")
+	})
+	if _, err := panics(context.Background(), mcp.CallToolRequest{}); err == nil || !strings.Contains(err.Error(), "panic recovered in analyze_pprof tool handler: index out of range") {
+		t.Errorf("Expected the panic to be recovered as an error, got %v", err)
+	}
+
+	// The check before parsing compares the heap and the estimate with the budget
+	if err := checkMemoryBudget("parsing", 50<<20); err != nil {
+		t.Errorf("Expected a profile within the budget to be accepted, got %v", err)
+	}
+	var budgetErr *memoryBudgetError
+	if err := checkMemoryBudget("parsing", 200<<20); !errors.As(err, &budgetErr) || budgetErr.NeededBytes != 200<<20 {
+		t.Errorf("Expected a profile over the budget to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// otlpProfilesPath is the OTLP/HTTP path of the profiles signal in the schema analyzer.ConvertProfileToOTLP
+// produces, appended to endpoints given without a path.
+const otlpProfilesPath = "/v1experimental/profiles"
+
+// otlpPushTimeout bounds a push to an OTLP endpoint.
+const otlpPushTimeout = 30 * time.Second
+
+// otlpEndpointURL validates an 'otlp_endpoint' argument and returns the URL to post to.
+func otlpEndpointURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid otlp_endpoint '%s': expected an http:// or https:// URL such as 'http://collector:4318'", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpProfilesPath
+	}
+	return u.String(), nil
+}
+
+// parseOTLPHeaders parses the 'otlp_headers' argument: comma-separated 'key=value' pairs, like the
+// OTEL_EXPORTER_OTLP_HEADERS environment variable.
+func parseOTLPHeaders(s string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid otlp_headers entry '%s': expected key=value", pair)
+		}
+		headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// redactOTLPHeaders replaces the values of an 'otlp_headers' argument, which often carry credentials, for
+// the record file.
+func redactOTLPHeaders(s string) string {
+	pairs := make([]string, 0)
+	for _, pair := range strings.Split(s, ",") {
+		if key, _, ok := strings.Cut(pair, "="); ok {
+			pairs = append(pairs, strings.TrimSpace(key)+"=xxxxx")
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// pushOTLPProfile posts an OTLP/HTTP JSON export request to endpoint; any non-2xx status is an error.
+func pushOTLPProfile(ctx context.Context, endpoint string, headers http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid OTLP request to '%s': %w", endpoint, err)
+	}
+	for key, values := range headers {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: otlpPushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push profile to '%s': %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// 后端的错误信息 (例如不支持的 schema 版本) 说明了拒绝的原因
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to push profile to '%s': received status code %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// handleExportOTLP converts a profile into the OpenTelemetry profiles format and returns it, or pushes it
+// to an OTLP endpoint when 'otlp_endpoint' is given.
+func handleExportOTLP(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	serviceName, _ := args["service_name"].(string)
+	endpoint, _ := args["otlp_endpoint"].(string) // 为空时直接返回 OTLP JSON
+	if endpoint != "" {
+		if endpoint, err = otlpEndpointURL(endpoint); err != nil {
+			return nil, err
+		}
+	}
+	headersStr, _ := args["otlp_headers"].(string)
+	headers, err := parseOTLPHeaders(headersStr)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Handling export_otlp: URI=%s, Endpoint=%s, Service=%s", profileURIStr, endpoint, serviceName)
+	if endpoint != "" {
+		if confirmErr := confirmPush("export_otlp", args, endpoint); confirmErr != nil {
+			return confirmErr.toolResult(), nil
+		}
+	}
+	prof, err := loadProfile(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	body, err := analyzer.FormatOTLPJSON(prof, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint == "" {
+		hookReport := saveAnalysisResult(ctx, analysisID, "export_otlp", "json", string(body))
+		return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: string(body),
+				},
+			},
+		}, hookReport), prof), nil
+	}
+
+	if err := pushOTLPProfile(ctx, endpoint, headers, body); err != nil {
+		return nil, err
+	}
+	log.Printf("Pushed OTLP profile (%d bytes) to %s", len(body), endpoint)
+	return withRecoveryWarnings(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: fmt.Sprintf("Pushed the profile (%d samples, %d bytes of OTLP JSON) to %s\n", len(prof.Sample), len(body), endpoint),
+			},
+		},
+	}, prof), nil
+}
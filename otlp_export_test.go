@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestOTLPEndpointURL(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"http://collector:4318":          "http://collector:4318/v1experimental/profiles",
+		"https://collector:4318/":        "https://collector:4318/v1experimental/profiles",
+		"http://collector:4318/custom/p": "http://collector:4318/custom/p",
+	} {
+		if got, err := otlpEndpointURL(endpoint); err != nil || got != want {
+			t.Errorf("otlpEndpointURL(%q) = %q (%v), want %q", endpoint, got, err, want)
+		}
+	}
+	for _, endpoint := range []string{"collector:4318", "grpc://collector:4317", "http://"} {
+		if _, err := otlpEndpointURL(endpoint); err == nil {
+			t.Errorf("Expected an error for %q", endpoint)
+		}
+	}
+}
+
+func TestParseOTLPHeaders(t *testing.T) {
+	headers, err := parseOTLPHeaders("authorization=Bearer abc, x-scope-orgid = tenant1,")
+	if err != nil {
+		t.Fatalf("parseOTLPHeaders failed: %v", err)
+	}
+	if headers.Get("Authorization") != "Bearer abc" || headers.Get("X-Scope-Orgid") != "tenant1" {
+		t.Errorf("Unexpected headers: %v", headers)
+	}
+	if _, err := parseOTLPHeaders("authorization"); err == nil {
+		t.Errorf("Expected an error for an entry without '='")
+	}
+	if got := redactOTLPHeaders("authorization=Bearer abc, x-scope-orgid=tenant1"); got != "authorization=xxxxx,x-scope-orgid=xxxxx" {
+		t.Errorf("Unexpected redacted headers: %q", got)
+	}
+}
+
+func TestHandleExportOTLP(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(workspaceEnv, dir)
+	path := filepath.Join(dir, "cpu.pprof")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := poolTestProfile("main.hot", 10, 20).Write(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	export := func(args map[string]interface{}) (string, error) {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handleExportOTLP(context.Background(), request)
+		if err != nil {
+			return "", err
+		}
+		return result.Content[0].(mcp.TextContent).Text, nil
+	}
+
+	// Without an endpoint the OTLP JSON is returned
+	text, err := export(map[string]interface{}{"profile_uri": path, "service_name": "api"})
+	if err != nil {
+		t.Fatalf("handleExportOTLP failed: %v", err)
+	}
+	var data analyzer.OTLPProfilesData
+	if err := json.Unmarshal([]byte(text), &data); err != nil {
+		t.Fatalf("Expected OTLP JSON, got %q: %v", text, err)
+	}
+	if n := len(data.ResourceProfiles[0].ScopeProfiles[0].Profiles[0].Profile.Sample); n != 2 {
+		t.Errorf("Expected 2 samples, got %d", n)
+	}
+
+	var gotPath, gotAuth, gotType string
+	var gotBody []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth, gotType = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		gotBody, _ = io.ReadAll(r.Body)
+		if r.Header.Get("X-Reject") != "" {
+			http.Error(w, "unsupported schema", http.StatusBadRequest)
+		}
+	}))
+	defer collector.Close()
+
+	// Nothing leaves the machine before the user approved it
+	t.Setenv(confirmEnv, "on")
+	text, err = export(map[string]interface{}{"profile_uri": path, "otlp_endpoint": collector.URL})
+	if err != nil || !strings.Contains(text, "send_to_endpoint") || gotPath != "" {
+		t.Errorf("Expected a confirmation request before pushing, got %q (%v)", text, err)
+	}
+
+	text, err = export(map[string]interface{}{"profile_uri": path, "otlp_endpoint": collector.URL, "otlp_headers": "authorization=Bearer abc", "confirm": true})
+	if err != nil {
+		t.Fatalf("handleExportOTLP failed to push: %v", err)
+	}
+	if !strings.Contains(text, "Pushed the profile (2 samples") || !strings.Contains(text, collector.URL+otlpProfilesPath) {
+		t.Errorf("Unexpected result: %q", text)
+	}
+	if gotPath != otlpProfilesPath || gotAuth != "Bearer abc" || gotType != "application/json" {
+		t.Errorf("Unexpected request: path %q, authorization %q, content type %q", gotPath, gotAuth, gotType)
+	}
+	if err := json.Unmarshal(gotBody, &data); err != nil {
+		t.Errorf("Expected an OTLP JSON body: %v", err)
+	}
+
+	_, err = export(map[string]interface{}{"profile_uri": path, "otlp_endpoint": collector.URL, "otlp_headers": "x-reject=1", "confirm": true})
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "unsupported schema") {
+		t.Errorf("Expected the collector's rejection, got %v", err)
+	}
+}
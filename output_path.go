@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// withOverwrite declares the 'overwrite' argument on a tool writing to a caller-chosen path.
+func withOverwrite() mcp.ToolOption {
+	return mcp.WithBoolean("overwrite",
+		mcp.Description("Whether an existing file at the output path may be replaced. If false (default), the call fails with a structured 'file_exists' error and the file is left untouched."),
+		mcp.DefaultBool(false),
+	)
+}
+
+// fileExistsError reports an output path that already exists while overwriting was not allowed.
+type fileExistsError struct {
+	Code       string `json:"error"` // Always "file_exists"
+	Message    string `json:"message"`
+	Tool       string `json:"tool"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	ModTime    string `json:"modTime"`
+	Suggestion string `json:"suggestion"`
+}
+
+func (e *fileExistsError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// toolResult returns the error as a structured tool error, so clients can ask before retrying with overwrite.
+func (e *fileExistsError) toolResult() *mcp.CallToolResult {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return mcp.NewToolResultError(e.Message)
+	}
+	return mcp.NewToolResultError(string(data))
+}
+
+// checkOutputPath returns a *fileExistsError if path exists and may not be overwritten.
+func checkOutputPath(tool, path string, overwrite bool) *fileExistsError {
+	if overwrite {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil // Missing (or unreadable, which writing will report)
+	}
+	return &fileExistsError{
+		Code:       "file_exists",
+		Message:    fmt.Sprintf("'%s' already exists and was not overwritten", path),
+		Tool:       tool,
+		Path:       path,
+		Size:       info.Size(),
+		ModTime:    info.ModTime().UTC().Format("2006-01-02T15:04:05Z"),
+		Suggestion: fmt.Sprintf("Retry %s with 'overwrite': true to replace it, or choose another output path", tool),
+	}
+}
+
+// tempOutputPath returns a new, empty temporary file next to path (on the same filesystem, so that
+// commitOutputFile can rename it into place atomically).
+func tempOutputPath(path string) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for '%s': %w", path, err)
+	}
+	tempFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for '%s': %w", path, err)
+	}
+	tempFile.Close()
+	return tempFile.Name(), nil
+}
+
+// commitOutputFile moves a completely written temporary file to path, so a partially written artifact never
+// appears there. Without overwrite, the existence check is repeated to avoid replacing a file created meanwhile.
+func commitOutputFile(tool, tempPath, path string, overwrite bool) error {
+	if existsErr := checkOutputPath(tool, path, overwrite); existsErr != nil {
+		os.Remove(tempPath)
+		return existsErr
+	}
+	if err := os.Chmod(tempPath, 0o644); err != nil { // CreateTemp uses 0600
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to set permissions of '%s': %w", tempPath, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to move '%s' to '%s': %w", tempPath, path, err)
+	}
+	return nil
+}
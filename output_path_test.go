@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dirEntries returns the names of the files in dir, to check that no temporary file is left behind.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestWriteOutputFileCollision(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.pb.gz")
+	if err := os.WriteFile(path, []byte("original"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	existsErr := checkOutputPath("subtract_profile", path, false)
+	if existsErr == nil || existsErr.Code != "file_exists" || existsErr.Path != path || existsErr.Size != 8 ||
+		!strings.Contains(existsErr.Suggestion, "'overwrite': true") {
+		t.Fatalf("Expected a file_exists error for the existing file, got %+v", existsErr)
+	}
+	if result := existsErr.toolResult(); !result.IsError || !strings.Contains(resultText(result), `"error": "file_exists"`) {
+		t.Errorf("Expected a structured tool error, got %+v", result)
+	}
+	if checkOutputPath("subtract_profile", path, true) != nil || checkOutputPath("subtract_profile", filepath.Join(dir, "new.pb.gz"), false) != nil {
+		t.Error("Expected no error with overwrite or for a new file")
+	}
+
+	// A file created while the output was written is not replaced, and the temporary file is removed
+	_, err := writeProfileFile("subtract_profile", poolTestProfile("main.new", 100), path, "", false)
+	var fileExists *fileExistsError
+	if !errors.As(err, &fileExists) {
+		t.Fatalf("Expected a file_exists error, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "original" {
+		t.Errorf("Expected the existing file to be left untouched, got %q", data)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("Expected the temporary file to be removed, got %v", names)
+	}
+
+	written, err := writeProfileFile("subtract_profile", poolTestProfile("main.new", 100), path, "", true)
+	if err != nil || written != path {
+		t.Fatalf("Expected the file to be replaced with overwrite, got %q, %v", written, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == 8 || info.Mode().Perm() != 0o644 {
+		t.Errorf("Expected the new profile with mode 0644, got %v, %v", info, err)
+	}
+	if names := dirEntries(t, dir); len(names) != 1 {
+		t.Errorf("Expected no temporary file left, got %v", names)
+	}
+}
+
+func TestWriteOutputFileRelativePath(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	// Missing directories are created relative to the working directory
+	path := filepath.Join("out", "diff.pb.gz")
+	written, err := writeProfileFile("subtract_profile", poolTestProfile("main.new", 100), path, "", false)
+	if err != nil || written != path {
+		t.Fatalf("Expected the relative path to be written, got %q, %v", written, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "diff.pb.gz")); err != nil {
+		t.Errorf("Expected the file in the working directory: %v", err)
+	}
+	if names := dirEntries(t, filepath.Join(dir, "out")); len(names) != 1 {
+		t.Errorf("Expected no temporary file left, got %v", names)
+	}
+	if existsErr := checkOutputPath("subtract_profile", path, false); existsErr == nil {
+		t.Error("Expected the relative path to collide with the file just written")
+	}
+}
+
+func TestWriteOutputFileUnwritableDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// A regular file where a directory is expected can never be written to
+	parent := filepath.Join(dir, "file")
+	if err := os.WriteFile(parent, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tempOutputPath(filepath.Join(parent, "out.pb.gz")); err == nil || !strings.Contains(err.Error(), "failed to create directory") {
+		t.Errorf("Expected an error creating the directory, got %v", err)
+	}
+
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(readOnly, 0o755) })
+	if probe, err := os.CreateTemp(readOnly, "probe"); err == nil {
+		probe.Close()
+		os.Remove(probe.Name())
+		t.Skip("Permissions are not enforced for this user")
+	}
+	_, err := writeProfileFile("subtract_profile", poolTestProfile("main.new", 100), filepath.Join(readOnly, "out.pb.gz"), "", false)
+	if err == nil || !strings.Contains(err.Error(), "failed to create temporary file") || !errors.Is(err, os.ErrPermission) {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+	if names := dirEntries(t, readOnly); len(names) != 0 {
+		t.Errorf("Expected nothing written, got %v", names)
+	}
+}
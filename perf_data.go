@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// perfDataMagic starts every perf.data file written by Linux 'perf record'.
+var perfDataMagic = []byte("PERFILE2")
+
+// perfToProfileEnv points to the perf_to_profile converter (github.com/google/perf_data_converter) when it
+// is not in PATH.
+const perfToProfileEnv = "PPROF_ANALYZER_PERF_TO_PROFILE"
+
+// perfConversionTimeout bounds the conversion of one perf.data file; symbolizing large recordings is slow.
+const perfConversionTimeout = 5 * time.Minute
+
+// isPerfData reports whether data is a perf.data file rather than a pprof profile.
+func isPerfData(data []byte) bool {
+	return bytes.HasPrefix(data, perfDataMagic)
+}
+
+// convertPerfData converts the perf.data file at filePath to a pprof profile with perf_to_profile, which
+// also symbolizes it using the binaries and build IDs recorded by perf. The converted profile is returned
+// as serialized protobuf, so every tool parsing profiles in-process handles perf.data like a Go profile.
+func convertPerfData(ctx context.Context, filePath string) ([]byte, error) {
+	converter := os.Getenv(perfToProfileEnv)
+	if converter == "" {
+		var err error
+		converter, err = exec.LookPath("perf_to_profile")
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is a perf.data file; converting it requires perf_to_profile "+
+				"(https://github.com/google/perf_data_converter) in PATH or %s pointing to it, "+
+				"or pass the output of 'perf script -i %s' instead", filePath, perfToProfileEnv, filePath)
+		}
+	}
+
+	if confirmErr := confirmSpawnContext(ctx, []string{converter, "-i", filePath, "-o", "<temporary file>", "-f"}); confirmErr != nil {
+		return nil, confirmErr
+	}
+
+	out, err := os.CreateTemp("", "pprof-perf-*.pb.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for the converted profile: %w", err)
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	ctx, cancel := context.WithTimeout(ctx, perfConversionTimeout)
+	defer cancel()
+	log.Printf("Converting perf.data file '%s' with %s", filePath, converter)
+	// -f overwrites the (empty) output file created above
+	cmd := exec.CommandContext(ctx, converter, "-i", filePath, "-o", out.Name(), "-f")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to convert perf.data file '%s': %w. Output: %s", filePath, err, string(output))
+	}
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read converted profile of '%s': %w", filePath, err)
+	}
+	return data, nil
+}
+
+// convertPerfScript converts the text output of 'perf script' read from filePath to a pprof profile (see
+// analyzer.ParsePerfScript), returned as serialized protobuf like the output of convertPerfData. No external
+// command is needed: perf already symbolized the stacks.
+func convertPerfScript(filePath string, data []byte) ([]byte, error) {
+	log.Printf("Converting perf script output '%s'", filePath)
+	p, err := analyzer.ParsePerfScript(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert perf script output '%s': %w", filePath, err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize the profile converted from '%s': %w", filePath, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// convertFoldedStacks converts folded stacks read from filePath (e.g. written by stackcollapse-perf.pl or an
+// eBPF tool) to a pprof profile (see analyzer.ParseFoldedStacks), like convertPerfScript.
+func convertFoldedStacks(filePath string, data []byte) ([]byte, error) {
+	log.Printf("Converting folded stacks '%s'", filePath)
+	p, err := analyzer.ParseFoldedStacks(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert folded stacks '%s': %w", filePath, err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize the profile converted from '%s': %w", filePath, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// convertDebugText converts a text dump of runtime/pprof (debug=1 profiles, debug=2 goroutine dumps) into a
+// symbolized pprof profile.
+func convertDebugText(filePath string, data []byte) ([]byte, error) {
+	log.Printf("Converting debug text dump '%s'", filePath)
+	p, err := analyzer.ParseDebugText(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert debug text dump '%s': %w", filePath, err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		return nil, fmt.Errorf("failed to serialize the profile converted from '%s': %w", filePath, err)
+	}
+	return buf.Bytes(), nil
+}
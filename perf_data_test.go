@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProfilePerfScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.perf")
+	script := "myapp 42/42 [001] 100.000000:  1000000 cpu-clock:\n\t4005d6 compress+0x10 (/usr/bin/myapp)\n\t4005a0 main (/usr/bin/myapp)\n\n" +
+		"myapp 42/42 [001] 100.001000:  1000000 cpu-clock:\n\t4005a0 main (/usr/bin/myapp)\n"
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	prof, err := loadProfile(context.Background(), path, "")
+	if err != nil {
+		t.Fatalf("loadProfile failed on perf script output: %v", err)
+	}
+	if len(prof.Sample) != 2 || prof.DefaultSampleType != "cpu" || prof.Sample[0].Location[0].Line[0].Function.Name != "compress" {
+		t.Errorf("Unexpected converted profile: %d samples, default sample type %q", len(prof.Sample), prof.DefaultSampleType)
+	}
+	if !isPerfData([]byte("PERFILE2...")) || isPerfData([]byte(script)) {
+		t.Errorf("Expected only perf.data files to need perf_to_profile")
+	}
+}
+
+func TestLoadProfileFoldedStacks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.folded")
+	if err := os.WriteFile(path, []byte("java;App.main;App.work 40\njava;App.main 10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prof, err := loadProfile(context.Background(), path, "")
+	if err != nil {
+		t.Fatalf("loadProfile failed on folded stacks: %v", err)
+	}
+	if len(prof.Sample) != 2 || prof.Sample[0].Location[0].Line[0].Function.Name != "App.work" || prof.Sample[0].Value[0] != 40 {
+		t.Errorf("Unexpected converted profile: %d samples", len(prof.Sample))
+	}
+}
+
+func TestLoadProfileDebugText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goroutine.txt")
+	dump := "goroutine 1 [running]:\nmain.main()\n\t/app/main.go:33 +0x2b1\n\n" +
+		"goroutine 7 [sleep]:\ntime.Sleep(0x34630b8a000)\n\t/usr/local/go/src/runtime/time.go:368 +0x165\nmain.main.func1()\n\t/app/main.go:25 +0x1d\n"
+	if err := os.WriteFile(path, []byte(dump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prof, err := loadProfile(context.Background(), path, "")
+	if err != nil {
+		t.Fatalf("loadProfile failed on a goroutine dump: %v", err)
+	}
+	if len(prof.Sample) != 2 || prof.Sample[1].Location[0].Line[0].Function.Name != "time.Sleep" || prof.Sample[1].Label["state"][0] != "sleep" {
+		t.Errorf("Unexpected converted profile: %d samples", len(prof.Sample))
+	}
+}
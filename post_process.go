@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// postProcessEnv holds the command template run after an artifact is generated, e.g.
+// "aws s3 cp {path} s3://bucket/profiles/{name}". It is split into arguments like a shell would (quotes are
+// honored) but never run through a shell; placeholders are substituted inside single arguments, so artifact
+// paths cannot inject extra arguments or commands.
+const postProcessEnv = "PPROF_ANALYZER_POST_PROCESS"
+
+// postProcessTimeoutEnv overrides how long the hook may run (a Go duration such as "2m").
+const postProcessTimeoutEnv = "PPROF_ANALYZER_POST_PROCESS_TIMEOUT"
+
+// postProcessKindsEnv restricts the hook to a comma-separated list of artifact kinds
+// ("flamegraph", "analysis", "bundle", "profile"); by default it runs for all of them.
+const postProcessKindsEnv = "PPROF_ANALYZER_POST_PROCESS_KINDS"
+
+// defaultPostProcessTimeout is the hook timeout when none is configured.
+const defaultPostProcessTimeout = 30 * time.Second
+
+// maxPostProcessOutput is the number of bytes of hook output kept for the tool result.
+const maxPostProcessOutput = 4096
+
+// postProcessReport is the outcome of one hook run, reported in the tool result.
+type postProcessReport struct {
+	Artifact string
+	Command  []string
+	ExitCode int
+	Duration time.Duration
+	Output   string
+	Err      error
+	// Set when the hook was not run because the call is not confirmed (see confirmSpawnContext)
+	Confirmation *confirmationRequired
+}
+
+// String formats the report for the tool result.
+func (r *postProcessReport) String() string {
+	var b strings.Builder
+	if r.Confirmation != nil {
+		data, _ := json.MarshalIndent(r.Confirmation, "", "  ")
+		return fmt.Sprintf("Post-processing hook for %s: not run, confirmation required\n%s\n", r.Artifact, data)
+	}
+	status := fmt.Sprintf("exit code %d", r.ExitCode)
+	if r.Err != nil {
+		status = fmt.Sprintf("failed: %v", r.Err)
+	}
+	b.WriteString(fmt.Sprintf("Post-processing hook for %s: %s (%s)\n", r.Artifact, status, r.Duration.Round(time.Millisecond)))
+	if len(r.Command) > 0 {
+		b.WriteString(fmt.Sprintf("Command: %s\n", strings.Join(r.Command, " ")))
+	}
+	if output := strings.TrimSpace(r.Output); output != "" {
+		b.WriteString("Output:\n" + output + "\n")
+	}
+	return b.String()
+}
+
+// runPostProcessHook runs the configured hook ($PPROF_ANALYZER_POST_PROCESS) for a generated artifact.
+// It returns nil when no hook is configured or the artifact kind is not selected. Hook failures are
+// reported, not returned: the artifact itself was generated successfully.
+func runPostProcessHook(ctx context.Context, analysisID string, artifact AnalysisArtifact) *postProcessReport {
+	template := strings.TrimSpace(os.Getenv(postProcessEnv))
+	if template == "" || !postProcessKindSelected(artifact.Kind) {
+		return nil
+	}
+	report := &postProcessReport{Artifact: artifact.Path, ExitCode: -1}
+
+	// 加密保存的 artifact 以同名的解密副本交给钩子，钩子结束后删除
+	plainPath, cleanup, err := openProfileFile(artifact.Path, func() {})
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	defer cleanup()
+
+	args, err := expandPostProcessTemplate(template, analysisID, artifact, plainPath)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	report.Command = args
+	// The unexpanded template is confirmed, as the expanded command names per-call temporary files
+	unexpanded, _ := splitCommandLine(template)
+	if confirmErr := confirmSpawnContext(ctx, unexpanded); confirmErr != nil {
+		report.Confirmation = confirmErr
+		return report
+	}
+
+	timeout := defaultPostProcessTimeout
+	if value := os.Getenv(postProcessTimeoutEnv); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("Warning: ignoring invalid %s=%q, using %s", postProcessTimeoutEnv, value, timeout)
+		} else {
+			timeout = parsed
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout) // Not the request's: a finished hook run is not canceled
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = os.TempDir()
+	cmd.Env = postProcessEnviron(analysisID, artifact, plainPath)
+	cmd.WaitDelay = time.Second // Don't wait for children still holding the output pipe after a kill
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	log.Printf("Running post-processing hook for %s: %s", artifact.Path, strings.Join(args, " "))
+	start := time.Now()
+	err = cmd.Run()
+	report.Duration = time.Since(start)
+	report.Output = output.String()
+	if len(report.Output) > maxPostProcessOutput {
+		report.Output = report.Output[:maxPostProcessOutput] + "\n... (output truncated)"
+	}
+	if cmd.ProcessState != nil {
+		report.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		report.Err = fmt.Errorf("timed out after %s", timeout)
+	case err != nil && !errors.As(err, &exitErr):
+		report.Err = err
+	}
+	if report.Err != nil || report.ExitCode != 0 {
+		log.Printf("Warning: post-processing hook for %s did not succeed: exit code %d, %v", artifact.Path, report.ExitCode, report.Err)
+	}
+	return report
+}
+
+// postProcessKindSelected reports whether the hook runs for artifacts of the given kind.
+func postProcessKindSelected(kind string) bool {
+	kinds := strings.TrimSpace(os.Getenv(postProcessKindsEnv))
+	if kinds == "" {
+		return true
+	}
+	for _, k := range strings.Split(kinds, ",") {
+		if strings.TrimSpace(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPostProcessTemplate splits a hook template into arguments and substitutes the placeholders {path}
+// (absolute path of plainPath, the artifact or its decrypted copy), {dir} (the artifact's directory, so
+// converted files written there outlive the copy), {name} (base name), {kind} and {analysis_id}. The
+// program itself must be given literally.
+func expandPostProcessTemplate(template, analysisID string, artifact AnalysisArtifact, plainPath string) ([]string, error) {
+	args, err := splitCommandLine(template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", postProcessEnv, err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid %s: empty command", postProcessEnv)
+	}
+	if strings.Contains(args[0], "{") {
+		return nil, fmt.Errorf("invalid %s: the program '%s' must not contain placeholders", postProcessEnv, args[0])
+	}
+	path, err := filepath.Abs(plainPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve artifact path '%s': %w", plainPath, err)
+	}
+	dir, err := filepath.Abs(filepath.Dir(artifact.Path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve artifact path '%s': %w", artifact.Path, err)
+	}
+	replacer := strings.NewReplacer(
+		"{path}", path,
+		"{dir}", dir,
+		"{name}", filepath.Base(path),
+		"{kind}", artifact.Kind,
+		"{analysis_id}", analysisID,
+	)
+	for i := 1; i < len(args); i++ {
+		args[i] = replacer.Replace(args[i])
+	}
+	return args, nil
+}
+
+// splitCommandLine splits a command line into arguments at unquoted whitespace. Single quotes preserve
+// everything literally; elsewhere a backslash escapes the next character.
+func splitCommandLine(line string) ([]string, error) {
+	args := make([]string, 0)
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in '%s'", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// postProcessEnviron is the environment of the hook: only what is needed to find and run tools, plus the
+// artifact details, so the server's own environment (credentials, tokens) is not handed to it wholesale.
+func postProcessEnviron(analysisID string, artifact AnalysisArtifact, plainPath string) []string {
+	path, _ := filepath.Abs(plainPath)
+	return append(commandEnviron(),
+		"PPROF_ARTIFACT_PATH="+path,
+		"PPROF_ARTIFACT_KIND="+artifact.Kind,
+		"PPROF_ARTIFACT_SOURCE="+artifact.Source,
+		"PPROF_ANALYSIS_ID="+analysisID,
+	)
+}
+
+// commandEnviron is the environment of commands run on behalf of tool calls: what is needed to find and run
+// tools, plus the named variables that are set.
+func commandEnviron(names ...string) []string {
+	env := make([]string, 0)
+	for _, name := range append([]string{"PATH", "HOME", "USER", "TMPDIR", "LANG", "SYSTEMROOT"}, names...) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// withPostProcessReports appends the reports of hooks run for a tool's artifacts to its result.
+func withPostProcessReports(result *mcp.CallToolResult, reports ...*postProcessReport) *mcp.CallToolResult {
+	for _, report := range reports {
+		if report == nil {
+			continue
+		}
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: report.String()})
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandPostProcessTemplate(t *testing.T) {
+	artifact := AnalysisArtifact{Path: "/data/my flame; rm -rf ~.svg", Kind: "flamegraph"}
+	cases := []struct {
+		template string
+		want     []string
+		err      string
+	}{
+		{
+			template: `aws s3 cp {path} s3://bucket/{analysis_id}/{name}`,
+			want:     []string{"aws", "s3", "cp", "/data/my flame; rm -rf ~.svg", "s3://bucket/a1/my flame; rm -rf ~.svg"},
+		},
+		{
+			template: `convert "{dir}/{name}" '{dir}/out $1.png' --kind={kind}`,
+			want:     []string{"convert", "/data/my flame; rm -rf ~.svg", "/data/out $1.png", "--kind=flamegraph"},
+		},
+		{template: `{dir}/upload.sh {path}`, err: "must not contain placeholders"},
+		{template: `upload "{path}`, err: "unterminated quote"},
+		{template: "   ", err: "empty command"},
+	}
+	for _, tc := range cases {
+		args, err := expandPostProcessTemplate(tc.template, "a1", artifact, artifact.Path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tc.template, tc.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(args, tc.want) {
+			t.Errorf("%s: expected %q, got %q (%v)", tc.template, tc.want, args, err)
+		}
+	}
+
+	// {path} names the decrypted copy, {dir} the directory of the artifact itself
+	args, err := expandPostProcessTemplate("cp {path} {dir}/{name}.txt", "", artifact, "/tmp/pprof-decrypted-1/cpu.pb.gz")
+	if err != nil || !reflect.DeepEqual(args, []string{"cp", "/tmp/pprof-decrypted-1/cpu.pb.gz", "/data/cpu.pb.gz.txt"}) {
+		t.Errorf("Unexpected arguments for a decrypted copy: %q (%v)", args, err)
+	}
+}
+
+// writeHookArtifact writes an analysis result the hook can transform.
+func writeHookArtifact(t *testing.T, dir string, data []byte) AnalysisArtifact {
+	t.Helper()
+	path := filepath.Join(dir, "pprof-a1-analysis-analyze_pprof.json")
+	if err := writeSealedFile(path, data); err != nil {
+		t.Fatal(err)
+	}
+	return AnalysisArtifact{Path: path, Kind: "analysis", Source: "analyze_pprof"}
+}
+
+func TestRunPostProcessHook(t *testing.T) {
+	t.Setenv(confirmEnv, "off")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	// The hook converts the artifact next to it and reports its environment
+	t.Setenv(postProcessEnv, `sh -c 'tr a-z A-Z < "$1" > "$2/$3.upper" && echo "converted $4 of $5" && env' hook {path} {dir} {name} {kind} {analysis_id}`)
+	t.Setenv(postProcessKindsEnv, "")
+	t.Setenv(postProcessTimeoutEnv, "")
+
+	for _, encrypted := range []bool{false, true} {
+		saved := fileEncryption
+		if encrypted {
+			enc, err := newEncryptedStore(nil, bytes.Repeat([]byte{3}, 32))
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileEncryption = enc
+		}
+		dir := t.TempDir()
+		artifact := writeHookArtifact(t, dir, []byte(`{"top": "main.hot"}`))
+		report := runPostProcessHook(context.Background(), "a1", artifact)
+		fileEncryption = saved
+
+		if report == nil || report.Err != nil || report.ExitCode != 0 || !strings.Contains(report.Output, "converted analysis of a1") {
+			t.Fatalf("encrypted=%v: expected a successful hook run, got %+v", encrypted, report)
+		}
+		converted, err := os.ReadFile(artifact.Path + ".upper")
+		if err != nil || string(converted) != `{"TOP": "MAIN.HOT"}` {
+			t.Errorf("encrypted=%v: expected the converted plaintext next to the artifact, got %q (%v)", encrypted, converted, err)
+		}
+		if !strings.Contains(report.Output, "PPROF_ARTIFACT_KIND=analysis") || strings.Contains(report.Output, "AWS_SECRET_ACCESS_KEY") {
+			t.Errorf("encrypted=%v: expected only the minimal environment, got:\n%s", encrypted, report.Output)
+		}
+		if !strings.Contains(report.String(), "Post-processing hook for "+artifact.Path+": exit code 0") {
+			t.Errorf("Unexpected report:\n%s", report.String())
+		}
+		// The decrypted copy is gone once the hook returned
+		if copies, _ := filepath.Glob(filepath.Join(os.TempDir(), "pprof-decrypted-*", filepath.Base(artifact.Path))); len(copies) != 0 {
+			t.Errorf("encrypted=%v: expected the decrypted copy to be removed, got %v", encrypted, copies)
+		}
+	}
+}
+
+func TestRunPostProcessHookFailures(t *testing.T) {
+	artifact := writeHookArtifact(t, t.TempDir(), []byte("{}"))
+	t.Setenv(confirmEnv, "off")
+	t.Setenv(postProcessKindsEnv, "")
+	t.Setenv(postProcessTimeoutEnv, "")
+
+	t.Setenv(postProcessEnv, `sh -c 'echo "upload refused" >&2; exit 3'`)
+	if report := runPostProcessHook(context.Background(), "a1", artifact); report == nil || report.ExitCode != 3 || report.Err != nil ||
+		!strings.Contains(report.String(), "exit code 3") || !strings.Contains(report.String(), "upload refused") {
+		t.Errorf("Expected the exit code and output of a failing hook, got %+v", report)
+	}
+
+	t.Setenv(postProcessEnv, "sleep 10")
+	t.Setenv(postProcessTimeoutEnv, "100ms")
+	if report := runPostProcessHook(context.Background(), "a1", artifact); report == nil || report.Err == nil || !strings.Contains(report.Err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected the hook to time out, got %+v", report)
+	}
+
+	// Other kinds of artifacts are not post-processed
+	t.Setenv(postProcessKindsEnv, "flamegraph, bundle")
+	if report := runPostProcessHook(context.Background(), "a1", artifact); report != nil {
+		t.Errorf("Expected no hook run for an analysis, got %+v", report)
+	}
+
+	// With confirmations on, the hook waits for the user's approval
+	t.Setenv(postProcessKindsEnv, "")
+	t.Setenv(confirmEnv, "on")
+	ctx := withConfirmationScope(context.Background(), "analyze_pprof", map[string]interface{}{})
+	if report := runPostProcessHook(ctx, "a1", artifact); report == nil || report.Confirmation == nil || report.Duration != 0 ||
+		!strings.Contains(report.String(), "not run, confirmation required") {
+		t.Errorf("Expected a confirmation request, got %+v", report)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// pprofWebAddress is the address of the web UI in the reproduced commands that open it.
+const pprofWebAddress = "localhost:8080"
+
+// inlineProfilePlaceholder stands for a profile passed as profile_data_base64, which has no path the user knows.
+const inlineProfilePlaceholder = "profile.pb.gz"
+
+// withPprofCommands declares the 'include_pprof_commands' argument of a tool whose result 'go tool pprof' can
+// reproduce.
+func withPprofCommands() mcp.ToolOption {
+	return mcp.WithBoolean("include_pprof_commands",
+		mcp.Description("Append the 'go tool pprof' command lines reproducing this result locally, with flags mirroring the applied filters (focus_regex, ignore_regex, tag_filter, exclude_test_frames and an imported pprof config), to continue the investigation in a terminal. Differences the commands cannot reproduce are noted."),
+		mcp.DefaultBool(false),
+	)
+}
+
+// pprofCommand is a 'go tool pprof' command line reproducing a tool's result.
+type pprofCommand struct {
+	args []string // Arguments after 'go tool pprof'
+	note string   // What the command shows, when it is not the plain report; may be empty
+}
+
+// pprofCommandBuilder builds the commands of one tool call.
+type pprofCommandBuilder struct {
+	args       map[string]interface{}
+	profileArg func(uriStr string) string // See pprofCommandsHandler
+	notes      []string
+}
+
+// str returns a string argument, "" when it is missing.
+func (b *pprofCommandBuilder) str(name string) string {
+	value, _ := b.args[name].(string)
+	return value
+}
+
+// profile returns the command argument for the profile URI argument name.
+func (b *pprofCommandBuilder) profile(name string) string {
+	return b.profileArg(b.str(name))
+}
+
+// sampleIndexFlags returns -sample_index for the 'sample_type' argument.
+func (b *pprofCommandBuilder) sampleIndexFlags() []string {
+	if sampleType := b.str("sample_type"); sampleType != "" {
+		return []string{"-sample_index=" + sampleType}
+	}
+	return nil
+}
+
+// filterFlags returns the flags of the pprof config imported for the profile URI argument uriArg followed by
+// those of the sample filter arguments. The analysis applies both, but pprof keeps only the last of a
+// repeated flag, so a config flag the arguments set again is dropped and noted.
+func (b *pprofCommandBuilder) filterFlags(uriArg string) []string {
+	configFlags := pprofConfigFlags(b.str(uriArg))
+	filters, err := sampleFiltersFromArgs(b.args)
+	if err != nil {
+		return configFlags // The call itself failed on them
+	}
+	argFlags := pprofFilterFlags(filters)
+	tagFlags, ok := pprofTagFlags(filters)
+	if !ok {
+		b.notes = append(b.notes, fmt.Sprintf("tag_filter '%s' has more than one 'key=regex' or 'key!=regex' condition, but pprof takes one -tagfocus and one -tagignore: the commands leave it out", filters.Tags))
+	}
+	argFlags = append(argFlags, tagFlags...)
+
+	flags := make([]string, 0, len(configFlags)+len(argFlags))
+	for _, flag := range configFlags {
+		name, _, _ := strings.Cut(flag, "=")
+		overridden := false
+		for _, argFlag := range argFlags {
+			if argName, _, _ := strings.Cut(argFlag, "="); argName == name {
+				overridden = true
+			}
+		}
+		if overridden {
+			b.notes = append(b.notes, fmt.Sprintf("the imported pprof config sets %s as well, which the analysis applied too; pprof keeps only one, so the commands use the tool's", flag))
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	return append(flags, argFlags...)
+}
+
+// renamedFunctionsNote notes that pprof compares functions by exact name, for tools matching renamed ones.
+func (b *pprofCommandBuilder) renamedFunctionsNote() {
+	if match, ok := b.args["match_renamed_functions"].(bool); !ok || match {
+		b.notes = append(b.notes, "pprof matches functions by exact name: functions renamed between the profiles (see match_renamed_functions) show up as removed and added")
+	}
+}
+
+// pprofCommandsFor returns the commands reproducing the result of a call of tool with args, and notes on what
+// they do not reproduce exactly. It returns no commands for tools without a 'go tool pprof' equivalent.
+func pprofCommandsFor(tool string, args map[string]interface{}, profileArg func(uriStr string) string) ([]pprofCommand, []string) {
+	b := &pprofCommandBuilder{args: args, profileArg: profileArg}
+	var commands []pprofCommand
+	topN, _ := args["top_n"].(float64)
+
+	switch tool {
+	case "analyze_pprof":
+		uri := b.str("profile_uri")
+		if live, _, _, err := liveCaptureURI(uri, args); err == nil {
+			uri = live // pprof fetches the capture itself, with the same duration and rate
+		}
+		view := []string{"-top"}
+		note := ""
+		switch format := b.str("output_format"); format {
+		case "callgraph", "callgraph-dot", "graphml":
+			view = []string{"-dot"}
+		case "flamegraph-json":
+			view = []string{"-http=" + pprofWebAddress}
+			note = fmt.Sprintf("flame graph at http://%s/ui/flamegraph", pprofWebAddress)
+		case "folded":
+			view = []string{"-raw"}
+			note = "raw stacks, which stackcollapse-go.pl folds"
+		case "edges":
+			if focus := b.str("focus_regex"); focus != "" {
+				view = []string{"-peek=" + focus}
+				note = "callers and callees of the matching functions, with the weight of each edge"
+			} else {
+				view = []string{"-dot"}
+			}
+		default:
+			if sortBy := b.str("sort_by"); sortBy == "cum" {
+				view = append(view, "-cum")
+			}
+		}
+		if topN > 0 && (view[0] == "-top" || view[0] == "-dot") {
+			view = append(view, fmt.Sprintf("-nodecount=%d", int(topN)))
+		}
+		switch b.str("aggregation_level") {
+		case "file":
+			view = append(view, "-files")
+		case "package":
+			b.notes = append(b.notes, "pprof cannot aggregate by package: the commands show functions")
+		}
+		if b.str("group_by") == "package" {
+			b.notes = append(b.notes, "pprof cannot group by package: the commands show functions")
+		}
+		if maxSamples, _ := args["max_samples"].(float64); maxSamples > 0 {
+			b.notes = append(b.notes, "pprof reads every sample: values can differ slightly from the analysis if it was downsampled (max_samples)")
+		}
+		flags := append(append(view, b.sampleIndexFlags()...), b.filterFlags("profile_uri")...)
+		commands = append(commands, pprofCommand{args: append(flags, profileArg(uri)), note: note})
+		if key := b.str("group_by_label"); key != "" {
+			flags := append([]string{"-tags", "-tagshow=^" + regexp.QuoteMeta(key) + "$"}, b.filterFlags("profile_uri")...)
+			commands = append(commands, pprofCommand{args: append(flags, profileArg(uri)), note: fmt.Sprintf("share of each value of the '%s' label", key)})
+		}
+
+	case "generate_flamegraph":
+		flags := make([]string, 0)
+		switch b.str("profile_type") {
+		case "heap":
+			flags = append(flags, "-inuse_space")
+		case "allocs":
+			flags = append(flags, "-alloc_space")
+		}
+		flags = append(flags, b.filterFlags("profile_uri")...)
+		flags = append(flags, "-svg", "-output="+b.str("output_svg_path"))
+		commands = append(commands, pprofCommand{args: append(flags, b.profile("profile_uri"))})
+
+	case "detect_memory_leaks":
+		commands = append(commands, pprofCommand{
+			args: []string{"-top", "-sample_index=inuse_space", "-base=" + b.profile("old_profile_uri"), b.profile("new_profile_uri")},
+			note: "growth by function; the analysis also groups it by object type",
+		})
+		b.renamedFunctionsNote()
+
+	case "subtract_profile":
+		commands = append(commands, pprofCommand{args: []string{"-top", "-base=" + b.profile("base_profile_uri"), b.profile("profile_uri")}})
+		b.renamedFunctionsNote()
+
+	case "diff_profiles":
+		flags := []string{"-top"}
+		if b.str("sort_by") == "cum" {
+			flags = append(flags, "-cum")
+		}
+		if topN > 0 {
+			flags = append(flags, fmt.Sprintf("-nodecount=%d", int(topN)))
+		}
+		flags = append(flags, b.sampleIndexFlags()...)
+		flags = append(flags, "-diff_base="+b.profile("old_profile_uri"), b.profile("new_profile_uri"))
+		commands = append(commands, pprofCommand{args: flags})
+		b.renamedFunctionsNote()
+
+	case "diff_flamegraph_structure", "diff_flamegraph":
+		flags := append([]string{"-http=" + pprofWebAddress}, b.sampleIndexFlags()...)
+		if normalize, _ := args["normalize"].(bool); normalize {
+			flags = append(flags, "-normalize")
+		}
+		flags = append(flags, "-diff_base="+b.profile("old_profile_uri"), b.profile("new_profile_uri"))
+		commands = append(commands, pprofCommand{args: flags, note: fmt.Sprintf("differential flame graph at http://%s/ui/flamegraph", pprofWebAddress)})
+		b.renamedFunctionsNote()
+
+	case "annotate_source":
+		flags := append([]string{"-list=" + b.str("function_regex")}, b.sampleIndexFlags()...)
+		if sourceRoot := b.str("source_root"); sourceRoot != "" {
+			flags = append(flags, "-source_path="+sourceRoot)
+		}
+		commands = append(commands, pprofCommand{args: append(flags, b.profile("profile_uri"))})
+
+	case "disassemble_function":
+		flags := append([]string{"-disasm=" + b.str("function_regex")}, b.sampleIndexFlags()...)
+		commands = append(commands, pprofCommand{args: append(flags, b.str("binary_path"), b.profile("profile_uri"))})
+	}
+	return commands, b.notes
+}
+
+// shellQuote quotes s for a POSIX shell when it contains characters the shell would interpret.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:,@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// formatPprofCommands renders the commands as shell command lines, followed by the notes.
+func formatPprofCommands(commands []pprofCommand, notes []string) string {
+	var b strings.Builder
+	b.WriteString("Reproduce with go tool pprof:")
+	for _, c := range commands {
+		quoted := make([]string, 0, len(c.args))
+		for _, arg := range c.args {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		fmt.Fprintf(&b, "\n  go tool pprof %s", strings.Join(quoted, " "))
+		if c.note != "" {
+			fmt.Fprintf(&b, "\n    # %s", c.note)
+		}
+	}
+	for _, note := range notes {
+		fmt.Fprintf(&b, "\n- Note: %s", note)
+	}
+	return b.String()
+}
+
+// pprofCommandsHandler appends the commands of pprofCommandsFor to the successful results of calls with
+// 'include_pprof_commands'. It sees the arguments before an inline profile or the output of a profile command
+// is written to a temporary file, which is replaced by inlineProfilePlaceholder, and runs inside
+// sourceReportingHandler, so candidate lists are replaced by the candidate used. file:// URIs become paths;
+// URLs are kept, as pprof fetches them itself.
+func pprofCommandsHandler(toolName string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		args := request.Params.Arguments
+		include, _ := args["include_pprof_commands"].(bool)
+		inlineData, _ := args[inlineProfileArg].(string)
+		command, _ := args[profileCommandArg].(string)
+		result, err := handler(ctx, request)
+		if !include || err != nil || result == nil || result.IsError {
+			return result, err
+		}
+		inlinePath, _ := args["profile_uri"].(string) // Set by materializeInlineProfile or materializeProfileCommand
+		profileArg := func(uriStr string) string {
+			if (inlineData != "" || command != "") && uriStr == inlinePath {
+				return inlineProfilePlaceholder
+			}
+			return pprofProfileArg(ctx, uriStr)
+		}
+		commands, notes := pprofCommandsFor(toolName, args, profileArg)
+		if len(commands) == 0 {
+			return result, nil
+		}
+		if inlineData != "" {
+			notes = append([]string{fmt.Sprintf("the profile was passed inline: save the decoded %s as %s first", inlineProfileArg, inlineProfilePlaceholder)}, notes...)
+		} else if command != "" {
+			notes = append([]string{fmt.Sprintf("the profile came from %s: save its output first, e.g. %s > %s", profileCommandArg, command, inlineProfilePlaceholder)}, notes...)
+		}
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: formatPprofCommands(commands, notes)})
+		return result, nil
+	}
+}
+
+// pprofProfileArg returns the argument 'go tool pprof' reads the profile of uriStr from: the candidate a
+// candidate list resolved to during the call in ctx, the path of a local profile, or the URL itself.
+func pprofProfileArg(ctx context.Context, uriStr string) string {
+	if sourceLog, ok := ctx.Value(profileSourceLogKey{}).(*profileSourceLog); ok {
+		sourceLog.mutex.Lock()
+		for _, s := range sourceLog.sources {
+			if s.URI == uriStr {
+				uriStr = s.Source
+				break
+			}
+		}
+		sourceLog.mutex.Unlock()
+	}
+	if path := localProfilePath(uriStr); path != "" {
+		return path
+	}
+	return uriStr
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestShellQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"-top":                  "-top",
+		"/tmp/cpu.pprof":        "/tmp/cpu.pprof",
+		`-focus=main\.handle`:   `'-focus=main\.handle'`,
+		"-tagfocus=a=^(?:b c)$": "'-tagfocus=a=^(?:b c)$'",
+		"it's":                  `'it'\''s'`,
+		"":                      "''",
+	} {
+		if got := shellQuote(s); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestPprofCommandsFor(t *testing.T) {
+	local := func(uriStr string) string { return pprofProfileArg(context.Background(), uriStr) }
+	commandLines := func(commands []pprofCommand) []string {
+		lines := make([]string, 0, len(commands))
+		for _, c := range commands {
+			lines = append(lines, strings.Join(c.args, " "))
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name  string
+		tool  string
+		args  map[string]interface{}
+		want  []string
+		notes []string
+	}{
+		{
+			name: "AnalyzeFilters",
+			tool: "analyze_pprof",
+			args: map[string]interface{}{"profile_uri": "file:///tmp/cpu.pprof", "top_n": 5.0, "sort_by": "cum", "sample_type": "samples",
+				"focus_regex": `main\.handle`, "tag_filter": "tenant=acme", "exclude_test_frames": true},
+			want: []string{`-top -cum -nodecount=5 -sample_index=samples -focus=main\.handle -hide=` + analyzer.TestFramesPattern + ` -tagfocus=tenant=^(?:acme)$ /tmp/cpu.pprof`},
+		},
+		{
+			name:  "AnalyzeLiveFlamegraph",
+			tool:  "analyze_pprof",
+			args:  map[string]interface{}{"profile_uri": "http://host:6060/debug/pprof/profile", "seconds": 5.0, "output_format": "flamegraph-json", "max_samples": 100.0},
+			want:  []string{"-http=localhost:8080 http://host:6060/debug/pprof/profile?seconds=5"},
+			notes: []string{"downsampled"},
+		},
+		{
+			name:  "AnalyzeGroupByLabel",
+			tool:  "analyze_pprof",
+			args:  map[string]interface{}{"profile_uri": "cpu.pprof", "group_by_label": "tenant", "tag_filter": "a=1,b=2"},
+			want:  []string{"-top cpu.pprof", "-tags -tagshow=^tenant$ cpu.pprof"},
+			notes: []string{"more than one"},
+		},
+		{
+			name:  "DiffProfiles",
+			tool:  "diff_profiles",
+			args:  map[string]interface{}{"old_profile_uri": "old.pprof", "new_profile_uri": "new.pprof", "top_n": 3.0, "sample_type": "alloc_space"},
+			want:  []string{"-top -nodecount=3 -sample_index=alloc_space -diff_base=old.pprof new.pprof"},
+			notes: []string{"exact name"},
+		},
+		{
+			name: "DiffFlamegraphNormalized",
+			tool: "diff_flamegraph",
+			args: map[string]interface{}{"old_profile_uri": "old.pprof", "new_profile_uri": "new.pprof", "normalize": true, "match_renamed_functions": false},
+			want: []string{"-http=localhost:8080 -normalize -diff_base=old.pprof new.pprof"},
+		},
+		{
+			name: "Flamegraph",
+			tool: "generate_flamegraph",
+			args: map[string]interface{}{"profile_uri": "heap.pprof", "profile_type": "heap", "output_svg_path": "out.svg", "ignore_regex": "runtime"},
+			want: []string{"-inuse_space -ignore=runtime -svg -output=out.svg heap.pprof"},
+		},
+		{
+			name: "AnnotateSource",
+			tool: "annotate_source",
+			args: map[string]interface{}{"profile_uri": "cpu.pprof", "function_regex": "main.work", "source_root": "/src"},
+			want: []string{"-list=main.work -source_path=/src cpu.pprof"},
+		},
+		{
+			name: "Disassemble",
+			tool: "disassemble_function",
+			args: map[string]interface{}{"profile_uri": "cpu.pprof", "binary_path": "./app", "function_regex": "main.work"},
+			want: []string{"-disasm=main.work ./app cpu.pprof"},
+		},
+		{
+			name: "NoEquivalent",
+			tool: "query_profile",
+			args: map[string]interface{}{"profile_uri": "cpu.pprof"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			commands, notes := pprofCommandsFor(tc.tool, tc.args, local)
+			if got := commandLines(commands); strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+				t.Errorf("Expected commands %q, got %q", tc.want, got)
+			}
+			for _, want := range tc.notes {
+				if !strings.Contains(strings.Join(notes, "\n"), want) {
+					t.Errorf("Expected a note containing %q, got %q", want, notes)
+				}
+			}
+			if len(tc.notes) == 0 && len(notes) != 0 {
+				t.Errorf("Expected no notes, got %q", notes)
+			}
+		})
+	}
+}
+
+func TestPprofCommandsImportedConfig(t *testing.T) {
+	pprofConfigsMutex.Lock()
+	pprofConfigs["cpu.pprof"] = &analyzer.PprofConfig{Focus: "main", Hide: "runtime"}
+	pprofConfigsMutex.Unlock()
+	defer func() {
+		pprofConfigsMutex.Lock()
+		delete(pprofConfigs, "cpu.pprof")
+		pprofConfigsMutex.Unlock()
+	}()
+
+	args := map[string]interface{}{"profile_uri": "cpu.pprof", "focus_regex": "main.work"}
+	commands, notes := pprofCommandsFor("analyze_pprof", args, func(uriStr string) string { return uriStr })
+	if got := strings.Join(commands[0].args, " "); got != "-top -hide=runtime -focus=main.work cpu.pprof" {
+		t.Errorf("Expected the config's flags with the tool's focus, got %q", got)
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "-focus=main") {
+		t.Errorf("Expected a note on the replaced config focus, got %q", notes)
+	}
+}
+
+func TestPprofCommandsHandler(t *testing.T) {
+	handler := pprofCommandsHandler("analyze_pprof", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// Like validatedHandler with an inline profile
+		if _, ok := request.Params.Arguments[inlineProfileArg]; ok {
+			delete(request.Params.Arguments, inlineProfileArg)
+			request.Params.Arguments["profile_uri"] = "/tmp/pprof-analysis-123"
+		}
+		recordProfileSource(ctx, resolvedProfileSource{URI: "a.pprof|file:///data/b.pprof", Source: "file:///data/b.pprof", Index: 2, Count: 2})
+		return mcp.NewToolResultText("report"), nil
+	})
+	call := func(args map[string]interface{}) []mcp.Content {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		ctx := context.WithValue(context.Background(), profileSourceLogKey{}, &profileSourceLog{})
+		result, err := handler(ctx, request)
+		if err != nil {
+			t.Fatalf("handler failed: %v", err)
+		}
+		return result.Content
+	}
+
+	if content := call(map[string]interface{}{"profile_uri": "cpu.pprof"}); len(content) != 1 {
+		t.Errorf("Expected no commands without include_pprof_commands, got %v", content)
+	}
+	content := call(map[string]interface{}{"profile_uri": "a.pprof|file:///data/b.pprof", "include_pprof_commands": true})
+	if len(content) != 2 || !strings.Contains(content[1].(mcp.TextContent).Text, "go tool pprof -top /data/b.pprof") {
+		t.Errorf("Expected the command with the candidate used, got %v", content)
+	}
+	content = call(map[string]interface{}{inlineProfileArg: base64.StdEncoding.EncodeToString([]byte("x")), "include_pprof_commands": true})
+	text := content[len(content)-1].(mcp.TextContent).Text
+	if !strings.Contains(text, "go tool pprof -top "+inlineProfilePlaceholder) || !strings.Contains(text, "passed inline") {
+		t.Errorf("Expected the inline profile placeholder, got %q", text)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// pprofConfigs holds the imported pprof configs, keyed by the profile URI they apply to.
+var (
+	pprofConfigs      = make(map[string]*analyzer.PprofConfig)
+	pprofConfigsMutex sync.Mutex
+)
+
+// defaultPprofSettingsPath returns the settings file the pprof web UI saves configs to.
+func defaultPprofSettingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the user config directory: %w", err)
+	}
+	return filepath.Join(dir, "pprof", "settings.json"), nil
+}
+
+// applyPprofConfigDefaults applies the config imported for uriStr (if any) to a freshly parsed profile.
+func applyPprofConfigDefaults(uriStr string, prof *profile.Profile) error {
+	pprofConfigsMutex.Lock()
+	cfg, ok := pprofConfigs[uriStr]
+	pprofConfigsMutex.Unlock()
+	if !ok {
+		return nil
+	}
+	notes, err := cfg.Apply(prof)
+	if err != nil {
+		return fmt.Errorf("failed to apply imported pprof config '%s': %w", cfg.Name, err)
+	}
+	log.Printf("Applied pprof config '%s' to %s: %s", cfg.Name, uriStr, strings.Join(notes, "; "))
+	return nil
+}
+
+// pprofConfigFingerprint describes the config imported for uriStr for use in cache keys; empty if there is none.
+func pprofConfigFingerprint(uriStr string) string {
+	pprofConfigsMutex.Lock()
+	defer pprofConfigsMutex.Unlock()
+	cfg, ok := pprofConfigs[uriStr]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("|config:%+v", *cfg)
+}
+
+// pprofConfigFlags returns the 'go tool pprof' flags of the config imported for uriStr; nil if there is none.
+func pprofConfigFlags(uriStr string) []string {
+	pprofConfigsMutex.Lock()
+	defer pprofConfigsMutex.Unlock()
+	cfg, ok := pprofConfigs[uriStr]
+	if !ok {
+		return nil
+	}
+	return cfg.PprofFlags()
+}
+
+// handleImportPprofConfig reads a pprof web UI config and registers it as the default for analyses of a profile.
+func handleImportPprofConfig(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	configPath, _ := args["config_path"].(string)
+	configName, _ := args["config_name"].(string)
+	clear, _ := args["clear"].(bool)
+
+	if clear {
+		pprofConfigsMutex.Lock()
+		delete(pprofConfigs, profileURIStr)
+		pprofConfigsMutex.Unlock()
+		log.Printf("Cleared imported pprof config for %s", profileURIStr)
+		return &mcp.CallToolResult{
+			Content: []mcp.Content{
+				mcp.TextContent{
+					Type: "text",
+					Text: fmt.Sprintf("Removed the imported pprof config for '%s'.", profileURIStr),
+				},
+			},
+		}, nil
+	}
+
+	if configPath == "" {
+		var err error
+		if configPath, err = defaultPprofSettingsPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Handling import_pprof_config: URI=%s, ConfigPath=%s, ConfigName=%s", profileURIStr, configPath, configName)
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pprof config '%s': %w", configPath, err)
+	}
+	cfg, err := analyzer.ParsePprofConfig(data, configName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the config against the unfiltered profile now, so errors surface here rather than in later analyses
+	pprofConfigsMutex.Lock()
+	previous, hadPrevious := pprofConfigs[profileURIStr]
+	delete(pprofConfigs, profileURIStr)
+	pprofConfigsMutex.Unlock()
+	restore := func() {
+		if hadPrevious {
+			pprofConfigsMutex.Lock()
+			pprofConfigs[profileURIStr] = previous
+			pprofConfigsMutex.Unlock()
+		}
+	}
+
+	prof, err := loadProfile(ctx, profileURIStr, "")
+	if err != nil {
+		restore()
+		return nil, err
+	}
+	notes, err := cfg.Apply(prof.Copy()) // The loaded profile may be shared by the parsed-profile pool
+	if err != nil {
+		restore()
+		return nil, err
+	}
+
+	pprofConfigsMutex.Lock()
+	pprofConfigs[profileURIStr] = cfg
+	pprofConfigsMutex.Unlock()
+
+	var b strings.Builder
+	name := cfg.Name
+	if name == "" {
+		name = filepath.Base(configPath)
+	}
+	b.WriteString(fmt.Sprintf("Imported pprof config '%s' from %s as the default for analyses of '%s'.\n", name, configPath, profileURIStr))
+	if len(notes) == 0 {
+		b.WriteString("The config contains no settings that affect the analyses.\n")
+	}
+	for _, note := range notes {
+		b.WriteString(fmt.Sprintf("  - %s\n", note))
+	}
+	b.WriteString(fmt.Sprintf("After applying it the profile has %d samples. Call again with clear=true to remove it.\n", len(prof.Sample)))
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, nil
+}
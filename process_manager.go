@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net/url"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -12,14 +13,25 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
+// pprofSession 表示一个由本服务器启动的后台 UI 进程。
+// done 在进程退出 (cmd.Wait() 返回) 后关闭。
+type pprofSession struct {
+	process *os.Process
+	done    chan struct{}
+}
+
+// disconnectGracePeriod 是发送 Interrupt 后等待进程退出的时间，超时后发送 Kill。
+const disconnectGracePeriod = 5 * time.Second
+
 // 全局变量，用于跟踪由本服务器启动的 pprof 进程
 var (
-	runningPprofs = make(map[int]*os.Process) // 存储 PID 到 Process 指针的映射
-	pprofMutex    sync.Mutex                  // 用于保护 runningPprofs 的互斥锁
+	runningPprofs = make(map[int]*pprofSession) // 存储 PID 到会话的映射，进程退出后由 Wait goroutine 移除
+	pprofMutex    sync.Mutex                    // 用于保护 runningPprofs 的互斥锁
 )
 
 // handleOpenInteractivePprof 处理在 macOS 上尝试打开 pprof 交互式 UI 的请求。
@@ -30,6 +42,11 @@ func handleOpenInteractivePprof(ctx context.Context, request mcp.CallToolRequest
 
 	args := request.Params.Arguments
 
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
 	profileURIStr, ok := args["profile_uri"].(string)
 	if !ok || profileURIStr == "" {
 		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
@@ -39,52 +56,102 @@ func handleOpenInteractivePprof(ctx context.Context, request mcp.CallToolRequest
 		httpAddress = ":8081" // 默认端口
 		log.Printf("No http_address provided, using default: %s", httpAddress)
 	}
+	openBrowser, ok := args["open_browser"].(bool)
+	if !ok {
+		openBrowser = true // 与 'go tool pprof -http' 一致，本地会话默认打开浏览器
+	}
+	remote := isRemoteSession()
+	ui, ok := args["ui"].(string)
+	if !ok || ui == "" {
+		ui = "pprof"
+	}
 
-	log.Printf("Handling open_interactive_pprof: URI=%s, Address=%s", profileURIStr, httpAddress)
+	log.Printf("Handling open_interactive_pprof: URI=%s, Address=%s, UI=%s", profileURIStr, httpAddress, ui)
 
-	inputFilePath, cleanup, err := getProfileAsFile(profileURIStr) // 调用 profile_utils.go 中的函数
+	// 启动后台进程前需要用户确认 (见 confirmation.go)
+	// 预览与实际启动的命令一致 (临时文件以原始 URI 代替)，包括是否由子进程打开浏览器
+	preview := []string{"go", "tool", "pprof", "-http=" + httpAddress}
+	if !openBrowser || remote {
+		preview = append(preview, "-no_browser")
+	}
+	preview = append(preview, profileURIStr)
+	if ui == "speedscope" {
+		preview = []string{os.Args[0], serveSpeedscopeCommand, "<speedscope JSON of " + profileURIStr + ">", httpAddress, fmt.Sprintf("%t", openBrowser && !remote)}
+	}
+	if confirmErr := confirmSpawn("open_interactive_pprof", args, preview, true); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+
+	inputFilePath, cleanup, err := getProfileAsFile(ctx, profileURIStr, analysisID) // 调用 profile_utils.go 中的函数
 	if err != nil {
 		return nil, fmt.Errorf("failed to get profile file: %w", err)
 	}
-	// 注意：不能在这里 defer cleanup()，因为 pprof 进程需要持续访问文件
-
-	cmdArgs := []string{"tool", "pprof"}
-	cmdArgs = append(cmdArgs, fmt.Sprintf("-http=%s", httpAddress)) // 总是添加 -http 参数
-	cmdArgs = append(cmdArgs, inputFilePath)
+	// 注意：不能在这里 defer cleanup()，因为 pprof 进程需要持续访问文件 (speedscope 模式转换后即可清理)
+
+	var cmd *exec.Cmd
+	uiName := "go tool pprof"
+	switch ui {
+	case "pprof":
+		cmdArgs := []string{"tool", "pprof"}
+		cmdArgs = append(cmdArgs, fmt.Sprintf("-http=%s", httpAddress)) // 总是添加 -http 参数
+		if !openBrowser || remote {
+			// pprof 默认会使用平台相关的方式 (open/xdg-open/BROWSER 环境变量) 打开浏览器，未请求时禁用
+			cmdArgs = append(cmdArgs, "-no_browser")
+		}
+		cmdArgs = append(cmdArgs, inputFilePath)
 
-	log.Printf("Preparing to execute command in background: go %s", strings.Join(cmdArgs, " "))
+		log.Printf("Preparing to execute command in background: go %s", strings.Join(cmdArgs, " "))
 
-	_, err = exec.LookPath("go")
-	if err != nil {
-		log.Println("Error: 'go' command not found in PATH.")
-		if parsedURI, parseErr := url.Parse(profileURIStr); parseErr == nil && (parsedURI.Scheme == "http" || parsedURI.Scheme == "https") {
+		_, err = exec.LookPath("go")
+		if err != nil {
+			log.Println("Error: 'go' command not found in PATH.")
 			cleanup() // 尝试清理临时文件
+			return nil, fmt.Errorf("'go' command not found in PATH, cannot start pprof")
 		}
-		return nil, fmt.Errorf("'go' command not found in PATH, cannot start pprof")
-	}
+		// 不使用 CommandContext：进程需要在本次请求结束后继续运行
+		cmd = exec.Command("go", cmdArgs...)
+
+	case "speedscope":
+		uiName = "speedscope"
+		var jsonPath string
+		cmd, jsonPath, err = buildSpeedscopeCommand(inputFilePath, httpAddress, openBrowser && !remote)
+		// 转换后的 JSON 由子进程负责删除，原始的临时 profile 文件 (下载或解密的副本) 不再需要
+		cleanup()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Prepared speedscope profile %s, starting UI server in background", jsonPath)
 
-	cmd := exec.CommandContext(ctx, "go", cmdArgs...)
-	err = cmd.Start()
+	default:
+		cleanup()
+		return nil, fmt.Errorf("unsupported ui: '%s' (expected 'pprof' or 'speedscope')", ui)
+	}
 
+	var onExit func()
+	if ui == "pprof" {
+		onExit = cleanup // 进程已退出，下载或解密的临时文件不再需要
+	}
+	pid, err := startPprofSession(cmd, uiName, onExit)
 	if err != nil {
-		log.Printf("Error starting 'go tool pprof' in background: %v", err)
-		if parsedURI, parseErr := url.Parse(profileURIStr); parseErr == nil && (parsedURI.Scheme == "http" || parsedURI.Scheme == "https") {
-			cleanup() // 尝试清理临时文件
-		}
-		return nil, fmt.Errorf("failed to start 'go tool pprof': %w", err)
+		log.Printf("Error starting '%s' in background: %v", uiName, err)
+		cleanup() // 尝试清理临时文件
+		return nil, fmt.Errorf("failed to start '%s': %w", uiName, err)
 	}
 
-	pid := cmd.Process.Pid
-	pprofMutex.Lock()
-	runningPprofs[pid] = cmd.Process
-	pprofMutex.Unlock()
-
-	log.Printf("Successfully started 'go tool pprof' in background with PID: %d", pid)
+	log.Printf("Successfully started '%s' in background with PID: %d", uiName, pid)
 
-	resultText := fmt.Sprintf("已成功在后台启动 'go tool pprof' (PID: %d) 来分析 '%s'", pid, inputFilePath)
+	resultText := fmt.Sprintf("已成功在后台启动 '%s' (PID: %d) 来分析 '%s'", uiName, pid, inputFilePath)
 	resultText += fmt.Sprintf("，监听地址约为 %s。", httpAddress)
 	resultText += "\n你可以使用 'disconnect_pprof_session' 工具并提供 PID 来尝试终止此进程。"
-	resultText += "\n注意：如果是远程 URL，下载的临时 pprof 文件在进程结束前不会被自动删除。"
+	resultText += "\n如果 UI 未能正常启动，可以使用 'get_pprof_session_logs' 工具并提供 PID 查看进程输出。"
+	if ui == "pprof" {
+		resultText += "\n注意：如果是远程 URL，下载的临时 pprof 文件在进程结束后才会被删除。"
+	}
+	if openBrowser && remote {
+		resultText += "\n检测到服务器运行在远程会话中，未在服务器主机上打开浏览器，请使用下面的 URL 访问。"
+	} else if openBrowser {
+		resultText += "\nUI 就绪后将使用默认浏览器打开。"
+	}
 
 	log.Println(resultText)
 
@@ -94,10 +161,78 @@ func handleOpenInteractivePprof(ctx context.Context, request mcp.CallToolRequest
 				Type: "text",
 				Text: resultText,
 			},
+			mcp.TextContent{
+				Type: "text",
+				Text: pprofUIURL(httpAddress),
+			},
 		},
 	}, nil
 }
 
+// startPprofSession 在后台启动 cmd，捕获其输出供 get_pprof_session_logs 查看，并记录为运行中的会话。
+// 进程退出后会被回收并移出运行记录，退出状态随日志保存，然后调用 onExit (如果不为 nil)。
+func startPprofSession(cmd *exec.Cmd, uiName string, onExit func()) (int, error) {
+	logBuf := newRingBuffer(sessionLogCapacity) // 捕获 stdout/stderr，便于排查 UI 启动失败的原因
+	cmd.Stdout = logBuf
+	cmd.Stderr = logBuf
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	pid := cmd.Process.Pid
+	session := &pprofSession{process: cmd.Process, done: make(chan struct{})}
+	pprofMutex.Lock()
+	runningPprofs[pid] = session
+	pprofMutex.Unlock()
+	registerSessionLog(pid, logBuf)
+
+	// 由唯一的 goroutine 负责 Wait：回收已退出的进程 (避免僵尸进程)，记录退出状态并移除运行记录，
+	// 这样崩溃的进程不会一直显示为 running
+	go func() {
+		waitErr := cmd.Wait()
+		pprofMutex.Lock()
+		if runningPprofs[pid] == session {
+			delete(runningPprofs, pid)
+		}
+		pprofMutex.Unlock()
+		close(session.done)
+
+		status := "exit status 0"
+		if waitErr != nil {
+			status = waitErr.Error()
+		}
+		log.Printf("'%s' (PID %d) exited: %s", uiName, pid, status)
+		markSessionExited(pid, logBuf, status)
+		if onExit != nil {
+			onExit()
+		}
+	}()
+	return pid, nil
+}
+
+// pprofUIURL 根据 -http 监听地址构造可点击的 UI URL。
+func pprofUIURL(httpAddress string) string {
+	host, port, err := net.SplitHostPort(httpAddress)
+	if err != nil {
+		return "http://" + httpAddress
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
+
+// isRemoteSession 判断服务器是否运行在无法直接打开浏览器的远程环境中 (例如 SSH 会话或无图形界面的 Linux)。
+func isRemoteSession() bool {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
+		return true
+	}
+	if runtime.GOOS == "linux" && os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return true
+	}
+	return false
+}
+
 // handleDisconnectPprofSession 处理断开指定 pprof 会话的请求。
 func handleDisconnectPprofSession(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := request.Params.Arguments
@@ -114,7 +249,7 @@ func handleDisconnectPprofSession(ctx context.Context, request mcp.CallToolReque
 	log.Printf("Handling disconnect_pprof_session for PID: %d", pid)
 
 	pprofMutex.Lock()
-	process, exists := runningPprofs[pid]
+	session, exists := runningPprofs[pid]
 	if !exists {
 		pprofMutex.Unlock()
 		log.Printf("PID %d not found in running pprof sessions.", pid)
@@ -124,24 +259,26 @@ func handleDisconnectPprofSession(ctx context.Context, request mcp.CallToolReque
 	pprofMutex.Unlock()
 
 	log.Printf("Attempting to terminate process with PID: %d", pid)
-	err := process.Signal(os.Interrupt) // 尝试 Interrupt
+	err := session.process.Signal(os.Interrupt) // 尝试 Interrupt
+	if err == nil {
+		// 进程由启动时的 Wait goroutine 回收，这里只等待它退出
+		select {
+		case <-session.done:
+		case <-time.After(disconnectGracePeriod):
+			log.Printf("PID %d did not exit within %s after Interrupt. Trying Kill signal.", pid, disconnectGracePeriod)
+			err = fmt.Errorf("process did not exit after Interrupt")
+		}
+	}
 	if err != nil {
-		log.Printf("Failed to send Interrupt signal to PID %d: %v. Trying Kill signal.", pid, err)
-		err = process.Signal(os.Kill) // 尝试 Kill
-		if err != nil {
+		log.Printf("Failed to terminate PID %d with Interrupt: %v. Trying Kill signal.", pid, err)
+		err = session.process.Signal(os.Kill) // 尝试 Kill
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
 			log.Printf("Failed to send Kill signal to PID %d: %v", pid, err)
 			// 即使信号发送失败，也认为尝试过断开，但返回错误
 			return nil, fmt.Errorf("尝试终止 PID %d 失败：%w", pid, err)
 		}
 	}
 
-	// 尝试释放进程资源（虽然信号可能异步处理，但这有助于清理）
-	_, err = process.Wait()
-	if err != nil && !strings.Contains(err.Error(), "wait: no child processes") && !strings.Contains(err.Error(), "signal:") {
-		// 忽略 "no child processes" 和信号相关的错误，因为进程可能已经被信号终止
-		log.Printf("Warning: Error waiting for process PID %d after signaling: %v", pid, err)
-	}
-
 	resultText := fmt.Sprintf("已成功向 PID %d 发送终止信号。", pid)
 	log.Println(resultText)
 
@@ -165,14 +302,19 @@ func setupSignalHandler() {
 		sig := <-sigs
 		log.Printf("Received signal: %s. Cleaning up running pprof processes...", sig)
 
+		// 先停止快照计划，避免退出时写入不完整的快照
+		if stopped := stopSnapshotSchedules(snapshotStopTimeout); stopped > 0 {
+			log.Printf("Stopped %d snapshot schedule(s).", stopped)
+		}
+
 		pprofMutex.Lock()
 		pidsToTerminate := make([]int, 0, len(runningPprofs))
 		processesToTerminate := make([]*os.Process, 0, len(runningPprofs))
-		for pid, process := range runningPprofs {
+		for pid, session := range runningPprofs {
 			pidsToTerminate = append(pidsToTerminate, pid)
-			processesToTerminate = append(processesToTerminate, process)
+			processesToTerminate = append(processesToTerminate, session.process)
 		}
-		runningPprofs = make(map[int]*os.Process) // 清空 map
+		runningPprofs = make(map[int]*pprofSession) // 清空 map
 		pprofMutex.Unlock()
 
 		if len(pidsToTerminate) == 0 {
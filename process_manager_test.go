@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// startTestSession starts a shell script as a pprof session and returns its PID and a channel closed once
+// the session has been reaped.
+func startTestSession(t *testing.T, script string) (int, chan struct{}) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	exited := make(chan struct{})
+	pid, err := startPprofSession(exec.Command("sh", "-c", script), "test", func() { close(exited) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pprofMutex.Lock()
+		session := runningPprofs[pid]
+		pprofMutex.Unlock()
+		if session != nil {
+			session.process.Kill()
+			<-exited
+		}
+		dropSessionLog(pid, sessionLogBuffer(pid))
+	})
+	return pid, exited
+}
+
+// sessionLogBuffer returns the log buffer of a session, or nil.
+func sessionLogBuffer(pid int) *ringBuffer {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+	if entry, ok := sessionLogs[pid]; ok {
+		return entry.buf
+	}
+	return nil
+}
+
+func waitExited(t *testing.T, exited chan struct{}) {
+	t.Helper()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the session to be reaped")
+	}
+}
+
+func TestPprofSessionReaped(t *testing.T) {
+	pid, exited := startTestSession(t, "echo 'failed to load profile' >&2; exit 3")
+	waitExited(t, exited)
+
+	// The crashed process no longer shows as running, and its logs keep the exit status
+	pprofMutex.Lock()
+	_, running := runningPprofs[pid]
+	pprofMutex.Unlock()
+	if running {
+		t.Error("Expected the exited session to be removed from the running sessions")
+	}
+	text, err := sessionLogsText(t, pid, 0)
+	if err != nil || !strings.Contains(text, "(exited: exit status 3)") || !strings.Contains(text, "failed to load profile") {
+		t.Errorf("Expected the exit status and the output (%v):\n%s", err, text)
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"pid": float64(pid)}
+	if _, err := handleDisconnectPprofSession(context.Background(), request); err == nil {
+		t.Error("Expected disconnecting an exited session to fail")
+	}
+}
+
+func TestDisconnectPprofSession(t *testing.T) {
+	pid, exited := startTestSession(t, "echo serving; exec sleep 60")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if buf := sessionLogBuffer(pid); buf != nil && strings.Contains(buf.String(), "serving") {
+			break
+		}
+	}
+	if text, err := sessionLogsText(t, pid, 0); err != nil || !strings.Contains(text, "(running)") {
+		t.Fatalf("Expected a running session (%v):\n%s", err, text)
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"pid": float64(pid)}
+	if _, err := handleDisconnectPprofSession(context.Background(), request); err != nil {
+		t.Fatalf("disconnect_pprof_session failed: %v", err)
+	}
+	waitExited(t, exited)
+	if text, _ := sessionLogsText(t, pid, 0); !strings.Contains(text, "(exited: signal: interrupt)") {
+		t.Errorf("Expected the session to be interrupted:\n%s", text)
+	}
+}
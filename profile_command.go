@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// profileCommandArg is the alternative to 'profile_uri' for capture paths the built-in fetchers don't know
+// about (e.g. 'kubectl exec pod -- curl -s localhost:6060/debug/pprof/heap'): the command's stdout is the
+// profile.
+const profileCommandArg = "profile_command"
+
+// profileCommandsEnv is the allowlist of profile commands: command prefixes separated by ';', each split into
+// arguments like splitCommandLine, e.g. "kubectl exec; ssh prod-db curl -s". A command is run only if its
+// arguments start with those of a prefix; the executable must match exactly, so "kubectl" does not allow
+// "/tmp/kubectl". Unset, profile_command is disabled.
+const profileCommandsEnv = "PPROF_ANALYZER_PROFILE_COMMANDS"
+
+// profileCommandEnvEnv lists the environment variables passed to profile commands besides the basic ones
+// (see commandEnviron), separated by commas, e.g. "KUBECONFIG,AWS_PROFILE".
+const profileCommandEnvEnv = "PPROF_ANALYZER_PROFILE_COMMAND_ENV"
+
+// profileCommandTimeoutEnv overrides how long a profile command may run (a Go duration such as "5m").
+const profileCommandTimeoutEnv = "PPROF_ANALYZER_PROFILE_COMMAND_TIMEOUT"
+
+// maxProfileCommandEnv caps the output of profile commands, in MB (default 256).
+const maxProfileCommandEnv = "PPROF_ANALYZER_MAX_PROFILE_COMMAND_MB"
+
+const (
+	defaultProfileCommandTimeout = 2 * time.Minute
+	defaultMaxProfileCommandMB   = 256
+	// maxProfileCommandStderr is the number of bytes of stderr kept for the error of a failed command.
+	maxProfileCommandStderr = 2048
+)
+
+// profileCommandAllowlist returns the allowed command prefixes; invalid entries are ignored with a warning.
+func profileCommandAllowlist() [][]string {
+	prefixes := make([][]string, 0)
+	for _, entry := range strings.Split(os.Getenv(profileCommandsEnv), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		prefix, err := splitCommandLine(entry)
+		if err != nil || len(prefix) == 0 {
+			log.Printf("Warning: ignoring invalid %s entry %q: %v", profileCommandsEnv, entry, err)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// profileCommandAllowed reports whether command starts with one of the allowed prefixes.
+func profileCommandAllowed(command []string, allowlist [][]string) bool {
+	for _, prefix := range allowlist {
+		if len(command) < len(prefix) {
+			continue
+		}
+		allowed := true
+		for i := range prefix {
+			if command[i] != prefix[i] {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// withProfileCommand declares the profile command argument on a tool whose 'profile_uri' it replaces. The
+// description lists the allowed prefixes read at startup, so clients know what they may run.
+func withProfileCommand() mcp.ToolOption {
+	allowed := "disabled on this server: set " + profileCommandsEnv + " to enable it"
+	if allowlist := profileCommandAllowlist(); len(allowlist) > 0 {
+		prefixes := make([]string, 0, len(allowlist))
+		for _, prefix := range allowlist {
+			prefixes = append(prefixes, "'"+strings.Join(prefix, " ")+"'")
+		}
+		allowed = "commands starting with " + strings.Join(prefixes, ", ")
+	}
+	return mcp.WithString(profileCommandArg,
+		mcp.Description(fmt.Sprintf("A command whose stdout is the profile, instead of 'profile_uri', e.g. 'kubectl exec pod -- curl -s localhost:6060/debug/pprof/heap'. It is split into arguments like a shell would (quotes are honored) but never run through a shell, needs confirmation, and is limited to the allowlist (%s). Allowed: %s.", profileCommandsEnv, allowed)),
+	)
+}
+
+// limitedWriter writes to a file until limit bytes were written, then cancels the command producing them.
+type limitedWriter struct {
+	file     *os.File
+	limit    int64
+	written  int64
+	exceeded bool
+	cancel   context.CancelFunc
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.limit {
+		w.exceeded = true
+		w.cancel()
+		return 0, errors.New("output too large")
+	}
+	n, err := w.file.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	data []byte
+	max  int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+// materializeProfileCommand runs the profile command argument, once allowed and confirmed, and replaces it
+// with a 'profile_uri' pointing to a temporary file holding its stdout, so handlers load it like any local
+// profile. The command runs without a shell or stdin, in the temporary directory, with a minimal environment
+// (see commandEnviron and profileCommandEnvEnv), a timeout and a cap on its output. The returned function
+// removes the file, except for files belonging to an analysis: those are kept until cleanup_analysis.
+func materializeProfileCommand(ctx context.Context, tool mcp.Tool, args map[string]interface{}) (cleanup func(), err error) {
+	cleanup = func() {}
+	if _, ok := tool.InputSchema.Properties[profileCommandArg]; !ok {
+		return cleanup, nil
+	}
+	value, present := args[profileCommandArg]
+	if !present || value == nil || value == "" {
+		return cleanup, nil
+	}
+	line, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid argument %s: expected a string, got %s", profileCommandArg, jsonTypeName(value))
+	}
+	uriStr, _ := args["profile_uri"].(string)
+	inlineData, _ := args[inlineProfileArg].(string)
+	if uriStr != "" || inlineData != "" {
+		return nil, fmt.Errorf("profile_uri, %s and %s are mutually exclusive: pass only one of them", inlineProfileArg, profileCommandArg)
+	}
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	command, err := splitCommandLine(line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argument %s: %w", profileCommandArg, err)
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("invalid argument %s: the command is empty", profileCommandArg)
+	}
+	allowlist := profileCommandAllowlist()
+	if len(allowlist) == 0 {
+		return nil, fmt.Errorf("%s is disabled on this server: set %s to the allowed command prefixes to enable it", profileCommandArg, profileCommandsEnv)
+	}
+	if !profileCommandAllowed(command, allowlist) {
+		log.Printf("%s: rejected profile command not in %s: %s", tool.Name, profileCommandsEnv, strings.Join(command, " "))
+		return nil, fmt.Errorf("invalid argument %s: '%s' is not allowed; allowed commands start with one of the prefixes in %s", profileCommandArg, command[0], profileCommandsEnv)
+	}
+	if confirmErr := confirmSpawn(tool.Name, args, command, false); confirmErr != nil {
+		return nil, confirmErr
+	}
+
+	timeout := defaultProfileCommandTimeout
+	if value := os.Getenv(profileCommandTimeoutEnv); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Printf("Warning: ignoring invalid %s=%q, using %s", profileCommandTimeoutEnv, value, timeout)
+		} else {
+			timeout = parsed
+		}
+	}
+	maxMB := captureLimit(maxProfileCommandEnv, defaultMaxProfileCommandMB)
+
+	tempFile, err := os.CreateTemp("", analysisTempPattern(analysisID, "profile")) // 与下载的 profile 命名相同
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for the output of %s: %w", profileCommandArg, err)
+	}
+	filePath := tempFile.Name()
+	removeTemp := func() {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to remove temporary file '%s': %v", filePath, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = os.TempDir()
+	cmd.Env = commandEnviron(strings.Split(os.Getenv(profileCommandEnvEnv), ",")...)
+	cmd.WaitDelay = time.Second // Don't wait for children still holding the output pipe after a kill
+	stdout := &limitedWriter{file: tempFile, limit: int64(maxMB) << 20, cancel: cancel}
+	stderr := &tailBuffer{max: maxProfileCommandStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	log.Printf("%s: running profile command: %s", tool.Name, strings.Join(command, " "))
+	start := time.Now()
+	err = cmd.Run()
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	message := strings.TrimSpace(string(stderr.data))
+	switch {
+	case stdout.exceeded:
+		err = fmt.Errorf("the output is larger than %d MB (%s)", maxMB, maxProfileCommandEnv)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("timed out after %s (%s)", timeout, profileCommandTimeoutEnv)
+	case err == nil && stdout.written == 0:
+		err = errors.New("the command wrote nothing to stdout")
+	}
+	if err != nil {
+		removeTemp()
+		if message != "" {
+			return nil, fmt.Errorf("%s '%s' failed: %w. Stderr: %s", profileCommandArg, strings.Join(command, " "), err, message)
+		}
+		return nil, fmt.Errorf("%s '%s' failed: %w", profileCommandArg, strings.Join(command, " "), err)
+	}
+	log.Printf("Wrote output of profile command (%d bytes in %s) to temporary file: %s", stdout.written, time.Since(start).Round(time.Millisecond), filePath)
+	// 命令直接写入临时文件，完成后再加密
+	if err := sealProfileFile(filePath); err != nil {
+		removeTemp()
+		return nil, fmt.Errorf("failed to encrypt the output of %s: %w", profileCommandArg, err)
+	}
+
+	cleanup = removeTemp
+	if analysisID != "" {
+		cleanup = func() {}
+		if err := recordAnalysisArtifact(analysisID, AnalysisArtifact{Path: filePath, Kind: "profile", Source: profileCommandArg + ":" + strings.Join(command, " "), Temporary: true}); err != nil {
+			log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+		}
+	}
+	delete(args, profileCommandArg)
+	args["profile_uri"] = filePath
+	return cleanup, nil
+}
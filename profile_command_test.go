@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestProfileCommandAllowed(t *testing.T) {
+	t.Setenv(profileCommandsEnv, "kubectl exec; ssh prod-db 'curl -s' ;;")
+	allowlist := profileCommandAllowlist()
+	if len(allowlist) != 2 || len(allowlist[1]) != 3 || allowlist[1][2] != "curl -s" {
+		t.Fatalf("Unexpected allowlist: %q", allowlist)
+	}
+	for command, want := range map[string]bool{
+		"kubectl exec pod -- curl -s localhost:6060/debug/pprof/heap": true,
+		"kubectl exec":                   true,
+		"kubectl delete pod":             false,
+		"/tmp/kubectl exec pod":          false,
+		"ssh prod-db 'curl -s' http://x": true,
+		"ssh prod-db curl -s http://x":   false, // "curl -s" is a single argument of the prefix
+	} {
+		args, err := splitCommandLine(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := profileCommandAllowed(args, allowlist); got != want {
+			t.Errorf("profileCommandAllowed(%q) = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestMaterializeProfileCommand(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cpu.pprof")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := poolTestProfile("main.remote", 3, 4).Write(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	tool := mcp.NewTool("command_tool", mcp.WithString("profile_uri"), withInlineProfileData(), withProfileCommand())
+	t.Setenv(confirmEnv, "off")
+	t.Setenv(profileCommandsEnv, "cat; true; sleep; head -c")
+	t.Setenv(maxProfileCommandEnv, "1")
+	t.Setenv(profileCommandTimeoutEnv, "200ms")
+
+	cases := []struct {
+		name string
+		args map[string]interface{}
+		err  string // Expected substring of the error; empty when the command succeeds
+	}{
+		{name: "Output", args: map[string]interface{}{profileCommandArg: "cat " + path}},
+		{name: "NotAllowed", args: map[string]interface{}{profileCommandArg: "rm -rf " + dir}, err: "'rm' is not allowed"},
+		{name: "Both", args: map[string]interface{}{profileCommandArg: "cat " + path, "profile_uri": path}, err: "mutually exclusive"},
+		{name: "Unterminated", args: map[string]interface{}{profileCommandArg: "cat '" + path}, err: "unterminated quote"},
+		{name: "Failure", args: map[string]interface{}{profileCommandArg: "cat " + filepath.Join(dir, "missing")}, err: "No such file"},
+		{name: "NoOutput", args: map[string]interface{}{profileCommandArg: "true"}, err: "wrote nothing to stdout"},
+		{name: "Timeout", args: map[string]interface{}{profileCommandArg: "sleep 5"}, err: "timed out after 200ms"},
+		{name: "TooLarge", args: map[string]interface{}{profileCommandArg: "head -c 2000000 /dev/zero"}, err: "larger than 1 MB"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cleanup, err := materializeProfileCommand(context.Background(), tool, tc.args)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer cleanup()
+			if _, ok := tc.args[profileCommandArg]; ok {
+				t.Errorf("Expected %s to be replaced by profile_uri", profileCommandArg)
+			}
+			prof, err := loadProfile(context.Background(), tc.args["profile_uri"].(string), "")
+			if err != nil || prof.Sample[0].Location[0].Line[0].Function.Name != "main.remote" {
+				t.Errorf("Expected the command's output as the profile, got %v", err)
+			}
+		})
+	}
+
+	t.Setenv(profileCommandsEnv, "")
+	if _, err := materializeProfileCommand(context.Background(), tool, map[string]interface{}{profileCommandArg: "cat " + path}); err == nil || !strings.Contains(err.Error(), "disabled on this server") {
+		t.Errorf("Expected profile_command to be disabled without an allowlist, got %v", err)
+	}
+}
+
+func TestProfileCommandConfirmation(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	t.Setenv(confirmEnv, "on")
+	t.Setenv(profileCommandsEnv, "touch")
+	tool := mcp.NewTool("command_tool", mcp.WithString("profile_uri"), withInlineProfileData(), withProfileCommand())
+	handler := validatedHandler(tool, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("handled"), nil
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]interface{}{profileCommandArg: "touch " + marker}
+	result, err := handler(context.Background(), request)
+	if err != nil || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "spawn_process") {
+		t.Fatalf("Expected a structured confirmation request, got %v (%v)", result, err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Expected the command not to run before it was confirmed")
+	}
+}
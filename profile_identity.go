@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// fileDigest returns the SHA256 of the profile file from a cache key of loadProfileWithKey, which starts
+// with it (an imported pprof config appends its fingerprint).
+func fileDigest(cacheKey string) string {
+	if len(cacheKey) < sha256.Size*2 {
+		return cacheKey
+	}
+	return cacheKey[:sha256.Size*2]
+}
+
+// handleIsSameProfile tells whether two URIs refer to byte-identical or semantically identical profiles.
+func handleIsSameProfile(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	otherURIStr, ok := args["other_profile_uri"].(string)
+	if !ok || otherURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: other_profile_uri (string)")
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling is_same_profile: URI=%s, Other=%s, Format=%s", profileURIStr, otherURIStr, outputFormat)
+
+	prof, key, err := loadProfileWithKey(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	other, otherKey, err := loadProfileWithKey(ctx, otherURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := analyzer.CompareProfileIdentity(prof, other)
+	identity.ProfileURI, identity.OtherURI = profileURIStr, otherURIStr
+	identity.Digest, identity.OtherDigest = fileDigest(key), fileDigest(otherKey)
+	identity.ByteIdentical = identity.Digest == identity.OtherDigest
+	result, err := analyzer.FormatProfileIdentity(identity, outputFormat)
+	if err != nil {
+		return nil, err
+	}
+	hookReport := saveAnalysisResult(ctx, analysisID, "is_same_profile", outputFormat, result)
+
+	return withRecoveryWarnings(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, hookReport), prof, other), nil
+}
+
+// withSameProfileWarning puts a warning before the result of a tool comparing two profiles when they are
+// semantically identical, e.g. the same file passed twice by mistake.
+func withSameProfileWarning(result *mcp.CallToolResult, p, other *profile.Profile) *mcp.CallToolResult {
+	if !analyzer.CompareProfileIdentity(p, other).SemanticallyIdentical {
+		return result
+	}
+	warning := mcp.TextContent{Type: "text", Text: "WARNING: both profiles are identical (the same file or the same samples), so the comparison shows no changes. Check that the intended snapshots were passed (see is_same_profile)."}
+	result.Content = append([]mcp.Content{warning}, result.Content...)
+	return result
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/google/pprof/profile"
+)
+
+// profilePoolEnv overrides the memory budget of the parsed-profile pool, in MB (0 disables the pool).
+const profilePoolEnv = "PPROF_ANALYZER_PROFILE_POOL_MB"
+
+// defaultProfilePoolMB is the default memory budget of the parsed-profile pool.
+const defaultProfilePoolMB = 256
+
+// parsedProfiles keeps recently parsed profiles keyed by the SHA256 of their file, so tools drilling into the
+// same profile one after another skip parsing it again. Pooled profiles are shared and must not be modified;
+// loadProfileWithKey hands out a copy when an imported pprof config has to be applied.
+var parsedProfiles = newProfilePool(profilePoolBudget())
+
+// profilePool is a least-recently-used cache of parsed profiles with a memory budget rather than an entry count,
+// since profile sizes vary by orders of magnitude.
+type profilePool struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	usedBytes int64
+	order     *list.List // Front is the most recently used profile
+	entries   map[string]*list.Element
+}
+
+type pooledProfile struct {
+	digest string
+	prof   *profile.Profile
+	size   int64
+}
+
+func newProfilePool(maxBytes int64) *profilePool {
+	return &profilePool{maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// profilePoolBudget returns the pool budget in bytes from $PPROF_ANALYZER_PROFILE_POOL_MB, or the default.
+func profilePoolBudget() int64 {
+	mb := int64(defaultProfilePoolMB)
+	if value := os.Getenv(profilePoolEnv); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			log.Printf("Warning: ignoring invalid %s=%q, using %d MB", profilePoolEnv, value, mb)
+		} else {
+			mb = parsed
+		}
+	}
+	return mb << 20
+}
+
+// get returns the pooled profile parsed from a file with the given digest.
+func (pp *profilePool) get(digest string) (*profile.Profile, bool) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	elem, ok := pp.entries[digest]
+	if !ok {
+		return nil, false
+	}
+	pp.order.MoveToFront(elem)
+	return elem.Value.(*pooledProfile).prof, true
+}
+
+// add pools a parsed profile, evicting the least recently used ones until it fits the budget.
+// Profiles larger than the whole budget are not pooled.
+func (pp *profilePool) add(digest string, prof *profile.Profile) {
+	size := estimateProfileSize(prof)
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if size > pp.maxBytes {
+		return
+	}
+	if _, ok := pp.entries[digest]; ok {
+		return
+	}
+	for pp.usedBytes+size > pp.maxBytes {
+		oldest := pp.order.Back()
+		evicted := oldest.Value.(*pooledProfile)
+		pp.order.Remove(oldest)
+		delete(pp.entries, evicted.digest)
+		pp.usedBytes -= evicted.size
+		log.Printf("Evicted parsed profile %.12s (~%d KB) from the pool", evicted.digest, evicted.size>>10)
+	}
+	pp.entries[digest] = pp.order.PushFront(&pooledProfile{digest: digest, prof: prof, size: size})
+	pp.usedBytes += size
+}
+
+// purge drops all pooled profiles, e.g. to free memory when the analyzer is over its memory budget.
+func (pp *profilePool) purge() {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if len(pp.entries) > 0 {
+		log.Printf("Purging %d parsed profile(s) (~%d KB) from the pool", len(pp.entries), pp.usedBytes>>10)
+	}
+	pp.order.Init()
+	pp.entries = make(map[string]*list.Element)
+	pp.usedBytes = 0
+}
+
+// estimateProfileSize roughly estimates the memory held by a parsed profile from its samples, locations and
+// functions; exact accounting is not needed for eviction.
+func estimateProfileSize(p *profile.Profile) int64 {
+	size := int64(256)
+	for _, s := range p.Sample {
+		size += 96 + 8*int64(len(s.Value)+len(s.Location))
+		for key, values := range s.Label {
+			size += int64(len(key)) + 16*int64(len(values))
+		}
+	}
+	for _, loc := range p.Location {
+		size += 96 + 40*int64(len(loc.Line))
+	}
+	for _, fn := range p.Function {
+		size += 80 + int64(len(fn.Name)+len(fn.SystemName)+len(fn.Filename))
+	}
+	for _, m := range p.Mapping {
+		size += 128 + int64(len(m.File)+len(m.BuildID))
+	}
+	return size
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// poolTestProfile returns a small CPU profile with one sample per value, all in function fn.
+func poolTestProfile(fn string, values ...int64) *profile.Profile {
+	function := &profile.Function{ID: 1, Name: fn}
+	location := &profile.Location{ID: 1, Line: []profile.Line{{Function: function}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{function},
+		Location:   []*profile.Location{location},
+	}
+	for _, v := range values {
+		p.Sample = append(p.Sample, &profile.Sample{Value: []int64{v}, Location: []*profile.Location{location}})
+	}
+	return p
+}
+
+// checkPoolInvariants verifies that the pool's bookkeeping is consistent and within its budget.
+func checkPoolInvariants(t *testing.T, pp *profilePool) {
+	t.Helper()
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if pp.order.Len() != len(pp.entries) {
+		t.Errorf("List has %d elements but the map %d entries", pp.order.Len(), len(pp.entries))
+	}
+	var used int64
+	for elem := pp.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*pooledProfile)
+		if pp.entries[entry.digest] != elem {
+			t.Errorf("Entry %s is not indexed by its digest", entry.digest)
+		}
+		used += entry.size
+	}
+	if used != pp.usedBytes || used > pp.maxBytes {
+		t.Errorf("Pool accounts for %d bytes, entries hold %d, budget %d", pp.usedBytes, used, pp.maxBytes)
+	}
+}
+
+func TestProfilePoolEvictsLeastRecentlyUsed(t *testing.T) {
+	a, b, c := poolTestProfile("main.a", 1), poolTestProfile("main.b", 2), poolTestProfile("main.c", 3)
+	size := estimateProfileSize(a)
+	pp := newProfilePool(2*size + size/2) // Room for two profiles
+
+	pp.add("a", a)
+	pp.add("b", b)
+	// Using a makes b the least recently used profile
+	if got, ok := pp.get("a"); !ok || got != a {
+		t.Fatalf("Expected the pooled profile a, got %v, %v", got, ok)
+	}
+	pp.add("c", c)
+
+	if _, ok := pp.get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for digest, want := range map[string]*profile.Profile{"a": a, "c": c} {
+		if got, ok := pp.get(digest); !ok || got != want {
+			t.Errorf("Expected %s to stay pooled", digest)
+		}
+	}
+	checkPoolInvariants(t, pp)
+
+	// A profile larger than the whole budget is not pooled and evicts nothing
+	pp.add("big", poolTestProfile("main.big", make([]int64, 1000)...))
+	if _, ok := pp.get("big"); ok {
+		t.Error("Expected a profile larger than the budget not to be pooled")
+	}
+	if _, ok := pp.get("a"); !ok {
+		t.Error("Expected an oversized profile not to evict others")
+	}
+
+	pp.purge()
+	if _, ok := pp.get("a"); ok {
+		t.Error("Expected purge to drop every profile")
+	}
+	checkPoolInvariants(t, pp)
+}
+
+func TestLoadProfileKeyChangesWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.pb.gz")
+	write := func(p *profile.Profile) {
+		t.Helper()
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Write(file); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func() (*profile.Profile, string) {
+		t.Helper()
+		p, key, err := loadProfileWithKey(context.Background(), path, "")
+		if err != nil {
+			t.Fatalf("loadProfileWithKey failed: %v", err)
+		}
+		return p, key
+	}
+
+	write(poolTestProfile("main.before", 10))
+	first, firstKey := load()
+	if again, againKey := load(); again != first || againKey != firstKey {
+		t.Error("Expected an unchanged file to reuse the pooled profile and its key")
+	}
+
+	// The key is the digest of the content, so rewriting the file invalidates it
+	write(poolTestProfile("main.after", 20, 30))
+	changed, changedKey := load()
+	if changedKey == firstKey || changed == first {
+		t.Fatal("Expected a changed file to get a new key and a freshly parsed profile")
+	}
+	if len(changed.Sample) != 2 || changed.Function[0].Name != "main.after" {
+		t.Errorf("Expected the rewritten profile, got %d samples of %s", len(changed.Sample), changed.Function[0].Name)
+	}
+
+	write(poolTestProfile("main.before", 10))
+	if _, key := load(); key != firstKey {
+		t.Errorf("Expected the original content to get its original key back, got %s", key)
+	}
+}
+
+func TestProfilePoolConcurrentAccess(t *testing.T) {
+	profiles := make([]*profile.Profile, 8)
+	for i := range profiles {
+		profiles[i] = poolTestProfile(fmt.Sprintf("main.f%d", i), int64(i))
+	}
+	// Room for about half of them, so adds keep evicting
+	pp := newProfilePool(4*estimateProfileSize(profiles[0]) + 1)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				n := (g + i) % len(profiles)
+				digest := fmt.Sprintf("digest-%d", n)
+				if p, ok := pp.get(digest); ok && p != profiles[n] {
+					t.Errorf("get(%s) returned another digest's profile", digest)
+					return
+				}
+				pp.add(digest, profiles[n])
+				if i%100 == 99 {
+					pp.purge()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	checkPoolInvariants(t, pp)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// profileURISeparator separates the candidate locations of a profile URI, e.g.
+// "cache/cpu.pprof|https://artifacts.example.com/run/42/cpu.pprof|http://host:6060/debug/pprof/profile".
+// URLs cannot contain an unescaped '|' (RFC 3986), so a single URL is never split.
+const profileURISeparator = "|"
+
+// profileURICandidates splits a profile URI into its candidate locations, in priority order. A URI without
+// separator is its only candidate.
+func profileURICandidates(uriStr string) []string {
+	if !strings.Contains(uriStr, profileURISeparator) {
+		return []string{uriStr}
+	}
+	candidates := make([]string, 0)
+	for _, candidate := range strings.Split(uriStr, profileURISeparator) {
+		if candidate = strings.TrimSpace(candidate); candidate != "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// resolvedProfileSource is the candidate a profile URI with several candidate locations was loaded from.
+type resolvedProfileSource struct {
+	URI         string   // The whole candidate list, as given
+	Source      string   // The candidate used
+	Index       int      // 1-based position of Source in the list
+	Count       int      // Number of candidates
+	Unavailable []string // Errors of the candidates tried before Source
+}
+
+// profileSourceLog collects the sources resolved during one tool call (see sourceReportingHandler).
+type profileSourceLog struct {
+	mutex   sync.Mutex
+	sources []resolvedProfileSource
+}
+
+type profileSourceLogKey struct{}
+
+// recordProfileSource adds a resolved source to the log of the tool call in ctx, if any.
+func recordProfileSource(ctx context.Context, source resolvedProfileSource) {
+	sourceLog, ok := ctx.Value(profileSourceLogKey{}).(*profileSourceLog)
+	if !ok {
+		return
+	}
+	sourceLog.mutex.Lock()
+	defer sourceLog.mutex.Unlock()
+	for _, s := range sourceLog.sources {
+		if s.URI == source.URI && s.Source == source.Source {
+			return // Loaded more than once by the same call (e.g. once per pass of a tool)
+		}
+	}
+	sourceLog.sources = append(sourceLog.sources, source)
+}
+
+// getProfileFromCandidates tries the candidate locations of uriStr in order and returns the first that can be
+// used: a local file that exists, or a download that succeeds. The candidate used is logged, recorded in the
+// analysis manifest as the artifact's source (by getSingleProfileAsFile) and reported in the tool result (see
+// sourceReportingHandler). When every candidate fails, the error lists why each one did.
+func getProfileFromCandidates(ctx context.Context, uriStr string, candidates []string, analysisID string) (string, func(), error) {
+	var unavailable []string
+	for i, candidate := range candidates {
+		if err := ctx.Err(); err != nil {
+			return "", nil, err
+		}
+		if path := localProfilePath(candidate); path != "" {
+			// Local paths are otherwise accepted without checking, which would end the search at a missing file
+			if _, err := os.Stat(path); err != nil {
+				log.Printf("Profile candidate %d of %d ('%s') is unavailable: %v", i+1, len(candidates), candidate, err)
+				unavailable = append(unavailable, fmt.Sprintf("%s: %v", candidate, err))
+				continue
+			}
+		}
+		filePath, cleanup, err := getSingleProfileAsFile(ctx, candidate, analysisID)
+		if err != nil {
+			log.Printf("Profile candidate %d of %d ('%s') is unavailable: %v", i+1, len(candidates), candidate, err)
+			unavailable = append(unavailable, fmt.Sprintf("%s: %v", candidate, err))
+			continue
+		}
+		log.Printf("Resolved profile URI '%s' to candidate %d of %d: %s", uriStr, i+1, len(candidates), candidate)
+		recordProfileSource(ctx, resolvedProfileSource{URI: uriStr, Source: candidate, Index: i + 1, Count: len(candidates), Unavailable: unavailable})
+		return filePath, cleanup, nil
+	}
+	return "", nil, fmt.Errorf("none of the %d candidate locations of the profile is available:\n  %s", len(candidates), strings.Join(unavailable, "\n  "))
+}
+
+// sourceReportingHandler wraps handler to append, to successful results, which candidate each profile URI with
+// several candidate locations was loaded from.
+func sourceReportingHandler(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sourceLog := &profileSourceLog{}
+		result, err := handler(context.WithValue(ctx, profileSourceLogKey{}, sourceLog), request)
+		if err != nil || result == nil || result.IsError || len(sourceLog.sources) == 0 {
+			return result, err
+		}
+		var b strings.Builder
+		b.WriteString("Profile sources:")
+		for _, s := range sourceLog.sources {
+			fmt.Fprintf(&b, "\n- %s (candidate %d of %d)", s.Source, s.Index, s.Count)
+			for _, reason := range s.Unavailable {
+				fmt.Fprintf(&b, "\n  skipped %s", reason)
+			}
+		}
+		result.Content = append(result.Content, mcp.TextContent{Type: "text", Text: b.String()})
+		return result, nil
+	}
+}
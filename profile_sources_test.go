@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestProfileURICandidates(t *testing.T) {
+	cases := []struct {
+		uri  string
+		want []string
+	}{
+		{"cpu.pprof", []string{"cpu.pprof"}},
+		{"https://host/debug/pprof/profile?seconds=5", []string{"https://host/debug/pprof/profile?seconds=5"}},
+		{"cache/cpu.pprof | https://ci/cpu.pprof|http://host:6060/debug/pprof/profile", []string{"cache/cpu.pprof", "https://ci/cpu.pprof", "http://host:6060/debug/pprof/profile"}},
+		{"cpu.pprof||", []string{"cpu.pprof"}},
+		{" | ", []string{}},
+	}
+	for _, tc := range cases {
+		if got := profileURICandidates(tc.uri); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("profileURICandidates(%q): expected %q, got %q", tc.uri, tc.want, got)
+		}
+	}
+}
+
+func TestGetProfileFromCandidates(t *testing.T) {
+	dir := t.TempDir()
+	cached := filepath.Join(dir, "cpu.pprof")
+	file, err := os.Create(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := poolTestProfile("main.cached", 3).Write(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	missingArtifact := httptest.NewServer(http.NotFoundHandler())
+	defer missingArtifact.Close()
+
+	handler := sourceReportingHandler(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		prof, err := loadProfile(ctx, request.Params.Arguments["profile_uri"].(string), "")
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(prof.Function[0].Name), nil
+	})
+	call := func(uri string) (*mcp.CallToolResult, error) {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]interface{}{"profile_uri": uri}
+		return handler(context.Background(), request)
+	}
+
+	gone := filepath.Join(dir, "gone.pprof")
+	result, err := call(gone + "|" + missingArtifact.URL + "/cpu.pprof|file://" + cached)
+	if err != nil {
+		t.Fatalf("Expected the third candidate to be used, got: %v", err)
+	}
+	if len(result.Content) != 2 || result.Content[0].(mcp.TextContent).Text != "main.cached" {
+		t.Fatalf("Unexpected result: %+v", result.Content)
+	}
+	sources := result.Content[1].(mcp.TextContent).Text
+	for _, want := range []string{"file://" + cached + " (candidate 3 of 3)", "skipped " + gone + ":", "received status code 404"} {
+		if !strings.Contains(sources, want) {
+			t.Errorf("Expected %q in the sources:\n%s", want, sources)
+		}
+	}
+
+	// A single location is not reported
+	if result, err := call(cached); err != nil || len(result.Content) != 1 {
+		t.Errorf("Expected only the tool output for a single location, got %+v (%v)", result, err)
+	}
+
+	_, err = call(gone + "|" + missingArtifact.URL + "/cpu.pprof")
+	if err == nil || !strings.Contains(err.Error(), "none of the 2 candidate locations") || !strings.Contains(err.Error(), gone) {
+		t.Errorf("Expected an error listing both candidates, got: %v", err)
+	}
+}
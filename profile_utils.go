@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -8,15 +12,33 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
 )
 
 // getProfileAsFile 获取 profile 文件。
+// - 如果输入是以 '|' 分隔的候选位置列表，按顺序尝试，使用第一个可用的位置 (见 getProfileFromCandidates)。
 // - 如果输入不包含 "://", 则视为本地文件路径（相对或绝对）。
 // - 如果是 file:// URI，直接使用其路径。
 // - 如果是 http:// 或 https:// URI，下载到临时文件并返回其路径。
 // 返回最终的文件路径、一个用于清理临时文件的函数（如果创建了临时文件）以及错误。
-func getProfileAsFile(uriStr string) (filePath string, cleanup func(), err error) {
+// 如果提供了 analysisID，文件会被记录到该分析的 manifest 中；下载的临时文件以分析 ID 命名，
+// 并保留到调用 cleanup_analysis 为止 (此时返回的清理函数为空操作)。
+func getProfileAsFile(ctx context.Context, uriStr string, analysisID string) (filePath string, cleanup func(), err error) {
+	if candidates := profileURICandidates(uriStr); len(candidates) != 1 || candidates[0] != uriStr {
+		return getProfileFromCandidates(ctx, uriStr, candidates, analysisID)
+	}
+	return getSingleProfileAsFile(ctx, uriStr, analysisID)
+}
+
+// getSingleProfileAsFile 是 getProfileAsFile 针对单个位置的实现。
+func getSingleProfileAsFile(ctx context.Context, uriStr string, analysisID string) (filePath string, cleanup func(), err error) {
 	cleanup = func() {} // 默认清理函数为空操作
 
 	// 检查输入是否包含协议头，如果没有，则假定为本地文件路径
@@ -27,12 +49,13 @@ func getProfileAsFile(uriStr string) (filePath string, cleanup func(), err error
 			return "", nil, fmt.Errorf("failed to get absolute path for '%s': %w", uriStr, err)
 		}
 		log.Printf("Using absolute local path: %s", absPath)
+		recordInputProfile(analysisID, absPath, uriStr)
 		// 可以在这里添加 os.Stat 检查文件是否存在且可读
 		// _, statErr := os.Stat(absPath)
 		// if statErr != nil {
 		// 	 return "", nil, fmt.Errorf("local file '%s' (resolved to '%s') error: %w", uriStr, absPath, statErr)
 		// }
-		return absPath, cleanup, nil
+		return openProfileFile(absPath, cleanup) // 加密保存的采集文件解密到临时副本
 	}
 
 	// 如果包含 "://", 则按 URI 处理
@@ -48,22 +71,34 @@ func getProfileAsFile(uriStr string) (filePath string, cleanup func(), err error
 			return "", nil, fmt.Errorf("invalid file path derived from URI '%s'", uriStr)
 		}
 		log.Printf("Using local profile file: %s", filePath)
-		return filePath, cleanup, nil
+		recordInputProfile(analysisID, filePath, uriStr)
+		return openProfileFile(filePath, cleanup)
 
 	case "http", "https":
 		log.Printf("Attempting to download profile from URL: %s", uriStr)
-		resp, err := http.Get(uriStr)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uriStr, nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid profile URL '%s': %w", uriStr, err)
+		}
+		// 请求被取消时 (例如内存守卫中止了它) 下载随之停止；超时包含目标采集 profile 所需的时间
+		client := &http.Client{Timeout: downloadTimeout(parsedURI)}
+		resp, err := client.Do(req)
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to download profile from '%s': %w", uriStr, err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
+			// 服务端的错误信息 (例如 "profile duration exceeds server's WriteTimeout") 说明了拒绝的原因
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			if message := strings.TrimSpace(string(body)); message != "" {
+				return "", nil, fmt.Errorf("failed to download profile from '%s': received status code %d: %s", uriStr, resp.StatusCode, message)
+			}
 			return "", nil, fmt.Errorf("failed to download profile from '%s': received status code %d", uriStr, resp.StatusCode)
 		}
 
 		// 创建临时文件来存储下载的内容
-		tempFile, err := os.CreateTemp("", "pprof-*") // 使用通用模式
+		tempFile, err := os.CreateTemp("", analysisTempPattern(analysisID, "profile")) // 有分析 ID 时使用 pprof-<id>-profile-* 命名
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to create temporary file for download: %w", err)
 		}
@@ -71,19 +106,24 @@ func getProfileAsFile(uriStr string) (filePath string, cleanup func(), err error
 		log.Printf("Downloading profile to temporary file: %s", filePath)
 
 		// 定义清理函数，用于删除临时文件
-		cleanup = func() {
+		removeTemp := func() {
 			log.Printf("Cleaning up temporary file: %s", filePath)
 			err := os.Remove(filePath)
 			if err != nil && !os.IsNotExist(err) {
 				log.Printf("Warning: failed to remove temporary file '%s': %v", filePath, err)
 			}
 		}
+		cleanup = removeTemp
+		if analysisID != "" {
+			// 属于某个分析的下载文件保留到 cleanup_analysis，便于按分析查找、打包或统一清理
+			cleanup = func() {}
+		}
 
 		_, err = io.Copy(tempFile, resp.Body)
 		closeErr := tempFile.Close()
 
 		if err != nil {
-			cleanup() // 如果复制失败，尝试清理临时文件
+			removeTemp() // 如果复制失败，尝试清理临时文件
 			return "", nil, fmt.Errorf("failed to write downloaded content to temporary file '%s': %w", filePath, err)
 		}
 		if closeErr != nil {
@@ -91,9 +131,189 @@ func getProfileAsFile(uriStr string) (filePath string, cleanup func(), err error
 		}
 
 		log.Printf("Successfully downloaded profile to %s", filePath)
-		return filePath, cleanup, nil
+		if analysisID != "" {
+			// 保留到 cleanup_analysis 的下载文件在配置了密钥时加密保存，本次调用使用解密的临时副本
+			if err := sealProfileFile(filePath); err != nil {
+				removeTemp()
+				return "", nil, fmt.Errorf("failed to encrypt downloaded profile '%s': %w", filePath, err)
+			}
+		}
+		// 下载完成后再记录，使存储后端 (见 storage.go) 复制的是完整的文件
+		if err := recordAnalysisArtifact(analysisID, AnalysisArtifact{Path: filePath, Kind: "profile", Source: uriStr, Temporary: true}); err != nil {
+			log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+		}
+		return openProfileFile(filePath, cleanup)
 
 	default:
 		return "", nil, fmt.Errorf("unsupported URI scheme '%s', only 'file://', 'http://', 'https://', or a plain local path are supported", parsedURI.Scheme)
 	}
 }
+
+// 下载超时：URL 带有 'seconds' 参数 (目标需要先采集这么久) 时为采集时长加上余量，否则为 defaultDownloadTimeout。
+const (
+	defaultDownloadTimeout = 60 * time.Second
+	downloadTimeoutMargin  = 30 * time.Second
+)
+
+// downloadTimeout 返回下载指定 profile URL 的超时时间。
+func downloadTimeout(u *url.URL) time.Duration {
+	timeout := defaultDownloadTimeout
+	if seconds, err := strconv.Atoi(u.Query().Get("seconds")); err == nil && seconds > 0 {
+		if capture := time.Duration(seconds)*time.Second + downloadTimeoutMargin; capture > timeout {
+			timeout = capture
+		}
+	}
+	return timeout
+}
+
+// recordInputProfile 将调用方提供的本地 profile 记录到分析的 manifest 中 (cleanup_analysis 不会删除它们)。
+func recordInputProfile(analysisID, filePath, uriStr string) {
+	if err := recordAnalysisArtifact(analysisID, AnalysisArtifact{Path: filePath, Kind: "input", Source: uriStr}); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+}
+
+// loadProfile 获取并解析指定 URI 的 profile，临时文件会在解析完成后被清理 (属于某个分析的文件除外)。
+// 相同内容的 profile 只解析一次 (见 parsedProfiles)；返回的 profile 可能被共享，调用方不得修改它，
+// 需要修改时请先调用 Copy()。
+func loadProfile(ctx context.Context, uriStr string, analysisID string) (*profile.Profile, error) {
+	prof, _, err := loadProfileWithKey(ctx, uriStr, analysisID)
+	return prof, err
+}
+
+// loadProfileWithKey 与 loadProfile 相同，并额外返回用于分析缓存的键 (见 analyzer.WithCacheKey)：
+// 文件内容的 SHA256，加上为该 URI 导入的 pprof 配置 (它会在解析后修改 profile)。
+func loadProfileWithKey(ctx context.Context, uriStr string, analysisID string) (*profile.Profile, string, error) {
+	filePath, cleanup, err := getProfileAsFile(ctx, uriStr, analysisID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get profile file: %w", err)
+	}
+	defer cleanup()
+
+	// 请求被取消 (例如内存守卫中止了它) 时不再读取和解析
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Printf("Error opening profile file '%s': %v", filePath, err)
+		return nil, "", fmt.Errorf("failed to open profile file '%s': %w", filePath, err)
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	prof, ok := parsedProfiles.get(digest)
+	if ok {
+		log.Printf("Reusing parsed profile for '%s' (digest %.12s)", filePath, digest)
+	} else {
+		if isPerfData(data) {
+			// perf.data 文件 (Linux 'perf record') 先转换为 pprof 格式；池仍以原文件的摘要为键
+			if data, err = convertPerfData(ctx, filePath); err != nil {
+				return nil, "", err
+			}
+		} else if analyzer.IsDebugText(data) {
+			// debug=1 文本 profile (例如 /debug/pprof/heap?debug=1) 用其 '#' 注释行符号化，完整的 goroutine 转储 (debug=2) 直接解析
+			if data, err = convertDebugText(filePath, data); err != nil {
+				return nil, "", err
+			}
+		} else if analyzer.IsPerfScript(data) {
+			// 'perf script' 的文本输出 (例如 perf.data 无法用 perf_to_profile 转换时) 同样先转换为 pprof 格式
+			if data, err = convertPerfScript(filePath, data); err != nil {
+				return nil, "", err
+			}
+		} else if isJFR(data) {
+			// Java Flight Recorder 录制 (.jfr) 用 JDK 的 jfr 工具导出 CPU 和分配事件后转换
+			if data, err = convertJFR(ctx, filePath); err != nil {
+				return nil, "", err
+			}
+		} else if analyzer.IsJFRJSON(data) {
+			// 已导出的 'jfr print --json' 输出直接转换
+			if data, err = convertJFRJSON(filePath, data); err != nil {
+				return nil, "", err
+			}
+		} else if analyzer.IsFoldedStacks(data) {
+			// Brendan Gregg 格式的 folded stacks (stackcollapse 脚本、eBPF 工具的输出)
+			if data, err = convertFoldedStacks(filePath, data); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		if err := checkMemoryBudget(fmt.Sprintf("parsing '%s'", filePath), estimateParseMemory(data)); err != nil {
+			return nil, "", err
+		}
+		prof, err = profile.ParseData(data)
+		if err != nil {
+			log.Printf("Error parsing profile file '%s': %v", filePath, err)
+			// 崩溃进程留下的截断/损坏文件：尽量恢复损坏点之前的数据，结果中带有醒目的警告
+			recovered, stats, recoverErr := analyzer.RecoverProfile(data)
+			if recoverErr != nil {
+				return nil, "", fmt.Errorf("failed to parse profile file '%s': %w (partial recovery failed: %v)", filePath, err, recoverErr)
+			}
+			log.Printf("Warning: partially recovered profile file '%s' (%d of %d bytes parsed, %d samples dropped)",
+				filePath, stats.ParsedBytes, stats.DecodedBytes, stats.DroppedSamples)
+			prof = recovered
+		} else {
+			log.Printf("Successfully parsed profile file from path: %s", filePath)
+		}
+		parsedProfiles.add(digest, prof)
+	}
+
+	// 池中的 profile 是共享的：应用导入的 pprof 配置前先复制一份
+	fingerprint := pprofConfigFingerprint(uriStr)
+	if fingerprint != "" {
+		prof = prof.Copy()
+		if err := applyPprofConfigDefaults(uriStr, prof); err != nil {
+			return nil, "", err
+		}
+	}
+	return prof, digest + fingerprint, nil
+}
+
+// withRecoveryWarnings puts the warnings of partially recovered input profiles (see analyzer.RecoverProfile)
+// before a tool's result, for tools whose analyzers do not report them themselves.
+func withRecoveryWarnings(result *mcp.CallToolResult, profs ...*profile.Profile) *mcp.CallToolResult {
+	var warnings []mcp.Content
+	for _, p := range profs {
+		if p == nil {
+			continue
+		}
+		if warning := analyzer.RecoveryWarning(p); warning != "" {
+			warnings = append(warnings, mcp.TextContent{Type: "text", Text: warning})
+		}
+	}
+	result.Content = append(warnings, result.Content...)
+	return result
+}
+
+// writeProfileFile writes a profile produced by a tool (gzipped pprof proto) to outputPath, or to a temporary
+// file named after the tool and the analysis when outputPath is empty, and returns the path written. Like
+// the other tools' outputs, it is written next to outputPath and renamed into place, and an existing file is
+// only replaced with overwrite (a *fileExistsError otherwise).
+func writeProfileFile(toolName string, p *profile.Profile, outputPath, analysisID string, overwrite bool) (string, error) {
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return "", fmt.Errorf("failed to serialize the %s output: %w", toolName, err)
+	}
+	// 配置了密钥时与采集的 profile 一样加密保存，其他工具读取时解密 (见 openProfileFile)
+	if outputPath == "" {
+		path, err := writeSealedTemp(analysisTempPattern(analysisID, strings.TrimSuffix(toolName, "_profile"))+".pb.gz", buf.Bytes())
+		if err != nil {
+			return "", fmt.Errorf("failed to write the %s output: %w", toolName, err)
+		}
+		return path, nil
+	}
+	tempPath, err := tempOutputPath(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create the %s output: %w", toolName, err)
+	}
+	if err := writeSealedFile(tempPath, buf.Bytes()); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write the %s output '%s': %w", toolName, tempPath, err)
+	}
+	if err := commitOutputFile(toolName, tempPath, outputPath, overwrite); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// recordFileEnv enables recording: every tool call (arguments, digests of the local input profiles and
+// output) is appended to this file as one JSON line, so that 'replay_analysis' can reproduce it later.
+const recordFileEnv = "PPROF_ANALYZER_RECORD_FILE"
+
+// replayToolName is the name of the replay tool, whose own calls are not recorded.
+const replayToolName = "replay_analysis"
+
+// recordedProfile is a profile argument of a recorded tool call.
+type recordedProfile struct {
+	Argument string `json:"argument"`
+	URI      string `json:"uri"`
+	SHA256   string `json:"sha256,omitempty"` // Empty for remote profiles, which cannot be verified on replay
+}
+
+// recordedContent is one content item of a recorded tool result: the text, or the digest of other content.
+type recordedContent struct {
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// toolCallRecord is one line of the record file.
+type toolCallRecord struct {
+	Time       time.Time              `json:"time"`
+	Tool       string                 `json:"tool"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	Profiles   []recordedProfile      `json:"profiles,omitempty"`
+	DurationMs int64                  `json:"durationMs"`
+	Output     []recordedContent      `json:"output,omitempty"`
+	IsError    bool                   `json:"isError,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// toolHandlers are the handlers registered by addTool, as called by the server but without recording, so
+// that replay_analysis can run recorded calls again.
+var toolHandlers = make(map[string]server.ToolHandlerFunc)
+
+// recordMutex serializes appends to the record file.
+var recordMutex sync.Mutex
+
+// localProfilePath returns the file of a local profile URI ('file://' or a path), or "" for remote ones.
+func localProfilePath(uriStr string) string {
+	if !strings.Contains(uriStr, "://") {
+		return uriStr
+	}
+	if u, err := url.Parse(uriStr); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return ""
+}
+
+// recordProfileArgs lists the profile arguments ('*_uri') of a call with the SHA256 of local files.
+// Files that cannot be read are recorded without a digest; the call itself reports the error.
+func recordProfileArgs(args map[string]interface{}) []recordedProfile {
+	names := make([]string, 0)
+	for name := range args {
+		if strings.HasSuffix(name, "_uri") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	profiles := make([]recordedProfile, 0, len(names))
+	for _, name := range names {
+		uriStr, ok := args[name].(string)
+		if !ok || uriStr == "" {
+			continue
+		}
+		rp := recordedProfile{Argument: name, URI: uriStr}
+		if path := localProfilePath(uriStr); path != "" {
+			if data, err := os.ReadFile(path); err == nil {
+				sum := sha256.Sum256(data)
+				rp.SHA256 = hex.EncodeToString(sum[:])
+			}
+		}
+		profiles = append(profiles, rp)
+	}
+	return profiles
+}
+
+// recordedOutput converts a tool result for the record file.
+func recordedOutput(result *mcp.CallToolResult) []recordedContent {
+	if result == nil {
+		return nil
+	}
+	output := make([]recordedContent, 0, len(result.Content))
+	for _, c := range result.Content {
+		switch content := c.(type) {
+		case mcp.TextContent:
+			output = append(output, recordedContent{Type: "text", Text: content.Text})
+		case mcp.ImageContent:
+			sum := sha256.Sum256([]byte(content.Data))
+			output = append(output, recordedContent{Type: "image", SHA256: hex.EncodeToString(sum[:])})
+		default:
+			data, _ := json.Marshal(c)
+			sum := sha256.Sum256(data)
+			output = append(output, recordedContent{Type: fmt.Sprintf("%T", c), SHA256: hex.EncodeToString(sum[:])})
+		}
+	}
+	return output
+}
+
+// recordedHandler wraps handler to append every call to $PPROF_ANALYZER_RECORD_FILE, when set. The
+// arguments are copied before the call, as sent by the client (handlers normalize them in place).
+func recordedHandler(toolName string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		recordFile := os.Getenv(recordFileEnv)
+		if recordFile == "" || toolName == replayToolName {
+			return handler(ctx, request)
+		}
+		record := toolCallRecord{Time: time.Now().UTC(), Tool: toolName, Arguments: make(map[string]interface{}, len(request.Params.Arguments))}
+		for name, value := range request.Params.Arguments {
+			record.Arguments[name] = value
+		}
+		if headers, ok := record.Arguments["otlp_headers"].(string); ok {
+			record.Arguments["otlp_headers"] = redactOTLPHeaders(headers) // 通常包含认证信息
+		}
+		record.Profiles = recordProfileArgs(record.Arguments)
+
+		result, err := handler(ctx, request)
+		record.DurationMs = time.Since(record.Time).Milliseconds()
+		record.Output = recordedOutput(result)
+		if result != nil {
+			record.IsError = result.IsError
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		if appendErr := appendToolCallRecord(recordFile, record); appendErr != nil {
+			log.Printf("Warning: failed to record %s call to '%s': %v", toolName, recordFile, appendErr)
+		}
+		return result, err
+	}
+}
+
+// appendToolCallRecord appends one record to the record file.
+func appendToolCallRecord(path string, record toolCallRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	recordMutex.Lock()
+	defer recordMutex.Unlock()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readToolCallRecords reads the records of a record file, keeping the calls of analysisID when it is set.
+func readToolCallRecords(path, analysisID string) ([]toolCallRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open record file '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	records := make([]toolCallRecord, 0)
+	decoder := json.NewDecoder(file) // Lines may be long (inline profiles, large outputs)
+	for {
+		var record toolCallRecord
+		if err := decoder.Decode(&record); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid record file '%s' (record %d): %w", path, len(records)+1, err)
+		}
+		if analysisID != "" && record.Arguments["analysis_id"] != analysisID {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// replayedCall is the outcome of replaying one recorded call.
+type replayedCall struct {
+	Index  int    `json:"index"` // 1-based position among the replayed records
+	Tool   string `json:"tool"`
+	Status string `json:"status"` // "identical", "different", "skipped"
+	Detail string `json:"detail,omitempty"`
+}
+
+// replayReport is the result of replay_analysis.
+type replayReport struct {
+	RecordFile string         `json:"recordFile"`
+	AnalysisID string         `json:"analysisId,omitempty"`
+	Identical  int            `json:"identical"`
+	Different  int            `json:"different"`
+	Skipped    int            `json:"skipped"`
+	Calls      []replayedCall `json:"calls"`
+}
+
+// firstDifference describes where two outputs start to differ, e.g. "line 4: "a" (recorded) vs "b" (replayed)".
+func firstDifference(recorded, replayed []recordedContent) string {
+	if len(recorded) != len(replayed) {
+		return fmt.Sprintf("%d content item(s) recorded, %d replayed", len(recorded), len(replayed))
+	}
+	for i := range recorded {
+		if recorded[i] == replayed[i] {
+			continue
+		}
+		if recorded[i].Type != "text" || replayed[i].Type != "text" {
+			return fmt.Sprintf("content item %d (%s) differs", i+1, recorded[i].Type)
+		}
+		oldLines, newLines := strings.Split(recorded[i].Text, "\n"), strings.Split(replayed[i].Text, "\n")
+		for line := 0; line < len(oldLines) || line < len(newLines); line++ {
+			var oldLine, newLine string
+			if line < len(oldLines) {
+				oldLine = oldLines[line]
+			}
+			if line < len(newLines) {
+				newLine = newLines[line]
+			}
+			if oldLine != newLine || line >= len(oldLines) || line >= len(newLines) {
+				return fmt.Sprintf("content item %d, line %d: %q (recorded) vs %q (replayed)", i+1, line+1, oldLine, newLine)
+			}
+		}
+	}
+	return ""
+}
+
+// replayableTools are the tools replay_analysis runs again: read-only analyses, plus import_pprof_config,
+// whose in-memory settings later recorded calls depend on. The others start processes, write or delete
+// files, or capture from live services, which a replay must never repeat on its own.
+var replayableTools = map[string]bool{
+	"analyze_pprof":              true,
+	"detect_memory_leaks":        true,
+	"analyze_pool_effectiveness": true,
+	"detect_leak_patterns":       true,
+	"analyze_db_pool_contention": true,
+	"attribute_costs":            true,
+	"import_pprof_config":        true,
+	"query_profile":              true,
+	"get_flamegraph_subtree":     true,
+	"compare_stack_sets":         true,
+	"is_same_profile":            true,
+	"diff_profiles":              true,
+	"profile_heatmap":            true,
+	"annotate_source":            true,
+	"diff_flamegraph_structure":  true,
+	"analyze_ci_artifacts":       true,
+	"diff_flamegraph":            true,
+	"detect_stuck_goroutines":    true,
+}
+
+// replayToolCall runs one recorded call again. Calls of tools with side effects, and calls whose local input
+// profiles changed since the recording, are skipped.
+func replayToolCall(ctx context.Context, record toolCallRecord) (status, detail string) {
+	handler, ok := toolHandlers[record.Tool]
+	if !ok {
+		return "skipped", fmt.Sprintf("unknown tool '%s'", record.Tool)
+	}
+	if !replayableTools[record.Tool] {
+		return "skipped", "side effects"
+	}
+	if command, ok := record.Arguments[profileCommandArg].(string); ok && command != "" {
+		return "skipped", fmt.Sprintf("the profile came from %s '%s', which a replay does not run again", profileCommandArg, command)
+	}
+	for _, rp := range recordProfileArgs(record.Arguments) {
+		var recorded string
+		for _, old := range record.Profiles {
+			if old.Argument == rp.Argument {
+				recorded = old.SHA256
+			}
+		}
+		if recorded != "" && rp.SHA256 != recorded {
+			if rp.SHA256 == "" {
+				return "skipped", fmt.Sprintf("%s '%s' is no longer readable", rp.Argument, rp.URI)
+			}
+			return "skipped", fmt.Sprintf("%s '%s' changed since the recording (SHA256 %.12s, recorded %.12s)", rp.Argument, rp.URI, rp.SHA256, recorded)
+		}
+	}
+
+	var request mcp.CallToolRequest
+	request.Params.Name = record.Tool
+	request.Params.Arguments = make(map[string]interface{}, len(record.Arguments))
+	for name, value := range record.Arguments {
+		// A recorded approval was given for the original call, not for its replay
+		if name != "confirm" {
+			request.Params.Arguments[name] = value
+		}
+	}
+	result, err := handler(ctx, request)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	if errMsg != record.Error {
+		return "different", fmt.Sprintf("error %q (recorded) vs %q (replayed)", record.Error, errMsg)
+	}
+	if diff := firstDifference(record.Output, recordedOutput(result)); diff != "" {
+		return "different", diff
+	}
+	return "identical", ""
+}
+
+// handleReplayAnalysis runs the tool calls of a record file ($PPROF_ANALYZER_RECORD_FILE) again, in order,
+// and reports whether each one reproduces its recorded output.
+func handleReplayAnalysis(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	recordPath, _ := args["record_path"].(string)
+	if recordPath == "" {
+		recordPath = os.Getenv(recordFileEnv)
+	}
+	if recordPath == "" {
+		return nil, fmt.Errorf("missing argument: record_path (string), and %s is not set", recordFileEnv)
+	}
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+
+	log.Printf("Handling replay_analysis: RecordFile=%s, AnalysisID=%s, Format=%s", recordPath, analysisID, outputFormat)
+
+	records, err := readToolCallRecords(recordPath, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no recorded tool calls in '%s' (analysis_id '%s')", recordPath, analysisID)
+	}
+
+	report := replayReport{RecordFile: recordPath, AnalysisID: analysisID, Calls: make([]replayedCall, 0, len(records))}
+	for i, record := range records {
+		status, detail := replayToolCall(ctx, record)
+		log.Printf("Replayed call %d/%d (%s): %s", i+1, len(records), record.Tool, status)
+		switch status {
+		case "identical":
+			report.Identical++
+		case "different":
+			report.Different++
+		default:
+			report.Skipped++
+		}
+		report.Calls = append(report.Calls, replayedCall{Index: i + 1, Tool: record.Tool, Status: status, Detail: detail})
+	}
+
+	var result string
+	switch outputFormat {
+	case "text":
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("Replayed %d recorded call(s) from %s: %d identical, %d different, %d skipped\n",
+			len(records), recordPath, report.Identical, report.Different, report.Skipped))
+		for _, c := range report.Calls {
+			b.WriteString(fmt.Sprintf("  %d. %s: %s", c.Index, c.Tool, c.Status))
+			if c.Detail != "" {
+				b.WriteString(" (" + c.Detail + ")")
+			}
+			b.WriteString("\n")
+		}
+		result = b.String()
+	case "json":
+		jsonBytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal replay report: %w", err)
+		}
+		result = string(jsonBytes)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: result,
+			},
+		},
+	}, nil
+}
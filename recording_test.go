@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// registerToolHandlers registers handlers for replay_analysis, as addTool does, for the duration of the test.
+func registerToolHandlers(t *testing.T, handlers map[string]server.ToolHandlerFunc) {
+	t.Helper()
+	for name, handler := range handlers {
+		old, ok := toolHandlers[name]
+		toolHandlers[name] = handler
+		t.Cleanup(func() {
+			if ok {
+				toolHandlers[name] = old
+			} else {
+				delete(toolHandlers, name)
+			}
+		})
+	}
+}
+
+// replay runs replay_analysis on a record file and returns its report.
+func replay(t *testing.T, args map[string]interface{}) (replayReport, error) {
+	t.Helper()
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"output_format": "json"}
+	for name, value := range args {
+		request.Params.Arguments[name] = value
+	}
+	result, err := handleReplayAnalysis(context.Background(), request)
+	if err != nil {
+		return replayReport{}, err
+	}
+	var report replayReport
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &report); err != nil {
+		t.Fatalf("Invalid replay report: %v", err)
+	}
+	return report, nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	recordPath := filepath.Join(dir, "record.jsonl")
+	t.Setenv(recordFileEnv, recordPath)
+	profilePath := filepath.Join(dir, "cpu.pprof")
+	writeProfile := func(values ...int64) {
+		file, err := os.Create(profilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := poolTestProfile("main.hot", values...).Write(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeProfile(100, 200)
+
+	// query_profile stands for a tool whose output changes between runs, generate_flamegraph for one with
+	// side effects
+	calls := 0
+	sideEffects := 0
+	handlers := map[string]server.ToolHandlerFunc{
+		"analyze_pprof": handleAnalyzePprof,
+		"query_profile": func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			calls++
+			return mcp.NewToolResultText(fmt.Sprintf("matches\ncall %d", calls)), nil
+		},
+		"generate_flamegraph": func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			sideEffects++
+			return mcp.NewToolResultText("written"), nil
+		},
+	}
+	registerToolHandlers(t, handlers)
+	call := func(tool string, args map[string]interface{}) {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		if _, err := recordedHandler(tool, handlers[tool])(context.Background(), request); err != nil {
+			t.Fatalf("%s failed: %v", tool, err)
+		}
+	}
+	first, second := "replay-"+newArtifactID()[:8], "replay-"+newArtifactID()[:8]
+	t.Cleanup(func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		workspaceStore().Delete(ctx, analysisManifestKey(first))
+		workspaceStore().Delete(ctx, analysisManifestKey(second))
+	})
+	call("analyze_pprof", map[string]interface{}{"profile_uri": profilePath, "profile_type": "cpu", "analysis_id": first, "confirm": true})
+	call("query_profile", map[string]interface{}{"profile_uri": profilePath, "analysis_id": first})
+	call("generate_flamegraph", map[string]interface{}{"profile_uri": profilePath, "analysis_id": second})
+
+	records, err := readToolCallRecords(recordPath, "")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d (%v)", len(records), err)
+	}
+	if r := records[0]; r.Tool != "analyze_pprof" || len(r.Profiles) != 1 || r.Profiles[0].SHA256 == "" || len(r.Output) == 0 || r.Arguments["confirm"] != true {
+		t.Errorf("Unexpected record: %+v", r)
+	}
+
+	report, err := replay(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Identical != 1 || report.Different != 1 || report.Skipped != 1 {
+		t.Fatalf("Expected 1 identical, 1 different and 1 skipped call, got %+v", report)
+	}
+	if c := report.Calls[1]; c.Status != "different" || !strings.Contains(c.Detail, `line 2: "call 1" (recorded) vs "call 2" (replayed)`) {
+		t.Errorf("Expected the first differing line, got %+v", c)
+	}
+	if c := report.Calls[2]; c.Status != "skipped" || c.Detail != "side effects" || sideEffects != 1 {
+		t.Errorf("Expected the call with side effects to be skipped, got %+v (%d runs)", c, sideEffects)
+	}
+	// Replays are not recorded themselves
+	if records, _ := readToolCallRecords(recordPath, ""); len(records) != 3 {
+		t.Errorf("Expected the replay not to be recorded, got %d records", len(records))
+	}
+
+	// Only the calls of an analysis are replayed, and a changed profile is not used
+	writeProfile(100, 300)
+	report, err = replay(t, map[string]interface{}{"analysis_id": first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Calls) != 2 || report.Skipped != 2 || !strings.Contains(report.Calls[0].Detail, "changed since the recording") {
+		t.Errorf("Expected the calls of the changed profile to be skipped, got %+v", report)
+	}
+	if _, err := replay(t, map[string]interface{}{"analysis_id": "unknown"}); err == nil || !strings.Contains(err.Error(), "no recorded tool calls") {
+		t.Errorf("Expected an error for an analysis without records, got %v", err)
+	}
+}
+
+func TestReplayInvalidRecordFile(t *testing.T) {
+	dir := t.TempDir()
+	line, err := json.Marshal(toolCallRecord{Tool: "analyze_pprof", Arguments: map[string]interface{}{"profile_uri": "cpu.pprof"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "Corrupt", content: string(line) + "\nnot json\n", want: "(record 2)"},
+		{name: "Truncated", content: string(line) + "\n" + string(line[:len(line)/2]), want: "(record 2): unexpected EOF"},
+		{name: "WrongType", content: `{"tool": "analyze_pprof", "arguments": "cpu.pprof"}`, want: "(record 1)"},
+	}
+	for _, tc := range cases {
+		path := filepath.Join(dir, tc.name+".jsonl")
+		if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := replay(t, map[string]interface{}{"record_path": path}); err == nil || !strings.Contains(err.Error(), "invalid record file") || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error naming %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	if _, err := replay(t, map[string]interface{}{"record_path": filepath.Join(dir, "missing.jsonl")}); err == nil || !strings.Contains(err.Error(), "failed to open record file") {
+		t.Errorf("Expected an error for a missing record file, got %v", err)
+	}
+	t.Setenv(recordFileEnv, "")
+	if _, err := replay(t, nil); err == nil || !strings.Contains(err.Error(), "missing argument: record_path") {
+		t.Errorf("Expected an error without a record file, got %v", err)
+	}
+}
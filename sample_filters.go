@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// withFocusRegex declares the 'focus_regex' argument of a tool analyzing one profile.
+func withFocusRegex() mcp.ToolOption {
+	return mcp.WithString("focus_regex",
+		mcp.Description("Only keep samples whose stack has a function matching this regular expression, like 'go tool pprof -focus' (e.g. 'myapp/handlers'). A regex that matches no sample is reported as an error."),
+	)
+}
+
+// withIgnoreRegex declares the 'ignore_regex' argument of a tool analyzing one profile.
+func withIgnoreRegex() mcp.ToolOption {
+	return mcp.WithString("ignore_regex",
+		mcp.Description("Drop samples whose stack has a function matching this regular expression, like 'go tool pprof -ignore' (e.g. 'runtime\\.gcBgMarkWorker'). Applied together with 'focus_regex'."),
+	)
+}
+
+// withTagFilter declares the 'tag_filter' argument of a tool analyzing one profile.
+func withTagFilter() mcp.ToolOption {
+	return mcp.WithString("tag_filter",
+		mcp.Description("Only keep samples whose labels (set with pprof.Labels / pprof.Do) match, e.g. 'handler=/api/foo'. Comma-separated 'key=regex' conditions must all hold; 'key!=regex' drops matching samples instead. The regex must match the whole label value; numeric labels match with or without their unit."),
+	)
+}
+
+// withExcludeTestFrames declares the 'exclude_test_frames' argument of a tool analyzing one profile.
+func withExcludeTestFrames() mcp.ToolOption {
+	return mcp.WithBoolean("exclude_test_frames",
+		mcp.Description("Remove the frames of functions in _test.go files and of mock packages (mock, mocks, mock_*, *mock, *mocks, e.g. gomock or testify's mock) from stacks, like 'go tool pprof -hide', so their cost goes to their callers and profiles collected during tests reflect the production code paths."),
+		mcp.DefaultBool(false),
+	)
+}
+
+// withGroupByLabel declares the 'group_by_label' argument of a tool analyzing one profile.
+func withGroupByLabel() mcp.ToolOption {
+	return mcp.WithString("group_by_label",
+		mcp.Description("Break the analysis down by the values of this label key (e.g. 'handler'): the share of each value and its top_n functions by flat value. Samples without the label are grouped under '(no label)'; the labels present in the profile are listed when none carries it. Supports the text, markdown, markdown-compact and json formats."),
+	)
+}
+
+// withMinSampleValue declares the 'min_sample_value' argument of a tool analyzing one profile.
+func withMinSampleValue() mcp.ToolOption {
+	return mcp.WithString("min_sample_value",
+		mcp.Description("Drop samples below this value before aggregation, to cut the noise (and the memory and output size) of gigantic profiles: an absolute value in the unit of the analyzed sample type (e.g. '1000000' for 1ms of CPU time or 1MB of memory) or a percentage of its total (e.g. '0.1%'). How many samples were dropped and how much of the total they weighed is reported."),
+	)
+}
+
+// minSampleValueFromArgs returns the validated 'min_sample_value' argument.
+func minSampleValueFromArgs(args map[string]interface{}) (analyzer.MinSampleValue, error) {
+	value, _ := args["min_sample_value"].(string)
+	return analyzer.ParseMinSampleValue(value)
+}
+
+// sampleFiltersFromArgs returns the validated 'focus_regex', 'ignore_regex', 'tag_filter' and
+// 'exclude_test_frames' arguments.
+func sampleFiltersFromArgs(args map[string]interface{}) (analyzer.Filters, error) {
+	var filters analyzer.Filters
+	filters.Focus, _ = args["focus_regex"].(string)
+	filters.Ignore, _ = args["ignore_regex"].(string)
+	filters.Tags, _ = args["tag_filter"].(string)
+	filters.ExcludeTestFrames, _ = args["exclude_test_frames"].(bool)
+	for _, arg := range []struct{ name, expr string }{{"focus_regex", filters.Focus}, {"ignore_regex", filters.Ignore}} {
+		if _, err := regexp.Compile(arg.expr); err != nil {
+			return analyzer.Filters{}, fmt.Errorf("invalid %s '%s': %w", arg.name, arg.expr, err)
+		}
+	}
+	if _, err := analyzer.ParseTagFilter(filters.Tags); err != nil {
+		return analyzer.Filters{}, fmt.Errorf("invalid tag_filter: %w", err)
+	}
+	return filters, nil
+}
+
+// pprofFilterFlags returns the 'go tool pprof' flags applying the filters.
+func pprofFilterFlags(filters analyzer.Filters) []string {
+	flags := make([]string, 0, 3)
+	if filters.Focus != "" {
+		flags = append(flags, "-focus="+filters.Focus)
+	}
+	if filters.Ignore != "" {
+		flags = append(flags, "-ignore="+filters.Ignore)
+	}
+	if hide := filters.HidePattern(); hide != "" {
+		flags = append(flags, "-hide="+hide)
+	}
+	return flags
+}
+
+// pprofTagFlags returns the 'go tool pprof' flags applying the tag filter: -tagfocus for its "key=regex"
+// condition and -tagignore for its "key!=regex" one. pprof takes only one of each, so ok is false when the
+// filter has more conditions of a kind; numeric labels are compared as numbers by pprof, not as text.
+func pprofTagFlags(filters analyzer.Filters) (flags []string, ok bool) {
+	conditions, err := analyzer.ParseTagFilter(filters.Tags)
+	if err != nil {
+		return nil, false
+	}
+	var focus, ignore []string
+	for _, c := range conditions {
+		condition := c.Key + "=" + c.Value.String() // Value is anchored, like the whole-value match of the filter
+		if c.Negate {
+			ignore = append(ignore, condition)
+		} else {
+			focus = append(focus, condition)
+		}
+	}
+	if len(focus) > 1 || len(ignore) > 1 {
+		return nil, false
+	}
+	flags = make([]string, 0, 2)
+	if len(focus) == 1 {
+		flags = append(flags, "-tagfocus="+focus[0])
+	}
+	if len(ignore) == 1 {
+		flags = append(flags, "-tagignore="+ignore[0])
+	}
+	return flags, true
+}
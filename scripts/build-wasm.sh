@@ -0,0 +1,21 @@
+#!/bin/sh
+# Builds the WebAssembly module of the analyzer core (cmd/pprof-analyzer-wasm) into ./dist, next to the
+# wasm_exec.js loader of the Go version used to build it. Run from the repository root.
+set -eu
+
+DEST="${WASM_DEST:-dist}"
+mkdir -p "$DEST"
+
+GOOS=js GOARCH=wasm go build -trimpath -ldflags="-s -w" -o "$DEST/pprof-analyzer.wasm" ./cmd/pprof-analyzer-wasm
+
+# The loader moved from misc/wasm to lib/wasm in Go 1.24
+root="$(go env GOROOT)"
+for loader in "$root/lib/wasm/wasm_exec.js" "$root/misc/wasm/wasm_exec.js"; do
+	if [ -f "$loader" ]; then
+		cp "$loader" "$DEST/"
+		echo "WebAssembly module and loader written to ${DEST}"
+		exit 0
+	fi
+done
+echo "wasm_exec.js not found in ${root}" >&2
+exit 1
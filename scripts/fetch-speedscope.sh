@@ -0,0 +1,32 @@
+#!/bin/sh
+# Downloads the speedscope release embedded by speedscope_ui.go into ./speedscope.
+# Run from the repository root, usually through 'go generate ./...'.
+set -eu
+
+VERSION="${SPEEDSCOPE_VERSION:-1.22.2}"
+# SPEEDSCOPE_URL can point to a mirror of the release archive
+URL="${SPEEDSCOPE_URL:-https://github.com/jlfwong/speedscope/releases/download/v${VERSION}/speedscope-${VERSION}.zip}"
+DEST="speedscope"
+
+tmp="$(mktemp -d)"
+trap 'rm -rf "$tmp"' EXIT
+
+echo "Downloading speedscope ${VERSION} from ${URL}"
+if command -v curl >/dev/null 2>&1; then
+	curl -fsSL -o "$tmp/speedscope.zip" "$URL"
+else
+	wget -q -O "$tmp/speedscope.zip" "$URL"
+fi
+unzip -q "$tmp/speedscope.zip" -d "$tmp/unpacked"
+
+# The archive has a single top-level directory containing index.html
+index="$(find "$tmp/unpacked" -name index.html | head -n 1)"
+if [ -z "$index" ]; then
+	echo "speedscope ${VERSION}: no index.html in the release archive" >&2
+	exit 1
+fi
+
+# Keep the checked-in README, replace the previous release
+find "$DEST" -mindepth 1 ! -name README.md -exec rm -rf {} +
+cp -R "$(dirname "$index")"/. "$DEST"/
+echo "speedscope ${VERSION} unpacked into ${DEST}"
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// sessionLogCapacity is the maximum number of bytes kept per interactive pprof session.
+// Older output is discarded once the buffer is full.
+const sessionLogCapacity = 64 * 1024
+
+// ringBuffer is a fixed-size, concurrency-safe io.Writer that keeps only the most recent output.
+type ringBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	size    int
+	start   int   // index of the oldest byte
+	length  int   // number of valid bytes in data
+	written int64 // total bytes ever written, used to report truncation
+}
+
+// newRingBuffer creates a ring buffer holding at most size bytes.
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, size), size: size}
+}
+
+// Write implements io.Writer. It never fails; excess data overwrites the oldest bytes.
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	r.written += int64(n)
+	if n >= r.size {
+		// Only the tail of p fits
+		copy(r.data, p[n-r.size:])
+		r.start = 0
+		r.length = r.size
+		return n, nil
+	}
+	for _, c := range p {
+		end := (r.start + r.length) % r.size
+		r.data[end] = c
+		if r.length < r.size {
+			r.length++
+		} else {
+			r.start = (r.start + 1) % r.size
+		}
+	}
+	return n, nil
+}
+
+// String returns the buffered content in write order.
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]byte, 0, r.length)
+	for i := 0; i < r.length; i++ {
+		out = append(out, r.data[(r.start+i)%r.size])
+	}
+	return string(out)
+}
+
+// Truncated reports whether older output has been discarded.
+func (r *ringBuffer) Truncated() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.written > int64(r.length)
+}
+
+// sessionLogRetention is how long the logs of an exited session are kept, so a failed UI can still be inspected.
+const sessionLogRetention = 10 * time.Minute
+
+// maxExitedSessionLogs bounds the logs kept for exited sessions; the oldest are dropped first.
+// Together with sessionLogCapacity this caps the memory used by session logs of exited processes.
+const maxExitedSessionLogs = 16
+
+// sessionLog is the captured output of one pprof session.
+type sessionLog struct {
+	buf      *ringBuffer
+	exited   bool
+	exitedAt time.Time
+	status   string // Exit status, set once the process has exited
+}
+
+// Session log buffers for pprof processes started by this server, keyed by PID.
+// Entries of exited processes are kept for sessionLogRetention so failures can still be inspected.
+var (
+	sessionLogs      = make(map[int]*sessionLog)
+	sessionLogsMutex sync.Mutex
+)
+
+// registerSessionLog stores the log buffer for the given PID.
+func registerSessionLog(pid int, buf *ringBuffer) {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+	sessionLogs[pid] = &sessionLog{buf: buf}
+}
+
+// markSessionExited records the exit status of a session and schedules its logs to be dropped after
+// sessionLogRetention. buf identifies the session, since the PID may be reused by a later one.
+func markSessionExited(pid int, buf *ringBuffer, status string) {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+
+	entry, ok := sessionLogs[pid]
+	if !ok || entry.buf != buf {
+		return
+	}
+	entry.exited = true
+	entry.exitedAt = time.Now()
+	entry.status = status
+	time.AfterFunc(sessionLogRetention, func() {
+		dropSessionLog(pid, buf)
+	})
+
+	// Drop the oldest exited sessions beyond the limit
+	exited := make([]int, 0)
+	for p, e := range sessionLogs {
+		if e.exited {
+			exited = append(exited, p)
+		}
+	}
+	for len(exited) > maxExitedSessionLogs {
+		oldest := 0
+		for i, p := range exited {
+			if sessionLogs[p].exitedAt.Before(sessionLogs[exited[oldest]].exitedAt) {
+				oldest = i
+			}
+		}
+		delete(sessionLogs, exited[oldest])
+		exited = append(exited[:oldest], exited[oldest+1:]...)
+	}
+}
+
+// dropSessionLog removes the logs of the given session, unless the PID now belongs to another session.
+func dropSessionLog(pid int, buf *ringBuffer) {
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+	if entry, ok := sessionLogs[pid]; ok && entry.buf == buf {
+		delete(sessionLogs, pid)
+	}
+}
+
+// handleGetPprofSessionLogs returns the captured stdout/stderr of a background pprof process.
+func handleGetPprofSessionLogs(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	pidFloat, ok := args["pid"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("missing or invalid required argument: pid (number)")
+	}
+	pid := int(pidFloat)
+	if pid <= 0 {
+		return nil, fmt.Errorf("invalid PID: %d", pid)
+	}
+	tailLines := 0
+	if tailFloat, ok := args["tail_lines"].(float64); ok && tailFloat > 0 {
+		tailLines = int(tailFloat)
+	}
+
+	log.Printf("Handling get_pprof_session_logs for PID: %d (tail_lines=%d)", pid, tailLines)
+
+	sessionLogsMutex.Lock()
+	entry, exists := sessionLogs[pid]
+	var buf *ringBuffer
+	status := "running"
+	if exists {
+		buf = entry.buf
+		if entry.exited {
+			status = "exited: " + entry.status
+		}
+	}
+	sessionLogsMutex.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("no captured logs found for PID %d (logs of exited sessions are kept for %s)", pid, sessionLogRetention)
+	}
+
+	output := buf.String()
+	if tailLines > 0 {
+		lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+		if len(lines) > tailLines {
+			lines = lines[len(lines)-tailLines:]
+		}
+		output = strings.Join(lines, "\n")
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("pprof session PID %d (%s)\n", pid, status))
+	if buf.Truncated() {
+		b.WriteString(fmt.Sprintf("Note: output exceeded %d bytes, only the most recent output is shown.\n", sessionLogCapacity))
+	}
+	b.WriteString("--------------------------------------------------\n")
+	if output == "" {
+		b.WriteString("(no output captured yet)\n")
+	} else {
+		b.WriteString(output)
+		if !strings.HasSuffix(output, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(8)
+	if r.String() != "" || r.Truncated() {
+		t.Errorf("Expected an empty buffer, got %q (truncated: %v)", r.String(), r.Truncated())
+	}
+	r.Write([]byte("abc"))
+	r.Write([]byte("defgh"))
+	if r.String() != "abcdefgh" || r.Truncated() {
+		t.Errorf("Expected a full buffer without truncation, got %q (truncated: %v)", r.String(), r.Truncated())
+	}
+
+	// Wraparound: the oldest bytes are overwritten, the rest is kept in write order
+	r.Write([]byte("ij"))
+	if r.String() != "cdefghij" || !r.Truncated() {
+		t.Errorf("Expected the buffer to wrap around, got %q (truncated: %v)", r.String(), r.Truncated())
+	}
+	for i := 0; i < 5; i++ {
+		r.Write([]byte{byte('k' + i)})
+	}
+	if r.String() != "hijklmno" {
+		t.Errorf("Expected the 8 most recent bytes, got %q", r.String())
+	}
+
+	// A write larger than the buffer keeps only its tail, also in a buffer that already wrapped
+	if n, err := r.Write([]byte("0123456789")); n != 10 || err != nil {
+		t.Errorf("Expected the whole write to be accepted, got %d, %v", n, err)
+	}
+	if r.String() != "23456789" {
+		t.Errorf("Expected the tail of the large write, got %q", r.String())
+	}
+	r.Write([]byte("AB"))
+	if r.String() != "456789AB" {
+		t.Errorf("Expected writes after a large write to wrap around, got %q", r.String())
+	}
+}
+
+func TestRingBufferConcurrentWrites(t *testing.T) {
+	r := newRingBuffer(1024)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				fmt.Fprintf(r, "writer %d line %03d\n", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// The buffer never grows beyond its size, and writes are not interleaved within a line
+	output := r.String()
+	if len(output) != 1024 || !r.Truncated() {
+		t.Fatalf("Expected 1024 bytes after truncation, got %d (truncated: %v)", len(output), r.Truncated())
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	for _, line := range lines[1:] {
+		var writer, n int
+		if _, err := fmt.Sscanf(line, "writer %d line %d", &writer, &n); err != nil {
+			t.Errorf("Expected complete lines, got %q", line)
+		}
+	}
+}
+
+// testSessionPID returns a PID that no real process uses, for sessions registered by tests.
+func testSessionPID(t *testing.T, i int) int {
+	pid := 1<<30 + i
+	t.Cleanup(func() {
+		sessionLogsMutex.Lock()
+		delete(sessionLogs, pid)
+		sessionLogsMutex.Unlock()
+	})
+	return pid
+}
+
+func sessionLogsText(t *testing.T, pid int, tailLines int) (string, error) {
+	t.Helper()
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"pid": float64(pid), "tail_lines": float64(tailLines)}
+	result, err := handleGetPprofSessionLogs(context.Background(), request)
+	if err != nil {
+		return "", err
+	}
+	return result.Content[0].(mcp.TextContent).Text, nil
+}
+
+func TestSessionLogs(t *testing.T) {
+	first, second := testSessionPID(t, 1), testSessionPID(t, 2)
+	firstBuf, secondBuf := newRingBuffer(sessionLogCapacity), newRingBuffer(sessionLogCapacity)
+	registerSessionLog(first, firstBuf)
+	registerSessionLog(second, secondBuf)
+	firstBuf.Write([]byte("Serving web UI on http://localhost:8081\nline 2\nline 3\n"))
+	secondBuf.Write([]byte("failed to open profile\n"))
+
+	// Each session only shows its own output
+	text, err := sessionLogsText(t, first, 0)
+	if err != nil || !strings.Contains(text, "(running)") || !strings.Contains(text, "Serving web UI") || strings.Contains(text, "failed to open") {
+		t.Errorf("Unexpected logs of the first session (%v):\n%s", err, text)
+	}
+	text, err = sessionLogsText(t, second, 0)
+	if err != nil || !strings.Contains(text, "failed to open profile") || strings.Contains(text, "Serving web UI") {
+		t.Errorf("Unexpected logs of the second session (%v):\n%s", err, text)
+	}
+	if text, _ := sessionLogsText(t, first, 2); strings.Contains(text, "Serving web UI") || !strings.HasSuffix(text, "line 2\nline 3\n") {
+		t.Errorf("Expected the last 2 lines, got:\n%s", text)
+	}
+
+	// The output is bounded per session
+	for i := 0; i < 2*sessionLogCapacity/16; i++ {
+		secondBuf.Write([]byte("0123456789abcde\n"))
+	}
+	if text, _ := sessionLogsText(t, second, 0); !strings.Contains(text, fmt.Sprintf("output exceeded %d bytes", sessionLogCapacity)) || strings.Contains(text, "failed to open") {
+		t.Errorf("Expected truncated logs, got %d bytes", len(text))
+	}
+	if text, _ := sessionLogsText(t, first, 0); strings.Contains(text, "output exceeded") {
+		t.Errorf("Expected the first session not to be truncated:\n%s", text)
+	}
+
+	// An exited session keeps its logs with its exit status; a stale buffer for a reused PID is ignored
+	markSessionExited(first, newRingBuffer(8), "exit status 2")
+	if text, _ := sessionLogsText(t, first, 0); !strings.Contains(text, "(running)") {
+		t.Errorf("Expected a stale exit to be ignored:\n%s", text)
+	}
+	markSessionExited(first, firstBuf, "exit status 1")
+	if text, _ := sessionLogsText(t, first, 0); !strings.Contains(text, "(exited: exit status 1)") || !strings.Contains(text, "line 3") {
+		t.Errorf("Expected the exit status with the logs:\n%s", text)
+	}
+	dropSessionLog(first, newRingBuffer(8))
+	if _, err := sessionLogsText(t, first, 0); err != nil {
+		t.Errorf("Expected the logs to be kept when dropping another session's buffer, got %v", err)
+	}
+	dropSessionLog(first, firstBuf)
+	if _, err := sessionLogsText(t, first, 0); err == nil || !strings.Contains(err.Error(), "no captured logs found") {
+		t.Errorf("Expected the dropped logs to be gone, got %v", err)
+	}
+}
+
+func TestExitedSessionLogsBound(t *testing.T) {
+	var pids []int
+	var bufs []*ringBuffer
+	for i := 0; i < maxExitedSessionLogs+3; i++ {
+		pid, buf := testSessionPID(t, 100+i), newRingBuffer(64)
+		registerSessionLog(pid, buf)
+		pids, bufs = append(pids, pid), append(bufs, buf)
+	}
+	running := testSessionPID(t, 99)
+	registerSessionLog(running, newRingBuffer(64))
+	for i, pid := range pids {
+		markSessionExited(pid, bufs[i], "exit status 0")
+		time.Sleep(time.Millisecond) // Distinct exit times
+	}
+
+	// Only the most recently exited sessions are kept, and running sessions are never dropped
+	sessionLogsMutex.Lock()
+	defer sessionLogsMutex.Unlock()
+	for i, pid := range pids {
+		if _, kept := sessionLogs[pid]; kept != (i >= 3) {
+			t.Errorf("Session %d exited as #%d: expected kept=%v", pid, i, i >= 3)
+		}
+	}
+	if _, ok := sessionLogs[running]; !ok {
+		t.Error("Expected the running session's logs to be kept")
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// defaultSnapshotIntervalMinutes is the default interval of start_snapshot_schedule.
+	defaultSnapshotIntervalMinutes = 15
+	// defaultSnapshotTypes are the profiles captured by default: heap and goroutine snapshots show leaks over time.
+	defaultSnapshotTypes = "heap,goroutine"
+	// snapshotsDirName is the directory of the workspace schedules save their snapshots under by default.
+	snapshotsDirName = "pprof-snapshots"
+	// snapshotRecentFiles is the number of recent snapshot files listed by list_snapshot_schedules.
+	snapshotRecentFiles = 5
+	// defaultMaxSnapshots is the default number of snapshot files a schedule keeps before pruning the oldest.
+	defaultMaxSnapshots = 200
+	// maxSnapshotSchedules limits the schedules running at once.
+	maxSnapshotSchedules = 10
+	// snapshotStopTimeout is how long stopping the schedules on exit waits for captures in progress.
+	snapshotStopTimeout = 5 * time.Second
+)
+
+// snapshotTypes are the profile types a schedule can capture: the snapshots of net/http/pprof, which
+// return at once (CPU profiles and traces take 'seconds' to capture; use capture_profile for them).
+var snapshotTypes = map[string]bool{"heap": true, "allocs": true, "goroutine": true, "mutex": true, "block": true, "threadcreate": true}
+
+// snapshotSchedule captures profiles from a target periodically, until it is stopped.
+type snapshotSchedule struct {
+	ID           string
+	BaseURL      string
+	ProfileTypes []string
+	Interval     time.Duration
+	Dir          string // The schedule's own directory
+	MaxSnapshots int    // Snapshot files kept; the oldest are pruned beyond it
+	AnalysisID   string
+	StartedAt    time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the capture loop has returned
+
+	mu          sync.Mutex // Protects the fields below, updated by the capture loop
+	Captures    int        // Snapshots saved
+	Failures    int
+	LastCapture time.Time
+	LastError   string
+	Files       []string // The last snapshotRecentFiles saved
+	Pruned      int      // Snapshots removed to keep MaxSnapshots
+	saved       []string // The snapshot files kept, oldest first
+}
+
+var (
+	snapshotSchedules      = make(map[string]*snapshotSchedule) // Running schedules by ID
+	snapshotSchedulesMutex sync.Mutex
+)
+
+// parseSnapshotTypes splits the 'profile_types' argument on commas or whitespace and validates it.
+func parseSnapshotTypes(spec string) ([]string, error) {
+	types := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, t := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' }) {
+		if !snapshotTypes[t] {
+			return nil, fmt.Errorf("unsupported profile type '%s' in profile_types (supported: allocs, block, goroutine, heap, mutex, threadcreate)", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("missing or invalid argument: profile_types (string)")
+	}
+	return types, nil
+}
+
+// captureSnapshots captures every profile type of the schedule once, recording the saved files.
+func (s *snapshotSchedule) captureSnapshots(ctx context.Context) {
+	for _, profileType := range s.ProfileTypes {
+		captureURL, err := captureProfileURL(s.BaseURL, profileType, 0, 0)
+		var path string
+		if err == nil {
+			path, _, _, err = captureToFile(ctx, captureURL, profileType, s.Dir)
+		}
+		if ctx.Err() != nil {
+			return // Stopped during the capture
+		}
+		s.mu.Lock()
+		if err != nil {
+			log.Printf("Warning: snapshot schedule '%s' failed to capture %s: %v", s.ID, profileType, err)
+			s.Failures++
+			s.LastError = fmt.Sprintf("%s: %s: %v", time.Now().UTC().Format(time.RFC3339), profileType, err)
+		} else {
+			s.Captures++
+			s.LastCapture = time.Now()
+			s.Files = append(s.Files, path)
+			s.saved = append(s.saved, path)
+			if len(s.Files) > snapshotRecentFiles {
+				s.Files = s.Files[len(s.Files)-snapshotRecentFiles:]
+			}
+		}
+		s.mu.Unlock()
+		if err != nil {
+			continue
+		}
+		// 记录到分析中的快照可以直接传给 profile_heatmap 等工具；以生成工具标注，热力图以 (带时间的) 文件路径区分各列
+		artifact := AnalysisArtifact{Path: path, Kind: "profile", Source: "start_snapshot_schedule"}
+		if err := recordAnalysisArtifact(s.AnalysisID, artifact); err != nil {
+			log.Printf("Warning: failed to record artifact for analysis '%s': %v", s.AnalysisID, err)
+		}
+		runPostProcessHook(ctx, s.AnalysisID, artifact)
+	}
+}
+
+// pruneSnapshots removes the oldest snapshots beyond MaxSnapshots, and forgets them in the schedule's analysis.
+func (s *snapshotSchedule) pruneSnapshots() {
+	s.mu.Lock()
+	var pruned []string
+	if excess := len(s.saved) - s.MaxSnapshots; excess > 0 {
+		pruned = append(pruned, s.saved[:excess]...)
+		s.saved = s.saved[excess:]
+	}
+	s.mu.Unlock()
+	if len(pruned) == 0 {
+		return
+	}
+
+	removed := make([]string, 0, len(pruned))
+	for _, path := range pruned {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: snapshot schedule '%s' failed to prune %s: %v", s.ID, path, err)
+			continue
+		}
+		removed = append(removed, path)
+	}
+	s.mu.Lock()
+	s.Pruned += len(removed)
+	s.mu.Unlock()
+	if err := forgetAnalysisArtifacts(s.AnalysisID, removed); err != nil {
+		log.Printf("Warning: failed to forget pruned snapshots in analysis '%s': %v", s.AnalysisID, err)
+	}
+}
+
+// run captures snapshots right away, then every interval, until ctx is canceled.
+func (s *snapshotSchedule) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.captureSnapshots(ctx)
+		s.pruneSnapshots()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// status returns a copy of the schedule's state for reporting.
+func (s *snapshotSchedule) status() snapshotScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := snapshotScheduleStatus{
+		ID:              s.ID,
+		BaseURL:         s.BaseURL,
+		ProfileTypes:    s.ProfileTypes,
+		IntervalMinutes: s.Interval.Minutes(),
+		Dir:             s.Dir,
+		MaxSnapshots:    s.MaxSnapshots,
+		AnalysisID:      s.AnalysisID,
+		StartedAt:       s.StartedAt.UTC().Format(time.RFC3339),
+		Captures:        s.Captures,
+		Failures:        s.Failures,
+		LastError:       s.LastError,
+		Pruned:          s.Pruned,
+		RecentFiles:     append([]string(nil), s.Files...),
+	}
+	if !s.LastCapture.IsZero() {
+		status.LastCapture = s.LastCapture.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// snapshotScheduleStatus is the state of a schedule as reported by the schedule tools.
+type snapshotScheduleStatus struct {
+	ID              string   `json:"id"`
+	BaseURL         string   `json:"baseUrl"`
+	ProfileTypes    []string `json:"profileTypes"`
+	IntervalMinutes float64  `json:"intervalMinutes"`
+	Dir             string   `json:"dir"`
+	MaxSnapshots    int      `json:"maxSnapshots"`
+	AnalysisID      string   `json:"analysisId,omitempty"`
+	StartedAt       string   `json:"startedAt"`
+	Captures        int      `json:"captures"`
+	Failures        int      `json:"failures"`
+	LastCapture     string   `json:"lastCapture,omitempty"`
+	LastError       string   `json:"lastError,omitempty"`
+	Pruned          int      `json:"pruned"`
+	RecentFiles     []string `json:"recentFiles"`
+}
+
+// formatSnapshotSchedule describes a schedule for the text output of the schedule tools.
+func formatSnapshotSchedule(b *strings.Builder, s snapshotScheduleStatus) {
+	b.WriteString(fmt.Sprintf("Schedule %s: %s from %s every %s min\n", s.ID, strings.Join(s.ProfileTypes, ", "), s.BaseURL, formatSnapshotInterval(s.IntervalMinutes)))
+	b.WriteString(fmt.Sprintf("  Directory: %s (keeping the last %d snapshots, %d pruned)\n", s.Dir, s.MaxSnapshots, s.Pruned))
+	if s.AnalysisID != "" {
+		b.WriteString(fmt.Sprintf("  Analysis: %s\n", s.AnalysisID))
+	}
+	b.WriteString(fmt.Sprintf("  Started: %s, %d snapshot(s) saved, %d failed\n", s.StartedAt, s.Captures, s.Failures))
+	if s.LastCapture != "" {
+		b.WriteString(fmt.Sprintf("  Last snapshot: %s\n", s.LastCapture))
+	}
+	if s.LastError != "" {
+		b.WriteString(fmt.Sprintf("  Last error: %s\n", s.LastError))
+	}
+	for _, file := range s.RecentFiles {
+		b.WriteString(fmt.Sprintf("    %s\n", file))
+	}
+}
+
+// formatSnapshotInterval formats an interval in minutes without a trailing ".0".
+func formatSnapshotInterval(minutes float64) string {
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", minutes), ".0")
+}
+
+// handleStartSnapshotSchedule starts capturing heap, goroutine or other snapshot profiles from a target every
+// interval_minutes into a directory of the workspace, the basis for trend and leak analysis over time.
+func handleStartSnapshotSchedule(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	baseURL, ok := args["base_url"].(string)
+	if !ok || baseURL == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: base_url (string)")
+	}
+	typesStr, ok := args["profile_types"].(string)
+	if !ok || typesStr == "" {
+		typesStr = defaultSnapshotTypes
+	}
+	profileTypes, err := parseSnapshotTypes(typesStr)
+	if err != nil {
+		return nil, err
+	}
+	intervalFloat, ok := args["interval_minutes"].(float64)
+	if !ok {
+		intervalFloat = defaultSnapshotIntervalMinutes
+	}
+	if intervalFloat < 1 {
+		return nil, fmt.Errorf("invalid interval_minutes %g: must be at least 1", intervalFloat)
+	}
+	interval := time.Duration(intervalFloat * float64(time.Minute))
+	maxSnapshots := defaultMaxSnapshots
+	if maxFloat, ok := args["max_snapshots"].(float64); ok {
+		if maxFloat < 1 {
+			return nil, fmt.Errorf("invalid max_snapshots %g: must be at least 1", maxFloat)
+		}
+		maxSnapshots = int(maxFloat)
+	}
+	// 校验目标地址，避免启动一个每次都会失败的计划
+	for _, profileType := range profileTypes {
+		if _, err := captureProfileURL(baseURL, profileType, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	id := newArtifactID()[:8]
+	outputDir, _ := args["output_dir"].(string)
+	if outputDir == "" {
+		outputDir = filepath.Join(workspaceDirs()[0], snapshotsDirName)
+	} else if !filepath.IsAbs(outputDir) {
+		if cwd, err := os.Getwd(); err == nil {
+			outputDir = filepath.Join(cwd, outputDir)
+		}
+	}
+
+	log.Printf("Handling start_snapshot_schedule: BaseURL=%s, Types=%v, Interval=%s, MaxSnapshots=%d, OutputDir=%s", baseURL, profileTypes, interval, maxSnapshots, outputDir)
+	// 按父目录确认：每个计划的子目录以随机 ID 命名
+	if confirmErr := confirmWrite("start_snapshot_schedule", args, outputDir); confirmErr != nil {
+		return confirmErr.toolResult(), nil
+	}
+	dir := filepath.Join(outputDir, id)
+
+	// 计划在调用返回后继续运行，因此不使用请求的 context
+	runCtx, cancel := context.WithCancel(context.Background())
+	schedule := &snapshotSchedule{
+		ID:           id,
+		BaseURL:      baseURL,
+		ProfileTypes: profileTypes,
+		Interval:     interval,
+		Dir:          dir,
+		MaxSnapshots: maxSnapshots,
+		AnalysisID:   analysisID,
+		StartedAt:    time.Now(),
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	snapshotSchedulesMutex.Lock()
+	if len(snapshotSchedules) >= maxSnapshotSchedules {
+		snapshotSchedulesMutex.Unlock()
+		cancel()
+		return nil, fmt.Errorf("too many snapshot schedules running (at most %d): stop one with stop_snapshot_schedule first", maxSnapshotSchedules)
+	}
+	snapshotSchedules[id] = schedule
+	snapshotSchedulesMutex.Unlock()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		snapshotSchedulesMutex.Lock()
+		delete(snapshotSchedules, id)
+		snapshotSchedulesMutex.Unlock()
+		cancel()
+		return nil, fmt.Errorf("failed to create the snapshot directory '%s': %w", dir, err)
+	}
+	go schedule.run(runCtx)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Started snapshot schedule %s: %s from %s every %s min, the first now.\n",
+		id, strings.Join(profileTypes, ", "), baseURL, formatSnapshotInterval(intervalFloat)))
+	b.WriteString(fmt.Sprintf("Snapshots are saved in: %s (the last %d are kept, older ones are pruned)\n", dir, maxSnapshots))
+	if analysisID != "" {
+		b.WriteString(fmt.Sprintf("They are recorded in analysis '%s', e.g. for profile_heatmap.\n", analysisID))
+	}
+	b.WriteString(fmt.Sprintf("Check it with list_snapshot_schedules and stop it with stop_snapshot_schedule (schedule_id '%s').\n", id))
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, nil
+}
+
+// handleStopSnapshotSchedule stops a schedule started by start_snapshot_schedule. Its snapshots are kept.
+func handleStopSnapshotSchedule(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	id, ok := args["schedule_id"].(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: schedule_id (string)")
+	}
+	log.Printf("Handling stop_snapshot_schedule: ID=%s", id)
+
+	snapshotSchedulesMutex.Lock()
+	schedule, exists := snapshotSchedules[id]
+	delete(snapshotSchedules, id)
+	snapshotSchedulesMutex.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("no running snapshot schedule with ID '%s'", id)
+	}
+	schedule.cancel()
+	// 等待进行中的采集结束，使报告的快照列表是最终的
+	select {
+	case <-schedule.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var b strings.Builder
+	b.WriteString("Stopped. The snapshots were kept.\n")
+	formatSnapshotSchedule(&b, schedule.status())
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, nil
+}
+
+// stopSnapshotSchedules stops every running schedule, e.g. when the server exits, waiting up to timeout for
+// the captures in progress. It returns the number of schedules stopped.
+func stopSnapshotSchedules(timeout time.Duration) int {
+	snapshotSchedulesMutex.Lock()
+	schedules := make([]*snapshotSchedule, 0, len(snapshotSchedules))
+	for id, schedule := range snapshotSchedules {
+		schedules = append(schedules, schedule)
+		delete(snapshotSchedules, id)
+	}
+	snapshotSchedulesMutex.Unlock()
+
+	for _, schedule := range schedules {
+		schedule.cancel()
+	}
+	deadline := time.After(timeout)
+	for _, schedule := range schedules {
+		select {
+		case <-schedule.done:
+		case <-deadline:
+			log.Printf("Warning: timed out waiting for snapshot schedule '%s' to stop", schedule.ID)
+			return len(schedules)
+		}
+	}
+	return len(schedules)
+}
+
+// handleListSnapshotSchedules lists the running schedules with their progress and most recent snapshots.
+func handleListSnapshotSchedules(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	outputFormat, ok := args["output_format"].(string)
+	if !ok || outputFormat == "" {
+		outputFormat = "text"
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return nil, fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+	log.Printf("Handling list_snapshot_schedules: Format=%s", outputFormat)
+
+	snapshotSchedulesMutex.Lock()
+	statuses := make([]snapshotScheduleStatus, 0, len(snapshotSchedules))
+	for _, schedule := range snapshotSchedules {
+		statuses = append(statuses, schedule.status())
+	}
+	snapshotSchedulesMutex.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].StartedAt != statuses[j].StartedAt {
+			return statuses[i].StartedAt < statuses[j].StartedAt
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	var text string
+	if outputFormat == "json" {
+		data, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal snapshot schedules to JSON: %w", err)
+		}
+		text = string(data)
+	} else if len(statuses) == 0 {
+		text = "No snapshot schedules are running.\n"
+	} else {
+		var b strings.Builder
+		for i, status := range statuses {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			formatSnapshotSchedule(&b, status)
+		}
+		text = b.String()
+	}
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}, nil
+}
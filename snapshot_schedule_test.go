@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestParseSnapshotTypes(t *testing.T) {
+	types, err := parseSnapshotTypes("heap, goroutine,heap")
+	if err != nil || strings.Join(types, ",") != "heap,goroutine" {
+		t.Errorf("Expected [heap goroutine], got %v (%v)", types, err)
+	}
+	if _, err := parseSnapshotTypes("heap,cpu"); err == nil {
+		t.Error("Expected an error for cpu, which is not a snapshot")
+	}
+}
+
+func TestSnapshotSchedule(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := poolTestProfile("main.leak", 100).Write(w); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer target.Close()
+	t.Setenv(workspaceEnv, t.TempDir())
+
+	call := func(handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]interface{}) string {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		result, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("Schedule tool failed: %v", err)
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+	list := func() []snapshotScheduleStatus {
+		t.Helper()
+		var statuses []snapshotScheduleStatus
+		if err := json.Unmarshal([]byte(call(handleListSnapshotSchedules, map[string]interface{}{"output_format": "json"})), &statuses); err != nil {
+			t.Fatal(err)
+		}
+		return statuses
+	}
+
+	text := call(handleStartSnapshotSchedule, map[string]interface{}{"base_url": target.URL, "interval_minutes": 60.0})
+	if !strings.Contains(text, "Started snapshot schedule") {
+		t.Fatalf("Unexpected start result:\n%s", text)
+	}
+	// The first heap and goroutine snapshots are captured right away
+	var status snapshotScheduleStatus
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		statuses := list()
+		if len(statuses) != 1 {
+			t.Fatalf("Expected one running schedule, got %+v", statuses)
+		}
+		if status = statuses[0]; status.Captures == 2 {
+			break
+		}
+	}
+	if status.Captures != 2 || status.Failures != 0 || len(status.RecentFiles) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %+v", status)
+	}
+
+	text = call(handleStopSnapshotSchedule, map[string]interface{}{"schedule_id": status.ID})
+	if !strings.Contains(text, "Stopped") {
+		t.Errorf("Unexpected stop result:\n%s", text)
+	}
+	for _, file := range status.RecentFiles {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected the snapshot %s to be kept: %v", file, err)
+		}
+	}
+	if statuses := list(); len(statuses) != 0 {
+		t.Errorf("Expected no running schedule after stopping it, got %+v", statuses)
+	}
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"schedule_id": status.ID}
+	if _, err := handleStopSnapshotSchedule(context.Background(), request); err == nil {
+		t.Error("Expected an error when stopping a schedule twice")
+	}
+}
+
+func TestSnapshotSchedulePruning(t *testing.T) {
+	t.Setenv(workspaceEnv, t.TempDir())
+	dir := t.TempDir()
+	// Manifests are kept in the shared temporary directory: use an analysis of its own
+	schedule := &snapshotSchedule{ID: "prune", Dir: dir, MaxSnapshots: 3, AnalysisID: "prune-" + newArtifactID()[:8]}
+	t.Cleanup(func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		workspaceStore().Delete(ctx, analysisManifestKey(schedule.AnalysisID))
+	})
+	var files []string
+	for i := 0; i < 5; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("heap-%d.pb.gz", i))
+		if err := os.WriteFile(path, []byte("snapshot"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := recordAnalysisArtifact(schedule.AnalysisID, AnalysisArtifact{Path: path, Kind: "profile", Source: "start_snapshot_schedule"}); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	schedule.saved = append([]string(nil), files...)
+
+	schedule.pruneSnapshots()
+	for i, path := range files {
+		_, err := os.Stat(path)
+		if kept := err == nil; kept != (i >= 2) {
+			t.Errorf("Expected only the 3 newest snapshots to be kept, %s kept: %v", path, kept)
+		}
+	}
+	if status := schedule.status(); status.Pruned != 2 || status.MaxSnapshots != 3 {
+		t.Errorf("Expected 2 pruned snapshots, got %+v", status)
+	}
+	manifest, err := readAnalysisManifest(schedule.AnalysisID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Artifacts) != 3 || manifest.Artifacts[0].Path != files[2] {
+		t.Errorf("Expected the pruned snapshots to be removed from the analysis, got %+v", manifest.Artifacts)
+	}
+
+	// Within the limit, nothing is pruned
+	schedule.pruneSnapshots()
+	if schedule.status().Pruned != 2 || len(schedule.saved) != 3 {
+		t.Errorf("Expected no more pruning, got %+v", schedule.status())
+	}
+}
+
+func TestSnapshotScheduleLimit(t *testing.T) {
+	t.Setenv(workspaceEnv, t.TempDir())
+	snapshotSchedulesMutex.Lock()
+	for i := 0; i < maxSnapshotSchedules; i++ {
+		id := fmt.Sprintf("full%d", i)
+		snapshotSchedules[id] = &snapshotSchedule{ID: id, cancel: func() {}, done: make(chan struct{})}
+	}
+	snapshotSchedulesMutex.Unlock()
+
+	var request mcp.CallToolRequest
+	request.Params.Arguments = map[string]interface{}{"base_url": "localhost:6060"}
+	if _, err := handleStartSnapshotSchedule(context.Background(), request); err == nil || !strings.Contains(err.Error(), "too many snapshot schedules") {
+		t.Errorf("Expected the schedule limit to be enforced, got %v", err)
+	}
+	// Schedules that do not stop in time do not block the server from exiting
+	if stopped := stopSnapshotSchedules(10 * time.Millisecond); stopped != maxSnapshotSchedules {
+		t.Errorf("Expected %d schedules to be stopped, got %d", maxSnapshotSchedules, stopped)
+	}
+	if len(snapshotSchedules) != 0 {
+		t.Errorf("Expected no schedules left, got %d", len(snapshotSchedules))
+	}
+}
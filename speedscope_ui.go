@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// serveSpeedscopeCommand is the hidden subcommand used to run the speedscope UI server
+// as a separate process, so it can be managed exactly like a 'go tool pprof' session.
+const serveSpeedscopeCommand = "__serve-speedscope"
+
+// speedscopeBundleEnv points to a directory containing an unpacked speedscope release.
+// It overrides the embedded bundle (see speedscopeBundle).
+const speedscopeBundleEnv = "PPROF_ANALYZER_SPEEDSCOPE_DIR"
+
+// speedscopeBundle is the speedscope release embedded in the binary. The directory only holds a README in
+// the repository; 'go generate' (scripts/fetch-speedscope.sh) downloads the pinned release into it before
+// the build. Without it, the hosted app at speedscopeHostedOrigin is used.
+//
+//go:generate sh scripts/fetch-speedscope.sh
+//go:embed all:speedscope
+var speedscopeBundle embed.FS
+
+// speedscopeHostedOrigin is the origin of the hosted speedscope app, the only origin allowed to fetch
+// the profile cross-origin when no local bundle is available.
+const speedscopeHostedOrigin = "https://www.speedscope.app"
+
+// speedscopeProfileName is the file name the converted profile is served as, below a random URL token.
+const speedscopeProfileName = "profile.speedscope.json"
+
+// buildSpeedscopeCommand converts the profile at inputFilePath into speedscope JSON and returns
+// a command that serves it on httpAddress, along with the path of the converted file.
+func buildSpeedscopeCommand(inputFilePath, httpAddress string, openBrowser bool) (*exec.Cmd, string, error) {
+	file, err := os.Open(inputFilePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open profile file '%s': %w", inputFilePath, err)
+	}
+	defer file.Close()
+
+	prof, err := profile.Parse(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse profile file '%s': %w", inputFilePath, err)
+	}
+
+	speedscope, err := analyzer.BuildSpeedscopeProfile(prof, analyzer.DefaultSampleIndex(prof), "pprof")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to convert profile to speedscope format: %w", err)
+	}
+
+	jsonFile, err := os.CreateTemp("", "pprof-speedscope-*.json")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temporary file for speedscope profile: %w", err)
+	}
+	encodeErr := json.NewEncoder(jsonFile).Encode(speedscope)
+	closeErr := jsonFile.Close()
+	if encodeErr != nil || closeErr != nil {
+		os.Remove(jsonFile.Name())
+		return nil, "", fmt.Errorf("failed to write speedscope profile: %v %v", encodeErr, closeErr)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		os.Remove(jsonFile.Name())
+		return nil, "", fmt.Errorf("failed to locate server executable: %w", err)
+	}
+
+	cmd := exec.Command(self, serveSpeedscopeCommand, jsonFile.Name(), httpAddress, fmt.Sprintf("%t", openBrowser))
+	return cmd, jsonFile.Name(), nil
+}
+
+// runSpeedscopeServer is the entry point of the speedscope UI subprocess.
+// args: <speedscope json path> <http address> <open browser: true|false>
+func runSpeedscopeServer(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: %s <profile.json> <http_address> <open_browser>", serveSpeedscopeCommand)
+	}
+	jsonPath, httpAddress, openBrowser := args[0], args[1], args[2] == "true"
+	defer os.Remove(jsonPath)
+
+	// disconnect_pprof_session sends Interrupt; remove the converted profile before exiting
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		os.Remove(jsonPath)
+		os.Exit(0)
+	}()
+
+	listener, err := net.Listen("tcp", httpAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", httpAddress, err)
+	}
+	baseURL := pprofUIURL(listener.Addr().String())
+
+	// The profile is only served below a random token, so other pages cannot guess its URL
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("failed to generate profile URL token: %w", err)
+	}
+	profilePath := "/" + hex.EncodeToString(token) + "/" + speedscopeProfileName
+	profileURL := baseURL + profilePath[1:]
+
+	bundle, bundleName := localSpeedscopeBundle()
+	mux := http.NewServeMux()
+	mux.HandleFunc(profilePath, func(w http.ResponseWriter, r *http.Request) {
+		// A local UI fetches the profile from the same origin; the hosted app needs CORS, but only its origin is allowed
+		if bundle == nil {
+			w.Header().Set("Access-Control-Allow-Origin", speedscopeHostedOrigin)
+			w.Header().Set("Vary", "Origin")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, jsonPath)
+	})
+
+	uiURL := speedscopeHostedOrigin + "/#profileURL=" + url.QueryEscape(profileURL)
+	if bundle != nil {
+		log.Printf("Serving local speedscope bundle from %s", bundleName)
+		mux.Handle("/", http.FileServer(http.FS(bundle)))
+		uiURL = baseURL + "#profileURL=" + url.QueryEscape(profilePath)
+	} else {
+		log.Printf("No local speedscope bundle (run 'go generate' before building, or set %s); using %s", speedscopeBundleEnv, speedscopeHostedOrigin)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, uiURL, http.StatusFound)
+		})
+	}
+
+	log.Printf("Serving speedscope UI at %s (profile: %s)", uiURL, profileURL)
+	if openBrowser {
+		if err := openURL(uiURL); err != nil {
+			log.Printf("Failed to open browser: %v", err)
+		}
+	}
+	return http.Serve(listener, mux)
+}
+
+// localSpeedscopeBundle returns the speedscope UI to serve locally and where it comes from: the directory in
+// speedscopeBundleEnv, or else the embedded release. It returns nil when neither contains a speedscope build.
+func localSpeedscopeBundle() (fs.FS, string) {
+	if bundleDir := os.Getenv(speedscopeBundleEnv); bundleDir != "" {
+		dir := os.DirFS(bundleDir)
+		if _, err := fs.Stat(dir, "index.html"); err == nil {
+			return dir, bundleDir
+		}
+		log.Printf("Warning: ignoring %s=%q: no index.html in the directory", speedscopeBundleEnv, bundleDir)
+	}
+	embedded, err := fs.Sub(speedscopeBundle, "speedscope")
+	if err != nil {
+		return nil, ""
+	}
+	if _, err := fs.Stat(embedded, "index.html"); err != nil {
+		return nil, ""
+	}
+	return embedded, "the embedded release"
+}
+
+// openURL opens the given URL with the platform's default browser.
+func openURL(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		if browser := os.Getenv("BROWSER"); browser != "" {
+			cmd = exec.Command(browser, u)
+		} else {
+			cmd = exec.Command("xdg-open", u)
+		}
+	}
+	return cmd.Start()
+}
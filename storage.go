@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storageEnv selects where analysis manifests and artifact copies are persisted: a local directory
+// ("/var/lib/pprof-analyzer" or "file:///var/lib/pprof-analyzer"), an S3 bucket ("s3://bucket/prefix") or a
+// GCS bucket ("gs://bucket/prefix"). Unset, manifests are kept in the temporary directory and artifacts are
+// not copied, so a team-shared instance should set it to keep investigations across restarts and hosts.
+const storageEnv = "PPROF_ANALYZER_STORAGE"
+
+// storageEndpointEnv overrides the S3 or GCS endpoint, e.g. for MinIO or a storage emulator.
+const storageEndpointEnv = "PPROF_ANALYZER_STORAGE_ENDPOINT"
+
+// storageTimeout bounds one storage request.
+const storageTimeout = 2 * time.Minute
+
+// errNotStored is returned by artifactStore.Get for a missing key.
+var errNotStored = errors.New("not found in storage")
+
+// artifactStore persists the analysis workspace: manifests and copies of artifacts, addressed by
+// slash-separated keys (see analysisManifestKey and analysisArtifactKey).
+type artifactStore interface {
+	// Name describes the store for logs and results, e.g. "s3://bucket/prefix".
+	Name() string
+	// Put creates or replaces the object at key.
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the object at key, or errNotStored.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the object at key; a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	workspaceStoreOnce sync.Once
+	workspaceStoreImpl artifactStore
+	workspaceStoreErr  error
+	storesArtifacts    bool // Artifacts are copied to the store (storageEnv is set)
+)
+
+// initWorkspaceStore sets up the store configured by storageEnv. main calls it at startup and exits on
+// error: a shared instance that silently kept its analyses in the temporary directory would lose them.
+func initWorkspaceStore() error {
+	workspaceStoreOnce.Do(func() {
+		var store artifactStore = &localStore{dir: os.TempDir()}
+		workspaceStoreImpl = store
+		if value := strings.TrimSpace(os.Getenv(storageEnv)); value != "" {
+			configured, err := newArtifactStore(value, strings.TrimSpace(os.Getenv(storageEndpointEnv)))
+			if err != nil {
+				workspaceStoreErr = fmt.Errorf("invalid %s=%q: %w", storageEnv, value, err)
+				return
+			}
+			store = configured
+			storesArtifacts = true
+		}
+		// 加密同样适用于临时目录中的默认存储；密钥无效时拒绝启动，而不是以明文保存
+		key, err := loadEncryptionKey()
+		if err != nil {
+			workspaceStoreErr = err
+			return
+		}
+		if key != nil {
+			encrypted, err := newEncryptedStore(store, key)
+			if err != nil {
+				workspaceStoreErr = fmt.Errorf("failed to set up storage encryption: %w", err)
+				return
+			}
+			encrypted.allowPlaintext = strings.TrimSpace(os.Getenv(encryptionAllowPlaintextEnv)) == "1"
+			store, fileEncryption = encrypted, encrypted
+		}
+		workspaceStoreImpl = store
+		if storesArtifacts {
+			log.Printf("Persisting analysis manifests and artifacts to %s", store.Name())
+		} else if key != nil {
+			log.Printf("Encrypting analysis manifests in %s", store.Name())
+		}
+	})
+	return workspaceStoreErr
+}
+
+// workspaceStore returns the store set up by initWorkspaceStore (the temporary directory when storageEnv
+// is unset).
+func workspaceStore() artifactStore {
+	initWorkspaceStore()
+	return workspaceStoreImpl
+}
+
+// artifactsStored reports whether artifacts are copied to the workspace store.
+func artifactsStored() bool {
+	workspaceStore()
+	return storesArtifacts
+}
+
+// newArtifactStore returns the store for a storageEnv value.
+func newArtifactStore(value, endpoint string) (artifactStore, error) {
+	if filepath.IsAbs(value) {
+		return newLocalStore(value)
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return newLocalStore(u.Path)
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing bucket in '%s'", value)
+		}
+		return newS3Store(u.Host, prefix, endpoint)
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing bucket in '%s'", value)
+		}
+		return newGCSStore(u.Host, prefix, endpoint), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage '%s' (expected an absolute directory, file://, s3:// or gs://)", value)
+	}
+}
+
+// storageContext returns the context of one storage request.
+func storageContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), storageTimeout)
+}
+
+// localStore keeps objects as files below a directory.
+type localStore struct {
+	dir string
+}
+
+// newLocalStore returns a store in dir, creating it if needed.
+func newLocalStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory '%s': %w", dir, err)
+	}
+	return &localStore{dir: dir}, nil
+}
+
+func (s *localStore) Name() string {
+	return s.dir
+}
+
+// path returns the file of a key; keys never contain "..", as analysis IDs and file names are validated.
+func (s *localStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *localStore) Put(ctx context.Context, key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Written next to the destination and renamed, so readers never see a partial manifest
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
+
+func (s *localStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, errNotStored
+	}
+	return data, err
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	path := s.path(key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Remove the analysis' artifact directory once it is empty
+	if dir := filepath.Dir(path); dir != filepath.Clean(s.dir) {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// objectKey joins a bucket prefix and a key.
+func objectKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// escapeObjectKey percent-encodes a key for a URL path, keeping '/' and the RFC 3986 unreserved characters.
+func escapeObjectKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
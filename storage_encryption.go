@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// encryptionKeyEnv holds a base64-encoded 256-bit key. When a key is configured, manifests and artifact copies
+// are encrypted with AES-256-GCM before they reach the workspace store (see storageEnv), so profiles kept on a
+// shared host or bucket are not readable without it.
+const encryptionKeyEnv = "PPROF_ANALYZER_ENCRYPTION_KEY"
+
+// encryptionKeyFileEnv names a file holding the key instead, e.g. a mounted Kubernetes or Vault secret.
+const encryptionKeyFileEnv = "PPROF_ANALYZER_ENCRYPTION_KEY_FILE"
+
+// encryptionKeyCommandEnv is a command printing the key instead, e.g. a KMS call decrypting a data key:
+// "aws kms decrypt --ciphertext-blob fileb:///etc/pprof/key.enc --query Plaintext --output text". It is split
+// into arguments like postProcessEnv, run once at startup without a shell, and may print the key base64-encoded
+// or as 32 raw bytes.
+const encryptionKeyCommandEnv = "PPROF_ANALYZER_ENCRYPTION_KEY_COMMAND"
+
+// encryptionAllowPlaintextEnv set to "1" lets the encrypted store read objects that are not encrypted, to migrate
+// the objects saved before encryption was enabled (they are encrypted when rewritten). It is meant to be set
+// once: otherwise a plaintext object put in place of an encrypted one would be accepted without authentication.
+const encryptionAllowPlaintextEnv = "PPROF_ANALYZER_ENCRYPTION_ALLOW_PLAINTEXT"
+
+// encryptionKeyCommandTimeout bounds the key command.
+const encryptionKeyCommandTimeout = 30 * time.Second
+
+// encryptedMagic starts every encrypted object. It is followed by the key ID, the nonce and the sealed data.
+const encryptedMagic = "PPROFENC1"
+
+// encryptionKeyIDSize is the length of the key ID: a truncated SHA256 of the key, which tells a wrong key
+// from tampered data without revealing the key.
+const encryptionKeyIDSize = 8
+
+// loadEncryptionKey returns the key configured by one of the encryption environment variables, or nil when
+// none is set.
+func loadEncryptionKey() ([]byte, error) {
+	var sources []string
+	for _, env := range []string{encryptionKeyEnv, encryptionKeyFileEnv, encryptionKeyCommandEnv} {
+		if strings.TrimSpace(os.Getenv(env)) != "" {
+			sources = append(sources, env)
+		}
+	}
+	switch len(sources) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("%s are mutually exclusive: set only one of them", strings.Join(sources, " and "))
+	}
+
+	var raw []byte
+	switch source := sources[0]; source {
+	case encryptionKeyEnv:
+		raw = []byte(os.Getenv(encryptionKeyEnv))
+	case encryptionKeyFileEnv:
+		data, err := os.ReadFile(strings.TrimSpace(os.Getenv(encryptionKeyFileEnv)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", encryptionKeyFileEnv, err)
+		}
+		raw = data
+	case encryptionKeyCommandEnv:
+		data, err := runEncryptionKeyCommand(os.Getenv(encryptionKeyCommandEnv))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", encryptionKeyCommandEnv, err)
+		}
+		raw = data
+	}
+	key, err := parseEncryptionKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", sources[0], err)
+	}
+	return key, nil
+}
+
+// runEncryptionKeyCommand runs the key command and returns its standard output.
+func runEncryptionKeyCommand(commandLine string) ([]byte, error) {
+	args, err := splitCommandLine(commandLine)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), encryptionKeyCommandTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// parseEncryptionKey accepts a 256-bit key in base64 (standard or URL-safe alphabet, surrounding whitespace
+// ignored) or as 32 raw bytes.
+func parseEncryptionKey(raw []byte) ([]byte, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if key, err := encoding.DecodeString(trimmed); err == nil && len(key) == 32 {
+			return key, nil
+		}
+	}
+	if len(raw) == 32 {
+		return raw, nil
+	}
+	return nil, fmt.Errorf("expected a 256-bit key, base64-encoded (e.g. 'openssl rand -base64 32') or as 32 raw bytes")
+}
+
+// encryptedStore encrypts the objects of another store with AES-256-GCM. The object key is authenticated
+// along with the data, so a stored object cannot be passed off as another one (e.g. a manifest swapped
+// between analyses).
+type encryptedStore struct {
+	inner          artifactStore
+	aead           cipher.AEAD
+	keyID          []byte
+	allowPlaintext bool // Read objects that are not encrypted, see encryptionAllowPlaintextEnv
+}
+
+// newEncryptedStore wraps inner with encryption under a 256-bit key.
+func newEncryptedStore(inner artifactStore, key []byte) (*encryptedStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &encryptedStore{inner: inner, aead: aead, keyID: sum[:encryptionKeyIDSize]}, nil
+}
+
+func (s *encryptedStore) Name() string {
+	return s.inner.Name() + " (encrypted)"
+}
+
+// additionalData binds a sealed object to the format, the key and its object key.
+func (s *encryptedStore) additionalData(key string) []byte {
+	return []byte(encryptedMagic + string(s.keyID) + key)
+}
+
+// seal encrypts data bound to the object key.
+func (s *encryptedStore) seal(key string, data []byte) ([]byte, error) {
+	header := make([]byte, 0, len(encryptedMagic)+len(s.keyID)+s.aead.NonceSize())
+	header = append(append(header, encryptedMagic...), s.keyID...)
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.aead.Seal(append(header, nonce...), nonce, data, s.additionalData(key)), nil
+}
+
+// open decrypts data sealed under the object key. what names the data in errors, e.g. "stored object 'key'".
+func (s *encryptedStore) open(key, what string, data []byte) ([]byte, error) {
+	rest := data[len(encryptedMagic):]
+	if len(rest) < len(s.keyID)+s.aead.NonceSize() {
+		return nil, fmt.Errorf("%s is truncated", what)
+	}
+	if keyID := rest[:len(s.keyID)]; !bytes.Equal(keyID, s.keyID) {
+		return nil, fmt.Errorf("%s was encrypted with another key (key ID %x, configured key %x)", what, keyID, s.keyID)
+	}
+	rest = rest[len(s.keyID):]
+	nonce, sealed := rest[:s.aead.NonceSize()], rest[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, s.additionalData(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: it was modified or belongs to another key", what)
+	}
+	return plain, nil
+}
+
+func (s *encryptedStore) Put(ctx context.Context, key string, data []byte) error {
+	sealed, err := s.seal(key, data)
+	if err != nil {
+		return err
+	}
+	return s.inner.Put(ctx, key, sealed)
+}
+
+func (s *encryptedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		// 未加密的对象可能是替换了加密对象的伪造内容，只在显式开启迁移时读取
+		if !s.allowPlaintext {
+			return nil, fmt.Errorf("stored object '%s' is not encrypted and was refused, since anyone with write access to the store could have put it there; "+
+				"set %s=1 once to read the objects saved before encryption was enabled", key, encryptionAllowPlaintextEnv)
+		}
+		log.Printf("Warning: stored object '%s' is not encrypted; reading it because %s is set", key, encryptionAllowPlaintextEnv)
+		return data, nil
+	}
+	return s.open(key, fmt.Sprintf("stored object '%s'", key), data)
+}
+
+func (s *encryptedStore) Delete(ctx context.Context, key string) error {
+	return s.inner.Delete(ctx, key)
+}
+
+// profileFileKey is the object key the files the server writes (profiles and analysis results) are sealed under.
+// Files are renamed (a capture is committed from a temporary name) and may be moved by users, so only their
+// content and the key are authenticated.
+const profileFileKey = "profile-file"
+
+// fileEncryption encrypts the files the server writes, when an encryption key is configured (see
+// initWorkspaceStore).
+var fileEncryption *encryptedStore
+
+// profileFileEncryption returns the encryption of the server's files, or nil when no key is configured.
+func profileFileEncryption() *encryptedStore {
+	workspaceStore()
+	return fileEncryption
+}
+
+// sealFileData encrypts the content of a file the server writes when a key is configured, and returns it
+// unchanged otherwise.
+func sealFileData(data []byte) ([]byte, error) {
+	enc := profileFileEncryption()
+	if enc == nil {
+		return data, nil
+	}
+	return enc.seal(profileFileKey, data)
+}
+
+// openFileData decrypts the content of a file sealed by sealFileData, read from path; other content is
+// returned as it is.
+func openFileData(path string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		return data, nil
+	}
+	enc := profileFileEncryption()
+	if enc == nil {
+		return nil, fmt.Errorf("file '%s' is encrypted, but no encryption key is configured (see %s)", path, encryptionKeyEnv)
+	}
+	return enc.open(profileFileKey, fmt.Sprintf("file '%s'", path), data)
+}
+
+// writeSealedFile writes a profile or a result, only readable by the server's user and encrypted when a key
+// is configured.
+func writeSealedFile(path string, data []byte) error {
+	sealed, err := sealFileData(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0o600)
+}
+
+// writeSealedTemp writes data like writeSealedFile to a new temporary file named after pattern (see
+// os.CreateTemp), and returns its path.
+func writeSealedTemp(pattern string, data []byte) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+	file.Close()
+	if err := writeSealedFile(path, data); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// sealProfileFile encrypts a file written by another process (a download, the output of a profile command)
+// in place when a key is configured.
+func sealProfileFile(path string) error {
+	if profileFileEncryption() == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeSealedFile(path, data)
+}
+
+// readSealedFile reads a file written by the server, decrypting it if needed.
+func readSealedFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return openFileData(path, data)
+}
+
+// openProfileFile returns the path of the plaintext of a profile file: the file itself, or for an encrypted
+// file a decrypted copy with the same name in a private temporary directory, which the returned cleanup
+// removes before running cleanup. Tools reading the file (including 'go tool pprof' and the post-processing
+// hook) thus only see plaintext for the duration of a call or session.
+func openProfileFile(path string, cleanup func()) (string, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return path, cleanup, nil // Reported by the caller when it reads the file
+	}
+	header := make([]byte, len(encryptedMagic))
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+	if n < len(header) || string(header) != encryptedMagic {
+		return path, cleanup, nil
+	}
+
+	plain, err := readSealedFile(path)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "pprof-decrypted-*")
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to create temporary directory for decryption: %w", err)
+	}
+	tempPath := filepath.Join(dir, filepath.Base(path))
+	if err := os.WriteFile(tempPath, plain, 0o600); err != nil {
+		os.RemoveAll(dir)
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write decrypted profile '%s': %w", tempPath, err)
+	}
+	log.Printf("Decrypted profile file '%s' to temporary file %s", path, tempPath)
+	return tempPath, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Warning: failed to remove decrypted profile '%s': %v", tempPath, err)
+		}
+		cleanup()
+	}, nil
+}
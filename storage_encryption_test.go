@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	inner, err := newLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	store, err := newEncryptedStore(inner, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("main.handle /srv/app/handler.go")
+
+	if err := store.Put(ctx, "a/manifest.json", data); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	stored, err := inner.Get(ctx, "a/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("handler.go")) {
+		t.Fatal("Expected the stored object to be encrypted")
+	}
+	if got, err := store.Get(ctx, "a/manifest.json"); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Expected the object back, got %q, %v", got, err)
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := append([]byte{}, stored...)
+		tampered[len(tampered)-1] ^= 1
+		inner.Put(ctx, "tampered", tampered)
+		if _, err := store.Get(ctx, "tampered"); err == nil || !strings.Contains(err.Error(), "failed to decrypt") {
+			t.Errorf("Expected tampering to be detected, got %v", err)
+		}
+	})
+
+	// The object key is authenticated: a copy under another key does not decrypt
+	t.Run("Swapped", func(t *testing.T) {
+		inner.Put(ctx, "b/manifest.json", stored)
+		if _, err := store.Get(ctx, "b/manifest.json"); err == nil {
+			t.Error("Expected an object moved to another key to be rejected")
+		}
+	})
+
+	t.Run("OtherKey", func(t *testing.T) {
+		other, err := newEncryptedStore(inner, bytes.Repeat([]byte{8}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Get(ctx, "a/manifest.json"); err == nil || !strings.Contains(err.Error(), "encrypted with another key") {
+			t.Errorf("Expected a wrong key to be reported, got %v", err)
+		}
+	})
+
+	// A plaintext object put in place of an encrypted one is refused, since it is not authenticated
+	t.Run("SwappedPlaintext", func(t *testing.T) {
+		inner.Put(ctx, "a/manifest.json", []byte(`{"artifacts":[{"path":"/etc/passwd"}]}`))
+		if _, err := store.Get(ctx, "a/manifest.json"); err == nil || !strings.Contains(err.Error(), "not encrypted and was refused") {
+			t.Errorf("Expected the forged plaintext object to be refused, got %v", err)
+		}
+	})
+
+	// Objects saved before encryption was enabled are only readable during an explicit migration
+	t.Run("Plaintext", func(t *testing.T) {
+		inner.Put(ctx, "legacy", data)
+		if _, err := store.Get(ctx, "legacy"); err == nil || !strings.Contains(err.Error(), encryptionAllowPlaintextEnv) {
+			t.Errorf("Expected the plaintext object to be refused, got %v", err)
+		}
+		store.allowPlaintext = true
+		defer func() { store.allowPlaintext = false }()
+		if got, err := store.Get(ctx, "legacy"); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Expected the plaintext object, got %q, %v", got, err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := store.Get(ctx, "missing"); err != errNotStored {
+			t.Errorf("Expected errNotStored, got %v", err)
+		}
+	})
+}
+
+func TestProfileFileEncryption(t *testing.T) {
+	initWorkspaceStore()
+	enc, err := newEncryptedStore(nil, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := fileEncryption
+	fileEncryption = enc
+	defer func() { fileEncryption = saved }()
+
+	data := []byte("main.handle /srv/app/handler.go")
+	path := filepath.Join(t.TempDir(), "cpu-localhost-20240102T150405Z.pb.gz")
+	if err := writeSealedFile(path, data); err != nil {
+		t.Fatalf("writeSealedFile failed: %v", err)
+	}
+	if stored, err := os.ReadFile(path); err != nil || bytes.Contains(stored, []byte("handler.go")) {
+		t.Fatalf("Expected the captured profile to be encrypted on disk, got %q, %v", stored, err)
+	}
+
+	cleaned := false
+	plainPath, cleanup, err := openProfileFile(path, func() { cleaned = true })
+	if err != nil {
+		t.Fatalf("openProfileFile failed: %v", err)
+	}
+	if got, err := os.ReadFile(plainPath); plainPath == path || err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Expected a decrypted copy, got %s: %q, %v", plainPath, got, err)
+	}
+	cleanup()
+	if _, err := os.Stat(plainPath); !os.IsNotExist(err) || !cleaned {
+		t.Errorf("Expected the cleanup to remove the decrypted copy and run the original cleanup, got %v", err)
+	}
+
+	// Profiles provided by the user are plaintext and used as they are
+	input := filepath.Join(t.TempDir(), "input.pb.gz")
+	os.WriteFile(input, data, 0o600)
+	if got, _, err := openProfileFile(input, func() {}); err != nil || got != input {
+		t.Errorf("Expected the plaintext input itself, got %s, %v", got, err)
+	}
+
+	fileEncryption = nil
+	if _, _, err := openProfileFile(path, func() {}); err == nil || !strings.Contains(err.Error(), "no encryption key is configured") {
+		t.Errorf("Expected an error without a key, got %v", err)
+	}
+}
+
+func TestLoadEncryptionKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		env  map[string]string
+		want []byte
+		err  string // Expected substring of the error; empty when the key loads
+	}{
+		{name: "None", env: map[string]string{}},
+		{name: "Env", env: map[string]string{encryptionKeyEnv: encoded}, want: key},
+		{name: "URLSafe", env: map[string]string{encryptionKeyEnv: base64.URLEncoding.EncodeToString(key)}, want: key},
+		{name: "File", env: map[string]string{encryptionKeyFileEnv: keyFile}, want: key},
+		{name: "Command", env: map[string]string{encryptionKeyCommandEnv: "echo " + encoded}, want: key},
+		{name: "Short", env: map[string]string{encryptionKeyEnv: base64.StdEncoding.EncodeToString(key[:16])}, err: "expected a 256-bit key"},
+		{name: "MissingFile", env: map[string]string{encryptionKeyFileEnv: keyFile + ".missing"}, err: "invalid " + encryptionKeyFileEnv},
+		{name: "FailingCommand", env: map[string]string{encryptionKeyCommandEnv: "false"}, err: "'false' failed"},
+		{name: "Both", env: map[string]string{encryptionKeyEnv: encoded, encryptionKeyFileEnv: keyFile}, err: "mutually exclusive"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, env := range []string{encryptionKeyEnv, encryptionKeyFileEnv, encryptionKeyCommandEnv} {
+				t.Setenv(env, tc.env[env])
+			}
+			got, err := loadEncryptionKey()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("Loaded key %x, want %x", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestEncryptedProfileWriters runs the tools that write profiles and results with a key configured, and
+// checks that every file they leave in the temporary directory is encrypted.
+func TestEncryptedProfileWriters(t *testing.T) {
+	initWorkspaceStore()
+	enc, err := newEncryptedStore(nil, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := fileEncryption
+	fileEncryption = enc
+	defer func() { fileEncryption = saved }()
+
+	tmp, inputs := t.TempDir(), t.TempDir()
+	analysisID := "enc-" + newArtifactID()[:8]
+	t.Cleanup(func() {
+		ctx, cancel := storageContext()
+		defer cancel()
+		workspaceStore().Delete(ctx, analysisManifestKey(analysisID))
+	})
+	gzipped := func(p *profile.Profile) []byte {
+		var buf bytes.Buffer
+		if err := p.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// perf_to_profile and jfr are scripts writing a converted profile and the JSON of a recording
+	converted := filepath.Join(inputs, "converted.pb.gz")
+	os.WriteFile(converted, gzipped(poolTestProfile("main.perf", 5)), 0o600)
+	perfTool := filepath.Join(inputs, "perf_to_profile")
+	os.WriteFile(perfTool, []byte("#!/bin/sh\ncp "+converted+" \"$4\"\n"), 0o755)
+	recording := filepath.Join(inputs, "recording.json")
+	os.WriteFile(recording, []byte(testJFRJSON), 0o600)
+	jfrTool := filepath.Join(inputs, "jfr")
+	os.WriteFile(jfrTool, []byte("#!/bin/sh\ncat "+recording+"\n"), 0o755)
+	t.Setenv(perfToProfileEnv, perfTool)
+	t.Setenv(jfrToolEnv, jfrTool)
+	t.Setenv(confirmEnv, "off")
+	t.Setenv("TMPDIR", tmp) // os.TempDir: where the tools write their temporary files
+
+	tool := mcp.NewTool("inline_tool", mcp.WithString("profile_uri"), withInlineProfileData())
+	ingest := func(data []byte) string {
+		t.Helper()
+		args := map[string]interface{}{inlineProfileArg: base64.StdEncoding.EncodeToString(data), "analysis_id": analysisID}
+		if _, err := materializeInlineProfile(tool, args); err != nil {
+			t.Fatalf("materializeInlineProfile failed: %v", err)
+		}
+		return args["profile_uri"].(string)
+	}
+	call := func(handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), args map[string]interface{}) {
+		t.Helper()
+		var request mcp.CallToolRequest
+		request.Params.Arguments = args
+		if _, err := handler(context.Background(), request); err != nil {
+			t.Fatalf("Tool failed: %v", err)
+		}
+	}
+
+	// Inline profiles, then analyzed: the profile and the saved result
+	current := ingest(gzipped(poolTestProfile("main.handle", 30, 40)))
+	base := ingest(gzipped(poolTestProfile("main.handle", 10)))
+	call(handleAnalyzePprof, map[string]interface{}{"profile_uri": current, "profile_type": "cpu", "analysis_id": analysisID})
+	// Subtraction output
+	call(handleSubtractProfile, map[string]interface{}{"profile_uri": current, "base_profile_uri": base, "analysis_id": analysisID})
+	// Merged fleet profile
+	if path := saveMergedProfile(analysisID, poolTestProfile("main.fleet", 1, 2)); path == "" {
+		t.Fatal("saveMergedProfile failed")
+	}
+	// perf.data and JFR recordings, converted by the external tools
+	for _, data := range [][]byte{[]byte("PERFILE2 perf.data"), []byte("FLR\x00\x00\x02\x00\x01 binary chunk")} {
+		prof, err := loadProfile(context.Background(), ingest(data), analysisID)
+		if err != nil || len(prof.Sample) == 0 {
+			t.Fatalf("Failed to load the converted profile: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 7 {
+		t.Fatalf("Expected the written profiles and results in %s, got %d entries", tmp, len(entries))
+	}
+	for _, entry := range entries {
+		path := filepath.Join(tmp, entry.Name())
+		if entry.IsDir() {
+			t.Errorf("Expected no directory to be left (e.g. a decrypted copy), got %s", path)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) || bytes.HasPrefix(data, []byte{0x0a}) || !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+			t.Errorf("Expected %s to be encrypted, got %q", entry.Name(), data[:min(len(data), 16)])
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsScope is the OAuth scope requested for service account credentials.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsMetadataTokenURL returns the access token of the instance's service account on GCE, GKE and Cloud Run.
+const gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcsStore keeps objects in a Google Cloud Storage bucket through the XML API. The access token is taken,
+// in order, from GOOGLE_OAUTH_ACCESS_TOKEN, the service account key file in GOOGLE_APPLICATION_CREDENTIALS,
+// or the metadata server. With an endpoint override (an emulator) and none of them, requests are anonymous.
+type gcsStore struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	client   *http.Client
+
+	tokenMutex sync.Mutex
+	token      string
+	expiry     time.Time
+	anonymous  bool // No credentials for an emulator endpoint
+}
+
+// newGCSStore returns a store in bucket below prefix.
+func newGCSStore(bucket, prefix, endpoint string) *gcsStore {
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	return &gcsStore{
+		bucket:   bucket,
+		prefix:   prefix,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: storageTimeout},
+	}
+}
+
+func (s *gcsStore) Name() string {
+	return "gs://" + strings.TrimSuffix(objectKey(s.bucket, s.prefix), "/")
+}
+
+// do sends an authorized request and returns the response body of a successful one.
+func (s *gcsStore) do(ctx context.Context, method, key string, body []byte) ([]byte, int, error) {
+	target := fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, escapeObjectKey(objectKey(s.prefix, key)))
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s %s: %w", method, s.Name()+"/"+key, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s: %w", method, s.Name()+"/"+key, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, s.Name()+"/"+key, resp.Status, truncateBody(data))
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s *gcsStore) Put(ctx context.Context, key string, data []byte) error {
+	_, _, err := s.do(ctx, http.MethodPut, key, data)
+	return err
+}
+
+func (s *gcsStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, status, err := s.do(ctx, http.MethodGet, key, nil)
+	if status == http.StatusNotFound {
+		return nil, errNotStored
+	}
+	return data, err
+}
+
+func (s *gcsStore) Delete(ctx context.Context, key string) error {
+	_, status, err := s.do(ctx, http.MethodDelete, key, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// gcsTokenResponse is the OAuth token response of the token endpoint and the metadata server.
+type gcsTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// accessToken returns a cached access token, refreshing it a minute before it expires.
+func (s *gcsStore) accessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	s.tokenMutex.Lock()
+	defer s.tokenMutex.Unlock()
+	if s.anonymous || s.token != "" && time.Now().Before(s.expiry) {
+		return s.token, nil
+	}
+
+	var resp gcsTokenResponse
+	var err error
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		resp, err = s.serviceAccountToken(ctx, path)
+	} else {
+		resp, err = s.metadataToken(ctx)
+		if err != nil && s.endpoint != "https://storage.googleapis.com" {
+			s.anonymous = true // Emulators accept anonymous requests
+			return "", nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get a GCS access token: %w", err)
+	}
+	s.token = resp.AccessToken
+	s.expiry = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// metadataToken asks the metadata server for the token of the instance's service account.
+func (s *gcsStore) metadataToken(ctx context.Context) (gcsTokenResponse, error) {
+	// Off Google Cloud the metadata host does not resolve or answer; don't wait for the storage timeout
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataTokenURL, nil)
+	if err != nil {
+		return gcsTokenResponse{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return s.tokenRequest(req)
+}
+
+// serviceAccountToken exchanges a JWT signed with a service account key for an access token.
+func (s *gcsStore) serviceAccountToken(ctx context.Context, path string) (gcsTokenResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return gcsTokenResponse{}, err
+	}
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return gcsTokenResponse{}, fmt.Errorf("failed to parse '%s': %w", path, err)
+	}
+	if key.Type != "service_account" {
+		return gcsTokenResponse{}, fmt.Errorf("'%s' is not a service account key (type '%s')", path, key.Type)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return gcsTokenResponse{}, fmt.Errorf("no private key in '%s'", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return gcsTokenResponse{}, fmt.Errorf("failed to parse the private key in '%s': %w", path, err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return gcsTokenResponse{}, fmt.Errorf("the private key in '%s' is not an RSA key", path)
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": gcsScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return gcsTokenResponse{}, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return gcsTokenResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.tokenRequest(req)
+}
+
+// tokenRequest sends a token request and parses the response.
+func (s *gcsStore) tokenRequest(req *http.Request) (gcsTokenResponse, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return gcsTokenResponse{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return gcsTokenResponse{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return gcsTokenResponse{}, fmt.Errorf("%s: %s", resp.Status, truncateBody(data))
+	}
+	var token gcsTokenResponse
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return gcsTokenResponse{}, fmt.Errorf("unexpected token response: %s", truncateBody(data))
+	}
+	return token, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTokenServer verifies the JWT bearer assertions signed with key and returns access tokens valid for
+// expiresIn seconds, counting the exchanges.
+func fakeTokenServer(t *testing.T, key *rsa.PrivateKey, expiresIn int, exchanges *atomic.Int32) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyAssertion(r, &key.PublicKey, server.URL+"/token"); err != nil {
+			http.Error(w, `{"error":"invalid_grant","error_description":"`+err.Error()+`"}`, http.StatusBadRequest)
+			return
+		}
+		n := exchanges.Add(1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d,"token_type":"Bearer"}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// verifyAssertion checks the RS256 signature and the claims of the JWT in a token request.
+func verifyAssertion(r *http.Request, key *rsa.PublicKey, audience string) error {
+	if r.Method != http.MethodPost || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		return fmt.Errorf("unexpected %s request, grant type %q", r.Method, r.FormValue("grant_type"))
+	}
+	parts := strings.Split(r.FormValue("assertion"), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed assertion")
+	}
+	var header, claims map[string]interface{}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return fmt.Errorf("bad signature: %v", err)
+	}
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		return fmt.Errorf("unexpected header %v", header)
+	}
+	now := float64(time.Now().Unix())
+	iat, _ := claims["iat"].(float64)
+	exp, _ := claims["exp"].(float64)
+	if claims["iss"] != "pprof@project.iam.gserviceaccount.com" || claims["scope"] != gcsScope || claims["aud"] != audience ||
+		iat > now+5 || iat < now-60 || exp-iat != 3600 {
+		return fmt.Errorf("unexpected claims %v", claims)
+	}
+	return nil
+}
+
+// writeServiceAccountKey writes a service account key file with key and tokenURI.
+func writeServiceAccountKey(t *testing.T, key *rsa.PrivateKey, tokenURI string) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "pprof@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURI,
+	})
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// bearerAuthorizer accepts requests with one of the given bearer tokens.
+func bearerAuthorizer(tokens ...string) func(*http.Request, []byte) error {
+	return func(r *http.Request, _ []byte) error {
+		for _, token := range tokens {
+			if r.Header.Get("Authorization") == "Bearer "+token {
+				return nil
+			}
+		}
+		return fmt.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+	}
+}
+
+func TestGCSStoreServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exchanges atomic.Int32
+	tokens := fakeTokenServer(t, key, 3600, &exchanges)
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", writeServiceAccountKey(t, key, tokens.URL+"/token"))
+
+	objects := fakeObjectServer(t, bearerAuthorizer("token-1"))
+	testStoreRoundTrip(t, newGCSStore("bucket", "prefix", objects.URL))
+	// The token is cached until it expires
+	if n := exchanges.Load(); n != 1 {
+		t.Errorf("Expected one token exchange, got %d", n)
+	}
+
+	// A token about to expire is refreshed before each request
+	exchanges.Store(0)
+	expiring := fakeTokenServer(t, key, 30, &exchanges)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", writeServiceAccountKey(t, key, expiring.URL+"/token"))
+	store := newGCSStore("bucket", "prefix", fakeObjectServer(t, bearerAuthorizer("token-1", "token-2")).URL)
+	for i := 0; i < 2; i++ {
+		if err := store.Put(context.Background(), "key", []byte("data")); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if n := exchanges.Load(); n != 2 {
+		t.Errorf("Expected the expiring token to be refreshed, got %d exchanges", n)
+	}
+
+	// A key the token endpoint does not accept is reported
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", writeServiceAccountKey(t, other, tokens.URL+"/token"))
+	err = newGCSStore("bucket", "prefix", objects.URL).Put(context.Background(), "key", []byte("data"))
+	if err == nil || !strings.Contains(err.Error(), "failed to get a GCS access token") || !strings.Contains(err.Error(), "bad signature") {
+		t.Errorf("Expected the rejected assertion to be reported, got %v", err)
+	}
+}
+
+func TestGCSStoreAccessToken(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "static-token")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	testStoreRoundTrip(t, newGCSStore("bucket", "", fakeObjectServer(t, bearerAuthorizer("static-token")).URL))
+}
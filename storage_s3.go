@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store keeps objects in an S3 (or S3-compatible) bucket, signing requests with AWS Signature Version 4.
+// Credentials are read from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// variables, the region from AWS_REGION or AWS_DEFAULT_REGION (default us-east-1).
+//
+// Only these static variables are supported: unlike the AWS SDKs, the store does not read the shared
+// config and credentials files, assume roles, or ask the instance metadata service (IAM roles, instance
+// profiles, IRSA). With such credentials, export them before starting the server, e.g. with
+// 'aws configure export-credentials --format env'; temporary ones are not refreshed, so restart the
+// server before they expire.
+type s3Store struct {
+	bucket       string
+	prefix       string
+	endpoint     string // Path-style endpoint, e.g. "http://localhost:9000"; empty uses AWS virtual-hosted URLs
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newS3Store returns a store in bucket below prefix.
+func newS3Store(bucket, prefix, endpoint string) (*s3Store, error) {
+	s := &s3Store{
+		bucket:       bucket,
+		prefix:       prefix,
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: storageTimeout},
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for s3:// storage (shared config files, IAM roles and instance profiles are not supported)")
+	}
+	return s, nil
+}
+
+func (s *s3Store) Name() string {
+	return "s3://" + strings.TrimSuffix(objectKey(s.bucket, s.prefix), "/")
+}
+
+// url returns the URL of a key.
+func (s *s3Store) url(key string) string {
+	path := escapeObjectKey(objectKey(s.prefix, key))
+	if s.endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, path)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, path)
+}
+
+// do sends a signed request and returns the response body of a successful one.
+func (s *s3Store) do(ctx context.Context, method, key string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+	signS3Request(req, s.accessKey, s.secretKey, s.region, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s %s: %w", method, s.Name()+"/"+key, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s: %w", method, s.Name()+"/"+key, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, s.Name()+"/"+key, resp.Status, truncateBody(data))
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) error {
+	_, _, err := s.do(ctx, http.MethodPut, key, data)
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	data, status, err := s.do(ctx, http.MethodGet, key, nil)
+	if status == http.StatusNotFound {
+		return nil, errNotStored
+	}
+	return data, err
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	_, status, err := s.do(ctx, http.MethodDelete, key, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// signS3Request adds the AWS Signature Version 4 Authorization header to req, signing the host and every
+// header already set (including x-amz-content-sha256, which must hold the payload hash).
+func signS3Request(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+
+	canonicalRequest, signedHeaders := s3CanonicalRequest(req)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, region)
+	stringToSign := s3StringToSign(amzDate, scope, canonicalRequest)
+	signature := s3Signature(secretKey, date, region, stringToSign)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// s3CanonicalRequest returns the canonical request of req and the list of its signed headers. An
+// Authorization header, e.g. of a request signed before, is not signed.
+func s3CanonicalRequest(req *http.Request) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); name != "authorization" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n"), signedHeaders
+}
+
+// s3StringToSign returns the string to sign of a canonical request in the given credential scope.
+func s3StringToSign(amzDate, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+}
+
+// s3Signature signs a string to sign with the key derived from the secret key for the date and region.
+func s3Signature(secretKey, date, region, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// truncateBody shortens an error response body for error messages.
+func truncateBody(data []byte) string {
+	const limit = 300
+	text := strings.TrimSpace(string(data))
+	if len(text) > limit {
+		return text[:limit] + "..."
+	}
+	return text
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// subtractedProfiles remembers the temporary files written by subtract_profile, keyed by the analysis ID and
+// the cache keys of both inputs (see loadProfileWithKey), so repeating a subtraction reuses the file instead
+// of writing a new one. The analysis ID is part of the key because the file belongs to that analysis: its
+// cleanup_analysis removes it, and another analysis must not hand out (or bundle) a path it doesn't own.
+var subtractedProfiles = struct {
+	sync.Mutex
+	paths map[string]string
+}{paths: make(map[string]string)}
+
+// handleSubtractProfile computes profile_uri − base_profile_uri like 'go tool pprof -base', clamping stacks
+// that shrank to zero, and writes the result as a profile any other tool can read.
+func handleSubtractProfile(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := request.Params.Arguments
+
+	analysisID, err := analysisIDFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	profileURIStr, ok := args["profile_uri"].(string)
+	if !ok || profileURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: profile_uri (string)")
+	}
+	baseURIStr, ok := args["base_profile_uri"].(string)
+	if !ok || baseURIStr == "" {
+		return nil, fmt.Errorf("missing or invalid required argument: base_profile_uri (string)")
+	}
+	outputPath, _ := args["output_path"].(string) // 为空时写入临时文件
+	overwrite, _ := args["overwrite"].(bool)
+	if outputPath != "" && !filepath.IsAbs(outputPath) {
+		if cwd, err := os.Getwd(); err == nil {
+			outputPath = filepath.Join(cwd, outputPath)
+		}
+	}
+
+	log.Printf("Handling subtract_profile: URI=%s, Base=%s, Output=%s", profileURIStr, baseURIStr, outputPath)
+	if outputPath != "" {
+		if confirmErr := confirmWrite("subtract_profile", args, outputPath); confirmErr != nil {
+			return confirmErr.toolResult(), nil
+		}
+		if existsErr := checkOutputPath("subtract_profile", outputPath, overwrite); existsErr != nil {
+			log.Printf("Refusing to overwrite existing file: %s", outputPath)
+			return existsErr.toolResult(), nil
+		}
+	}
+
+	prof, key, err := loadProfileWithKey(ctx, profileURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	base, baseKey, err := loadProfileWithKey(ctx, baseURIStr, analysisID)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, matches := mapRenamedFunctions(args, prof, base)
+	reuseKey := analysisID + "|" + key + "-" + baseKey
+	if len(matches) > 0 {
+		reuseKey += "-mapped"
+	}
+	if outputPath == "" {
+		subtractedProfiles.Lock()
+		path, ok := subtractedProfiles.paths[reuseKey]
+		subtractedProfiles.Unlock()
+		if _, statErr := os.Stat(path); ok && statErr == nil {
+			log.Printf("Reusing subtracted profile %s", path)
+			return &mcp.CallToolResult{
+				Content: []mcp.Content{
+					mcp.TextContent{
+						Type: "text",
+						Text: fmt.Sprintf("Reused the profile from an earlier identical subtraction: %s\nPass it as 'profile_uri' to any other tool.\n", path),
+					},
+				},
+			}, nil
+		}
+	}
+
+	diff, stats, err := analyzer.SubtractProfile(mapped, base)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := writeProfileFile("subtract_profile", diff, outputPath, analysisID, overwrite)
+	if err != nil {
+		var existsErr *fileExistsError
+		if errors.As(err, &existsErr) {
+			return existsErr.toolResult(), nil
+		}
+		return nil, err
+	}
+	artifact := AnalysisArtifact{Path: path, Kind: "profile", Source: "subtract_profile", Temporary: outputPath == ""}
+	if err := recordAnalysisArtifact(analysisID, artifact); err != nil {
+		log.Printf("Warning: failed to record artifact for analysis '%s': %v", analysisID, err)
+	}
+	if outputPath == "" {
+		subtractedProfiles.Lock()
+		subtractedProfiles.paths[reuseKey] = path
+		subtractedProfiles.Unlock()
+	}
+	hookReport := runPostProcessHook(ctx, analysisID, artifact)
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("Subtracted profile written to: %s\n", path))
+	b.WriteString(fmt.Sprintf("  %s − %s\n", profileURIStr, baseURIStr))
+	b.WriteString(fmt.Sprintf("Samples: %d (profile: %d); %d stacks that only shrank dropped, %d negative values clamped to zero\n",
+		stats.Samples, len(prof.Sample), stats.DroppedSamples, stats.ClampedValues))
+	for _, st := range analyzer.SummarizeSampleTypes(diff) {
+		b.WriteString(fmt.Sprintf("  %s: %s\n", st.Type, st.TotalFormatted))
+	}
+	b.WriteString("Pass it as 'profile_uri' to any other tool.\n")
+
+	return withSameProfileWarning(withRecoveryWarnings(withFunctionMatches(withPostProcessReports(&mcp.CallToolResult{
+		Content: []mcp.Content{
+			mcp.TextContent{
+				Type: "text",
+				Text: b.String(),
+			},
+		},
+	}, hookReport), matches), prof, base), prof, base), nil
+}
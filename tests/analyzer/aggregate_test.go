@@ -0,0 +1,64 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer/aggregate"
+	"github.com/google/pprof/profile"
+)
+
+func TestAggregate(t *testing.T) {
+	mainFn := &profile.Function{ID: 1, Name: "main.main", Filename: "main.go"}
+	allocFn := &profile.Function{ID: 2, Name: "main.alloc", Filename: "alloc.go"}
+	frame := func(id uint64, fn *profile.Function, line int64) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: fn, Line: line}}}
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "inuse_objects", Unit: "count"}, {Type: "inuse_space", Unit: "bytes"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{frame(1, allocFn, 10), frame(3, mainFn, 5)}, Value: []int64{2, 200}, Label: map[string][]string{"type": {"[]byte"}}},
+			{Location: []*profile.Location{frame(2, allocFn, 20), frame(3, mainFn, 5)}, Value: []int64{1, 300}},
+			{Location: []*profile.Location{frame(3, mainFn, 5)}, Value: []int64{1, 100}},
+		},
+	}
+
+	t.Run("AggregateByFunction", func(t *testing.T) {
+		agg := aggregate.AggregateByFunction(p, 1, 0)
+		if agg.Total != 600 || agg.TotalObjects != 4 {
+			t.Errorf("Expected totals 600 bytes / 4 objects, got %d / %d", agg.Total, agg.TotalObjects)
+		}
+		if len(agg.Stats) != 2 || agg.Stats[0] != (aggregate.Stat{Name: "main.alloc", Flat: 500, Objects: 3}) {
+			t.Errorf("Unexpected function stats: %+v", agg.Stats)
+		}
+	})
+
+	t.Run("AggregateBySite", func(t *testing.T) {
+		agg := aggregate.AggregateBySite(p, 1, -1)
+		if len(agg.Stats) != 3 || agg.Stats[0].Name != "main.alloc at alloc.go:20" || agg.Stats[0].Objects != 0 {
+			t.Errorf("Unexpected site stats: %+v", agg.Stats)
+		}
+	})
+
+	t.Run("BuildTree", func(t *testing.T) {
+		root, err := aggregate.BuildTree(p, 1)
+		if err != nil {
+			t.Fatalf("BuildTree failed: %v", err)
+		}
+		if root.Name != "root" || root.Value != 600 || root.Objects != 4 || len(root.Children) != 1 {
+			t.Fatalf("Unexpected root: %+v", root)
+		}
+		mainNode := root.Children[0]
+		if mainNode.Name != "main.main" || mainNode.Value != 600 || mainNode.Self != 100 || len(mainNode.Children) != 1 {
+			t.Fatalf("Unexpected main.main node: %+v", mainNode)
+		}
+		// Both call sites of main.alloc are merged into one node
+		allocNode := mainNode.Children[0]
+		if allocNode.Value != 500 || allocNode.Self != 500 || allocNode.Objects != 3 || allocNode.Type != "[]byte" {
+			t.Errorf("Unexpected main.alloc node: %+v", allocNode)
+		}
+
+		if _, err := aggregate.BuildTree(p, 5); err == nil {
+			t.Error("Expected an error for an invalid value index")
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestClusterAllocationSites(t *testing.T) {
+	server := []string{"main.handle", "net/http.HandlerFunc.ServeHTTP", "net/http.serverHandler.ServeHTTP", "net/http.(*conn).serve", "runtime.goexit"}
+	decode := append([]string{"encoding/json.(*decodeState).value", "encoding/json.(*decodeState).unmarshal", "encoding/json.Unmarshal", "main.decodeBody"}, server...)
+	stack := func(values []int64, leaf []string, callers []string) *profile.Sample {
+		return stackSample(values, append(append([]string{}, leaf...), callers...)...)
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "alloc_objects", Unit: "count"}, {Type: "alloc_space", Unit: "bytes"}},
+		Sample: []*profile.Sample{
+			// Four sites inside the JSON decoder, all reached through main.decodeBody
+			stack([]int64{1, 100}, []string{"reflect.New", "encoding/json.(*decodeState).object"}, decode),
+			stack([]int64{2, 200}, []string{"runtime.makeslice", "encoding/json.(*decodeState).array"}, decode),
+			stack([]int64{3, 300}, []string{"encoding/json.(*decodeState).literalStore", "encoding/json.(*decodeState).object"}, decode),
+			stack([]int64{4, 400}, []string{"runtime.slicebytetostring", "encoding/json.(*decodeState).literalStore", "encoding/json.(*decodeState).object"}, decode),
+			// Other sites of the same handler, which only share the server loop
+			stack([]int64{1, 2000}, []string{"main.newBuffer"}, server),
+			stack([]int64{1, 500}, []string{"main.render"}, server),
+			stackSample([]int64{1, 50}, "main.loadConfig", "main.main", "runtime.main"),
+		},
+	}
+
+	clusters := analyzer.ClusterAllocationSites(p, 1, 0, 10)
+	if len(clusters) != 1 {
+		t.Fatalf("Expected the JSON decoding path as the only cluster, got %+v", clusters)
+	}
+	c := clusters[0]
+	if c.Funnel != "encoding/json.(*decodeState).value" || c.SharedFrames != 9 || c.Sites != 4 || c.Value != 1000 || c.ObjectCount != 10 {
+		t.Errorf("Unexpected cluster: %+v", c)
+	}
+	if want := "encoding/json.(*decodeState).value,encoding/json.(*decodeState).unmarshal,encoding/json.Unmarshal,main.decodeBody"; strings.Join(c.EntryPath, ",") != want {
+		t.Errorf("Expected the entry path %s, got %v", want, c.EntryPath)
+	}
+	if len(c.TopSites) != 3 || c.TopSites[0].Site != "runtime.slicebytetostring at file.go:10" || c.TopSites[0].AvgSize != 100 {
+		t.Errorf("Unexpected top sites: %+v", c.TopSites)
+	}
+
+	text, err := analyzer.AnalyzeAllocsProfile(p, 10, "text")
+	if err != nil {
+		t.Fatalf("AnalyzeAllocsProfile failed: %v", err)
+	}
+	for _, want := range []string{"=== By Allocation Cluster (Sites Sharing an Entry Path) ===",
+		"encoding/json.(*decodeState).value ← encoding/json.(*decodeState).unmarshal ← encoding/json.Unmarshal ← main.decodeBody ← …"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+
+	jsonText, err := analyzer.AnalyzeAllocsProfile(p, 10, "json")
+	if err != nil {
+		t.Fatalf("AnalyzeAllocsProfile failed: %v", err)
+	}
+	var result struct {
+		AllocationClusters []analyzer.AllocSiteCluster `json:"allocationClusters"`
+	}
+	if err := json.Unmarshal([]byte(jsonText), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if len(result.AllocationClusters) != 1 || result.AllocationClusters[0].Sites != 4 {
+		t.Errorf("Unexpected JSON clusters: %+v", result.AllocationClusters)
+	}
+}
@@ -92,9 +92,9 @@ func TestAnalyzeAllocsProfile(t *testing.T) {
 			t.Fatalf("Error analyzing allocs profile with markdown format: %v", err)
 		}
 
-		// Check that the result contains markdown formatting
-		if !strings.Contains(result, "```text") || !strings.Contains(result, "```") {
-			t.Errorf("Expected markdown result to be wrapped in code blocks, but it isn't.\nResult: %s", result)
+		// Check that the result is rendered as markdown tables rather than a code block
+		if strings.Contains(result, "```") || !strings.Contains(result, "#### By Function") || !strings.Contains(result, "|---:|---:|---:|---|") {
+			t.Errorf("Expected markdown result to contain markdown tables, but it doesn't.\nResult: %s", result)
 		}
 	})
 
@@ -288,3 +288,55 @@ func TestAnalyzeAllocsProfileWithRealProfiles(t *testing.T) {
 		}
 	*/
 }
+
+func TestDetectDuplicateAllocations(t *testing.T) {
+	concatFn := &profile.Function{ID: 1, Name: "runtime.concatstrings", Filename: "string.go"}
+	callerFn := &profile.Function{ID: 2, Name: "main.buildKey", Filename: "main.go"}
+	bigFn := &profile.Function{ID: 3, Name: "main.bigBuffers", Filename: "main.go"}
+
+	testProfile := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+		},
+		Sample: []*profile.Sample{
+			{
+				Location: []*profile.Location{
+					{ID: 1, Line: []profile.Line{{Function: concatFn, Line: 10}}},
+					{ID: 2, Line: []profile.Line{{Function: callerFn, Line: 42}}},
+				},
+				Value:    []int64{5000, 5000 * 32},
+				NumLabel: map[string][]int64{"bytes": {32}},
+			},
+			{
+				// Large objects are not candidates
+				Location: []*profile.Location{{ID: 3, Line: []profile.Line{{Function: bigFn, Line: 7}}}},
+				Value:    []int64{5000, 5000 * 4096},
+				NumLabel: map[string][]int64{"bytes": {4096}},
+			},
+		},
+	}
+
+	findings := analyzer.DetectDuplicateAllocations(testProfile, 1, 0, 10)
+	if len(findings) != 1 {
+		t.Fatalf("Expected 1 finding, got %d: %+v", len(findings), findings)
+	}
+	finding := findings[0]
+	if !strings.Contains(finding.Site, "main.buildKey") {
+		t.Errorf("Expected finding to be attributed to the calling site, got '%s'", finding.Site)
+	}
+	if finding.Mechanism != "string concatenation" {
+		t.Errorf("Expected mechanism 'string concatenation', got '%s'", finding.Mechanism)
+	}
+	if finding.EstimatedSavings != 4999*32 {
+		t.Errorf("Expected estimated savings %d, got %d", 4999*32, finding.EstimatedSavings)
+	}
+
+	result, err := analyzer.AnalyzeAllocsProfile(testProfile, 5, "text")
+	if err != nil {
+		t.Fatalf("Error analyzing allocs profile: %v", err)
+	}
+	if !strings.Contains(result, "Interning/Pooling Candidates") {
+		t.Errorf("Expected result to contain the duplicate findings section.\nResult: %s", result)
+	}
+}
@@ -0,0 +1,122 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+// lineSample builds a sample from (function, line) frames ordered leaf first, all in file.
+func lineSample(value int64, file string, frames ...interface{}) *profile.Sample {
+	s := &profile.Sample{Value: []int64{1, value}}
+	for i := 0; i < len(frames); i += 2 {
+		fn := &profile.Function{Name: frames[i].(string), Filename: file, StartLine: 3}
+		s.Location = append(s.Location, &profile.Location{Line: []profile.Line{{Function: fn, Line: int64(frames[i+1].(int))}}})
+	}
+	return s
+}
+
+func TestAnnotateSource(t *testing.T) {
+	root := t.TempDir()
+	source := "package main\n\nfunc work() {\n\tfor {\n\t\tstep()\n\t}\n}\n\nfunc step() {}\n"
+	if err := os.MkdirAll(filepath.Join(root, "cmd", "app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "cmd", "app", "main.go"), []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Built elsewhere: only the suffix "cmd/app/main.go" exists below the root
+	const buildPath = "/build/src/example.com/app/cmd/app/main.go"
+	p := cpuProfile(
+		lineSample(300, buildPath, "main.work", 5),
+		lineSample(200, buildPath, "main.step", 9, "main.work", 5),
+		// Recursion through the same line is counted once
+		lineSample(100, buildPath, "main.work", 4, "main.work", 4),
+	)
+
+	r, err := analyzer.AnnotateSource(p, `main\.work`, root, "", 0)
+	if err != nil {
+		t.Fatalf("AnnotateSource failed: %v", err)
+	}
+	if r.Matched != 1 || len(r.Functions) != 1 || r.SampleType != "cpu" || r.Total != 600 {
+		t.Fatalf("Unexpected result: %+v", r)
+	}
+	f := r.Functions[0]
+	if f.ResolvedPath != filepath.Join(root, "cmd", "app", "main.go") || f.SourceMissing {
+		t.Errorf("Expected the source to be found by path suffix, got %q", f.ResolvedPath)
+	}
+	if f.Flat != 400 || f.Cum != 600 {
+		t.Errorf("Expected flat 400 and cum 600, got %d and %d", f.Flat, f.Cum)
+	}
+	// From the start line to two lines after the last sampled line
+	got := make(map[int64][2]int64)
+	for _, l := range f.Lines {
+		got[l.Line] = [2]int64{l.Flat, l.Cum}
+	}
+	if len(f.Lines) != 5 || f.Lines[0].Line != 3 || f.Lines[0].Source != "func work() {" {
+		t.Errorf("Unexpected lines: %+v", f.Lines)
+	}
+	if got[4] != [2]int64{100, 100} || got[5] != [2]int64{300, 500} || got[6] != [2]int64{0, 0} {
+		t.Errorf("Unexpected line values: %v", got)
+	}
+
+	t.Run("SourceMissing", func(t *testing.T) {
+		r, err := analyzer.AnnotateSource(p, `main\.`, t.TempDir(), "", 10)
+		if err != nil {
+			t.Fatalf("AnnotateSource failed: %v", err)
+		}
+		if r.Matched != 2 || r.Functions[0].Function != "main.work" || !r.Functions[0].SourceMissing {
+			t.Fatalf("Unexpected result: %+v", r)
+		}
+		// Only the sampled lines are listed
+		if lines := r.Functions[0].Lines; len(lines) != 2 || lines[0].Line != 4 || lines[1].Line != 5 {
+			t.Errorf("Expected the sampled lines only, got %+v", lines)
+		}
+	})
+
+	// Paths come from the profile: files without a source extension and paths leaving the root are not read
+	t.Run("Untrusted", func(t *testing.T) {
+		secret := filepath.Join(root, "secret.txt")
+		os.WriteFile(secret, []byte("password\n"), 0o600)
+		outside := filepath.Join(filepath.Dir(root), "outside.go")
+		os.WriteFile(outside, []byte("package outside\n"), 0o600)
+		defer os.Remove(outside)
+		for _, file := range []string{secret, "secret.txt", "../outside.go"} {
+			r, err := analyzer.AnnotateSource(cpuProfile(lineSample(1, file, "main.x", 1)), "main", root, "", 1)
+			if err != nil {
+				t.Fatalf("AnnotateSource failed: %v", err)
+			}
+			if !r.Functions[0].SourceMissing {
+				t.Errorf("Expected %s not to be read, got %+v", file, r.Functions[0])
+			}
+		}
+	})
+
+	t.Run("Formats", func(t *testing.T) {
+		text, err := analyzer.FormatAnnotatedSource(r, "text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(text, "ROUTINE ======================== main.work in "+buildPath) || !strings.Contains(text, "     5:         step()") {
+			t.Errorf("Unexpected text output:\n%s", text)
+		}
+		markdown, _ := analyzer.FormatAnnotatedSource(r, "markdown")
+		if !strings.Contains(markdown, "### `main.work`") || !strings.Contains(markdown, "```text") {
+			t.Errorf("Unexpected markdown output:\n%s", markdown)
+		}
+		data, _ := analyzer.FormatAnnotatedSource(r, "json")
+		var parsed analyzer.AnnotatedSource
+		if err := json.Unmarshal([]byte(data), &parsed); err != nil || len(parsed.Functions) != 1 {
+			t.Errorf("Invalid JSON output: %v\n%s", err, data)
+		}
+	})
+
+	if _, err := analyzer.AnnotateSource(p, "nomatch", root, "", 1); err == nil {
+		t.Error("Expected an error when no function matches")
+	}
+}
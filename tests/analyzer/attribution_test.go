@@ -0,0 +1,92 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func cpuProfile(samples ...*profile.Sample) *profile.Profile {
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		Sample: samples,
+	}
+}
+
+func TestAttributeCostsByHandler(t *testing.T) {
+	p := cpuProfile(
+		stackSample([]int64{3, 300}, "encoding/json.Marshal", "main.(*server).SayHello", "example.com/pb._Greeter_SayHello_Handler",
+			"google.golang.org/grpc.(*Server).processUnaryRPC", "google.golang.org/grpc.(*Server).handleStream"),
+		stackSample([]int64{1, 100}, "runtime.memmove", "example.com/pb._Greeter_SayHello_Handler.func1", "google.golang.org/grpc.(*Server).processUnaryRPC"),
+		stackSample([]int64{2, 200}, "strings.ToUpper", "main.usersHandler", "net/http.HandlerFunc.ServeHTTP",
+			"github.com/gorilla/mux.(*Router).ServeHTTP", "net/http.serverHandler.ServeHTTP", "net/http.(*conn).serve"),
+		stackSample([]int64{4, 400}, "runtime.gcBgMarkWorker"),
+	)
+
+	output, err := analyzer.AttributeCosts(p, "handler", "", 10, "json")
+	if err != nil {
+		t.Fatalf("AttributeCosts returned error: %v", err)
+	}
+	var result analyzer.AttributionResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Failed to unmarshal JSON result: %v", err)
+	}
+
+	if result.ValueType != "cpu" || result.TotalValue != 1000 || result.AttributedValue != 600 {
+		t.Errorf("Unexpected totals: type=%s total=%d attributed=%d", result.ValueType, result.TotalValue, result.AttributedValue)
+	}
+	want := []analyzer.AttributionStat{
+		{Key: "/Greeter/SayHello", Kind: "grpc", Value: 400, Samples: 2},
+		{Key: "main.usersHandler", Kind: "http", Value: 200, Samples: 1},
+	}
+	if len(result.Entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(want), len(result.Entries), result.Entries)
+	}
+	for i, w := range want {
+		got := result.Entries[i]
+		if got.Key != w.Key || got.Kind != w.Kind || got.Value != w.Value || got.Samples != w.Samples {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+
+	if _, err := analyzer.AttributeCosts(p, "handler", "inuse_space", 10, "text"); err == nil {
+		t.Error("Expected an error for a missing sample type")
+	}
+}
+
+func TestAttributeCostsByTest(t *testing.T) {
+	p := cpuProfile(
+		stackSample([]int64{5, 500}, "sort.Slice", "example.com/pkg.TestSort.func1", "testing.tRunner", "runtime.goexit"),
+		stackSample([]int64{2, 200}, "example.com/pkg.TestSort", "testing.tRunner", "runtime.goexit"),
+		stackSample([]int64{7, 700}, "example.com/pkg.(*Cache).Get", "example.com/pkg.BenchmarkCache", "testing.(*B).runN", "testing.(*B).launch"),
+		stackSample([]int64{1, 100}, "example.com/pkg.Testify", "testing.tRunner"),
+	)
+
+	output, err := analyzer.AttributeCosts(p, "test", "cpu", 10, "json")
+	if err != nil {
+		t.Fatalf("AttributeCosts returned error: %v", err)
+	}
+	var result analyzer.AttributionResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Failed to unmarshal JSON result: %v", err)
+	}
+
+	want := []analyzer.AttributionStat{
+		{Key: "example.com/pkg.BenchmarkCache", Kind: "benchmark", Value: 700, Samples: 1},
+		{Key: "example.com/pkg.TestSort", Kind: "test", Value: 700, Samples: 2},
+	}
+	if len(result.Entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(want), len(result.Entries), result.Entries)
+	}
+	for i, w := range want {
+		got := result.Entries[i]
+		if got.Key != w.Key || got.Kind != w.Kind || got.Value != w.Value || got.Samples != w.Samples {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, w, got)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+// buildProfile returns a CPU profile of 12 main.workN functions calling main.helperN, inlined or not, from the
+// given binary.
+func buildProfile(inlined bool, binary, buildID string, value int64) *profile.Profile {
+	function := func(name string) *profile.Function {
+		return &profile.Function{Name: name, Filename: "main.go"}
+	}
+	p := cpuProfile()
+	p.Mapping = []*profile.Mapping{{ID: 1, File: binary, BuildID: buildID, HasFunctions: true}}
+	for i := 0; i < 12; i++ {
+		helper := profile.Line{Function: function(fmt.Sprintf("main.helper%d", i)), Line: 5}
+		work := profile.Line{Function: function(fmt.Sprintf("main.work%d", i)), Line: 20}
+		locs := []*profile.Location{{Line: []profile.Line{helper}}, {Line: []profile.Line{work}}}
+		if inlined {
+			locs = []*profile.Location{{Line: []profile.Line{helper, work}}}
+		}
+		locs = append(locs, &profile.Location{Line: []profile.Line{{Function: function("main.main"), Line: 40}}})
+		p.Sample = append(p.Sample, &profile.Sample{Location: locs, Value: []int64{value, value * 1e7}})
+	}
+	return withLocationTable(p)
+}
+
+func TestDiffProfilesOptimizationMismatch(t *testing.T) {
+	release := buildProfile(true, "/app/server", "a1", 10)
+	debug := buildProfile(false, "/app/__debug_bin3821", "d4", 30)
+
+	diff, err := analyzer.DiffProfiles(release, debug, "cpu", "", "flat", 5)
+	if err != nil {
+		t.Fatalf("DiffProfiles failed: %v", err)
+	}
+	m := diff.OptimizationMismatch
+	if m == nil {
+		t.Fatal("Expected an optimization mismatch between a release and a debug build")
+	}
+	if m.UnoptimizedBuild != "new" || len(m.Packages) != 1 || m.Packages[0].Package != "main" || m.Packages[0].OldInlinedPercent != 50 || m.InliningChanged != 12 {
+		t.Errorf("Unexpected mismatch: %+v", m)
+	}
+	if len(m.Evidence) != 4 || !strings.Contains(m.Evidence[1], "__debug_bin3821") || !strings.Contains(m.Evidence[3], "(a1, d4)") {
+		t.Errorf("Unexpected evidence: %q", m.Evidence)
+	}
+	if r := diff.Regressions[0]; r.Name != "main.helper0" || r.Inlining != "old" {
+		t.Errorf("Expected the inlined helpers to be annotated, got %+v", r)
+	}
+
+	text, err := analyzer.FormatProfileDiff(diff, "text")
+	if err != nil {
+		t.Fatalf("FormatProfileDiff failed: %v", err)
+	}
+	for _, want := range []string{"=== Optimization Mismatch ===", "WARNING: The new profile comes from a build with optimizations or inlining disabled",
+		"- package main: 50.00% of 24 locations have inlined frames in the old build, none of 36 in the new build",
+		"main.helper0 (inlined in the old build only)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+	output, err := analyzer.FormatProfileDiff(diff, "json")
+	if err != nil {
+		t.Fatalf("FormatProfileDiff failed: %v", err)
+	}
+	var parsed analyzer.ProfileDiffResult
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil || parsed.OptimizationMismatch == nil || parsed.OptimizationMismatch.UnoptimizedBuild != "new" {
+		t.Errorf("Expected the mismatch in the JSON diff, got %s (%v)", output, err)
+	}
+
+	diff, err = analyzer.DiffProfiles(release, buildProfile(true, "/app/server", "a2", 20), "cpu", "", "flat", 5)
+	if err != nil {
+		t.Fatalf("DiffProfiles failed: %v", err)
+	}
+	if diff.OptimizationMismatch != nil || diff.Regressions[0].Inlining != "" {
+		t.Errorf("Expected no mismatch between two release builds, got %+v", diff.OptimizationMismatch)
+	}
+}
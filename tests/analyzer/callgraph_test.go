@@ -0,0 +1,125 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestCallGraph(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{3, 300}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{2, 200}, "main.encode", "main.handle", "main.main"),
+		stackSample([]int64{1, 100}, "main.walk", "main.walk", "main.main"),
+		stackSample([]int64{4, 400}, "runtime.gcBgMarkWorker"),
+	))
+
+	output, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(10), analyzer.WithFormat("callgraph"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var graph analyzer.CallGraph
+	if err := json.Unmarshal([]byte(output), &graph); err != nil {
+		t.Fatalf("Failed to parse JSON: %v\n%s", err, output)
+	}
+	if graph.Total != 1000 || len(graph.Nodes) != 6 || graph.Nodes[0].Name != "main.main" || graph.Nodes[0].Cum != 600 {
+		t.Fatalf("Unexpected nodes: %+v", graph)
+	}
+	edges := make(map[string]analyzer.CallGraphEdge)
+	for _, e := range graph.Edges {
+		edges[e.Caller+"->"+e.Callee] = e
+	}
+	if e := edges["main.main->main.handle"]; e.Cum != 500 || e.Flat != 0 {
+		t.Errorf("Expected main.main->main.handle with cum 500, got %+v", e)
+	}
+	if e := edges["main.handle->main.parse"]; e.Cum != 300 || e.Flat != 300 {
+		t.Errorf("Expected main.handle->main.parse with flat and cum 300, got %+v", e)
+	}
+	if e := edges["main.walk->main.walk"]; e.Cum != 100 {
+		t.Errorf("Expected a recursive self edge, got %+v", e)
+	}
+
+	// Only edges between the kept nodes remain
+	output, err = analyzer.Analyze(p, "cpu", analyzer.WithTopN(2), analyzer.WithFormat("callgraph"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	graph = analyzer.CallGraph{}
+	if err := json.Unmarshal([]byte(output), &graph); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if len(graph.Nodes) != 2 || graph.DroppedNodes != 4 || len(graph.Edges) != 1 || graph.Edges[0].Callee != "main.handle" {
+		t.Errorf("Expected main.main and main.handle with one edge, got %+v", graph)
+	}
+
+	dot, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(10), analyzer.WithFormat("callgraph-dot"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{`digraph "callgraph" {`, `N1 [label="main.main\nflat 0ns (0.00%)\ncum 600ns (60.00%)"`, "N1 -> N2", "}\n"} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected %q in:\n%s", want, dot)
+		}
+	}
+}
+
+func TestCallGraphGraphML(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{3, 300}, "encoding/json.Marshal", "main.(*handler).serve", "main.main"),
+		stackSample([]int64{1, 100}, "main.render[go.shape.string]", "main.(*handler).serve", "main.main"),
+	))
+
+	output, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(10), analyzer.WithFormat("graphml"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	// The output is well-formed GraphML, with a data element per attribute
+	var doc struct {
+		Keys []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"key"`
+		Graph struct {
+			Nodes []struct {
+				ID   string `xml:"id,attr"`
+				Data []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+				Data   []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal([]byte(output), &doc); err != nil {
+		t.Fatalf("Failed to parse GraphML: %v\n%s", err, output)
+	}
+	if len(doc.Keys) != 9 || len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 3 {
+		t.Fatalf("Unexpected graph: %d keys, %d nodes, %d edges", len(doc.Keys), len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	attributes := make(map[string]map[string]string)
+	for _, n := range doc.Graph.Nodes {
+		data := make(map[string]string)
+		for _, d := range n.Data {
+			data[d.Key] = d.Value
+		}
+		attributes[data["label"]] = data
+	}
+	if marshal := attributes["encoding/json.Marshal"]; marshal["package"] != "encoding/json" || marshal["standard"] != "true" || marshal["flat"] != "300" || marshal["flat_percent"] != "75.0000" {
+		t.Errorf("Unexpected attributes for encoding/json.Marshal: %v", marshal)
+	}
+	if serve := attributes["main.(*handler).serve"]; serve["package"] != "main" || serve["standard"] != "false" || serve["cum"] != "400" {
+		t.Errorf("Unexpected attributes for main.(*handler).serve: %v", serve)
+	}
+	if !strings.Contains(output, `<edge id="e1" source="n2" target="n1">`) || !strings.Contains(output, `<data key="weight">400</data>`) {
+		t.Errorf("Expected the heaviest edge first, weighted by its cum value:\n%s", output)
+	}
+}
@@ -0,0 +1,60 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestFormatCaptureChange(t *testing.T) {
+	previous := cpuProfile(
+		stackSample([]int64{1, 100}, "main.parse", "main.main"),
+		stackSample([]int64{1, 100}, "main.log", "main.main"),
+	)
+	current := cpuProfile(
+		stackSample([]int64{1, 300}, "main.parse", "main.main"),
+		stackSample([]int64{1, 50}, "main.log", "main.main"),
+	)
+	now := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+	change, err := analyzer.CompareWithPreviousCapture(previous, current, "cpu", "", now.Add(-12*time.Minute), now, 3)
+	if err != nil {
+		t.Fatalf("CompareWithPreviousCapture failed: %v", err)
+	}
+	if change.ElapsedSeconds != 720 || change.Diff.TotalDelta != 150 {
+		t.Fatalf("Unexpected change: %+v", change)
+	}
+
+	text, err := analyzer.FormatCaptureChange(change, "text")
+	if err != nil {
+		t.Fatalf("FormatCaptureChange failed: %v", err)
+	}
+	for _, want := range []string{"Change since last capture (12 minutes ago)", "Total cpu: 200ns → 350ns (+150ns, +75.00%)", "main.parse", "main.log"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the section:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "main.parse") > strings.Index(text, "main.log") {
+		t.Errorf("Expected growth before shrinkage:\n%s", text)
+	}
+
+	markdown, err := analyzer.FormatCaptureChange(change, "markdown")
+	if err != nil || !strings.HasPrefix(markdown, "### Change since last capture") || !strings.Contains(markdown, "| `main.parse` |") {
+		t.Errorf("Unexpected markdown section (%v):\n%s", err, markdown)
+	}
+
+	output, err := analyzer.FormatCaptureChange(change, "json")
+	if err != nil {
+		t.Fatalf("FormatCaptureChange failed: %v", err)
+	}
+	var decoded map[string]analyzer.CaptureChange
+	if err := json.Unmarshal([]byte(output), &decoded); err != nil || decoded["changeSinceLastCapture"].Diff == nil {
+		t.Errorf("Unexpected JSON (%v): %s", err, output)
+	}
+
+	if _, err := analyzer.FormatCaptureChange(change, "folded"); err == nil {
+		t.Errorf("Expected an error for an unsupported format")
+	}
+}
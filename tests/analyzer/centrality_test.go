@@ -0,0 +1,66 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestCallGraphCentrality(t *testing.T) {
+	// Two handlers both go through main.decode, which spreads the cost over two parsers
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{3, 300}, "main.parseHeader", "main.decode", "main.handleA", "main.main"),
+		stackSample([]int64{2, 200}, "main.parseBody", "main.decode", "main.handleB", "main.main"),
+		stackSample([]int64{1, 100}, "main.parseBody", "main.decode", "main.decode", "main.handleA", "main.main"),
+		stackSample([]int64{1, 100}, "main.log", "main.handleB", "main.main"),
+		stackSample([]int64{3, 300}, "runtime.gcBgMarkWorker"),
+	))
+
+	functions, total, err := analyzer.CallGraphCentrality(p, 1)
+	if err != nil {
+		t.Fatalf("CallGraphCentrality failed: %v", err)
+	}
+	if total != 1000 {
+		t.Fatalf("Expected a total of 1000, got %d", total)
+	}
+	byName := make(map[string]analyzer.FunctionCentrality)
+	for _, c := range functions {
+		byName[c.Name] = c
+	}
+	// The root and the leaves pass no cost through; recursion counts once per sample
+	decode := byName["main.decode"]
+	if decode.Through != 600 || decode.Betweenness != 0.6 || decode.FanIn != 2 || decode.FanOut != 2 || decode.Role != "chokepoint" {
+		t.Errorf("Unexpected main.decode: %+v", decode)
+	}
+	if byName["main.main"].Through != 0 || byName["main.parseBody"].Through != 0 || byName["runtime.gcBgMarkWorker"].Through != 0 {
+		t.Errorf("Expected no flow-through for roots and leaves: %+v", functions)
+	}
+	if c := byName["main.handleB"]; c.Through != 300 || c.Role != "dispatcher" || c.FanIn != 1 {
+		t.Errorf("Unexpected main.handleB: %+v", c)
+	}
+	if c := byName["main.handleA"]; c.Role != "chain" {
+		t.Errorf("Expected main.handleA to be a chain, got %+v", c)
+	}
+	if functions[0].Name != "main.decode" {
+		t.Errorf("Expected main.decode first, got %s", functions[0].Name)
+	}
+
+	text, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(3), analyzer.WithFormat("centrality"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{"Top 3 Functions by Flow-through", "=== Chokepoints", "- main.decode (chokepoint): 60.00% of the cost flows through it from 2 callers to 2 callees"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "main.parseBody") {
+		t.Errorf("Expected only the top 3 functions:\n%s", text)
+	}
+
+	flat := withLocationTable(cpuProfile(stackSample([]int64{1, 100}, "main.work", "main.main")))
+	if text, err := analyzer.Analyze(flat, "cpu", analyzer.WithFormat("centrality")); err != nil || !strings.Contains(text, "None:") {
+		t.Errorf("Expected no chokepoints for a single stack, got %q (%v)", text, err)
+	}
+}
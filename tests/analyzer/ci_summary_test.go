@@ -0,0 +1,92 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestInferProfileType(t *testing.T) {
+	fullHeap := &profile.Profile{SampleType: []*profile.ValueType{
+		{Type: "alloc_objects", Unit: "count"}, {Type: "alloc_space", Unit: "bytes"},
+		{Type: "inuse_objects", Unit: "count"}, {Type: "inuse_space", Unit: "bytes"},
+	}}
+	tests := []struct {
+		name string
+		p    *profile.Profile
+		file string
+		want string
+	}{
+		{"cpu", cpuProfile(), "cpu.out", "cpu"},
+		{"heap", fullHeap, "mem.out", "heap"},
+		{"allocs by name", fullHeap, "ci/allocs.pb.gz", "allocs"},
+		{"mutex", contentionProfile(), "lock.prof", "mutex"},
+		{"block by name", contentionProfile(), "pkg/block.out", "block"},
+		{"goroutine", &profile.Profile{SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}}}, "g.pprof", "goroutine"},
+		{"unknown", &profile.Profile{SampleType: []*profile.ValueType{{Type: "events", Unit: "count"}}}, "x.pprof", ""},
+	}
+	for _, tt := range tests {
+		if got := analyzer.InferProfileType(tt.p, tt.file); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFormatCISummary(t *testing.T) {
+	cpu := cpuProfile(
+		stackSample([]int64{3, 300}, "main.parse", "main.main"),
+		stackSample([]int64{1, 100}, "main.handle", "main.main"),
+	)
+	summary := &analyzer.CISummary{
+		Source: "test.json",
+		Packages: []analyzer.CIPackage{
+			{Package: "example.com/app", Status: "fail", Profiles: []analyzer.CIProfile{
+				analyzer.SummarizeCIProfile(cpu, "cpu.pprof", 1),
+				{Path: "missing.pprof", Error: "no such file"},
+			}},
+		},
+		Skipped: 2,
+	}
+	if p := summary.Packages[0].Profiles[0]; p.ProfileType != "cpu" || p.Total != 400 || len(p.Functions) != 1 || p.Functions[0].FunctionName != "main.parse" || p.Functions[0].Percentage != 75 {
+		t.Fatalf("Unexpected profile summary: %+v", p)
+	}
+
+	text, err := analyzer.FormatCISummary(summary, "text")
+	if err != nil {
+		t.Fatalf("FormatCISummary failed: %v", err)
+	}
+	for _, want := range []string{
+		"CI Profile Summary: 2 profile(s) in 1 package(s) from test.json",
+		"1 profile(s) could not be analyzed.",
+		"2 more profile(s) were found but not analyzed",
+		"=== example.com/app (fail) ===",
+		"cpu.pprof: cpu profile, total 400ns cpu",
+		"main.parse",
+		"missing.pprof: ERROR: no such file",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the text summary:\n%s", want, text)
+		}
+	}
+
+	markdown, err := analyzer.FormatCISummary(summary, "markdown")
+	if err != nil || !strings.Contains(markdown, "#### example.com/app (fail)") || !strings.Contains(markdown, "| `main.parse` |") {
+		t.Errorf("Unexpected markdown summary (%v):\n%s", err, markdown)
+	}
+
+	jsonOutput, err := analyzer.FormatCISummary(summary, "json")
+	if err != nil {
+		t.Fatalf("FormatCISummary failed: %v", err)
+	}
+	var decoded analyzer.CISummary
+	if err := json.Unmarshal([]byte(jsonOutput), &decoded); err != nil || len(decoded.Packages) != 1 || decoded.Packages[0].Profiles[1].Error != "no such file" {
+		t.Errorf("Unexpected JSON summary (%v): %s", err, jsonOutput)
+	}
+
+	if _, err := analyzer.FormatCISummary(summary, "folded"); err == nil {
+		t.Errorf("Expected an error for an unsupported format")
+	}
+}
@@ -0,0 +1,66 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestCPUCumulativeValues(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{3, 300}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{2, 200}, "main.encode", "main.handle", "main.main"),
+		stackSample([]int64{4, 400}, "runtime.gcBgMarkWorker"),
+		// Recursion counts once per sample
+		stackSample([]int64{1, 100}, "main.walk", "main.walk", "main.walk", "main.main"),
+	))
+
+	output, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(10), analyzer.WithFormat("json"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var result analyzer.CPUAnalysisResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if result.SortBy != "flat" || len(result.Functions) != 4 || result.Functions[0].FunctionName != "runtime.gcBgMarkWorker" {
+		t.Fatalf("Expected the leaf functions sorted by flat value, got %+v", result)
+	}
+	for _, f := range result.Functions {
+		want := map[string]int64{"runtime.gcBgMarkWorker": 400, "main.parse": 300, "main.encode": 200, "main.walk": 100}[f.FunctionName]
+		if f.CumValue != want {
+			t.Errorf("Expected cum %d for %s, got %d", want, f.FunctionName, f.CumValue)
+		}
+	}
+
+	output, err = analyzer.Analyze(p, "cpu", analyzer.WithTopN(3), analyzer.WithFormat("json"), analyzer.WithSortBy("cum"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	result = analyzer.CPUAnalysisResult{}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	names := make([]string, 0, len(result.Functions))
+	for _, f := range result.Functions {
+		names = append(names, f.FunctionName)
+	}
+	if got := strings.Join(names, ","); got != "main.main,main.handle,runtime.gcBgMarkWorker" {
+		t.Errorf("Expected functions sorted by cum value, got %s", got)
+	}
+	if f := result.Functions[0]; f.FlatValue != 0 || f.CumValue != 600 || f.CumPercentage != 60 {
+		t.Errorf("Expected main.main with no flat and 60%% cum, got %+v", f)
+	}
+
+	text, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(3), analyzer.WithSortBy("cum"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{"Top 3 Functions by Cum Time", "Cum Time", "Flat%", "main.handle"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
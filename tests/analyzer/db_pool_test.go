@@ -0,0 +1,63 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func contentionProfile(samples ...*profile.Sample) *profile.Profile {
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "contentions", Unit: "count"},
+			{Type: "delay", Unit: "nanoseconds"},
+		},
+		Sample: samples,
+	}
+}
+
+func TestAnalyzeDBPoolContention(t *testing.T) {
+	profiles := analyzer.DBPoolProfiles{
+		Block: contentionProfile(
+			stackSample([]int64{30, 3e9}, "runtime.selectgo", "database/sql.(*DB).conn", "database/sql.(*DB).query", "database/sql.(*DB).QueryContext", "main.listUsers"),
+			stackSample([]int64{5, 1e9}, "runtime.chanrecv1", "main.worker"),
+		),
+		Goroutine: goroutineProfile(
+			stackSample([]int64{42}, "runtime.gopark", "runtime.selectgo", "database/sql.(*DB).conn", "database/sql.(*DB).query", "main.listUsers"),
+			stackSample([]int64{1}, "runtime.gopark", "runtime.selectgo", "database/sql.(*DB).connectionOpener", "runtime.goexit"),
+		),
+	}
+
+	output, err := analyzer.AnalyzeDBPoolContention(profiles, 10, "json")
+	if err != nil {
+		t.Fatalf("AnalyzeDBPoolContention returned error: %v", err)
+	}
+	var result analyzer.DBPoolContentionResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Failed to unmarshal JSON result: %v", err)
+	}
+
+	if !result.PoolExhaustionSuspected {
+		t.Errorf("Expected pool exhaustion to be suspected, verdict: %s", result.Verdict)
+	}
+	if result.BlockDelay != 3e9 || result.BlockContentions != 30 {
+		t.Errorf("Expected 3s block delay over 30 contentions, got %d over %d", result.BlockDelay, result.BlockContentions)
+	}
+	if result.BlockDelayShare != 75 {
+		t.Errorf("Expected block delay share of 75%%, got %.2f", result.BlockDelayShare)
+	}
+	if result.WaitingGoroutines != 42 || result.ConnectionOpeners != 1 {
+		t.Errorf("Expected 42 waiting goroutines and 1 opener, got %d and %d", result.WaitingGoroutines, result.ConnectionOpeners)
+	}
+	for _, site := range result.Sites {
+		if site.Site != "main.listUsers at file.go:50" {
+			t.Errorf("Expected waits to be attributed to main.listUsers, got %q (%s)", site.Site, site.Source)
+		}
+	}
+
+	if _, err := analyzer.AnalyzeDBPoolContention(analyzer.DBPoolProfiles{}, 10, "text"); err == nil {
+		t.Error("Expected an error when no profiles are provided")
+	}
+}
@@ -0,0 +1,176 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+const heapDebugText = `heap profile: 3: 3072 [5: 5120] @ heap/1048576
+2: 2048 [3: 3072] @ 0x47d305 0x51bee5 0x51be69 0x44b327 0x484801
+#	0x51bee4	main.alloc+0xa4		/app/main.go:18
+#	0x51bee4	main.fill+0x10		/app/main.go:30
+#	0x51be68	main.main+0x28		/app/main.go:24
+#	0x44b326	runtime.main+0x426	/usr/local/go/src/runtime/proc.go:283
+
+1: 1024 [2: 2048] @ 0x47d305 0x51c0be 0x44b327 0x484801
+#	0x51c0bd	main.main+0x27d		/app/main.go:32
+#	0x44b326	runtime.main+0x426	/usr/local/go/src/runtime/proc.go:283
+
+0: 0 [1: 16] @ 0x47d305 0x41d685
+#	0x47d304	runtime.mallocgc+0x104	/usr/local/go/src/runtime/malloc.go:1131
+#	0x41d684	runtime.newobject+0x24	/usr/local/go/src/runtime/malloc.go:1700
+
+
+# runtime.MemStats
+# Alloc = 3072
+`
+
+const goroutineDump = `goroutine 1 [running]:
+main.main()
+	/app/main.go:33 +0x2b1
+
+goroutine 7 [chan receive, 5 minutes]:
+main.(*worker).run(0xc000010000, {0x64e8e8?, 0xc00001c000?})
+	/app/worker.go:41 +0x45
+created by main.main in goroutine 1
+	/app/main.go:25 +0x12a
+
+goroutine 8 [chan receive, 5 minutes]:
+main.(*worker).run(0xc000010010, {0x64e8e8?, 0xc00001c008?})
+	/app/worker.go:41 +0x45
+created by main.main in goroutine 1
+	/app/main.go:25 +0x12a
+
+goroutine 10 [chan receive, 95 minutes, locked to thread]:
+main.(*worker).run(0xc000010020, {0x64e8e8?, 0xc00001c010?})
+	/app/worker.go:41 +0x45
+created by main.main in goroutine 1
+	/app/main.go:25 +0x12a
+
+goroutine 11 [chan receive]:
+main.(*worker).run(0xc000010030, {0x64e8e8?, 0xc00001c018?})
+	/app/worker.go:41 +0x45
+created by main.main in goroutine 1
+	/app/main.go:25 +0x12a
+
+goroutine 9 gp=0xc000007c00 m=nil [select]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:435 +0xce fp=0xc000057f38 sp=0xc000057f18 pc=0x47c4ee
+main.poll(...)
+	/app/poll.go:12
+...additional frames elided...
+`
+
+func TestParseDebugTextHeap(t *testing.T) {
+	if !analyzer.IsDebugText([]byte(heapDebugText)) || !analyzer.IsDebugText([]byte(goroutineDump)) {
+		t.Fatalf("Expected the debug text dumps to be detected")
+	}
+	for _, other := range []string{"heap profile: 1: 2 [3: 4] @ heap/1\n1: 2 [3: 4] @ 0x1 0x2\n", "main.main;main.work 10\n", "goroutine profile: total 1\n", ""} {
+		if analyzer.IsDebugText([]byte(other)) {
+			t.Errorf("Expected %q not to be detected as a debug text dump", other)
+		}
+	}
+
+	p, err := analyzer.ParseDebugText([]byte(heapDebugText))
+	if err != nil {
+		t.Fatalf("ParseDebugText failed: %v", err)
+	}
+	// The runtime frames hidden by debug=1 are dropped, inlined frames keep the callee first
+	leaf := p.Sample[0].Location[0]
+	if len(leaf.Line) != 2 || leaf.Line[0].Function.Name != "main.alloc" || leaf.Line[1].Function.Name != "main.fill" || leaf.Line[0].Line != 18 {
+		t.Fatalf("Unexpected leaf location: %+v", leaf.Line)
+	}
+	if leaf.Line[0].Function.Filename != "/app/main.go" {
+		t.Errorf("Expected the file name to be kept, got %q", leaf.Line[0].Function.Filename)
+	}
+	if got := p.Sample[1].Location[0].Line[0].Function.Name; got != "main.main" {
+		t.Errorf("Expected the second stack to start at main.main, got %s", got)
+	}
+	// A stack of runtime frames only is kept as printed
+	if got := p.Sample[2].Location[0].Line[0].Function.Name; got != "runtime.mallocgc" {
+		t.Errorf("Expected a runtime-only stack to keep runtime.mallocgc, got %s", got)
+	}
+
+	text := mustAnalyze(t, p)
+	if !strings.Contains(text, "main.alloc") || strings.Contains(text, "0x51bee4") {
+		t.Errorf("Expected a symbolized heap analysis:\n%s", text)
+	}
+}
+
+func TestParseGoroutineDump(t *testing.T) {
+	p, err := analyzer.ParseDebugText([]byte(goroutineDump))
+	if err != nil {
+		t.Fatalf("ParseDebugText failed: %v", err)
+	}
+	if p.SampleType[0].Type != "goroutine" || len(p.Sample) != 5 {
+		t.Fatalf("Expected 5 distinct goroutine samples, got %d", len(p.Sample))
+	}
+	// Workers merge when their wait duration matches; "created by" is a label, not a frame
+	workers := p.Sample[1]
+	if workers.Value[0] != 2 || workers.Label["state"][0] != "chan receive" || workers.Label["created_by"][0] != "main.main" || len(workers.Location) != 1 {
+		t.Errorf("Unexpected worker sample: %v %v (%d frames)", workers.Value, workers.Label, len(workers.Location))
+	}
+	if workers.NumLabel["wait_minutes"][0] != 5 || workers.NumUnit["wait_minutes"][0] != "minutes" {
+		t.Errorf("Expected a wait of 5 minutes, got %v %v", workers.NumLabel, workers.NumUnit)
+	}
+	if p.Sample[2].NumLabel["wait_minutes"][0] != 95 || p.Sample[3].NumLabel != nil {
+		t.Errorf("Unexpected waits: %v, %v", p.Sample[2].NumLabel, p.Sample[3].NumLabel)
+	}
+	if got := workers.Location[0].Line[0]; got.Function.Name != "main.(*worker).run" || got.Function.Filename != "/app/worker.go" || got.Line != 41 {
+		t.Errorf("Unexpected worker frame: %s %s:%d", got.Function.Name, got.Function.Filename, got.Line)
+	}
+	// GOTRACEBACK=system fields and "(...)" arguments are handled, elided frames skipped
+	polling := p.Sample[4]
+	if polling.Label["state"][0] != "select" || len(polling.Location) != 2 || polling.Location[1].Line[0].Function.Name != "main.poll" || polling.Location[1].Line[0].Line != 12 {
+		t.Errorf("Unexpected polling sample: %v (%d frames)", polling.Label, len(polling.Location))
+	}
+
+	if _, err := analyzer.ParseGoroutineDump([]byte("goroutine 1 [running]:\n")); err == nil {
+		t.Errorf("Expected an error for a dump without stacks")
+	}
+}
+
+func TestAnalyzeGoroutineDump(t *testing.T) {
+	p, err := analyzer.ParseGoroutineDump([]byte(goroutineDump))
+	if err != nil {
+		t.Fatalf("ParseGoroutineDump failed: %v", err)
+	}
+
+	states, groups := analyzer.SummarizeGoroutineWaits(p)
+	if len(states) != 3 || states[0].State != "chan receive" || states[0].Count != 4 || states[0].Waiting != 3 || states[0].MaxWaitMinutes != 95 {
+		t.Fatalf("Unexpected states: %+v", states)
+	}
+	// Workers with different waits form one group, with the range of their waits
+	if groups[0].Count != 4 || groups[0].MinWaitMinutes != 0 || groups[0].MaxWaitMinutes != 95 || groups[0].CreatedBy != "main.main" {
+		t.Errorf("Unexpected longest-waiting group: %+v", groups[0])
+	}
+
+	// The runtime's states replace the ones inferred from the stacks: runtime.gopark would be "other wait"
+	text, err := analyzer.Analyze(p, "goroutine", analyzer.WithFormat("text"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{
+		"States: 6 total: 4 chan receive, 1 running, 1 select",
+		"=== By State (from goroutine dump) ===",
+		"chan receive             4            3                95 minutes (1h35m)",
+		"=== Longest-Waiting Stacks ===",
+		"4 goroutines in chan receive, waiting up to 95 minutes (1h35m), created by main.main (1 frames):",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+
+	jsonText, err := analyzer.Analyze(p, "goroutine", analyzer.WithFormat("json"))
+	if err != nil || !strings.Contains(jsonText, `"longestWaiting"`) || !strings.Contains(jsonText, `"maxWaitMinutes": 95`) {
+		t.Errorf("Expected the waits in the JSON output, got %v:\n%s", err, jsonText)
+	}
+
+	// Sampled goroutine profiles have no wait durations
+	if text, err := analyzer.Analyze(goroutineProfile(stackSample([]int64{1}, "runtime.gopark")), "goroutine", analyzer.WithFormat("text")); err != nil || strings.Contains(text, "Longest-Waiting") {
+		t.Errorf("Expected no wait sections for a goroutine profile, got %v:\n%s", err, text)
+	}
+}
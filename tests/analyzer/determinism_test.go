@@ -0,0 +1,122 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+// typedSample labels a heap sample with its object type.
+func typedSample(typeName string, values []int64, funcs ...string) *profile.Sample {
+	s := stackSample(values, funcs...)
+	s.Label = map[string][]string{"type": {typeName}}
+	return s
+}
+
+// tiedProfiles returns profiles whose functions, types and stacks all have equal values, so that their order
+// in the output depends only on the tie-breakers.
+func tiedProfiles() map[string]*profile.Profile {
+	return map[string]*profile.Profile{
+		"cpu": withLocationTable(cpuProfile(
+			stackSample([]int64{1, 100}, "main.delta", "main.run"),
+			stackSample([]int64{1, 100}, "main.alpha", "main.run"),
+			stackSample([]int64{1, 100}, "main.charlie", "main.run"),
+			stackSample([]int64{1, 100}, "main.bravo", "main.run"),
+		)),
+		"heap": withLocationTable(heapProfile(
+			typedSample("*main.D", []int64{2, 256}, "main.newD", "main.run"),
+			typedSample("*main.A", []int64{2, 256}, "main.newA", "main.run"),
+			typedSample("*main.C", []int64{2, 256}, "main.newC", "main.run"),
+			typedSample("*main.B", []int64{2, 256}, "main.newB", "main.run"),
+		)),
+		"goroutine": withLocationTable(goroutineProfile(
+			stackSample([]int64{5}, "runtime.gopark", "main.workerD"),
+			stackSample([]int64{5}, "runtime.gopark", "main.workerA"),
+			stackSample([]int64{5}, "runtime.gopark", "main.workerC"),
+			stackSample([]int64{5}, "runtime.gopark", "main.workerB"),
+		)),
+		"threadcreate": withLocationTable(&profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "threadcreate", Unit: "count"}},
+			Sample: []*profile.Sample{
+				stackSample([]int64{3}, "runtime.newm", "runtime.cgocall", "main.d"),
+				stackSample([]int64{3}, "runtime.newm", "syscall.Syscall", "main.a"),
+				stackSample([]int64{3}, "runtime.newm", "runtime.cgocall", "main.b"),
+				stackSample([]int64{3}, "runtime.newm", "syscall.Syscall", "main.c"),
+			},
+		}),
+	}
+}
+
+func TestAnalyzeOutputIsDeterministic(t *testing.T) {
+	for profileType, p := range tiedProfiles() {
+		for _, format := range []string{"text", "markdown-compact", "json"} {
+			first, err := analyzer.Analyze(p, profileType, analyzer.WithTopN(10), analyzer.WithFormat(format))
+			if err != nil {
+				t.Fatalf("Analyze(%s, %s) failed: %v", profileType, format, err)
+			}
+			for i := 0; i < 20; i++ {
+				output, err := analyzer.Analyze(p, profileType, analyzer.WithTopN(10), analyzer.WithFormat(format))
+				if err != nil {
+					t.Fatalf("Analyze(%s, %s) failed: %v", profileType, format, err)
+				}
+				if output != first {
+					t.Fatalf("Analyze(%s, %s) output changed between runs:\n%s\n---\n%s", profileType, format, first, output)
+				}
+			}
+		}
+	}
+}
+
+func TestTiesAreOrderedByName(t *testing.T) {
+	profiles := tiedProfiles()
+	tests := []struct {
+		profileType string
+		ordered     []string
+	}{
+		{"cpu", []string{"main.alpha", "main.bravo", "main.charlie", "main.delta"}},
+		{"heap", []string{"*main.A", "*main.B", "*main.C", "*main.D"}},
+		{"goroutine", []string{"main.workerA", "main.workerB", "main.workerC", "main.workerD"}},
+		{"threadcreate", []string{"main.b", "main.d", "main.a", "main.c"}}, // Causes first: cgo call, then blocking syscall
+	}
+	for _, tt := range tests {
+		t.Run(tt.profileType, func(t *testing.T) {
+			output, err := analyzer.Analyze(profiles[tt.profileType], tt.profileType, analyzer.WithTopN(10), analyzer.WithFormat("text"))
+			if err != nil {
+				t.Fatalf("Analyze failed: %v", err)
+			}
+			assertOrdered(t, output, tt.ordered)
+		})
+	}
+}
+
+func TestMemoryLeakTiesAreOrderedByType(t *testing.T) {
+	oldProfile := heapProfile(typedSample("*main.Z", []int64{1, 100}, "main.newZ"))
+	newProfile := heapProfile(
+		typedSample("*main.Z", []int64{2, 200}, "main.newZ"),
+		typedSample("*main.B", []int64{1, 100}, "main.newB"),
+		typedSample("*main.A", []int64{1, 100}, "main.newA"),
+	)
+	output, err := analyzer.DetectPotentialMemoryLeaks(oldProfile, newProfile, 0.1, 10)
+	if err != nil {
+		t.Fatalf("DetectPotentialMemoryLeaks failed: %v", err)
+	}
+	assertOrdered(t, output, []string{"*main.A", "*main.B", "*main.Z"})
+}
+
+// assertOrdered checks that every string occurs in output, in the given order.
+func assertOrdered(t *testing.T, output string, ordered []string) {
+	t.Helper()
+	last := -1
+	for _, s := range ordered {
+		i := strings.Index(output, s)
+		if i < 0 {
+			t.Fatalf("Expected %q in:\n%s", s, output)
+		}
+		if i < last {
+			t.Fatalf("Expected %v in this order in:\n%s", ordered, output)
+		}
+		last = i
+	}
+}
@@ -0,0 +1,71 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestDiffProfiles(t *testing.T) {
+	oldProfile := withLocationTable(contentionProfile(
+		stackSample([]int64{10, 1000}, "sync.(*Mutex).Lock", "main.cache"),
+		stackSample([]int64{5, 500}, "sync.(*Mutex).Unlock", "main.flush"),
+		stackSample([]int64{2, 200}, "sync.(*RWMutex).RLock", "main.lookup"),
+	))
+	newProfile := withLocationTable(contentionProfile(
+		stackSample([]int64{30, 4000}, "sync.(*Mutex).Lock", "main.cache"),
+		stackSample([]int64{5, 100}, "sync.(*Mutex).Unlock", "main.flush"),
+		stackSample([]int64{1, 300}, "sync.(*Mutex).Lock", "main.register"),
+	))
+
+	diff, err := analyzer.DiffProfiles(oldProfile, newProfile, "contention", "", "flat", 10)
+	if err != nil {
+		t.Fatalf("DiffProfiles failed: %v", err)
+	}
+	if diff.ProfileType != "mutex" || diff.SampleType != "delay" || diff.OldTotal != 1700 || diff.NewTotal != 4400 {
+		t.Fatalf("Expected the delay of the mutex profiles, got %+v", diff)
+	}
+	// sync.(*Mutex).Lock grows by 3000 + 300; the RWMutex is gone and Unlock shrank
+	if len(diff.Regressions) != 1 || diff.Regressions[0].Name != "sync.(*Mutex).Lock" || diff.Regressions[0].Delta != 3300 || diff.Regressions[0].DeltaPercent != 330 {
+		t.Errorf("Unexpected regressions: %+v", diff.Regressions)
+	}
+	names := make([]string, 0, len(diff.Improvements))
+	for _, d := range diff.Improvements {
+		names = append(names, d.Name)
+	}
+	if got := strings.Join(names, ","); got != "sync.(*Mutex).Unlock,sync.(*RWMutex).RLock" {
+		t.Errorf("Expected improvements sorted by size, got %s", got)
+	}
+
+	diff, err = analyzer.DiffProfiles(oldProfile, newProfile, "mutex", "contentions", "cum", 1)
+	if err != nil {
+		t.Fatalf("DiffProfiles failed: %v", err)
+	}
+	if diff.Regressed != 3 || len(diff.Regressions) != 1 || diff.Regressions[0].Name != "sync.(*Mutex).Lock" || diff.Regressions[0].Delta != 21 {
+		t.Errorf("Expected the top cum regression in contentions, got %+v", diff.Regressions)
+	}
+
+	output, err := analyzer.FormatProfileDiff(diff, "json")
+	if err != nil {
+		t.Fatalf("FormatProfileDiff failed: %v", err)
+	}
+	var parsed analyzer.ProfileDiffResult
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil || parsed.Regressions[0].DeltaFormatted != "+21" {
+		t.Errorf("Expected a JSON diff, got %s (%v)", output, err)
+	}
+	text, err := analyzer.FormatProfileDiff(diff, "text")
+	if err != nil {
+		t.Fatalf("FormatProfileDiff failed: %v", err)
+	}
+	for _, want := range []string{"Profile Diff: mutex (contentions, cum values)", "Regressions (showing 1 of 3)", "+21"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+
+	if _, err := analyzer.DiffProfiles(oldProfile, newProfile, "cpu", "", "flat", 10); err == nil || !strings.Contains(err.Error(), "not a cpu profile") {
+		t.Errorf("Expected a profile type mismatch error, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package analyzer_test
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestDownsample(t *testing.T) {
+	p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}}}
+	var total int64
+	for i := 0; i < 1000; i++ {
+		v := int64(10 + i%7)
+		if i == 0 {
+			v = 100000 // Hotspot that must survive unchanged
+		}
+		total += v
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{{Line: []profile.Line{{Function: &profile.Function{Name: fmt.Sprintf("main.f%d", i)}}}}},
+			Value:    []int64{1, v},
+		})
+	}
+
+	downsampled, stats, err := analyzer.Downsample(p, 1, 100, 42)
+	if err != nil {
+		t.Fatalf("Downsample failed: %v", err)
+	}
+	if stats.OriginalSamples != 1000 || stats.KeptSamples < 50 || stats.KeptSamples > 150 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	var kept int64
+	for _, s := range downsampled.Sample {
+		kept += s.Value[1]
+	}
+	if kept != total {
+		t.Errorf("Expected the total %d to be preserved, got %d", total, kept)
+	}
+	if downsampled.Sample[0] != p.Sample[0] {
+		t.Error("Expected the hotspot to be kept unchanged")
+	}
+	if len(p.Sample) != 1000 || p.Sample[1].Value[1] != 11 {
+		t.Error("Expected the original profile to be left unmodified")
+	}
+
+	// The same seed gives the same result
+	again, _, _ := analyzer.Downsample(p, 1, 100, 42)
+	if len(again.Sample) != len(downsampled.Sample) || !reflect.DeepEqual(again.Sample[len(again.Sample)-1].Value, downsampled.Sample[len(downsampled.Sample)-1].Value) {
+		t.Error("Expected downsampling to be deterministic for a seed")
+	}
+
+	small, stats, err := analyzer.Downsample(p, 1, 5000, 42)
+	if err != nil || small != p || stats.KeptSamples != 1000 {
+		t.Errorf("Expected a profile within the budget to be returned unchanged, got %+v, %v", stats, err)
+	}
+}
+
+func TestDownsampleTotals(t *testing.T) {
+	// sample has a count of about a tenth of its value, with the same sign, so both sample types scale alike
+	sample := func(i int, v int64) *profile.Sample {
+		return &profile.Sample{
+			Location: []*profile.Location{{Line: []profile.Line{{Function: &profile.Function{Name: fmt.Sprintf("main.f%d", i)}}}}},
+			Value:    []int64{v / 10, v},
+		}
+	}
+	cases := []struct {
+		name       string
+		maxSamples int
+		values     func(i int) int64
+		n          int
+		unchanged  int // Expected number of samples kept unchanged, or -1
+	}{
+		{
+			// The budget is used up by the non-zero samples, which are all kept
+			name: "ExactlyMaxSamplesHeavy", maxSamples: 100, n: 600, unchanged: 100,
+			values: func(i int) int64 {
+				if i%6 == 0 {
+					return 1000 + int64(i)
+				}
+				return 0
+			},
+		},
+		{
+			name: "MaxSamplesHeavyAndLight", maxSamples: 100, n: 5100, unchanged: -1,
+			values: func(i int) int64 {
+				if i < 100 {
+					return 1000000
+				}
+				return 100 + int64(i%7)*10
+			},
+		},
+		{
+			name: "MixedSigns", maxSamples: 200, n: 3000, unchanged: -1,
+			values: func(i int) int64 {
+				v := 100 + int64(i%13)*10
+				if i%25 == 0 {
+					v = 500000 // Hotspots of both signs
+				}
+				if i%2 == 1 {
+					v = -v
+				}
+				return v
+			},
+		},
+		{
+			// The few positive values are too light to be sampled, but their sum is still preserved
+			name: "FewPositive", maxSamples: 50, n: 2000, unchanged: -1,
+			values: func(i int) int64 {
+				if i%500 == 0 {
+					return 20
+				}
+				return -1000 - int64(i%5)*100
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}}}
+			for i := 0; i < tc.n; i++ {
+				p.Sample = append(p.Sample, sample(i, tc.values(i)))
+			}
+			sums := func(samples []*profile.Sample) (positive, negative, count int64) {
+				for _, s := range samples {
+					if s.Value[1] >= 0 {
+						positive += s.Value[1]
+					} else {
+						negative += s.Value[1]
+					}
+					count += s.Value[0]
+				}
+				return positive, negative, count
+			}
+			wantPositive, wantNegative, wantCount := sums(p.Sample)
+
+			downsampled, stats, err := analyzer.Downsample(p, 1, tc.maxSamples, 7)
+			if err != nil {
+				t.Fatalf("Downsample failed: %v", err)
+			}
+			if limit := tc.maxSamples*13/10 + 2; stats.KeptSamples > limit || stats.KeptSamples != len(downsampled.Sample) {
+				t.Errorf("Expected at most %d samples, got %+v", limit, stats)
+			}
+			positive, negative, count := sums(downsampled.Sample)
+			if positive != wantPositive || negative != wantNegative {
+				t.Errorf("Expected the positive and negative totals %d and %d to be preserved, got %d and %d", wantPositive, wantNegative, positive, negative)
+			}
+			if diff := math.Abs(float64(count - wantCount)); diff > 0.02*math.Abs(float64(wantCount))+float64(stats.KeptSamples) {
+				t.Errorf("Expected the count total %d to be preserved within 2%%, got %d", wantCount, count)
+			}
+
+			// Samples at or above the threshold are kept unchanged
+			original := make(map[*profile.Sample]bool)
+			for _, s := range p.Sample {
+				original[s] = true
+			}
+			unchanged := 0
+			for _, s := range downsampled.Sample {
+				if original[s] {
+					unchanged++
+				}
+			}
+			heavy := 0
+			for _, s := range p.Sample {
+				if v := s.Value[1]; v >= stats.Threshold || -v >= stats.Threshold {
+					heavy++
+				}
+			}
+			if unchanged != heavy || tc.unchanged >= 0 && unchanged != tc.unchanged {
+				t.Errorf("Expected the %d samples over the threshold %d to be kept unchanged, got %d", heavy, stats.Threshold, unchanged)
+			}
+		})
+	}
+}
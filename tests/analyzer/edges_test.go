@@ -0,0 +1,55 @@
+package analyzer_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestTopCallEdges(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{5, 500}, "runtime.mallocgc", "main.decode", "main.handle", "main.main"),
+		stackSample([]int64{2, 200}, "runtime.mallocgc", "main.encode", "main.handle", "main.main"),
+		stackSample([]int64{1, 100}, "main.walk", "main.walk", "main.main"),
+		stackSample([]int64{2, 200}, "runtime.gcBgMarkWorker"),
+	))
+
+	edges, total, err := analyzer.TopCallEdges(p, 1, 3, nil)
+	if err != nil {
+		t.Fatalf("TopCallEdges failed: %v", err)
+	}
+	if total != 1000 || len(edges) != 3 {
+		t.Fatalf("Expected 3 edges out of a total of 1000, got %d (%d)", len(edges), total)
+	}
+	if e := edges[0]; e.Caller != "main.main" || e.Callee != "main.handle" || e.Weight != 700 || e.Percent != 70 || e.CalleePercent != 100 {
+		t.Errorf("Unexpected heaviest edge: %+v", e)
+	}
+
+	// Callers of runtime.mallocgc, with their share of its cost; recursion is not an edge
+	edges, _, err = analyzer.TopCallEdges(p, 1, 0, regexp.MustCompile(`^runtime\.mallocgc$`))
+	if err != nil {
+		t.Fatalf("TopCallEdges failed: %v", err)
+	}
+	if len(edges) != 2 || edges[0].Caller != "main.decode" || edges[0].CalleePercent != 500.0/7 || edges[1].Caller != "main.encode" {
+		t.Errorf("Unexpected edges into runtime.mallocgc: %+v", edges)
+	}
+	if edges, _, _ := analyzer.TopCallEdges(p, 1, 0, regexp.MustCompile(`main\.walk`)); len(edges) != 1 || edges[0].Caller != "main.main" {
+		t.Errorf("Expected the self edge of main.walk to be left out: %+v", edges)
+	}
+
+	text, err := analyzer.Analyze(p, "cpu", analyzer.WithTopN(5), analyzer.WithFormat("edges"),
+		analyzer.WithFilters(analyzer.Filters{Focus: "mallocgc"}))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{"Top 5 Call Edges into Functions Matching 'mallocgc'", "Total cpu (nanoseconds): 700ns", "500ns           71.43      71.43      main.decode -> runtime.mallocgc"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "main.main -> main.handle") {
+		t.Errorf("Expected only the edges into runtime.mallocgc:\n%s", text)
+	}
+}
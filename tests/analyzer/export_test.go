@@ -0,0 +1,42 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestPrepareProfile(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{1, 300}, "main.parse", "main.main"),
+		stackSample([]int64{1, 100}, "main.log", "main.main"),
+	))
+
+	exported, stats, err := analyzer.PrepareProfile(p, analyzer.WithFilters(analyzer.Filters{Ignore: "main\\.log"}))
+	if err != nil {
+		t.Fatalf("PrepareProfile failed: %v", err)
+	}
+	if stats.OriginalSamples != 2 || stats.Samples != 1 || stats.Downsampled != nil {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	// Compaction drops the function no sample refers to any more
+	for _, f := range exported.Function {
+		if f.Name == "main.log" {
+			t.Errorf("Expected main.log to be dropped, got functions %v", exported.Function)
+		}
+	}
+	if len(p.Sample) != 2 {
+		t.Errorf("Expected the input profile to be unchanged, got %d samples", len(p.Sample))
+	}
+
+	unchanged, stats, err := analyzer.PrepareProfile(p)
+	if err != nil || stats.Samples != 2 || unchanged == p {
+		t.Errorf("Expected a compacted copy of the whole profile, got %+v (%v)", stats, err)
+	}
+	if _, _, err := analyzer.PrepareProfile(p, analyzer.WithSampleType("alloc_space")); err == nil {
+		t.Errorf("Expected an error for a missing sample type")
+	}
+	if _, _, err := analyzer.PrepareProfile(p, analyzer.WithFilters(analyzer.Filters{Tags: "a=b"})); err == nil {
+		t.Errorf("Expected an error when no sample is left")
+	}
+}
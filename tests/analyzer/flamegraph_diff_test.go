@@ -0,0 +1,112 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+type fg = analyzer.FlameGraphNode
+
+func TestDiffFlameGraphTrees(t *testing.T) {
+	// main.parse moves from main.handle to a new main.decode layer and grows, main.gc shrinks,
+	// main.cache is new code and main.log is gone
+	oldRoot := &fg{Name: "root", Value: 110, Children: []*fg{
+		{Name: "main.main", Value: 110, Children: []*fg{
+			{Name: "main.handle", Value: 60, Children: []*fg{
+				// Split by function ID, merged by name
+				{Name: "main.parse", Value: 25},
+				{Name: "main.parse", Value: 15},
+				{Name: "main.render", Value: 20},
+			}},
+			{Name: "main.gc", Value: 40},
+			{Name: "main.log", Value: 10},
+		}},
+	}}
+	newRoot := &fg{Name: "root", Value: 130, Children: []*fg{
+		{Name: "main.main", Value: 130, Children: []*fg{
+			{Name: "main.handle", Value: 20, Children: []*fg{
+				{Name: "main.render", Value: 20},
+			}},
+			{Name: "main.decode", Value: 50, Children: []*fg{
+				{Name: "main.parse", Value: 50},
+			}},
+			{Name: "main.gc", Value: 30},
+			{Name: "main.cache", Value: 30},
+		}},
+	}}
+
+	r, err := analyzer.DiffFlameGraphTrees(oldRoot, newRoot, "count", 0)
+	if err != nil {
+		t.Fatalf("DiffFlameGraphTrees failed: %v", err)
+	}
+	if r.TotalDelta != 20 || r.MovedCount != 1 || r.ValueChangedCount != 1 || r.AddedCount != 1 || r.RemovedCount != 1 {
+		t.Fatalf("Unexpected counts: %+v", r)
+	}
+	if m := r.Moves[0]; m.Name != "main.parse" || m.OldPath != "root;main.main;main.handle;main.parse" ||
+		m.NewPath != "root;main.main;main.decode;main.parse" || m.OldValue != 40 || m.NewValue != 50 || m.Similarity != 0.8 {
+		t.Errorf("Unexpected move: %+v", m)
+	}
+	if c := r.ValueChanges[0]; c.Path != "root;main.main;main.gc" || c.Delta != -10 {
+		t.Errorf("Unexpected value change: %+v", c)
+	}
+	// main.decode only holds the moved subtree, so it is not reported as added code
+	if a := r.Added[0]; a.Path != "root;main.main;main.cache" || a.NewValue != 30 {
+		t.Errorf("Unexpected added subtree: %+v", a)
+	}
+	if d := r.Removed[0]; d.Path != "root;main.main;main.log" || d.OldValue != 10 {
+		t.Errorf("Unexpected removed subtree: %+v", d)
+	}
+
+	t.Run("DissimilarSubtreesAreNotMoves", func(t *testing.T) {
+		oldRoot := &fg{Name: "root", Value: 100, Children: []*fg{{Name: "main.a", Value: 100, Children: []*fg{{Name: "main.x", Value: 100}}}}}
+		newRoot := &fg{Name: "root", Value: 10, Children: []*fg{{Name: "main.b", Value: 10, Children: []*fg{{Name: "main.x", Value: 10}}}}}
+		r, err := analyzer.DiffFlameGraphTrees(oldRoot, newRoot, "count", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.MovedCount != 0 || r.AddedCount != 1 || r.RemovedCount != 1 || r.Removed[0].Path != "root;main.a" {
+			t.Errorf("Expected main.a removed and main.b added, got %+v", r)
+		}
+	})
+
+	t.Run("Formats", func(t *testing.T) {
+		text, err := analyzer.FormatFlameGraphDiff(r, "text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(text, "from: root;main.main;main.handle;main.parse") || !strings.Contains(text, "Moved subtrees: 1, value changes: 1") {
+			t.Errorf("Unexpected text output:\n%s", text)
+		}
+		data, _ := analyzer.FormatFlameGraphDiff(r, "json")
+		var parsed analyzer.FlameGraphDiff
+		if err := json.Unmarshal([]byte(data), &parsed); err != nil || len(parsed.Moves) != 1 {
+			t.Errorf("Invalid JSON output: %v\n%s", err, data)
+		}
+		if _, err := analyzer.FormatFlameGraphDiff(r, "svg"); err == nil {
+			t.Error("Expected an error for an unsupported format")
+		}
+	})
+}
+
+func TestDiffFlameGraphs(t *testing.T) {
+	oldProfile := cpuProfile(stackSample([]int64{3, 300}, "main.work", "main.a", "main.main"))
+	newProfile := cpuProfile(stackSample([]int64{3, 290}, "main.work", "main.b", "main.main"))
+
+	r, err := analyzer.DiffFlameGraphs(oldProfile, newProfile, "cpu", "", 10)
+	if err != nil {
+		t.Fatalf("DiffFlameGraphs failed: %v", err)
+	}
+	if r.SampleType != "cpu" || r.MovedCount != 1 || r.AddedCount != 0 || r.RemovedCount != 0 {
+		t.Fatalf("Expected main.work to move from main.a to main.b, got %+v", r)
+	}
+	if m := r.Moves[0]; m.OldPath != "root;main.main;main.a;main.work" || m.NewPath != "root;main.main;main.b;main.work" {
+		t.Errorf("Unexpected move: %+v", m)
+	}
+
+	if _, err := analyzer.DiffFlameGraphs(oldProfile, newProfile, "heap", "", 10); err == nil {
+		t.Error("Expected an error when the profiles are not of the given type")
+	}
+}
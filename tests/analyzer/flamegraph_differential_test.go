@@ -0,0 +1,102 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// findDiffNode returns the node at the given path below root (root excluded), or nil.
+func findDiffNode(root *analyzer.DiffFlameGraphNode, path ...string) *analyzer.DiffFlameGraphNode {
+	node := root
+	for _, name := range path {
+		var next *analyzer.DiffFlameGraphNode
+		for _, c := range node.Children {
+			if c.Name == name {
+				next = c
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+func TestDiffFlameGraph(t *testing.T) {
+	// Unique function IDs per frame: the differential tree merges frames by name, not ID
+	base := withLocationTable(cpuProfile(
+		stackSample([]int64{1, 100}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{1, 50}, "main.handle", "main.main"),
+		stackSample([]int64{1, 50}, "main.log", "main.main"),
+	))
+	current := withLocationTable(cpuProfile(
+		stackSample([]int64{1, 250}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{1, 50}, "main.handle", "main.main"),
+		stackSample([]int64{1, 100}, "main.cache", "main.main"),
+	))
+
+	result, err := analyzer.DiffFlameGraph(base, current, "cpu", "", false)
+	if err != nil {
+		t.Fatalf("DiffFlameGraph failed: %v", err)
+	}
+	if result.SampleType != "cpu" || result.BaseTotal != 200 || result.NewTotal != 400 || result.Normalized {
+		t.Fatalf("Unexpected totals: %+v", result)
+	}
+
+	tests := []struct {
+		path                               []string
+		base, new, delta, selfDelta, value int64
+		deltaPercent                       float64
+	}{
+		{[]string{"main.main"}, 200, 400, 200, 0, 400, 100},
+		{[]string{"main.main", "main.handle"}, 150, 300, 150, 0, 300, 100},
+		{[]string{"main.main", "main.handle", "main.parse"}, 100, 250, 150, 150, 250, 150},
+		{[]string{"main.main", "main.cache"}, 0, 100, 100, 100, 100, 100},
+		{[]string{"main.main", "main.log"}, 50, 0, -50, -50, 0, -100},
+	}
+	for _, tt := range tests {
+		node := findDiffNode(result.Root, tt.path...)
+		if node == nil {
+			t.Errorf("%v: node not found", tt.path)
+			continue
+		}
+		if node.BaseValue != tt.base || node.NewValue != tt.new || node.Delta != tt.delta || node.SelfDelta != tt.selfDelta ||
+			node.Value != tt.value || node.DeltaPercent != tt.deltaPercent {
+			t.Errorf("%v: unexpected node %+v", tt.path, node)
+		}
+	}
+	// Children are ordered by the larger of both values
+	if main := findDiffNode(result.Root, "main.main"); main.Children[0].Name != "main.handle" || main.Children[1].Name != "main.cache" {
+		t.Errorf("Unexpected child order: %s, %s", main.Children[0].Name, main.Children[1].Name)
+	}
+
+	normalized, err := analyzer.DiffFlameGraph(base, current, "cpu", "", true)
+	if err != nil {
+		t.Fatalf("DiffFlameGraph failed: %v", err)
+	}
+	if handle := findDiffNode(normalized.Root, "main.main", "main.handle"); !normalized.Normalized || normalized.BaseTotal != 400 || handle.BaseValue != 300 || handle.Delta != 0 {
+		t.Errorf("Expected the base scaled to the new total, got total %d and handle %+v", normalized.BaseTotal, handle)
+	}
+
+	output, err := analyzer.FormatDiffFlameGraphJSON(base, current, "cpu", "samples", false)
+	if err != nil {
+		t.Fatalf("FormatDiffFlameGraphJSON failed: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &decoded); err != nil || decoded["sampleType"] != "samples" {
+		t.Errorf("Unexpected JSON (%v): %s", err, output)
+	}
+	root, _ := decoded["root"].(map[string]interface{})
+	for _, key := range []string{"name", "value", "baseValue", "newValue", "delta", "children"} {
+		if _, ok := root[key]; !ok {
+			t.Errorf("Expected '%s' in the root node: %v", key, root)
+		}
+	}
+
+	if _, err := analyzer.DiffFlameGraph(base, current, "threadcreate", "", false); err == nil {
+		t.Errorf("Expected an error for an unsupported profile type")
+	}
+}
@@ -2,6 +2,7 @@ package analyzer_test
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
@@ -305,3 +306,145 @@ func TestBuildFlameGraphTree(t *testing.T) {
 		}
 	})
 }
+
+func TestFindFlameGraphSubtree(t *testing.T) {
+	root := &analyzer.FlameGraphNode{Name: "root", Value: 100, Children: []*analyzer.FlameGraphNode{
+		{Name: "main.main", Value: 90, Children: []*analyzer.FlameGraphNode{
+			{Name: "main.work", Value: 60, Children: []*analyzer.FlameGraphNode{
+				{Name: "main.leaf", Value: 60},
+			}},
+			{Name: "main.idle", Value: 30},
+		}},
+		{Name: "runtime.gcBgMarkWorker", Value: 10},
+	}}
+
+	t.Run("PathWithAndWithoutRoot", func(t *testing.T) {
+		for _, path := range []string{"root;main.main;main.work", "main.main;main.work"} {
+			node, err := analyzer.FindFlameGraphSubtree(root, path, 0)
+			if err != nil {
+				t.Fatalf("FindFlameGraphSubtree(%q) failed: %v", path, err)
+			}
+			if node.Name != "main.work" || node.Value != 60 || len(node.Children) != 1 {
+				t.Errorf("FindFlameGraphSubtree(%q) = %+v, want main.work with 1 child", path, node)
+			}
+		}
+	})
+
+	t.Run("MaxDepthHidesChildren", func(t *testing.T) {
+		node, err := analyzer.FindFlameGraphSubtree(root, "root", 1)
+		if err != nil {
+			t.Fatalf("FindFlameGraphSubtree failed: %v", err)
+		}
+		if len(node.Children) != 2 {
+			t.Fatalf("Expected 2 children at depth 1, got %d", len(node.Children))
+		}
+		mainNode := node.Children[0]
+		if len(mainNode.Children) != 0 || mainNode.HiddenChildren != 2 {
+			t.Errorf("Expected main.main children to be hidden (HiddenChildren=2), got %d children, HiddenChildren=%d",
+				len(mainNode.Children), mainNode.HiddenChildren)
+		}
+		// The cached tree must not be modified
+		if len(root.Children[0].Children) != 2 {
+			t.Errorf("FindFlameGraphSubtree modified the original tree")
+		}
+	})
+
+	t.Run("MissingNode", func(t *testing.T) {
+		_, err := analyzer.FindFlameGraphSubtree(root, "root;main.main;main.missing", 0)
+		if err == nil {
+			t.Fatal("Expected an error for a missing node")
+		}
+		if !strings.Contains(err.Error(), "main.work") {
+			t.Errorf("Expected the error to list the available children, got: %v", err)
+		}
+	})
+}
+
+func TestBuildFlameGraphTreeLabels(t *testing.T) {
+	labeled := func(value int64, labels map[string][]string, funcs ...string) *profile.Sample {
+		s := stackSample([]int64{1, value}, funcs...)
+		s.Label = labels
+		return s
+	}
+	p := cpuProfile(
+		labeled(90, map[string][]string{"tenant": {"acme"}}, "main.query", "main.handle"),
+		labeled(10, map[string][]string{"tenant": {"globex"}, "route": {"/a"}}, "main.query", "main.handle"),
+		labeled(100, nil, "main.idle"),
+	)
+	root, err := analyzer.BuildFlameGraphTree(p, 1)
+	if err != nil {
+		t.Fatalf("BuildFlameGraphTree failed: %v", err)
+	}
+	if l := root.Labels["tenant"]; l.Value != "acme" || l.Percentage != 45 {
+		t.Errorf("Expected tenant=acme on 45%% of the root, got %+v", root.Labels)
+	}
+	var handle, idle *analyzer.FlameGraphNode
+	for _, c := range root.Children {
+		switch c.Name {
+		case "main.handle":
+			handle = c
+		case "main.idle":
+			idle = c
+		}
+	}
+	if handle == nil || idle == nil {
+		t.Fatalf("Unexpected children: %+v", root.Children)
+	}
+	if l := handle.Labels["tenant"]; l.Value != "acme" || l.Percentage != 90 {
+		t.Errorf("Expected tenant=acme on 90%% of main.handle, got %+v", handle.Labels)
+	}
+	if l := handle.Children[0].Labels["route"]; l.Value != "/a" || l.Percentage != 10 {
+		t.Errorf("Expected route=/a on 10%% of main.query, got %+v", handle.Children[0].Labels)
+	}
+	// Nodes without labeled samples have no labels field
+	data, _ := json.Marshal(idle)
+	if idle.Labels != nil || strings.Contains(string(data), "labels") {
+		t.Errorf("Expected no labels on main.idle, got %s", data)
+	}
+}
+
+func TestStackTreeFlameGraph(t *testing.T) {
+	decode := func(out string) *analyzer.FlameGraphNode {
+		t.Helper()
+		var root analyzer.FlameGraphNode
+		if err := json.Unmarshal([]byte(out), &root); err != nil {
+			t.Fatalf("Invalid flame graph JSON %s: %v", out, err)
+		}
+		return &root
+	}
+
+	// Goroutine stacks are weighted by their count
+	goroutines := withLocationTable(goroutineProfile(
+		stackSample([]int64{40}, "runtime.gopark", "main.worker"),
+		stackSample([]int64{2}, "runtime.gopark", "main.main"),
+	))
+	out, err := analyzer.Analyze(goroutines, "goroutine", analyzer.WithFormat("flamegraph-json"))
+	if err != nil {
+		t.Fatalf("Analyze failed for a goroutine flame graph: %v", err)
+	}
+	if root := decode(out); root.Value != 42 || len(root.Children) != 2 || root.Children[0].ValueFormatted != "" {
+		t.Errorf("Unexpected goroutine flame graph: %s", out)
+	}
+
+	// Mutex and block stacks are weighted by their delay, or by the selected sample type
+	contention := withLocationTable(contentionProfile(
+		stackSample([]int64{1, 3e9}, "sync.(*Mutex).Lock", "main.save"),
+		stackSample([]int64{9, 1e9}, "sync.(*Mutex).Lock", "main.load"),
+	))
+	for _, profileType := range []string{"mutex", "block"} {
+		out, err := analyzer.Analyze(contention, profileType, analyzer.WithFormat("flamegraph-json"))
+		if err != nil {
+			t.Fatalf("Analyze failed for a %s flame graph: %v", profileType, err)
+		}
+		if root := decode(out); root.Value != 4e9 || root.ValueFormatted != "4.00s" {
+			t.Errorf("Expected the %s flame graph to be weighted by delay: %s", profileType, out)
+		}
+	}
+	out, err = analyzer.Analyze(contention, "mutex", analyzer.WithFormat("flamegraph-json"), analyzer.WithSampleType("contentions"))
+	if err != nil {
+		t.Fatalf("Analyze failed for a mutex flame graph of contentions: %v", err)
+	}
+	if root := decode(out); root.Value != 10 {
+		t.Errorf("Expected the flame graph to be weighted by contentions: %s", out)
+	}
+}
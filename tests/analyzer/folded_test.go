@@ -0,0 +1,99 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestFoldedStacks(t *testing.T) {
+	inlined := stackSample([]int64{1, 50}, "main.main")
+	inlined.Location[0].Line = append([]profile.Line{{Function: &profile.Function{ID: 9, Name: "strings.Index;x"}}}, inlined.Location[0].Line...)
+	p := cpuProfile(
+		stackSample([]int64{2, 200}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{1, 100}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{3, 300}, "main.handle", "main.main"),
+		stackSample([]int64{0, 0}, "main.idle", "main.main"),
+		inlined,
+	)
+
+	folded, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("folded"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	// 相同的栈合并，零值样本被跳过，内联帧按调用方在前展开，函数名中的 ';' 被替换
+	want := "main.main;main.handle 300\n" +
+		"main.main;main.handle;main.parse 300\n" +
+		"main.main;strings.Index:x 50\n"
+	if folded != want {
+		t.Errorf("Unexpected folded stacks:\n%s\nwant:\n%s", folded, want)
+	}
+
+	samples, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("folded"), analyzer.WithSampleType("samples"))
+	if err != nil || !strings.Contains(samples, "main.main;main.handle;main.parse 3\n") {
+		t.Errorf("Expected the samples values with sample_type, got %q (%v)", samples, err)
+	}
+
+	if _, err := analyzer.Analyze(goroutineProfile(stackSample([]int64{1}, "runtime.gopark")), "goroutine", analyzer.WithFormat("folded")); err == nil {
+		t.Errorf("Expected an error for a goroutine profile")
+	}
+}
+
+func TestParseFoldedStacks(t *testing.T) {
+	input := "# collapsed by an eBPF tool\n" +
+		"main.main;main.handle;main.parse 300\n" +
+		"main.main;main.handle 120\r\n" +
+		"\n" +
+		"main.main;main.handle 80\n" +
+		"swapper;cpu_idle_[k] 2.6\n" +
+		"main.main;main.idle 0\n"
+	if !analyzer.IsFoldedStacks([]byte(input)) {
+		t.Fatalf("Expected folded stacks to be detected")
+	}
+	for _, other := range []string{"goroutine profile: total 5\n5 @ 0x1 0x2\n", "main.main 10\n", "heap profile: 1: 2 [3: 4] @ heapprofile\n", ""} {
+		if analyzer.IsFoldedStacks([]byte(other)) {
+			t.Errorf("Expected %q not to be detected as folded stacks", other)
+		}
+	}
+
+	p, err := analyzer.ParseFoldedStacks([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseFoldedStacks failed: %v", err)
+	}
+	if len(p.Sample) != 3 || p.DefaultSampleType != "samples" {
+		t.Fatalf("Expected 3 distinct stacks, got %d", len(p.Sample))
+	}
+	// Leaf first, identical stacks merged, decimal values rounded, annotations kept
+	first := p.Sample[0]
+	if first.Location[0].Line[0].Function.Name != "main.parse" || first.Location[2].Line[0].Function.Name != "main.main" {
+		t.Errorf("Expected a leaf-first stack, got %s ... %s", first.Location[0].Line[0].Function.Name, first.Location[2].Line[0].Function.Name)
+	}
+	if p.Sample[1].Value[0] != 200 || p.Sample[2].Value[0] != 3 || p.Sample[2].Location[0].Line[0].Function.Name != "cpu_idle_[k]" {
+		t.Errorf("Unexpected samples: %v, %v (%s)", p.Sample[1].Value, p.Sample[2].Value, p.Sample[2].Location[0].Line[0].Function.Name)
+	}
+	if first.Location[2] != p.Sample[1].Location[1] {
+		t.Errorf("Expected main.main to share its location")
+	}
+
+	// Folding the parsed profile again gives the same stacks
+	folded, err := analyzer.FormatFoldedStacks(p, 0)
+	if err != nil {
+		t.Fatalf("FormatFoldedStacks failed: %v", err)
+	}
+	want := "main.main;main.handle 200\nmain.main;main.handle;main.parse 300\nswapper;cpu_idle_[k] 3\n"
+	if folded != want {
+		t.Errorf("Unexpected round trip:\n%s\nwant:\n%s", folded, want)
+	}
+	if text := mustAnalyze(t, p); !strings.Contains(text, "main.parse") {
+		t.Errorf("Expected the cpu analysis to work on folded stacks:\n%s", text)
+	}
+
+	if _, err := analyzer.ParseFoldedStacks([]byte("main.main;main.work 10\nnot a stack\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+	if _, err := analyzer.ParseFoldedStacks([]byte("main.main;main.work 0\n")); err == nil {
+		t.Errorf("Expected an error without positive values")
+	}
+}
@@ -0,0 +1,120 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestValueFormat(t *testing.T) {
+	en, err := analyzer.LookupNumberLocale("en_US.UTF-8")
+	if err != nil {
+		t.Fatalf("LookupNumberLocale failed: %v", err)
+	}
+	de, _ := analyzer.LookupNumberLocale("de")
+
+	tests := []struct {
+		name   string
+		format analyzer.ValueFormat
+		got    func(f analyzer.ValueFormat) string
+		want   string
+	}{
+		{"jedec", analyzer.ValueFormat{}, func(f analyzer.ValueFormat) string { return f.Bytes(1536 * 1024) }, "1.50 MB"},
+		{"iec", analyzer.ValueFormat{ByteUnits: analyzer.ByteUnitsIEC}, func(f analyzer.ValueFormat) string { return f.Bytes(1536 * 1024) }, "1.50 MiB"},
+		{"si", analyzer.ValueFormat{ByteUnits: analyzer.ByteUnitsSI}, func(f analyzer.ValueFormat) string { return f.Bytes(1536 * 1024) }, "1.57 MB"},
+		{"si below 1 kB", analyzer.ValueFormat{ByteUnits: analyzer.ByteUnitsSI}, func(f analyzer.ValueFormat) string { return f.Bytes(999) }, "999 B"},
+		{"negative bytes", analyzer.ValueFormat{}, func(f analyzer.ValueFormat) string { return f.Bytes(-2048) }, "-2.00 KB"},
+		{"en grouping", analyzer.ValueFormat{Locale: en}, func(f analyzer.ValueFormat) string { return f.SampleValue(1234567, "count") }, "1,234,567"},
+		{"de decimals", analyzer.ValueFormat{Locale: de}, func(f analyzer.ValueFormat) string { return f.SampleValue(1500000000, "nanoseconds") }, "1,50s"},
+		{"de bytes", analyzer.ValueFormat{Locale: de, ByteUnits: analyzer.ByteUnitsIEC}, func(f analyzer.ValueFormat) string { return f.Bytes(1234) }, "1,21 KiB"},
+		{"negative duration", analyzer.ValueFormat{}, func(f analyzer.ValueFormat) string { return f.SampleValue(-2500000, "nanoseconds") }, "-2.00ms"},
+		{"bytes unit", analyzer.ValueFormat{}, func(f analyzer.ValueFormat) string { return f.SampleValue(2048, "bytes") }, "2.00 KB"},
+		{"grouped counts", analyzer.ValueFormat{}, func(f analyzer.ValueFormat) string { return f.Count(123456789) }, "123,456,789"},
+		{"de counts", analyzer.ValueFormat{Locale: de}, func(f analyzer.ValueFormat) string { return f.Count(-1234567) }, "-1.234.567"},
+		{"plain counts", analyzer.ValueFormat{Counts: analyzer.CountsPlain}, func(f analyzer.ValueFormat) string { return f.Count(123456789) }, "123456789"},
+		{"human counts", analyzer.ValueFormat{Counts: analyzer.CountsHuman}, func(f analyzer.ValueFormat) string { return f.Count(123456789) }, "123.5M"},
+		{"human small counts", analyzer.ValueFormat{Counts: analyzer.CountsHuman}, func(f analyzer.ValueFormat) string { return f.Count(9999) }, "9,999"},
+		{"human rounding", analyzer.ValueFormat{Counts: analyzer.CountsHuman}, func(f analyzer.ValueFormat) string { return f.Count(999950) }, "1.0M"},
+		{"human negative", analyzer.ValueFormat{Counts: analyzer.CountsHuman, Locale: de}, func(f analyzer.ValueFormat) string { return f.Count(-25000) }, "-25,0K"},
+		{"count unit", analyzer.ValueFormat{}, func(f analyzer.ValueFormat) string { return f.SampleValue(4096, "count") }, "4,096"},
+	}
+	for _, tt := range tests {
+		if got := tt.got(tt.format); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := analyzer.LookupNumberLocale("xx"); err == nil {
+		t.Errorf("Expected an error for an unknown locale")
+	}
+	if _, err := analyzer.ParseByteUnits("binary"); err == nil {
+		t.Errorf("Expected an error for unknown byte units")
+	}
+	if style, err := analyzer.ParseCountStyle(""); err != nil || style != analyzer.CountsGrouped {
+		t.Errorf("Expected grouped counts by default, got %q (%v)", style, err)
+	}
+	if _, err := analyzer.ParseCountStyle("short"); err == nil {
+		t.Errorf("Expected an error for an unknown count style")
+	}
+}
+
+func TestDefaultValueFormatInReports(t *testing.T) {
+	de, _ := analyzer.LookupNumberLocale("de")
+	analyzer.SetDefaultValueFormat(analyzer.ValueFormat{ByteUnits: analyzer.ByteUnitsIEC, Locale: de, AlignRight: true})
+	t.Cleanup(func() { analyzer.SetDefaultValueFormat(analyzer.ValueFormat{}) })
+
+	p := heapProfile(
+		stackSample([]int64{3, 3 << 20}, "main.big", "main.main"),
+		stackSample([]int64{1, 1024}, "main.small", "main.main"),
+	)
+	text, err := analyzer.AnalyzeHeapProfile(p, 2, "text")
+	if err != nil {
+		t.Fatalf("AnalyzeHeapProfile failed: %v", err)
+	}
+	for _, want := range []string{"Total inuse_space (bytes): 3,00 MiB", "       3,00 MiB           99,97               3 main.big", "       1,00 KiB            0,03               1 main.small"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
+
+func TestReportTables(t *testing.T) {
+	p := cpuProfile(
+		stackSample([]int64{3, 3e7}, "main.work", "main.main"),
+		stackSample([]int64{1, 1e7}, "main.(*T).Get|x", "main.main"),
+	)
+	markdown, err := analyzer.AnalyzeCPUProfile(p, 5, "markdown")
+	if err != nil {
+		t.Fatalf("AnalyzeCPUProfile failed: %v", err)
+	}
+	for _, want := range []string{"### CPU Profile Analysis", "| Flat Time | Flat% | Cum Time | Cum% | Function Name |", "|---:|---:|---:|---:|---|", "| `main.work` |", "`main.(*T).Get\\|x`"} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("Expected %q in:\n%s", want, markdown)
+		}
+	}
+	if strings.Contains(markdown, "```") || strings.Contains(markdown, "\x1b[") {
+		t.Errorf("Expected a markdown table without code blocks or colors:\n%s", markdown)
+	}
+
+	analyzer.SetDefaultValueFormat(analyzer.ValueFormat{Color: true})
+	t.Cleanup(func() { analyzer.SetDefaultValueFormat(analyzer.ValueFormat{}) })
+	text, err := analyzer.AnalyzeCPUProfile(p, 5, "text")
+	if err != nil {
+		t.Fatalf("AnalyzeCPUProfile failed: %v", err)
+	}
+	if !strings.Contains(text, "\x1b[1mCPU Profile Analysis") || !strings.Contains(text, "30.00ms") {
+		t.Errorf("Expected a colored text report:\n%q", text)
+	}
+	diff, err := analyzer.DiffProfiles(p, cpuProfile(stackSample([]int64{5, 5e7}, "main.work", "main.main")), "cpu", "", "flat", 5)
+	if err != nil {
+		t.Fatalf("DiffProfiles failed: %v", err)
+	}
+	text, err = analyzer.FormatProfileDiff(diff, "text")
+	if err != nil {
+		t.Fatalf("FormatProfileDiff failed: %v", err)
+	}
+	if !strings.Contains(text, "\x1b[31m+20.00ms") || !strings.Contains(text, "\x1b[32m-10.00ms") {
+		t.Errorf("Expected growth in red and shrinkage in green:\n%q", text)
+	}
+}
@@ -0,0 +1,116 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestNormalizeFunctionName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"example.com/lib/v2.Parse", "example.com/lib.Parse"},
+		{"example.com/lib/v3/codec.(*Decoder).Decode", "example.com/lib/codec.(*Decoder).Decode"},
+		{"gopkg.in/yaml.v3.Unmarshal", "gopkg.in/yaml.Unmarshal"},
+		{"example.com/app/vendor/golang.org/x/net/http2.(*Framer).ReadFrame", "golang.org/x/net/http2.(*Framer).ReadFrame"},
+		{"main.run.func3", "main.run.func"},
+		{"main.run.func2.1", "main.run.func"},
+		{"main.serve.gowrap1", "main.serve.gowrap"},
+		{"slices.SortFunc[go.shape.[]string]", "slices.SortFunc[...]"},
+		{"runtime.mallocgc", "runtime.mallocgc"},
+	}
+	for _, tt := range tests {
+		if got := analyzer.NormalizeFunctionName(tt.name); got != tt.want {
+			t.Errorf("NormalizeFunctionName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fileSample builds a one-frame sample of a function defined in file.
+func fileSample(value int64, name, file string) *profile.Sample {
+	s := stackSample([]int64{1, value}, name)
+	s.Location[0].Line[0].Function.Filename = file
+	return s
+}
+
+func TestMatchFunctionIdentities(t *testing.T) {
+	base := withLocationTable(cpuProfile(
+		fileSample(100, "example.com/lib.Parse", "parse.go"),
+		fileSample(100, "main.run.func1", "main.go"),
+		fileSample(100, "example.com/old/server.(*Server).Handle", "handler.go"),
+		fileSample(100, "main.unchanged", "main.go"),
+		fileSample(100, "main.removed", "main.go"),
+		fileSample(100, "main.ambiguous.func1", "main.go"),
+	))
+	p := withLocationTable(cpuProfile(
+		fileSample(100, "example.com/lib/v2.Parse", "parse.go"),
+		fileSample(100, "main.run.func2", "main.go"),
+		fileSample(100, "example.com/new/server.(*Server).Handle", "handler.go"),
+		fileSample(100, "main.unchanged", "main.go"),
+		fileSample(100, "main.added", "main.go"),
+		fileSample(100, "main.ambiguous.func2", "main.go"),
+		fileSample(100, "main.ambiguous.func3", "main.go"),
+	))
+
+	matches := analyzer.MatchFunctionIdentities(p, base)
+	want := []analyzer.FunctionMatch{
+		{Name: "example.com/lib/v2.Parse", BaseName: "example.com/lib.Parse", Method: "normalized name"},
+		{Name: "example.com/new/server.(*Server).Handle", BaseName: "example.com/old/server.(*Server).Handle", Method: "file and name"},
+		{Name: "main.run.func2", BaseName: "main.run.func1", Method: "normalized name"},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("Expected %d matches, got %+v", len(want), matches)
+	}
+	for i := range want {
+		if matches[i] != want[i] {
+			t.Errorf("Match %d: expected %+v, got %+v", i, want[i], matches[i])
+		}
+	}
+
+	mapped, _ := analyzer.MapFunctionIdentities(p, base)
+	if p.Function[0].Name != "example.com/lib/v2.Parse" {
+		t.Errorf("MapFunctionIdentities modified its input: %s", p.Function[0].Name)
+	}
+	comparison, err := analyzer.CompareStackSets(mapped, base, "only_in_profile", "function", "", 10)
+	if err != nil {
+		t.Fatalf("CompareStackSets failed: %v", err)
+	}
+	keys := make([]string, 0, len(comparison.Entries))
+	for _, e := range comparison.Entries {
+		keys = append(keys, e.Key)
+	}
+	if got := strings.Join(keys, ","); got != "main.added,main.ambiguous.func2,main.ambiguous.func3" {
+		t.Errorf("Expected only the added and ambiguous functions to be new, got %s", got)
+	}
+
+	summary := analyzer.FormatFunctionMatches(matches, 2)
+	for _, s := range []string{"Matched 3 renamed function(s)", "example.com/lib/v2.Parse → example.com/lib.Parse (normalized name)", "... and 1 more"} {
+		if !strings.Contains(summary, s) {
+			t.Errorf("Expected %q in:\n%s", s, summary)
+		}
+	}
+}
+
+func TestMatchFunctionIdentitiesByAddress(t *testing.T) {
+	// Two builds of the same binary symbolized differently: the build ID and offset identify the function
+	build := func(start uint64, name string) *profile.Profile {
+		m := &profile.Mapping{ID: 1, Start: start, Limit: start + 0x10000, BuildID: "abc123"}
+		fn := &profile.Function{ID: 1, Name: name}
+		loc := &profile.Location{ID: 1, Mapping: m, Address: start + 0x1234, Line: []profile.Line{{Function: fn}}}
+		return &profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+			Sample:     []*profile.Sample{{Location: []*profile.Location{loc}, Value: []int64{1}}},
+			Mapping:    []*profile.Mapping{m},
+			Location:   []*profile.Location{loc},
+			Function:   []*profile.Function{fn},
+		}
+	}
+	matches := analyzer.MatchFunctionIdentities(build(0x500000, "main.worker"), build(0x400000, "main.(*pool).worker"))
+	if len(matches) != 1 || matches[0].Method != "address" || matches[0].BaseName != "main.(*pool).worker" {
+		t.Errorf("Expected an address match, got %+v", matches)
+	}
+}
@@ -0,0 +1,89 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestGoroutineStateSummary(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		Sample: []*profile.Sample{
+			stackSample([]int64{2100}, "runtime.gopark", "runtime.chanrecv", "runtime.chanrecv1", "main.worker"),
+			stackSample([]int64{600}, "runtime.gopark", "runtime.netpollblock", "internal/poll.runtime_pollWait", "net.(*conn).Read"),
+			stackSample([]int64{300}, "runtime.gopark", "runtime.selectgo", "main.loop"),
+			stackSample([]int64{200}, "main.compute", "main.main"),
+			stackSample([]int64{40}, "runtime/pprof.writeGoroutineStacks", "net/http/pprof.handler.ServeHTTP"),
+		},
+	}
+	p = withLocationTable(p)
+
+	total, states := analyzer.SummarizeGoroutineStates(p)
+	summary := analyzer.FormatGoroutineStateSummary(total, states)
+	if want := "3,240 total: 2,100 chan receive, 600 IO wait, 300 select, 240 running"; summary != want {
+		t.Errorf("Expected summary %q, got %q", want, summary)
+	}
+
+	text, err := analyzer.AnalyzeGoroutineProfile(p, 2, "text")
+	if err != nil {
+		t.Fatalf("AnalyzeGoroutineProfile failed: %v", err)
+	}
+	statesAt, stacksAt := strings.Index(text, "States: "+summary), strings.Index(text, "goroutines with stack")
+	if statesAt < 0 || stacksAt < statesAt {
+		t.Errorf("Expected the state summary before the stacks, got:\n%s", text)
+	}
+
+	jsonResult, err := analyzer.AnalyzeGoroutineProfile(p, 2, "json")
+	if err != nil {
+		t.Fatalf("AnalyzeGoroutineProfile failed: %v", err)
+	}
+	var result analyzer.GoroutineAnalysisResult
+	if err := json.Unmarshal([]byte(jsonResult), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if result.StateSummary != summary || len(result.States) != 4 || result.States[0].State != "chan receive" {
+		t.Errorf("Unexpected JSON states: %q %+v", result.StateSummary, result.States)
+	}
+}
+
+func TestGoroutineMaxStackDepth(t *testing.T) {
+	p := withLocationTable(&profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		Sample: []*profile.Sample{
+			stackSample([]int64{3}, "runtime.gopark", "runtime.chanrecv", "main.c", "main.b", "main.a", "main.main"),
+		},
+	})
+
+	text, err := analyzer.Analyze(p, "goroutine", analyzer.WithTopN(1), analyzer.WithFormat("text"), analyzer.WithMaxStackDepth(2))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{"3 goroutines with stack (6 frames):", "runtime.chanrecv", "… 4 more frames"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "main.c") {
+		t.Errorf("Expected frames beyond the depth to be hidden, got:\n%s", text)
+	}
+
+	jsonResult, err := analyzer.Analyze(p, "goroutine", analyzer.WithTopN(1), analyzer.WithFormat("json"), analyzer.WithMaxStackDepth(2))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var result analyzer.GoroutineAnalysisResult
+	if err := json.Unmarshal([]byte(jsonResult), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if len(result.Stacks) != 1 || result.Stacks[0].Frames != 6 || len(result.Stacks[0].StackTrace) != 3 {
+		t.Errorf("Unexpected JSON stacks: %+v", result.Stacks)
+	}
+
+	if _, err := analyzer.Analyze(p, "goroutine", analyzer.WithMaxStackDepth(-1)); err == nil {
+		t.Errorf("Expected an error for a negative max stack depth")
+	}
+}
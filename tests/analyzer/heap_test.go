@@ -91,9 +91,9 @@ func TestAnalyzeHeapProfile(t *testing.T) {
 			t.Fatalf("Error analyzing heap profile with markdown format: %v", err)
 		}
 
-		// Check that the result contains markdown formatting
-		if !strings.Contains(result, "```text") || !strings.Contains(result, "```") {
-			t.Errorf("Expected markdown result to be wrapped in code blocks, but it isn't.\nResult: %s", result)
+		// Check that the result is rendered as markdown tables rather than a code block
+		if strings.Contains(result, "```") || !strings.Contains(result, "#### By Function") || !strings.Contains(result, "|---:|---:|---:|---|") {
+			t.Errorf("Expected markdown result to contain markdown tables, but it doesn't.\nResult: %s", result)
 		}
 	})
 
@@ -226,3 +226,113 @@ func TestAnalyzeHeapProfile(t *testing.T) {
 		}
 	})
 }
+
+func TestEstimateObjectSurvival(t *testing.T) {
+	retainedFn := &profile.Function{ID: 1, Name: "RetainedFunction", Filename: "cache.go"}
+	transientFn := &profile.Function{ID: 2, Name: "TransientFunction", Filename: "handler.go"}
+
+	testProfile := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		Sample: []*profile.Sample{
+			{
+				Location: []*profile.Location{{ID: 1, Line: []profile.Line{{Function: retainedFn, Line: 10}}}},
+				Value:    []int64{100, 10240, 98, 10035}, // 98% survival
+			},
+			{
+				Location: []*profile.Location{{ID: 2, Line: []profile.Line{{Function: transientFn, Line: 20}}}},
+				Value:    []int64{1000, 102400, 10, 1024}, // 1% survival
+			},
+		},
+	}
+
+	stats, err := analyzer.EstimateObjectSurvival(testProfile)
+	if err != nil {
+		t.Fatalf("Error estimating object survival: %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 survival stats, got %d", len(stats))
+	}
+	if stats[0].SurvivalRatio < 0.97 || stats[0].SurvivalRatio > 0.99 {
+		t.Errorf("Expected survival ratio ~0.98 for the retained site, got %.2f", stats[0].SurvivalRatio)
+	}
+
+	result, err := analyzer.AnalyzeHeapProfile(testProfile, 5, "text")
+	if err != nil {
+		t.Fatalf("Error analyzing heap profile: %v", err)
+	}
+	if !strings.Contains(result, "Retention Candidates") {
+		t.Errorf("Expected result to contain retention candidates section.\nResult: %s", result)
+	}
+	section := result[strings.Index(result, "Retention Candidates"):]
+	if !strings.Contains(section, "RetainedFunction") || strings.Contains(section, "TransientFunction") {
+		t.Errorf("Expected only the retained site to be flagged.\nSection: %s", section)
+	}
+
+	// Profiles without object counts cannot be analyzed
+	_, err = analyzer.EstimateObjectSurvival(&profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "inuse_space", Unit: "bytes"}},
+	})
+	if err == nil {
+		t.Error("Expected error for profile without object counts, but got nil")
+	}
+}
+
+func TestHeapOwnershipByPackage(t *testing.T) {
+	cases := map[string]string{
+		"github.com/org/repo/pkg.(*T).Method": "github.com/org/repo/pkg",
+		"gopkg.in/yaml.v3.(*parser).parse":    "gopkg.in/yaml.v3",
+		"encoding/json.Marshal":               "encoding/json",
+		"pkg.F[go.shape.*github.com/x.T]":     "pkg",
+		"main.main":                           "main",
+		"0x4a1f20":                            "",
+	}
+	for name, want := range cases {
+		if got := analyzer.PackageName(name); got != want {
+			t.Errorf("PackageName(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	p := heapProfile(
+		// Allocations made by the standard library are owned by the first application frame
+		stackSample([]int64{10, 6000}, "bytes.Clone", "github.com/org/cache.(*Store).Put", "main.main"),
+		stackSample([]int64{5, 3000}, "github.com/org/cache.(*Store).grow", "main.main"),
+		stackSample([]int64{2, 800}, "main.load", "main.main"),
+		stackSample([]int64{1, 200}, "runtime.malg", "runtime.newproc1"),
+	)
+	result, err := analyzer.Analyze(p, "heap", analyzer.WithGroupBy("package"), analyzer.WithOwnershipThreshold(50), analyzer.WithTopN(2), analyzer.WithFormat("json"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var report analyzer.OwnershipReport
+	if err := json.Unmarshal([]byte(result), &report); err != nil {
+		t.Fatalf("Failed to parse result: %v\n%s", err, result)
+	}
+	if report.TotalValue != 10000 || report.PackageCount != 3 || len(report.Packages) != 2 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if top := report.Packages[0]; top.Package != "github.com/org/cache" || top.Value != 9000 || top.ObjectCount != 15 || !top.Dominant {
+		t.Errorf("Expected github.com/org/cache to own 90%% of the heap, got %+v", top)
+	}
+	if len(report.Dominant) != 1 || report.Packages[1].Dominant {
+		t.Errorf("Expected only one package over the threshold, got %+v", report.Packages)
+	}
+
+	text, err := analyzer.Analyze(p, "heap", analyzer.WithGroupBy("package"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !strings.Contains(text, "Dominant owners (> 20.0% of the total): github.com/org/cache") {
+		t.Errorf("Expected the default threshold in the summary:\n%s", text)
+	}
+	if _, err := analyzer.Analyze(p, "heap", analyzer.WithGroupBy("package"), analyzer.WithFormat("flamegraph-json")); err == nil {
+		t.Error("Expected an error for flamegraph-json with group_by package")
+	}
+	if _, err := analyzer.Analyze(p, "heap", analyzer.WithGroupBy("module")); err == nil {
+		t.Error("Expected an error for an unsupported group_by")
+	}
+}
@@ -0,0 +1,94 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestFormatHeatmapJSON(t *testing.T) {
+	snapshot := func(values map[string]int64) *profile.Profile {
+		p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "inuse_space", Unit: "bytes"}}}
+		for name, v := range values {
+			p.Sample = append(p.Sample, &profile.Sample{
+				Location: []*profile.Location{{Line: []profile.Line{{Function: &profile.Function{Name: name}}}}},
+				Value:    []int64{v},
+			})
+		}
+		return p
+	}
+	snapshots := []*profile.Profile{
+		snapshot(map[string]int64{"main.a": 300, "main.b": 100}),
+		snapshot(map[string]int64{"main.a": 100, "main.b": 100, "main.c": 800}),
+	}
+
+	result, err := analyzer.FormatHeatmapJSON(snapshots, []string{"t0", "t1"}, "inuse_space", 2)
+	if err != nil {
+		t.Fatalf("FormatHeatmapJSON failed: %v", err)
+	}
+	var heatmap analyzer.HeatmapResult
+	if err := json.Unmarshal([]byte(result), &heatmap); err != nil {
+		t.Fatalf("Failed to parse heatmap JSON: %v", err)
+	}
+
+	// main.c peaks at 80% of t1, main.a at 75% of t0; main.b (max 50%) is cut by topN
+	if len(heatmap.Functions) != 2 || heatmap.Functions[0] != "main.c" || heatmap.Functions[1] != "main.a" {
+		t.Fatalf("Unexpected functions: %v", heatmap.Functions)
+	}
+	if heatmap.ColumnTotals[0] != 400 || heatmap.ColumnTotals[1] != 1000 {
+		t.Errorf("Unexpected column totals: %v", heatmap.ColumnTotals)
+	}
+	if heatmap.Values[1][0] != 300 || heatmap.Values[0][0] != 0 {
+		t.Errorf("Unexpected values: %v", heatmap.Values)
+	}
+	if heatmap.Normalized[1][0] != 0.75 || heatmap.Normalized[0][1] != 0.8 {
+		t.Errorf("Unexpected normalized values: %v", heatmap.Normalized)
+	}
+
+	if _, err := analyzer.FormatHeatmapJSON(snapshots, []string{"t0", "t1"}, "alloc_space", 2); err == nil {
+		t.Error("Expected an error for a missing sample type")
+	}
+}
+
+func TestBuildReplicaVariance(t *testing.T) {
+	replica := func(values map[string]int64) *profile.Profile {
+		p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}}}
+		for name, v := range values {
+			p.Sample = append(p.Sample, &profile.Sample{
+				Location: []*profile.Location{{Line: []profile.Line{{Function: &profile.Function{Name: name}}}}},
+				Value:    []int64{v},
+			})
+		}
+		return p
+	}
+	// main.work is equally hot everywhere; main.retry only burns CPU on pod-c
+	replicas := []*profile.Profile{
+		replica(map[string]int64{"main.work": 500, "main.idle": 500}),
+		replica(map[string]int64{"main.work": 1000, "main.idle": 1000}),
+		replica(map[string]int64{"main.work": 500, "main.idle": 100, "main.retry": 400}),
+	}
+
+	report, err := analyzer.BuildReplicaVariance(replicas, []string{"pod-a", "pod-b", "pod-c"}, "", 3)
+	if err != nil {
+		t.Fatalf("BuildReplicaVariance failed: %v", err)
+	}
+	byName := make(map[string]analyzer.FunctionVariance)
+	for _, fv := range report.Functions {
+		byName[fv.Function] = fv
+	}
+
+	work := byName["main.work"]
+	if work.Scope != "systemic" || len(work.Outliers) != 0 || work.StdDev != 0 || work.MeanShare != 0.5 {
+		t.Errorf("Unexpected variance for main.work: %+v", work)
+	}
+	retry := byName["main.retry"]
+	if retry.Scope != "localized" || len(retry.Outliers) != 1 || retry.Outliers[0] != "pod-c" {
+		t.Errorf("Unexpected variance for main.retry: %+v", retry)
+	}
+
+	if _, err := analyzer.BuildReplicaVariance(replicas[:1], []string{"pod-a"}, "", 3); err == nil {
+		t.Error("Expected an error for a single replica")
+	}
+}
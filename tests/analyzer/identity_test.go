@@ -0,0 +1,49 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestCompareProfileIdentity(t *testing.T) {
+	p := heapProfile(
+		stackSample([]int64{10, 1000}, "main.cache", "main.main"),
+		stackSample([]int64{5, 500}, "main.steady", "main.main"),
+	)
+	// The same samples split differently, in another order, with other IDs and capture time
+	same := withLocationTable(heapProfile(
+		stackSample([]int64{5, 500}, "main.steady", "main.main"),
+		stackSample([]int64{4, 400}, "main.cache", "main.main"),
+		stackSample([]int64{6, 600}, "main.cache", "main.main"),
+	))
+	same.TimeNanos = 42
+
+	identity := analyzer.CompareProfileIdentity(p, same)
+	if !identity.SemanticallyIdentical || len(identity.Differences) != 0 {
+		t.Errorf("Expected semantically identical profiles, got differences %v", identity.Differences)
+	}
+	if len(identity.IgnoredDifferences) != 1 || identity.IgnoredDifferences[0] != "capture times differ" {
+		t.Errorf("Expected the capture time difference to be reported as ignored, got %v", identity.IgnoredDifferences)
+	}
+
+	grown := heapProfile(
+		stackSample([]int64{20, 2000}, "main.cache", "main.main"),
+		stackSample([]int64{5, 500}, "main.steady", "main.main"),
+		stackSample([]int64{1, 100}, "main.new", "main.main"),
+	)
+	identity = analyzer.CompareProfileIdentity(p, grown)
+	if identity.SemanticallyIdentical {
+		t.Fatalf("Expected different profiles")
+	}
+	text, err := analyzer.FormatProfileIdentity(identity, "text")
+	if err != nil {
+		t.Fatalf("FormatProfileIdentity failed: %v", err)
+	}
+	for _, want := range []string{"Different profiles.", "1 stacks only in the other profile (e.g. main.main;main.new)", "1 stacks with different values (e.g. main.main;main.cache)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// jfrJSON is trimmed 'jfr print --json' output: two CPU samples, a sampled allocation and an event that is
+// not converted.
+const jfrJSON = `{
+  "recording": {
+    "events": [{
+      "type": "jdk.ExecutionSample",
+      "values": {
+        "startTime": "2024-05-01T10:00:00.000000000+02:00",
+        "sampledThread": {"osName": "main", "javaName": "main"},
+        "state": "STATE_RUNNABLE",
+        "stackTrace": {"truncated": false, "frames": [
+          {"method": {"type": {"name": "java.util.HashMap"}, "name": "hash"}, "lineNumber": 339, "type": "Inlined"},
+          {"method": {"type": {"name": "com\/example\/App"}, "name": "main"}, "lineNumber": 12, "type": "Interpreted"}
+        ]}
+      }
+    }, {
+      "type": "jdk.ExecutionSample",
+      "values": {
+        "startTime": "2024-05-01T10:00:02.000000000+02:00",
+        "sampledThread": {"osName": "worker-1", "javaName": "worker-1"},
+        "stackTrace": {"truncated": true, "frames": [
+          {"method": {"type": {"name": "com.example.App"}, "name": "main"}, "lineNumber": 12, "type": "Interpreted"}
+        ]}
+      }
+    }, {
+      "type": "jdk.ObjectAllocationSample",
+      "values": {
+        "startTime": "2024-05-01T10:00:01.000000000+02:00",
+        "eventThread": {"javaName": "main"},
+        "objectClass": {"name": "byte[]"},
+        "weight": 4096,
+        "stackTrace": {"truncated": false, "frames": [
+          {"method": {"type": {"name": "com.example.App"}, "name": "main"}, "lineNumber": 14, "type": "JIT compiled"}
+        ]}
+      }
+    }, {
+      "type": "jdk.GarbageCollection",
+      "values": {"startTime": "2024-05-01T10:00:01.500000000+02:00", "name": "G1New"}
+    }]
+  }
+}`
+
+func TestParseJFRJSON(t *testing.T) {
+	if !analyzer.IsJFRJSON([]byte(jfrJSON)) || analyzer.IsJFRJSON([]byte(`{"resourceProfiles": []}`)) || analyzer.IsJFRJSON([]byte("FLR\x00")) {
+		t.Errorf("Unexpected jfr print --json detection")
+	}
+
+	p, err := analyzer.ParseJFRJSON(strings.NewReader(jfrJSON))
+	if err != nil {
+		t.Fatalf("ParseJFRJSON failed: %v", err)
+	}
+	if len(p.Sample) != 3 || p.DefaultSampleType != "samples" || len(p.SampleType) != 3 {
+		t.Fatalf("Expected 3 samples of 3 types, got %d samples, default %q", len(p.Sample), p.DefaultSampleType)
+	}
+	if p.DurationNanos != 2e9 {
+		t.Errorf("Expected a 2s duration, got %d", p.DurationNanos)
+	}
+	if len(p.Comments) != 1 || !strings.Contains(p.Comments[0], "1 stack traces were truncated") {
+		t.Errorf("Expected a comment about the truncated stack, got %v", p.Comments)
+	}
+
+	first := p.Sample[0]
+	if first.Location[0].Line[0].Function.Name != "java.util.HashMap.hash" || first.Location[1].Line[0].Function.Name != "com.example.App.main" {
+		t.Errorf("Expected leaf-first frames with dotted class names, got %s, %s",
+			first.Location[0].Line[0].Function.Name, first.Location[1].Line[0].Function.Name)
+	}
+	if first.Location[1].Line[0].Line != 12 || first.Value[0] != 1 || first.Label["thread"][0] != "main" {
+		t.Errorf("Unexpected first sample: values %v, labels %v", first.Value, first.Label)
+	}
+	// The same method at the same line shares its location
+	if p.Sample[1].Location[0] != first.Location[1] {
+		t.Errorf("Expected the location of com.example.App.main:12 to be shared")
+	}
+
+	alloc := p.Sample[2]
+	if alloc.Value[0] != 0 || alloc.Value[1] != 1 || alloc.Value[2] != 4096 || alloc.Label["type"][0] != "byte[]" {
+		t.Errorf("Unexpected allocation sample: values %v, labels %v", alloc.Value, alloc.Label)
+	}
+	// Same method as the CPU samples at another line: a new location, the same function
+	if alloc.Location[0] == first.Location[1] || alloc.Location[0].Line[0].Function != first.Location[1].Line[0].Function {
+		t.Errorf("Expected a new location for line 14 of an existing function")
+	}
+
+	text, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("text"))
+	if err != nil || !strings.Contains(text, "java.util.HashMap.hash") {
+		t.Errorf("Expected the cpu analysis to work on the recording, got %q (%v)", text, err)
+	}
+	text, err = analyzer.Analyze(p, "allocs", analyzer.WithFormat("text"))
+	if err != nil || !strings.Contains(text, "com.example.App.main") {
+		t.Errorf("Expected the allocs analysis to work on the recording, got %q (%v)", text, err)
+	}
+}
+
+func TestParseJFRJSONErrors(t *testing.T) {
+	if _, err := analyzer.ParseJFRJSON(strings.NewReader(`{"recording": {"events": [{"type": "jdk.GarbageCollection", "values": {}}]}}`)); err == nil || !strings.Contains(err.Error(), "no CPU or allocation samples") {
+		t.Errorf("Expected an error without samples, got %v", err)
+	}
+	if _, err := analyzer.ParseJFRJSON(strings.NewReader(`{"recording": {"metadata": {}}}`)); err == nil {
+		t.Errorf("Expected an error without events")
+	}
+
+	p, err := analyzer.ParseJFRJSON(strings.NewReader(`{"recording": {"events": [{"type": "jdk.ObjectAllocationInNewTLAB", "values": {"tlabSize": 65536, "allocationSize": 24,
+		"stackTrace": {"frames": [{"method": {"type": {"name": "A"}, "name": "b"}, "lineNumber": 1}]}}}]}}`))
+	if err != nil {
+		t.Fatalf("ParseJFRJSON failed: %v", err)
+	}
+	if p.DefaultSampleType != "alloc_space" || p.Sample[0].Value[2] != 65536 {
+		t.Errorf("Expected a TLAB allocation weighted by the TLAB size, got default %q, values %v", p.DefaultSampleType, p.Sample[0].Value)
+	}
+}
@@ -0,0 +1,104 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func labeled(s *profile.Sample, key, value string) *profile.Sample {
+	if s.Label == nil {
+		s.Label = make(map[string][]string)
+	}
+	s.Label[key] = append(s.Label[key], value)
+	return s
+}
+
+func TestLabelFilteringAndBreakdown(t *testing.T) {
+	labeledProfile := func() *profile.Profile {
+		sized := stackSample([]int64{1, 100}, "main.upload", "main.main")
+		sized.NumLabel = map[string][]int64{"bytes": {4096}}
+		sized.NumUnit = map[string][]string{"bytes": {"bytes"}}
+		return withLocationTable(cpuProfile(
+			labeled(stackSample([]int64{3, 300}, "encoding/json.Marshal", "main.fooHandler"), "handler", "/api/foo"),
+			labeled(stackSample([]int64{1, 200}, "strings.ToUpper", "main.fooHandler"), "handler", "/api/foo"),
+			labeled(stackSample([]int64{2, 250}, "database/sql.(*DB).Query", "main.barHandler"), "handler", "/api/bar"),
+			sized,
+		))
+	}
+
+	t.Run("TagFilter", func(t *testing.T) {
+		result, err := analyzer.Analyze(labeledProfile(), "cpu", analyzer.WithFormat("json"),
+			analyzer.WithFilters(analyzer.Filters{Tags: "handler=/api/foo"}))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		var parsed analyzer.CPUAnalysisResult
+		if err := json.Unmarshal([]byte(result), &parsed); err != nil {
+			t.Fatalf("Failed to parse result: %v", err)
+		}
+		if parsed.TotalValue != 500 || len(parsed.Functions) != 2 {
+			t.Errorf("Expected only the /api/foo samples, got %+v", parsed)
+		}
+
+		// The regex must match the whole value; numeric labels match with or without their unit
+		for filter, want := range map[string]int{
+			"handler=/api/fo":         0,
+			"handler!=/api/foo":       2,
+			"handler=/api/.*,bytes=1": 0,
+			"bytes=4096":              1,
+			"bytes=4096 bytes":        1,
+		} {
+			p := labeledProfile()
+			_, err := analyzer.Analyze(p, "cpu", analyzer.WithFilters(analyzer.Filters{Tags: filter}))
+			if want == 0 {
+				if err == nil || !strings.Contains(err.Error(), "no samples left after filtering with tags=") {
+					t.Errorf("Expected %q to remove every sample, got %v", filter, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Analyze with %q failed: %v", filter, err)
+			}
+		}
+
+		if _, err := analyzer.Analyze(labeledProfile(), "cpu", analyzer.WithFilters(analyzer.Filters{Tags: "handler"})); err == nil {
+			t.Error("Expected an error for a condition without '='")
+		}
+	})
+
+	t.Run("GroupByLabel", func(t *testing.T) {
+		p := labeledProfile()
+		report := analyzer.BuildLabelBreakdown(p, "cpu", "handler", 1, 1)
+		if report.TotalValue != 850 || len(report.Groups) != 3 || report.Unlabeled != 1 {
+			t.Fatalf("Unexpected breakdown: %+v", report)
+		}
+		foo := report.Groups[0]
+		if foo.Value != "/api/foo" || foo.Total != 500 || len(foo.Functions) != 1 || foo.Functions[0].FunctionName != "encoding/json.Marshal" || foo.Functions[0].Percentage != 60 {
+			t.Errorf("Expected /api/foo first with its top function, got %+v", foo)
+		}
+		if last := report.Groups[2]; last.Value != "(no label)" || last.Total != 100 {
+			t.Errorf("Expected the unlabeled samples in their own group, got %+v", last)
+		}
+
+		text, err := analyzer.Analyze(p, "cpu", analyzer.WithGroupByLabel("tenant"))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if !strings.Contains(text, "No sample carries the label 'tenant' (labels in this profile: bytes, handler)") {
+			t.Errorf("Expected the available labels to be listed:\n%s", text)
+		}
+
+		text, err = analyzer.Analyze(labeledProfile(), "cpu", analyzer.WithGroupByLabel("handler"),
+			analyzer.WithFilters(analyzer.Filters{Tags: "handler!=/api/bar"}))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if strings.Contains(text, "/api/bar") || !strings.Contains(text, "handler=/api/foo") {
+			t.Errorf("Expected the tag filter to apply before the breakdown:\n%s", text)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+// stackSample builds a sample from function names ordered leaf first.
+func stackSample(values []int64, funcs ...string) *profile.Sample {
+	locs := make([]*profile.Location, 0, len(funcs))
+	for i, name := range funcs {
+		locs = append(locs, &profile.Location{
+			ID:   uint64(i + 1),
+			Line: []profile.Line{{Function: &profile.Function{ID: uint64(i + 1), Name: name, Filename: "file.go"}, Line: int64(10 * (i + 1))}},
+		})
+	}
+	return &profile.Sample{Location: locs, Value: values}
+}
+
+func goroutineProfile(samples ...*profile.Sample) *profile.Profile {
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutines", Unit: "count"}},
+		Sample:     samples,
+	}
+}
+
+func heapProfile(samples ...*profile.Sample) *profile.Profile {
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		Sample: samples,
+	}
+}
+
+func TestDetectLeakPattern(t *testing.T) {
+	tests := []struct {
+		name         string
+		pattern      string
+		profiles     analyzer.LeakPatternProfiles
+		wantSuspect  bool
+		wantSiteName string
+	}{
+		{
+			name:    "TimerGrowth",
+			pattern: "timer",
+			profiles: analyzer.LeakPatternProfiles{
+				OldGoroutine: goroutineProfile(stackSample([]int64{2}, "runtime.gopark", "runtime.selectgo", "time.Sleep", "main.poll")),
+				Goroutine:    goroutineProfile(stackSample([]int64{200}, "runtime.gopark", "runtime.selectgo", "time.Sleep", "main.poll")),
+				OldHeap:      heapProfile(stackSample([]int64{1, 64}, "time.NewTicker", "main.startWorker")),
+				Heap:         heapProfile(stackSample([]int64{100, 6400}, "time.NewTicker", "main.startWorker")),
+			},
+			wantSuspect:  true,
+			wantSiteName: "main.startWorker",
+		},
+		{
+			name:    "HTTPConnectionGrowth",
+			pattern: "http",
+			profiles: analyzer.LeakPatternProfiles{
+				OldGoroutine: goroutineProfile(
+					stackSample([]int64{4}, "runtime.gopark", "runtime.selectgo", "net/http.(*persistConn).readLoop"),
+				),
+				Goroutine: goroutineProfile(
+					stackSample([]int64{400}, "runtime.gopark", "runtime.selectgo", "net/http.(*persistConn).readLoop"),
+					stackSample([]int64{400}, "runtime.gopark", "runtime.selectgo", "net/http.(*persistConn).writeLoop"),
+				),
+				Heap: heapProfile(stackSample([]int64{10, 40960}, "bufio.NewReaderSize", "net/http.(*Transport).roundTrip", "net/http.(*Client).do", "main.fetchUser")),
+			},
+			wantSuspect:  true,
+			wantSiteName: "main.fetchUser",
+		},
+		{
+			name:    "ContextGrowth",
+			pattern: "context",
+			profiles: analyzer.LeakPatternProfiles{
+				OldHeap: heapProfile(stackSample([]int64{10, 800}, "context.withCancel", "context.WithTimeout", "main.handleRequest")),
+				Heap:    heapProfile(stackSample([]int64{1000, 80000}, "context.withCancel", "context.WithTimeout", "main.handleRequest")),
+			},
+			wantSuspect:  true,
+			wantSiteName: "main.handleRequest",
+		},
+		{
+			name:    "TimerAbsent",
+			pattern: "timer",
+			profiles: analyzer.LeakPatternProfiles{
+				Goroutine: goroutineProfile(stackSample([]int64{5}, "runtime.gopark", "main.serve")),
+			},
+			wantSuspect: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := analyzer.DetectLeakPattern(tt.pattern, tt.profiles, 10, "json")
+			if err != nil {
+				t.Fatalf("Error detecting leak pattern: %v", err)
+			}
+			var parsed analyzer.LeakPatternResult
+			if err := json.Unmarshal([]byte(result), &parsed); err != nil {
+				t.Fatalf("Error parsing JSON result: %v", err)
+			}
+			if parsed.Suspected != tt.wantSuspect {
+				t.Errorf("Expected suspected=%t, got %t.\nResult: %s", tt.wantSuspect, parsed.Suspected, result)
+			}
+			if tt.wantSiteName != "" && !strings.Contains(result, tt.wantSiteName) {
+				t.Errorf("Expected result to mention call site '%s'.\nResult: %s", tt.wantSiteName, result)
+			}
+
+			text, err := analyzer.DetectLeakPattern(tt.pattern, tt.profiles, 10, "text")
+			if err != nil {
+				t.Fatalf("Error detecting leak pattern with text format: %v", err)
+			}
+			if !strings.Contains(text, "Leak Pattern Report") {
+				t.Errorf("Expected text report header.\nResult: %s", text)
+			}
+		})
+	}
+
+	// Unknown patterns and missing profiles are errors
+	if _, err := analyzer.DetectLeakPattern("unknown", analyzer.LeakPatternProfiles{Goroutine: goroutineProfile()}, 10, "text"); err == nil {
+		t.Error("Expected error for unknown pattern, but got nil")
+	}
+	if _, err := analyzer.DetectLeakPattern("timer", analyzer.LeakPatternProfiles{}, 10, "text"); err == nil {
+		t.Error("Expected error when no profiles are given, but got nil")
+	}
+}
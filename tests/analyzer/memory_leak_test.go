@@ -108,3 +108,71 @@ func TestDetectPotentialMemoryLeaks(t *testing.T) {
 		t.Error("Expected error for missing inuse_space sample type, but got nil")
 	}
 }
+
+func TestRankByMateriality(t *testing.T) {
+	changes := []analyzer.DiffChange{
+		analyzer.NewDiffChange("big-but-flat", 1000000, 1010000), // +10000, +1%   -> score 100
+		analyzer.NewDiffChange("small-but-steep", 100, 1000),     // +900,   +900% -> score 8100
+		analyzer.NewDiffChange("material", 100000, 150000),       // +50000, +50%  -> score 25000
+		analyzer.NewDiffChange("shrinking", 10000, 5000),         // -5000,  -50%  -> score 2500
+		analyzer.NewDiffChange("vanished", 2000, 0),              // -2000,  -100% -> score 2000
+		analyzer.NewDiffChange("unchanged", 500, 500),
+	}
+
+	regressions, improvements := analyzer.RankByMateriality(changes, 2)
+	if len(regressions) != 2 || regressions[0].Name != "material" || regressions[1].Name != "small-but-steep" {
+		t.Errorf("Unexpected regressions ranking: %+v", regressions)
+	}
+	if len(improvements) != 2 || improvements[0].Name != "shrinking" || improvements[1].Name != "vanished" {
+		t.Errorf("Unexpected improvements ranking: %+v", improvements)
+	}
+	if improvements[1].DeltaPercent != -100 {
+		t.Errorf("Expected a vanished entry to count as -100%%, got %.2f", improvements[1].DeltaPercent)
+	}
+}
+
+func TestDetectPotentialMemoryLeaksIgnoreList(t *testing.T) {
+	heapProfile := func(noiseBytes, appBytes int64) *profile.Profile {
+		sample := func(fn, typ string, bytes int64) *profile.Sample {
+			return &profile.Sample{
+				Location: []*profile.Location{{Line: []profile.Line{{Function: &profile.Function{Name: fn}}}}},
+				Value:    []int64{bytes, 1},
+				Label:    map[string][]string{"type": {typ}},
+			}
+		}
+		return withLocationTable(&profile.Profile{
+			SampleType: []*profile.ValueType{{Type: "inuse_space", Unit: "bytes"}, {Type: "inuse_objects", Unit: "count"}},
+			Sample:     []*profile.Sample{sample("runtime.futex", "NoiseType", noiseBytes), sample("main.cache", "AppType", appBytes)},
+		})
+	}
+
+	ignore, err := analyzer.ParseIgnoreList(`runtime\.futex, ,runtime\.netpoll`)
+	if err != nil {
+		t.Fatalf("ParseIgnoreList failed: %v", err)
+	}
+	if len(ignore) != 2 {
+		t.Fatalf("Expected 2 patterns (empty elements skipped), got %d", len(ignore))
+	}
+	if _, err := analyzer.ParseIgnoreList("runtime.(futex"); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+
+	oldProfile, newProfile := heapProfile(1000, 1000), heapProfile(9000, 2000)
+	result, err := analyzer.DetectPotentialMemoryLeaksWithOptions(oldProfile, newProfile, 0.1, 10,
+		analyzer.WithIgnoreFunctions(ignore))
+	if err != nil {
+		t.Fatalf("Error detecting memory leaks: %v", err)
+	}
+	if strings.Contains(result, "NoiseType") {
+		t.Errorf("Expected ignored function's allocations to be excluded, got:\n%s", result)
+	}
+	for _, expected := range []string{"AppType", `Ignored functions: runtime\.futex`, "excluded 1 old / 1 new samples"} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("Expected result to contain '%s', got:\n%s", expected, result)
+		}
+	}
+	// The caller's profiles may be shared (e.g. pooled), so they must not be modified
+	if len(oldProfile.Sample) != 2 || len(newProfile.Sample) != 2 {
+		t.Errorf("Expected the input profiles to keep their samples, got %d old / %d new", len(oldProfile.Sample), len(newProfile.Sample))
+	}
+}
@@ -0,0 +1,86 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestParseMinSampleValue(t *testing.T) {
+	cases := []struct {
+		in   string
+		want analyzer.MinSampleValue
+	}{
+		{"", analyzer.MinSampleValue{}},
+		{"1000000", analyzer.MinSampleValue{Value: 1000000}},
+		{" 0.5 % ", analyzer.MinSampleValue{Percent: 0.5}},
+	}
+	for _, tc := range cases {
+		got, err := analyzer.ParseMinSampleValue(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseMinSampleValue(%q): expected %+v, got %+v (%v)", tc.in, tc.want, got, err)
+		}
+	}
+	for _, in := range []string{"-1", "150%", "1ms", "abc%"} {
+		if _, err := analyzer.ParseMinSampleValue(in); err == nil {
+			t.Errorf("ParseMinSampleValue(%q): expected an error", in)
+		}
+	}
+}
+
+func TestDropSmallSamples(t *testing.T) {
+	p := cpuProfile(
+		stackSample([]int64{90, 900}, "main.hot", "main.main"),
+		stackSample([]int64{5, 50}, "main.warm", "main.main"),
+		stackSample([]int64{3, 30}, "main.noise1", "main.main"),
+		stackSample([]int64{2, 20}, "main.noise2", "main.main"),
+	)
+
+	// 5% of the 1000ns total is 50ns: the two lightest samples are dropped
+	dropped, stats, err := analyzer.DropSmallSamples(p, 1, analyzer.MinSampleValue{Percent: 5})
+	if err != nil {
+		t.Fatalf("DropSmallSamples failed: %v", err)
+	}
+	if stats.Threshold != 50 || stats.DroppedSamples != 2 || stats.DroppedValue != 50 || stats.Total != 1000 || stats.DroppedPercent() != 5 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if len(dropped.Sample) != 2 || dropped.Sample[1] != p.Sample[1] || len(p.Sample) != 4 {
+		t.Errorf("Expected the two heaviest samples kept and the original left unmodified, got %d samples", len(dropped.Sample))
+	}
+
+	same, stats, err := analyzer.DropSmallSamples(p, 1, analyzer.MinSampleValue{Value: 10})
+	if err != nil || same != p || stats.DroppedSamples != 0 {
+		t.Errorf("Expected the profile returned unchanged when nothing is dropped, got %+v (%v)", stats, err)
+	}
+
+	text, err := analyzer.Analyze(p, "cpu", analyzer.WithMinSampleValue(analyzer.MinSampleValue{Value: 50}), analyzer.WithTopN(5))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !strings.HasPrefix(text, "Note: dropped 2 of 4 samples below 50ns (min_sample_value 50); they weighed 50ns, 5.00% of the total.") {
+		t.Errorf("Expected the dropped weight to be reported:\n%s", text)
+	}
+	if strings.Contains(text, "main.noise1") || !strings.Contains(text, "main.warm") {
+		t.Errorf("Expected only the samples below the threshold to be dropped:\n%s", text)
+	}
+
+	jsonText, err := analyzer.Analyze(p, "cpu", analyzer.WithMinSampleValue(analyzer.MinSampleValue{Percent: 5}), analyzer.WithFormat("json"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var result struct {
+		MinSampleValue analyzer.MinValueSummary `json:"minSampleValue"`
+	}
+	if err := json.Unmarshal([]byte(jsonText), &result); err != nil {
+		t.Fatal(err)
+	}
+	if got := result.MinSampleValue; got.MinSampleValue != "5%" || got.DroppedSamples != 2 || got.DroppedPercent != 5 {
+		t.Errorf("Unexpected minSampleValue in the JSON result: %+v", got)
+	}
+
+	if _, err := analyzer.Analyze(p, "cpu", analyzer.WithMinSampleValue(analyzer.MinSampleValue{Value: 1000})); err == nil || !strings.Contains(err.Error(), "all 4 samples are below") {
+		t.Errorf("Expected an error when every sample is dropped, got %v", err)
+	}
+}
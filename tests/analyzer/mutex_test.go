@@ -0,0 +1,72 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestAnalyzeMutexProfile(t *testing.T) {
+	p := contentionProfile(
+		// The cache lock, released from three different callers
+		stackSample([]int64{100, 3e8}, "sync.(*Mutex).Unlock", "main.(*Cache).Get", "main.handleUsers"),
+		stackSample([]int64{100, 2e8}, "sync.(*Mutex).Unlock", "main.(*Cache).Get", "main.handleOrders"),
+		stackSample([]int64{50, 1e8}, "sync.(*Mutex).Unlock", "main.(*Cache).Get", "main.warmup"),
+		// Few contentions, but each one waits long
+		stackSample([]int64{10, 2e8}, "sync.(*Mutex).Unlock", "main.(*Log).Flush", "main.run"),
+		// Readers holding the config lock delay its writer
+		stackSample([]int64{200, 1.5e8}, "sync.(*RWMutex).rUnlockSlow", "sync.(*RWMutex).RUnlock", "main.(*Config).Load", "main.run"),
+		stackSample([]int64{5, 0.5e8}, "sync.(*Mutex).Unlock", "sync.(*RWMutex).Unlock", "main.(*Config).Store", "main.reload"),
+	)
+
+	jsonText, err := analyzer.Analyze(p, "mutex", analyzer.WithTopN(10), analyzer.WithFormat("json"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var result analyzer.MutexAnalysisResult
+	if err := json.Unmarshal([]byte(jsonText), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if result.TotalDelay != 1e9 || result.TotalContentions != 465 || len(result.Sites) != 4 {
+		t.Fatalf("Unexpected totals: %+v", result)
+	}
+	if s := result.Sites[0]; s.Site != "main.(*Cache).Get" || s.Lock != "Mutex" || s.Stacks != 3 || s.Percentage != 60 || s.AvgWaitFormatted != "2.00ms" {
+		t.Errorf("Unexpected top site: %+v", s)
+	}
+	if s := result.Sites[2]; s.Site != "main.(*Config).Load" || s.Lock != "RWMutex (read)" {
+		t.Errorf("Expected the RWMutex read path third, got %+v", s)
+	}
+
+	want := []struct {
+		kind, site string
+	}{
+		{"shard", "main.(*Cache).Get"},
+		{"long-critical-section", "main.(*Log).Flush"},
+		{"rwmutex-read-path", ""},
+	}
+	if len(result.Recommendations) != len(want) {
+		t.Fatalf("Expected %d recommendations, got %+v", len(want), result.Recommendations)
+	}
+	for i, w := range want {
+		if r := result.Recommendations[i]; r.Kind != w.kind || r.Site != w.site || r.Message == "" {
+			t.Errorf("Recommendation %d: expected %s for %q, got %+v", i, w.kind, w.site, r)
+		}
+	}
+
+	text, err := analyzer.AnalyzeMutexProfile(p, 2, "text")
+	if err != nil {
+		t.Fatalf("AnalyzeMutexProfile failed: %v", err)
+	}
+	for _, s := range []string{"Total Delay: 1.00s over 465 contentions", "=== Recommendations ===",
+		"1. The Mutex released in main.(*Cache).Get is contended by 3 stacks", "RWMutex read path dominates"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("Expected %q in:\n%s", s, text)
+		}
+	}
+
+	if _, err := analyzer.AnalyzeMutexProfile(goroutineProfile(), 5, "text"); err == nil {
+		t.Error("Expected an error without a delay sample type")
+	}
+}
@@ -0,0 +1,395 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestAnalyzeWithOptions(t *testing.T) {
+	cpuProfile := func() *profile.Profile {
+		return cpuProfile(
+			stackSample([]int64{3, 300}, "encoding/json.Marshal", "main.(*server).SayHello", "main.main"),
+			stackSample([]int64{2, 500}, "strings.ToUpper", "main.usersHandler", "main.main"),
+			stackSample([]int64{1, 100}, "runtime.gcBgMarkWorker"),
+		)
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		o := analyzer.NewOptions()
+		if o.TopN != 5 || o.Format != "text" || o.SortBy != "flat" {
+			t.Errorf("Unexpected default options: %+v", o)
+		}
+	})
+
+	t.Run("MatchesPositionalWrapper", func(t *testing.T) {
+		want, err := analyzer.AnalyzeCPUProfile(cpuProfile(), 3, "json")
+		if err != nil {
+			t.Fatalf("AnalyzeCPUProfile failed: %v", err)
+		}
+		got, err := analyzer.Analyze(cpuProfile(), "cpu", analyzer.WithTopN(3), analyzer.WithFormat("json"))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		// Analyze adds the profile's sample types (see SummarizeSampleTypes); the rest must be identical
+		var gotFields, wantFields map[string]interface{}
+		if err := json.Unmarshal([]byte(got), &gotFields); err != nil {
+			t.Fatalf("Failed to parse Analyze result: %v", err)
+		}
+		if err := json.Unmarshal([]byte(want), &wantFields); err != nil {
+			t.Fatalf("Failed to parse AnalyzeCPUProfile result: %v", err)
+		}
+		if _, ok := gotFields["sampleTypes"]; !ok {
+			t.Errorf("Expected Analyze to add sampleTypes:\n%s", got)
+		}
+		delete(gotFields, "sampleTypes")
+		if !reflect.DeepEqual(gotFields, wantFields) {
+			t.Errorf("Analyze with options differs from AnalyzeCPUProfile:\n%s\nvs\n%s", got, want)
+		}
+	})
+
+	t.Run("SampleTypeAndFilters", func(t *testing.T) {
+		p := withLocationTable(cpuProfile())
+		result, err := analyzer.Analyze(p, "cpu",
+			analyzer.WithFormat("json"),
+			analyzer.WithSampleType("samples"),
+			analyzer.WithFilters(analyzer.Filters{Ignore: `SayHello`}),
+		)
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		var parsed analyzer.CPUAnalysisResult
+		if err := json.Unmarshal([]byte(result), &parsed); err != nil {
+			t.Fatalf("Failed to parse result: %v", err)
+		}
+		if parsed.ValueType != "samples" {
+			t.Errorf("Expected the samples value type, got %s", parsed.ValueType)
+		}
+		for _, fn := range parsed.Functions {
+			if strings.Contains(fn.FunctionName, "SayHello") {
+				t.Errorf("Expected SayHello stacks to be ignored, got %+v", parsed.Functions)
+			}
+		}
+		if len(p.Sample) != len(cpuProfile().Sample) {
+			t.Errorf("Expected the caller's profile not to be modified")
+		}
+	})
+
+	t.Run("FocusAcrossAnalyzers", func(t *testing.T) {
+		flamegraph, err := analyzer.Analyze(withLocationTable(cpuProfile()), "cpu",
+			analyzer.WithFormat("flamegraph-json"),
+			analyzer.WithFilters(analyzer.Filters{Focus: `usersHandler`}),
+		)
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if !strings.Contains(flamegraph, "strings.ToUpper") || strings.Contains(flamegraph, "SayHello") || !strings.Contains(flamegraph, `"value":500`) {
+			t.Errorf("Expected only the usersHandler stack in the flame graph, got:\n%s", flamegraph)
+		}
+
+		goroutines := withLocationTable(goroutineProfile(
+			stackSample([]int64{40}, "runtime.gopark", "main.worker"),
+			stackSample([]int64{2}, "runtime.gopark", "net/http.(*conn).serve"),
+		))
+		text, err := analyzer.Analyze(goroutines, "goroutine", analyzer.WithFilters(analyzer.Filters{Ignore: `main\.worker`}))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if strings.Contains(text, "main.worker") || !strings.Contains(text, "net/http.(*conn).serve") {
+			t.Errorf("Expected the worker goroutines to be ignored, got:\n%s", text)
+		}
+
+		_, err = analyzer.Analyze(withLocationTable(cpuProfile()), "cpu", analyzer.WithFilters(analyzer.Filters{Focus: `nothing`}))
+		if err == nil || !strings.Contains(err.Error(), "no samples left after filtering with focus=nothing") {
+			t.Errorf("Expected an error when the filters remove every sample, got %v", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := analyzer.Analyze(cpuProfile(), "cpu", analyzer.WithSortBy("name")); err == nil {
+			t.Error("Expected an error for an unsupported sort order")
+		}
+		if _, err := analyzer.Analyze(cpuProfile(), "cpu", analyzer.WithSampleType("alloc_space")); err == nil {
+			t.Error("Expected an error for a missing sample type")
+		}
+		if _, err := analyzer.Analyze(cpuProfile(), "trace"); err == nil {
+			t.Error("Expected an error for an unsupported profile type")
+		}
+	})
+}
+
+func TestAnalyzeCacheKey(t *testing.T) {
+	p := cpuProfile(
+		stackSample([]int64{3, 300}, "main.a", "main.main"),
+		stackSample([]int64{2, 500}, "main.b", "main.main"),
+	)
+	first, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("json"), analyzer.WithCacheKey("TestAnalyzeCacheKey"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !strings.Contains(first, "main.b") {
+		t.Fatalf("Expected main.b in the result, got:\n%s", first)
+	}
+
+	// A changed profile under the same key reuses the cached aggregation, even with another top_n;
+	// a different key recomputes it
+	p.Sample[1].Location[0].Line[0].Function.Name = "main.renamed"
+	cached, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("json"), analyzer.WithTopN(1), analyzer.WithCacheKey("TestAnalyzeCacheKey"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !strings.Contains(cached, "main.b") || strings.Contains(cached, "main.a") {
+		t.Errorf("Expected the cached aggregation limited to the top function, got:\n%s", cached)
+	}
+	fresh, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("json"), analyzer.WithCacheKey("TestAnalyzeCacheKey-2"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !strings.Contains(fresh, "main.renamed") {
+		t.Errorf("Expected a new key to recompute the aggregation, got:\n%s", fresh)
+	}
+}
+
+func TestResolveProfileType(t *testing.T) {
+	cases := map[string]string{
+		"memory":     "heap",
+		" Heap ":     "heap",
+		"contention": "mutex",
+		"blocking":   "block",
+		"goroutines": "goroutine",
+		"CPU":        "cpu",
+		"weird":      "weird",
+	}
+	for input, want := range cases {
+		if got := analyzer.ResolveProfileType(input); got != want {
+			t.Errorf("ResolveProfileType(%q) = %q, want %q", input, got, want)
+		}
+	}
+
+	p := cpuProfile(stackSample([]int64{1, 100}, "main.work", "main.main"))
+	if _, err := analyzer.Analyze(p, "Profile", analyzer.WithFormat("json")); err != nil {
+		t.Errorf("Expected the 'profile' alias to analyze as cpu, got: %v", err)
+	}
+}
+
+func TestProfileTypeNames(t *testing.T) {
+	got := analyzer.ProfileTypeNames("heap", "mutex")
+	want := []string{"heap", "mutex", "contention", "lock", "locks", "mem", "memory"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ProfileTypeNames() = %v, want %v", got, want)
+	}
+	for _, name := range got {
+		if resolved := analyzer.ResolveProfileType(name); resolved != "heap" && resolved != "mutex" {
+			t.Errorf("%q resolves to %q, outside the requested types", name, resolved)
+		}
+	}
+}
+
+func TestAnalyzeMarkdownCompact(t *testing.T) {
+	if got := analyzer.AbbreviateFunctionName("github.com/org/repo/pkg.(*T).Method"); got != "pkg.(*T).Method" {
+		t.Errorf("Unexpected abbreviation: %s", got)
+	}
+	if got := analyzer.AbbreviateFunctionName("pkg.F[go.shape.*github.com/x.T]"); got != "pkg.F[go.shape.*github.com/x.T]" {
+		t.Errorf("Expected generic type arguments to be left alone, got: %s", got)
+	}
+
+	samples := make([]*profile.Sample, 0)
+	for i := 0; i < 50; i++ {
+		samples = append(samples, stackSample([]int64{1, int64(100 + i)}, fmt.Sprintf("github.com/org/repo/internal/worker.step%d", i), "main.main"))
+	}
+	p := cpuProfile(samples...)
+
+	result, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("markdown-compact"), analyzer.WithTopN(50), analyzer.WithCharBudget(600))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if len(result) > 700 {
+		t.Errorf("Expected the report to stay close to the 600 character budget, got %d characters:\n%s", len(result), result)
+	}
+	for _, want := range []string{"worker.step49", "Top stacks", "lines omitted"} {
+		if !strings.Contains(result, want) {
+			t.Errorf("Expected %q in the report:\n%s", want, result)
+		}
+	}
+	if strings.Contains(result, "github.com/") {
+		t.Errorf("Expected abbreviated function names:\n%s", result)
+	}
+}
+
+func TestAnalyzeSampleTypeSummary(t *testing.T) {
+	p := cpuProfile(
+		stackSample([]int64{3, 300}, "main.a", "main.main"),
+		stackSample([]int64{2, 500}, "main.b", "main.main"),
+	)
+	summaries := analyzer.SummarizeSampleTypes(p)
+	if len(summaries) != 2 || summaries[0].Total != 5 || summaries[1].Total != 800 || !summaries[1].Default {
+		t.Fatalf("Unexpected sample type summaries: %+v", summaries)
+	}
+
+	text, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("text"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !strings.Contains(text, "Sample Types") || !strings.Contains(text, "samples") {
+		t.Errorf("Expected a sample type table in the text report:\n%s", text)
+	}
+
+	jsonResult, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("json"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	var parsed struct {
+		SampleTypes []analyzer.SampleTypeSummary `json:"sampleTypes"`
+		TotalValue  int64                        `json:"totalValue"`
+	}
+	if err := json.Unmarshal([]byte(jsonResult), &parsed); err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, jsonResult)
+	}
+	if len(parsed.SampleTypes) != 2 || parsed.TotalValue != 800 {
+		t.Errorf("Unexpected JSON result: %+v", parsed)
+	}
+
+	// The flame graph layout is fixed; its sample types are formatted separately
+	flamegraph, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("flamegraph-json"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if analyzer.ReportsSampleTypes("flamegraph-json") || strings.Contains(flamegraph, "sampleTypes") {
+		t.Errorf("Expected no sample types in the flame graph JSON:\n%s", flamegraph)
+	}
+	if table := analyzer.FormatSampleTypes(p, "text"); !strings.Contains(table, "Sample Types") || !strings.Contains(table, "samples") {
+		t.Errorf("Expected a sample type table, got:\n%s", table)
+	}
+}
+
+func TestAnalyzeAggregationLevel(t *testing.T) {
+	newProfile := func() *profile.Profile {
+		return withLocationTable(cpuProfile(
+			fileSample(300, "example.com/store.(*DB).Query", "store/db.go"),
+			fileSample(200, "example.com/store.encode", "store/codec.go"),
+			fileSample(400, "main.handle", "main.go"),
+			stackSample([]int64{1, 100}, "encoding/json.Marshal", "example.com/store.(*DB).Query", "example.com/store.(*DB).Exec", "main.handle"),
+		))
+	}
+
+	t.Run("Package", func(t *testing.T) {
+		result, err := analyzer.Analyze(newProfile(), "cpu", analyzer.WithFormat("json"), analyzer.WithSortBy("cum"), analyzer.WithAggregationLevel("package"))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		var parsed struct {
+			Functions []struct {
+				FunctionName string `json:"functionName"`
+				FlatValue    int64  `json:"flatValue"`
+				CumValue     int64  `json:"cumValue"`
+			} `json:"functions"`
+		}
+		if err := json.Unmarshal([]byte(result), &parsed); err != nil {
+			t.Fatalf("Failed to parse result: %v\n%s", err, result)
+		}
+		got := make(map[string][2]int64)
+		for _, f := range parsed.Functions {
+			got[f.FunctionName] = [2]int64{f.FlatValue, f.CumValue}
+		}
+		// Both store functions roll up into one package; its two frames in the last stack count once in cum
+		want := map[string][2]int64{
+			"example.com/store": {500, 600},
+			"main":              {400, 500},
+			"encoding/json":     {100, 100},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected package rollup %v, want %v\n%s", got, want, result)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		result, err := analyzer.Analyze(newProfile(), "cpu", analyzer.WithAggregationLevel("file"))
+		if err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if !strings.Contains(result, "Note: aggregated by file") || !strings.Contains(result, "store/codec.go") || strings.Contains(result, "store.encode") {
+			t.Errorf("Expected functions rolled up by source file:\n%s", result)
+		}
+	})
+
+	t.Run("InputUnchanged", func(t *testing.T) {
+		p := newProfile()
+		if _, err := analyzer.Analyze(p, "cpu", analyzer.WithAggregationLevel("package")); err != nil {
+			t.Fatalf("Analyze failed: %v", err)
+		}
+		if name := p.Sample[0].Location[0].Line[0].Function.Name; name != "example.com/store.(*DB).Query" {
+			t.Errorf("Expected the caller's profile to keep its function names, got %s", name)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := analyzer.Analyze(newProfile(), "cpu", analyzer.WithAggregationLevel("module")); err == nil || !strings.Contains(err.Error(), "unsupported aggregation level") {
+			t.Errorf("Expected an unsupported aggregation level error, got %v", err)
+		}
+		if _, err := analyzer.Analyze(newProfile(), "heap", analyzer.WithGroupBy("package"), analyzer.WithAggregationLevel("package")); err == nil {
+			t.Error("Expected group_by 'package' to be rejected with a package rollup")
+		}
+	})
+}
+
+func TestExcludeTestFrames(t *testing.T) {
+	testFunc := stackSample([]int64{1, 100}, "example.com/app/store.(*Store).Get", "example.com/app/store.TestGet", "testing.tRunner")
+	testFunc.Location[1].Line[0].Function.Filename = "/src/app/store/store_test.go"
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{3, 300}, "github.com/golang/mock/gomock.(*Controller).Call", "example.com/app/internal/mocks.(*MockDB).Query",
+			"example.com/app/store.(*Store).Get", "main.main"),
+		testFunc,
+	))
+
+	result, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("text"), analyzer.WithFilters(analyzer.Filters{ExcludeTestFrames: true}))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, excluded := range []string{"gomock", "MockDB", "TestGet"} {
+		if strings.Contains(result, excluded) {
+			t.Errorf("Expected %s to be excluded:\n%s", excluded, result)
+		}
+	}
+	// The mocks' cost goes to their production caller, which is now the leaf of both stacks
+	if !strings.Contains(result, "400ns") || !strings.Contains(result, "(*Store).Get") {
+		t.Errorf("Expected (*Store).Get to carry the flat cost of both stacks:\n%s", result)
+	}
+
+	if !strings.Contains(mustAnalyze(t, p), "gomock") {
+		t.Errorf("Expected test frames to be kept by default")
+	}
+
+	pattern := regexp.MustCompile(analyzer.TestFramesPattern)
+	for name, want := range map[string]bool{
+		"github.com/stretchr/testify/mock.(*Mock).Called": true,
+		"example.com/app/mock_store.(*MockStore).Get":     true,
+		"example.com/app/storemock.(*Store).Get":          true,
+		"/src/app/store/store_test.go":                    true,
+		"example.com/app/store.(*Store).Get":              false,
+		"example.com/mockingbird.Sing":                    false,
+		"/src/app/store/test.go":                          false,
+	} {
+		if got := pattern.MatchString(name); got != want {
+			t.Errorf("TestFramesPattern on %q: expected %t, got %t", name, want, got)
+		}
+	}
+	if hide := (analyzer.Filters{Hide: "runtime\\.", ExcludeTestFrames: true}).HidePattern(); hide != "runtime\\.|"+analyzer.TestFramesPattern {
+		t.Errorf("Unexpected combined hide pattern: %s", hide)
+	}
+}
+
+// mustAnalyze returns the text analysis of a CPU profile with the default options.
+func mustAnalyze(t *testing.T, p *profile.Profile) string {
+	t.Helper()
+	result, err := analyzer.Analyze(p, "cpu", analyzer.WithFormat("text"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	return result
+}
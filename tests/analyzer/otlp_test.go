@@ -0,0 +1,97 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestConvertProfileToOTLP(t *testing.T) {
+	labeled := stackSample([]int64{1, 300}, "main.parse", "main.main")
+	labeled.Label = map[string][]string{"tenant": {"acme"}}
+	labeled.NumLabel = map[string][]int64{"bytes": {512}}
+	labeled.NumUnit = map[string][]string{"bytes": {"bytes"}}
+	p := withLocationTable(cpuProfile(
+		labeled,
+		stackSample([]int64{1, 100}, "main.log", "main.main"),
+	))
+
+	data, err := analyzer.ConvertProfileToOTLP(p, "checkout")
+	if err != nil {
+		t.Fatalf("ConvertProfileToOTLP failed: %v", err)
+	}
+	resource := data.ResourceProfiles[0]
+	if attrs := resource.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "checkout" {
+		t.Errorf("Expected the service.name resource attribute, got %+v", attrs)
+	}
+	container := resource.ScopeProfiles[0].Profiles[0]
+	if len(container.ProfileID) != 32 {
+		t.Errorf("Expected a 16-byte hex profile ID, got %q", container.ProfileID)
+	}
+	out := container.Profile
+	str := func(i int64) string { return out.StringTable[i] }
+	if out.StringTable[0] != "" {
+		t.Errorf("Expected the string table to start with the empty string, got %q", out.StringTable[0])
+	}
+	if len(out.SampleType) != 2 || str(out.SampleType[1].Type) != "cpu" || str(out.SampleType[1].Unit) != "nanoseconds" {
+		t.Errorf("Unexpected sample types: %+v", out.SampleType)
+	}
+	if len(out.Sample) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(out.Sample))
+	}
+
+	// The stack of a sample is a range of locationIndices, leaf first
+	first := out.Sample[0]
+	stack := make([]string, 0, first.LocationsLength)
+	for _, li := range out.LocationIndices[first.LocationsStartIndex : first.LocationsStartIndex+first.LocationsLength] {
+		line := out.Location[li].Line[0]
+		stack = append(stack, str(out.Function[line.FunctionIndex].Name))
+	}
+	if len(stack) != 2 || stack[0] != "main.parse" || stack[1] != "main.main" || first.Value[1] != 300 {
+		t.Errorf("Unexpected first sample: stack %v, values %v", stack, first.Value)
+	}
+
+	attrs := make(map[string]analyzer.OTLPAnyValue)
+	for _, i := range first.Attributes {
+		attrs[out.AttributeTable[i].Key] = out.AttributeTable[i].Value
+	}
+	if v := attrs["tenant"]; v.StringValue == nil || *v.StringValue != "acme" {
+		t.Errorf("Expected the tenant label as a string attribute, got %+v", attrs)
+	}
+	if v := attrs["bytes"]; v.IntValue == nil || *v.IntValue != "512" {
+		t.Errorf("Expected the bytes label as an int attribute, got %+v", attrs)
+	}
+	if len(out.AttributeUnits) != 1 || str(out.AttributeUnits[0].AttributeKey) != "bytes" || str(out.AttributeUnits[0].Unit) != "bytes" {
+		t.Errorf("Expected the unit of the bytes label, got %+v", out.AttributeUnits)
+	}
+	if len(out.Sample[1].Attributes) != 0 {
+		t.Errorf("Expected no attributes on the unlabeled sample, got %v", out.Sample[1].Attributes)
+	}
+
+	again, err := analyzer.ConvertProfileToOTLP(p, "")
+	if err != nil {
+		t.Fatalf("ConvertProfileToOTLP failed: %v", err)
+	}
+	if id := again.ResourceProfiles[0].ScopeProfiles[0].Profiles[0].ProfileID; id != container.ProfileID {
+		t.Errorf("Expected the same profile ID for the same profile, got %q and %q", container.ProfileID, id)
+	}
+	if attrs := again.ResourceProfiles[0].Resource.Attributes; len(attrs) != 0 {
+		t.Errorf("Expected no resource attributes without a service name, got %+v", attrs)
+	}
+}
+
+func TestFormatOTLPJSON(t *testing.T) {
+	p := withLocationTable(cpuProfile(stackSample([]int64{1, 100}, "main.main")))
+	body, err := analyzer.FormatOTLPJSON(p, "api")
+	if err != nil {
+		t.Fatalf("FormatOTLPJSON failed: %v", err)
+	}
+	var decoded analyzer.OTLPProfilesData
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Invalid OTLP JSON: %v", err)
+	}
+	if n := len(decoded.ResourceProfiles[0].ScopeProfiles[0].Profiles[0].Profile.Sample); n != 1 {
+		t.Errorf("Expected 1 sample after a round trip, got %d", n)
+	}
+}
@@ -0,0 +1,118 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// perfScriptOutput is the output of 'perf record -g -e cpu-clock' followed by 'perf script', with a kernel
+// frame, a C++ symbol with parentheses and an unknown frame.
+const perfScriptOutput = `# ========
+# captured on    : Thu Oct 15 10:00:00 2026
+# ========
+#
+nginx 1234/1240 [002] 5231.500000:     250000 cpu-clock:pppH:
+	ffffffff8100 native_safe_halt+0x6 ([kernel.kallsyms])
+	55d3a4 ngx_process_events+0x1c (/usr/sbin/nginx)
+	55d000 main (/usr/sbin/nginx)
+
+nginx 1234/1240 [002] 5231.750000:     250000 cpu-clock:pppH:
+	55d3a4 ngx_process_events+0x1c (/usr/sbin/nginx)
+	55d000 main (/usr/sbin/nginx)
+
+Web Content 4321 [000] 5232.500000:     500000 cpu-clock:pppH:
+	7f12 Foo::bar(int) const (/usr/lib/libxul.so)
+	0 [unknown] ([unknown])
+`
+
+func TestIsPerfScript(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data string
+		want bool
+	}{
+		"CallChains":    {perfScriptOutput, true},
+		"NoCallChains":  {"app 99 10.0: 1 cycles:u:  4005d6 work (/app)\napp 99 10.5: 1 cycles:u:  4005e0 main (/app)\n", true},
+		"LegacyHeap":    {"heap profile: 1: 8192 [1: 8192] @ heap/1048576\n1: 8192 [1: 8192] @ 0x1 0x2\n", false},
+		"GoroutineText": {"goroutine profile: total 2\n2 @ 0x43 0x44\n#\t0x43\truntime.gopark+0x1\n", false},
+		"Folded":        {"main;work 10\n", false},
+		"Binary":        {"\x1f\x8b\x08\x00\x00", false},
+	} {
+		if got := analyzer.IsPerfScript([]byte(tc.data)); got != tc.want {
+			t.Errorf("%s: IsPerfScript = %t, want %t", name, got, tc.want)
+		}
+	}
+}
+
+func TestParsePerfScript(t *testing.T) {
+	p, err := analyzer.ParsePerfScript([]byte(perfScriptOutput))
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	if len(p.SampleType) != 2 || p.SampleType[1].Type != "cpu" || p.SampleType[1].Unit != "nanoseconds" || p.DefaultSampleType != "cpu" {
+		t.Fatalf("Expected samples/count and cpu/nanoseconds, got %v (default %q)", p.SampleType, p.DefaultSampleType)
+	}
+	if len(p.Sample) != 3 || p.DurationNanos != 1e9 {
+		t.Fatalf("Expected 3 samples over 1s, got %d over %dns", len(p.Sample), p.DurationNanos)
+	}
+
+	first := p.Sample[0]
+	names := make([]string, 0, len(first.Location))
+	for _, loc := range first.Location {
+		names = append(names, loc.Line[0].Function.Name)
+	}
+	if strings.Join(names, ";") != "native_safe_halt;ngx_process_events;main" || first.Value[0] != 1 || first.Value[1] != 250000 {
+		t.Errorf("Unexpected first sample: frames %v, values %v", names, first.Value)
+	}
+	if first.Location[0].Mapping.File != "[kernel.kallsyms]" || first.Location[0].Address != 0xffffffff8100 {
+		t.Errorf("Unexpected leaf location: %+v", first.Location[0])
+	}
+	if first.Label["comm"][0] != "nginx" || first.NumLabel["pid"][0] != 1234 || first.NumLabel["tid"][0] != 1240 {
+		t.Errorf("Unexpected labels: %v %v", first.Label, first.NumLabel)
+	}
+	if p.Sample[1].Location[0] != first.Location[1] {
+		t.Errorf("Expected locations to be shared between samples")
+	}
+
+	last := p.Sample[2]
+	if last.Label["comm"][0] != "Web Content" || last.Location[0].Line[0].Function.Name != "Foo::bar(int) const" || last.Location[0].Mapping.File != "/usr/lib/libxul.so" {
+		t.Errorf("Unexpected sample of a command with spaces and a C++ symbol: %v, %+v", last.Label, last.Location[0].Line[0].Function)
+	}
+	if last.Location[1].Line[0].Function.Name != "[unknown]" {
+		t.Errorf("Expected an unknown frame, got %q", last.Location[1].Line[0].Function.Name)
+	}
+
+	// The CPU analysis works on the converted profile like on a Go one
+	result := mustAnalyze(t, p)
+	if !strings.Contains(result, "ngx_process_events") {
+		t.Errorf("Expected ngx_process_events in the CPU analysis:\n%s", result)
+	}
+}
+
+func TestParsePerfScriptEvents(t *testing.T) {
+	data := "app 99 10.0: 1000 cycles:u:  4005d6 work+0x10 (/app)\napp 99 10.5: 10 instructions:u:  4005e0 main (/app)\n\napp 99 11.0: cycles:u:  4005d6 work (/app)\n"
+	p, err := analyzer.ParsePerfScript([]byte(data))
+	if err != nil {
+		t.Fatalf("ParsePerfScript failed: %v", err)
+	}
+	types := make([]string, 0, len(p.SampleType))
+	for _, st := range p.SampleType {
+		types = append(types, st.Type+"/"+st.Unit)
+	}
+	if strings.Join(types, ",") != "samples/count,cycles/count,instructions/count" || p.DefaultSampleType != "cycles" {
+		t.Fatalf("Unexpected sample types %v (default %q)", types, p.DefaultSampleType)
+	}
+	for i, want := range [][]int64{{1, 1000, 0}, {1, 0, 10}, {1, 1, 0}} {
+		if got := p.Sample[i].Value; len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Errorf("Sample %d: expected values %v, got %v", i, want, got)
+		}
+	}
+	if p.Sample[0].Location[0] != p.Sample[2].Location[0] || p.Sample[0].Location[0].Line[0].Function.Name != "work" {
+		t.Errorf("Expected the symbol offset to be dropped and the location shared")
+	}
+
+	if _, err := analyzer.ParsePerfScript([]byte("# only comments\n")); err == nil {
+		t.Error("Expected an error without samples")
+	}
+}
@@ -0,0 +1,113 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func allocsProfile(samples ...*profile.Sample) *profile.Profile {
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "alloc_objects", Unit: "count"}, {Type: "alloc_space", Unit: "bytes"}},
+		Sample:     samples,
+	}
+}
+
+func analyzePools(t *testing.T, current, baseline *profile.Profile) analyzer.PoolAnalysisResult {
+	t.Helper()
+	output, err := analyzer.AnalyzePoolEffectiveness(current, baseline, 5, "json")
+	if err != nil {
+		t.Fatalf("AnalyzePoolEffectiveness failed: %v", err)
+	}
+	var result analyzer.PoolAnalysisResult
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	return result
+}
+
+func TestAnalyzePoolEffectiveness(t *testing.T) {
+	current := func() *profile.Profile {
+		return allocsProfile(
+			// Pool misses: New allocates below Get
+			stackSample([]int64{10, 1000}, "runtime.mallocgc", "main.newBuffer", "sync.(*Pool).Get", "main.(*Server).handle", "main.serve"),
+			// The owner is the first caller outside sync, above the sync.Once wrapping the pool
+			stackSample([]int64{5, 500}, "runtime.makeslice", "main.newEncoder.func1", "sync.(*Pool).Get", "sync.(*Once).Do", "main.encode", "main.serve"),
+			// Direct allocations
+			stackSample([]int64{20, 3000}, "runtime.mallocgc", "main.(*Server).handle", "main.serve"),
+			stackSample([]int64{1, 500}, "main.loadConfig", "main.main"),
+		)
+	}
+	baseline := func() *profile.Profile {
+		return allocsProfile(
+			stackSample([]int64{90, 9000}, "runtime.mallocgc", "main.(*Server).handle", "main.serve"),
+			stackSample([]int64{20, 2000}, "runtime.makeslice", "main.encode", "main.serve"),
+		)
+	}
+
+	t.Run("WithoutBaseline", func(t *testing.T) {
+		result := analyzePools(t, current(), nil)
+		if result.TotalBytes != 5000 || result.PoolBytes != 1500 || result.DirectBytes != 3500 || result.HasBaseline || len(result.Pools) != 2 {
+			t.Fatalf("Unexpected totals: %+v", result)
+		}
+		handle, encode := result.Pools[0], result.Pools[1]
+		if handle.Owner != "main.(*Server).handle" || handle.NewFunction != "main.newBuffer" || handle.MissBytes != 1000 ||
+			handle.MissObjects != 10 || handle.OwnerCumBytes != 4000 || handle.MissShare != 25 || handle.EstimatedSavings != 0 {
+			t.Errorf("Unexpected handle pool: %+v", handle)
+		}
+		if encode.Owner != "main.encode" || encode.NewFunction != "main.newEncoder.func1" || encode.MissShare != 100 {
+			t.Errorf("Unexpected encode pool: %+v", encode)
+		}
+	})
+
+	// Profiles without durations are compared as they are
+	t.Run("Baseline", func(t *testing.T) {
+		result := analyzePools(t, current(), baseline())
+		if !result.HasBaseline || result.BaselineScale != 1 || result.BaselineTotalBytes != 11000 {
+			t.Fatalf("Unexpected baseline: %+v", result)
+		}
+		if handle := result.Pools[0]; handle.BaselineOwnerCumBytes != 9000 || handle.EstimatedSavings != 5000 || handle.EstimatedSavingsFormatted != "4.88 KB" {
+			t.Errorf("Unexpected handle savings: %+v", handle)
+		}
+		if encode := result.Pools[1]; encode.BaselineOwnerCumBytes != 2000 || encode.EstimatedSavings != 1500 {
+			t.Errorf("Unexpected encode savings: %+v", encode)
+		}
+
+		text, err := analyzer.AnalyzePoolEffectiveness(current(), baseline(), 5, "text")
+		if err != nil {
+			t.Fatalf("AnalyzePoolEffectiveness failed: %v", err)
+		}
+		for _, want := range []string{"Est. Savings", "New function: main.newBuffer", "savings assume they cover the same duration"} {
+			if !strings.Contains(text, want) {
+				t.Errorf("Expected %q in:\n%s", want, text)
+			}
+		}
+	})
+
+	// A baseline covering twice the duration is scaled down by half
+	t.Run("ScaledBaseline", func(t *testing.T) {
+		p, base := current(), baseline()
+		p.DurationNanos, base.DurationNanos = 10e9, 20e9
+		result := analyzePools(t, p, base)
+		if result.BaselineScale != 0.5 || result.BaselineTotalBytes != 5500 {
+			t.Fatalf("Unexpected baseline scaling: %+v", result)
+		}
+		if handle := result.Pools[0]; handle.BaselineOwnerCumBytes != 4500 || handle.EstimatedSavings != 500 {
+			t.Errorf("Unexpected scaled handle savings: %+v", handle)
+		}
+		if encode := result.Pools[1]; encode.BaselineOwnerCumBytes != 1000 || encode.EstimatedSavings != 500 {
+			t.Errorf("Unexpected scaled encode savings: %+v", encode)
+		}
+
+		text, err := analyzer.AnalyzePoolEffectiveness(p, base, 5, "text")
+		if err != nil {
+			t.Fatalf("AnalyzePoolEffectiveness failed: %v", err)
+		}
+		if !strings.Contains(text, "scaled by 0.50 to the duration of the profile") || strings.Contains(text, "same duration") {
+			t.Errorf("Expected the scaling to be reported:\n%s", text)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+// withLocationTable gives every location and function a unique ID and fills the profile's tables,
+// as the pprof filtering functions require.
+func withLocationTable(p *profile.Profile) *profile.Profile {
+	for _, s := range p.Sample {
+		for _, loc := range s.Location {
+			loc.ID = uint64(len(p.Location) + 1)
+			p.Location = append(p.Location, loc)
+			for _, line := range loc.Line {
+				line.Function.ID = uint64(len(p.Function) + 1)
+				p.Function = append(p.Function, line.Function)
+			}
+		}
+	}
+	return p
+}
+
+func TestParsePprofConfig(t *testing.T) {
+	settings := []byte(`{"configs":[{"name":"handlers","focus":"main\\.serve","granularity":"functions"},{"name":"gc","ignore":"runtime\\.gc"}]}`)
+
+	cfg, err := analyzer.ParsePprofConfig(settings, "gc")
+	if err != nil {
+		t.Fatalf("ParsePprofConfig returned error: %v", err)
+	}
+	if cfg.Name != "gc" || cfg.Ignore != `runtime\.gc` {
+		t.Errorf("Unexpected config selected: %+v", cfg)
+	}
+
+	if _, err := analyzer.ParsePprofConfig(settings, ""); err == nil || !strings.Contains(err.Error(), "handlers") {
+		t.Errorf("Expected an error listing available configs, got: %v", err)
+	}
+	if _, err := analyzer.ParsePprofConfig(settings, "missing"); err == nil {
+		t.Error("Expected an error for an unknown config name")
+	}
+
+	single, err := analyzer.ParsePprofConfig([]byte(`{"focus":"main\\."}`), "")
+	if err != nil || single.Focus != `main\.` {
+		t.Errorf("Expected a single config object to be accepted, got %+v, %v", single, err)
+	}
+}
+
+func TestPprofConfigApply(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{1, 100}, "main.work", "main.serve"),
+		stackSample([]int64{2, 200}, "runtime.gcBgMarkWorker"),
+		stackSample([]int64{3, 300}, "main.other", "main.main"),
+	))
+
+	cfg := &analyzer.PprofConfig{Focus: `main\.serve`, NodeCount: 80}
+	notes, err := cfg.Apply(p)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if len(p.Sample) != 1 || p.Sample[0].Value[1] != 100 {
+		t.Errorf("Expected only the focused sample to remain, got %d samples", len(p.Sample))
+	}
+	if !strings.Contains(strings.Join(notes, "\n"), "nodecount") {
+		t.Errorf("Expected graph-only settings to be reported as not applied, got: %v", notes)
+	}
+
+	if _, err := (&analyzer.PprofConfig{Ignore: "("}).Apply(p); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+	if _, err := (&analyzer.PprofConfig{Granularity: "pixels"}).Apply(p); err == nil {
+		t.Error("Expected an error for an unsupported granularity")
+	}
+}
+
+func TestPprofConfigFlags(t *testing.T) {
+	cfg := &analyzer.PprofConfig{Focus: `main\.serve`, PruneFrom: "runtime", Granularity: "lines", NoInlines: true, DropNegative: true, NodeCount: 80}
+	want := []string{`-focus=main\.serve`, "-prune_from=runtime", "-drop_negative", "-lines", "-noinlines"}
+	if got := cfg.PprofFlags(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected flags %q, got %q", want, got)
+	}
+	if got := (&analyzer.PprofConfig{NodeCount: 80}).PprofFlags(); len(got) != 0 {
+		t.Errorf("Expected no flags for graph-only settings, got %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestQueryProfile(t *testing.T) {
+	withLabels := func(s *profile.Sample, labels map[string][]string, numLabels map[string][]int64) *profile.Sample {
+		s.Label = labels
+		s.NumLabel = numLabels
+		return s
+	}
+	p := heapProfile(
+		withLabels(stackSample([]int64{10, 1000}, "encoding/json.Marshal", "main.listUsers"), map[string][]string{"handler": {"/users"}}, map[string][]int64{"bytes": {100}}),
+		withLabels(stackSample([]int64{5, 500}, "encoding/json.Unmarshal", "main.createUser"), map[string][]string{"handler": {"/users"}}, map[string][]int64{"bytes": {100}}),
+		withLabels(stackSample([]int64{1, 4096}, "bytes.growSlice", "main.listOrders"), map[string][]string{"handler": {"/orders"}}, map[string][]int64{"bytes": {4096}}),
+		stackSample([]int64{2, 64}, "runtime.malg"),
+	)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantGroups [][]string
+		wantFirst  []int64
+		wantErr    bool
+	}{
+		{
+			name:       "FilterByFunctionAndLabel",
+			query:      `SELECT inuse_space WHERE function ~ "encoding/json" AND label.handler = "/users" GROUP BY leaf`,
+			wantGroups: [][]string{{"encoding/json.Marshal"}, {"encoding/json.Unmarshal"}},
+			wantFirst:  []int64{1000},
+		},
+		{
+			name:       "GroupByLabelAllColumns",
+			query:      `select * group by label.handler order by inuse_objects desc limit 2`,
+			wantGroups: [][]string{{"/users"}, {"<none>"}},
+			wantFirst:  []int64{15, 1500},
+		},
+		{
+			name:       "NumericLabel",
+			query:      `SELECT inuse_space WHERE numlabel.bytes >= 1024 GROUP BY root`,
+			wantGroups: [][]string{{"main.listOrders"}},
+			wantFirst:  []int64{4096},
+		},
+		{
+			name:       "NoGroupBy",
+			query:      `SELECT inuse_objects WHERE label.handler != "/users"`,
+			wantGroups: [][]string{nil},
+			wantFirst:  []int64{3},
+		},
+		{name: "UnknownSampleType", query: `SELECT cpu`, wantErr: true},
+		{name: "BadOperator", query: `SELECT inuse_space WHERE numlabel.bytes ~ "1"`, wantErr: true},
+		{name: "ClauseOrder", query: `SELECT inuse_space LIMIT 2 GROUP BY leaf`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := analyzer.QueryProfile(p, tt.query, "json")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error for query %q", tt.query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("QueryProfile returned error: %v", err)
+			}
+			var result analyzer.QueryResult
+			if err := json.Unmarshal([]byte(output), &result); err != nil {
+				t.Fatalf("Failed to unmarshal JSON result: %v", err)
+			}
+			if len(result.Groups) != len(tt.wantGroups) {
+				t.Fatalf("Expected %d groups, got %d: %+v", len(tt.wantGroups), len(result.Groups), result.Groups)
+			}
+			for i, want := range tt.wantGroups {
+				got := result.Groups[i].Key
+				if len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) {
+					t.Errorf("Group %d: expected key %v, got %v", i, want, got)
+				}
+			}
+			for i, want := range tt.wantFirst {
+				if result.Groups[0].Values[i] != want {
+					t.Errorf("Expected first group value %d to be %d, got %d", i, want, result.Groups[0].Values[i])
+				}
+			}
+		})
+	}
+}
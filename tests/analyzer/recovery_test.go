@@ -0,0 +1,87 @@
+package analyzer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestRecoverTruncatedProfile(t *testing.T) {
+	p := withLocationTable(cpuProfile(
+		stackSample([]int64{10, 10e6}, "main.hot", "main.main"),
+		stackSample([]int64{5, 5e6}, "main.warm", "main.main"),
+		stackSample([]int64{1, 1e6}, "main.cold", "main.main"),
+	))
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	data := buf.Bytes()
+
+	// An intact profile is recovered completely, without dropping anything
+	full, stats, err := analyzer.RecoverProfile(data)
+	if err != nil {
+		t.Fatalf("RecoverProfile failed on an intact profile: %v", err)
+	}
+	if len(full.Sample) != 3 || stats.DroppedSamples != 0 || stats.MissingStrings || stats.ParsedBytes != stats.DecodedBytes {
+		t.Errorf("Unexpected recovery of an intact profile: %d samples, %+v", len(full.Sample), stats)
+	}
+
+	// Every truncation point either recovers a valid profile or fails cleanly
+	recovered := 0
+	for size := 1; size < len(data); size++ {
+		r, _, err := analyzer.RecoverProfile(data[:size])
+		if err != nil {
+			continue
+		}
+		recovered++
+		if err := r.CheckValid(); err != nil {
+			t.Fatalf("Recovered invalid profile from %d of %d bytes: %v", size, len(data), err)
+		}
+		if analyzer.RecoveryWarning(r) == "" {
+			t.Fatalf("Profile recovered from %d of %d bytes has no warning", size, len(data))
+		}
+	}
+	if recovered == 0 {
+		t.Fatalf("No truncation of the %d byte profile could be recovered", len(data))
+	}
+
+	// The string table is written last, so a profile cut inside it keeps its samples with placeholder names
+	var raw bytes.Buffer
+	if err := p.WriteUncompressed(&raw); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	r, stats, err := analyzer.RecoverProfile(raw.Bytes()[:raw.Len()-3])
+	if err != nil {
+		t.Fatalf("RecoverProfile failed on a slightly truncated profile: %v", err)
+	}
+	if len(r.Sample) != 3 || !stats.MissingStrings {
+		t.Errorf("Expected 3 samples with placeholder names, got %d samples, %+v", len(r.Sample), stats)
+	}
+
+	text, err := analyzer.Analyze(r, "cpu", analyzer.WithFormat("text"))
+	if err != nil {
+		t.Fatalf("Analyze failed on a recovered profile: %v", err)
+	}
+	if !strings.HasPrefix(text, "WARNING: ") {
+		t.Errorf("Expected the text analysis to start with the recovery warning, got:\n%s", text)
+	}
+	jsonResult, err := analyzer.Analyze(r, "cpu", analyzer.WithFormat("json"))
+	if err != nil {
+		t.Fatalf("Analyze failed on a recovered profile: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonResult), &decoded); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if warning, _ := decoded["warning"].(string); !strings.HasPrefix(warning, "WARNING: ") {
+		t.Errorf("Expected a warning field in the JSON result, got %v", decoded["warning"])
+	}
+
+	if analyzer.RecoveryWarning(p) != "" {
+		t.Errorf("Intact profile should have no recovery warning")
+	}
+}
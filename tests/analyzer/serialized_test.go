@@ -0,0 +1,76 @@
+package analyzer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestSerializedProfileJSON(t *testing.T) {
+	p := withLocationTable(heapProfile(
+		stackSample([]int64{4, 4096}, "bytes.growSlice", "main.load"),
+		stackSample([]int64{1, 1024}, "main.parse", "main.load"),
+	))
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := analyzer.AggregateProfileJSON(buf.Bytes(), "", "function", 1)
+	if err != nil {
+		t.Fatalf("AggregateProfileJSON failed: %v", err)
+	}
+	var agg analyzer.ProfileAggregation
+	if err := json.Unmarshal([]byte(out), &agg); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", out, err)
+	}
+	if agg.SampleType != "inuse_space" || agg.Total != 5120 || agg.TotalObjects != 5 || agg.Omitted != 1 || len(agg.Entries) != 1 {
+		t.Fatalf("Unexpected aggregation: %+v", agg)
+	}
+	if e := agg.Entries[0]; e.Name != "bytes.growSlice" || e.Percent != 80 || e.Objects != 4 || e.FlatFormatted != "4.00 KB" {
+		t.Errorf("Unexpected top entry: %+v", e)
+	}
+	if out, err := analyzer.AggregateProfileJSON(buf.Bytes(), "inuse_objects", "site", 0); err != nil || !strings.Contains(out, `"name":"main.parse at file.go:10"`) {
+		t.Errorf("Expected aggregation by site of object counts, got %s (%v)", out, err)
+	}
+	if _, err := analyzer.AggregateProfileJSON(buf.Bytes(), "", "package", 0); err == nil {
+		t.Error("Expected an error for an unsupported aggregation")
+	}
+
+	out, err = analyzer.FlameGraphTreeJSON(buf.Bytes(), "")
+	if err != nil {
+		t.Fatalf("FlameGraphTreeJSON failed: %v", err)
+	}
+	var root analyzer.FlameGraphNode
+	if err := json.Unmarshal([]byte(out), &root); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", out, err)
+	}
+	if root.Value != 5120 || len(root.Children) == 0 || root.Children[0].Name != "main.load" {
+		t.Errorf("Unexpected flame graph tree: %s", out)
+	}
+	if _, err := analyzer.FlameGraphTreeJSON([]byte("not a profile"), ""); err == nil {
+		t.Error("Expected an error for data that is not a profile")
+	}
+}
+
+// The analyzer core must stay free of process and file system access in the WebAssembly build.
+func TestAnalyzerImportsForWasm(t *testing.T) {
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH = "js", "wasm"
+	for _, dir := range []string{"../../analyzer", "../../analyzer/aggregate"} {
+		pkg, err := ctx.ImportDir(filepath.FromSlash(dir), 0)
+		if err != nil {
+			t.Fatalf("Failed to load %s for js/wasm: %v", dir, err)
+		}
+		for _, imp := range pkg.Imports {
+			if imp == "os" || imp == "os/exec" || imp == "net" || strings.HasPrefix(imp, "net/") {
+				t.Errorf("%s imports %s in the WebAssembly build", dir, imp)
+			}
+		}
+	}
+}
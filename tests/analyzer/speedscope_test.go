@@ -0,0 +1,71 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestBuildSpeedscopeProfile(t *testing.T) {
+	mainFn := &profile.Function{ID: 1, Name: "main", Filename: "main.go"}
+	fooFn := &profile.Function{ID: 2, Name: "foo", Filename: "foo.go"}
+	barFn := &profile.Function{ID: 3, Name: "bar", Filename: "bar.go"}
+	mainLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: mainFn, Line: 10}}}
+	fooLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: fooFn, Line: 20}}}
+	barLoc := &profile.Location{ID: 3, Line: []profile.Line{{Function: barFn, Line: 30}}}
+
+	testProfile := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{barLoc, fooLoc, mainLoc}, Value: []int64{1, 1000}},
+			{Location: []*profile.Location{fooLoc, mainLoc}, Value: []int64{2, 2000}},
+			{Location: []*profile.Location{mainLoc}, Value: []int64{0, 0}}, // Skipped: zero value
+		},
+	}
+
+	if idx := analyzer.DefaultSampleIndex(testProfile); idx != 1 {
+		t.Errorf("Expected default sample index 1, got %d", idx)
+	}
+
+	result, err := analyzer.BuildSpeedscopeProfile(testProfile, 1, "test")
+	if err != nil {
+		t.Fatalf("Error building speedscope profile: %v", err)
+	}
+
+	if len(result.Shared.Frames) != 3 {
+		t.Errorf("Expected 3 shared frames, got %d", len(result.Shared.Frames))
+	}
+	if len(result.Profiles) != 1 {
+		t.Fatalf("Expected 1 profile, got %d", len(result.Profiles))
+	}
+	sampled := result.Profiles[0]
+	if sampled.Unit != "nanoseconds" {
+		t.Errorf("Expected unit 'nanoseconds', got '%s'", sampled.Unit)
+	}
+	if sampled.EndValue != 3000 {
+		t.Errorf("Expected end value 3000, got %d", sampled.EndValue)
+	}
+	if len(sampled.Samples) != 2 || len(sampled.Weights) != 2 {
+		t.Fatalf("Expected 2 samples and weights, got %d and %d", len(sampled.Samples), len(sampled.Weights))
+	}
+
+	// Stacks must be ordered from root to leaf
+	first := sampled.Samples[0]
+	if result.Shared.Frames[first[0]].Name != "main" || result.Shared.Frames[first[len(first)-1]].Name != "bar" {
+		t.Errorf("Expected first stack to go from main to bar, got %v", first)
+	}
+
+	if _, err := json.Marshal(result); err != nil {
+		t.Errorf("Error marshaling speedscope profile: %v", err)
+	}
+
+	// Test with an invalid value index
+	if _, err := analyzer.BuildSpeedscopeProfile(testProfile, 5, "test"); err == nil {
+		t.Error("Expected error for invalid value index, but got nil")
+	}
+}
@@ -0,0 +1,75 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestCompareStackSets(t *testing.T) {
+	base := cpuProfile(
+		stackSample([]int64{5, 500}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{2, 200}, "main.legacyCache", "main.handle", "main.main"),
+	)
+	after := cpuProfile(
+		stackSample([]int64{4, 400}, "main.parse", "main.handle", "main.main"),
+		stackSample([]int64{6, 600}, "main.validate", "main.handle", "main.main"),
+		stackSample([]int64{1, 100}, "main.parse", "main.validate", "main.handle", "main.main"),
+	)
+
+	tests := []struct {
+		operation string
+		level     string
+		want      []string
+	}{
+		{"only_in_profile", "function", []string{"main.validate"}},
+		{"only_in_base", "function", []string{"main.legacyCache"}},
+		{"intersection", "function", []string{"main.handle", "main.main", "main.parse"}},
+		{"only_in_profile", "stack", []string{"main.main;main.handle;main.validate", "main.main;main.handle;main.validate;main.parse"}},
+		{"union", "stack", []string{"main.main;main.handle;main.validate", "main.main;main.handle;main.parse", "main.main;main.handle;main.legacyCache", "main.main;main.handle;main.validate;main.parse"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation+"/"+tt.level, func(t *testing.T) {
+			result, err := analyzer.CompareStackSets(after, base, tt.operation, tt.level, "", 10)
+			if err != nil {
+				t.Fatalf("CompareStackSets failed: %v", err)
+			}
+			keys := make([]string, 0, len(result.Entries))
+			for _, e := range result.Entries {
+				keys = append(keys, e.Key)
+			}
+			if strings.Join(keys, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Expected %v, got %v", tt.want, keys)
+			}
+		})
+	}
+
+	result, err := analyzer.CompareStackSets(after, base, "intersection", "function", "samples", 1)
+	if err != nil {
+		t.Fatalf("CompareStackSets failed: %v", err)
+	}
+	if result.Matched != 3 || len(result.Entries) != 1 || result.InBoth != 3 || result.OnlyProfile != 1 || result.OnlyBase != 1 {
+		t.Errorf("Unexpected counts: %+v", result)
+	}
+	// Function values are cumulative: main.parse is also on the main.validate stack
+	if e := result.Entries[0]; e.Key != "main.handle" || e.Value != 11 || e.BaseValue != 7 {
+		t.Errorf("Unexpected top entry: %+v", e)
+	}
+	formatted, err := analyzer.FormatStackSetResult(result, "json")
+	if err != nil {
+		t.Fatalf("FormatStackSetResult failed: %v", err)
+	}
+	var parsed analyzer.StackSetResult
+	if err := json.Unmarshal([]byte(formatted), &parsed); err != nil || parsed.SampleType != "samples" {
+		t.Errorf("Unexpected JSON result (%v):\n%s", err, formatted)
+	}
+
+	if _, err := analyzer.CompareStackSets(after, base, "xor", "function", "", 10); err == nil {
+		t.Error("Expected an error for an unsupported operation")
+	}
+	if _, err := analyzer.CompareStackSets(after, heapProfile(), "union", "function", "", 10); err == nil {
+		t.Error("Expected an error when the base profile lacks the sample type")
+	}
+}
@@ -0,0 +1,110 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestDetectStuckGoroutines(t *testing.T) {
+	p := withLocationTable(&profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		Sample: []*profile.Sample{
+			stackSample([]int64{120}, "runtime.gopark", "runtime.goparkunlock", "sync.runtime_SemacquireMutex", "sync.(*Mutex).lockSlow", "sync.(*Mutex).Lock", "main.(*Cache).Get", "main.handle"),
+			stackSample([]int64{40}, "runtime.gopark", "runtime.chansend", "runtime.chansend1", "main.fetch.func1"),
+			stackSample([]int64{30}, "runtime.gopark", "runtime.semacquire1", "sync.runtime_SemacquireWaitGroup", "sync.(*WaitGroup).Wait", "main.fanOut"),
+			stackSample([]int64{3}, "runtime.gopark", "runtime.chanrecv", "runtime.chanrecv1", "main.worker"),
+			stackSample([]int64{500}, "runtime.gopark", "runtime.netpollblock", "internal/poll.runtime_pollWait", "net.(*conn).Read"),
+		},
+	})
+
+	jsonText, err := analyzer.DetectStuckGoroutines(p, analyzer.StuckGoroutineCriteria{MinGoroutines: 10, MinWaitMinutes: 5}, 10, 0, "json")
+	if err != nil {
+		t.Fatalf("DetectStuckGoroutines failed: %v", err)
+	}
+	var result analyzer.StuckGoroutineResult
+	if err := json.Unmarshal([]byte(jsonText), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if result.TotalGoroutines != 693 || result.BlockedGoroutines != 193 || result.HasWaitDurations || !result.Suspected {
+		t.Errorf("Unexpected totals: %+v", result)
+	}
+	// IO wait is not stuck, and the 3 idle workers are below min_goroutines
+	want := []struct {
+		kind, site string
+		count      int64
+	}{
+		{"mutex", "main.(*Cache).Get at file.go:60", 120},
+		{"chan send", "main.fetch.func1 at file.go:40", 40},
+		{"waitgroup", "main.fanOut at file.go:50", 30},
+	}
+	if len(result.Findings) != len(want) {
+		t.Fatalf("Expected %d findings, got %+v", len(want), result.Findings)
+	}
+	for i, w := range want {
+		f := result.Findings[i]
+		if f.Kind != w.kind || f.Site != w.site || f.Goroutines != w.count || f.Severity != "medium" || f.LikelyCause == "" || len(f.Stacks) != 1 {
+			t.Errorf("Finding %d: expected %s at %s (%d), got %+v", i, w.kind, w.site, w.count, f)
+		}
+	}
+
+	text, err := analyzer.DetectStuckGoroutines(p, analyzer.StuckGoroutineCriteria{MinGoroutines: 100}, 10, 2, "text")
+	if err != nil {
+		t.Fatalf("DetectStuckGoroutines failed: %v", err)
+	}
+	for _, s := range []string{"Verdict: Stuck goroutines suspected: 120 goroutines parked at 1 call sites", "1. 120 goroutines (mutex) at main.(*Cache).Get", "Likely cause: The lock is never released", "… 5 more frames", "wait durations need a goroutine dump"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("Expected %q in:\n%s", s, text)
+		}
+	}
+	if strings.Contains(text, "chan send") {
+		t.Errorf("Expected the sites below min_goroutines to be left out:\n%s", text)
+	}
+}
+
+func TestDetectStuckGoroutinesDump(t *testing.T) {
+	var dump strings.Builder
+	dump.WriteString("goroutine 1 [running]:\nmain.main()\n\t/app/main.go:33 +0x2b1\n\n")
+	// Two goroutines stuck on a mutex for over an hour: few, but waiting long
+	for id := 2; id < 4; id++ {
+		fmt.Fprintf(&dump, "goroutine %d [sync.Mutex.Lock, 75 minutes]:\nsync.(*Mutex).Lock(...)\n\t/usr/local/go/src/sync/mutex.go:46\nmain.(*Store).Put(0xc000010000)\n\t/app/store.go:12 +0x45\ncreated by main.main in goroutine 1\n\t/app/main.go:25 +0x12a\n\n", id)
+	}
+	dump.WriteString("goroutine 9 [chan receive (nil chan)]:\nmain.waitReady()\n\t/app/ready.go:8 +0x1d\ncreated by main.main in goroutine 1\n\t/app/main.go:27 +0x12a\n\n")
+	dump.WriteString("goroutine 10 [chan receive, 2 minutes]:\nmain.(*worker).run(0xc000010000)\n\t/app/worker.go:41 +0x45\ncreated by main.main in goroutine 1\n\t/app/main.go:25 +0x12a\n")
+	p, err := analyzer.ParseGoroutineDump([]byte(dump.String()))
+	if err != nil {
+		t.Fatalf("ParseGoroutineDump failed: %v", err)
+	}
+
+	jsonText, err := analyzer.DetectStuckGoroutines(p, analyzer.StuckGoroutineCriteria{MinGoroutines: 10, MinWaitMinutes: 60}, 10, 0, "json")
+	if err != nil {
+		t.Fatalf("DetectStuckGoroutines failed: %v", err)
+	}
+	var result analyzer.StuckGoroutineResult
+	if err := json.Unmarshal([]byte(jsonText), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if !result.HasWaitDurations || result.BlockedGoroutines != 4 || len(result.Findings) != 2 {
+		t.Fatalf("Expected the nil channel and the long mutex wait, got %+v", result)
+	}
+	nilChan, mutex := result.Findings[0], result.Findings[1]
+	if nilChan.Kind != "nil channel" || nilChan.Severity != "high" || nilChan.Site != "main.waitReady at /app/ready.go:8" {
+		t.Errorf("Unexpected nil channel finding: %+v", nilChan)
+	}
+	if mutex.Kind != "mutex" || mutex.Severity != "medium" || mutex.Goroutines != 2 || mutex.MaxWaitMinutes != 75 ||
+		mutex.Site != "main.(*Store).Put at /app/store.go:12" || mutex.Stacks[0].CreatedBy != "main.main" {
+		t.Errorf("Unexpected mutex finding: %+v", mutex)
+	}
+
+	text, err := analyzer.DetectStuckGoroutines(p, analyzer.StuckGoroutineCriteria{MinGoroutines: 10}, 10, 0, "markdown")
+	if err != nil {
+		t.Fatalf("DetectStuckGoroutines failed: %v", err)
+	}
+	if !strings.Contains(text, "| high |") || strings.Contains(text, "main.(*Store).Put") {
+		t.Errorf("Expected only the nil channel without min_wait_minutes:\n%s", text)
+	}
+}
@@ -0,0 +1,53 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestSubtractProfile(t *testing.T) {
+	before := withLocationTable(heapProfile(
+		stackSample([]int64{10, 1000}, "main.cache", "main.main"),
+		stackSample([]int64{5, 500}, "main.steady", "main.main"),
+		stackSample([]int64{8, 800}, "main.shrinking", "main.main"),
+	))
+	after := withLocationTable(heapProfile(
+		stackSample([]int64{30, 3000}, "main.cache", "main.main"),
+		stackSample([]int64{5, 500}, "main.steady", "main.main"),
+		stackSample([]int64{2, 200}, "main.shrinking", "main.main"),
+		stackSample([]int64{1, 100}, "main.new", "main.main"),
+	))
+	// Parsed profiles always have a period type; profile.Merge requires it
+	before.PeriodType = &profile.ValueType{Type: "space", Unit: "bytes"}
+	after.PeriodType = &profile.ValueType{Type: "space", Unit: "bytes"}
+	after.DurationNanos = 5e9
+
+	diff, stats, err := analyzer.SubtractProfile(after, before)
+	if err != nil {
+		t.Fatalf("SubtractProfile failed: %v", err)
+	}
+	values := make(map[string]int64)
+	for _, s := range diff.Sample {
+		values[s.Location[0].Line[0].Function.Name] = s.Value[1]
+	}
+	if len(values) != 2 || values["main.cache"] != 2000 || values["main.new"] != 100 {
+		t.Errorf("Expected main.cache (2000) and main.new (100), got %v", values)
+	}
+	if stats.Samples != 2 || stats.DroppedSamples != 1 || stats.ClampedValues != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if diff.DurationNanos != 5e9 {
+		t.Errorf("Expected the duration of the first profile, got %d", diff.DurationNanos)
+	}
+	if before.Sample[0].Value[1] != 1000 || after.Sample[0].Value[1] != 3000 {
+		t.Error("Expected the inputs not to be modified")
+	}
+
+	cpu := withLocationTable(cpuProfile(stackSample([]int64{1, 100}, "main.work")))
+	cpu.PeriodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+	if _, _, err := analyzer.SubtractProfile(after, cpu); err == nil {
+		t.Error("Expected an error for incompatible profiles")
+	}
+}
@@ -0,0 +1,53 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+func TestSuggestNextCallsAfterHeapAnalysis(t *testing.T) {
+	p := heapProfile(
+		stackSample([]int64{10, 4096}, "main.cache", "main.handle", "main.main"),
+		stackSample([]int64{1, 128}, "main.small", "main.main"),
+	)
+	suggestions := analyzer.SuggestNextCalls(p, analyzer.SuggestionContext{
+		Tool:         "analyze_pprof",
+		ProfileURI:   "/tmp/heap.pb.gz",
+		ProfileType:  "heap",
+		OutputFormat: "flamegraph-json",
+		AnalysisID:   "inv-1",
+	})
+
+	byTool := make(map[string]analyzer.SuggestedCall)
+	for _, s := range suggestions {
+		if _, ok := byTool[s.Tool]; !ok {
+			byTool[s.Tool] = s
+		}
+		if s.Arguments["analysis_id"] != "inv-1" {
+			t.Errorf("Suggestion %s does not carry the analysis ID: %v", s.Tool, s.Arguments)
+		}
+	}
+	if subtree, ok := byTool["get_flamegraph_subtree"]; !ok || subtree.Arguments["path"] != "root;main.main;main.handle;main.cache" {
+		t.Errorf("Expected a flame graph drill-down along the hottest stack, got %+v", subtree)
+	}
+	leaks, ok := byTool["detect_memory_leaks"]
+	if !ok || leaks.Arguments["old_profile_uri"] != "/tmp/heap.pb.gz" || len(leaks.Missing) != 1 || leaks.Missing[0] != "new_profile_uri" {
+		t.Errorf("Expected detect_memory_leaks with the profile as the old snapshot, got %+v", leaks)
+	}
+	if query, ok := byTool["query_profile"]; !ok || query.Arguments["query"] != `SELECT inuse_space WHERE function = "main.cache" GROUP BY root LIMIT 10` {
+		t.Errorf("Expected a query focused on main.cache, got %+v", query)
+	}
+
+	text, err := analyzer.FormatSuggestedCalls(suggestions)
+	if err != nil {
+		t.Fatalf("FormatSuggestedCalls failed: %v", err)
+	}
+	var decoded struct {
+		SuggestedNextCalls []analyzer.SuggestedCall `json:"suggestedNextCalls"`
+	}
+	if err := json.Unmarshal([]byte(text), &decoded); err != nil || len(decoded.SuggestedNextCalls) != len(suggestions) {
+		t.Errorf("Invalid suggestion JSON (%v):\n%s", err, text)
+	}
+}
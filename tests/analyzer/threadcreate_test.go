@@ -0,0 +1,50 @@
+package analyzer_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+	"github.com/google/pprof/profile"
+)
+
+func TestThreadCreateAnalysis(t *testing.T) {
+	p := withLocationTable(&profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "threadcreate", Unit: "count"}},
+		Sample: []*profile.Sample{
+			stackSample([]int64{230}, "runtime.newm", "runtime.startm", "runtime.handoffp", "runtime.entersyscallblock", "syscall.Syscall", "main.readAll"),
+			stackSample([]int64{8}, "runtime.newm", "runtime.startm", "runtime.wakep", "runtime.ready"),
+			stackSample([]int64{2}, "runtime.newm", "runtime.startm", "runtime.cgocall", "main.sqlite"),
+			{Value: []int64{3}},
+		},
+	})
+
+	total, causes := analyzer.SummarizeThreadCreateCauses(p)
+	summary := analyzer.FormatThreadCreateCauseSummary(total, causes)
+	if want := "243 total: 230 blocking syscall, 8 scheduler, 3 no stack, 2 cgo call"; summary != want {
+		t.Errorf("Expected summary %q, got %q", want, summary)
+	}
+
+	text, err := analyzer.Analyze(p, "threads", analyzer.WithTopN(2), analyzer.WithFormat("text"))
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, want := range []string{"Causes: " + summary, "OS threads were created", "threads blocked in system calls", "230 threads created by stack (blocking syscall, 6 frames):"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+
+	jsonResult, err := analyzer.AnalyzeThreadCreateProfile(p, 5, "json")
+	if err != nil {
+		t.Fatalf("AnalyzeThreadCreateProfile failed: %v", err)
+	}
+	var result analyzer.ThreadCreateAnalysisResult
+	if err := json.Unmarshal([]byte(jsonResult), &result); err != nil {
+		t.Fatalf("Invalid JSON result: %v", err)
+	}
+	if result.TotalThreads != 243 || len(result.Stacks) != 3 || result.Stacks[2].Cause != "cgo call" {
+		t.Errorf("Unexpected JSON result: %+v", result)
+	}
+}
@@ -0,0 +1,201 @@
+package analyzer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// traceDump is the output of 'go tool trace -d=parsed' for two goroutines on two Ps: goroutine 1 waits
+// 500ns for a P twice, blocks 5us on the network and 2us in a system call; goroutine 2 is still blocked on a
+// channel when the trace ends.
+const traceDump = `M=-1 P=-1 G=-1 Sync Time=1000 N=1 Trace=1000 Mono=1000 Wall=2026-01-01T00:00:00Z
+M=1 P=-1 G=-1 StateTransition Time=1000 ProcID=0 Idle->Running Reason=""
+M=1 P=0 G=-1 Metric Time=1000 Name="/sched/gomaxprocs:threads" Value=Value{Uint64(2)}
+M=1 P=0 G=-1 StateTransition Time=1000 GoID=1 NotExist->Runnable Reason=""
+M=1 P=0 G=-1 StateTransition Time=1500 GoID=1 Runnable->Running Reason=""
+M=2 P=-1 G=-1 StateTransition Time=2000 ProcID=1 Idle->Running Reason=""
+M=2 P=1 G=-1 StateTransition Time=2000 GoID=2 Undetermined->Running Reason=""
+M=1 P=0 G=1 StateTransition Time=3500 GoID=1 Running->Waiting Reason="network"
+Stack=
+	internal/poll.(*FD).Read @ 0x4a1f00
+		/usr/local/go/src/internal/poll/fd_unix.go:165
+	main.fetch @ 0x4b2c10
+		/app/main.go:12
+	main.main @ 0x4b2d00
+		/app/main.go:30
+
+M=2 P=1 G=2 StateTransition Time=4000 GoID=2 Running->Waiting Reason="chan receive"
+Stack=
+	runtime.chanrecv1 @ 0x40a1b0
+		/usr/local/go/src/runtime/chan.go:509
+	main.worker @ 0x4b2e00
+		/app/worker.go:7
+
+M=2 P=-1 G=-1 StateTransition Time=4000 ProcID=1 Running->Idle Reason=""
+M=1 P=0 G=-1 StateTransition Time=8500 GoID=1 Waiting->Runnable Reason=""
+M=1 P=0 G=-1 StateTransition Time=9000 GoID=1 Runnable->Running Reason=""
+M=1 P=0 G=1 StateTransition Time=10000 GoID=1 Running->Syscall Reason=""
+Stack=
+	syscall.read @ 0x47f000
+		/usr/local/go/src/syscall/zsyscall_linux_amd64.go:736
+	os.(*File).Read @ 0x490000
+		/usr/local/go/src/os/file.go:118
+	main.load @ 0x4b2f00
+		/app/load.go:9
+
+M=1 P=0 G=1 StateTransition Time=12000 GoID=1 Syscall->Running Reason=""
+M=1 P=0 G=1 StateTransition Time=13000 GoID=1 Running->NotExist Reason=""
+M=1 P=-1 G=-1 StateTransition Time=13000 ProcID=0 Running->Idle Reason=""
+`
+
+func TestParseTraceEvents(t *testing.T) {
+	if !analyzer.IsTraceEventDump([]byte(traceDump)) || analyzer.IsTraceEventDump([]byte("goroutine profile: total 1\n")) {
+		t.Error("Unexpected detection of trace event dumps")
+	}
+	if !analyzer.IsGoTrace([]byte("go 1.23 trace\x00\x00\x00\x01")) || analyzer.IsGoTrace([]byte(traceDump)) {
+		t.Error("Unexpected detection of trace files")
+	}
+
+	s, err := analyzer.ParseTraceEvents(strings.NewReader(traceDump))
+	if err != nil {
+		t.Fatalf("ParseTraceEvents failed: %v", err)
+	}
+	if s.Duration != 12000 || s.Goroutines != 2 || s.Created != 1 || s.Ended != 1 || s.GoMaxProcs != 2 {
+		t.Errorf("Unexpected totals: %+v", s)
+	}
+	if s.SchedulerLatency.Count != 2 || s.SchedulerLatency.Total != 1000 || s.SchedulerLatency.Max != 500 {
+		t.Errorf("Unexpected scheduler latency: %+v", s.SchedulerLatency)
+	}
+	// P0 ran for the whole trace, P1 for 2us of it
+	if len(s.Procs) != 2 || s.Procs[0].Running != 12000 || s.Procs[1].Running != 2000 || s.Utilization != 14000.0/24000*100 {
+		t.Errorf("Unexpected proc utilization: %+v (%v%%)", s.Procs, s.Utilization)
+	}
+
+	blocking := make(map[string]analyzer.TraceBlockingStats)
+	for _, b := range s.Blocking {
+		blocking[b.Reason] = b
+	}
+	if b := blocking["chan receive"]; b.Total != 9000 || b.Ongoing != 1 || s.Blocking[0].Reason != "chan receive" {
+		t.Errorf("Expected the ongoing channel wait first, counted until the end of the trace: %+v", s.Blocking)
+	}
+	if b := blocking["network"]; b.Count != 1 || b.Total != 5000 || b.Ongoing != 0 {
+		t.Errorf("Unexpected network blocking: %+v", b)
+	}
+	if b := blocking[analyzer.TraceSyscallReason]; b.Count != 1 || b.Total != 2000 {
+		t.Errorf("Unexpected syscall blocking: %+v", b)
+	}
+
+	// Sites are the first frames outside the standard library
+	sites := make(map[string]string)
+	for _, site := range s.Sites {
+		sites[site.Reason] = site.Function + " " + site.Location
+	}
+	if sites["network"] != "main.fetch /app/main.go:12" || sites[analyzer.TraceSyscallReason] != "main.load /app/load.go:9" || sites["chan receive"] != "main.worker /app/worker.go:7" {
+		t.Errorf("Unexpected blocking sites: %v", sites)
+	}
+
+	if _, err := analyzer.ParseTraceEvents(strings.NewReader("not a trace\n")); err == nil {
+		t.Error("Expected an error without events")
+	}
+}
+
+func TestFormatTraceSummary(t *testing.T) {
+	s, err := analyzer.ParseTraceEvents(strings.NewReader(traceDump))
+	if err != nil {
+		t.Fatalf("ParseTraceEvents failed: %v", err)
+	}
+	text, err := analyzer.FormatTraceSummary(s, 1, "text")
+	if err != nil {
+		t.Fatalf("FormatTraceSummary failed: %v", err)
+	}
+	for _, want := range []string{"GOMAXPROCS: 2", "P1", "58.33% of 2 procs", "scheduler latency", "(1 still blocked at the end)", "=== Top 1 Blocking Sites ===", "main.worker (/app/worker.go:7)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the text output:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "main.fetch") {
+		t.Errorf("Expected only the top blocking site:\n%s", text)
+	}
+
+	jsonOut, err := analyzer.FormatTraceSummary(s, 0, "json")
+	if err != nil || !strings.Contains(jsonOut, `"schedulerLatency"`) || !strings.Contains(jsonOut, `"function": "main.fetch"`) {
+		t.Errorf("Unexpected JSON output (%v):\n%s", err, jsonOut)
+	}
+	if _, err := analyzer.FormatTraceSummary(s, 1, "markdown"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+}
+
+// traceGCDump has two GC cycles: the runtime records the mark phase before the sweep termination pause, and
+// the second cycle is still marking when the trace ends.
+const traceGCDump = `M=1 P=0 G=1 Metric Time=0 Name="/sched/gomaxprocs:threads" Value=Value{Uint64(4)}
+M=1 P=0 G=1 Metric Time=0 Name="/gc/heap/goal:bytes" Value=Value{Uint64(4194304)}
+M=1 P=0 G=1 Metric Time=1000 Name="/memory/classes/heap/objects:bytes" Value=Value{Uint64(4000000)}
+M=1 P=0 G=1 RangeBegin Time=2000 Name="GC concurrent mark phase" Scope=None
+M=1 P=0 G=1 RangeBegin Time=2100 Name="stop-the-world (GC sweep termination)" Scope=Goroutine(1)
+M=1 P=0 G=1 RangeEnd Time=2200 Name="stop-the-world (GC sweep termination)" Scope=Goroutine(1) Attributes=[]
+M=1 P=0 G=5 RangeBegin Time=3000 Name="GC mark assist" Scope=Goroutine(5)
+M=1 P=0 G=5 RangeEnd Time=3500 Name="GC mark assist" Scope=Goroutine(5) Attributes=[]
+M=1 P=0 G=1 Metric Time=3600 Name="/memory/classes/heap/objects:bytes" Value=Value{Uint64(4500000)}
+M=1 P=0 G=1 RangeBegin Time=5900 Name="stop-the-world (GC mark termination)" Scope=Goroutine(1)
+M=1 P=0 G=1 RangeEnd Time=6000 Name="GC concurrent mark phase" Scope=None Attributes=[]
+M=1 P=0 G=1 Metric Time=6100 Name="/gc/heap/goal:bytes" Value=Value{Uint64(6000000)}
+M=1 P=0 G=1 RangeEnd Time=6200 Name="stop-the-world (GC mark termination)" Scope=Goroutine(1) Attributes=[]
+M=1 P=0 G=1 Metric Time=7000 Name="/memory/classes/heap/objects:bytes" Value=Value{Uint64(2000000)}
+M=1 P=0 G=1 RangeBegin Time=9000 Name="GC concurrent mark phase" Scope=None
+M=1 P=0 G=1 Metric Time=10000 Name="/memory/classes/heap/objects:bytes" Value=Value{Uint64(2500000)}
+`
+
+func TestParseTraceGC(t *testing.T) {
+	s, err := analyzer.ParseTraceGC(strings.NewReader(traceGCDump), 4)
+	if err != nil {
+		t.Fatalf("ParseTraceGC failed: %v", err)
+	}
+	if s.Duration != 10000 || s.GoMaxProcs != 4 || len(s.Cycles) != 2 {
+		t.Fatalf("Unexpected totals: %+v", s)
+	}
+	if s.Pauses.Count != 2 || s.Pauses.Total != 400 || s.PausePercent != 4 || s.Assists.Total != 500 {
+		t.Errorf("Unexpected pauses and assists: %+v, %+v (%v%%)", s.Pauses, s.Assists, s.PausePercent)
+	}
+	// The open mark phase is counted until the end of the trace
+	if s.MarkPhases.Count != 2 || s.MarkPhases.Total != 5000 {
+		t.Errorf("Unexpected mark phases: %+v", s.MarkPhases)
+	}
+	want := analyzer.TraceGCCycle{Start: 2000, Mark: 4000, Pause: 400, Assist: 500, HeapLive: 4000000, HeapGoal: 4194304, NextHeapGoal: 6000000}
+	if s.Cycles[0] != want {
+		t.Errorf("Unexpected first cycle: %+v", s.Cycles[0])
+	}
+	if c := s.Cycles[1]; c.Start != 9000 || c.HeapLive != 2000000 || c.HeapGoal != 6000000 || c.NextHeapGoal != 0 {
+		t.Errorf("Unexpected second cycle: %+v", c)
+	}
+
+	// 10us downsampled to at most 4 buckets of 4us: values are carried forward, peaks kept per bucket
+	if len(s.Series) != 3 {
+		t.Fatalf("Expected 3 points, got %+v", s.Series)
+	}
+	if p := s.Series[1]; p.Time != 8000 || p.HeapLive != 2000000 || p.HeapPeak != 2000000 || p.HeapGoal != 6000000 {
+		t.Errorf("Unexpected second point: %+v", p)
+	}
+	if p := s.Series[0]; p.HeapPeak != 4500000 || p.HeapGoal != 4194304 {
+		t.Errorf("Unexpected first point: %+v", p)
+	}
+	if p := s.Series[2]; p.Time != 10000 || p.HeapLive != 2500000 {
+		t.Errorf("Expected the last point at the end of the trace: %+v", p)
+	}
+
+	text, err := analyzer.FormatTraceGCSummary(s, 1, "text")
+	if err != nil {
+		t.Fatalf("FormatTraceGCSummary failed: %v", err)
+	}
+	for _, want := range []string{"GC cycles: 2 (one every 7.00us)", "stop-the-world pauses", "4.00% of the trace", "=== Top 1 GC Cycles by Pause ===", "5.72 MB", "(3 points)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in the text output:\n%s", want, text)
+		}
+	}
+	jsonOut, err := analyzer.FormatTraceGCSummary(s, 1, "json")
+	if err != nil || !strings.Contains(jsonOut, `"nextHeapGoalBytes": 6000000`) || !strings.Contains(jsonOut, `"series"`) {
+		t.Errorf("Unexpected JSON output (%v):\n%s", err, jsonOut)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// addTool registers a tool whose arguments are validated against its input schema before the handler runs,
+// so every tool rejects malformed arguments the same way instead of through ad-hoc type assertions.
+func addTool(s *server.MCPServer, tool mcp.Tool, handler server.ToolHandlerFunc) {
+	wrapped := memoryGuardedHandler(tool.Name, sourceReportingHandler(pprofCommandsHandler(tool.Name, validatedHandler(tool, confirmableHandler(tool.Name, handler)))))
+	toolHandlers[tool.Name] = wrapped
+	s.AddTool(tool, auditedHandler(tool.Name, recordedHandler(tool.Name, wrapped)))
+}
+
+// validatedHandler wraps handler with normalizeProfileTypeArg, materializeProfileCommand,
+// materializeInlineProfile and validateToolArguments.
+func validatedHandler(tool mcp.Tool, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		normalizeProfileTypeArg(request.Params.Arguments)
+		commandCleanup, err := materializeProfileCommand(ctx, tool, request.Params.Arguments)
+		if err != nil {
+			// Runs outside confirmableHandler: the confirmation request is returned as its structured result here
+			var confirmErr *confirmationRequired
+			if errors.As(err, &confirmErr) {
+				return confirmErr.toolResult(), nil
+			}
+			return nil, err
+		}
+		defer commandCleanup()
+		cleanup, err := materializeInlineProfile(tool, request.Params.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		if err := validateToolArguments(tool, request.Params.Arguments); err != nil {
+			return nil, err
+		}
+		return handler(ctx, request)
+	}
+}
+
+// normalizeProfileTypeArg resolves an aliased 'profile_type' argument in place (see analyzer.ResolveProfileType),
+// before its enum is checked and the handler dispatches on it.
+func normalizeProfileTypeArg(args map[string]interface{}) {
+	if profileType, ok := args["profile_type"].(string); ok && profileType != "" {
+		args["profile_type"] = analyzer.ResolveProfileType(profileType)
+	}
+}
+
+// validateToolArguments checks arguments against the tool's input schema: unknown and missing required
+// arguments, types, enums, numeric ranges and the syntax of profile URIs ('*_uri' arguments). All problems
+// are reported together, followed by the accepted arguments.
+func validateToolArguments(tool mcp.Tool, args map[string]interface{}) error {
+	problems := make([]string, 0)
+	for _, name := range tool.InputSchema.Required {
+		if value, ok := args[name]; !ok || value == nil || value == "" {
+			problems = append(problems, fmt.Sprintf("'%s': required argument is missing", name))
+		}
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := args[name]
+		property, ok := tool.InputSchema.Properties[name].(map[string]interface{})
+		if !ok {
+			problems = append(problems, fmt.Sprintf("'%s': unknown argument", name))
+			continue
+		}
+		if value == nil {
+			continue // Treated like an omitted argument
+		}
+		if problem := validateArgument(name, value, property); problem != "" {
+			problems = append(problems, fmt.Sprintf("'%s': %s", name, problem))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("invalid arguments for tool '%s':\n", tool.Name))
+	for _, problem := range problems {
+		b.WriteString("  - " + problem + "\n")
+	}
+	b.WriteString("Accepted arguments:\n")
+	b.WriteString(describeToolSchema(tool))
+	return fmt.Errorf("%s", strings.TrimSuffix(b.String(), "\n"))
+}
+
+// validateArgument checks one argument against its property schema and returns the problem, if any.
+func validateArgument(name string, value interface{}, property map[string]interface{}) string {
+	switch property["type"] {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("expected a string, got %s", jsonTypeName(value))
+		}
+		if enum, ok := property["enum"].([]string); ok && s != "" && !containsString(enum, s) {
+			return fmt.Sprintf("'%s' is not one of %s", s, strings.Join(enum, ", "))
+		}
+		if strings.HasSuffix(name, "_uri") && s != "" {
+			if err := validateProfileURI(s); err != nil {
+				return err.Error()
+			}
+		}
+	case "number":
+		f, ok := value.(float64)
+		if !ok {
+			return fmt.Sprintf("expected a number, got %s", jsonTypeName(value))
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "expected a finite number"
+		}
+		if min, ok := property["minimum"].(float64); ok && f < min {
+			return fmt.Sprintf("%v is less than the minimum %v", f, min)
+		}
+		if max, ok := property["maximum"].(float64); ok && f > max {
+			return fmt.Sprintf("%v is greater than the maximum %v", f, max)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Sprintf("expected a boolean, got %s", jsonTypeName(value))
+		}
+	}
+	return ""
+}
+
+// validateProfileURI checks the syntax of a profile URI as accepted by getProfileAsFile, or of each of its
+// candidate locations (see profileURICandidates).
+func validateProfileURI(uriStr string) error {
+	if candidates := profileURICandidates(uriStr); len(candidates) != 1 || candidates[0] != uriStr {
+		if len(candidates) == 0 {
+			return fmt.Errorf("profile URI '%s' lists no candidate location", uriStr)
+		}
+		for i, candidate := range candidates {
+			if err := validateProfileURI(candidate); err != nil {
+				return fmt.Errorf("candidate %d: %v", i+1, err)
+			}
+		}
+		return nil
+	}
+	if !strings.Contains(uriStr, "://") {
+		return nil // Plain local path
+	}
+	parsed, err := url.Parse(uriStr)
+	if err != nil {
+		return fmt.Errorf("invalid URI: %v", err)
+	}
+	switch parsed.Scheme {
+	case "file":
+		if parsed.Path == "" {
+			return fmt.Errorf("file URI '%s' has no path", uriStr)
+		}
+	case "http", "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("%s URI '%s' has no host", parsed.Scheme, uriStr)
+		}
+	default:
+		return fmt.Errorf("unsupported URI scheme '%s' (supported: file://, http://, https:// or a plain local path)", parsed.Scheme)
+	}
+	return nil
+}
+
+// describeToolSchema lists the accepted arguments of a tool, one per line.
+func describeToolSchema(tool mcp.Tool) string {
+	names := make([]string, 0, len(tool.InputSchema.Properties))
+	for name := range tool.InputSchema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		property, _ := tool.InputSchema.Properties[name].(map[string]interface{})
+		details := []string{fmt.Sprintf("%v", property["type"])}
+		if containsString(tool.InputSchema.Required, name) {
+			details = append(details, "required")
+		}
+		if enum, ok := property["enum"].([]string); ok {
+			details = append(details, "one of "+strings.Join(enum, "|"))
+		}
+		if min, ok := property["minimum"].(float64); ok {
+			details = append(details, fmt.Sprintf(">= %v", min))
+		}
+		if max, ok := property["maximum"].(float64); ok {
+			details = append(details, fmt.Sprintf("<= %v", max))
+		}
+		if def, ok := property["default"]; ok {
+			details = append(details, fmt.Sprintf("default %v", def))
+		}
+		b.WriteString(fmt.Sprintf("  - %s (%s)\n", name, strings.Join(details, ", ")))
+	}
+	return b.String()
+}
+
+// jsonTypeName names the JSON type of a decoded argument value.
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// validationTestTool is a tool with one argument of each validated kind.
+func validationTestTool() mcp.Tool {
+	return mcp.NewTool("test_tool",
+		mcp.WithString("profile_uri", mcp.Required()),
+		mcp.WithString("profile_type", mcp.Enum("cpu", "heap")),
+		mcp.WithNumber("top_n", mcp.Min(1), mcp.Max(100)),
+		mcp.WithBoolean("json"),
+	)
+}
+
+func TestValidateToolArguments(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     map[string]interface{}
+		problems []string // Expected substrings of the error; none means the arguments are valid
+	}{
+		{
+			name: "Valid",
+			args: map[string]interface{}{"profile_uri": "file:///tmp/cpu.pb.gz", "profile_type": "cpu", "top_n": 5.0, "json": true},
+		},
+		{
+			name: "NilIsOmitted",
+			args: map[string]interface{}{"profile_uri": "cpu.pb.gz", "top_n": nil},
+		},
+		{
+			name:     "UnknownArgument",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz", "topn": 5.0},
+			problems: []string{"'topn': unknown argument", "Accepted arguments:", "  - top_n (number, >= 1, <= 100)"},
+		},
+		{
+			name:     "MissingRequired",
+			args:     map[string]interface{}{"profile_uri": ""},
+			problems: []string{"'profile_uri': required argument is missing"},
+		},
+		// Values are not coerced: a number sent as a string is reported, not parsed
+		{
+			name:     "NumberAsString",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz", "top_n": "5"},
+			problems: []string{"'top_n': expected a number, got a string"},
+		},
+		{
+			name:     "BooleanAsString",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz", "json": "true"},
+			problems: []string{"'json': expected a boolean, got a string"},
+		},
+		{
+			name:     "StringAsNumber",
+			args:     map[string]interface{}{"profile_uri": 1.0},
+			problems: []string{"'profile_uri': expected a string, got a number"},
+		},
+		{
+			name:     "NotFinite",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz", "top_n": math.NaN()},
+			problems: []string{"'top_n': expected a finite number"},
+		},
+		{
+			name:     "Range",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz", "top_n": 0.0},
+			problems: []string{"'top_n': 0 is less than the minimum 1"},
+		},
+		{
+			name:     "Enum",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz", "profile_type": "trace"},
+			problems: []string{"'profile_type': 'trace' is not one of cpu, heap"},
+		},
+		{
+			name:     "URIScheme",
+			args:     map[string]interface{}{"profile_uri": "ftp://host/cpu.pb.gz"},
+			problems: []string{"unsupported URI scheme 'ftp'"},
+		},
+		{
+			name: "CandidateList",
+			args: map[string]interface{}{"profile_uri": "cache/cpu.pb.gz | https://ci.example.com/cpu.pb.gz"},
+		},
+		{
+			name:     "CandidateScheme",
+			args:     map[string]interface{}{"profile_uri": "cpu.pb.gz|ftp://host/cpu.pb.gz"},
+			problems: []string{"candidate 2: unsupported URI scheme 'ftp'"},
+		},
+		{
+			name:     "AllProblemsReported",
+			args:     map[string]interface{}{"bogus": 1.0, "top_n": "5"},
+			problems: []string{"'profile_uri': required argument is missing", "'bogus': unknown argument", "'top_n': expected a number"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateToolArguments(validationTestTool(), tc.args)
+			if len(tc.problems) == 0 {
+				if err != nil {
+					t.Errorf("Expected valid arguments, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected an error for %v", tc.args)
+			}
+			for _, problem := range tc.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Expected %q in the error, got:\n%v", problem, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatedHandler(t *testing.T) {
+	var got map[string]interface{}
+	handler := validatedHandler(validationTestTool(), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		got = request.Params.Arguments
+		return mcp.NewToolResultText("ok"), nil
+	})
+	call := func(args map[string]interface{}) error {
+		got = nil
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "test_tool"
+		request.Params.Arguments = args
+		_, err := handler(context.Background(), request)
+		return err
+	}
+
+	// The only coercion is the resolution of profile type aliases, before the enum is checked
+	if err := call(map[string]interface{}{"profile_uri": "cpu.pb.gz", "profile_type": " Memory "}); err != nil {
+		t.Fatalf("Expected the 'memory' alias to be accepted, got: %v", err)
+	}
+	if got["profile_type"] != "heap" {
+		t.Errorf("Expected the handler to receive profile_type 'heap', got %v", got["profile_type"])
+	}
+
+	if err := call(map[string]interface{}{"profile_uri": "cpu.pb.gz", "unexpected": true}); err == nil || !strings.Contains(err.Error(), "'unexpected': unknown argument") {
+		t.Errorf("Expected the unknown argument to be rejected, got: %v", err)
+	}
+	if got != nil {
+		t.Error("Expected the handler not to run with invalid arguments")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ZephyrDeng/pprof-analyzer-mcp/analyzer"
+)
+
+// byteUnitsEnv selects how byte values are shown by every analyzer: "jedec" (default, 1024-based KB/MB),
+// "iec" (KiB/MiB) or "si" (1000-based kB/MB).
+const byteUnitsEnv = "PPROF_ANALYZER_BYTE_UNITS"
+
+// numberLocaleEnv selects the decimal and thousands separators: "c" (default, 1234.5), "en" (1,234.5),
+// "de" (1.234,5), "fr" (1 234,5) or "ch" (1'234.5).
+const numberLocaleEnv = "PPROF_ANALYZER_NUMBER_LOCALE"
+
+// countStyleEnv selects how counts (objects, goroutines, samples) are shown: "grouped" (default, 123,456,789
+// with the locale's thousands separator), "plain" (123456789) or "human" (123.5M).
+const countStyleEnv = "PPROF_ANALYZER_COUNTS"
+
+// alignValuesEnv right-aligns the value columns of text reports when true.
+const alignValuesEnv = "PPROF_ANALYZER_ALIGN_VALUES"
+
+// parseValueFormat builds the analyzers' value format from its settings.
+func parseValueFormat(units, locale, counts string, alignRight bool) (analyzer.ValueFormat, error) {
+	byteUnits, err := analyzer.ParseByteUnits(units)
+	if err != nil {
+		return analyzer.ValueFormat{}, err
+	}
+	numberLocale, err := analyzer.LookupNumberLocale(locale)
+	if err != nil {
+		return analyzer.ValueFormat{}, err
+	}
+	countStyle, err := analyzer.ParseCountStyle(counts)
+	if err != nil {
+		return analyzer.ValueFormat{}, err
+	}
+	return analyzer.ValueFormat{ByteUnits: byteUnits, Locale: numberLocale, Counts: countStyle, AlignRight: alignRight}, nil
+}
+
+// envAlignValues reports whether $PPROF_ANALYZER_ALIGN_VALUES is set to a true value.
+func envAlignValues() bool {
+	value := os.Getenv(alignValuesEnv)
+	if value == "" {
+		return false
+	}
+	align, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: ignoring invalid %s=%q", alignValuesEnv, value)
+	}
+	return align
+}
+
+// configureValueFormatFromEnv applies the value format settings from the environment; invalid settings are
+// logged and replaced by the defaults.
+func configureValueFormatFromEnv() {
+	format, err := parseValueFormat(os.Getenv(byteUnitsEnv), os.Getenv(numberLocaleEnv), os.Getenv(countStyleEnv), envAlignValues())
+	if err != nil {
+		log.Printf("Warning: %v; using the default value format", err)
+		format, _ = parseValueFormat("", "", "", envAlignValues())
+	}
+	analyzer.SetDefaultValueFormat(format)
+}
+
+// useColor resolves the CLI's -color mode: "always", "never", or "auto" (the default), which colors text reports
+// only when stdout is a terminal and neither $NO_COLOR is set nor $TERM is "dumb".
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "", "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("unsupported color mode '%s' (supported: auto, always, never)", mode)
+	}
+}